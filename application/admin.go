@@ -0,0 +1,60 @@
+// Implements the admin sockets through which an operator controls
+// a running CONIKS application, e.g., a registration bot or a key
+// server.
+
+package application
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// ServeAdmin listens for the commands of the application's operator at
+// the named Unix socket addr, and serves them in the background until
+// the returned listener is closed. Each connection carries one command,
+// i.e., one line, whose reply handle returns.
+func ServeAdmin(addr string, handle func(cmd string) string) (net.Listener, error) {
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleAdminCommand(conn, handle)
+		}
+	}()
+	return ln, nil
+}
+
+func handleAdminCommand(conn net.Conn, handle func(cmd string) string) {
+	defer conn.Close()
+	cmd, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && cmd == "" {
+		return
+	}
+	conn.Write([]byte(handle(strings.TrimSpace(cmd)) + "\n"))
+}
+
+// SendAdminCommand sends the command cmd to the application whose admin
+// socket is the named Unix socket addr, and returns the reply.
+func SendAdminCommand(addr, cmd string) (string, error) {
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
+		return "", err
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(reply)), nil
+}
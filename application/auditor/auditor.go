@@ -0,0 +1,532 @@
+package auditor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	protoauditor "github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// DefaultSyncInterval is the interval at which a started Auditor
+// syncs with the audited directories, unless specified otherwise in
+// its Options.
+const DefaultSyncInterval = time.Minute
+
+// These are the steps of a sync of an audited directory
+// (see SyncAll()).
+const (
+	OpSync    = "sync"
+	OpSample  = "sample"
+	OpWitness = "witness"
+	OpGossip  = "gossip"
+)
+
+// A SyncError reports that the step Op of a sync of the directory at
+// the address Directory, identified by DirInitHash, failed with Err.
+// Peer is the address of the peer auditor with which the auditor
+// gossiped, if Op is OpGossip.
+type SyncError struct {
+	Directory   string
+	DirInitHash [crypto.HashSizeByte]byte
+	Op          string
+	Err         error
+	Peer        string
+}
+
+// Error returns the message of the sync error e.
+func (e *SyncError) Error() string {
+	switch e.Op {
+	case OpSample:
+		return "Sampling failed: " + e.Err.Error()
+	case OpWitness:
+		return "Rekor witness failed: " + e.Err.Error()
+	case OpGossip:
+		if e.Peer == "" {
+			return "Gossip failed: " + e.Err.Error()
+		}
+		return "Gossip with " + e.Peer + " failed: " + e.Err.Error()
+	default:
+		return e.Err.Error()
+	}
+}
+
+// Options configures an Auditor. The zero Options is valid, and
+// creates an auditor which stores the verified STRs in a new audit log,
+// and reaches the directories over the network.
+type Options struct {
+	// Log is the audit log in which the auditor stores the STR
+	// histories it verifies, e.g., a log the embedding service also
+	// reads. A new audit log is created if it is nil.
+	Log auditlog.ConiksAuditLog
+	// DB is the database in which the auditor persists its audit log
+	// after each change (see auditlog.ConiksAuditLog.Flush()), so
+	// that the log can be restored when the auditor restarts (see
+	// auditlog.Load()). The audit log is kept in memory only if DB
+	// is nil.
+	DB kv.DB
+	// Send sends the message msg to the key server at the address
+	// addr, and returns the server's response. It defaults to sending
+	// msg over TCP or a Unix socket, according to the scheme of addr.
+	Send func(addr string, msg []byte) ([]byte, error)
+	// SyncInterval is the interval at which a started auditor syncs
+	// with the audited directories (see Start()), and defaults to
+	// DefaultSyncInterval.
+	SyncInterval time.Duration
+	// Clock is the clock of the started auditor's timer, and defaults
+	// to the real clock.
+	Clock utils.Clock
+	// OnError is called with each error of the syncs of a started
+	// auditor, if it is not nil.
+	OnError func(*SyncError)
+	// Peers are the addresses of the other auditors of the audited
+	// directories, with which the auditor gossips after each sync
+	// (see Gossip()).
+	Peers []string
+}
+
+// An Auditor audits a set of CONIKS directories: it fetches the STRs
+// the directories issue, verifies their signatures and hash chain, and
+// stores the verified STR histories in its audit log.
+//
+// An Auditor doesn't depend on any configuration file or listener, so
+// that it can be embedded into an existing Go service, e.g., a
+// messaging backend auditing its own CONIKS provider: the service adds
+// the directories to audit (see AddDirectory()), syncs with them
+// periodically (see Start()) or on demand (see Sync()), and may serve
+// the observed STRs to its clients (see HandleRequests()).
+// The methods of an Auditor are safe to call concurrently.
+type Auditor struct {
+	// lock guards the audit log and the audited directories
+	lock      sync.Mutex
+	log       auditlog.ConiksAuditLog
+	db        kv.DB
+	addrs     map[[crypto.HashSizeByte]byte]string
+	samplers  map[[crypto.HashSizeByte]byte]protoauditor.Sampler
+	witnesses map[[crypto.HashSizeByte]byte]*witness
+	peers     []string
+	send      func(addr string, msg []byte) ([]byte, error)
+
+	interval time.Duration
+	clock    utils.Clock
+	onError  func(*SyncError)
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// A witness is the Rekor log in which an audited directory publishes
+// its STRs signed with the directory's signing key pk.
+type witness struct {
+	log *application.RekorClient
+	pk  sign.PublicKey
+}
+
+// NewAuditor creates an Auditor of no directory, configured by opts.
+func NewAuditor(opts Options) *Auditor {
+	a := &Auditor{
+		log:       opts.Log,
+		db:        opts.DB,
+		addrs:     make(map[[crypto.HashSizeByte]byte]string),
+		samplers:  make(map[[crypto.HashSizeByte]byte]protoauditor.Sampler),
+		witnesses: make(map[[crypto.HashSizeByte]byte]*witness),
+		peers:     opts.Peers,
+		send:      opts.Send,
+		interval:  opts.SyncInterval,
+		clock:     opts.Clock,
+		onError:   opts.OnError,
+	}
+	if a.log == nil {
+		a.log = auditlog.New()
+	}
+	if a.send == nil {
+		a.send = sendToDirectory
+	}
+	if a.interval <= 0 {
+		a.interval = DefaultSyncInterval
+	}
+	if a.clock == nil {
+		a.clock = utils.RealClock
+	}
+	return a
+}
+
+// AddDirectory makes the auditor audit the directory dir, pinning its
+// signing key and initial STR, and returns the directory's identifier,
+// i.e. the hash of its initial STR. Only the parsed SigningPubKey and
+// InitSTR of dir are used, so that dir needn't be loaded from a file.
+// If the auditor's audit log already contains the directory's
+// history, e.g., restored from the auditor's database, the auditor
+// resumes auditing the directory from the latest STR of this history.
+// AddDirectory() returns an ErrAuditLog if the directory is already
+// audited, and the database's error if the new history can't be
+// persisted.
+func (a *Auditor) AddDirectory(dir *DirectoryConfig) ([crypto.HashSizeByte]byte, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	h := protoauditor.ComputeDirectoryIdentity(dir.InitSTR)
+	if _, ok := a.addrs[h]; ok {
+		return h, protocol.ErrAuditLog
+	}
+	if a.log.LatestObservedSTR(h) == nil {
+		if err := a.log.InitHistory(dir.Address, dir.SigningPubKey,
+			[]*protocol.DirSTR{dir.InitSTR}); err != nil {
+			return h, err
+		}
+		if err := a.flush(); err != nil {
+			return h, err
+		}
+	}
+	a.addrs[h] = dir.Address
+	if dir.SampleSize > 0 {
+		a.samplers[h] = protoauditor.RandomSampler(dir.SampleSize)
+	}
+	if dir.Rekor != nil {
+		a.witnesses[h] = &witness{
+			log: application.NewRekorClient(dir.Rekor),
+			pk:  dir.SigningPubKey,
+		}
+	}
+	return h, nil
+}
+
+// SetSampler makes the auditor sample the tree of the latest STR of the
+// directory identified by dirInitHash after each sync, at the lookup
+// indices chosen by s (see Sample()), replacing the RandomSampler
+// configured with the directory's SampleSize, if any. A nil s disables
+// the sampling.
+func (a *Auditor) SetSampler(dirInitHash [crypto.HashSizeByte]byte,
+	s protoauditor.Sampler) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if s == nil {
+		delete(a.samplers, dirInitHash)
+		return
+	}
+	a.samplers[dirInitHash] = s
+}
+
+// Directories returns the address of each audited directory,
+// indexed by the directory's identifier, i.e. the hash of its
+// initial STR.
+func (a *Auditor) Directories() map[[crypto.HashSizeByte]byte]string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	addrs := make(map[[crypto.HashSizeByte]byte]string, len(a.addrs))
+	for h, addr := range a.addrs {
+		addrs[h] = addr
+	}
+	return addrs
+}
+
+// Start syncs with the audited directories in a new goroutine, at the
+// auditor's sync interval until Stop() is called (see SyncAll()).
+// An Auditor can only be started once.
+func (a *Auditor) Start() {
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go a.run(a.clock.NewTimer(a.interval))
+}
+
+// Stop stops the auditor started by Start(), and waits for the
+// ongoing sync, if any.
+func (a *Auditor) Stop() {
+	close(a.stop)
+	<-a.done
+}
+
+func (a *Auditor) run(timer utils.Timer) {
+	defer close(a.done)
+	for {
+		select {
+		case <-a.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+			for _, err := range a.SyncAll() {
+				if a.onError != nil {
+					a.onError(err)
+				}
+			}
+			timer.Reset(a.interval)
+		}
+	}
+}
+
+// SyncAll syncs the histories of all audited directories (see Sync()),
+// samples the tree of their latest STRs (see Sample()), looks them up
+// in the directories' Rekor logs (see Witness()), gossips them with the
+// peer auditors (see Gossip()), and returns the errors. A directory
+// whose history fails to sync isn't sampled, witnessed nor gossiped.
+func (a *Auditor) SyncAll() []*SyncError {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var errs []*SyncError
+	for h, addr := range a.addrs {
+		if err := a.sync(h); err != nil {
+			errs = append(errs, &SyncError{addr, h, OpSync, err, ""})
+			continue
+		}
+		if err := a.sample(h); err != nil {
+			errs = append(errs, &SyncError{addr, h, OpSample, err, ""})
+		}
+		if err := a.witness(h); err != nil {
+			errs = append(errs, &SyncError{addr, h, OpWitness, err, ""})
+		}
+		errs = append(errs, a.gossip(h)...)
+	}
+	return errs
+}
+
+// Sync fetches all STRs the directory identified by dirInitHash has
+// issued since the latest STR the auditor has verified, and audits them.
+// It returns the consistency check error of the first STR which fails
+// to verify, if any, and a ReqUnknownDirectory if the directory
+// isn't audited.
+// The STRs verified before an error are persisted all the same.
+func (a *Auditor) Sync(dirInitHash [crypto.HashSizeByte]byte) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.sync(dirInitHash)
+}
+
+func (a *Auditor) sync(dirInitHash [crypto.HashSizeByte]byte) error {
+	err := a.fetch(dirInitHash)
+	if ferr := a.flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// fetch fetches and audits the new STRs of the directory identified
+// by dirInitHash (see Sync()).
+func (a *Auditor) fetch(dirInitHash [crypto.HashSizeByte]byte) error {
+	addr, ok := a.addrs[dirInitHash]
+	if !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	return clientapp.FetchSTRHistory(func() uint64 {
+		return a.log.LatestObservedSTR(dirInitHash).Epoch
+	}, func(msg []byte) ([]byte, error) {
+		return a.send(addr, msg)
+	}, func(res *protocol.Response) error {
+		return a.log.Update(dirInitHash, res)
+	})
+}
+
+// Sample requests the authentication paths of the tree of the latest
+// STR the auditor has verified for the directory identified by
+// dirInitHash, at the lookup indices chosen by the directory's sampler,
+// and verifies them against this STR (see protoauditor.VerifySample()).
+// A CheckBadAuthPath indicates that the directory's STR doesn't
+// commit to the tree it serves.
+// Sample() returns a ReqUnknownDirectory if the directory isn't
+// audited, and does nothing if the directory has no sampler.
+func (a *Auditor) Sample(dirInitHash [crypto.HashSizeByte]byte) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.sample(dirInitHash)
+}
+
+func (a *Auditor) sample(dirInitHash [crypto.HashSizeByte]byte) error {
+	addr, ok := a.addrs[dirInitHash]
+	if !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	s, ok := a.samplers[dirInitHash]
+	if !ok {
+		return nil
+	}
+	str := a.log.LatestObservedSTR(dirInitHash)
+	indices, err := s.Sample(str)
+	if err != nil {
+		return err
+	}
+	msg, err := clientapp.CreateSampleMsg(indices, str.Epoch)
+	if err != nil {
+		return err
+	}
+	res, err := a.send(addr, msg)
+	if err != nil {
+		return err
+	}
+	return protoauditor.VerifySample(str, indices,
+		application.UnmarshalResponse(protocol.SampleType, res))
+}
+
+// Witness looks up the latest STR the auditor has verified for the
+// directory identified by dirInitHash in the Rekor log in which the
+// directory publishes its STRs, and verifies the log's proof of its
+// inclusion (see application.RekorClient.Lookup()). Since the log is
+// append-only and public, the recorded STR is the one the directory
+// has committed to publicly, independently of the auditors; an
+// application.ErrNotRecorded thus indicates that the directory may
+// present different views of its history.
+// Witness() returns a ReqUnknownDirectory if the directory isn't
+// audited, and does nothing if the directory has no Rekor log.
+func (a *Auditor) Witness(dirInitHash [crypto.HashSizeByte]byte) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.witness(dirInitHash)
+}
+
+func (a *Auditor) witness(dirInitHash [crypto.HashSizeByte]byte) error {
+	if _, ok := a.addrs[dirInitHash]; !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	w, ok := a.witnesses[dirInitHash]
+	if !ok {
+		return nil
+	}
+	_, err := w.log.Lookup(a.log.LatestObservedSTR(dirInitHash), w.pk)
+	return err
+}
+
+// Gossip sends the latest STR the auditor has verified for the
+// directory identified by dirInitHash to each peer auditor, and checks
+// the STRs the peer has observed for the same epochs against the
+// auditor's history (see auditlog.ConiksAuditLog.VerifyGossip()).
+// A CheckBadSTR indicates that the directory presents different views
+// of its history to the auditor and the peer.
+// Gossip() returns the errors of the peers which failed, and ignores
+// the peers which don't audit the directory. It returns a
+// ReqUnknownDirectory if the directory isn't audited.
+func (a *Auditor) Gossip(dirInitHash [crypto.HashSizeByte]byte) []*SyncError {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.gossip(dirInitHash)
+}
+
+func (a *Auditor) gossip(dirInitHash [crypto.HashSizeByte]byte) []*SyncError {
+	addr, ok := a.addrs[dirInitHash]
+	if !ok {
+		return []*SyncError{{addr, dirInitHash, OpGossip,
+			protocol.ReqUnknownDirectory, ""}}
+	}
+	var errs []*SyncError
+	for _, peer := range a.peers {
+		if err := a.gossipWith(peer, dirInitHash); err != nil &&
+			err != protocol.ReqUnknownDirectory {
+			errs = append(errs, &SyncError{addr, dirInitHash, OpGossip, err, peer})
+		}
+	}
+	return errs
+}
+
+// gossipWith gossips the latest STR of the directory identified by
+// dirInitHash with the peer auditor at the address peer.
+func (a *Auditor) gossipWith(peer string, dirInitHash [crypto.HashSizeByte]byte) error {
+	msg, err := application.MarshalRequest(protocol.GossipType,
+		a.log.Gossip(dirInitHash))
+	if err != nil {
+		return err
+	}
+	res, err := a.send(peer, msg)
+	if err != nil {
+		return err
+	}
+	return a.log.VerifyGossip(dirInitHash,
+		application.UnmarshalResponse(protocol.GossipType, res))
+}
+
+// VerifySTR checks the STR str, obtained out-of-band by the user,
+// against the observed history of the directory identified by
+// dirInitHash. See auditlog.ConiksAuditLog.VerifySTR() for the
+// returned errors.
+func (a *Auditor) VerifySTR(dirInitHash [crypto.HashSizeByte]byte,
+	str *protocol.DirSTR) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.log.VerifySTR(dirInitHash, str)
+}
+
+// LatestSTR returns the latest STR the auditor has verified for the
+// directory identified by dirInitHash, or nil if the directory
+// isn't audited.
+func (a *Auditor) LatestSTR(dirInitHash [crypto.HashSizeByte]byte) *protocol.DirSTR {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.addrs[dirInitHash]; !ok {
+		return nil
+	}
+	return a.log.LatestObservedSTR(dirInitHash)
+}
+
+// DetectPartitions returns the evidence of the epochs in which the
+// observation reports of the clients of the directory identified by
+// dirInitHash disagree, among each other or with the auditor (see
+// auditlog.ConiksAuditLog.DetectPartitions()).
+func (a *Auditor) DetectPartitions(dirInitHash [crypto.HashSizeByte]byte) []*auditlog.PartitionEvidence {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.log.DetectPartitions(dirInitHash)
+}
+
+// HandleRequests passes the request req to the audit log's handler
+// according to the request type, i.e., the auditing requests and
+// observation reports of the clients, the STRs pushed by the
+// audited directories (see auditlog.ConiksAuditLog.ReceiveSTRs()),
+// and the gossip of the peer auditors (see
+// auditlog.ConiksAuditLog.ReceiveGossip()).
+// A service embedding the auditor may pass it the requests it
+// receives on its own connections, after checking their permissions
+// (see application.AuditingRequests, application.PushRequests and
+// application.GossipRequests). Since HandleRequests() doesn't know
+// the peers sending the requests, it counts all observation reports
+// as sent by the same peer; HandleRequestsFrom() should be used to
+// accept the reports of many clients.
+func (a *Auditor) HandleRequests(req *protocol.Request) *protocol.Response {
+	return a.HandleRequestsFrom("", req)
+}
+
+// HandleRequestsFrom is HandleRequests() for the request req received
+// from peer, e.g., the client's IP address, which limits the
+// observation reports of each peer (see
+// auditlog.ConiksAuditLog.ReportObservation()).
+func (a *Auditor) HandleRequestsFrom(peer string, req *protocol.Request) *protocol.Response {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch req.Type {
+	case protocol.AuditType:
+		if msg, ok := req.Request.(*protocol.AuditingRequest); ok {
+			return a.log.GetObservedSTRs(msg)
+		}
+	case protocol.ObservationReportType:
+		if msg, ok := req.Request.(*protocol.ObservationReport); ok {
+			return a.log.ReportObservation(msg, peer)
+		}
+	case protocol.STRPushType:
+		if msg, ok := req.Request.(*protocol.STRPush); ok {
+			res := a.log.ReceiveSTRs(msg)
+			if err := a.flush(); err != nil {
+				return protocol.NewErrorResponse(protocol.ErrAuditLog)
+			}
+			return res
+		}
+	case protocol.GossipType:
+		if msg, ok := req.Request.(*protocol.Gossip); ok {
+			return a.log.ReceiveGossip(msg)
+		}
+	}
+	return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+}
+
+// flush persists the changes of the audit log to the auditor's
+// database, if any.
+func (a *Auditor) flush() error {
+	if a.db == nil {
+		return nil
+	}
+	return a.log.Flush(a.db)
+}
+
+// sendToDirectory sends msg to the key server at addr.
+func sendToDirectory(addr string, msg []byte) ([]byte, error) {
+	return application.SendRequest(msg, addr, nil, nil)
+}
@@ -0,0 +1,599 @@
+package auditor
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	protoauditor "github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// newTestAuditor creates an auditor of the directory d, which is
+// reached directly instead of over the network. The auditor's
+// connections are addrs.
+func newTestAuditor(t *testing.T, d *directory.ConiksDirectory, addrs ...*Address) (
+	*ConiksAuditor, [crypto.HashSizeByte]byte) {
+	conf, dirInitHash := newTestConfig(t, d, addrs...)
+	a, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.send = directorySender(t, d)
+	return a, dirInitHash
+}
+
+// newTestConfig returns the configuration of an auditor of the
+// directory d, with the connections addrs.
+func newTestConfig(t *testing.T, d *directory.ConiksDirectory, addrs ...*Address) (
+	*Config, [crypto.HashSizeByte]byte) {
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	initSTR := testutil.JSONSTR(t, d.LatestSTR())
+	return &Config{
+		CommonConfig: &application.CommonConfig{
+			Logger: &application.LoggerConfig{
+				Environment: "development",
+			},
+		},
+		Directories: []*DirectoryConfig{{
+			SigningPubKey: pk,
+			InitSTR:       initSTR,
+			Address:       "mem://directory",
+		}},
+		Addresses: addrs,
+	}, protoauditor.ComputeDirectoryIdentity(initSTR)
+}
+
+// directorySender returns a function which passes the messages sent to
+// a key server directly to the directory d.
+func directorySender(t *testing.T, d *directory.ConiksDirectory) func(
+	addr string, msg []byte) ([]byte, error) {
+	return func(addr string, msg []byte) ([]byte, error) {
+		req, err := application.UnmarshalRequest(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch req.Type {
+		case protocol.STRType:
+			return application.MarshalResponse(
+				d.GetSTRHistory(req.Request.(*protocol.STRHistoryRequest)))
+		case protocol.SampleType:
+			return application.MarshalResponse(
+				d.Sample(req.Request.(*protocol.SampleRequest)))
+		}
+		t.Fatal("Unexpected request type", req.Type)
+		return nil, nil
+	}
+}
+
+func TestAuditorSyncAndVerifySTR(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d)
+
+	// more STRs than a single fetch returns, all in the directory's
+	// history of 10 snapshots
+	N := clientapp.MaxSTRsPerFetch + 1
+	for i := 0; i < N; i++ {
+		d.Update()
+	}
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	if ep := a.log.LatestObservedSTR(dirInitHash).Epoch; ep != d.LatestSTR().Epoch {
+		t.Fatal("Expect epoch", d.LatestSTR().Epoch, "got", ep)
+	}
+	if err := a.VerifySTR(dirInitHash, testutil.JSONSTR(t, d.LatestSTR())); err != nil {
+		t.Fatal("Expect", nil, "got", err)
+	}
+
+	// a fork of d signed with the same key
+	fork := directory.NewTestDirectory(t)
+	fork.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key")})
+	fork.Update()
+	if err := a.VerifySTR(dirInitHash, testutil.JSONSTR(t, fork.LatestSTR())); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
+
+func TestAuditorRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := directory.NewTestDirectory(t)
+	conf, dirInitHash := newTestConfig(t, d)
+	conf.DatabasePath = path.Join(dir, "auditor.db")
+
+	a, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.send = directorySender(t, d)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	a.Shutdown()
+
+	// the restarted auditor resumes from the persisted history,
+	// without fetching it again
+	a, err = New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown()
+	if str := a.LatestSTR(dirInitHash); str == nil || str.Epoch != 3 {
+		t.Fatal("Expect the restored epoch", 3, "got", str)
+	}
+	if err := a.VerifySTR(dirInitHash, testutil.JSONSTR(t, d.LatestSTR())); err != nil {
+		t.Fatal("Expect", nil, "got", err)
+	}
+	var fetched []uint64
+	send := directorySender(t, d)
+	a.send = func(addr string, msg []byte) ([]byte, error) {
+		req, err := application.UnmarshalRequest(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched = append(fetched, req.Request.(*protocol.STRHistoryRequest).StartEpoch)
+		return send(addr, msg)
+	}
+	d.Update()
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != 1 || fetched[0] != 3 {
+		t.Fatal("Expect to fetch from epoch", 3, "got", fetched)
+	}
+	if str := a.LatestSTR(dirInitHash); str.Epoch != 4 {
+		t.Fatal("Expect epoch", 4, "got", str.Epoch)
+	}
+}
+
+func TestAuditorSample(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d)
+	// without a sampler, the tree isn't sampled
+	if err := a.Sample(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	a.SetSampler(dirInitHash, protoauditor.RandomSampler(8))
+	d.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key")})
+	d.Update()
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Sample(dirInitHash); err != nil {
+		t.Fatal("Expect", nil, "got", err)
+	}
+
+	// a directory serving a tree to which its latest STR doesn't commit
+	send := a.send
+	a.send = func(addr string, msg []byte) ([]byte, error) {
+		req, _ := application.UnmarshalRequest(msg)
+		if req.Type == protocol.SampleType {
+			d.Register(&protocol.RegistrationRequest{
+				Username: "bob",
+				Key:      []byte("key")})
+			d.Update()
+			res := d.Sample(&protocol.SampleRequest{
+				Indices: req.Request.(*protocol.SampleRequest).Indices,
+				Epoch:   d.LatestSTR().Epoch,
+			})
+			res.SampleProof().STR =
+				a.log.LatestObservedSTR(dirInitHash)
+			return application.MarshalResponse(res)
+		}
+		return send(addr, msg)
+	}
+	if err := a.Sample(dirInitHash); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+}
+
+func TestAuditorWitness(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d)
+	// without a Rekor log, the STRs aren't looked up
+	if err := a.Witness(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	url, keyPath, stop := testutil.NewRekorServer(t, rekor.NewTestLog(t), dir)
+	defer stop()
+	conf := &application.RekorConfig{URL: url, PublicKeyPath: keyPath}
+	if err := conf.Load(path.Join(dir, "config.toml")); err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	log := application.NewRekorClient(conf)
+	a.witnesses[dirInitHash] = &witness{log: log, pk: pk}
+
+	for i := 0; i < 3; i++ {
+		d.Update()
+		if _, err := log.Publish(d.LatestSTR(), pk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Witness(dirInitHash); err != nil {
+		t.Fatal("Expect", nil, "got", err)
+	}
+
+	// an STR the directory hasn't published
+	d.Update()
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Witness(dirInitHash); err != application.ErrNotRecorded {
+		t.Fatal("Expect", application.ErrNotRecorded, "got", err)
+	}
+}
+
+func TestAuditorUnknownDirectory(t *testing.T) {
+	a, _ := newTestAuditor(t, directory.NewTestDirectory(t))
+	var unknown [crypto.HashSizeByte]byte
+	if err := a.Sync(unknown); err != protocol.ReqUnknownDirectory {
+		t.Fatal("Expect", protocol.ReqUnknownDirectory, "got", err)
+	}
+}
+
+func TestAuditorGossip(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d)
+	conf, _ := newTestConfig(t, d)
+	dir := conf.Directories[0]
+	d.Update()
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+	peer := "mem://peer"
+	sendToPeer := func(addr string, msg []byte) ([]byte, error) {
+		req, err := application.UnmarshalRequest(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != peer {
+			return application.MarshalResponse(
+				protocol.NewErrorResponse(protocol.ReqUnknownDirectory))
+		}
+		return application.MarshalResponse(a.HandleRequests(req))
+	}
+
+	// an auditor which has observed the same history, and a peer
+	// which doesn't audit the directory
+	b := NewAuditor(Options{Send: sendToPeer,
+		Peers: []string{peer, "mem://other-peer"}})
+	if _, err := b.AddDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	if errs := b.Gossip(dirInitHash); len(errs) != 0 {
+		t.Fatal("Expect no error, got", errs[0])
+	}
+
+	// an auditor to which the directory has shown a fork of its history
+	fork := directory.NewTestDirectory(t)
+	fork.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	fork.Update()
+	log := auditlog.New()
+	if err := log.InitHistory(dir.Address, dir.SigningPubKey,
+		[]*protocol.DirSTR{dir.InitSTR, testutil.JSONSTR(t, fork.LatestSTR())}); err != nil {
+		t.Fatal(err)
+	}
+	c := NewAuditor(Options{Log: log, Send: sendToPeer, Peers: []string{peer}})
+	if _, err := c.AddDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	errs := c.Gossip(dirInitHash)
+	if len(errs) != 1 || errs[0].Err != protocol.CheckBadSTR || errs[0].Peer != peer {
+		t.Fatal("Expect", protocol.CheckBadSTR, "from", peer, "got", errs)
+	}
+}
+
+func TestAddressPermissions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		addr    *Address
+		reqType int
+		want    bool
+	}{
+		{"client auditing", &Address{}, protocol.AuditType, true},
+		{"observation report", &Address{}, protocol.ObservationReportType, true},
+		{"push", &Address{}, protocol.STRPushType, false},
+		{"accepted push", &Address{AcceptPushes: true}, protocol.STRPushType, true},
+		{"auditing with pushes", &Address{AcceptPushes: true}, protocol.AuditType, true},
+		{"directory STR history", &Address{AcceptPushes: true}, protocol.STRType, false},
+		{"lookup", &Address{}, protocol.KeyLookupType, false},
+		{"gossip", &Address{AcceptPushes: true}, protocol.GossipType, false},
+		{"accepted gossip", &Address{AcceptGossip: true}, protocol.GossipType, true},
+		{"auditing with gossip", &Address{AcceptGossip: true}, protocol.AuditType, true},
+	} {
+		if got := tc.addr.permissions()[tc.reqType]; got != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", got)
+		}
+	}
+}
+
+func TestAuditorRun(t *testing.T) {
+	addr := testutil.MemConnection
+	addrs := []*Address{{
+		ServerAddress: &application.ServerAddress{
+			Address: addr,
+		},
+	}}
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d, addrs...)
+	d.Update()
+	a.Run(addrs)
+	defer a.Shutdown()
+
+	auditMsg, err := clientapp.CreateAuditingMsg(dirInitHash, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strMsg, err := clientapp.CreateSTRHistoryMsg(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		reqType int
+		msg     []byte
+		want    protocol.ErrorCode
+	}{
+		{"client auditing", protocol.AuditType, auditMsg, protocol.ReqSuccess},
+		{"directory STR history", protocol.STRType, strMsg, protocol.ErrMalformedMessage},
+	} {
+		rev, err := testutil.NewMemClient(tc.msg, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := application.UnmarshalResponse(tc.reqType, rev)
+		if res.Error != tc.want {
+			t.Fatal(tc.name, "expect", tc.want, "got", res.Error)
+		}
+		if res.Error == protocol.ReqSuccess {
+			// the auditor has synced with the directory at startup
+			strs := res.STRHistoryRange().STR
+			if len(strs) != 2 || strs[1].Epoch != 1 {
+				t.Fatal("Expect", 2, "STRs, got", len(strs))
+			}
+		}
+	}
+	if stats := a.ListenerStats(); len(stats) != 1 || stats[0].Label != "public" {
+		t.Fatal("Expect a listener labeled public")
+	}
+}
+
+func TestEmbeddedAuditor(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	log := auditlog.New()
+	send := directorySender(t, d)
+	fetched := make(chan struct{}, 1)
+	a := NewAuditor(Options{
+		Log: log,
+		Send: func(addr string, msg []byte) ([]byte, error) {
+			select {
+			case fetched <- struct{}{}:
+			default:
+			}
+			return send(addr, msg)
+		},
+		SyncInterval: time.Minute,
+		Clock:        clock,
+		OnError: func(err *SyncError) {
+			t.Error("Unexpected sync error", err)
+		},
+	})
+	dir := &DirectoryConfig{
+		SigningPubKey: pk,
+		InitSTR:       testutil.JSONSTR(t, d.LatestSTR()),
+		Address:       "mem://directory",
+	}
+	dirInitHash, err := a.AddDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddDirectory(dir); err != protocol.ErrAuditLog {
+		t.Fatal("Expect", protocol.ErrAuditLog, "got", err)
+	}
+	a.Start()
+	defer a.Stop()
+
+	d.Update()
+	clock.Advance(time.Minute)
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("Expect the auditor to sync after its sync interval")
+	}
+	// waits for the sync to finish
+	if str := a.LatestSTR(dirInitHash); str == nil || str.Epoch != 1 {
+		t.Fatal("Expect the auditor to verify epoch", 1)
+	}
+	// the auditor stores the verified STRs in the embedding service's log
+	if ep := log.LatestObservedSTR(dirInitHash).Epoch; ep != 1 {
+		t.Fatal("Expect", 1, "got", ep)
+	}
+	msg, err := clientapp.CreateAuditingMsg(dirInitHash, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := application.UnmarshalRequest(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := a.HandleRequests(req); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+}
+
+func TestAuditorObservationReports(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d)
+	d.Update()
+	if err := a.Sync(dirInitHash); err != nil {
+		t.Fatal(err)
+	}
+
+	str := d.LatestSTR()
+	forked := *str.SignedTreeRoot
+	forked.Signature = append([]byte{}, str.Signature...)
+	forked.Signature[0]++
+	for i, tc := range []struct {
+		peer string
+		str  *protocol.DirSTR
+		want protocol.ErrorCode
+	}{
+		{"192.0.2.1", str, protocol.ReqSuccess},
+		{"192.0.2.2", &protocol.DirSTR{SignedTreeRoot: &forked, Policies: str.Policies},
+			protocol.ReqSuccess},
+	} {
+		report := &protocol.ObservationReport{
+			DirInitSTRHash: dirInitHash,
+			Epoch:          tc.str.Epoch,
+		}
+		copy(report.STRHash[:], crypto.Digest(tc.str.Signature))
+		report.ReporterTag[0] = byte(i)
+		req := &protocol.Request{Type: protocol.ObservationReportType, Request: report}
+		if res := a.HandleRequestsFrom(tc.peer, req); res.Error != tc.want {
+			t.Fatal("Expect", tc.want, "got", res.Error)
+		}
+	}
+	ev := a.DetectPartitions(dirInitHash)
+	if len(ev) != 1 || ev[0].Epoch != str.Epoch || len(ev[0].Counts) != 2 {
+		t.Fatal("Expect the evidence of the forked STR, got", ev)
+	}
+}
+
+func TestAuditorServesClientsOverTLS(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	// a port chosen by the system, apart from the other tests'
+	addrs := []*Address{{
+		ServerAddress: &application.ServerAddress{
+			Address:     "tcp://127.0.0.1:0",
+			TLSCertPath: path.Join(dir, "server.pem"),
+			TLSKeyPath:  path.Join(dir, "server.key"),
+		},
+	}}
+	d := directory.NewTestDirectory(t)
+	initSTR := d.LatestSTR()
+	a, dirInitHash := newTestAuditor(t, d, addrs...)
+	d.Update()
+	a.Run(addrs)
+	defer a.Shutdown()
+	addr := a.ListenerStats()[0].Address
+
+	msg, err := clientapp.CreateAuditingMsg(dirInitHash, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := testutil.NewTCPClient(msg, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := application.UnmarshalResponse(protocol.AuditType, rev)
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	// the client compares the auditor's STRs with the directory's
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := client.New(initSTR, true, pk)
+	lookup, err := application.MarshalResponse(
+		d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.HandleResponse(protocol.KeyLookupType,
+		application.UnmarshalResponse(protocol.KeyLookupType, lookup), "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.CheckEquivocation(res); err != nil {
+		t.Fatal("Expect", nil, "got", err)
+	}
+}
+
+func TestAuditorServesHTTP(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	addrs := []*Address{{
+		ServerAddress: &application.ServerAddress{
+			Address:     "tcp://127.0.0.1:0",
+			TLSCertPath: path.Join(dir, "server.pem"),
+			TLSKeyPath:  path.Join(dir, "server.key"),
+			HTTP:        true,
+		},
+	}}
+	d := directory.NewTestDirectory(t)
+	a, dirInitHash := newTestAuditor(t, d, addrs...)
+	d.Update()
+	a.Run(addrs)
+	defer a.Shutdown()
+	url := "https://" + strings.TrimPrefix(a.ListenerStats()[0].Address, "tcp://")
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	for _, tc := range []struct {
+		name   string
+		hash   []byte
+		status int
+	}{
+		{"audited directory", dirInitHash[:], http.StatusOK},
+		{"unknown directory", make([]byte, crypto.HashSizeByte), http.StatusNotFound},
+	} {
+		res, err := client.Get(url + application.HTTPAuditPath +
+			hex.EncodeToString(tc.hash) + "?start=0&end=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status {
+			t.Error(tc.name, "expect status", tc.status, "got", res.StatusCode)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		msg := application.UnmarshalResponse(protocol.AuditType, body)
+		if strs := msg.STRHistoryRange().STR; len(strs) != 2 {
+			t.Error(tc.name, "expect", 2, "STRs, got", len(strs))
+		}
+		if cache := res.Header.Get("Cache-Control"); cache != "public, max-age=31536000, immutable" {
+			t.Error(tc.name, "expect an immutable response, got", cache)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package auditor
+
+import (
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A DirectoryConfig contains the values the auditor needs to audit
+// a single CONIKS directory.
+type DirectoryConfig struct {
+	// SignPubkeyPath is the path to the directory's signing public key,
+	// and SigningPubKey is the parsed key.
+	SignPubkeyPath string `toml:"sign_pubkey_path"`
+	SigningPubKey  sign.PublicKey
+	// InitSTRPath is the path to the directory's initial STR,
+	// and InitSTR is the parsed STR.
+	InitSTRPath string `toml:"init_str_path"`
+	InitSTR     *protocol.DirSTR
+	// Address is the address of the directory's key server.
+	Address string `toml:"address"`
+	// SampleSize is the number of random authentication paths the
+	// auditor samples from the tree of the directory's latest STR
+	// after each sync, up to protocol.MaxSampleSize. The tree isn't
+	// sampled if it is 0.
+	SampleSize int `toml:"sample_size,omitempty"`
+	// Rekor optionally specifies the Rekor transparency log in which
+	// the directory publishes its STRs, and in which the auditor
+	// looks up the latest STR it has verified after each sync
+	// (see ConiksAuditor.Witness()).
+	Rekor *application.RekorConfig `toml:"rekor,omitempty"`
+}
+
+// A Config contains configuration values
+// which are read at initialization time from
+// a TOML format configuration file.
+type Config struct {
+	*application.CommonConfig
+	// Directories contains the configuration of
+	// each directory the auditor audits.
+	Directories []*DirectoryConfig `toml:"directories"`
+	// Addresses contains the auditor's connections configuration.
+	// An auditor without addresses doesn't serve any request.
+	Addresses []*Address `toml:"addresses,omitempty"`
+	// SyncInterval is the interval in seconds at which the running
+	// auditor fetches new STRs from the audited directories. If it is
+	// 0, the auditor only fetches them at startup, and then relies on
+	// the directories pushing their new STRs.
+	SyncInterval protocol.Timestamp `toml:"sync_interval,omitempty"`
+	// DatabasePath is the path to the database in which the auditor
+	// persists its audit log, so that it keeps the observed histories
+	// across restarts. The audit log is kept in memory only if no
+	// path is specified.
+	DatabasePath string `toml:"database_path,omitempty"`
+	// Peers contains the addresses of the other auditors of the same
+	// directories, with which the auditor exchanges the STRs it has
+	// observed after each sync to detect split views. The peers
+	// accept the gossip on these addresses (see Address).
+	Peers []string `toml:"peers,omitempty"`
+}
+
+var _ application.AppConfig = (*Config)(nil)
+
+// NewConfig initializes a new auditor configuration at the given
+// file path, with the given config encoding, the configuration of the
+// audited directories, the auditor's addresses, logger configuration
+// and sync interval.
+func NewConfig(file, encoding string, dirs []*DirectoryConfig,
+	addrs []*Address, logConfig *application.LoggerConfig,
+	syncInterval protocol.Timestamp) *Config {
+	var conf = Config{
+		CommonConfig: application.NewCommonConfig(file, encoding, logConfig),
+		Directories:  dirs,
+		Addresses:    addrs,
+		SyncInterval: syncInterval,
+	}
+
+	return &conf
+}
+
+// Load initializes an auditor configuration at the given file path
+// using the given encoding.
+// It reads the signing public key and the initial STR of each
+// audited directory, and updates the path of TLS certificate files
+// of each Address to absolute path.
+func (conf *Config) Load(file, encoding string) error {
+	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+
+	for _, dir := range conf.Directories {
+		// load signing key
+		signPubKey, err := application.LoadSigningPubKey(dir.SignPubkeyPath, file)
+		if err != nil {
+			return err
+		}
+		dir.SigningPubKey = signPubKey
+
+		// load initial STR
+		initSTR, err := application.LoadInitSTR(dir.InitSTRPath, file)
+		if err != nil {
+			return err
+		}
+		dir.InitSTR = initSTR
+
+		if dir.Rekor != nil {
+			if err := dir.Rekor.Load(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, hook := range conf.Webhooks {
+		if err := hook.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// also update path for TLS cert files
+	for _, addr := range conf.Addresses {
+		addr.TLSCertPath = utils.ResolvePath(addr.TLSCertPath, file)
+		addr.TLSKeyPath = utils.ResolvePath(addr.TLSKeyPath, file)
+	}
+	// logger config
+	conf.Logger.Path = utils.ResolvePath(conf.Logger.Path, file)
+	if conf.DatabasePath != "" {
+		conf.DatabasePath = utils.ResolvePath(conf.DatabasePath, file)
+	}
+
+	return nil
+}
+
+// Save writes an auditor's configuration.
+func (conf *Config) Save() error {
+	return conf.GetLoader().Encode(conf)
+}
+
+// GetPath returns the auditor's configuration file path.
+func (conf *Config) GetPath() string {
+	return conf.Path
+}
@@ -0,0 +1,28 @@
+/*
+Package auditor implements a CONIKS auditor which users can run
+to check manually whether a CONIKS directory equivocates.
+
+The auditor pins the signing key and the initial STR of each
+directory it is configured to audit, fetches the directory's STR
+history, and verifies the history's signatures and hash chain.
+An STR obtained out-of-band, e.g. copied from a directory's website,
+can then be checked against the STR the auditor has observed for
+the same epoch.
+
+The auditing itself is implemented by an Auditor, which doesn't read
+any configuration file nor open any listener, so that it can also be
+embedded as a library into an existing Go service:
+
+	a := auditor.NewAuditor(auditor.Options{
+		SyncInterval: time.Minute,
+		OnError:      func(err *auditor.SyncError) { log.Println(err) },
+	})
+	dirInitHash, err := a.AddDirectory(&auditor.DirectoryConfig{
+		Address:       "tcp://coniks.example.org:3000",
+		SigningPubKey: pk,
+		InitSTR:       initSTR,
+	})
+	a.Start()
+	defer a.Stop()
+*/
+package auditor
@@ -0,0 +1,141 @@
+// Implements the standalone CONIKS auditor service, which runs an
+// Auditor configured by its configuration file, and serves the
+// observed STRs on its connections.
+
+package auditor
+
+import (
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/storage/kv/leveldbkv"
+)
+
+// An Address describes a connection of the auditor.
+// The auditing requests of the clients (see
+// application.AuditingRequests) are allowed on every connection,
+// while accepting the STRs pushed by the audited directories (see
+// application.PushRequests) has to be specified explicitly.
+// Likewise, accepting the gossip of the peer auditors (see
+// application.GossipRequests) has to be specified explicitly.
+// The requests which auditors send to directories are never accepted.
+// Unless the address is labeled explicitly, its listeners are labeled
+// "push" if it accepts pushes, and "public" otherwise.
+type Address struct {
+	*application.ServerAddress
+	AcceptPushes bool `toml:"accept_pushes,omitempty"`
+	AcceptGossip bool `toml:"accept_gossip,omitempty"`
+}
+
+// permissions returns the request permissions of the address addr.
+func (addr *Address) permissions() map[int]bool {
+	classes := [][]int{application.AuditingRequests}
+	if addr.AcceptPushes {
+		classes = append(classes, application.PushRequests)
+	}
+	if addr.AcceptGossip {
+		classes = append(classes, application.GossipRequests)
+	}
+	return application.Permissions(classes...)
+}
+
+// A ConiksAuditor maintains the audit log of the directories specified
+// in its configuration, and keeps their histories up to date by
+// fetching new STRs from their key servers.
+// A running ConiksAuditor also serves the observed STRs to the clients.
+type ConiksAuditor struct {
+	*application.ServerBase
+	*Auditor
+	syncTimer *application.EpochTimer // nil if the auditor doesn't sync periodically
+	db        kv.DB                   // nil if the audit log isn't persisted
+}
+
+// New creates a new auditor of the directories specified in conf.
+// If conf specifies a database, the auditor restores the histories
+// persisted in the database, and resumes auditing the directories
+// from their latest observed STRs.
+// It returns an ErrAuditLog if conf specifies the same
+// directory twice, and the database's error if the persisted
+// audit log can't be read.
+func New(conf *Config) (*ConiksAuditor, error) {
+	perms := make(map[*application.ServerAddress]map[int]bool)
+	for _, addr := range conf.Addresses {
+		perms[addr.ServerAddress] = addr.permissions()
+	}
+	a := &ConiksAuditor{
+		ServerBase: application.NewServerBase(conf.CommonConfig,
+			"Auditing", perms),
+	}
+	opts := Options{Peers: conf.Peers}
+	if conf.DatabasePath != "" {
+		a.db = leveldbkv.OpenDB(conf.DatabasePath)
+		log, err := auditlog.Load(a.db, nil)
+		if err != nil {
+			a.db.Close()
+			return nil, err
+		}
+		opts.Log, opts.DB = log, a.db
+	}
+	a.Auditor = NewAuditor(opts)
+	if conf.SyncInterval > 0 {
+		a.syncTimer = application.NewEpochTimer(conf.SyncInterval)
+	}
+	for _, dir := range conf.Directories {
+		if _, err := a.AddDirectory(dir); err != nil {
+			a.Shutdown()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Shutdown stops the auditor's listeners and background syncs
+// (see application.ServerBase.Shutdown()), and closes its database,
+// if any.
+func (a *ConiksAuditor) Shutdown() error {
+	err := a.ServerBase.Shutdown()
+	if a.db != nil {
+		a.db.Close()
+	}
+	return err
+}
+
+// Run catches up with the STR histories of the audited directories,
+// and then listens for all declared connections while following the
+// directories in the background.
+func (a *ConiksAuditor) Run(addrs []*Address) {
+	a.syncAll()
+	if a.syncTimer != nil {
+		a.RunInBackground(func() {
+			a.EpochUpdate(a.syncTimer, func() error {
+				a.syncAll()
+				return nil
+			})
+		})
+	}
+	for _, addr := range addrs {
+		label := addr.Label
+		if label == "" {
+			label = "public"
+			if addr.AcceptPushes {
+				label = "push"
+			}
+		}
+		a.ListenAndHandleFrom(addr.ServerAddress, label, a.HandleRequestsFrom)
+	}
+}
+
+// syncAll syncs with all audited directories (see SyncAll()),
+// and logs the errors, and the evidence of partitions in the clients'
+// observation reports (see DetectPartitions()).
+func (a *ConiksAuditor) syncAll() {
+	for _, err := range a.SyncAll() {
+		a.Logger().Error(err.Error(), "directory", err.Directory)
+	}
+	for dirInitHash, addr := range a.Directories() {
+		for _, ev := range a.DetectPartitions(dirInitHash) {
+			a.Logger().Error("Clients observed diverging STRs",
+				"directory", addr, "epoch", ev.Epoch, "views", len(ev.Counts))
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Implements the admin socket through which an operator controls
+// a running registration bot, e.g., to rotate its credentials.
+
+package bots
+
+import (
+	"log"
+	"net"
+
+	"github.com/coniks-sys/coniks-go/application"
+)
+
+// RotateCommand is the admin command which makes the bot reload its
+// configuration (see Rotator).
+const RotateCommand = "rotate"
+
+// A Rotator is a Bot whose credentials and identity with the
+// identity provider can be rotated while it runs. Rotate() reloads
+// the bot's configuration without dropping the registrations in
+// flight, or returns an error and keeps the current configuration.
+type Rotator interface {
+	Bot
+	Rotate() error
+}
+
+// ServeAdmin listens for the commands of the bot's operator at the
+// named Unix socket addr, and serves them in the background until the
+// returned listener is closed (see application.ServeAdmin()). Each
+// connection carries one command, e.g., RotateCommand, and gets the
+// reply "OK", or the error which occurred while running the command.
+func ServeAdmin(addr string, bot Rotator) (net.Listener, error) {
+	return application.ServeAdmin(addr, func(cmd string) string {
+		switch cmd {
+		case RotateCommand:
+			if err := bot.Rotate(); err != nil {
+				log.Printf("[registration bot] Rotation failed: %v", err)
+				return err.Error()
+			}
+			return "OK"
+		default:
+			return "Unknown command " + cmd
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package bots
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+)
+
+type fakeRotator struct {
+	Bot
+	err     error
+	rotated int
+}
+
+func (r *fakeRotator) Rotate() error {
+	r.rotated++
+	return r.err
+}
+
+func TestAdminRotate(t *testing.T) {
+	addr := "/tmp/coniksbot-admin-test.sock"
+	bot := new(fakeRotator)
+	ln, err := ServeAdmin(addr, bot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for _, tc := range []struct {
+		cmd  string
+		err  error
+		want string
+	}{
+		{RotateCommand, nil, "OK"},
+		{RotateCommand, fmt.Errorf("Could not authenticate you"), "Could not authenticate you"},
+		{"restart", nil, "Unknown command restart"},
+	} {
+		bot.err = tc.err
+		reply, err := application.SendAdminCommand(addr, tc.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != tc.want {
+			t.Error("Expect", tc.want, "got", reply)
+		}
+	}
+	if bot.rotated != 2 {
+		t.Fatal("Expect", 2, "got", bot.rotated)
+	}
+}
@@ -1,14 +1,20 @@
 // A CONIKS registration proxy interface that can be used to implement
 // an account verification bot for any first-party identity provider.
-// Currently, this interface is used to implement a Twitter account
-// verification bot.
+// Currently, this interface is used to implement the Twitter, XMPP,
+// email and webhook account verification bots.
 
 package bots
 
 import (
 	"bytes"
 	"io"
+	"log"
 	"net"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
 )
 
 const (
@@ -52,3 +58,75 @@ func SendRequestToCONIKS(addr string, msg []byte) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// forwardRegistration validates the registration request msg which
+// a CONIKS client sent on behalf of the owner of an account with the
+// bot's identity provider, and forwards it to the CONIKS server
+// listening at coniksAddress (see SendRequestToCONIKS()) if the
+// account owns the requested username, i.e., if owns(request.Username)
+// returns true. It returns the server's response as a string.
+// See https://godoc.org/github.com/coniks-sys/coniks-go/protocol/#ConiksDirectory.Register
+// for details on the possible server responses.
+func forwardRegistration(coniksAddress string, msg []byte, owns func(string) bool) string {
+	// validate request message
+	invalid := false
+	req, err := application.UnmarshalRequest(msg)
+	if err != nil {
+		invalid = true
+	} else {
+		request, ok := req.Request.(*protocol.RegistrationRequest)
+		if req.Type != protocol.RegistrationType || !ok ||
+			!owns(request.Username) {
+			invalid = true
+		}
+	}
+	if invalid {
+		log.Println("[registration bot] Malformed client request")
+		return errorResponse(protocol.ErrMalformedMessage)
+	}
+
+	// send request to coniks server
+	res, err := SendRequestToCONIKS(coniksAddress, msg)
+	if err != nil {
+		log.Println("[registration bot] " + err.Error())
+		return errorResponse(protocol.ErrDirectory)
+	}
+	return string(res)
+}
+
+// attest validates the attestation request msg which a CONIKS client
+// sent on behalf of the owner of an account with the bot's identity
+// provider, and returns a protocol.RegistrationAttestation for
+// request.Username, valid until expiry and signed with signKey, if the
+// account owns the username, i.e., if owns(request.Username) returns
+// true. The client then includes the attestation in the registration
+// it sends directly to the CONIKS server, so the bot never handles the
+// client's key material.
+func attest(signKey sign.PrivateKey, expiry time.Time, msg []byte,
+	owns func(string) bool) string {
+	var request *protocol.AttestationRequest
+	req, err := application.UnmarshalRequest(msg)
+	if err == nil && req.Type == protocol.AttestationType {
+		request, _ = req.Request.(*protocol.AttestationRequest)
+	}
+	if request == nil || !owns(request.Username) {
+		log.Println("[registration bot] Malformed client request")
+		return errorResponse(protocol.ErrMalformedMessage)
+	}
+	res, err := application.MarshalResponse(protocol.NewAttestationResponse(
+		protocol.NewRegistrationAttestation(signKey, request.Username, expiry)))
+	if err != nil {
+		panic(err)
+	}
+	return string(res)
+}
+
+// errorResponse returns the encoding of a response with the error
+// code e.
+func errorResponse(e protocol.ErrorCode) string {
+	res, err := application.MarshalResponse(protocol.NewErrorResponse(e))
+	if err != nil {
+		panic(err)
+	}
+	return string(res)
+}
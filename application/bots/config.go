@@ -1,7 +1,13 @@
 package bots
 
 import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
 	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
 // A TwitterConfig contains the address of the named UNIX socket
@@ -9,13 +15,41 @@ import (
 // the OAuth information needed to authenticate the bot with Twitter,
 // and the bot's reserved Twitter handle. These values are specified
 // in a configuration file, which is read at initialization time.
+//
+// If Detached is set, the bot doesn't forward the registrations to
+// the CONIKS server, but returns attestations signed with the private
+// key at SignKeyPath, which are valid for AttestationLifetime seconds
+// (see protocol.RegistrationAttestation).
+//
+// Suffix is the suffix of the CONIKS usernames the bot verifies, which
+// the bot appends to the Twitter screennames, and defaults to
+// DefaultSuffix. It must match the suffix for which the CONIKS server
+// trusts the bot (see the server's name policies).
+//
+// If AdminAddress is set, the bot listens at this named UNIX socket
+// for the commands of its operator (see ServeAdmin()).
 type TwitterConfig struct {
 	*application.CommonConfig
-	CONIKSAddress string `toml:"coniks_address"`
-	TwitterOAuth  `toml:"twitter_oauth"`
-	Handle        string `toml:"twitter_bot_handle"`
+	CONIKSAddress       string `toml:"coniks_address"`
+	TwitterOAuth        `toml:"twitter_oauth"`
+	Handle              string `toml:"twitter_bot_handle"`
+	Suffix              string `toml:"suffix,omitempty"`
+	Detached            bool   `toml:"detached,omitempty"`
+	SignKeyPath         string `toml:"sign_key_path,omitempty"`
+	AttestationLifetime uint64 `toml:"attestation_lifetime,omitempty"`
+	AdminAddress        string `toml:"admin_address,omitempty"`
+	signKey             sign.PrivateKey
 }
 
+// DefaultAttestationLifetime is the number of seconds for which the
+// attestations of a detached bot are valid, unless specified
+// otherwise in the bot's configuration.
+const DefaultAttestationLifetime = 300
+
+// DefaultSuffix is the suffix of the CONIKS usernames a Twitter bot
+// verifies, unless specified otherwise in the bot's configuration.
+const DefaultSuffix = "@twitter"
+
 var _ application.AppConfig = (*TwitterConfig)(nil)
 
 // A TwitterOAuth contains the four secret values needed to authenticate
@@ -46,9 +80,41 @@ func NewTwitterConfig(file, encoding, addr, handle string,
 
 // Load initializes a Twitter registration proxy configuration
 // at the given file path using the given encoding.
+// It reads the bot's signing key if the bot runs in detached mode.
 func (conf *TwitterConfig) Load(file, encoding string) error {
 	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
-	return conf.GetLoader().Decode(conf)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+	if conf.Suffix == "" {
+		conf.Suffix = DefaultSuffix
+	}
+	if !conf.Detached {
+		return nil
+	}
+	signKey, err := loadSignKey(conf.SignKeyPath, file)
+	if err != nil {
+		return err
+	}
+	conf.signKey = signKey
+	if conf.AttestationLifetime == 0 {
+		conf.AttestationLifetime = DefaultAttestationLifetime
+	}
+	return nil
+}
+
+// loadSignKey reads the private key with which a detached bot signs
+// its attestations from the file at path, relative to the directory
+// of the configuration file file.
+func loadSignKey(path, file string) (sign.PrivateKey, error) {
+	signKey, err := ioutil.ReadFile(utils.ResolvePath(path, file))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read signing key: %v", err)
+	}
+	if len(signKey) != sign.PrivateKeySize {
+		return nil, fmt.Errorf("Signing key must be 64 bytes (got %d)", len(signKey))
+	}
+	return signKey, nil
 }
 
 // Save writes a Twitter registration proxy configuration
@@ -61,3 +127,321 @@ func (conf *TwitterConfig) Save() error {
 func (conf *TwitterConfig) GetPath() string {
 	return conf.Path
 }
+
+// An XMPPConfig contains the address of the named UNIX socket
+// through which the bot and the CONIKS server communicate, the address
+// of the XMPP server of the bot's account, and the bot's JID and
+// password, with which the bot authenticates to its XMPP server.
+// These values are specified in a configuration file, which is read at
+// initialization time.
+//
+// The bot connects to Server over TLS, either directly if DirectTLS is
+// set, or by upgrading the connection with STARTTLS, which the server
+// must then offer: the bot never sends its password in the clear.
+//
+// Domains are the XMPP domains whose accounts the bot verifies, and
+// default to the domain of the bot's JID. The CONIKS username of an
+// XMPP account is its bare JID, e.g., "alice@example.org", so the
+// CONIKS server should trust the bot for the suffix "@example.org" of
+// each of its domains (see the server's name policies).
+//
+// Detached, SignKeyPath and AttestationLifetime are the settings of
+// the detached mode of the bot (see TwitterConfig).
+type XMPPConfig struct {
+	*application.CommonConfig
+	CONIKSAddress       string   `toml:"coniks_address"`
+	Server              string   `toml:"xmpp_server"`
+	JID                 string   `toml:"xmpp_bot_jid"`
+	Password            string   `toml:"xmpp_password"`
+	DirectTLS           bool     `toml:"direct_tls,omitempty"`
+	Domains             []string `toml:"domains,omitempty"`
+	Detached            bool     `toml:"detached,omitempty"`
+	SignKeyPath         string   `toml:"sign_key_path,omitempty"`
+	AttestationLifetime uint64   `toml:"attestation_lifetime,omitempty"`
+	signKey             sign.PrivateKey
+}
+
+var _ application.AppConfig = (*XMPPConfig)(nil)
+
+// NewXMPPConfig initializes a new XMPP registration bot configuration
+// at the given file path, with the config encoding, server address,
+// XMPP server address, and the bot's JID and password.
+func NewXMPPConfig(file, encoding, addr, server, jid, password string) *XMPPConfig {
+	var conf = XMPPConfig{
+		CommonConfig:  application.NewCommonConfig(file, encoding, nil),
+		CONIKSAddress: addr,
+		Server:        server,
+		JID:           jid,
+		Password:      password,
+	}
+
+	return &conf
+}
+
+// Load initializes an XMPP registration proxy configuration
+// at the given file path using the given encoding.
+// It reads the bot's signing key if the bot runs in detached mode.
+func (conf *XMPPConfig) Load(file, encoding string) error {
+	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+	local, domain, ok := splitJID(conf.JID)
+	if !ok || local == "" {
+		return fmt.Errorf("Invalid bot JID %q", conf.JID)
+	}
+	if len(conf.Domains) == 0 {
+		conf.Domains = []string{domain}
+	}
+	if !conf.Detached {
+		return nil
+	}
+	signKey, err := loadSignKey(conf.SignKeyPath, file)
+	if err != nil {
+		return err
+	}
+	conf.signKey = signKey
+	if conf.AttestationLifetime == 0 {
+		conf.AttestationLifetime = DefaultAttestationLifetime
+	}
+	return nil
+}
+
+// Save writes an XMPP registration proxy configuration
+// using the given encoding.
+func (conf *XMPPConfig) Save() error {
+	return conf.GetLoader().Encode(conf)
+}
+
+// GetPath returns the XMPP configuration's file path.
+func (conf *XMPPConfig) GetPath() string {
+	return conf.Path
+}
+
+// An EmailConfig contains the address of the named UNIX socket
+// through which the bot and the CONIKS server communicate, the bot's
+// email Address, the addresses of the IMAP server of its mailbox and of
+// the SMTP server through which it sends its emails, and the Login and
+// Password with which the bot authenticates to both servers, which
+// default to its address and to no password, respectively.
+// These values are specified in a configuration file, which is read at
+// initialization time.
+//
+// The bot connects to IMAPServer over TLS, and to SMTPServer with
+// STARTTLS. It polls its mailbox every PollInterval seconds, and its
+// challenges are valid for ChallengeLifetime seconds (see EmailBot).
+//
+// Domains are the email domains whose addresses the bot verifies, and
+// default to the domain of the bot's address. The CONIKS username of
+// an email account is its address, e.g., "alice@example.org", so the
+// CONIKS server should trust the bot for the suffix "@example.org" of
+// each of its domains (see the server's name policies).
+//
+// Detached, SignKeyPath and AttestationLifetime are the settings of
+// the detached mode of the bot (see TwitterConfig).
+type EmailConfig struct {
+	*application.CommonConfig
+	CONIKSAddress       string   `toml:"coniks_address"`
+	IMAPServer          string   `toml:"imap_server"`
+	SMTPServer          string   `toml:"smtp_server"`
+	Address             string   `toml:"email_address"`
+	Login               string   `toml:"email_login,omitempty"`
+	Password            string   `toml:"email_password"`
+	Domains             []string `toml:"domains,omitempty"`
+	PollInterval        uint64   `toml:"poll_interval,omitempty"`
+	ChallengeLifetime   uint64   `toml:"challenge_lifetime,omitempty"`
+	Detached            bool     `toml:"detached,omitempty"`
+	SignKeyPath         string   `toml:"sign_key_path,omitempty"`
+	AttestationLifetime uint64   `toml:"attestation_lifetime,omitempty"`
+	signKey             sign.PrivateKey
+}
+
+// DefaultPollInterval is the number of seconds between the polls of
+// an email bot's mailbox, unless specified otherwise in the bot's
+// configuration.
+const DefaultPollInterval = 30
+
+// DefaultChallengeLifetime is the number of seconds for which the
+// challenges of an email bot are valid, unless specified otherwise in
+// the bot's configuration.
+const DefaultChallengeLifetime = 900
+
+var _ application.AppConfig = (*EmailConfig)(nil)
+
+// NewEmailConfig initializes a new email registration bot configuration
+// at the given file path, with the config encoding, server address,
+// IMAP and SMTP server addresses, and the bot's email address and
+// password.
+func NewEmailConfig(file, encoding, addr, imapServer, smtpServer,
+	address, password string) *EmailConfig {
+	var conf = EmailConfig{
+		CommonConfig:  application.NewCommonConfig(file, encoding, nil),
+		CONIKSAddress: addr,
+		IMAPServer:    imapServer,
+		SMTPServer:    smtpServer,
+		Address:       address,
+		Password:      password,
+	}
+
+	return &conf
+}
+
+// Load initializes an email registration proxy configuration
+// at the given file path using the given encoding.
+// It reads the bot's signing key if the bot runs in detached mode.
+func (conf *EmailConfig) Load(file, encoding string) error {
+	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+	i := strings.LastIndex(conf.Address, "@")
+	if i <= 0 || i == len(conf.Address)-1 {
+		return fmt.Errorf("Invalid bot address %q", conf.Address)
+	}
+	if conf.Login == "" {
+		conf.Login = conf.Address
+	}
+	if len(conf.Domains) == 0 {
+		conf.Domains = []string{conf.Address[i+1:]}
+	}
+	if conf.PollInterval == 0 {
+		conf.PollInterval = DefaultPollInterval
+	}
+	if conf.ChallengeLifetime == 0 {
+		conf.ChallengeLifetime = DefaultChallengeLifetime
+	}
+	if !conf.Detached {
+		return nil
+	}
+	signKey, err := loadSignKey(conf.SignKeyPath, file)
+	if err != nil {
+		return err
+	}
+	conf.signKey = signKey
+	if conf.AttestationLifetime == 0 {
+		conf.AttestationLifetime = DefaultAttestationLifetime
+	}
+	return nil
+}
+
+// Save writes an email registration proxy configuration
+// using the given encoding.
+func (conf *EmailConfig) Save() error {
+	return conf.GetLoader().Encode(conf)
+}
+
+// GetPath returns the email configuration's file path.
+func (conf *EmailConfig) GetPath() string {
+	return conf.Path
+}
+
+// A WebhookConfig contains the address of the named UNIX socket
+// through which the bot and the CONIKS server communicate, the TCP
+// Address at which the bot serves its HTTPS endpoint with the TLS
+// certificate at TLSCertPath and its private key at TLSKeyPath, and
+// the path ProviderKeyPath to the public key of the identity provider
+// which posts the registrations. These values are specified in a
+// configuration file, which is read at initialization time.
+//
+// Suffix is the suffix of the CONIKS usernames for which the bot
+// accepts the provider's registrations, e.g., "@example.org". It must
+// match the suffix for which the CONIKS server trusts the bot (see the
+// server's name policies). The bot accepts the signatures of the
+// provider made at most MaxClockSkew seconds away from its current
+// time, DefaultMaxClockSkew by default (see WebhookBot).
+//
+// Detached, SignKeyPath and AttestationLifetime are the settings of
+// the detached mode of the bot (see TwitterConfig).
+type WebhookConfig struct {
+	*application.CommonConfig
+	CONIKSAddress       string `toml:"coniks_address"`
+	Address             string `toml:"webhook_address"`
+	TLSCertPath         string `toml:"cert"`
+	TLSKeyPath          string `toml:"key"`
+	ProviderKeyPath     string `toml:"provider_key_path"`
+	Suffix              string `toml:"suffix"`
+	MaxClockSkew        uint64 `toml:"max_clock_skew,omitempty"`
+	Detached            bool   `toml:"detached,omitempty"`
+	SignKeyPath         string `toml:"sign_key_path,omitempty"`
+	AttestationLifetime uint64 `toml:"attestation_lifetime,omitempty"`
+	providerKey         sign.PublicKey
+	signKey             sign.PrivateKey
+}
+
+// DefaultMaxClockSkew is the number of seconds by which the time of
+// the provider's signature of a registration posted to a webhook bot
+// may differ from the bot's current time, unless specified otherwise
+// in the bot's configuration.
+const DefaultMaxClockSkew = 300
+
+var _ application.AppConfig = (*WebhookConfig)(nil)
+
+// NewWebhookConfig initializes a new webhook registration bot
+// configuration at the given file path, with the config encoding,
+// server address, the bot's HTTPS address, TLS certificate and key
+// paths, the path to the provider's public key, and the suffix of the
+// usernames the bot accepts.
+func NewWebhookConfig(file, encoding, addr, address, certPath, keyPath,
+	providerKeyPath, suffix string) *WebhookConfig {
+	var conf = WebhookConfig{
+		CommonConfig:    application.NewCommonConfig(file, encoding, nil),
+		CONIKSAddress:   addr,
+		Address:         address,
+		TLSCertPath:     certPath,
+		TLSKeyPath:      keyPath,
+		ProviderKeyPath: providerKeyPath,
+		Suffix:          suffix,
+	}
+
+	return &conf
+}
+
+// Load initializes a webhook registration proxy configuration
+// at the given file path using the given encoding.
+// It reads the provider's public key, and the bot's signing key if the
+// bot runs in detached mode.
+func (conf *WebhookConfig) Load(file, encoding string) error {
+	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+	if conf.Suffix == "" {
+		return fmt.Errorf("A webhook bot must have a username suffix")
+	}
+	conf.TLSCertPath = utils.ResolvePath(conf.TLSCertPath, file)
+	conf.TLSKeyPath = utils.ResolvePath(conf.TLSKeyPath, file)
+	providerKey, err := ioutil.ReadFile(utils.ResolvePath(conf.ProviderKeyPath, file))
+	if err != nil {
+		return fmt.Errorf("Cannot read provider key: %v", err)
+	}
+	if len(providerKey) != sign.PublicKeySize {
+		return fmt.Errorf("Provider key must be 32 bytes (got %d)", len(providerKey))
+	}
+	conf.providerKey = providerKey
+	if conf.MaxClockSkew == 0 {
+		conf.MaxClockSkew = DefaultMaxClockSkew
+	}
+	if !conf.Detached {
+		return nil
+	}
+	signKey, err := loadSignKey(conf.SignKeyPath, file)
+	if err != nil {
+		return err
+	}
+	conf.signKey = signKey
+	if conf.AttestationLifetime == 0 {
+		conf.AttestationLifetime = DefaultAttestationLifetime
+	}
+	return nil
+}
+
+// Save writes a webhook registration proxy configuration
+// using the given encoding.
+func (conf *WebhookConfig) Save() error {
+	return conf.GetLoader().Encode(conf)
+}
+
+// GetPath returns the webhook configuration's file path.
+func (conf *WebhookConfig) GetPath() string {
+	return conf.Path
+}
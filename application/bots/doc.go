@@ -28,5 +28,29 @@ Twitter Bot
 
 This module provides a registration proxy for Twitter accounts
 that implements the CONIKS account verification Bot interface.
+
+XMPP Bot
+
+This module provides a registration proxy for XMPP accounts
+that implements the CONIKS account verification Bot interface.
+The bot verifies the accounts of its XMPP domains through the
+in-band messages their owners send it, whose senders the XMPP
+servers authenticate.
+
+Email Bot
+
+This module provides a registration proxy for email addresses
+that implements the CONIKS account verification Bot interface.
+Since the sender of an email isn't authenticated, the bot mails a
+challenge to each registered address, which the client returns
+to complete its registration.
+
+Webhook Bot
+
+This module provides a registration proxy for any identity
+provider which verifies its accounts itself, and posts the
+registrations of its users, signed with its key, to the bot's
+HTTPS endpoint. It lets a provider integrate with a CONIKS server
+without implementing a Bot.
 */
 package bots
@@ -0,0 +1,283 @@
+// A minimal email client, which implements just what an account
+// verification bot needs: fetching the messages of its inbox over
+// IMAP (RFC 3501), reading their text, and sending replies over SMTP.
+
+package bots
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// An imapConn is an IMAP client connection, on which the client sends
+// tagged commands and reads the server's responses.
+// The literals of the responses are collected in literals.
+type imapConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	tag      int
+	literals [][]byte
+}
+
+// fetchMail logs in to the IMAP server at the other end of conn as
+// user with password, and returns the raw messages of the INBOX which
+// haven't been seen yet, in the order of their UIDs, which it then
+// deletes from the INBOX. It logs out and closes conn.
+func fetchMail(conn net.Conn, user, password string) ([][]byte, error) {
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	defer conn.Close()
+	greeting, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return nil, fmt.Errorf("[registration bot] Unexpected IMAP greeting %q", greeting)
+	}
+	if _, err := c.command("LOGIN %s %s", imapQuote(user), imapQuote(password)); err != nil {
+		return nil, err
+	}
+	defer c.command("LOGOUT")
+	if _, err := c.command("SELECT INBOX"); err != nil {
+		return nil, err
+	}
+	untagged, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, line := range untagged {
+		if strings.HasPrefix(line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(line, "* SEARCH"))...)
+		}
+	}
+
+	var msgs [][]byte
+	for _, uid := range uids {
+		if _, err := strconv.ParseUint(uid, 10, 32); err != nil {
+			return nil, fmt.Errorf("[registration bot] Malformed IMAP UID %q", uid)
+		}
+		c.literals = nil
+		if _, err := c.command("UID FETCH %s BODY.PEEK[]", uid); err != nil {
+			return nil, err
+		}
+		if len(c.literals) != 1 {
+			return nil, fmt.Errorf("[registration bot] Malformed IMAP FETCH response")
+		}
+		msgs = append(msgs, c.literals[0])
+		if _, err := c.command(`UID STORE %s +FLAGS.SILENT (\Seen \Deleted)`, uid); err != nil {
+			return nil, err
+		}
+	}
+	if len(uids) > 0 {
+		if _, err := c.command("EXPUNGE"); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// maxLiteralSize is the maximum size of a literal the client reads,
+// i.e., of a message of the bot's inbox.
+const maxLiteralSize = 1 << 20
+
+// command sends the command format to the server with a new tag, and
+// returns the untagged responses which the server sent before its
+// tagged response, or an error unless the tagged response is OK.
+// The literals of the responses are appended to c.literals.
+func (c *imapConn) command(format string, args ...interface{}) ([]string, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s "+format+"\r\n", append([]interface{}{tag}, args...)...); err != nil {
+		return nil, err
+	}
+	var untagged []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, tag+" ") {
+			status := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("[registration bot] IMAP command failed: %s", status)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, line)
+	}
+}
+
+// readLine reads a response line, without its CRLF. If the line ends
+// with a literal, i.e., {n}, readLine() reads the literal into
+// c.literals, and the rest of the line following it.
+func (c *imapConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	for strings.HasSuffix(line, "}") {
+		i := strings.LastIndex(line, "{")
+		if i < 0 {
+			break
+		}
+		n, err := strconv.Atoi(line[i+1 : len(line)-1])
+		if err != nil || n < 0 || n > maxLiteralSize {
+			return "", fmt.Errorf("[registration bot] Malformed IMAP literal")
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return "", err
+		}
+		c.literals = append(c.literals, literal)
+		rest, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = line[:i] + strings.TrimRight(rest, "\r\n")
+	}
+	return line, nil
+}
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// dialIMAP opens a TLS connection to the IMAP server at addr.
+func dialIMAP(addr string, tlsConf *tls.Config) (net.Conn, error) {
+	if tlsConf == nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf = &tls.Config{ServerName: host}
+	}
+	return tls.Dial("tcp", addr, tlsConf)
+}
+
+// sendMail sends the text message body with the given subject from
+// the address from to the address to, through the SMTP server at addr,
+// authenticating as user with password. net/smtp upgrades the
+// connection with STARTTLS, and refuses to send the password over an
+// unencrypted connection to a remote server.
+func sendMail(addr, user, password, from, to, subject, body string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: base64\r\n\r\n")
+	w := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: &msg})
+	io.WriteString(w, body)
+	w.Close()
+	auth := smtp.PlainAuth("", user, password, host)
+	return smtp.SendMail(addr, auth, from, []string{to}, msg.Bytes())
+}
+
+// A lineWrapper wraps the lines of base64 text it writes at 76
+// characters, as required by RFC 2045.
+type lineWrapper struct {
+	w   io.Writer
+	col int
+}
+
+func (lw *lineWrapper) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := 76 - lw.col
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		if _, err := lw.w.Write(p[:chunk]); err != nil {
+			return n, err
+		}
+		n += chunk
+		lw.col += chunk
+		p = p[chunk:]
+		if lw.col == 76 {
+			if _, err := io.WriteString(lw.w, "\r\n"); err != nil {
+				return n, err
+			}
+			lw.col = 0
+		}
+	}
+	return n, nil
+}
+
+// readMail parses the raw message raw, and returns the address of its
+// sender and its text, i.e., its body if it is a text/plain message,
+// or its first text/plain part if it is a multipart message, decoded
+// according to its Content-Transfer-Encoding.
+func readMail(raw []byte) (from string, text string, err error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", "", err
+	}
+	addr, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return "", "", err
+	}
+	body, err := readText(msg.Header.Get("Content-Type"),
+		msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return addr.Address, body, nil
+}
+
+// readText returns the text of a body of the given content type and
+// transfer encoding (see readMail()).
+func readText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" {
+		mediaType, err = "text/plain", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextRawPart()
+			if err != nil {
+				return "", fmt.Errorf("[registration bot] No text in the message")
+			}
+			ct := part.Header.Get("Content-Type")
+			if ct == "" || strings.HasPrefix(ct, "text/plain") {
+				return readText(ct, part.Header.Get("Content-Transfer-Encoding"), part)
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("[registration bot] No text in the message")
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		// the decoder ignores the line breaks
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	text, err := ioutil.ReadAll(io.LimitReader(body, maxLiteralSize))
+	return string(text), err
+}
@@ -0,0 +1,309 @@
+// A registration proxy for email addresses that implements the
+// CONIKS account verification Bot interface, by challenging each
+// registered address.
+
+package bots
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+const (
+	// challengePrefix prefixes the line of the bot's challenge in
+	// its email, and of the challenge which the client returns.
+	challengePrefix = "?CONIKS-CHALLENGE?"
+
+	// challengeLabel separates the MACs of the challenges from any
+	// other MAC computed with the same key.
+	challengeLabel = "coniks-email-challenge"
+
+	// mailSubject is the subject of the bot's emails.
+	mailSubject = "CONIKS registration"
+)
+
+// An EmailBot is an account verification bot for CONIKS clients
+// registering email addresses with a CONIKS key server.
+//
+// Since the sender of an email can't be authenticated, an EmailBot
+// verifies that the client controls the mailbox of the registered
+// address with a challenge. The client first emails its request to
+// the bot's address. The bot then emails a challenge to the registered
+// address, which is bound to the request and expires after the
+// challenge lifetime. Once the client returns the challenge along with
+// the same request, from its mailbox, the bot forwards the
+// registration to the CONIKS server, or returns an attestation in
+// detached mode, in its reply.
+//
+// The challenges are authenticated with a MAC key the bot generates
+// when it starts, so the bot keeps no state about the requests in
+// flight, and the challenges it issued before a restart expire.
+type EmailBot struct {
+	coniksAddress string
+	address       string
+	domains       []string
+
+	detached            bool
+	signKey             sign.PrivateKey
+	attestationLifetime time.Duration
+	challengeLifetime   time.Duration
+	pollInterval        time.Duration
+	clock               utils.Clock
+
+	macKey []byte
+
+	// fetch returns the new messages of the bot's inbox, and send
+	// sends an email; both are replaced in tests
+	fetch func() ([][]byte, error)
+	send  func(to, subject, body string) error
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+var _ Bot = (*EmailBot)(nil)
+
+// NewEmailBot constructs a new account verification bot for email
+// addresses that implements the Bot interface.
+//
+// NewEmailBot checks that the CONIKS key server is live (unless the
+// bot runs in detached mode), and logs in to the bot's IMAP server, so
+// that a misconfigured bot fails at startup. If any of these steps
+// fail, NewEmailBot returns a (nil, error) tuple. Otherwise, it
+// returns an EmailBot struct with the appropriate values obtained
+// during the setup.
+func NewEmailBot(conf *EmailConfig) (*EmailBot, error) {
+	return newEmailBot(conf, nil)
+}
+
+// newEmailBot is NewEmailBot() with the TLS configuration tlsConf
+// of the IMAP connections, which defaults to verifying the
+// certificate of the IMAP server's host.
+func newEmailBot(conf *EmailConfig, tlsConf *tls.Config) (*EmailBot, error) {
+	// Notify if the CONIKS key server is down
+	if _, err := os.Stat(conf.CONIKSAddress); !conf.Detached && os.IsNotExist(err) {
+		return nil, fmt.Errorf("CONIKS Key Server is down")
+	}
+	macKey := make([]byte, sha256.Size)
+	if _, err := rand.Read(macKey); err != nil {
+		return nil, err
+	}
+
+	bot := new(EmailBot)
+	bot.coniksAddress = conf.CONIKSAddress
+	bot.address = conf.Address
+	bot.domains = conf.Domains
+	bot.detached = conf.Detached
+	bot.signKey = conf.signKey
+	bot.attestationLifetime = time.Duration(conf.AttestationLifetime) * time.Second
+	bot.challengeLifetime = time.Duration(conf.ChallengeLifetime) * time.Second
+	bot.pollInterval = time.Duration(conf.PollInterval) * time.Second
+	bot.clock = utils.RealClock
+	bot.macKey = macKey
+	bot.fetch = func() ([][]byte, error) {
+		conn, err := dialIMAP(conf.IMAPServer, tlsConf)
+		if err != nil {
+			return nil, err
+		}
+		return fetchMail(conn, conf.Login, conf.Password)
+	}
+	bot.send = func(to, subject, body string) error {
+		return sendMail(conf.SMTPServer, conf.Login, conf.Password,
+			conf.Address, to, subject, body)
+	}
+
+	if _, err := bot.fetch(); err != nil {
+		return nil, err
+	}
+	return bot, nil
+}
+
+// Run implements the main functionality of an email registration
+// proxy. It polls the bot's inbox in the background, and calls
+// HandleRegistration() upon receiving a valid email sent by a CONIKS
+// client. The result of HandleRegistration() is emailed back to the
+// CONIKS client.
+func (bot *EmailBot) Run() {
+	bot.stop = make(chan struct{})
+	bot.done.Add(1)
+	go func() {
+		defer bot.done.Done()
+		for {
+			bot.poll()
+			timer := bot.clock.NewTimer(bot.pollInterval)
+			select {
+			case <-bot.stop:
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+		}
+	}()
+}
+
+// poll fetches the new emails of the bot's inbox, and handles each of
+// them.
+func (bot *EmailBot) poll() {
+	msgs, err := bot.fetch()
+	if err != nil {
+		log.Printf("[registration bot] Cannot fetch the emails: %v", err)
+		return
+	}
+	for _, raw := range msgs {
+		from, text, err := readMail(raw)
+		if err != nil {
+			log.Printf("[registration bot] Malformed email: %v", err)
+			continue
+		}
+		if strings.EqualFold(from, bot.address) || !strings.Contains(text, messagePrefix) {
+			continue
+		}
+		reply := bot.HandleRegistration(from, []byte(text))
+		if err := bot.send(from, mailSubject, reply); err != nil {
+			log.Printf("[registration bot] Cannot send the email: %v", err)
+		}
+	}
+}
+
+// Stop stops polling the bot's inbox, once the emails it has fetched
+// are handled.
+func (bot *EmailBot) Stop() {
+	close(bot.stop)
+	bot.done.Wait()
+}
+
+// HandleRegistration verifies the authenticity of a CONIKS registration
+// request for an email address, in the email text msg sent from the
+// address from, and returns the text of the bot's reply, which is sent
+// to from. The request is the line of msg which starts with the
+// message prefix.
+//
+// The address from must be the requested username, in one of the
+// domains the bot verifies. If msg includes no challenge,
+// HandleRegistration() returns a new challenge for the request (see
+// EmailBot), which the bot sends to from. Otherwise, the line of msg
+// which starts with the challenge prefix must be a challenge for the
+// request which hasn't expired, in which case HandleRegistration()
+// forwards the request to the bot's corresponding CONIKS key server
+// (see forwardRegistration()), or returns an attestation if the bot
+// runs in detached mode (see attest()). Any other email gets
+// a response with an ErrMalformedMessage.
+func (bot *EmailBot) HandleRegistration(from string, msg []byte) string {
+	var challenge, request string
+	for _, line := range strings.Split(string(msg), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, challengePrefix):
+			challenge = strings.TrimPrefix(line, challengePrefix)
+		case strings.HasPrefix(line, messagePrefix):
+			request = strings.TrimPrefix(line, messagePrefix)
+		}
+	}
+	username := bot.requestedUsername([]byte(request))
+	if username == "" || !bot.isAccount(from, username) {
+		log.Println("[registration bot] Malformed client request")
+		return messagePrefix + errorResponse(protocol.ErrMalformedMessage)
+	}
+	if challenge == "" {
+		return fmt.Sprintf("Your CONIKS client is registering %s. If you didn't request "+
+			"this registration, ignore this email.\n\n%s%s\n",
+			username, challengePrefix, bot.newChallenge(username, []byte(request)))
+	}
+	if !bot.verifyChallenge(challenge, username, []byte(request)) {
+		log.Println("[registration bot] Invalid challenge")
+		return messagePrefix + errorResponse(protocol.ErrMalformedMessage)
+	}
+
+	owns := func(name string) bool { return name == username }
+	if bot.detached {
+		expiry := bot.clock.Now().Add(bot.attestationLifetime)
+		return messagePrefix + attest(bot.signKey, expiry, []byte(request), owns)
+	}
+	return messagePrefix + forwardRegistration(bot.coniksAddress, []byte(request), owns)
+}
+
+// requestedUsername returns the username of the request msg, i.e.,
+// of an attestation request if the bot runs in detached mode, and of
+// a registration request otherwise, or "" if msg isn't such a request.
+func (bot *EmailBot) requestedUsername(msg []byte) string {
+	req, err := application.UnmarshalRequest(msg)
+	if err != nil {
+		return ""
+	}
+	switch r := req.Request.(type) {
+	case *protocol.RegistrationRequest:
+		if !bot.detached && req.Type == protocol.RegistrationType {
+			return r.Username
+		}
+	case *protocol.AttestationRequest:
+		if bot.detached && req.Type == protocol.AttestationType {
+			return r.Username
+		}
+	}
+	return ""
+}
+
+// isAccount returns whether the CONIKS username is the email address
+// from, and whether the bot verifies its domain.
+func (bot *EmailBot) isAccount(from, username string) bool {
+	i := strings.LastIndex(from, "@")
+	if i <= 0 || !strings.EqualFold(from, username) {
+		return false
+	}
+	for _, d := range bot.domains {
+		if strings.EqualFold(d, from[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// newChallenge returns a challenge for the request msg for username,
+// which expires after the bot's challenge lifetime: the expiry time and
+// a MAC of the expiry time, username and msg, in base64.
+func (bot *EmailBot) newChallenge(username string, msg []byte) string {
+	expiry := uint64(bot.clock.Now().Add(bot.challengeLifetime).Unix())
+	challenge := append(utils.ULongToBytes(expiry), bot.challengeMAC(expiry, username, msg)...)
+	return base64.RawURLEncoding.EncodeToString(challenge)
+}
+
+// verifyChallenge returns whether challenge is a challenge the bot
+// issued for the request msg for username, which hasn't expired.
+func (bot *EmailBot) verifyChallenge(challenge, username string, msg []byte) bool {
+	buf, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(buf) != 8+sha256.Size {
+		return false
+	}
+	// utils.ULongToBytes() is little-endian
+	expiry := binary.LittleEndian.Uint64(buf[:8])
+	if uint64(bot.clock.Now().Unix()) > expiry {
+		return false
+	}
+	return hmac.Equal(buf[8:], bot.challengeMAC(expiry, username, msg))
+}
+
+// challengeMAC returns the MAC of a challenge.
+func (bot *EmailBot) challengeMAC(expiry uint64, username string, msg []byte) []byte {
+	mac := hmac.New(sha256.New, bot.macKey)
+	mac.Write([]byte(challengeLabel))
+	mac.Write(utils.ULongToBytes(expiry))
+	mac.Write(utils.ULongToBytes(uint64(len(username))))
+	mac.Write([]byte(username))
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
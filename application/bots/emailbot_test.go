@@ -0,0 +1,194 @@
+package bots
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func newTestEmailBot(t *testing.T) (*EmailBot, sign.PublicKey, *utils.FakeClock) {
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	return &EmailBot{
+		address:             "bot@example.org",
+		domains:             []string{"example.org"},
+		detached:            true,
+		signKey:             signKey,
+		attestationLifetime: time.Minute,
+		challengeLifetime:   15 * time.Minute,
+		clock:               clock,
+		macKey:              []byte("mac key"),
+	}, pk, clock
+}
+
+// challengeOf returns the challenge in the bot's reply.
+func challengeOf(t *testing.T, reply string) string {
+	for _, line := range strings.Split(reply, "\n") {
+		if strings.HasPrefix(line, challengePrefix) {
+			return line
+		}
+	}
+	t.Fatal("Expect a challenge, got", reply)
+	return ""
+}
+
+func TestEmailChallenge(t *testing.T) {
+	bot, pk, clock := newTestEmailBot(t)
+	request, _ := json.Marshal(&protocol.Request{
+		Type:    protocol.AttestationType,
+		Request: &protocol.AttestationRequest{Username: "alice@example.org"},
+	})
+	malformed := fmt.Sprintf(`%s{"Error":%d}`, messagePrefix, protocol.ErrMalformedMessage)
+
+	// the request is challenged
+	challenge := challengeOf(t, bot.HandleRegistration("alice@example.org",
+		[]byte(messagePrefix+string(request))))
+	msg := challenge + "\r\n" + messagePrefix + string(request) + "\r\n"
+	reply := bot.HandleRegistration("alice@example.org", []byte(msg))
+	res := application.UnmarshalResponse(protocol.AttestationType,
+		[]byte(strings.TrimPrefix(reply, messagePrefix)))
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.RegistrationAttestation().Verify(pk, "alice@example.org", time.Unix(60, 0)) {
+		t.Fatal("Expect a valid attestation")
+	}
+
+	// the challenge is bound to the request
+	other, _ := json.Marshal(&protocol.Request{
+		Type:    protocol.AttestationType,
+		Request: &protocol.AttestationRequest{Username: "bob@example.org"},
+	})
+	for _, tc := range []struct {
+		name string
+		from string
+		msg  string
+	}{
+		{"other sender", "bob@example.org", msg},
+		{"other request", "bob@example.org",
+			challenge + "\n" + messagePrefix + string(other)},
+		{"other domain", "alice@evil.example",
+			strings.Replace(msg, "example.org", "evil.example", -1)},
+		{"forged challenge", "alice@example.org",
+			challengePrefix + "AAAA\n" + messagePrefix + string(request)},
+		{"no request", "alice@example.org", challenge},
+	} {
+		if reply := bot.HandleRegistration(tc.from, []byte(tc.msg)); reply != malformed {
+			t.Error(tc.name, "expect", malformed, "got", reply)
+		}
+	}
+
+	// the challenge expires
+	clock.Advance(16 * time.Minute)
+	if reply := bot.HandleRegistration("alice@example.org", []byte(msg)); reply != malformed {
+		t.Error("Expect", malformed, "got", reply)
+	}
+}
+
+func TestEmailBotPoll(t *testing.T) {
+	bot, pk, _ := newTestEmailBot(t)
+	request, _ := json.Marshal(&protocol.Request{
+		Type:    protocol.AttestationType,
+		Request: &protocol.AttestationRequest{Username: "alice@example.org"},
+	})
+	var inbox [][]byte
+	type email struct{ to, body string }
+	var sent []email
+	bot.fetch = func() ([][]byte, error) {
+		msgs := inbox
+		inbox = nil
+		return msgs, nil
+	}
+	bot.send = func(to, subject, body string) error {
+		sent = append(sent, email{to, body})
+		return nil
+	}
+
+	inbox = append(inbox, []byte("From: Alice <alice@example.org>\r\n"+
+		"Subject: hi\r\n\r\nnot a request\r\n"),
+		[]byte("From: Alice <alice@example.org>\r\n"+
+			"Content-Type: text/plain\r\n"+
+			"Content-Transfer-Encoding: quoted-printable\r\n\r\n"+
+			messagePrefix+strings.Replace(string(request), "=", "=3D", -1)+"\r\n"))
+	bot.poll()
+	if len(sent) != 1 || sent[0].to != "alice@example.org" {
+		t.Fatal("Expect a challenge to alice, got", sent)
+	}
+
+	// the client replies from a multipart message
+	challenge := challengeOf(t, sent[0].body)
+	inbox = append(inbox, []byte("From: alice@example.org\r\n"+
+		"Content-Type: multipart/alternative; boundary=b\r\n\r\n"+
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n"+
+		"--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n"+
+		challenge+"\r\n"+messagePrefix+string(request)+"\r\n--b--\r\n"))
+	bot.poll()
+	if len(sent) != 2 {
+		t.Fatal("Expect a reply, got", sent)
+	}
+	res := application.UnmarshalResponse(protocol.AttestationType,
+		[]byte(strings.TrimPrefix(sent[1].body, messagePrefix)))
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.RegistrationAttestation().Verify(pk, "alice@example.org", time.Unix(60, 0)) {
+		t.Fatal("Expect a valid attestation")
+	}
+}
+
+func TestFetchMail(t *testing.T) {
+	client, server := net.Pipe()
+	msg := "From: alice@example.org\r\n\r\nhello\r\n"
+	errs := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		fmt.Fprintf(server, "* OK IMAP4rev1 ready\r\n")
+		for _, step := range []struct{ cmd, res string }{
+			{`a1 LOGIN "bot@example.org" "pass\"word"`, ""},
+			{"a2 SELECT INBOX", "* 1 EXISTS\r\n"},
+			{"a3 UID SEARCH UNSEEN", "* SEARCH 7\r\n"},
+			{"a4 UID FETCH 7 BODY.PEEK[]",
+				fmt.Sprintf("* 1 FETCH (UID 7 BODY[] {%d}\r\n%s)\r\n", len(msg), msg)},
+			{`a5 UID STORE 7 +FLAGS.SILENT (\Seen \Deleted)`, ""},
+			{"a6 EXPUNGE", "* 1 EXPUNGE\r\n"},
+			{"a7 LOGOUT", "* BYE\r\n"},
+		} {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				errs <- err
+				return
+			}
+			if line != step.cmd+"\r\n" {
+				errs <- fmt.Errorf("expect %q, got %q", step.cmd, line)
+				return
+			}
+			tag := strings.Fields(step.cmd)[0]
+			fmt.Fprintf(server, "%s%s OK done\r\n", step.res, tag)
+		}
+		errs <- nil
+	}()
+	msgs, err := fetchMail(client, "bot@example.org", `pass"word`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0]) != msg {
+		t.Fatalf("Expect %q, got %q", msg, msgs)
+	}
+}
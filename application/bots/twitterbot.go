@@ -8,10 +8,11 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/coniks-sys/coniks-go/application"
-	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
 	"github.com/dghubble/go-twitter/twitter"
 	"github.com/dghubble/oauth1"
 )
@@ -23,29 +24,103 @@ import (
 // A TwitterBot maintains information about a
 // twitter client and stream, the address of its
 // corresponding CONIKS server, and its reserved
-// Twitter handle.
+// Twitter handle. A TwitterBot running in detached mode
+// also maintains the key with which it signs its attestations.
+//
+// The OAuth credentials, the reserved handle and the signing key
+// can be rotated while the bot runs (see Rotate()).
 type TwitterBot struct {
-	client        *twitter.Client
-	stream        *twitter.Stream
+	// lock guards the session and the settings which Rotate() updates
+	lock          sync.Mutex
+	session       *twitterSession
 	coniksAddress string
-	handle        string
+	// suffix is the suffix of the usernames the bot verifies,
+	// DefaultSuffix if empty
+	suffix string
+
+	detached            bool
+	signKey             sign.PrivateKey
+	attestationLifetime time.Duration
+	clock               utils.Clock
+
+	// the configuration file which Rotate() reloads
+	confPath     string
+	confEncoding string
+	// rotating serializes the rotations
+	rotating sync.Mutex
+	// handled records when the recently handled DMs were received,
+	// so that a DM delivered to both streams during a rotation
+	// is only handled once
+	handledLock sync.Mutex
+	handled     map[int64]time.Time
+
+	// connect and listen are replaced in tests
+	connect func(*TwitterConfig) (*twitter.Client, error)
+	listen  func(*twitterSession, func(*twitter.DirectMessage)) (dmStream, error)
+}
+
+// A twitterSession is the connection of a TwitterBot to Twitter with
+// one set of OAuth credentials for the reserved handle.
+// pending counts the goroutines handling the DMs received from the
+// session's stream.
+type twitterSession struct {
+	client  *twitter.Client
+	stream  dmStream
+	handle  string
+	pending sync.WaitGroup
+}
+
+// A dmStream is a stream of Twitter messages, i.e. a *twitter.Stream.
+type dmStream interface {
+	Stop()
 }
 
-var _ Bot = (*TwitterBot)(nil)
+// handledDMsWindow is the duration for which a TwitterBot remembers
+// the DMs it has handled.
+const handledDMsWindow = 10 * time.Minute
+
+var _ Rotator = (*TwitterBot)(nil)
 
 // NewTwitterBot constructs a new account verification bot for Twitter
 // accounts that implements the Bot interface.
 //
 // NewTwitterBot checks that the CONIKS key server
-// is live, and authenticates the bot's Twitter client via OAuth.
+// is live (unless the bot runs in detached mode), and authenticates the bot's Twitter client via OAuth.
 // If any of these steps fail, NewTwitterBot returns a (nil, error)
 // tuple. Otherwise, it returns a TwitterBot struct
 // with the appropriate values obtained during the setup.
-func NewTwitterBot(conf *TwitterConfig) (Bot, error) {
+func NewTwitterBot(conf *TwitterConfig) (Rotator, error) {
 	// Notify if the CONIKS key server is down
-	if _, err := os.Stat(conf.CONIKSAddress); os.IsNotExist(err) {
+	if _, err := os.Stat(conf.CONIKSAddress); !conf.Detached && os.IsNotExist(err) {
 		return nil, fmt.Errorf("CONIKS Key Server is down")
 	}
+	client, err := connectTwitter(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	bot := new(TwitterBot)
+	bot.session = &twitterSession{client: client, handle: conf.Handle}
+	bot.coniksAddress = conf.CONIKSAddress
+	bot.suffix = conf.Suffix
+	bot.detached = conf.Detached
+	bot.signKey = conf.signKey
+	bot.attestationLifetime = time.Duration(conf.AttestationLifetime) * time.Second
+	bot.clock = utils.RealClock
+	bot.confPath = conf.Path
+	bot.confEncoding = conf.Encoding
+	bot.connect = connectTwitter
+	bot.listen = listenTwitter
+
+	bot.deleteOldDMs()
+
+	return bot, nil
+}
+
+// connectTwitter authenticates a Twitter client with the OAuth
+// credentials in conf, and checks that they belong to the reserved
+// handle in conf.
+func connectTwitter(conf *TwitterConfig) (*twitter.Client, error) {
 	auth := conf.TwitterOAuth
 	config := oauth1.NewConfig(auth.ConsumerKey, auth.ConsumerSecret)
 	token := oauth1.NewToken(auth.AccessToken, auth.AccessSecret)
@@ -64,15 +139,29 @@ func NewTwitterBot(conf *TwitterConfig) (Bot, error) {
 		handle.ScreenName != conf.Handle {
 		return nil, fmt.Errorf("Could not authenticate you")
 	}
+	return client, nil
+}
 
-	bot := new(TwitterBot)
-	bot.client = client
-	bot.coniksAddress = conf.CONIKSAddress
-	bot.handle = conf.Handle
-
-	bot.deleteOldDMs()
-
-	return bot, nil
+// listenTwitter opens the stream of the session s, and passes each DM
+// received from the stream to handle in a goroutine which s.pending
+// counts until the stream is stopped.
+func listenTwitter(s *twitterSession, handle func(*twitter.DirectMessage)) (dmStream, error) {
+	demux := twitter.NewSwitchDemux()
+	demux.DM = handle
+	userParams := &twitter.StreamUserParams{
+		StallWarnings: twitter.Bool(true),
+	}
+	stream, err := s.client.Streams.User(userParams)
+	if err != nil {
+		return nil, err
+	}
+	// Receive messages until stopped or stream quits
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		demux.HandleChan(stream.Messages)
+	}()
+	return stream, nil
 }
 
 // Run implements the main functionality of a Twitter registration proxy.
@@ -82,9 +171,22 @@ func NewTwitterBot(conf *TwitterConfig) (Bot, error) {
 // The result of HandleRegistration() is returned to the CONIKS client
 // via DM.
 func (bot *TwitterBot) Run() {
-	demux := twitter.NewSwitchDemux()
-	demux.DM = func(requestDM *twitter.DirectMessage) {
-		if strings.EqualFold(requestDM.SenderScreenName, bot.handle) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	s := bot.session
+	stream, err := bot.listen(s, bot.handleDM(s))
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.stream = stream
+}
+
+// handleDM returns the function which handles the DMs received by the
+// session s, and replies through s.
+func (bot *TwitterBot) handleDM(s *twitterSession) func(*twitter.DirectMessage) {
+	return func(requestDM *twitter.DirectMessage) {
+		if strings.EqualFold(requestDM.SenderScreenName, s.handle) ||
+			!bot.firstDelivery(requestDM) {
 			return
 		}
 		var responseDM *twitter.DirectMessage
@@ -96,30 +198,89 @@ func (bot *TwitterBot) Run() {
 			// Hackity, hack, hack!
 			// Twitter APIs probably don't want people call them so fast
 			time.Sleep(5 * time.Second)
-			responseDM, err = bot.sendDM(requestDM.SenderScreenName, messagePrefix+res)
+			responseDM, err = s.sendDM(requestDM.SenderScreenName, messagePrefix+res)
 			if err != nil {
 				log.Printf("[registration bot] " + err.Error())
 			}
 		}
-		bot.deleteRequestDMs(requestDM, responseDM)
+		s.deleteRequestDMs(requestDM, responseDM)
 	}
+}
 
-	userParams := &twitter.StreamUserParams{
-		StallWarnings: twitter.Bool(true),
+// firstDelivery returns whether the DM dm hasn't been handled yet,
+// and records it as handled.
+func (bot *TwitterBot) firstDelivery(dm *twitter.DirectMessage) bool {
+	bot.handledLock.Lock()
+	defer bot.handledLock.Unlock()
+	now := time.Now()
+	if bot.handled == nil {
+		bot.handled = make(map[int64]time.Time)
 	}
-	stream, err := bot.client.Streams.User(userParams)
-	if err != nil {
-		log.Fatal(err)
+	for id, t := range bot.handled {
+		if now.Sub(t) > handledDMsWindow {
+			delete(bot.handled, id)
+		}
 	}
-	bot.stream = stream
-
-	// Receive messages until stopped or stream quits
-	go demux.HandleChan(stream.Messages)
+	if _, ok := bot.handled[dm.ID]; ok {
+		return false
+	}
+	bot.handled[dm.ID] = now
+	return true
 }
 
 // Stop closes the bot's open stream through which it communicates with Twitter.
 func (bot *TwitterBot) Stop() {
-	bot.stream.Stop()
+	bot.lock.Lock()
+	s := bot.session
+	bot.lock.Unlock()
+	s.stream.Stop()
+}
+
+// Rotate reloads the bot's configuration file, and switches the running
+// bot to the OAuth credentials, the reserved handle and the signing key
+// it specifies, without dropping the registrations in flight:
+// the bot first authenticates with the new credentials and starts
+// listening on the new stream, and then drains the old stream, i.e.
+// stops it and waits until the DMs received from it are handled.
+// If the new configuration can't be loaded or its credentials are
+// rejected, the bot keeps running with its current configuration,
+// and Rotate() returns the error.
+// A bot can't be switched to or from the detached mode.
+func (bot *TwitterBot) Rotate() error {
+	bot.rotating.Lock()
+	defer bot.rotating.Unlock()
+
+	conf := &TwitterConfig{}
+	if err := conf.Load(bot.confPath, bot.confEncoding); err != nil {
+		return err
+	}
+	if conf.Detached != bot.detached {
+		return fmt.Errorf("Cannot switch the bot's detached mode")
+	}
+	client, err := bot.connect(conf)
+	if err != nil {
+		return err
+	}
+	s := &twitterSession{client: client, handle: conf.Handle}
+	stream, err := bot.listen(s, bot.handleDM(s))
+	if err != nil {
+		return err
+	}
+	s.stream = stream
+
+	bot.lock.Lock()
+	old := bot.session
+	bot.session = s
+	bot.coniksAddress = conf.CONIKSAddress
+	bot.signKey = conf.signKey
+	bot.attestationLifetime = time.Duration(conf.AttestationLifetime) * time.Second
+	bot.lock.Unlock()
+
+	old.stream.Stop()
+	old.pending.Wait()
+	log.Printf("[registration bot] Rotated the credentials of @%s to @%s",
+		old.handle, s.handle)
+	return nil
 }
 
 // HandleRegistration verifies the authenticity of a CONIKS registration
@@ -134,60 +295,64 @@ func (bot *TwitterBot) Stop() {
 // request.Username, and returns the server's response as a string.
 // See https://godoc.org/github.com/coniks-sys/coniks-go/protocol/#ConiksDirectory.Register
 // for details on the possible server responses.
+//
+// If the bot runs in detached mode, HandleRegistration() expects an
+// attestation request instead, and returns a signed attestation for
+// request.Username if username matches it (see handleAttestation()).
 func (bot *TwitterBot) HandleRegistration(username string, msg []byte) string {
-	// validate request message
-	invalid := false
-	req, err := application.UnmarshalRequest(msg)
-	if err != nil {
-		invalid = true
-	} else {
-		request, ok := req.Request.(*protocol.RegistrationRequest)
-		if req.Type != protocol.RegistrationType || !ok ||
-			// FIXME: Agree on a convention in issues #17 / #30
-			!strings.EqualFold(strings.ToLower(username)+"@twitter", request.Username) {
-			invalid = true
-		}
-	}
-	if invalid {
-		log.Println("[registration bot] Malformed client request")
-		res, err := application.MarshalResponse(
-			protocol.NewErrorResponse(protocol.ErrMalformedMessage))
-		if err != nil {
-			panic(err)
-		}
-		return string(res)
+	bot.lock.Lock()
+	detached, coniksAddress := bot.detached, bot.coniksAddress
+	bot.lock.Unlock()
+	if detached {
+		return bot.handleAttestation(username, msg)
 	}
+	owns := func(name string) bool { return bot.isAccount(username, name) }
+	return forwardRegistration(coniksAddress, msg, owns)
+}
 
-	// send request to coniks server
-	res, err := SendRequestToCONIKS(bot.coniksAddress, msg)
-	if err != nil {
-		log.Println("[registration bot] " + err.Error())
-		res, err := application.MarshalResponse(
-			protocol.NewErrorResponse(protocol.ErrDirectory))
-		if err != nil {
-			panic(err)
-		}
-		return string(res)
+// handleAttestation validates an attestation request msg sent by
+// a CONIKS client on behalf of the Twitter user username, and returns
+// a protocol.RegistrationAttestation for request.Username, signed with
+// the bot's key, if username matches request.Username.
+// The client then includes the attestation in the registration it
+// sends directly to the CONIKS server, so the bot never handles the
+// client's key material.
+func (bot *TwitterBot) handleAttestation(username string, msg []byte) string {
+	bot.lock.Lock()
+	signKey, lifetime := bot.signKey, bot.attestationLifetime
+	bot.lock.Unlock()
+	owns := func(name string) bool { return bot.isAccount(username, name) }
+	return attest(signKey, bot.clock.Now().Add(lifetime), msg, owns)
+}
+
+// isAccount returns whether the CONIKS username corresponds to the
+// Twitter screenname, i.e., is the screenname followed by the suffix
+// of the usernames the bot verifies.
+func (bot *TwitterBot) isAccount(screenname, username string) bool {
+	suffix := bot.suffix
+	if suffix == "" {
+		suffix = DefaultSuffix
 	}
-	return string(res)
+	return strings.EqualFold(screenname+suffix, username)
 }
 
 // sendDM sends a Twitter direct message msg to the given Twitter screenname.
-// The sender screenname should be set to the bot's reserved Twitter handle.
-func (bot *TwitterBot) sendDM(screenname, msg string) (*twitter.DirectMessage, error) {
+// The sender screenname is the session's reserved Twitter handle.
+func (s *twitterSession) sendDM(screenname, msg string) (*twitter.DirectMessage, error) {
 	params := &twitter.DirectMessageNewParams{ScreenName: screenname, Text: msg}
-	dm, _, err := bot.client.DirectMessages.New(params)
+	dm, _, err := s.client.DirectMessages.New(params)
 	return dm, err
 }
 
 // deleteOldDMs deletes all prior DMs before the bot runs.
 func (bot *TwitterBot) deleteOldDMs() {
+	client := bot.session.client
 	log.Println("[registration bot] Deleting old DMs ...")
 	// GET /direct_messages returns at most 200 recent DMs.
 	// See https://dev.twitter.com/rest/reference/get/direct_messages
 	params := &twitter.DirectMessageGetParams{Count: 200}
 	for {
-		dms, _, err := bot.client.DirectMessages.Get(params)
+		dms, _, err := client.DirectMessages.Get(params)
 		if err != nil {
 			log.Println("[registration bot] Cannot get Twitter bot's DMs. Error: " + err.Error())
 		}
@@ -196,7 +361,7 @@ func (bot *TwitterBot) deleteOldDMs() {
 			return
 		}
 		for i := 0; i < len(dms); i++ {
-			_, _, err = bot.client.DirectMessages.Destroy(dms[i].ID, nil)
+			_, _, err = client.DirectMessages.Destroy(dms[i].ID, nil)
 			if err != nil {
 				log.Println("[registration bot] Could not remove Twitter bot's DM. Error: " + err.Error())
 			}
@@ -207,18 +372,18 @@ func (bot *TwitterBot) deleteOldDMs() {
 // deleteRequestDMs waits for 5 mins and
 // then removes the request and response DMs.
 // This should be called each time the bot handles a registration request.
-func (bot *TwitterBot) deleteRequestDMs(requestDM, responseDM *twitter.DirectMessage) {
+func (s *twitterSession) deleteRequestDMs(requestDM, responseDM *twitter.DirectMessage) {
 	timer := time.NewTimer(time.Second * 300)
 
 	go func() {
 		defer timer.Stop()
 		<-timer.C
-		_, _, err := bot.client.DirectMessages.Destroy(requestDM.ID, nil)
+		_, _, err := s.client.DirectMessages.Destroy(requestDM.ID, nil)
 		if err != nil {
 			log.Println("[registration bot] Could not remove Twitter bot's DM. Error: " + err.Error())
 		}
 		if responseDM != nil {
-			_, _, err = bot.client.DirectMessages.Destroy(responseDM.ID, nil)
+			_, _, err = s.client.DirectMessages.Destroy(responseDM.ID, nil)
 			if err != nil {
 				log.Println("[registration bot] Could not remove Twitter bot's DM. Error: " + err.Error())
 			}
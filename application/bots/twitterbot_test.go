@@ -3,9 +3,17 @@ package bots
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+	"github.com/dghubble/go-twitter/twitter"
 )
 
 func TestCannotUnmarshallRequest(t *testing.T) {
@@ -52,3 +60,213 @@ func TestInvalidUsername(t *testing.T) {
 		t.Error("Unexpected response", "got", response)
 	}
 }
+
+func TestDetachedAttestation(t *testing.T) {
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+	bot := &TwitterBot{
+		detached:            true,
+		signKey:             signKey,
+		attestationLifetime: time.Minute,
+		clock:               utils.NewFakeClock(time.Unix(0, 0)),
+	}
+
+	request, _ := json.Marshal(&protocol.Request{
+		Type:    protocol.AttestationType,
+		Request: &protocol.AttestationRequest{Username: "alice@twitter"},
+	})
+	res := application.UnmarshalResponse(protocol.AttestationType,
+		[]byte(bot.HandleRegistration("alice", request)))
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a := res.RegistrationAttestation()
+	if !a.Verify(pk, "alice@twitter", time.Unix(60, 0)) {
+		t.Fatal("Expect a valid attestation")
+	}
+	if a.Verify(pk, "alice@twitter", time.Unix(61, 0)) {
+		t.Fatal("Expect the attestation to expire")
+	}
+
+	// a detached bot doesn't attest other accounts, nor forwards registrations
+	response := bot.HandleRegistration("bob", request)
+	if response != fmt.Sprintf(`{"Error":%d}`, protocol.ErrMalformedMessage) {
+		t.Error("Unexpected response", "got", response)
+	}
+	request, _ = json.Marshal(&protocol.Request{
+		Type: protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{
+			Username: "alice@twitter",
+			Key:      []byte{1, 2, 3},
+		},
+	})
+	response = bot.HandleRegistration("alice", request)
+	if response != fmt.Sprintf(`{"Error":%d}`, protocol.ErrMalformedMessage) {
+		t.Error("Unexpected response", "got", response)
+	}
+}
+
+func TestDetachedAttestationSuffix(t *testing.T) {
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := &TwitterBot{
+		detached:            true,
+		suffix:              "@x.example",
+		signKey:             signKey,
+		attestationLifetime: time.Minute,
+		clock:               utils.NewFakeClock(time.Unix(0, 0)),
+	}
+
+	for _, tc := range []struct {
+		username string
+		valid    bool
+	}{
+		{"alice@x.example", true},
+		{"Alice@X.example", true},
+		{"alice@twitter", false},
+	} {
+		request, _ := json.Marshal(&protocol.Request{
+			Type:    protocol.AttestationType,
+			Request: &protocol.AttestationRequest{Username: tc.username},
+		})
+		res := application.UnmarshalResponse(protocol.AttestationType,
+			[]byte(bot.HandleRegistration("alice", request)))
+		if got := res.Error == protocol.ReqSuccess; got != tc.valid {
+			t.Error(tc.username, "expect", tc.valid, "got", res.Error)
+		}
+	}
+}
+
+// A fakeStream records whether it has been stopped, and keeps a
+// "DM" in flight until then, and until the DM's handling is released.
+type fakeStream struct {
+	stopped chan struct{}
+	release chan struct{}
+	drained bool
+}
+
+func (s *fakeStream) Stop() {
+	close(s.stopped)
+}
+
+// newRotatingBot returns a bot whose configuration is written to a
+// temporary file, and whose Twitter sessions are faked:
+// the OAuth access token "revoked" is rejected.
+func newRotatingBot(t *testing.T) (*TwitterBot, *TwitterConfig, func()) {
+	dir, err := ioutil.TempDir("", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := NewTwitterConfig(filepath.Join(dir, "botconfig.toml"), "toml",
+		"/tmp/coniks.sock", "oldbot", TwitterOAuth{AccessToken: "old"})
+	if err := conf.Save(); err != nil {
+		t.Fatal(err)
+	}
+	bot := &TwitterBot{
+		session:      &twitterSession{handle: conf.Handle},
+		confPath:     conf.Path,
+		confEncoding: conf.Encoding,
+		connect: func(conf *TwitterConfig) (*twitter.Client, error) {
+			if conf.AccessToken == "revoked" {
+				return nil, fmt.Errorf("Could not authenticate you")
+			}
+			return new(twitter.Client), nil
+		},
+		listen: func(s *twitterSession, _ func(*twitter.DirectMessage)) (dmStream, error) {
+			stream := &fakeStream{
+				stopped: make(chan struct{}),
+				release: make(chan struct{}),
+			}
+			s.pending.Add(1)
+			go func() {
+				defer s.pending.Done()
+				<-stream.stopped
+				<-stream.release
+				stream.drained = true
+			}()
+			return stream, nil
+		},
+	}
+	bot.Run()
+	return bot, conf, func() { os.RemoveAll(dir) }
+}
+
+// saveConfig overwrites the bot's configuration file with conf.
+func saveConfig(t *testing.T, conf *TwitterConfig) {
+	os.Remove(conf.Path)
+	if err := conf.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	bot, conf, teardown := newRotatingBot(t)
+	defer teardown()
+	old := bot.session
+
+	// the new credentials are rejected
+	conf.Handle = "newbot"
+	conf.AccessToken = "revoked"
+	saveConfig(t, conf)
+	if err := bot.Rotate(); err == nil {
+		t.Fatal("Expect the rotation to fail")
+	}
+	if bot.session != old || old.stream.(*fakeStream).drained {
+		t.Fatal("Expect the bot to keep its session")
+	}
+
+	conf.AccessToken = "new"
+	saveConfig(t, conf)
+	rotated := make(chan error, 1)
+	go func() {
+		rotated <- bot.Rotate()
+	}()
+	// the rotation waits for the DM in flight on the old stream
+	<-old.stream.(*fakeStream).stopped
+	select {
+	case err := <-rotated:
+		t.Fatal("Expect the rotation to wait for the old stream, got", err)
+	default:
+	}
+	close(old.stream.(*fakeStream).release)
+	if err := <-rotated; err != nil {
+		t.Fatal(err)
+	}
+	if bot.session.handle != "newbot" {
+		t.Fatal("Expect", "newbot", "got", bot.session.handle)
+	}
+	// the DMs in flight on the old stream have been handled
+	if !old.stream.(*fakeStream).drained {
+		t.Fatal("Expect the old stream to be drained")
+	}
+	if bot.session.stream.(*fakeStream).drained {
+		t.Fatal("Expect the new stream to be running")
+	}
+
+	// the detached mode can't be switched
+	conf.Detached = true
+	saveConfig(t, conf)
+	if err := bot.Rotate(); err == nil {
+		t.Fatal("Expect the rotation to fail")
+	}
+}
+
+func TestHandleDMOnce(t *testing.T) {
+	bot := new(TwitterBot)
+	dm := &twitter.DirectMessage{ID: 42}
+	if !bot.firstDelivery(dm) {
+		t.Fatal("Expect the DM to be handled")
+	}
+	// the DM is delivered again by the other stream during a rotation
+	if bot.firstDelivery(dm) {
+		t.Fatal("Expect the DM to be handled only once")
+	}
+	if !bot.firstDelivery(&twitter.DirectMessage{ID: 43}) {
+		t.Fatal("Expect the DM to be handled")
+	}
+}
@@ -0,0 +1,331 @@
+// A registration proxy for any identity provider which verifies its
+// accounts itself, and posts the registrations of its users to an
+// HTTPS endpoint, that implements the CONIKS account verification Bot
+// interface.
+
+package bots
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+const (
+	// WebhookPath is the path of the endpoint of a WebhookBot.
+	WebhookPath = "/v1/registration"
+
+	// WebhookSignatureHeader is the HTTP header of the provider's
+	// signature of a registration (see SignWebhook()).
+	WebhookSignatureHeader = "Coniks-Signature"
+
+	// webhookLabel separates the provider's signatures of the
+	// registrations from any other message signed with its key.
+	webhookLabel = "coniks-webhook"
+
+	// maxWebhookBodySize bounds the size of a posted registration.
+	maxWebhookBodySize = 8192
+
+	// maxSeenSignatures bounds the number of provider signatures
+	// a WebhookBot remembers to reject their replays.
+	maxSeenSignatures = 1 << 14
+)
+
+var (
+	errInvalidSignature  = errors.New("[registration bot] Invalid provider signature")
+	errReplayedSignature = errors.New("[registration bot] Replayed provider signature")
+)
+
+// A WebhookBot is an account verification bot for the identity
+// providers which verify the accounts of their users themselves, e.g.,
+// when the users log in to the provider's website, and vouch for their
+// registrations. The bot lets any provider integrate with a CONIKS key
+// server without a custom Bot implementation.
+//
+// The provider posts the CONIKS registration request of a user to the
+// bot's HTTPS endpoint WebhookPath, with its signature of the request
+// in the WebhookSignatureHeader header (see SignWebhook()). The bot
+// verifies the signature with the provider's public key, and forwards
+// the registration to the CONIKS server, or returns an attestation in
+// detached mode, if the requested username has the bot's suffix, for
+// which the CONIKS server trusts the provider. The body of the bot's
+// response is the CONIKS server's response.
+type WebhookBot struct {
+	ln            net.Listener
+	srv           *http.Server
+	served        chan struct{}
+	coniksAddress string
+	providerKey   sign.PublicKey
+	suffix        string
+	maxClockSkew  time.Duration
+
+	detached            bool
+	signKey             sign.PrivateKey
+	attestationLifetime time.Duration
+	clock               utils.Clock
+
+	seen seenSignatures
+}
+
+var _ Bot = (*WebhookBot)(nil)
+
+// seenSignatures records the provider signatures a WebhookBot has
+// accepted until they expire, i.e., until they fall out of the bot's
+// maximum clock skew, so that the bot rejects their replays.
+type seenSignatures struct {
+	sync.Mutex
+	expiry map[string]time.Time
+}
+
+// add records sig, which expires at expiry, and returns whether sig
+// hasn't been seen before. Once maxSeenSignatures unexpired signatures
+// are recorded, add rejects any new signature until some of them
+// expire, rather than forgetting the signatures which could still be
+// replayed.
+func (s *seenSignatures) add(sig []byte, expiry, now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.expiry == nil {
+		s.expiry = make(map[string]time.Time)
+	}
+	if _, ok := s.expiry[string(sig)]; ok {
+		return false
+	}
+	if len(s.expiry) >= maxSeenSignatures {
+		for k, e := range s.expiry {
+			if !e.After(now) {
+				delete(s.expiry, k)
+			}
+		}
+		if len(s.expiry) >= maxSeenSignatures {
+			return false
+		}
+	}
+	s.expiry[string(sig)] = expiry
+	return true
+}
+
+// NewWebhookBot constructs a new account verification bot for the
+// registrations posted by an identity provider that implements the
+// Bot interface.
+//
+// NewWebhookBot checks that the CONIKS key server is live (unless the
+// bot runs in detached mode), loads the bot's TLS certificate and
+// listens at the bot's address. If any of these steps fail,
+// NewWebhookBot returns a (nil, error) tuple. Otherwise, it returns
+// a WebhookBot struct with the appropriate values obtained during
+// the setup.
+func NewWebhookBot(conf *WebhookConfig) (*WebhookBot, error) {
+	return newWebhookBot(conf, nil)
+}
+
+// newWebhookBot is NewWebhookBot() with the TLS configuration tlsConf
+// of the endpoint, which defaults to serving the certificate of the
+// bot's configuration.
+func newWebhookBot(conf *WebhookConfig, tlsConf *tls.Config) (*WebhookBot, error) {
+	// Notify if the CONIKS key server is down
+	if _, err := os.Stat(conf.CONIKSAddress); !conf.Detached && os.IsNotExist(err) {
+		return nil, fmt.Errorf("CONIKS Key Server is down")
+	}
+	if tlsConf == nil {
+		cert, err := tls.LoadX509KeyPair(conf.TLSCertPath, conf.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load TLS certificate: %v", err)
+		}
+		tlsConf = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	ln, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	bot := new(WebhookBot)
+	bot.ln = tls.NewListener(ln, tlsConf)
+	bot.coniksAddress = conf.CONIKSAddress
+	bot.providerKey = conf.providerKey
+	bot.suffix = conf.Suffix
+	bot.maxClockSkew = time.Duration(conf.MaxClockSkew) * time.Second
+	bot.detached = conf.Detached
+	bot.signKey = conf.signKey
+	bot.attestationLifetime = time.Duration(conf.AttestationLifetime) * time.Second
+	bot.clock = utils.RealClock
+	bot.srv = &http.Server{
+		Handler:      bot,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	return bot, nil
+}
+
+// Run implements the main functionality of a webhook registration
+// proxy. It serves the bot's HTTPS endpoint in the background, and
+// calls HandleRegistration() upon receiving a registration posted by
+// the identity provider (see ServeHTTP()).
+func (bot *WebhookBot) Run() {
+	bot.served = make(chan struct{})
+	go func() {
+		defer close(bot.served)
+		if err := bot.srv.Serve(bot.ln); err != http.ErrServerClosed {
+			log.Println("[registration bot]", err)
+		}
+	}()
+}
+
+// Stop closes the bot's endpoint, and waits until the registrations it
+// has received are handled.
+func (bot *WebhookBot) Stop() {
+	bot.srv.Shutdown(context.Background())
+	<-bot.served
+}
+
+// ServeHTTP handles the HTTP request r. It only serves the POST method
+// at WebhookPath, whose body is the CONIKS request, and writes the
+// JSON-encoded response of HandleRegistration(). The status code of a
+// request which isn't signed by the provider, or whose signature has
+// already been accepted, is 401 Unauthorized.
+func (bot *WebhookBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != WebhookPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	msg, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", application.HTTPJSONType)
+	w.Header().Set("Cache-Control", "no-store")
+	res, err := bot.handleRegistration(r.Header.Get(WebhookSignatureHeader), msg)
+	if err != nil {
+		log.Println(err, "from", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	w.Write([]byte(res))
+}
+
+// HandleRegistration verifies the authenticity of a CONIKS registration
+// request msg, posted by the identity provider with its signature
+// signature (see SignWebhook()), and forwards this request to the
+// bot's corresponding CONIKS key server if the signature is valid and
+// the requested username has the bot's suffix. It returns the server's
+// response as a string (see forwardRegistration()).
+// A signature is only accepted once, so a registration can't be
+// replayed within the bot's maximum clock skew either.
+//
+// If the bot runs in detached mode, HandleRegistration() expects an
+// attestation request instead, and returns a signed attestation for
+// request.Username (see attest()).
+func (bot *WebhookBot) HandleRegistration(signature string, msg []byte) string {
+	res, err := bot.handleRegistration(signature, msg)
+	if err != nil {
+		log.Println(err)
+	}
+	return res
+}
+
+// handleRegistration is HandleRegistration(), but also returns
+// errInvalidSignature or errReplayedSignature if the provider's
+// signature isn't accepted, along with the error response.
+func (bot *WebhookBot) handleRegistration(signature string, msg []byte) (string, error) {
+	sig, expiry, ok := bot.verifySignature(signature, msg)
+	if !ok {
+		return errorResponse(protocol.ErrMalformedMessage), errInvalidSignature
+	}
+	if !bot.seen.add(sig, expiry, bot.clock.Now()) {
+		return errorResponse(protocol.ErrMalformedMessage), errReplayedSignature
+	}
+	owns := func(username string) bool {
+		return len(username) > len(bot.suffix) && strings.HasSuffix(username, bot.suffix)
+	}
+	if bot.detached {
+		expiry := bot.clock.Now().Add(bot.attestationLifetime)
+		return attest(bot.signKey, expiry, msg, owns), nil
+	}
+	return forwardRegistration(bot.coniksAddress, msg, owns), nil
+}
+
+// verifySignature returns the provider's signature sig parsed from
+// signature, and whether it is the provider's signature of msg, made
+// less than the bot's maximum clock skew away from the bot's current
+// time, which bounds the replays of a registration. The signature
+// expires once it is more than the maximum clock skew in the past.
+func (bot *WebhookBot) verifySignature(signature string, msg []byte) (
+	sig []byte, expiry time.Time, ok bool) {
+	timestamp, sig, ok := parseWebhookSignature(signature)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	t := time.Unix(int64(timestamp), 0)
+	skew := bot.clock.Now().Sub(t)
+	if skew > bot.maxClockSkew || -skew > bot.maxClockSkew {
+		return nil, time.Time{}, false
+	}
+	if !bot.providerKey.Verify(webhookMessage(timestamp, msg), sig) {
+		return nil, time.Time{}, false
+	}
+	return sig, t.Add(bot.maxClockSkew), true
+}
+
+// SignWebhook returns the value of the WebhookSignatureHeader header of
+// the registration request msg posted by an identity provider at time
+// t, signed with the provider's key: "t=<unix time>,sig=<base64 signature>".
+// The signature covers t, so a WebhookBot only accepts it within its
+// maximum clock skew of t.
+func SignWebhook(key sign.PrivateKey, t time.Time, msg []byte) string {
+	timestamp := uint64(t.Unix())
+	sig := key.Sign(webhookMessage(timestamp, msg))
+	return fmt.Sprintf("t=%d,sig=%s", timestamp, base64.StdEncoding.EncodeToString(sig))
+}
+
+// parseWebhookSignature parses the value of the WebhookSignatureHeader
+// header (see SignWebhook()).
+func parseWebhookSignature(signature string) (timestamp uint64, sig []byte, ok bool) {
+	fields := strings.Split(signature, ",")
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "t=") ||
+		!strings.HasPrefix(fields[1], "sig=") {
+		return 0, nil, false
+	}
+	timestamp, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "t="), 10, 63)
+	if err != nil {
+		return 0, nil, false
+	}
+	sig, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(fields[1], "sig="))
+	if err != nil || len(sig) != sign.SignatureSize {
+		return 0, nil, false
+	}
+	return timestamp, sig, true
+}
+
+// webhookMessage returns the message the provider signs for the
+// registration request msg posted at timestamp.
+func webhookMessage(timestamp uint64, msg []byte) []byte {
+	var buf []byte
+	buf = append(buf, []byte(webhookLabel)...)
+	buf = append(buf, utils.ULongToBytes(timestamp)...)
+	buf = append(buf, msg...)
+	return buf
+}
@@ -0,0 +1,179 @@
+package bots
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	providerKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := providerKey.Public()
+	bot := &WebhookBot{
+		providerKey:  pk,
+		maxClockSkew: 5 * time.Minute,
+		clock:        utils.NewFakeClock(time.Unix(1000, 0)),
+	}
+	msg := []byte("request")
+	for _, tc := range []struct {
+		name      string
+		signature string
+		valid     bool
+	}{
+		{"valid", SignWebhook(providerKey, time.Unix(1000, 0), msg), true},
+		{"early", SignWebhook(providerKey, time.Unix(1300, 0), msg), true},
+		{"late", SignWebhook(providerKey, time.Unix(700, 0), msg), true},
+		{"expired", SignWebhook(providerKey, time.Unix(699, 0), msg), false},
+		{"future", SignWebhook(providerKey, time.Unix(1301, 0), msg), false},
+		{"other request", SignWebhook(providerKey, time.Unix(1000, 0), []byte("other")), false},
+		{"other time", strings.Replace(
+			SignWebhook(providerKey, time.Unix(1000, 0), msg), "t=1000", "t=1001", 1), false},
+		{"missing", "", false},
+		{"malformed", "t=1000,sig=???", false},
+	} {
+		if _, _, got := bot.verifySignature(tc.signature, msg); got != tc.valid {
+			t.Error(tc.name, "expect", tc.valid, "got", got)
+		}
+	}
+}
+
+func TestWebhookBot(t *testing.T) {
+	cert, clientConf := newTestCertificate(t, "localhost")
+	providerKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerPK, _ := providerKey.Public()
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+
+	conf := &WebhookConfig{
+		Address:             "127.0.0.1:0",
+		Suffix:              "@example.org",
+		MaxClockSkew:        DefaultMaxClockSkew,
+		Detached:            true,
+		AttestationLifetime: 60,
+		providerKey:         providerPK,
+		signKey:             signKey,
+	}
+	bot, err := newWebhookBot(conf, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.clock = utils.NewFakeClock(time.Unix(0, 0))
+	bot.Run()
+	defer bot.Stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConf}}
+	url := "https://" + bot.ln.Addr().String() + WebhookPath
+	post := func(signature string, msg []byte) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", application.HTTPJSONType)
+		req.Header.Set(WebhookSignatureHeader, signature)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+	request := func(username string) []byte {
+		msg, _ := json.Marshal(&protocol.Request{
+			Type:    protocol.AttestationType,
+			Request: &protocol.AttestationRequest{Username: username},
+		})
+		return msg
+	}
+	malformed := fmt.Sprintf(`{"Error":%d}`, protocol.ErrMalformedMessage)
+
+	msg := request("alice@example.org")
+	status, body := post(SignWebhook(providerKey, time.Unix(0, 0), msg), msg)
+	if status != http.StatusOK {
+		t.Fatal("Expect", http.StatusOK, "got", status, body)
+	}
+	res := application.UnmarshalResponse(protocol.AttestationType, []byte(body))
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.RegistrationAttestation().Verify(pk, "alice@example.org", time.Unix(60, 0)) {
+		t.Fatal("Expect a valid attestation")
+	}
+
+	// the provider only vouches for the usernames with the bot's suffix
+	for _, username := range []string{"alice@evil.example", "@example.org"} {
+		msg := request(username)
+		status, body := post(SignWebhook(providerKey, time.Unix(0, 0), msg), msg)
+		if status != http.StatusOK || body != malformed {
+			t.Error(username, "expect", malformed, "got", status, body)
+		}
+	}
+
+	// a signed request is only accepted once
+	if status, body := post(SignWebhook(providerKey, time.Unix(0, 0), msg), msg); status != http.StatusUnauthorized || body != malformed {
+		t.Error("Expect the replay to be rejected with", http.StatusUnauthorized, "got", status, body)
+	}
+
+	// the requests must be signed by the provider
+	if status, body := post(SignWebhook(signKey, time.Unix(0, 0), msg), msg); status != http.StatusUnauthorized || body != malformed {
+		t.Error("Expect", http.StatusUnauthorized, "got", status, body)
+	}
+
+	res2, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2.Body.Close()
+	if res2.StatusCode != http.StatusMethodNotAllowed {
+		t.Error("Expect", http.StatusMethodNotAllowed, "got", res2.StatusCode)
+	}
+}
+
+func TestSeenSignatures(t *testing.T) {
+	var seen seenSignatures
+	now := time.Unix(1000, 0)
+	sig := func(i int) []byte {
+		return []byte(fmt.Sprint("sig", i))
+	}
+	if !seen.add(sig(0), now.Add(time.Minute), now) {
+		t.Fatal("Expect a new signature to be accepted")
+	}
+	if seen.add(sig(0), now.Add(time.Minute), now) {
+		t.Fatal("Expect a replayed signature to be rejected")
+	}
+	for i := 1; i < maxSeenSignatures; i++ {
+		seen.add(sig(i), now.Add(2*time.Minute), now)
+	}
+	// the cache is full of unexpired signatures
+	if seen.add(sig(maxSeenSignatures), now.Add(time.Minute), now) {
+		t.Fatal("Expect a new signature to be rejected while the cache is full")
+	}
+	// the first signature expires, but the other ones are remembered
+	now = now.Add(time.Minute)
+	if !seen.add(sig(maxSeenSignatures), now.Add(time.Minute), now) {
+		t.Fatal("Expect a new signature to be accepted once another one expired")
+	}
+	if seen.add(sig(1), now.Add(time.Minute), now) {
+		t.Fatal("Expect an unexpired signature to be remembered")
+	}
+}
@@ -0,0 +1,319 @@
+// A minimal XMPP client (RFC 6120), which implements just what an
+// account verification bot needs: authenticating over TLS with SASL
+// PLAIN, and exchanging chat messages.
+
+package bots
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	nsClient  = "jabber:client"
+	nsStream  = "http://etherx.jabber.org/streams"
+	nsTLS     = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsSASL    = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsBind    = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsSession = "urn:ietf:params:xml:ns:xmpp-session"
+
+	// xmppResource is the resource the bot binds its session to.
+	xmppResource = "coniksbot"
+)
+
+// errNoTLS indicates that the XMPP server doesn't offer STARTTLS.
+var errNoTLS = errors.New("[registration bot] The XMPP server doesn't support STARTTLS")
+
+// xmppFeatures are the stream features an XMPP server advertises.
+type xmppFeatures struct {
+	XMLName    xml.Name  `xml:"http://etherx.jabber.org/streams features"`
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Session    *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-session session"`
+}
+
+// An xmppMessage is a message stanza. The XMPP server of the recipient
+// sets From to the full JID of the authenticated sender.
+type xmppMessage struct {
+	XMLName xml.Name `xml:"jabber:client message"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	Body    string   `xml:"body"`
+}
+
+// An xmppIQ is an info/query stanza, of which the client only sends
+// the requests to bind its resource and to establish its session.
+type xmppIQ struct {
+	XMLName xml.Name `xml:"jabber:client iq"`
+	ID      string   `xml:"id,attr"`
+	Type    string   `xml:"type,attr"`
+	Bind    *struct {
+		JID string `xml:"jid"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// An xmppConn is an authenticated XMPP client stream, bound to the
+// full JID jid. The messages it receives are read with next(), and
+// its writes are serialized by lock.
+type xmppConn struct {
+	conn net.Conn
+	dec  *xml.Decoder
+	jid  string
+	lock sync.Mutex
+}
+
+// dialXMPP opens an XMPP client stream on conn for the account jid
+// (a bare JID), upgrades it to TLS with tlsConf, either immediately if
+// directTLS is set, or with STARTTLS, authenticates with password, and
+// binds the stream to a resource. It announces the client's presence,
+// so that the server delivers the messages sent to the bare JID.
+// dialXMPP() closes conn if any of these steps fails.
+func dialXMPP(conn net.Conn, jid, password string, tlsConf *tls.Config,
+	directTLS bool) (*xmppConn, error) {
+	c, err := negotiate(conn, jid, password, tlsConf, directTLS)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func negotiate(conn net.Conn, jid, password string, tlsConf *tls.Config,
+	directTLS bool) (*xmppConn, error) {
+	local, domain, ok := splitJID(jid)
+	if !ok {
+		return nil, fmt.Errorf("[registration bot] Invalid JID %q", jid)
+	}
+	if tlsConf == nil {
+		tlsConf = &tls.Config{ServerName: domain}
+	}
+	c := &xmppConn{conn: conn}
+	if directTLS {
+		c.conn = tls.Client(conn, tlsConf)
+	}
+	features, err := c.openStream(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if !directTLS {
+		if features.StartTLS == nil {
+			return nil, errNoTLS
+		}
+		if _, err := fmt.Fprintf(c.conn, "<starttls xmlns='%s'/>", nsTLS); err != nil {
+			return nil, err
+		}
+		if err := c.expect(nsTLS, "proceed"); err != nil {
+			return nil, err
+		}
+		c.conn = tls.Client(c.conn, tlsConf)
+		if features, err = c.openStream(domain); err != nil {
+			return nil, err
+		}
+	}
+
+	plain := false
+	for _, m := range features.Mechanisms {
+		plain = plain || m == "PLAIN"
+	}
+	if !plain {
+		return nil, fmt.Errorf("[registration bot] The XMPP server doesn't support SASL PLAIN")
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("\x00" + local + "\x00" + password))
+	if _, err := fmt.Fprintf(c.conn, "<auth xmlns='%s' mechanism='PLAIN'>%s</auth>",
+		nsSASL, auth); err != nil {
+		return nil, err
+	}
+	if err := c.expect(nsSASL, "success"); err != nil {
+		return nil, fmt.Errorf("[registration bot] XMPP authentication failed: %v", err)
+	}
+
+	if features, err = c.openStream(domain); err != nil {
+		return nil, err
+	}
+	if features.Bind == nil {
+		return nil, fmt.Errorf("[registration bot] The XMPP server doesn't support resource binding")
+	}
+	if _, err := fmt.Fprintf(c.conn,
+		"<iq type='set' id='bind'><bind xmlns='%s'><resource>%s</resource></bind></iq>",
+		nsBind, xmppResource); err != nil {
+		return nil, err
+	}
+	var iq xmppIQ
+	if err := c.decode(&iq); err != nil {
+		return nil, err
+	}
+	if iq.Type != "result" || iq.Bind == nil || iq.Bind.JID == "" {
+		return nil, fmt.Errorf("[registration bot] XMPP resource binding failed")
+	}
+	c.jid = iq.Bind.JID
+	if features.Session != nil {
+		if _, err := fmt.Fprintf(c.conn,
+			"<iq type='set' id='session'><session xmlns='%s'/></iq>", nsSession); err != nil {
+			return nil, err
+		}
+		if err := c.decode(&iq); err != nil {
+			return nil, err
+		}
+		if iq.Type != "result" {
+			return nil, fmt.Errorf("[registration bot] XMPP session establishment failed")
+		}
+	}
+	if _, err := io.WriteString(c.conn, "<presence/>"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// openStream opens a new stream to domain over c.conn, i.e., at the
+// start of the connection and after each restart of the stream, and
+// returns the features the server advertises.
+func (c *xmppConn) openStream(domain string) (*xmppFeatures, error) {
+	if _, err := fmt.Fprintf(c.conn, "<?xml version='1.0'?>"+
+		"<stream:stream to='%s' xmlns='%s' xmlns:stream='%s' version='1.0'>",
+		xmlEscape(domain), nsClient, nsStream); err != nil {
+		return nil, err
+	}
+	c.dec = xml.NewDecoder(c.conn)
+	for {
+		t, err := c.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			if se.Name.Space != nsStream || se.Name.Local != "stream" {
+				return nil, fmt.Errorf("[registration bot] Expect an XMPP stream, got <%s>",
+					se.Name.Local)
+			}
+			break
+		}
+	}
+	features := new(xmppFeatures)
+	if err := c.decode(features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// nextElement returns the start of the next top-level element of the
+// stream, or io.EOF if the server closed the stream.
+func (c *xmppConn) nextElement() (*xml.StartElement, error) {
+	for {
+		t, err := c.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			return &t, nil
+		case xml.EndElement:
+			// the end of the stream
+			return nil, io.EOF
+		}
+	}
+}
+
+// decode decodes the next top-level element of the stream into v.
+func (c *xmppConn) decode(v interface{}) error {
+	se, err := c.nextElement()
+	if err != nil {
+		return err
+	}
+	return c.dec.DecodeElement(v, se)
+}
+
+// expect reads the next top-level element of the stream, and returns
+// an error unless it is the element local in the namespace space.
+func (c *xmppConn) expect(space, local string) error {
+	se, err := c.nextElement()
+	if err != nil {
+		return err
+	}
+	if err := c.dec.Skip(); err != nil {
+		return err
+	}
+	if se.Name.Space != space || se.Name.Local != local {
+		return fmt.Errorf("unexpected <%s>", se.Name.Local)
+	}
+	return nil
+}
+
+// next returns the next message stanza received from the stream,
+// skipping any other stanza, or io.EOF once the stream is closed.
+func (c *xmppConn) next() (*xmppMessage, error) {
+	for {
+		se, err := c.nextElement()
+		if err != nil {
+			return nil, err
+		}
+		if se.Name.Space != nsClient || se.Name.Local != "message" {
+			if err := c.dec.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msg := new(xmppMessage)
+		if err := c.dec.DecodeElement(msg, se); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// send sends the chat message body to the JID to.
+func (c *xmppConn) send(to, body string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := fmt.Fprintf(c.conn, "<message to='%s' type='chat'><body>%s</body></message>",
+		xmlEscape(to), xmlEscape(body))
+	return err
+}
+
+// close closes the stream and the underlying connection.
+func (c *xmppConn) close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	io.WriteString(c.conn, "</stream:stream>")
+	return c.conn.Close()
+}
+
+// xmlEscape returns s escaped for XML character data and attribute
+// values, including the quotes.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// splitJID splits the bare or full JID jid into its local part and its
+// domain, dropping its resource, if any. It returns false if jid has no
+// domain.
+func splitJID(jid string) (local, domain string, ok bool) {
+	if i := strings.Index(jid, "/"); i >= 0 {
+		jid = jid[:i]
+	}
+	if i := strings.LastIndex(jid, "@"); i >= 0 {
+		local, domain = jid[:i], jid[i+1:]
+	} else {
+		domain = jid
+	}
+	return local, domain, domain != ""
+}
+
+// bareJID returns the bare JID of the full JID jid, i.e., jid without
+// its resource.
+func bareJID(jid string) string {
+	if i := strings.Index(jid, "/"); i >= 0 {
+		return jid[:i]
+	}
+	return jid
+}
@@ -0,0 +1,171 @@
+// A registration proxy for XMPP accounts that implements the
+// CONIKS account verification Bot interface.
+
+package bots
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// An XMPPBot is an account verification bot for CONIKS clients
+// registering XMPP accounts with a CONIKS key server.
+//
+// A client sends its registration to the bot's JID in an XMPP chat
+// message, from the account it registers. Since the XMPP servers
+// authenticate the senders of the messages they route, and stamp
+// each message with the JID of its sender, the bot verifies that the
+// sender's bare JID is the registered username, and that it belongs
+// to one of the domains the bot verifies.
+//
+// An XMPPBot maintains its XMPP stream, the address of its
+// corresponding CONIKS server, and the domains it verifies. An XMPPBot
+// running in detached mode also maintains the key with which it signs
+// its attestations.
+type XMPPBot struct {
+	conn          *xmppConn
+	coniksAddress string
+	domains       []string
+
+	detached            bool
+	signKey             sign.PrivateKey
+	attestationLifetime time.Duration
+	clock               utils.Clock
+
+	// pending counts the goroutines receiving and handling the
+	// messages, which Stop() waits for
+	pending sync.WaitGroup
+}
+
+var _ Bot = (*XMPPBot)(nil)
+
+// NewXMPPBot constructs a new account verification bot for XMPP
+// accounts that implements the Bot interface.
+//
+// NewXMPPBot checks that the CONIKS key server is live (unless the bot
+// runs in detached mode), and connects to the bot's XMPP server,
+// over TLS, with the bot's JID and password.
+// If any of these steps fail, NewXMPPBot returns a (nil, error)
+// tuple. Otherwise, it returns an XMPPBot struct
+// with the appropriate values obtained during the setup.
+func NewXMPPBot(conf *XMPPConfig) (*XMPPBot, error) {
+	return newXMPPBot(conf, nil)
+}
+
+// newXMPPBot is NewXMPPBot() with the TLS configuration tlsConf,
+// which defaults to verifying the certificate of the bot's domain.
+func newXMPPBot(conf *XMPPConfig, tlsConf *tls.Config) (*XMPPBot, error) {
+	// Notify if the CONIKS key server is down
+	if _, err := os.Stat(conf.CONIKSAddress); !conf.Detached && os.IsNotExist(err) {
+		return nil, fmt.Errorf("CONIKS Key Server is down")
+	}
+	conn, err := net.DialTimeout("tcp", conf.Server, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dialXMPP(conn, conf.JID, conf.Password, tlsConf, conf.DirectTLS)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[registration bot] Connected to XMPP as %s", c.jid)
+
+	bot := new(XMPPBot)
+	bot.conn = c
+	bot.coniksAddress = conf.CONIKSAddress
+	bot.domains = conf.Domains
+	bot.detached = conf.Detached
+	bot.signKey = conf.signKey
+	bot.attestationLifetime = time.Duration(conf.AttestationLifetime) * time.Second
+	bot.clock = utils.RealClock
+	return bot, nil
+}
+
+// Run implements the main functionality of an XMPP registration proxy.
+// It listens for the XMPP messages sent to the bot's JID in the
+// background, and calls HandleRegistration() upon receiving a valid
+// message sent by a CONIKS client connected to an XMPP account.
+// The result of HandleRegistration() is returned to the CONIKS client
+// in a reply to the message.
+func (bot *XMPPBot) Run() {
+	bot.pending.Add(1)
+	go func() {
+		defer bot.pending.Done()
+		for {
+			msg, err := bot.conn.next()
+			if err != nil {
+				log.Printf("[registration bot] XMPP stream closed: %v", err)
+				return
+			}
+			bot.pending.Add(1)
+			go func() {
+				defer bot.pending.Done()
+				bot.handleMessage(msg)
+			}()
+		}
+	}()
+}
+
+// handleMessage handles the message msg, and replies to its sender.
+func (bot *XMPPBot) handleMessage(msg *xmppMessage) {
+	if msg.Type == "error" || msg.Type == "groupchat" ||
+		!strings.HasPrefix(msg.Body, messagePrefix) ||
+		strings.EqualFold(bareJID(msg.From), bareJID(bot.conn.jid)) {
+		return
+	}
+	req := strings.TrimPrefix(msg.Body, messagePrefix)
+	res := bot.HandleRegistration(msg.From, []byte(req))
+	if err := bot.conn.send(msg.From, messagePrefix+res); err != nil {
+		log.Println("[registration bot]", err)
+	}
+}
+
+// Stop closes the bot's XMPP stream, and waits until the messages it
+// has received are handled.
+func (bot *XMPPBot) Stop() {
+	bot.conn.close()
+	bot.pending.Wait()
+}
+
+// HandleRegistration verifies the authenticity of a CONIKS registration
+// request msg for an XMPP user, and forwards this request to the bot's
+// corresponding CONIKS key server if the XMPP account of jid, the JID
+// which sent the request, owns the requested username, i.e., if the
+// username is the bare JID of jid and its domain is one of the domains
+// the bot verifies. It returns the server's response as a string
+// (see forwardRegistration()).
+//
+// If the bot runs in detached mode, HandleRegistration() expects an
+// attestation request instead, and returns a signed attestation for
+// request.Username if jid owns it (see attest()).
+func (bot *XMPPBot) HandleRegistration(jid string, msg []byte) string {
+	owns := func(username string) bool { return bot.isAccount(jid, username) }
+	if bot.detached {
+		expiry := bot.clock.Now().Add(bot.attestationLifetime)
+		return attest(bot.signKey, expiry, msg, owns)
+	}
+	return forwardRegistration(bot.coniksAddress, msg, owns)
+}
+
+// isAccount returns whether the CONIKS username is the bare JID of
+// the account jid, and whether the bot verifies its domain.
+func (bot *XMPPBot) isAccount(jid, username string) bool {
+	local, domain, ok := splitJID(jid)
+	if !ok || local == "" || !strings.EqualFold(bareJID(jid), username) {
+		return false
+	}
+	for _, d := range bot.domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
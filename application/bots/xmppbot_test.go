@@ -0,0 +1,280 @@
+package bots
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestXMPPIsAccount(t *testing.T) {
+	bot := &XMPPBot{domains: []string{"example.org"}}
+	for _, tc := range []struct {
+		jid, username string
+		valid         bool
+	}{
+		{"alice@example.org/phone", "alice@example.org", true},
+		{"Alice@Example.org", "alice@example.org", true},
+		{"alice@example.org/phone", "bob@example.org", false},
+		{"alice@evil.example/phone", "alice@evil.example", false},
+		{"example.org", "example.org", false},
+	} {
+		if got := bot.isAccount(tc.jid, tc.username); got != tc.valid {
+			t.Error(tc.jid, tc.username, "expect", tc.valid, "got", got)
+		}
+	}
+}
+
+// newTestCertificate returns a self-signed TLS certificate for domain,
+// and a client configuration which trusts it.
+func newTestCertificate(t *testing.T, domain string) (tls.Certificate, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		&tls.Config{RootCAs: roots, ServerName: domain}
+}
+
+// A fakeXMPPServer is the XMPP server of example.org, which accepts
+// the bot's account bot@example.org with the password "secret",
+// delivers the messages of its inbox to the bot, and forwards the
+// bot's replies to its outbox.
+type fakeXMPPServer struct {
+	ln     net.Listener
+	cert   tls.Certificate
+	inbox  chan *xmppMessage
+	outbox chan *xmppMessage
+	errs   chan error
+}
+
+type xmppElement struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+}
+
+func newFakeXMPPServer(t *testing.T, cert tls.Certificate) *fakeXMPPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeXMPPServer{
+		ln:     ln,
+		cert:   cert,
+		inbox:  make(chan *xmppMessage),
+		outbox: make(chan *xmppMessage, 1),
+		errs:   make(chan error, 1),
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.errs <- err
+			return
+		}
+		defer conn.Close()
+		s.errs <- s.serve(conn)
+	}()
+	return s
+}
+
+// openStream reads the client's stream header from conn, and replies
+// with the server's header and features.
+func (s *fakeXMPPServer) openStream(conn io.ReadWriter, features string) (*xml.Decoder, error) {
+	dec := xml.NewDecoder(conn)
+	if _, err := nextStart(dec); err != nil {
+		return nil, err
+	}
+	_, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream from='example.org' "+
+		"id='42' xmlns='%s' xmlns:stream='%s' version='1.0'>"+
+		"<stream:features>%s</stream:features>", nsClient, nsStream, features)
+	return dec, err
+}
+
+func nextStart(dec *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			return &se, nil
+		}
+	}
+}
+
+func readElement(dec *xml.Decoder) (*xmppElement, error) {
+	se, err := nextStart(dec)
+	if err != nil {
+		return nil, err
+	}
+	e := new(xmppElement)
+	return e, dec.DecodeElement(e, se)
+}
+
+func (s *fakeXMPPServer) serve(conn net.Conn) error {
+	dec, err := s.openStream(conn, "<starttls xmlns='"+nsTLS+"'><required/></starttls>")
+	if err != nil {
+		return err
+	}
+	if e, err := readElement(dec); err != nil || e.XMLName.Local != "starttls" {
+		return fmt.Errorf("expect starttls, got %v %v", e, err)
+	}
+	fmt.Fprintf(conn, "<proceed xmlns='%s'/>", nsTLS)
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+
+	dec, err = s.openStream(tlsConn,
+		"<mechanisms xmlns='"+nsSASL+"'><mechanism>PLAIN</mechanism></mechanisms>")
+	if err != nil {
+		return err
+	}
+	e, err := readElement(dec)
+	if err != nil {
+		return err
+	}
+	creds, _ := base64.StdEncoding.DecodeString(e.Text)
+	if e.XMLName.Local != "auth" || string(creds) != "\x00bot\x00secret" {
+		fmt.Fprintf(tlsConn, "<failure xmlns='%s'><not-authorized/></failure>", nsSASL)
+		return nil
+	}
+	fmt.Fprintf(tlsConn, "<success xmlns='%s'/>", nsSASL)
+
+	dec, err = s.openStream(tlsConn, "<bind xmlns='"+nsBind+"'/>")
+	if err != nil {
+		return err
+	}
+	if e, err := readElement(dec); err != nil || e.XMLName.Local != "iq" {
+		return fmt.Errorf("expect a bind request, got %v %v", e, err)
+	}
+	fmt.Fprintf(tlsConn, "<iq type='result' id='bind'><bind xmlns='%s'>"+
+		"<jid>bot@example.org/coniksbot</jid></bind></iq>", nsBind)
+	if e, err := readElement(dec); err != nil || e.XMLName.Local != "presence" {
+		return fmt.Errorf("expect a presence, got %v %v", e, err)
+	}
+
+	go func() {
+		for msg := range s.inbox {
+			fmt.Fprintf(tlsConn, "<iq type='get' id='ping' from='example.org'/>"+
+				"<message from='%s' to='bot@example.org' type='chat'><body>%s</body></message>",
+				xmlEscape(msg.From), xmlEscape(msg.Body))
+		}
+		io.WriteString(tlsConn, "</stream:stream>")
+	}()
+	for {
+		se, err := nextStart(dec)
+		if err != nil {
+			return nil
+		}
+		var msg xmppMessage
+		if err := dec.DecodeElement(&msg, se); err != nil {
+			return err
+		}
+		s.outbox <- &msg
+	}
+}
+
+func TestXMPPBot(t *testing.T) {
+	cert, tlsConf := newTestCertificate(t, "example.org")
+	s := newFakeXMPPServer(t, cert)
+	defer s.ln.Close()
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+
+	conf := &XMPPConfig{
+		Server:              s.ln.Addr().String(),
+		JID:                 "bot@example.org",
+		Password:            "secret",
+		Domains:             []string{"example.org"},
+		Detached:            true,
+		AttestationLifetime: 60,
+		signKey:             signKey,
+	}
+	bot, err := newXMPPBot(conf, tlsConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.clock = utils.NewFakeClock(time.Unix(0, 0))
+	bot.Run()
+
+	request, _ := json.Marshal(&protocol.Request{
+		Type:    protocol.AttestationType,
+		Request: &protocol.AttestationRequest{Username: "alice@example.org"},
+	})
+	s.inbox <- &xmppMessage{From: "alice@example.org/phone", Body: messagePrefix + string(request)}
+	reply := <-s.outbox
+	if reply.To != "alice@example.org/phone" || !strings.HasPrefix(reply.Body, messagePrefix) {
+		t.Fatal("Unexpected reply", reply)
+	}
+	res := application.UnmarshalResponse(protocol.AttestationType,
+		[]byte(strings.TrimPrefix(reply.Body, messagePrefix)))
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.RegistrationAttestation().Verify(pk, "alice@example.org", time.Unix(60, 0)) {
+		t.Fatal("Expect a valid attestation")
+	}
+
+	// another account can't obtain alice's attestation
+	s.inbox <- &xmppMessage{From: "mallory@example.org/laptop", Body: messagePrefix + string(request)}
+	reply = <-s.outbox
+	if reply.Body != fmt.Sprintf(`%s{"Error":%d}`, messagePrefix, protocol.ErrMalformedMessage) {
+		t.Fatal("Unexpected reply", reply.Body)
+	}
+
+	close(s.inbox)
+	bot.Stop()
+	if err := <-s.errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXMPPBotBadPassword(t *testing.T) {
+	cert, tlsConf := newTestCertificate(t, "example.org")
+	s := newFakeXMPPServer(t, cert)
+	defer s.ln.Close()
+	conf := &XMPPConfig{
+		Server:   s.ln.Addr().String(),
+		JID:      "bot@example.org",
+		Password: "wrong",
+		Detached: true,
+	}
+	if _, err := newXMPPBot(conf, tlsConf); err == nil {
+		t.Fatal("Expect the authentication to fail")
+	}
+}
@@ -1,21 +1,52 @@
 package client
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/coniks-sys/coniks-go/application"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
-// Config contains the client's configuration needed to send a request to a
-// CONIKS server: the path to the server's signing public-key file
-// and the actual public-key parsed from that file; the server's addresses
-// for sending registration requests and other types of requests,
-// respectively.
+// DefaultDirectoryName is the name of the directory configured by the
+// top-level values of a client's configuration, if it isn't named
+// explicitly.
+const DefaultDirectoryName = "default"
+
+// DirectoryConfig contains the client's configuration needed to send
+// a request to a single CONIKS directory: the directory's name, which
+// the user refers to the directory by, the path to the server's signing
+// public-key file and the actual public-key parsed from that file;
+// the server's addresses for sending registration requests and other
+// types of requests, respectively.
 //
 // Note that if RegAddress is empty, the client falls back to using Address
 // for all request types.
-type Config struct {
-	*application.CommonConfig
+// Strict optionally enables the client's strict mode for the directory
+// (see StrictConfig), and Resolution optionally restricts the resolution
+// of the host names of the directory's addresses (see
+// utils.ResolutionPolicy). Encoding is the encoding of the messages the
+// client exchanges with the directory, which has to match the encoding
+// of the server's addresses (see application.ServerAddress.Encoding).
+// StatePath optionally specifies the file in which the client keeps its
+// consistency state for the directory across restarts (see
+// Directory.LoadState()). Rekor optionally specifies the Rekor
+// transparency log in which the directory publishes its STRs (see
+// Directory.Witness()). CheckpointPaths optionally specifies the files
+// of historical STRs of the directory, e.g. of epochs 10000 and 100000,
+// which the client pins in addition to the initial STR (see
+// client.ConsistencyChecks.PinCheckpoints()), and Checkpoints are the
+// parsed STRs. Auditors optionally specifies the addresses of the
+// auditors which the client asks to audit its verified STR after each
+// registration and key lookup (see client.ConsistencyChecks.Audit()).
+// Telemetry optionally specifies the auditors to which the client
+// reports its verified STR anonymously, if its user opts in (see
+// TelemetryConfig).
+type DirectoryConfig struct {
+	Name string `toml:"name,omitempty"`
 
 	SignPubkeyPath string `toml:"sign_pubkey_path"`
 	SigningPubKey  sign.PublicKey
@@ -23,8 +54,85 @@ type Config struct {
 	InitSTRPath string `toml:"init_str_path"`
 	InitSTR     *protocol.DirSTR
 
+	CheckpointPaths []string `toml:"checkpoint_paths,omitempty"`
+	Checkpoints     []*protocol.DirSTR
+
 	RegAddress string `toml:"registration_address,omitempty"`
 	Address    string `toml:"address"`
+
+	Strict *StrictConfig `toml:"strict,omitempty"`
+
+	Auditors []string `toml:"auditors,omitempty"`
+
+	Telemetry *TelemetryConfig `toml:"telemetry,omitempty"`
+
+	Resolution *utils.ResolutionPolicy `toml:"resolution,omitempty"`
+
+	Encoding string `toml:"encoding,omitempty"`
+
+	StatePath string `toml:"state_path,omitempty"`
+	statePath string
+
+	Rekor *application.RekorConfig `toml:"rekor,omitempty"`
+}
+
+// These are the fallbacks a StrictConfig can specify.
+const (
+	FallbackReject = "reject"
+	FallbackAccept = "accept"
+)
+
+// StrictConfig contains the settings of the client's strict mode for
+// a directory (see client.StrictMode): the addresses of the auditors
+// the client asks to confirm the STRs of its registrations, the time
+// in seconds the client waits for a confirmation, and the fallback once
+// this time has passed, either FallbackReject (the default) or
+// FallbackAccept.
+type StrictConfig struct {
+	Auditors            []string           `toml:"auditors"`
+	ConfirmationTimeout protocol.Timestamp `toml:"confirmation_timeout"`
+	Fallback            string             `toml:"fallback,omitempty"`
+}
+
+// Mode returns the client.StrictMode corresponding to the strict
+// mode settings.
+func (sc *StrictConfig) Mode() *client.StrictMode {
+	mode := &client.StrictMode{
+		Timeout:  time.Duration(sc.ConfirmationTimeout) * time.Second,
+		Fallback: client.RejectUnconfirmed,
+	}
+	if sc.Fallback == FallbackAccept {
+		mode.Fallback = client.AcceptUnconfirmed
+	}
+	return mode
+}
+
+// validate checks that the strict mode settings specify at least one
+// auditor, a positive timeout and a known fallback.
+func (sc *StrictConfig) validate() error {
+	switch {
+	case len(sc.Auditors) == 0:
+		return fmt.Errorf("The strict mode requires at least one auditor")
+	case sc.ConfirmationTimeout == 0:
+		return fmt.Errorf("The strict mode requires a positive confirmation_timeout")
+	}
+	switch sc.Fallback {
+	case "", FallbackReject, FallbackAccept:
+		return nil
+	default:
+		return fmt.Errorf("Unknown strict mode fallback: %q", sc.Fallback)
+	}
+}
+
+// Config contains the client's configuration: the embedded
+// DirectoryConfig specifies the client's default directory, and
+// Directories specifies any additional directories, e.g. if a user has
+// identities in several CONIKS directories.
+type Config struct {
+	*application.CommonConfig
+	DirectoryConfig
+
+	Directories []*DirectoryConfig `toml:"directories,omitempty"`
 }
 
 var _ application.AppConfig = (*Config)(nil)
@@ -36,11 +144,13 @@ var _ application.AppConfig = (*Config)(nil)
 func NewConfig(file, encoding, signPubkeyPath, initSTRPath, regAddr,
 	serverAddr string) *Config {
 	var conf = Config{
-		CommonConfig:   application.NewCommonConfig(file, encoding, nil),
-		SignPubkeyPath: signPubkeyPath,
-		InitSTRPath:    initSTRPath,
-		RegAddress:     regAddr,
-		Address:        serverAddr,
+		CommonConfig: application.NewCommonConfig(file, encoding, nil),
+		DirectoryConfig: DirectoryConfig{
+			SignPubkeyPath: signPubkeyPath,
+			InitSTRPath:    initSTRPath,
+			RegAddress:     regAddr,
+			Address:        serverAddr,
+		},
 	}
 
 	return &conf
@@ -48,30 +158,122 @@ func NewConfig(file, encoding, signPubkeyPath, initSTRPath, regAddr,
 
 // Load initializes a client's configuration from the given file
 // using the given encoding.
-// It reads the signing public-key file and parses the actual key.
+// It reads the signing public-key file and parses the actual key,
+// and the initial STR of each configured directory.
+// Load() returns an error if two directories have the same name,
+// or if a directory's strict mode or telemetry settings are invalid.
 func (conf *Config) Load(file, encoding string) error {
 	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
 	if err := conf.GetLoader().Decode(conf); err != nil {
 		return err
 	}
+	if conf.Name == "" {
+		conf.Name = DefaultDirectoryName
+	}
+
+	names := make(map[string]bool)
+	for _, dir := range conf.AllDirectories() {
+		if names[dir.Name] {
+			return fmt.Errorf("Duplicate directory name: %q", dir.Name)
+		}
+		names[dir.Name] = true
+		if err := dir.load(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TelemetryConfig contains the settings of the anonymous telemetry
+// of a directory (see Directory.ReportObservations()): the addresses
+// of the auditors to which the client reports its verified STR, and
+// the interval in seconds between two reports, which defaults to
+// DefaultReportInterval. The client only sends the reports if its
+// user opts in, e.g., with the --telemetry flag of coniksclient run.
+type TelemetryConfig struct {
+	Auditors []string           `toml:"auditors"`
+	Interval protocol.Timestamp `toml:"interval,omitempty"`
+}
+
+// validate checks that the telemetry settings specify at least one
+// auditor.
+func (tc *TelemetryConfig) validate() error {
+	if len(tc.Auditors) == 0 {
+		return fmt.Errorf("The telemetry requires at least one auditor")
+	}
+	return nil
+}
+
+// load reads the directory's signing public-key, initial STR and
+// checkpoints at the paths specified in the given config file, and
+// validates the checkpoints, the directory's strict mode and telemetry
+// settings, if any, and its message encoding.
+func (dir *DirectoryConfig) load(file string) error {
+	if dir.Strict != nil {
+		if err := dir.Strict.validate(); err != nil {
+			return err
+		}
+	}
+	if dir.Telemetry != nil {
+		if err := dir.Telemetry.validate(); err != nil {
+			return err
+		}
+	}
+	if err := dir.Resolution.Validate(); err != nil {
+		return err
+	}
+	if err := application.ValidateEncoding(dir.Encoding); err != nil {
+		return err
+	}
 
 	// load signing key
-	signPubKey, err := application.LoadSigningPubKey(conf.SignPubkeyPath, file)
+	signPubKey, err := application.LoadSigningPubKey(dir.SignPubkeyPath, file)
 	if err != nil {
 		return err
 	}
-	conf.SigningPubKey = signPubKey
+	dir.SigningPubKey = signPubKey
 
 	// load initial STR
-	initSTR, err := application.LoadInitSTR(conf.InitSTRPath, file)
+	initSTR, err := application.LoadInitSTR(dir.InitSTRPath, file)
 	if err != nil {
 		return err
 	}
-	conf.InitSTR = initSTR
+	dir.InitSTR = initSTR
+
+	// load checkpoints
+	dir.Checkpoints = nil
+	for _, path := range dir.CheckpointPaths {
+		str, err := application.LoadSTR(path, file)
+		if err != nil {
+			return err
+		}
+		dir.Checkpoints = append(dir.Checkpoints, str)
+	}
+	if err := client.New(dir.InitSTR, true, dir.SigningPubKey).
+		PinCheckpoints(dir.Checkpoints...); err != nil {
+		return fmt.Errorf("Invalid checkpoints of directory %q: %v", dir.Name, err)
+	}
+
+	if dir.StatePath != "" {
+		dir.statePath = utils.ResolvePath(dir.StatePath, file)
+	}
+
+	if dir.Rekor != nil {
+		if err := dir.Rekor.Load(file); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// AllDirectories returns the configuration of the default directory,
+// followed by the configuration of all additional directories.
+func (conf *Config) AllDirectories() []*DirectoryConfig {
+	return append([]*DirectoryConfig{&conf.DirectoryConfig}, conf.Directories...)
+}
+
 // Save writes a client's configuration.
 func (conf *Config) Save() error {
 	return conf.GetLoader().Encode(conf)
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+const testConfig = `
+sign_pubkey_path = "sign.pub"
+init_str_path = "init.str"
+address = "tcp://127.0.0.1:3000"
+
+[[directories]]
+name = "work"
+sign_pubkey_path = "sign.pub"
+init_str_path = "init.str"
+registration_address = "tcp://127.0.0.1:4001"
+address = "tcp://127.0.0.1:4000"
+`
+
+func withTestConfig(t *testing.T, conf string, f func(file string)) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	if err := utils.WriteFile(path.Join(dir, "sign.pub"), pk, 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := directory.NewTestDirectory(t)
+	if err := application.SaveSTR(path.Join(dir, "init.str"), d.LatestSTR()); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, "config.toml")
+	if err := utils.WriteFile(file, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f(file)
+}
+
+func TestLoadMultipleDirectories(t *testing.T) {
+	withTestConfig(t, testConfig, func(file string) {
+		conf := &Config{}
+		if err := conf.Load(file, "toml"); err != nil {
+			t.Fatal(err)
+		}
+		dirs := NewDirectories(conf)
+		if len(dirs) != 2 {
+			t.Fatal("Expect", 2, "directories, got", len(dirs))
+		}
+		def, ok := dirs[DefaultDirectoryName]
+		if !ok || def.Address != "tcp://127.0.0.1:3000" || def.CC == nil {
+			t.Fatal("Unexpected default directory")
+		}
+		work, ok := dirs["work"]
+		if !ok || work.RegAddress != "tcp://127.0.0.1:4001" ||
+			work.InitSTR == nil || work.SigningPubKey == nil {
+			t.Fatal("Unexpected directory", "work")
+		}
+		// each directory has its own consistency state
+		if work.CC == def.CC {
+			t.Fatal("Expect separate consistency checks per directory")
+		}
+	})
+}
+
+func TestLoadDuplicateDirectories(t *testing.T) {
+	withTestConfig(t, testConfig+`
+[[directories]]
+name = "work"
+sign_pubkey_path = "sign.pub"
+init_str_path = "init.str"
+address = "tcp://127.0.0.1:5000"
+`, func(file string) {
+		conf := &Config{}
+		if err := conf.Load(file, "toml"); err == nil {
+			t.Fatal("Expect an error for duplicate directory names")
+		}
+	})
+}
+
+func TestLoadStrictMode(t *testing.T) {
+	withTestConfig(t, testConfig+`
+[directories.strict]
+auditors = ["tcp://127.0.0.1:6000"]
+confirmation_timeout = 30
+fallback = "accept"
+`, func(file string) {
+		conf := &Config{}
+		if err := conf.Load(file, "toml"); err != nil {
+			t.Fatal(err)
+		}
+		if conf.Strict != nil {
+			t.Fatal("Expect the strict mode to be disabled for the default directory")
+		}
+		strict := conf.Directories[0].Strict
+		if strict == nil || len(strict.Auditors) != 1 {
+			t.Fatal("Expect the strict mode settings of", "work")
+		}
+		mode := strict.Mode()
+		if mode.Timeout != 30*time.Second || mode.Fallback != client.AcceptUnconfirmed {
+			t.Fatal("Unexpected strict mode", mode)
+		}
+	})
+}
+
+func TestLoadInvalidStrictMode(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		strict string
+	}{
+		{"no auditors", "confirmation_timeout = 30"},
+		{"no timeout", `auditors = ["tcp://127.0.0.1:6000"]`},
+		{"unknown fallback", `auditors = ["tcp://127.0.0.1:6000"]
+confirmation_timeout = 30
+fallback = "retry"`},
+	} {
+		withTestConfig(t, testConfig+"\n[strict]\n"+tc.strict+"\n", func(file string) {
+			conf := &Config{}
+			if err := conf.Load(file, "toml"); err == nil {
+				t.Error(tc.name, "expect an error")
+			}
+		})
+	}
+}
+
+func TestLoadCheckpoints(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	d.Update()
+	root := *d.LatestSTR().SignedTreeRoot
+	root.Signature = append([]byte{}, root.Signature...)
+	root.Signature[0] ^= 1
+	forged := protocol.DirSTR{SignedTreeRoot: &root, Policies: d.LatestSTR().Policies}
+	for _, tc := range []struct {
+		name string
+		str  *protocol.DirSTR
+		ok   bool
+	}{
+		{"valid", d.LatestSTR(), true},
+		{"forged", &forged, false},
+	} {
+		withTestConfig(t, `checkpoint_paths = ["cp.str"]`+testConfig, func(file string) {
+			cp := path.Join(path.Dir(file), "cp.str")
+			if err := application.SaveSTR(cp, tc.str); err != nil {
+				t.Fatal(err)
+			}
+			conf := &Config{}
+			err := conf.Load(file, "toml")
+			if (err == nil) != tc.ok {
+				t.Fatal(tc.name, "expect valid", tc.ok, "got", err)
+			}
+			if err != nil {
+				return
+			}
+			dirs := NewDirectories(conf)
+			if str := dirs[DefaultDirectoryName].CC.LatestCheckpoint(); str == nil ||
+				str.Epoch != 2 {
+				t.Fatal(tc.name, "expect the checkpoint of epoch", 2, "got", str)
+			}
+			if str := dirs["work"].CC.LatestCheckpoint(); str != nil {
+				t.Fatal(tc.name, "expect no checkpoint for", "work")
+			}
+		})
+	}
+}
+
+func TestDirectoryState(t *testing.T) {
+	withTestConfig(t, "state_path = \"client.state\"\n"+testConfig, func(file string) {
+		conf := &Config{}
+		if err := conf.Load(file, "toml"); err != nil {
+			t.Fatal(err)
+		}
+		dirs := NewDirectories(conf)
+		// there is no saved state yet
+		if err := dirs.LoadStates(); err != nil {
+			t.Fatal(err)
+		}
+		def := dirs[DefaultDirectoryName]
+		if err := def.SaveState(); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirs["work"].SaveState(); err != nil {
+			t.Fatal(err)
+		}
+		statePath := path.Join(path.Dir(file), "client.state")
+		if _, err := os.Stat(statePath); err != nil {
+			t.Fatal(err)
+		}
+
+		cc := def.CC
+		if err := dirs.LoadStates(); err != nil {
+			t.Fatal(err)
+		}
+		if def.CC == cc || def.CC.VerifiedSTR().Epoch != cc.VerifiedSTR().Epoch {
+			t.Fatal("Expect the saved state to be restored")
+		}
+
+		if err := ioutil.WriteFile(statePath, []byte("corrupted"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirs.LoadStates(); err == nil {
+			t.Fatal("Expect an error for a corrupted state")
+		}
+	})
+}
+
+func TestDirectoryWitness(t *testing.T) {
+	log := rekor.NewTestLog(t)
+	dir, err := ioutil.TempDir("", "rekor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url, keyPath, stop := testutil.NewRekorServer(t, log, dir)
+	defer stop()
+
+	conf := strings.Replace(testConfig, "[[directories]]", fmt.Sprintf(
+		"[rekor]\nurl = %q\npublic_key_path = %q\n\n[[directories]]", url, keyPath), 1)
+	withTestConfig(t, conf, func(file string) {
+		conf := &Config{}
+		if err := conf.Load(file, "toml"); err != nil {
+			t.Fatal(err)
+		}
+		dirs := NewDirectories(conf)
+		// without a Rekor log, the STR isn't looked up
+		if e, err := dirs["work"].Witness(); e != nil || err != nil {
+			t.Fatal("Expect", nil, "got", err)
+		}
+		def := dirs[DefaultDirectoryName]
+		if _, err := def.Witness(); err != application.ErrNotRecorded {
+			t.Fatal("Expect", application.ErrNotRecorded, "got", err)
+		}
+		published, err := application.NewRekorClient(def.Rekor).Publish(
+			def.CC.VerifiedSTR(), def.SigningPubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := def.Witness()
+		if err != nil {
+			t.Fatal("Expect", nil, "got", err)
+		}
+		if e.LogIndex != published.LogIndex {
+			t.Fatal("Expect", published.LogIndex, "got", e.LogIndex)
+		}
+	})
+}
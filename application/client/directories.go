@@ -0,0 +1,157 @@
+package client
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A Directory is a client's context for a single CONIKS directory:
+// the directory's configuration, including its pinned signing key,
+// and the client's consistency state, i.e. the latest verified STR
+// and the bindings verified in this directory.
+type Directory struct {
+	*DirectoryConfig
+	CC *client.ConsistencyChecks
+}
+
+// Directories contains a client's context for each of its configured
+// directories, indexed by the directory's name.
+type Directories map[string]*Directory
+
+// NewDirectories creates a new context for each directory in conf.
+// Each context is initialized with the directory's pinned signing key,
+// initial STR and checkpoints, and the directory's strict mode
+// settings, if any. The consistency states saved in previous runs can
+// then be restored with LoadStates().
+// NewDirectories() panics if the checkpoints of a directory are invalid,
+// which Config.Load() rules out.
+func NewDirectories(conf *Config) Directories {
+	dirs := make(Directories)
+	for _, dir := range conf.AllDirectories() {
+		d := &Directory{DirectoryConfig: dir}
+		if err := d.setCC(client.New(dir.InitSTR, true, dir.SigningPubKey)); err != nil {
+			panic(err)
+		}
+		dirs[dir.Name] = d
+	}
+	return dirs
+}
+
+// setCC sets the consistency state of the directory to cc,
+// with the directory's strict mode settings, checkpoints and auditors.
+// It returns the error of pinning the checkpoints, e.g., if cc's
+// verified STR conflicts with a checkpoint, in which case the
+// consistency state of the directory is left unchanged.
+func (d *Directory) setCC(cc *client.ConsistencyChecks) error {
+	if err := cc.PinCheckpoints(d.Checkpoints...); err != nil {
+		return err
+	}
+	if d.Strict != nil {
+		cc.SetStrictMode(d.Strict.Mode())
+	}
+	if len(d.Auditors) > 0 {
+		cc.SetDirectoryIdentity(auditor.ComputeDirectoryIdentity(d.InitSTR))
+		cc.SetAuditors(d.audit, d.Auditors...)
+	}
+	d.CC = cc
+	return nil
+}
+
+// audit sends the auditing request req to the auditor at addr, and
+// returns the auditor's response.
+func (d *Directory) audit(addr string, req *protocol.AuditingRequest) (*protocol.Response, error) {
+	msg, err := application.MarshalRequest(protocol.AuditType, req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := SendRequest(msg, addr, d.Resolution)
+	if err != nil {
+		return nil, err
+	}
+	return application.UnmarshalResponse(protocol.AuditType, res), nil
+}
+
+// SendRequest sends req to the address addr, resolving its host name
+// under the given policy, and returns the response
+// (see application.SendRequest()).
+func SendRequest(req []byte, addr string, policy *utils.ResolutionPolicy) ([]byte, error) {
+	return application.SendRequest(req, addr, nil, policy)
+}
+
+// LoadState restores the consistency state of the directory saved at
+// its StatePath, if any, so that the client verifies the directory's
+// responses against the latest STR and bindings it verified before it
+// was restarted, rather than against the initial STR.
+// It returns an error if the saved state can't be restored (see
+// client.LoadState()), or if its verified STR conflicts with one of
+// the directory's checkpoints.
+func (d *Directory) LoadState() error {
+	if d.statePath == "" {
+		return nil
+	}
+	cc, err := client.LoadState(d.statePath, true, d.SigningPubKey)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return d.setCC(cc)
+}
+
+// SaveState saves the consistency state of the directory at its
+// StatePath, if any.
+func (d *Directory) SaveState() error {
+	if d.statePath == "" {
+		return nil
+	}
+	return client.SaveState(d.statePath, d.CC)
+}
+
+// Witness looks up the client's verified STR for the directory in the
+// Rekor log in which the directory publishes its STRs, and returns the
+// log's entry for it, with the verified proof of its inclusion in the
+// log (see application.RekorClient.Lookup()). The log is thus a witness,
+// independent of the directory and of its auditors, that the directory
+// has committed to this STR publicly; an application.ErrNotRecorded
+// indicates that the directory may present different views of its
+// history. Witness() returns nil if the directory has no Rekor log.
+func (d *Directory) Witness() (*rekor.Entry, error) {
+	if d.Rekor == nil {
+		return nil, nil
+	}
+	return application.NewRekorClient(d.Rekor).Lookup(d.CC.VerifiedSTR(),
+		d.SigningPubKey)
+}
+
+// LoadStates restores the saved consistency state of each directory
+// (see Directory.LoadState()).
+func (dirs Directories) LoadStates() error {
+	for name, d := range dirs {
+		if err := d.LoadState(); err != nil {
+			return fmt.Errorf("Couldn't restore the state of directory %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ByIdentity returns the context of the directory whose identity, i.e.
+// the hash of its initial STR, is id, or nil if no configured directory
+// has this identity. Unlike its name, which is local to the client's
+// configuration, a directory's identity can be shared with other
+// clients, e.g., in a contact's profile (see client.MultiClient).
+func (dirs Directories) ByIdentity(id [crypto.HashSizeByte]byte) *Directory {
+	for _, d := range dirs {
+		if auditor.ComputeDirectoryIdentity(d.InitSTR) == id {
+			return d
+		}
+	}
+	return nil
+}
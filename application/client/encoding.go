@@ -2,6 +2,8 @@ package client
 
 import (
 	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
 )
 
@@ -15,11 +17,266 @@ func CreateRegistrationMsg(name string, key []byte) ([]byte, error) {
 		})
 }
 
+// CreateUnsignedKeyChangeRegistrationMsg returns a JSON encoding of
+// a protocol.RegistrationRequest for the given (name, key) pair, which
+// lets the user change the key later without signing the change
+// (see protocol.RegistrationRequest.AllowUnsignedKeychange), e.g.,
+// since key isn't a signing key.
+func CreateUnsignedKeyChangeRegistrationMsg(name string, key []byte) ([]byte, error) {
+	return application.MarshalRequest(protocol.RegistrationType,
+		&protocol.RegistrationRequest{
+			Username:               name,
+			Key:                    key,
+			AllowUnsignedKeychange: true,
+		})
+}
+
+// CreateIdentifierRegistrationMsg returns a JSON encoding of
+// a protocol.RegistrationRequest binding key to the typed identifier id,
+// e.g., a device ID or a service account.
+func CreateIdentifierRegistrationMsg(id protocol.Identifier, key []byte) ([]byte, error) {
+	return CreateRegistrationMsg(id.String(), key)
+}
+
+// CreateAttestedRegistrationMsg returns a JSON encoding of
+// a protocol.RegistrationRequest for the given (name, key) pair,
+// which includes the attestation a client has obtained from an account
+// verification bot running in detached mode.
+func CreateAttestedRegistrationMsg(name string, key []byte,
+	attestation *protocol.RegistrationAttestation) ([]byte, error) {
+	return application.MarshalRequest(protocol.RegistrationType,
+		&protocol.RegistrationRequest{
+			Username:    name,
+			Key:         key,
+			Attestation: attestation,
+		})
+}
+
+// CreateAttestationMsg returns a JSON encoding of
+// a protocol.AttestationRequest for the given name.
+func CreateAttestationMsg(name string) ([]byte, error) {
+	return application.MarshalRequest(protocol.AttestationType,
+		&protocol.AttestationRequest{
+			Username: name,
+		})
+}
+
+// CreateKeyChangeMsg returns a JSON encoding of
+// a protocol.KeyChangeRequest for the given (name, new key) pair.
+func CreateKeyChangeMsg(name string, key []byte) ([]byte, error) {
+	return application.MarshalRequest(protocol.KeyChangeType,
+		&protocol.KeyChangeRequest{
+			Username: name,
+			Key:      key,
+		})
+}
+
+// CreateSignedKeyChangeMsg returns a JSON encoding of
+// a protocol.KeyChangeRequest changing the key bound to name from
+// previous to key, signed with signKey, the private key corresponding
+// to previous (see protocol.NewKeyChangeRequest()).
+func CreateSignedKeyChangeMsg(signKey sign.PrivateKey, name string,
+	previous, key []byte) ([]byte, error) {
+	return application.MarshalRequest(protocol.KeyChangeType,
+		protocol.NewKeyChangeRequest(signKey, name, previous, key))
+}
+
+// CreateDeactivationMsg returns a JSON encoding of
+// a protocol.DeactivationRequest for the given name.
+func CreateDeactivationMsg(name string) ([]byte, error) {
+	return application.MarshalRequest(protocol.DeactivationType,
+		&protocol.DeactivationRequest{
+			Username: name,
+		})
+}
+
+// CreateSignedDeactivationMsg returns a JSON encoding of
+// a protocol.DeactivationRequest deactivating the binding of name to
+// key, signed with signKey, the private key corresponding to key
+// (see protocol.NewDeactivationRequest()).
+func CreateSignedDeactivationMsg(signKey sign.PrivateKey, name string,
+	key []byte) ([]byte, error) {
+	return application.MarshalRequest(protocol.DeactivationType,
+		protocol.NewDeactivationRequest(signKey, name, key))
+}
+
+// CreateKeyChangeAbortMsg returns a JSON encoding of
+// a protocol.KeyChangeAbortRequest for the pending key change of name
+// promised by tb, signed with the private key signKey corresponding to
+// the previous key.
+func CreateKeyChangeAbortMsg(name string, tb *protocol.TemporaryBinding,
+	signKey sign.PrivateKey) ([]byte, error) {
+	return application.MarshalRequest(protocol.KeyChangeAbortType,
+		protocol.NewKeyChangeAbortRequest(signKey, name, tb))
+}
+
 // CreateKeyLookupMsg returns a JSON encoding of
 // a protocol.KeyLookupRequest for the given name.
 func CreateKeyLookupMsg(name string) ([]byte, error) {
 	return application.MarshalRequest(protocol.KeyLookupType,
-		&protocol.RegistrationRequest{
+		&protocol.KeyLookupRequest{
+			Username: name,
+		})
+}
+
+// CreateBatchKeyLookupMsg returns a JSON encoding of
+// a protocol.BatchKeyLookupRequest for the given names.
+func CreateBatchKeyLookupMsg(names []string) ([]byte, error) {
+	return application.MarshalRequest(protocol.BatchKeyLookupType,
+		&protocol.BatchKeyLookupRequest{
+			Usernames: names,
+		})
+}
+
+// CreateIdentifierKeyLookupMsg returns a JSON encoding of
+// a protocol.KeyLookupRequest for the typed identifier id.
+func CreateIdentifierKeyLookupMsg(id protocol.Identifier) ([]byte, error) {
+	return CreateKeyLookupMsg(id.String())
+}
+
+// CreateKeyLookupInEpochMsg returns a JSON encoding of
+// a protocol.KeyLookupInEpochRequest for the given name and past epoch.
+func CreateKeyLookupInEpochMsg(name string, epoch uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.KeyLookupInEpochType,
+		&protocol.KeyLookupInEpochRequest{
 			Username: name,
+			Epoch:    epoch,
+		})
+}
+
+// CreateMonitoringMsg returns a JSON encoding of
+// a protocol.MonitoringRequest for the given name and epoch range.
+// knownEp is the latest epoch for which the client has already
+// verified the directory's STR, or 0.
+func CreateMonitoringMsg(name string, startEp, endEp, knownEp uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.MonitoringType,
+		&protocol.MonitoringRequest{
+			Username:   name,
+			StartEpoch: startEp,
+			EndEpoch:   endEp,
+			KnownEpoch: knownEp,
+		})
+}
+
+// CreateKeyHistoryMsg returns a JSON encoding of
+// a protocol.KeyHistoryRequest for the given name and epoch range.
+func CreateKeyHistoryMsg(name string, startEp, endEp uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.KeyHistoryType,
+		&protocol.KeyHistoryRequest{
+			Username:   name,
+			StartEpoch: startEp,
+			EndEpoch:   endEp,
+		})
+}
+
+// CreateSTRHistoryMsg returns a JSON encoding of
+// a protocol.STRHistoryRequest for the given epoch range.
+func CreateSTRHistoryMsg(startEp, endEp uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.STRType,
+		&protocol.STRHistoryRequest{
+			StartEpoch: startEp,
+			EndEpoch:   endEp,
+		})
+}
+
+// CreateLatestSTRHistoryMsg returns a JSON encoding of
+// a protocol.STRHistoryRequest for the epoch range from startEp
+// up to the directory's latest epoch.
+func CreateLatestSTRHistoryMsg(startEp uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.STRType,
+		&protocol.STRHistoryRequest{
+			StartEpoch: startEp,
+			Latest:     true,
+		})
+}
+
+// CreateAuditingMsg returns a JSON encoding of
+// a protocol.AuditingRequest for the STRs which the directory
+// identified by dirInitHash has issued in the given epoch range.
+func CreateAuditingMsg(dirInitHash [crypto.HashSizeByte]byte, startEp, endEp uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.AuditType,
+		&protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			StartEpoch:     startEp,
+			EndEpoch:       endEp,
+		})
+}
+
+// CreatePoliciesMsg returns a JSON encoding of
+// a protocol.PoliciesRequest.
+func CreatePoliciesMsg() ([]byte, error) {
+	return application.MarshalRequest(protocol.PoliciesType,
+		&protocol.PoliciesRequest{})
+}
+
+// CreateEmptyRangeMsg returns a JSON encoding of
+// a protocol.EmptyRangeRequest for the range of lookup indices starting
+// with the first bits bits of prefix, at the given epoch.
+func CreateEmptyRangeMsg(prefix []byte, bits uint32, epoch uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.EmptyRangeType,
+		&protocol.EmptyRangeRequest{
+			Prefix:     prefix,
+			PrefixBits: bits,
+			Epoch:      epoch,
+		})
+}
+
+// CreateSubtreeMsg returns a JSON encoding of a protocol.SubtreeRequest
+// for the subtree of the namespace of provider, at the given epoch.
+func CreateSubtreeMsg(provider string, epoch uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.SubtreeType,
+		&protocol.SubtreeRequest{
+			Provider: provider,
+			Epoch:    epoch,
+		})
+}
+
+// CreateSampleMsg returns a JSON encoding of a protocol.SampleRequest
+// for the authentication paths at the given lookup indices,
+// at the given epoch.
+func CreateSampleMsg(indices [][]byte, epoch uint64) ([]byte, error) {
+	return application.MarshalRequest(protocol.SampleType,
+		&protocol.SampleRequest{
+			Indices: indices,
+			Epoch:   epoch,
 		})
 }
+
+// CreateEpochDeltaMsg returns a JSON encoding of
+// a protocol.EpochDeltaRequest for the leaves changed in the given
+// epoch, starting at the index start, which is nil for the first
+// request of the epoch, and the NextIndex of the previous response
+// otherwise.
+func CreateEpochDeltaMsg(epoch uint64, start []byte) ([]byte, error) {
+	return application.MarshalRequest(protocol.EpochDeltaType,
+		&protocol.EpochDeltaRequest{
+			Epoch:      epoch,
+			StartIndex: start,
+		})
+}
+
+// CreateObservationReportMsg returns a JSON encoding of
+// the given protocol.ObservationReport, which an opted-in client
+// sends to a CONIKS auditor.
+func CreateObservationReportMsg(report *protocol.ObservationReport) ([]byte, error) {
+	return application.MarshalRequest(protocol.ObservationReportType, report)
+}
+
+// EncodeRequest encodes the JSON-encoded request msg, e.g., created by
+// CreateKeyLookupMsg(), in the message encoding of the directory dir.
+func (dir *DirectoryConfig) EncodeRequest(msg []byte) ([]byte, error) {
+	if dir.Encoding != application.ProtobufEncoding {
+		return msg, nil
+	}
+	req, err := application.UnmarshalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	return application.MarshalRequestPB(req.Type, req.Request)
+}
+
+// DecodeResponse decodes the response msg of the directory dir to the
+// request type t in the directory's message encoding.
+func (dir *DirectoryConfig) DecodeResponse(t int, msg []byte) *protocol.Response {
+	return application.UnmarshalResponseWith(dir.Encoding, t, msg)
+}
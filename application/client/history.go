@@ -0,0 +1,50 @@
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// MaxSTRsPerFetch is the maximum number of STRs FetchSTRHistory()
+// requests from a directory at once, so that the response fits in
+// a single message.
+const MaxSTRsPerFetch = 8
+
+// FetchSTRHistory fetches the STRs a directory has issued since the
+// epoch returned by latest, in ranges of at most MaxSTRsPerFetch STRs
+// sent with send, e.g., for an auditor or a mirror to catch up with
+// the directory. Each response is passed to handle, which verifies the
+// STRs it includes and records them, so that latest returns the epoch
+// of the last of them.
+// FetchSTRHistory() returns the first error returned by send or
+// handle, if any, a protocol.ErrMalformedMessage if a response doesn't
+// include an STR history range, and nil once the directory has no
+// more STRs to send.
+func FetchSTRHistory(latest func() uint64,
+	send func(msg []byte) ([]byte, error),
+	handle func(res *protocol.Response) error) error {
+	for {
+		ep := latest()
+		msg, err := CreateSTRHistoryMsg(ep, ep+MaxSTRsPerFetch)
+		if err != nil {
+			return err
+		}
+		res, err := send(msg)
+		if err != nil {
+			return err
+		}
+		response := application.UnmarshalResponse(protocol.STRType, res)
+		if err := handle(response); err != nil {
+			return err
+		}
+		rng, ok := response.DirectoryResponse.(*protocol.STRHistoryRange)
+		if !ok {
+			return protocol.ErrMalformedMessage
+		}
+		// the directory cuts the range short if it doesn't fit
+		// in a single response
+		if rng.Continuation == nil && len(rng.STR) <= MaxSTRsPerFetch {
+			return nil
+		}
+	}
+}
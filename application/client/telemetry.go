@@ -0,0 +1,93 @@
+// Implements the client side of the opt-in anonymous telemetry, in
+// which a client periodically reports its verified STR for a directory
+// to the auditors of the directory's telemetry settings.
+
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// DefaultReportInterval is the interval at which a client reports its
+// verified STR for a directory whose telemetry settings don't specify
+// one.
+const DefaultReportInterval = time.Hour
+
+// ReportObservations sends an anonymous report of the client's
+// verified STR for the directory (see
+// client.ConsistencyChecks.NewObservationReport()) to each auditor of
+// the directory's telemetry settings at the telemetry interval, until
+// stop is closed. A report is only sent once the client has verified
+// an STR of a new epoch, since the auditors drop the duplicate reports.
+// The secret from which the reports' reporter tags are derived is
+// generated for each call, so it never outlives the client's process.
+// onError, if not nil, is called with the error of each report which
+// couldn't be sent to the auditor at addr.
+//
+// ReportObservations() returns immediately if the directory has no
+// telemetry settings, and returns an error if the secret can't be
+// generated.
+func (d *Directory) ReportObservations(clock utils.Clock, stop <-chan struct{},
+	onError func(addr string, err error)) error {
+	if d.Telemetry == nil {
+		return nil
+	}
+	secret, err := crypto.MakeRand()
+	if err != nil {
+		return err
+	}
+	interval := time.Duration(d.Telemetry.Interval) * time.Second
+	if interval == 0 {
+		interval = DefaultReportInterval
+	}
+	dirInitHash := auditor.ComputeDirectoryIdentity(d.InitSTR)
+	reported := false
+	var epoch uint64
+	timer := clock.NewTimer(interval)
+	for {
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C():
+			report := d.CC.NewObservationReport(dirInitHash, secret)
+			if !reported || report.Epoch != epoch {
+				for _, addr := range d.Telemetry.Auditors {
+					if err := d.report(addr, report); err != nil && onError != nil {
+						onError(addr, err)
+					}
+				}
+				reported, epoch = true, report.Epoch
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+// report sends the observation report to the auditor at addr, and
+// returns the auditor's error, if any.
+func (d *Directory) report(addr string, report *protocol.ObservationReport) error {
+	msg, err := CreateObservationReportMsg(report)
+	if err != nil {
+		return err
+	}
+	res, err := SendRequest(msg, addr, d.Resolution)
+	if err != nil {
+		return err
+	}
+	// the auditor's response only includes its error code
+	var r struct{ Error protocol.ErrorCode }
+	if err := json.Unmarshal(res, &r); err != nil {
+		return protocol.ErrMalformedMessage
+	}
+	if r.Error != protocol.ReqSuccess {
+		return r.Error
+	}
+	return nil
+}
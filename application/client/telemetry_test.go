@@ -0,0 +1,96 @@
+package client
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestReportObservations(t *testing.T) {
+	ln, err := utils.ListenMem("telemetrytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	reports := make(chan *protocol.ObservationReport, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf, _ := ioutil.ReadAll(conn)
+			req, err := application.UnmarshalRequest(buf)
+			if err == nil {
+				reports <- req.Request.(*protocol.ObservationReport)
+			}
+			res, _ := application.MarshalResponse(protocol.NewErrorResponse(protocol.ReqSuccess))
+			conn.Write(res)
+			conn.Close()
+		}
+	}()
+
+	withTestConfig(t, testConfig+`
+[telemetry]
+auditors = ["mem://telemetrytest"]
+interval = 60
+`, func(file string) {
+		conf := &Config{}
+		if err := conf.Load(file, "toml"); err != nil {
+			t.Fatal(err)
+		}
+		dirs := NewDirectories(conf)
+		clock := utils.NewFakeClock(time.Now())
+		stop := make(chan struct{})
+		done := make(chan error)
+		for _, d := range dirs {
+			d := d
+			go func() {
+				done <- d.ReportObservations(clock, stop, func(addr string, err error) {
+					t.Error(addr, err)
+				})
+			}()
+		}
+
+		// only the opted-in directory reports its verified STR
+		var report *protocol.ObservationReport
+		for report == nil {
+			clock.Advance(time.Minute)
+			select {
+			case report = <-reports:
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		def := dirs[DefaultDirectoryName]
+		if report.DirInitSTRHash != auditor.ComputeDirectoryIdentity(def.InitSTR) ||
+			report.Epoch != 0 ||
+			report.STRHash != digest(def.CC.VerifiedSTR().Signature) {
+			t.Fatal("Unexpected report", report)
+		}
+		// and doesn't report the same epoch again
+		clock.Advance(time.Minute)
+		select {
+		case report = <-reports:
+			t.Fatal("Unexpected report", report)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		close(stop)
+		for range dirs {
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func digest(b []byte) (h [crypto.HashSizeByte]byte) {
+	copy(h[:], crypto.Digest(b))
+	return
+}
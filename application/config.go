@@ -25,11 +25,16 @@ type AppConfig interface {
 // client etc.). It contains some common configuration
 // values including the file path, logger configuration, and config
 // loader.
+// RateLimits optionally limits the rate of the requests a server
+// handles (see RateLimits), and Webhooks optionally lists the webhooks
+// a server notifies of its events (see Webhook).
 type CommonConfig struct {
-	Path     string
-	Logger   *LoggerConfig `toml:"logger"`
-	Encoding string
-	loader   ConfigLoader
+	Path       string
+	Logger     *LoggerConfig `toml:"logger"`
+	RateLimits *RateLimits   `toml:"rate_limits,omitempty"`
+	Webhooks   []*Webhook    `toml:"webhooks,omitempty"`
+	Encoding   string
+	loader     ConfigLoader
 }
 
 // NewCommonConfig initializes an application's config file path,
@@ -71,14 +76,9 @@ func LoadSigningPubKey(path, file string) (sign.PublicKey, error) {
 // If there is any parsing error or the STR is malformed,
 // LoadInitSTR() returns an error with a nil STR.
 func LoadInitSTR(path, file string) (*protocol.DirSTR, error) {
-	initSTRPath := utils.ResolvePath(path, file)
-	initSTRBytes, err := ioutil.ReadFile(initSTRPath)
+	initSTR, err := LoadSTR(path, file)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot read init STR: %v", err)
-	}
-	initSTR := new(protocol.DirSTR)
-	if err := json.Unmarshal(initSTRBytes, &initSTR); err != nil {
-		return nil, fmt.Errorf("Cannot parse initial STR: %v", err)
+		return nil, err
 	}
 	if initSTR.Epoch != 0 {
 		return nil, fmt.Errorf("Initial STR epoch must be 0 (got %d)", initSTR.Epoch)
@@ -86,6 +86,53 @@ func LoadInitSTR(path, file string) (*protocol.DirSTR, error) {
 	return initSTR, nil
 }
 
+// LoadSTR loads an STR of any epoch, e.g. a checkpoint, at the given
+// path specified in the given config file.
+// If there is any parsing error, LoadSTR() returns an error with
+// a nil STR. The STR's signature isn't verified.
+func LoadSTR(path, file string) (*protocol.DirSTR, error) {
+	strPath := utils.ResolvePath(path, file)
+	strBytes, err := ioutil.ReadFile(strPath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read STR: %v", err)
+	}
+	str := new(protocol.DirSTR)
+	if err := json.Unmarshal(strBytes, &str); err != nil {
+		return nil, fmt.Errorf("Cannot parse STR: %v", err)
+	}
+	if str == nil || str.SignedTreeRoot == nil {
+		return nil, fmt.Errorf("Cannot parse STR: %s", strPath)
+	}
+	return str, nil
+}
+
+// LoadBootstrapSeed loads a bootstrap seed at the given path
+// specified in the given config file.
+// If there is any parsing error, LoadBootstrapSeed() returns an error
+// with a nil seed. The seed's signature isn't verified.
+func LoadBootstrapSeed(path, file string) (*protocol.BootstrapSeed, error) {
+	seedPath := utils.ResolvePath(path, file)
+	seedBytes, err := ioutil.ReadFile(seedPath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read bootstrap seed: %v", err)
+	}
+	seed := new(protocol.BootstrapSeed)
+	if err := json.Unmarshal(seedBytes, seed); err != nil {
+		return nil, fmt.Errorf("Cannot parse bootstrap seed: %v", err)
+	}
+	return seed, nil
+}
+
+// SaveBootstrapSeed serializes the given bootstrap seed
+// to the given file.
+func SaveBootstrapSeed(file string, seed *protocol.BootstrapSeed) error {
+	seedBytes, err := json.Marshal(seed)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFile(file, seedBytes, 0600)
+}
+
 // SaveSTR serializes the given STR to the given file.
 func SaveSTR(file string, str *protocol.DirSTR) error {
 	strBytes, err := json.Marshal(str)
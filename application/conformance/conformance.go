@@ -0,0 +1,366 @@
+package conformance
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+)
+
+var (
+	// ErrTimeout indicates that the target didn't reach the expected
+	// state within Target.Timeout.
+	ErrTimeout = errors.New("[conformance] Timed out waiting for the target")
+)
+
+// spec is the description of the protocol the suite checks the
+// target's responses against.
+var spec = application.NewProtocolSpec()
+
+// maxHistoryFetches bounds the number of partial responses the suite
+// follows when it fetches an STR history.
+const maxHistoryFetches = 1000
+
+// pollInterval is the interval between two lookups of the suite
+// while it waits for the target's next epoch.
+const pollInterval = 100 * time.Millisecond
+
+// A Target describes the CONIKS key server under test: Address is the
+// address of its public listener (e.g., "tcp://127.0.0.1:3000"), and
+// RegistrationAddress the address accepting registrations, which
+// defaults to Address. SigningKey is the server's pinned public
+// signing key. If InitSTR is set, the server's STR history must start
+// with it. Timeout bounds the time the suite waits for a registration
+// to be included in the tree, and defaults to two epoch deadlines of
+// the server.
+type Target struct {
+	Address             string
+	RegistrationAddress string
+	SigningKey          sign.PublicKey
+	InitSTR             *protocol.DirSTR
+	Timeout             time.Duration
+}
+
+// A scenario is a named check of the suite, which depends on the
+// success of the scenario named after, if any.
+type scenario struct {
+	name  string
+	after string
+	run   func(*runner) error
+}
+
+var scenarios = []scenario{
+	{"history", "", (*runner).history},
+	{"lookup-absent", "history", (*runner).lookupAbsent},
+	{"registration", "history", (*runner).registration},
+	{"duplicate", "registration", (*runner).duplicate},
+	{"lookup-pending", "registration", (*runner).lookupPending},
+	{"inclusion", "lookup-pending", (*runner).inclusion},
+	{"non-equivocation", "history", (*runner).nonEquivocation},
+}
+
+// A runner holds the state which the scenarios of a run share:
+// the STR history fetched first, the client's consistency state
+// pinned to the latest STR of this history, and the name and key
+// which the suite registers.
+type runner struct {
+	target *Target
+	send   func(addr string, msg []byte) ([]byte, error)
+	first  []*protocol.DirSTR
+	cc     *client.ConsistencyChecks
+	name   string
+	key    []byte
+	// the STR of the latest verified lookup or registration response
+	str *protocol.DirSTR
+}
+
+// Run runs the conformance suite against the target t,
+// and returns the report of the run.
+func Run(t *Target) *Report {
+	return run(t, send)
+}
+
+func run(t *Target, send func(string, []byte) ([]byte, error)) *Report {
+	r := &runner{
+		target: t,
+		send:   send,
+		name:   "conformance-" + randomHex(8),
+		key:    []byte(randomHex(16)),
+	}
+	report := &Report{Target: t.Address, Protocol: spec.Version}
+	passed := make(map[string]bool)
+	for _, s := range scenarios {
+		res := &Result{Name: s.name}
+		if s.after != "" && !passed[s.after] {
+			res.Skipped = true
+			res.Err = fmt.Errorf("depends on %s", s.after)
+		} else {
+			res.Err = s.run(r)
+			passed[s.name] = res.Err == nil
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+// send sends msg to the key server at addr.
+func send(addr string, msg []byte) ([]byte, error) {
+	return application.SendRequest(msg, addr, nil, nil)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (r *runner) registrationAddress() string {
+	if r.target.RegistrationAddress != "" {
+		return r.target.RegistrationAddress
+	}
+	return r.target.Address
+}
+
+// request sends msg to addr, and returns the response of type reqType.
+// The response's error code must be one of the error codes of the
+// protocol (see application.NewProtocolSpec()).
+func (r *runner) request(addr string, reqType int, msg []byte) (*protocol.Response, error) {
+	res, err := r.send(addr, msg)
+	if err != nil {
+		return nil, err
+	}
+	response := application.UnmarshalResponse(reqType, res)
+	if spec.ErrorCode(response.Error) == nil {
+		return nil, fmt.Errorf("undefined error code %d", response.Error)
+	}
+	return response, nil
+}
+
+// fetchHistory fetches the target's STR history since epoch 0,
+// following the continuations of the partial responses, and checks
+// that it is a hash chain of consecutive epochs signed with the
+// target's signing key, whose policy transitions match the STRs.
+func (r *runner) fetchHistory() ([]*protocol.DirSTR, error) {
+	var history []*protocol.DirSTR
+	start := uint64(0)
+	for i := 0; i < maxHistoryFetches; i++ {
+		msg, err := clientapp.CreateLatestSTRHistoryMsg(start)
+		if err != nil {
+			return nil, err
+		}
+		res, err := r.request(r.target.Address, protocol.STRType, msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := res.Validate(); err != nil {
+			return nil, err
+		}
+		rng := res.STRHistoryRange()
+		if rng.STR[0].Epoch != start {
+			return nil, fmt.Errorf("history starts at epoch %d instead of %d",
+				rng.STR[0].Epoch, start)
+		}
+		if err := rng.Verify(); err != nil {
+			return nil, err
+		}
+		history = append(history, rng.STR...)
+		if rng.Continuation == nil {
+			if err := r.verifyHistory(history); err != nil {
+				return nil, err
+			}
+			return history, nil
+		}
+		if rng.Continuation.NextEpoch <= start {
+			return nil, protocol.ErrMalformedMessage
+		}
+		start = rng.Continuation.NextEpoch
+	}
+	return nil, fmt.Errorf("history exceeds %d responses", maxHistoryFetches)
+}
+
+func (r *runner) verifyHistory(history []*protocol.DirSTR) error {
+	for i := 1; i < len(history); i++ {
+		if history[i].Epoch != history[i-1].Epoch+1 {
+			return fmt.Errorf("history skips epoch %d", history[i-1].Epoch+1)
+		}
+	}
+	if !r.target.SigningKey.Verify(history[0].Serialize(), history[0].Signature) {
+		return protocol.CheckBadSignature
+	}
+	return auditor.New(r.target.SigningKey, history[0]).
+		VerifySTRRange(history[0], history[1:])
+}
+
+func (r *runner) history() error {
+	history, err := r.fetchHistory()
+	if err != nil {
+		return err
+	}
+	if init := r.target.InitSTR; init != nil && !sameSTR(init, history[0]) {
+		return fmt.Errorf("history doesn't start with the initial STR")
+	}
+	latest := history[len(history)-1]
+	msg, err := clientapp.CreateSTRHistoryMsg(latest.Epoch+2, latest.Epoch+1)
+	if err != nil {
+		return err
+	}
+	res, err := r.request(r.target.Address, protocol.STRType, msg)
+	if err != nil {
+		return err
+	}
+	if res.Error != protocol.ErrMalformedMessage {
+		return fmt.Errorf("malformed STR history request returned %v", res.Error)
+	}
+	r.first = history
+	r.cc = client.New(latest, true, r.target.SigningKey)
+	return nil
+}
+
+// handle checks that res has the status code want, and verifies res
+// with the client's consistency checks. It returns the proof type of
+// the authentication path included in res.
+func (r *runner) handle(reqType int, res *protocol.Response,
+	want protocol.ErrorCode) (merkletree.ProofType, error) {
+	if res.Error != want {
+		return 0, fmt.Errorf("expect %v, got %v", want, res.Error)
+	}
+	if err := r.cc.HandleResponse(reqType, res, r.name, r.key); err != nil {
+		return 0, err
+	}
+	df := res.DirectoryProof()
+	r.str = df.STR[0]
+	return df.AP[0].ProofType(), nil
+}
+
+func (r *runner) lookup(want protocol.ErrorCode) (merkletree.ProofType, error) {
+	msg, err := clientapp.CreateKeyLookupMsg(r.name)
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.request(r.target.Address, protocol.KeyLookupType, msg)
+	if err != nil {
+		return 0, err
+	}
+	return r.handle(protocol.KeyLookupType, res, want)
+}
+
+func (r *runner) register(want protocol.ErrorCode) (*protocol.Response, error) {
+	msg, err := clientapp.CreateRegistrationMsg(r.name, r.key)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.request(r.registrationAddress(), protocol.RegistrationType, msg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.handle(protocol.RegistrationType, res, want); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *runner) lookupAbsent() error {
+	proofType, err := r.lookup(protocol.ReqNameNotFound)
+	if err != nil {
+		return err
+	}
+	if proofType != merkletree.ProofOfAbsence {
+		return protocol.ErrMalformedMessage
+	}
+	return nil
+}
+
+func (r *runner) registration() error {
+	res, err := r.register(protocol.ReqSuccess)
+	if err != nil {
+		return err
+	}
+	if res.DirectoryProof().TB == nil {
+		return fmt.Errorf("registration doesn't return a TB")
+	}
+	return nil
+}
+
+func (r *runner) duplicate() error {
+	_, err := r.register(protocol.ReqNameExisted)
+	return err
+}
+
+func (r *runner) lookupPending() error {
+	proofType, err := r.lookup(protocol.ReqSuccess)
+	if err != nil {
+		return err
+	}
+	if proofType != merkletree.ProofOfAbsence || r.cc.TB(r.name) == nil {
+		return fmt.Errorf("lookup doesn't return the TB")
+	}
+	return nil
+}
+
+// inclusion polls the target with lookups of the registered name,
+// each of which must verify, until the target returns a proof of
+// inclusion.
+func (r *runner) inclusion() error {
+	timeout := r.target.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Duration(r.str.Policies.EpochDeadline) * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		proofType, err := r.lookup(protocol.ReqSuccess)
+		if err != nil {
+			return err
+		}
+		if proofType == merkletree.ProofOfInclusion {
+			if r.cc.TB(r.name) != nil {
+				return protocol.CheckBrokenPromise
+			}
+			return nil
+		}
+		time.Sleep(pollInterval)
+	}
+	return ErrTimeout
+}
+
+// nonEquivocation fetches the target's STR history again, and checks
+// that it agrees with the history fetched first, and with the STR of
+// the latest verified response.
+func (r *runner) nonEquivocation() error {
+	history, err := r.fetchHistory()
+	if err != nil {
+		return err
+	}
+	views := [][]*protocol.DirSTR{r.first}
+	if r.str != nil {
+		views = append(views, []*protocol.DirSTR{r.str})
+	}
+	for _, view := range views {
+		e, err := auditor.FindFork(r.target.SigningKey, view, history)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			return fmt.Errorf("directory equivocates at epoch %d", e.Epoch)
+		}
+	}
+	return nil
+}
+
+// sameSTR returns true if the STRs a and b have the same contents and
+// signature.
+func sameSTR(a, b *protocol.DirSTR) bool {
+	return bytes.Equal(a.Signature, b.Signature) &&
+		bytes.Equal(a.Serialize(), b.Serialize())
+}
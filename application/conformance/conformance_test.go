@@ -0,0 +1,105 @@
+package conformance
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/server"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+)
+
+const testAddress = testutil.MemConnection
+
+// startServer runs a key server with an epoch deadline of one second,
+// which accepts registrations at testAddress.
+func startServer(t *testing.T) (sign.PublicKey, func()) {
+	dir, err := ioutil.TempDir("", "conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrfKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+	addrs := []*server.Address{
+		&server.Address{
+			ServerAddress:     &application.ServerAddress{Address: testAddress},
+			AllowRegistration: true,
+		},
+	}
+	conf := &server.Config{
+		CommonConfig: &application.CommonConfig{
+			Logger: &application.LoggerConfig{
+				Environment: "development",
+				Path:        path.Join(dir, "coniksserver.log"),
+			},
+		},
+		LoadedHistoryLength: 100,
+		Addresses:           addrs,
+		Policies:            server.NewPolicies(1, "", "", vrfKey, signKey),
+		EpochDeadline:       1,
+	}
+	s := server.NewConiksServer(conf)
+	s.Run(addrs)
+	return pk, func() {
+		s.Shutdown()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	pk, teardown := startServer(t)
+	defer teardown()
+
+	report := Run(&Target{Address: testAddress, SigningKey: pk})
+	var buf bytes.Buffer
+	if err := report.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed() {
+		t.Fatal(buf.String())
+	}
+	if len(report.Results) != len(scenarios) {
+		t.Fatal("Expect", len(scenarios), "results, got", len(report.Results))
+	}
+}
+
+func TestConformanceWrongKey(t *testing.T) {
+	_, teardown := startServer(t)
+	defer teardown()
+
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+	report := Run(&Target{Address: testAddress, SigningKey: pk})
+	if report.Passed() {
+		t.Fatal("Expect the run to fail")
+	}
+	var buf bytes.Buffer
+	if err := report.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"FAIL history",
+		"SKIP registration (depends on history)",
+		"0/7 scenarios passed",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatal("Expect", line, "in", buf.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Package conformance implements a black-box test suite which checks
+that a CONIKS key server interoperates with the coniks-go clients.
+
+The suite only talks to the server over the wire, through the JSON
+encoding of the application package, so it can be run against any
+implementation of a CONIKS directory. It verifies every response the
+way a coniks-go client does (see protocol/client), and produces
+a Report listing the result of each scenario.
+
+# Minimal verifier specification
+
+A conforming server must pass the following scenarios, which the
+suite runs in order against the server's public address, and the
+address accepting registrations:
+
+	history             The STR history request with the Latest flag
+	                    returns the signed, hash-chained STRs of
+	                    consecutive epochs since epoch 0, possibly split
+	                    into several responses with a continuation.
+	                    The policy transitions of each range match its
+	                    STRs, and a malformed range is rejected with
+	                    an ErrMalformedMessage.
+	lookup-absent       A lookup for an unregistered name returns
+	                    a ReqNameNotFound with a proof of absence.
+	registration        A registration of a fresh name returns
+	                    a ReqSuccess with a proof of absence and
+	                    a temporary binding (TB).
+	duplicate           A second registration of the same name returns
+	                    a ReqNameExisted.
+	lookup-pending      A lookup of the name returns its TB until the
+	                    next epoch.
+	inclusion           The name is included in the tree within two
+	                    epochs, and the STR of each epoch is consistent
+	                    with the previous one.
+	non-equivocation    Two fetches of the STR history agree on all
+	                    their common epochs, and with the STRs returned
+	                    in the lookup responses.
+
+A scenario depending on a failed scenario is skipped. The scenarios
+register a random name with a random key, so the server must accept
+unattested registrations on the registration address.
+*/
+package conformance
@@ -0,0 +1,61 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+)
+
+// A Result is the outcome of a scenario of the suite: the scenario
+// passed if Err is nil. If Skipped is set, the scenario wasn't run
+// since a scenario it depends on failed.
+type Result struct {
+	Name    string
+	Skipped bool
+	Err     error
+}
+
+// A Report lists the results of a run of the suite against the key
+// server at the address Target, for the version Protocol of the
+// protocol.
+type Report struct {
+	Target   string
+	Protocol string
+	Results  []*Result
+}
+
+// Passed returns whether all scenarios of the run passed.
+func (r *Report) Passed() bool {
+	for _, res := range r.Results {
+		if res.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes a human-readable summary of the report to w,
+// with one line per scenario.
+func (r *Report) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "CONIKS conformance report for %s (protocol %s)\n",
+		r.Target, r.Protocol); err != nil {
+		return err
+	}
+	passed := 0
+	for _, res := range r.Results {
+		var err error
+		switch {
+		case res.Skipped:
+			_, err = fmt.Fprintf(w, "SKIP %s (%v)\n", res.Name, res.Err)
+		case res.Err != nil:
+			_, err = fmt.Fprintf(w, "FAIL %s: %v\n", res.Name, res.Err)
+		default:
+			passed++
+			_, err = fmt.Fprintf(w, "PASS %s\n", res.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d/%d scenarios passed\n", passed, len(r.Results))
+	return err
+}
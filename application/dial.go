@@ -0,0 +1,90 @@
+// Implements the client side of the connections to a CONIKS server,
+// e.g., a client sending its requests to a key server, a key server
+// pushing its STRs to an auditor or a mirror syncing with its primary.
+
+package application
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// Dial connects to the CONIKS server listening at address, formatted
+// as a url (see utils.ParseAddress()), resolving its host name under
+// the policy p, which may be nil.
+// The TCP connections are secured with TLS: the server's certificate
+// is verified for the host of address against the root certificates
+// of conf, or the system's root certificates (which include the
+// certificates listed in SSL_CERT_FILE) if conf is nil. The Unix socket
+// and in-memory connections are local, and aren't encrypted.
+func Dial(address string, conf *tls.Config, p *utils.ResolutionPolicy) (net.Conn, error) {
+	network, addr, err := utils.ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := utils.Dial(address, p)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" || network == "mem" {
+		return conn, nil
+	}
+	if conf == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	if conf.ServerName == "" {
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return &tlsConn{Conn: tls.Client(conn, conf), raw: conn}, nil
+}
+
+// A tlsConn is a TLS connection whose CloseWrite() closes the sending
+// direction of the underlying connection, so that the server reads the
+// end of the request.
+type tlsConn struct {
+	*tls.Conn
+	raw net.Conn
+}
+
+func (c *tlsConn) CloseWrite() error {
+	if raw, ok := c.raw.(interface {
+		CloseWrite() error
+	}); ok {
+		return raw.CloseWrite()
+	}
+	return nil
+}
+
+// SendRequest sends msg to the CONIKS server listening at address
+// (see Dial()), closes the sending direction of the connection, and
+// returns the server's response.
+func SendRequest(msg []byte, address string, conf *tls.Config,
+	p *utils.ResolutionPolicy) ([]byte, error) {
+	conn, err := Dial(address, conf, p)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	if c, ok := conn.(interface {
+		CloseWrite() error
+	}); ok {
+		c.CloseWrite()
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, conn, protocol.MaxResponseSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package application
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestSendRequestVerifiesCertificate(t *testing.T) {
+	dir := t.TempDir()
+	if err := utils.CreateTLSCert(dir); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(path.Join(dir, "server.pem"), path.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			msg, _ := ioutil.ReadAll(conn)
+			conn.Write(append(msg, " pong"...))
+			conn.Close()
+		}
+	}()
+	address := "tcp://" + ln.Addr().String()
+
+	// the self-signed certificate isn't trusted by default
+	if _, err := SendRequest([]byte("ping"), address, nil, nil); err == nil {
+		t.Fatal("Expect the server's certificate to be rejected")
+	}
+
+	pem, err := ioutil.ReadFile(path.Join(dir, "server.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	res, err := SendRequest([]byte("ping"), address, &tls.Config{RootCAs: roots}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "ping pong" {
+		t.Fatal("Expect", "ping pong", "got", string(res))
+	}
+
+	// the certificate is verified for the address's host
+	_, err = SendRequest([]byte("ping"), address,
+		&tls.Config{RootCAs: roots, ServerName: "example.org"}, nil)
+	if err == nil {
+		t.Fatal("Expect the server's certificate to be rejected")
+	}
+}
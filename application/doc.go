@@ -11,8 +11,11 @@ and key servers.
 Encoding
 
 This module implements the message encoding and decoding for client-server
-communications. Currently this module only supports JSON encoding.
-Protobufs will be supported in the future.
+communications. The messages are encoded in JSON by default, or in
+protobuf (see coniks.proto) at the server addresses configured with
+the protobuf encoding. The server addresses configured to serve HTTP
+requests serve the STR history and auditing requests as HTTP GET
+requests with caching headers instead (see http.go).
 
 Logger
 
@@ -1,11 +1,16 @@
 // Defines methods/functions to encode/decode messages between client
-// and server. Currently this module supports JSON marshal/unmarshal only.
-// Protobuf will be supported in the future.
+// and server in JSON. The protobuf encoding is implemented in
+// protobuf.go.
 
 package application
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
 
 	"github.com/coniks-sys/coniks-go/protocol"
 )
@@ -21,47 +26,125 @@ func MarshalRequest(reqType int, request interface{}) ([]byte, error) {
 // UnmarshalRequest parses a JSON-encoded request msg and
 // creates the corresponding protocol.Request, which will be handled
 // by the server.
+// The decoding is strict: UnmarshalRequest() returns an error
+// describing why msg is malformed if its request type is unknown, or
+// if msg includes a field which its request type doesn't have or a
+// value of the wrong type.
 func UnmarshalRequest(msg []byte) (*protocol.Request, error) {
 	var content json.RawMessage
 	req := protocol.Request{
 		Request: &content,
 	}
-	if err := json.Unmarshal(msg, &req); err != nil {
-		return nil, err
+	if err := decodeStrict(msg, &req); err != nil {
+		return nil, fmt.Errorf("Malformed request: %v", err)
+	}
+	request := newRequest(req.Type)
+	if request == nil {
+		return nil, fmt.Errorf("Malformed request: unknown request type %d", req.Type)
+	}
+	if content == nil {
+		return nil, errors.New("Malformed request: no request content")
 	}
-	var request interface{}
-	switch req.Type {
+	if err := decodeStrict(content, request); err != nil {
+		return nil, fmt.Errorf("Malformed request of type %d: %v", req.Type, err)
+	}
+	req.Request = request
+	return &req, nil
+}
+
+// newRequest returns a new request of the request type t, into which
+// a request message is decoded, or nil if t is unknown.
+func newRequest(t int) interface{} {
+	switch t {
 	case protocol.RegistrationType:
-		request = new(protocol.RegistrationRequest)
+		return new(protocol.RegistrationRequest)
 	case protocol.KeyLookupType:
-		request = new(protocol.KeyLookupRequest)
+		return new(protocol.KeyLookupRequest)
+	case protocol.BatchKeyLookupType:
+		return new(protocol.BatchKeyLookupRequest)
 	case protocol.KeyLookupInEpochType:
-		request = new(protocol.KeyLookupInEpochRequest)
+		return new(protocol.KeyLookupInEpochRequest)
 	case protocol.MonitoringType:
-		request = new(protocol.MonitoringRequest)
-	}
-	if err := json.Unmarshal(content, &request); err != nil {
-		return nil, err
+		return new(protocol.MonitoringRequest)
+	case protocol.STRType:
+		return new(protocol.STRHistoryRequest)
+	case protocol.AuditType:
+		return new(protocol.AuditingRequest)
+	case protocol.ObservationReportType:
+		return new(protocol.ObservationReport)
+	case protocol.KeyHistoryType:
+		return new(protocol.KeyHistoryRequest)
+	case protocol.AttestationType:
+		return new(protocol.AttestationRequest)
+	case protocol.KeyChangeType:
+		return new(protocol.KeyChangeRequest)
+	case protocol.KeyChangeAbortType:
+		return new(protocol.KeyChangeAbortRequest)
+	case protocol.DeactivationType:
+		return new(protocol.DeactivationRequest)
+	case protocol.PoliciesType:
+		return new(protocol.PoliciesRequest)
+	case protocol.STRPushType:
+		return new(protocol.STRPush)
+	case protocol.EmptyRangeType:
+		return new(protocol.EmptyRangeRequest)
+	case protocol.SampleType:
+		return new(protocol.SampleRequest)
+	case protocol.SubtreeType:
+		return new(protocol.SubtreeRequest)
+	case protocol.EpochDeltaType:
+		return new(protocol.EpochDeltaRequest)
+	case protocol.GossipType:
+		return new(protocol.Gossip)
+	default:
+		return nil
 	}
-	req.Request = request
-	return &req, nil
+}
+
+// responseBuffers pools the buffers into which the responses are
+// encoded, which can hold the largest responses.
+var responseBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, protocol.MaxResponseSize)
+		return &buf
+	},
 }
 
 // MarshalResponse returns a JSON encoding of the server's response.
+// It appends the encoding to a pooled buffer directly (see
+// protocol.Response.AppendJSON()), which spares json.Marshal()'s
+// validation of the encoding.
 func MarshalResponse(response *protocol.Response) ([]byte, error) {
-	return json.Marshal(response)
+	buf := responseBuffers.Get().(*[]byte)
+	defer responseBuffers.Put(buf)
+	var err error
+	*buf, err = response.AppendJSON((*buf)[:0])
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), *buf...), nil
 }
 
 // UnmarshalResponse decodes the given message into a protocol.Response
 // according to the given request type t. The request types are integer
 // constants defined in the protocol package.
+// The response's Type tag (see protocol.Response.MarshalJSON()) must
+// name the type of the response to t. The untagged responses of older
+// key servers, including the DirectoryProof responses of the legacy
+// key servers, which include a single authentication path and STR, are
+// accepted as well.
+// The decoding is strict, as for UnmarshalRequest(): if msg includes a
+// field which the response to t doesn't have, or a value of the wrong
+// type, UnmarshalResponse() returns a
+// message.NewErrorResponse(ErrMalformedMessage).
 func UnmarshalResponse(t int, msg []byte) *protocol.Response {
 	type Response struct {
 		Error             protocol.ErrorCode
+		Type              string
 		DirectoryResponse json.RawMessage
 	}
 	var res Response
-	if err := json.Unmarshal(msg, &res); err != nil {
+	if err := decodeStrict(msg, &res); err != nil {
 		return &protocol.Response{
 			Error: protocol.ErrMalformedMessage,
 		}
@@ -79,34 +162,94 @@ func UnmarshalResponse(t int, msg []byte) *protocol.Response {
 		}
 	}
 
-	switch t {
-	case protocol.RegistrationType, protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
-		response := new(protocol.DirectoryProof)
-		if err := json.Unmarshal(res.DirectoryResponse, &response); err != nil {
-			return &protocol.Response{
-				Error: protocol.ErrMalformedMessage,
-			}
-		}
+	if res.Type != "" && res.Type != responseType(t) {
 		return &protocol.Response{
-			Error:             res.Error,
-			DirectoryResponse: response,
-		}
-	case protocol.STRType:
-		response := new(protocol.STRHistoryRange)
-		if err := json.Unmarshal(res.DirectoryResponse, &response); err != nil {
-			return &protocol.Response{
-				Error: protocol.ErrMalformedMessage,
-			}
+			Error: protocol.ErrMalformedMessage,
 		}
+	}
+	response, err := unmarshalDirectoryResponse(t, res.DirectoryResponse)
+	if err != nil {
 		return &protocol.Response{
-			Error:             res.Error,
-			DirectoryResponse: response,
+			Error: protocol.ErrMalformedMessage,
 		}
+	}
+	return &protocol.Response{
+		Error:             res.Error,
+		DirectoryResponse: response,
+	}
+}
+
+// isDirectoryProofType returns whether the response to the request
+// type t is a DirectoryProof.
+func isDirectoryProofType(t int) bool {
+	switch t {
+	case protocol.RegistrationType, protocol.KeyLookupType, protocol.KeyLookupInEpochType,
+		protocol.MonitoringType, protocol.KeyHistoryType,
+		protocol.KeyChangeType, protocol.KeyChangeAbortType, protocol.DeactivationType:
+		return true
+	default:
+		return false
+	}
+}
+
+// responseType returns the name of the type of the DirectoryResponse
+// of the response to the request type t.
+func responseType(t int) string {
+	if isDirectoryProofType(t) {
+		return "DirectoryProof"
+	}
+	switch t {
+	case protocol.STRType, protocol.AuditType, protocol.GossipType:
+		return "STRHistoryRange"
+	case protocol.AttestationType:
+		return "RegistrationAttestation"
+	case protocol.PoliciesType:
+		return "PolicyDocumentProof"
+	case protocol.STRPushType:
+		return "STRPushAck"
+	case protocol.EmptyRangeType:
+		return "EmptyRangeProof"
+	case protocol.SampleType:
+		return "SampleProof"
+	case protocol.SubtreeType:
+		return "SubtreeProof"
+	case protocol.BatchKeyLookupType:
+		return "BatchKeyLookupProof"
+	case protocol.EpochDeltaType:
+		return "EpochDelta"
 	default:
 		panic("Unknown request type")
 	}
 }
 
+// unmarshalDirectoryResponse strictly decodes the JSON-encoded
+// DirectoryResponse msg of the response to the request type t.
+func unmarshalDirectoryResponse(t int, msg json.RawMessage) (protocol.DirectoryResponse, error) {
+	if isDirectoryProofType(t) {
+		return unmarshalDirectoryProof(msg)
+	}
+	response := protocol.NewDirectoryResponse(responseType(t))
+	if err := decodeStrict(msg, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// decodeStrict decodes the JSON value msg into v. Unlike
+// json.Unmarshal(), it rejects the fields which v doesn't have,
+// and any data following the value.
+func decodeStrict(msg []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the message")
+	}
+	return nil
+}
+
 func malformedClientMsg(err error) *protocol.Response {
 	// check if we're just propagating a message
 	if err == nil {
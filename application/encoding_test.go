@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
@@ -40,8 +42,178 @@ func TestUnmarshalSampleMessage(t *testing.T) {
 		EndEpoch:   0})
 	msg, _ := MarshalResponse(res)
 	response := UnmarshalResponse(protocol.STRType, []byte(msg))
-	str := response.DirectoryResponse.(*protocol.STRHistoryRange).STR[0]
+	str := response.STRHistoryRange().STR[0]
 	if !bytes.Equal(d.LatestSTR().Serialize(), str.Serialize()) {
 		t.Error("Cannot unmarshal Associate Data properly")
 	}
 }
+
+func TestUnmarshalResponseType(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	msg, _ := MarshalResponse(d.GetSTRHistory(&protocol.STRHistoryRequest{}))
+	if res := UnmarshalResponse(protocol.STRType, msg); res.STRHistoryRange() == nil {
+		t.Fatal("Expect an STRHistoryRange", "got", res.Error)
+	}
+	// the tag must match the request type
+	if res := UnmarshalResponse(protocol.AttestationType, msg); res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+	// untagged responses are accepted
+	untagged := bytes.Replace(msg, []byte(`"Type":"STRHistoryRange",`), nil, 1)
+	if res := UnmarshalResponse(protocol.STRType, untagged); res.STRHistoryRange() == nil {
+		t.Error("Expect an untagged STRHistoryRange", "got", res.Error)
+	}
+}
+
+func TestUnmarshalSTRHistoryRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  string
+		want protocol.STRHistoryRequest
+	}{
+		{"without latest", `{"Type":5,"Request":{"StartEpoch":1,"EndEpoch":2}}`,
+			protocol.STRHistoryRequest{StartEpoch: 1, EndEpoch: 2}},
+		{"without end epoch", `{"Type":5,"Request":{"StartEpoch":1}}`,
+			protocol.STRHistoryRequest{StartEpoch: 1}},
+		{"latest", `{"Type":5,"Request":{"StartEpoch":1,"Latest":true}}`,
+			protocol.STRHistoryRequest{StartEpoch: 1, Latest: true}},
+	} {
+		req, err := UnmarshalRequest([]byte(tc.msg))
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if got := req.Request.(*protocol.STRHistoryRequest); *got != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", *got)
+		}
+	}
+}
+
+func TestUnmarshalRequestStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  string
+		ok   bool
+	}{
+		{"well-formed", `{"Type":1,"Request":{"Username":"alice"}}`, true},
+		{"unknown field", `{"Type":1,"Request":{"Username":"alice","Key":"AQI="}}`, false},
+		{"unknown envelope field", `{"Type":1,"Request":{"Username":"alice"},"Version":2}`, false},
+		{"type mismatch", `{"Type":1,"Request":{"Username":1}}`, false},
+		{"unknown type", `{"Type":99,"Request":{"Username":"alice"}}`, false},
+		{"no content", `{"Type":1}`, false},
+		{"trailing data", `{"Type":1,"Request":{"Username":"alice"}}{}`, false},
+	} {
+		req, err := UnmarshalRequest([]byte(tc.msg))
+		if tc.ok {
+			if _, ok := req.Request.(*protocol.KeyLookupRequest); err != nil || !ok {
+				t.Error(tc.name, "expect a key lookup request, got", err)
+			}
+		} else if err == nil {
+			t.Error(tc.name, "expect an error")
+		}
+	}
+}
+
+func TestUnmarshalResponseStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  string
+		want protocol.ErrorCode
+	}{
+		{"well-formed", `{"Error":100,"DirectoryResponse":{"Epoch":1}}`,
+			protocol.ReqSuccess},
+		{"unknown field", `{"Error":100,"DirectoryResponse":{"Epoch":1,"Extra":1}}`,
+			protocol.ErrMalformedMessage},
+		{"type mismatch", `{"Error":100,"DirectoryResponse":{"Epoch":"one"}}`,
+			protocol.ErrMalformedMessage},
+		{"unknown envelope field", `{"Error":100,"DirectoryResponse":{"Epoch":1},"Extra":1}`,
+			protocol.ErrMalformedMessage},
+	} {
+		res := UnmarshalResponse(protocol.STRPushType, []byte(tc.msg))
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+// reflectAuthPath and reflectDirSTR are encoded by the reflection of
+// encoding/json, which the hand-rolled encoding is benchmarked against.
+type reflectAuthPath merkletree.AuthenticationPath
+type reflectDirSTR protocol.DirSTR
+
+// reflectResponse returns the response res, which includes a
+// DirectoryProof, with the types encoded by reflection.
+func reflectResponse(res *protocol.Response) interface{} {
+	df := res.DirectoryProof()
+	type directoryProof struct {
+		AP           []*reflectAuthPath
+		STR          []*reflectDirSTR
+		TB           *protocol.TemporaryBinding `json:",omitempty"`
+		Continuation *protocol.Continuation     `json:",omitempty"`
+	}
+	proof := &directoryProof{TB: df.TB, Continuation: df.Continuation}
+	for _, ap := range df.AP {
+		proof.AP = append(proof.AP, (*reflectAuthPath)(ap))
+	}
+	for _, str := range df.STR {
+		proof.STR = append(proof.STR, (*reflectDirSTR)(str))
+	}
+	return &struct {
+		Error             protocol.ErrorCode
+		Type              string
+		DirectoryResponse *directoryProof
+	}{res.Error, df.ResponseType(), proof}
+}
+
+// monitoringProof returns the response to a monitoring request
+// spanning the epochs after the binding's inclusion, which is as large
+// as a response can be (see protocol.MaxResponseSize).
+func monitoringProof(b *testing.B) *protocol.Response {
+	const epochs = 100
+	d := directory.New(1, crypto.NewStaticTestVRFKey(),
+		crypto.NewStaticTestSigningKey(), epochs+2, true)
+	d.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key"),
+	})
+	for i := uint64(0); i <= epochs; i++ {
+		d.Update()
+	}
+	res := d.Monitor(&protocol.MonitoringRequest{
+		Username:   "alice",
+		StartEpoch: 2,
+		EndEpoch:   epochs + 1,
+	})
+	if res.Error != protocol.ReqSuccess || res.DirectoryProof().Transition != nil {
+		b.Fatal("Expect a monitoring proof", "got", res.Error)
+	}
+	// the hand-rolled encoding is identical
+	want, err := json.Marshal(reflectResponse(res))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if got, _ := MarshalResponse(res); !bytes.Equal(got, want) {
+		b.Fatal("Expect an identical encoding", "got", string(got))
+	}
+	return res
+}
+
+// encoded keeps the benchmarked encodings from being optimized away.
+var encoded []byte
+
+func BenchmarkMarshalMonitoringProof(b *testing.B) {
+	res := monitoringProof(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, _ = MarshalResponse(res)
+	}
+}
+
+func BenchmarkMarshalMonitoringProofReflect(b *testing.B) {
+	res := reflectResponse(monitoringProof(b))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, _ = json.Marshal(res)
+	}
+}
@@ -0,0 +1,429 @@
+// Implements the HTTP connections of a CONIKS server (see
+// ServerAddress.HTTP), which serve a REST API of the key server's
+// requests, i.e., the registrations, key lookups, monitoring and STR
+// history requests, and of the auditing requests, for web clients and
+// HTTP tooling. The STRs of past epochs never change, so HTTP caches
+// and CDNs can serve them to the clients catching up with a directory,
+// e.g., after an outage.
+
+package application
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// These are the paths of the HTTP endpoints of a server.
+//
+// HTTPRegisterPath serves the registrations sent to a key server with
+// the POST method: the body of the request is the JSON encoding of the
+// protocol.RegistrationRequest, e.g.,
+// {"Username": "alice", "Key": "<base64 key>"}.
+//
+// HTTPLookupPath serves the key lookups: the path is followed by the
+// looked up name, e.g., "/v1/lookup/alice" (see
+// protocol.KeyLookupRequest), and takes the optional query parameter
+// epoch to look up the name in a past epoch, e.g.,
+// "/v1/lookup/alice?epoch=3" (see protocol.KeyLookupInEpochRequest).
+//
+// HTTPMonitorPath serves the monitoring requests: the path is followed
+// by the monitored name, and takes the query parameters start and end,
+// e.g., "/v1/monitor/alice?start=3&end=5" (see
+// protocol.MonitoringRequest).
+//
+// HTTPSTRPath serves the STR history requests sent to a key server
+// (see protocol.STRHistoryRequest), with the query parameters start
+// and end, e.g., "/v1/str?start=3&end=5", or start and latest=true.
+// HTTPLegacySTRPath serves the same requests.
+//
+// HTTPAuditPath serves the auditing requests sent to an auditor (see
+// protocol.AuditingRequest): the path is followed by the hex-encoded
+// hash of the directory's initial STR, and takes the query parameters
+// start and end.
+//
+// All endpoints but HTTPRegisterPath serve the GET and HEAD methods.
+// The body of a response is the encoding of the protocol.Response
+// whatever its status code, in JSON (see MarshalResponse()) or in
+// protobuf (see MarshalResponsePB()), as negotiated with the client's
+// Accept header (see HTTPJSONType and HTTPProtobufType). Its status code
+// reflects the response's error code (see httpStatus()).
+const (
+	HTTPRegisterPath  = "/v1/register"
+	HTTPLookupPath    = "/v1/lookup/"
+	HTTPMonitorPath   = "/v1/monitor/"
+	HTTPSTRPath       = "/v1/str"
+	HTTPLegacySTRPath = "/str"
+	HTTPAuditPath     = "/audit/"
+)
+
+// These are the media types of the HTTP requests and responses.
+// The requests' bodies are JSON-encoded.
+const (
+	HTTPJSONType     = "application/json"
+	HTTPProtobufType = "application/x-protobuf"
+)
+
+// maxHTTPBodySize bounds the size of the body of the HTTP requests.
+const maxHTTPBodySize = 1 << 16
+
+// These are the Cache-Control headers of the HTTP responses:
+// a successful response which covers the requested range of past
+// epochs can be cached forever, a response which may change as the
+// directory issues new STRs has to be revalidated (see its ETag), and
+// an error may be resolved later, so it isn't cached.
+const (
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheRevalidate = "no-cache"
+	cacheNone       = "no-store"
+)
+
+// serveHTTP serves the HTTP requests received by the listener l of addr
+// on ln, over TLS if ln is a TCP listener, until the server is shut
+// down. The requests are passed to handler as the CONIKS requests
+// received on the other connections.
+func (sb *ServerBase) serveHTTP(addr *ServerAddress, l *listener,
+	ln net.Listener, tlsConfig *tls.Config,
+	handler func(peer string, req *protocol.Request) *protocol.Response) {
+	if _, ok := ln.(*net.TCPListener); ok {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sb.serveHTTPRequest(addr, l, w, r, handler)
+		}),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+
+	// wait for the requests being handled once the server shuts down
+	done := make(chan struct{})
+	go func() {
+		<-sb.stop
+		srv.Shutdown(context.Background())
+		close(done)
+	}()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		sb.logger.Error(err.Error(), "listener", l.label)
+	}
+	<-done
+}
+
+// serveHTTPRequest handles the HTTP request r: it writes the response
+// of handler to the corresponding CONIKS request, in the negotiated
+// encoding and with its caching headers, or the status code 304 if the
+// client's cached copy is still valid (see the If-None-Match header).
+func (sb *ServerBase) serveHTTPRequest(addr *ServerAddress, l *listener,
+	w http.ResponseWriter, r *http.Request,
+	handler func(peer string, req *protocol.Request) *protocol.Response) {
+	if !isHTTPEndpoint(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+	if allow := allowedMethods(r.URL.Path); !containsString(allow, r.Method) {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	encoding, contentType, ok := negotiateEncoding(r.Header.Get("Accept"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotAcceptable),
+			http.StatusNotAcceptable)
+		return
+	}
+	if r.Method == http.MethodPost {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != HTTPJSONType {
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType),
+				http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	atomic.AddUint64(&l.requests, 1)
+	req, err := parseHTTPRequest(r)
+
+	var response *protocol.Response
+	if err != nil {
+		sb.logger.Warn(err.Error(), "address", r.RemoteAddr, "listener", l.label)
+		response = malformedClientMsg(err)
+	} else {
+		response = sb.handle(addr, l, r.RemoteAddr, req, handler)
+	}
+	if response.Error != protocol.ReqSuccess {
+		atomic.AddUint64(&l.errors, 1)
+	}
+	res, e := MarshalResponseWith(encoding, response)
+	if e != nil {
+		panic(e)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if response.Error != protocol.ReqSuccess || req.Type == protocol.RegistrationType {
+		w.Header().Set("Cache-Control", cacheNone)
+		w.WriteHeader(httpStatus(response.Error))
+		w.Write(res)
+		return
+	}
+	etag := `"` + hex.EncodeToString(crypto.Digest(res)) + `"`
+	w.Header().Set("ETag", etag)
+	if coversRange(req, response) {
+		w.Header().Set("Cache-Control", cacheImmutable)
+	} else {
+		w.Header().Set("Cache-Control", cacheRevalidate)
+	}
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(res)
+}
+
+// isHTTPEndpoint returns whether path is the path of one of the HTTP
+// endpoints.
+func isHTTPEndpoint(path string) bool {
+	switch {
+	case path == HTTPRegisterPath, path == HTTPSTRPath, path == HTTPLegacySTRPath:
+		return true
+	case strings.HasPrefix(path, HTTPLookupPath),
+		strings.HasPrefix(path, HTTPMonitorPath),
+		strings.HasPrefix(path, HTTPAuditPath):
+		return true
+	default:
+		return false
+	}
+}
+
+// allowedMethods returns the methods allowed on the HTTP endpoint path.
+func allowedMethods(path string) []string {
+	if path == HTTPRegisterPath {
+		return []string{http.MethodPost}
+	}
+	return []string{http.MethodGet, http.MethodHead}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns the encoding of the response to a client
+// which accepts the media types listed in the Accept header accept,
+// and its media type, i.e., the acceptable type with the highest
+// quality, or the JSON encoding if accept is empty. It returns
+// ok = false if the client accepts neither of the encodings.
+func negotiateEncoding(accept string) (encoding, contentType string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return JSONEncoding, HTTPJSONType, true
+	}
+	best := -1.0
+	for _, r := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 || q <= best {
+			continue
+		}
+		switch mediaType {
+		case HTTPJSONType, "application/*", "*/*":
+			encoding, contentType = JSONEncoding, HTTPJSONType
+		case HTTPProtobufType:
+			encoding, contentType = ProtobufEncoding, HTTPProtobufType
+		default:
+			continue
+		}
+		best = q
+	}
+	return encoding, contentType, best > 0
+}
+
+// parseHTTPRequest returns the CONIKS request corresponding to the HTTP
+// request r to one of the HTTP endpoints. It returns an
+// ErrMalformedMessage if the path, the query parameters or the body of
+// r are malformed.
+func parseHTTPRequest(r *http.Request) (*protocol.Request, error) {
+	query := r.URL.Query()
+	switch path := r.URL.Path; {
+	case path == HTTPRegisterPath:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxHTTPBodySize))
+		if err != nil {
+			return nil, protocol.ErrMalformedMessage
+		}
+		msg := new(protocol.RegistrationRequest)
+		if err := decodeStrict(body, msg); err != nil || msg.Username == "" {
+			return nil, protocol.ErrMalformedMessage
+		}
+		return &protocol.Request{Type: protocol.RegistrationType, Request: msg}, nil
+
+	case strings.HasPrefix(path, HTTPLookupPath):
+		name := strings.TrimPrefix(path, HTTPLookupPath)
+		if name == "" {
+			return nil, protocol.ErrMalformedMessage
+		}
+		if query.Get("epoch") == "" {
+			return &protocol.Request{
+				Type:    protocol.KeyLookupType,
+				Request: &protocol.KeyLookupRequest{Username: name},
+			}, nil
+		}
+		epoch, err := strconv.ParseUint(query.Get("epoch"), 10, 64)
+		if err != nil {
+			return nil, protocol.ErrMalformedMessage
+		}
+		return &protocol.Request{
+			Type: protocol.KeyLookupInEpochType,
+			Request: &protocol.KeyLookupInEpochRequest{
+				Username: name,
+				Epoch:    epoch,
+			},
+		}, nil
+
+	case strings.HasPrefix(path, HTTPMonitorPath):
+		name := strings.TrimPrefix(path, HTTPMonitorPath)
+		start, end, _, ok := parseRange(query, false)
+		if name == "" || !ok {
+			return nil, protocol.ErrMalformedMessage
+		}
+		return &protocol.Request{
+			Type: protocol.MonitoringType,
+			Request: &protocol.MonitoringRequest{
+				Username:   name,
+				StartEpoch: start,
+				EndEpoch:   end,
+			},
+		}, nil
+
+	case path == HTTPSTRPath, path == HTTPLegacySTRPath:
+		start, end, latest, ok := parseRange(query, true)
+		if !ok {
+			return nil, protocol.ErrMalformedMessage
+		}
+		return &protocol.Request{
+			Type: protocol.STRType,
+			Request: &protocol.STRHistoryRequest{
+				StartEpoch: start,
+				EndEpoch:   end,
+				Latest:     latest,
+			},
+		}, nil
+
+	default: // HTTPAuditPath
+		h, err := hex.DecodeString(strings.TrimPrefix(path, HTTPAuditPath))
+		start, end, _, ok := parseRange(query, false)
+		if err != nil || len(h) != crypto.HashSizeByte || !ok {
+			return nil, protocol.ErrMalformedMessage
+		}
+		msg := &protocol.AuditingRequest{StartEpoch: start, EndEpoch: end}
+		copy(msg.DirInitSTRHash[:], h)
+		return &protocol.Request{Type: protocol.AuditType, Request: msg}, nil
+	}
+}
+
+// parseRange parses the epoch range [start, end] of the query
+// parameters query, or the range from start to the latest epoch if
+// latestAllowed is true and the query sets latest=true instead of end.
+// It returns ok = false if the range is malformed.
+func parseRange(query url.Values, latestAllowed bool) (start, end uint64,
+	latest, ok bool) {
+	start, err := strconv.ParseUint(query.Get("start"), 10, 64)
+	if err != nil {
+		return 0, 0, false, false
+	}
+	if latestAllowed && query.Get("latest") == "true" && query.Get("end") == "" {
+		return start, 0, true, true
+	}
+	end, err = strconv.ParseUint(query.Get("end"), 10, 64)
+	return start, end, false, err == nil
+}
+
+// coversRange returns whether the successful response to req covers
+// the requested epochs, i.e., whether it will never change: either its
+// STRs extend to the end of the range, or it is a part of the range
+// followed by a continuation.
+func coversRange(req *protocol.Request, response *protocol.Response) bool {
+	var end uint64
+	switch msg := req.Request.(type) {
+	case *protocol.STRHistoryRequest:
+		if msg.Latest {
+			return false
+		}
+		end = msg.EndEpoch
+	case *protocol.AuditingRequest:
+		end = msg.EndEpoch
+	default:
+		return false
+	}
+	r := response.STRHistoryRange()
+	if r == nil {
+		return false
+	}
+	return r.Continuation != nil ||
+		len(r.STR) > 0 && r.STR[len(r.STR)-1].Epoch == end
+}
+
+// matchETag returns whether the If-None-Match header header matches
+// the ETag etag.
+func matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// httpStatus returns the HTTP status code of a response with the
+// error code e. Note that the body of a response to a key lookup of
+// a missing name, with the status code 404, still holds the proof of
+// the name's absence.
+func httpStatus(e protocol.ErrorCode) int {
+	switch e {
+	case protocol.ReqSuccess:
+		return http.StatusOK
+	case protocol.ErrMalformedMessage:
+		return http.StatusBadRequest
+	case protocol.ReqNameNotFound, protocol.ReqUnknownDirectory,
+		protocol.ReqNoPolicyDocument:
+		return http.StatusNotFound
+	case protocol.ReqNameExisted, protocol.ReqNoPendingChange,
+		protocol.ReqRangeNotEmpty, protocol.ReqNameDeactivated:
+		return http.StatusConflict
+	case protocol.ReqLimitExceeded, protocol.ReqIDTypeDisabled,
+		protocol.ReqBadAttestation, protocol.ReqMissingAttestation,
+		protocol.ReqNameFrozen:
+		return http.StatusForbidden
+	case protocol.ErrUnsupportedRequest:
+		return http.StatusNotImplemented
+	case protocol.ReqRateLimited:
+		return http.StatusTooManyRequests
+	case protocol.ReqRetryLater:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
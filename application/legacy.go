@@ -0,0 +1,62 @@
+// Implements the compatibility with the key servers of the legacy
+// keyserver package, so that the clients can talk to both the current
+// and the legacy servers while a deployment migrates between them.
+
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// legacyDirectoryProof is the DirectoryProof a legacy key server
+// returns, which includes a single authentication path and STR
+// instead of a list of them.
+type legacyDirectoryProof struct {
+	AP  *merkletree.AuthenticationPath
+	STR *protocol.DirSTR
+	TB  *protocol.TemporaryBinding
+}
+
+// unmarshalDirectoryProof decodes the DirectoryProof msg, which is
+// either in the current format, or in the format of a legacy key
+// server. A legacy proof is converted to a DirectoryProof with lists
+// of one authentication path and one STR, which the client then
+// verifies as any other proof.
+func unmarshalDirectoryProof(msg []byte) (*protocol.DirectoryProof, error) {
+	// the fields are probed leniently, and then decoded strictly
+	var fields struct {
+		AP, STR json.RawMessage
+	}
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return nil, err
+	}
+	if !isJSONObject(fields.AP) && !isJSONObject(fields.STR) {
+		df := new(protocol.DirectoryProof)
+		if err := decodeStrict(msg, df); err != nil {
+			return nil, err
+		}
+		return df, nil
+	}
+
+	var legacy legacyDirectoryProof
+	if err := decodeStrict(msg, &legacy); err != nil {
+		return nil, err
+	}
+	df := &protocol.DirectoryProof{TB: legacy.TB}
+	if legacy.AP != nil {
+		df.AP = []*merkletree.AuthenticationPath{legacy.AP}
+	}
+	if legacy.STR != nil {
+		df.STR = []*protocol.DirSTR{legacy.STR}
+	}
+	return df, nil
+}
+
+// isJSONObject returns whether the JSON value msg is an object.
+func isJSONObject(msg json.RawMessage) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(msg), []byte("{"))
+}
@@ -0,0 +1,53 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestUnmarshalLegacyDirectoryProof(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	initSTR := d.LatestSTR()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	df := res.DirectoryProof()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+
+	current, err := MarshalResponse(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := json.Marshal(&struct {
+		Error             protocol.ErrorCode
+		DirectoryResponse *legacyDirectoryProof
+	}{res.Error, &legacyDirectoryProof{AP: df.AP[0], STR: df.STR[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+	}{
+		{"current", current},
+		{"legacy", legacy},
+	} {
+		response := UnmarshalResponse(protocol.KeyLookupType, tc.msg)
+		got := response.DirectoryProof()
+		if got == nil || len(got.AP) != 1 || len(got.STR) != 1 ||
+			!bytes.Equal(got.STR[0].Signature, df.STR[0].Signature) {
+			t.Fatal(tc.name, "expect a proof with one AP and one STR")
+		}
+		cc := client.New(initSTR, true, pk)
+		if err := cc.HandleResponse(protocol.KeyLookupType, response, "alice", []byte("key")); err != nil {
+			t.Error(tc.name, "expect", nil, "got", err)
+		}
+	}
+}
@@ -16,18 +16,24 @@ type Logger struct {
 // which is either "development" or "production",
 // the path of file to write the logging output to,
 // and an option to explicitly enable stracktrace output.
+// Level optionally overrides the minimum level of the environment
+// ("debug", "info", "warn" or "error"), and Encoding is either
+// "console" (the default) or "json".
 type LoggerConfig struct {
 	EnableStacktrace bool   `toml:"enable_stacktrace,omitempty"`
 	Environment      string `toml:"env"`
 	Path             string `toml:"path,omitempty"`
+	Level            string `toml:"level,omitempty"`
+	Encoding         string `toml:"encoding,omitempty"`
 }
 
 // NewLogger builds an instance of Logger with
 // default configurations. This logger writes
 // DebugLevel and above logs in development environment,
 // InfoLevel and above logs in production environment
+// (unless conf sets another Level)
 // to stderr and the file specified in conf,
-// in a human-friendly format.
+// in a human-friendly format, or one JSON object per line.
 func NewLogger(conf *LoggerConfig) *Logger {
 	zLevel := zap.NewAtomicLevel()
 	switch {
@@ -38,6 +44,19 @@ func NewLogger(conf *LoggerConfig) *Logger {
 	default:
 		panic("Environment must be either development or production")
 	}
+	if conf.Level != "" {
+		if err := zLevel.UnmarshalText([]byte(strings.ToLower(conf.Level))); err != nil {
+			panic(err)
+		}
+	}
+	encoding := "console"
+	switch {
+	case conf.Encoding == "", strings.EqualFold("console", conf.Encoding):
+	case strings.EqualFold("json", conf.Encoding):
+		encoding = "json"
+	default:
+		panic("Encoding must be either console or json")
+	}
 
 	zOutputPaths := []string{"stderr"}
 	if conf.Path != "" {
@@ -47,7 +66,7 @@ func NewLogger(conf *LoggerConfig) *Logger {
 	zConfig := &zap.Config{
 		Level:             zLevel,
 		Development:       false,
-		Encoding:          "console",
+		Encoding:          encoding,
 		DisableStacktrace: !conf.EnableStacktrace, // the developer needs to explicitly enable this
 		EncoderConfig: zapcore.EncoderConfig{
 			TimeKey:        "timestamp",
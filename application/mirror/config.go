@@ -0,0 +1,103 @@
+package mirror
+
+import (
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A Config contains configuration values
+// which are read at initialization time from
+// a TOML format configuration file.
+type Config struct {
+	*application.CommonConfig
+	// SignPubkeyPath is the path to the primary directory's
+	// signing public key, and SigningPubKey is the parsed key.
+	SignPubkeyPath string `toml:"sign_pubkey_path"`
+	SigningPubKey  sign.PublicKey
+	// InitSTRPath is the path to the primary directory's initial STR,
+	// and InitSTR is the parsed STR.
+	InitSTRPath string `toml:"init_str_path"`
+	InitSTR     *protocol.DirSTR
+	// PrimaryAddress is the address of the primary key server
+	// this mirror follows.
+	PrimaryAddress string `toml:"primary_address"`
+	// LoadedHistoryLength is the maximum number of
+	// verified STRs kept in memory.
+	LoadedHistoryLength uint64 `toml:"loaded_history_length"`
+	// Addresses contains the mirror's connections configuration.
+	Addresses []*application.ServerAddress `toml:"addresses"`
+	// SyncInterval is the interval in seconds at which the mirror
+	// fetches new STRs from the primary. It should be shorter than
+	// the primary's epoch deadline.
+	SyncInterval protocol.Timestamp `toml:"sync_interval"`
+}
+
+var _ application.AppConfig = (*Config)(nil)
+
+// NewConfig initializes a new mirror configuration at the given
+// file path, with the given config encoding, the primary's signing
+// public key path, initial STR path and address, the mirror's
+// addresses, logger configuration, loaded history length and sync interval.
+func NewConfig(file, encoding, signPubkeyPath, initSTRPath, primaryAddr string,
+	addrs []*application.ServerAddress, logConfig *application.LoggerConfig,
+	loadedHistLen uint64, syncInterval protocol.Timestamp) *Config {
+	var conf = Config{
+		CommonConfig:        application.NewCommonConfig(file, encoding, logConfig),
+		SignPubkeyPath:      signPubkeyPath,
+		InitSTRPath:         initSTRPath,
+		PrimaryAddress:      primaryAddr,
+		LoadedHistoryLength: loadedHistLen,
+		Addresses:           addrs,
+		SyncInterval:        syncInterval,
+	}
+
+	return &conf
+}
+
+// Load initializes a mirror configuration at the given file path
+// using the given encoding.
+// It reads the primary's signing public key and initial STR,
+// and updates the path of TLS certificate files of each
+// Address to absolute path.
+func (conf *Config) Load(file, encoding string) error {
+	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+
+	// load signing key
+	signPubKey, err := application.LoadSigningPubKey(conf.SignPubkeyPath, file)
+	if err != nil {
+		return err
+	}
+	conf.SigningPubKey = signPubKey
+
+	// load initial STR
+	initSTR, err := application.LoadInitSTR(conf.InitSTRPath, file)
+	if err != nil {
+		return err
+	}
+	conf.InitSTR = initSTR
+
+	// also update path for TLS cert files
+	for _, addr := range conf.Addresses {
+		addr.TLSCertPath = utils.ResolvePath(addr.TLSCertPath, file)
+		addr.TLSKeyPath = utils.ResolvePath(addr.TLSKeyPath, file)
+	}
+	// logger config
+	conf.Logger.Path = utils.ResolvePath(conf.Logger.Path, file)
+
+	return nil
+}
+
+// Save writes a mirror's configuration.
+func (conf *Config) Save() error {
+	return conf.GetLoader().Encode(conf)
+}
+
+// GetPath returns the mirror's configuration file path.
+func (conf *Config) GetPath() string {
+	return conf.Path
+}
@@ -0,0 +1,18 @@
+/*
+Package mirror implements a read-only public mirror of a CONIKS
+key server.
+
+A mirror follows a primary key server: it fetches each new STR
+the primary issues, and verifies the STR's signature and its
+consistency with the STR history the mirror has verified so far.
+The mirror serves the verified STR history itself, and forwards
+lookup and monitoring requests to the primary, relaying only
+responses whose proofs verify against its verified STR history.
+Verified proofs of inclusion are cached until the end of the epoch,
+which reduces the load on the primary.
+
+A mirror never accepts registrations. Since the primary's STRs are
+signed, the mirror serves them unmodified, so clients verify the
+mirror's responses exactly as they would verify the primary's.
+*/
+package mirror
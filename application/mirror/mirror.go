@@ -0,0 +1,339 @@
+package mirror
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// A ConiksMirror represents a read-only mirror of a CONIKS key server.
+// It wraps the STR history it has verified with a network layer which
+// handles requests/responses and their encoding/decoding.
+type ConiksMirror struct {
+	*application.ServerBase
+	primary    string
+	send       func(msg []byte) ([]byte, error)
+	aud        *auditor.AudState
+	history    []*protocol.DirSTR
+	historyLen uint64
+	syncTimer  *application.EpochTimer
+
+	cacheLock sync.Mutex
+	cache     map[string]*protocol.Response
+}
+
+// NewConiksMirror creates a new mirror of the primary key server
+// specified in conf.
+func NewConiksMirror(conf *Config) *ConiksMirror {
+	// a mirror only serves "read" requests
+	perms := make(map[*application.ServerAddress]map[int]bool)
+	for _, addr := range conf.Addresses {
+		perms[addr] = map[int]bool{
+			protocol.KeyLookupType:        true,
+			protocol.KeyLookupInEpochType: true,
+			protocol.MonitoringType:       true,
+			protocol.STRType:              true,
+			protocol.KeyHistoryType:       true,
+			protocol.PoliciesType:         true,
+		}
+	}
+
+	m := &ConiksMirror{
+		ServerBase: application.NewServerBase(conf.CommonConfig,
+			"Mirroring", perms),
+		primary:    conf.PrimaryAddress,
+		aud:        auditor.New(conf.SigningPubKey, conf.InitSTR),
+		history:    []*protocol.DirSTR{conf.InitSTR},
+		historyLen: conf.LoadedHistoryLength,
+		syncTimer:  application.NewEpochTimer(conf.SyncInterval),
+		cache:      make(map[string]*protocol.Response),
+	}
+	m.send = m.sendToPrimary
+	return m
+}
+
+// Run catches up with the primary's STR history, and then listens for
+// all declared connections while following the primary in
+// the background.
+func (m *ConiksMirror) Run(addrs []*application.ServerAddress) {
+	if err := m.Sync(); err != nil {
+		m.Logger().Error(err.Error(), "primary", m.primary)
+	}
+	m.RunInBackground(func() {
+		m.EpochUpdate(m.syncTimer, func() error {
+			if err := m.Sync(); err != nil {
+				m.Logger().Error(err.Error(), "primary", m.primary)
+			}
+			// the next sync catches up with the primary
+			return nil
+		})
+	})
+	for _, addr := range addrs {
+		m.ListenAndHandle(addr, m.HandleRequests)
+	}
+}
+
+// Sync fetches all STRs the primary has issued since the latest STR
+// the mirror has verified, and verifies them. It returns the consistency
+// check error of the first STR which fails to verify, if any.
+// Sync must not be called concurrently with HandleRequests.
+func (m *ConiksMirror) Sync() error {
+	return clientapp.FetchSTRHistory(func() uint64 {
+		return m.aud.VerifiedSTR().Epoch
+	}, m.send, func(response *protocol.Response) error {
+		if response.Error != protocol.ReqSuccess {
+			return response.Error
+		}
+		rng, ok := response.DirectoryResponse.(*protocol.STRHistoryRange)
+		if !ok {
+			return protocol.ErrMalformedMessage
+		}
+		strs := rng.STR
+		if err := m.aud.AuditDirectory(strs); err != nil {
+			return err
+		}
+		if err := rng.Verify(); err != nil {
+			return err
+		}
+		for _, t := range rng.Transitions {
+			m.Logger().Info("Primary changed its policies",
+				"epoch", t.Epoch, "policies", t.Changed)
+		}
+		if len(strs) > 1 {
+			m.update(strs[1:])
+		}
+		return nil
+	})
+}
+
+// update appends the newly verified strs to the history,
+// and drops the oldest STRs if the history exceeds its maximum length.
+// It also invalidates all cached responses.
+func (m *ConiksMirror) update(strs []*protocol.DirSTR) {
+	m.history = append(m.history, strs...)
+	if m.historyLen > 0 && uint64(len(m.history)) > m.historyLen {
+		m.history = append(m.history[:0],
+			m.history[uint64(len(m.history))-m.historyLen:]...)
+	}
+	m.aud.Update(strs[len(strs)-1])
+	m.cacheLock.Lock()
+	m.cache = make(map[string]*protocol.Response)
+	m.cacheLock.Unlock()
+	m.Logger().Info("Mirrored new epoch",
+		"epoch", m.aud.VerifiedSTR().Epoch)
+}
+
+// HandleRequests validates the request message and serves it from the
+// mirror's verified STR history, or forwards it to the primary,
+// according to the request type.
+func (m *ConiksMirror) HandleRequests(req *protocol.Request) *protocol.Response {
+	switch req.Type {
+	case protocol.STRType:
+		if msg, ok := req.Request.(*protocol.STRHistoryRequest); ok {
+			return m.GetSTRHistory(msg)
+		}
+	case protocol.KeyLookupType:
+		if msg, ok := req.Request.(*protocol.KeyLookupRequest); ok {
+			return m.keyLookup(req, msg.Username)
+		}
+	case protocol.KeyLookupInEpochType:
+		if msg, ok := req.Request.(*protocol.KeyLookupInEpochRequest); ok {
+			return m.forward(req, msg.Username)
+		}
+	case protocol.MonitoringType:
+		if msg, ok := req.Request.(*protocol.MonitoringRequest); ok {
+			return m.forward(req, msg.Username)
+		}
+	case protocol.KeyHistoryType:
+		if msg, ok := req.Request.(*protocol.KeyHistoryRequest); ok {
+			return m.forward(req, msg.Username)
+		}
+	case protocol.PoliciesType:
+		if _, ok := req.Request.(*protocol.PoliciesRequest); ok {
+			return m.getPolicies(req)
+		}
+	}
+
+	return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+}
+
+// GetSTRHistory returns the range of verified STRs requested in req,
+// following the semantics of directory.GetSTRHistory().
+// A start epoch older than the oldest STR the mirror keeps in memory
+// is considered malformed, and causes GetSTRHistory() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+func (m *ConiksMirror) GetSTRHistory(req *protocol.STRHistoryRequest) *protocol.Response {
+	first := m.history[0].Epoch
+	startEp, endEp, ok := req.Range(m.aud.VerifiedSTR().Epoch)
+	if !ok || startEp < first {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	return protocol.NewSTRHistoryRange(
+		m.history[startEp-first : endEp-first+1])
+}
+
+// keyLookup serves a verified proof of inclusion for uname from the
+// cache if possible, and otherwise forwards the lookup to the primary.
+func (m *ConiksMirror) keyLookup(req *protocol.Request, uname string) *protocol.Response {
+	m.cacheLock.Lock()
+	res, ok := m.cache[uname]
+	m.cacheLock.Unlock()
+	if ok {
+		return res
+	}
+
+	res = m.forward(req, uname)
+	if res.Error == protocol.ReqSuccess {
+		df := res.DirectoryProof()
+		// only cache proofs of inclusion for the latest verified epoch
+		// since a pending registration may add a TB to other responses
+		if df.AP[0].ProofType() == merkletree.ProofOfInclusion &&
+			df.STR[0].Epoch == m.aud.VerifiedSTR().Epoch {
+			m.cacheLock.Lock()
+			m.cache[uname] = res
+			m.cacheLock.Unlock()
+		}
+	}
+	return res
+}
+
+// getPolicies forwards req to the primary, verifies the primary's
+// policy document against the mirror's verified STR history, and
+// advertises the mirror in the response (see protocol.MirrorMetadata).
+// If the document cannot be verified, getPolicies() returns
+// a message.NewErrorResponse(ErrDirectory).
+func (m *ConiksMirror) getPolicies(req *protocol.Request) *protocol.Response {
+	response := m.relay(req)
+	if err := response.Validate(); err != nil {
+		return response
+	}
+	p := response.PolicyDocumentProof()
+	if p == nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	if err := m.verifyPolicies(p); err != nil {
+		m.Logger().Error(err.Error(), "primary", m.primary,
+			"request type", req.Type)
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	p.Mirror = &protocol.MirrorMetadata{
+		Primary:       m.primary,
+		VerifiedEpoch: m.aud.VerifiedSTR().Epoch,
+	}
+	return response
+}
+
+// verifyPolicies verifies the STR in p against the mirror's verified
+// STR history, and the primary's signature of the document in p
+// which the STR must commit to.
+func (m *ConiksMirror) verifyPolicies(p *protocol.PolicyDocumentProof) error {
+	if err := m.checkSTR(p.STR); err != nil {
+		return err
+	}
+	if !m.aud.Verify(p.Document.Serialize(), p.Document.Signature) {
+		return protocol.CheckBadSignature
+	}
+	_, err := p.Document.Decode(p.STR)
+	return err
+}
+
+// forward sends req to the primary, and verifies the proofs for uname
+// in the primary's response. If the response cannot be verified,
+// forward() returns a message.NewErrorResponse(ErrDirectory).
+func (m *ConiksMirror) forward(req *protocol.Request, uname string) *protocol.Response {
+	response := m.relay(req)
+	if err := response.Validate(); err != nil {
+		return response
+	}
+	df := response.DirectoryProof()
+	if err := m.verifyProof(uname, df); err != nil {
+		m.Logger().Error(err.Error(), "primary", m.primary,
+			"request type", req.Type)
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	return response
+}
+
+// verifyProof verifies each authentication path in df for uname
+// against the corresponding STR in df, and each STR in df against
+// the mirror's verified STR history.
+// If the primary has omitted the STRs a client already knows from
+// a monitoring proof, the authentication paths for these epochs are
+// verified against the STRs in the mirror's history instead.
+func (m *ConiksMirror) verifyProof(uname string, df *protocol.DirectoryProof) error {
+	if len(df.STR) == 0 || len(df.AP) < len(df.STR) {
+		return protocol.ErrMalformedMessage
+	}
+	omitted := uint64(len(df.AP) - len(df.STR))
+	strs := df.STR
+	if omitted > 0 {
+		first := m.history[0].Epoch
+		start := df.STR[0].Epoch - omitted
+		if df.STR[0].Epoch < omitted || start < first ||
+			df.STR[0].Epoch > m.aud.VerifiedSTR().Epoch+1 {
+			return protocol.ErrMalformedMessage
+		}
+		strs = append(append([]*protocol.DirSTR{},
+			m.history[start-first:start-first+omitted]...), df.STR...)
+	}
+	for i, str := range strs {
+		if err := m.checkSTR(str); err != nil {
+			return err
+		}
+		hasher, err := str.Policies.Hasher()
+		if err != nil || len(str.TreeHash) != hasher.Size() {
+			return protocol.CheckUnsupportedSTR
+		}
+		ap := df.AP[i]
+		if !str.Policies.VerifyVrf([]byte(uname), ap.LookupIndex, ap.VrfProof) {
+			return protocol.CheckBadVRFProof
+		}
+		if ap.VerifyWith(hasher, []byte(uname), ap.Leaf.Value, str.TreeHash) != nil {
+			return protocol.CheckBadAuthPath
+		}
+	}
+	return nil
+}
+
+// checkSTR checks that str is the STR of the same epoch in the mirror's
+// verified history, or that str is consistent with the latest verified
+// STR if the primary has issued str after the mirror's latest sync.
+func (m *ConiksMirror) checkSTR(str *protocol.DirSTR) error {
+	first := m.history[0].Epoch
+	latest := m.aud.VerifiedSTR().Epoch
+	switch {
+	case str.Epoch >= first && str.Epoch <= latest:
+		if !reflect.DeepEqual(m.history[str.Epoch-first], str) {
+			return protocol.CheckBadSTR
+		}
+		return nil
+	default:
+		return m.aud.CheckSTRAgainstVerified(str)
+	}
+}
+
+// relay sends req to the primary and returns the primary's response,
+// or a message.NewErrorResponse(ErrDirectory) if the primary
+// cannot be reached.
+func (m *ConiksMirror) relay(req *protocol.Request) *protocol.Response {
+	msg, err := application.MarshalRequest(req.Type, req.Request)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	res, err := m.send(msg)
+	if err != nil {
+		m.Logger().Error(err.Error(), "primary", m.primary)
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	return application.UnmarshalResponse(req.Type, res)
+}
+
+// sendToPrimary sends msg to the primary key server.
+func (m *ConiksMirror) sendToPrimary(msg []byte) ([]byte, error) {
+	return application.SendRequest(msg, m.primary, nil, nil)
+}
@@ -0,0 +1,222 @@
+package mirror
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestMirror creates a mirror of the directory d, which is
+// reached directly instead of over the network.
+func newTestMirror(t *testing.T, d *directory.ConiksDirectory) *ConiksMirror {
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	initSTR := testutil.JSONSTR(t, d.LatestSTR())
+	conf := &Config{
+		CommonConfig: &application.CommonConfig{
+			Logger: &application.LoggerConfig{
+				Environment: "development",
+			},
+		},
+		SigningPubKey:       pk,
+		InitSTR:             initSTR,
+		LoadedHistoryLength: 100,
+		SyncInterval:        60,
+	}
+	m := NewConiksMirror(conf)
+	m.send = func(msg []byte) ([]byte, error) {
+		req, err := application.UnmarshalRequest(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res *protocol.Response
+		switch req.Type {
+		case protocol.KeyLookupType:
+			res = d.KeyLookup(req.Request.(*protocol.KeyLookupRequest))
+		case protocol.STRType:
+			res = d.GetSTRHistory(req.Request.(*protocol.STRHistoryRequest))
+		case protocol.MonitoringType:
+			res = d.Monitor(req.Request.(*protocol.MonitoringRequest))
+		case protocol.PoliciesType:
+			res = d.GetPolicies(req.Request.(*protocol.PoliciesRequest))
+		default:
+			t.Fatal("Unexpected request type", req.Type)
+		}
+		return application.MarshalResponse(res)
+	}
+	return m
+}
+
+func TestMirrorSync(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	m := newTestMirror(t, d)
+
+	// more STRs than a single fetch returns, all in the directory's
+	// history of 10 snapshots
+	N := clientapp.MaxSTRsPerFetch + 1
+	for i := 0; i < N; i++ {
+		d.Update()
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if m.aud.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect epoch", d.LatestSTR().Epoch, "got", m.aud.VerifiedSTR().Epoch)
+	}
+
+	res := m.HandleRequests(&protocol.Request{
+		Type:    protocol.STRType,
+		Request: &protocol.STRHistoryRequest{StartEpoch: 2, EndEpoch: 100},
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	strs := res.STRHistoryRange().STR
+	if uint64(len(strs)) != d.LatestSTR().Epoch-1 || strs[0].Epoch != 2 {
+		t.Fatal("Unexpected STR history range")
+	}
+}
+
+func TestMirrorSyncDetectsFork(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	m := newTestMirror(t, d)
+	d.Update()
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a different directory with the same keys forks the history
+	fork := directory.NewTestDirectory(t)
+	fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	fork.Update()
+	m.send = newTestMirror(t, fork).send
+	if err := m.Sync(); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
+
+func TestMirrorKeyLookup(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	m := newTestMirror(t, d)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: "alice"},
+	}
+	res := m.HandleRequests(req)
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if _, ok := m.cache["alice"]; !ok {
+		t.Fatal("Expect the proof of inclusion to be cached")
+	}
+
+	// the primary issues a new epoch the mirror hasn't synced yet
+	d.Update()
+	req.Request = &protocol.KeyLookupRequest{Username: "bob"}
+	if res := m.HandleRequests(req); res.Error != protocol.ReqNameNotFound {
+		t.Fatal("Expect", protocol.ReqNameNotFound, "got", res.Error)
+	}
+
+	// registrations are never accepted
+	res = m.HandleRequests(&protocol.Request{
+		Type:    protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{Username: "bob", Key: []byte("key")},
+	})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestMirrorMonitoringKnownSTRs(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	// the static initial STR doesn't commit to the directory's tree
+	d.Update()
+	m := newTestMirror(t, d)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the omitted STRs are taken from the mirror's history
+	res := m.HandleRequests(&protocol.Request{
+		Type: protocol.MonitoringType,
+		Request: &protocol.MonitoringRequest{
+			Username:   "alice",
+			StartEpoch: 1,
+			EndEpoch:   4,
+			KnownEpoch: 3,
+		},
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	df := res.DirectoryProof()
+	if len(df.AP) != 4 || len(df.STR) != 1 {
+		t.Fatal("Unexpected monitoring proof")
+	}
+}
+
+func TestMirrorPolicies(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	m := newTestMirror(t, d)
+	d.PublishPolicyDocument(false)
+	d.Update()
+
+	req := &protocol.Request{
+		Type:    protocol.PoliciesType,
+		Request: &protocol.PoliciesRequest{},
+	}
+	// the STR committing to the document hasn't been synced yet,
+	// but is consistent with the mirror's verified history
+	res := m.HandleRequests(req)
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	res = m.HandleRequests(req)
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	p := res.PolicyDocumentProof()
+	if p.Mirror == nil || p.Mirror.Primary != m.primary ||
+		p.Mirror.VerifiedEpoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the mirror to be advertised, got", p.Mirror)
+	}
+	if _, err := p.Document.Decode(p.STR); err != nil {
+		t.Fatal(err)
+	}
+
+	// the primary's own responses don't carry the metadata
+	if d.GetPolicies(&protocol.PoliciesRequest{}).PolicyDocumentProof().Mirror != nil {
+		t.Fatal("Expect the primary's document not to be altered")
+	}
+
+	// a document which the STR doesn't commit to is rejected
+	m.send = func(msg []byte) ([]byte, error) {
+		res := d.GetPolicies(&protocol.PoliciesRequest{})
+		p := *res.PolicyDocumentProof()
+		doc := *p.Document
+		doc.Document = append([]byte(nil), doc.Document...)
+		doc.Document[0] ^= 1
+		p.Document = &doc
+		return application.MarshalResponse(protocol.NewPoliciesResponse(&p))
+	}
+	if res := m.HandleRequests(req); res.Error != protocol.ErrDirectory {
+		t.Fatal("Expect", protocol.ErrDirectory, "got", res.Error)
+	}
+}
@@ -0,0 +1,126 @@
+package monitor
+
+import (
+	"fmt"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A Binding is a binding the user registered, which the monitor
+// monitors: the user's name and key, as registered with the CONIKS
+// client, and the name of the client's directory in which the user
+// registered it. If Directory is empty, the binding belongs to the
+// client's default directory.
+type Binding struct {
+	Name      string `toml:"name"`
+	Key       string `toml:"key"`
+	Directory string `toml:"directory,omitempty"`
+}
+
+// AlertConfig specifies how the monitor raises its alerts (see Alert).
+// If WebhookURL is set, the monitor posts the JSON-encoded alert to
+// this URL. If Command is set, the monitor runs it with "sh -c",
+// passing the alert's message on the command's standard input and in
+// the CONIKS_ALERT environment variable, e.g.,
+// `notify-send "CONIKS" "$CONIKS_ALERT"` or `mail -s "CONIKS alert" alice`.
+type AlertConfig struct {
+	WebhookURL string `toml:"webhook_url,omitempty"`
+	Command    string `toml:"command,omitempty"`
+}
+
+// A Config contains configuration values
+// which are read at initialization time from
+// a TOML format configuration file.
+type Config struct {
+	*application.CommonConfig
+	// ClientConfigPath is the path to the configuration of the CONIKS
+	// client which configures the monitored directories,
+	// and Client is the parsed configuration.
+	ClientConfigPath string            `toml:"client_config_path"`
+	Client           *clientapp.Config `toml:"-"`
+	// Bindings lists the bindings the monitor monitors.
+	Bindings []*Binding `toml:"bindings"`
+	// Interval is the interval in seconds at which the monitor
+	// monitors the bindings. It should match the directories'
+	// epoch deadline.
+	Interval protocol.Timestamp `toml:"interval"`
+	// Alerts specifies how the monitor raises its alerts.
+	Alerts AlertConfig `toml:"alerts"`
+}
+
+var _ application.AppConfig = (*Config)(nil)
+
+// NewConfig initializes a new monitor configuration at the given
+// file path, with the given config encoding, the client's configuration
+// path, the monitored bindings, logger configuration, monitoring
+// interval and alert settings.
+func NewConfig(file, encoding, clientConfigPath string, bindings []*Binding,
+	logConfig *application.LoggerConfig, interval protocol.Timestamp,
+	alerts AlertConfig) *Config {
+	var conf = Config{
+		CommonConfig:     application.NewCommonConfig(file, encoding, logConfig),
+		ClientConfigPath: clientConfigPath,
+		Bindings:         bindings,
+		Interval:         interval,
+		Alerts:           alerts,
+	}
+
+	return &conf
+}
+
+// Load initializes a monitor configuration at the given file path
+// using the given encoding.
+// It loads the client's configuration, and checks that each binding
+// has a name and a key, and belongs to one of the client's directories.
+func (conf *Config) Load(file, encoding string) error {
+	conf.CommonConfig = application.NewCommonConfig(file, encoding, nil)
+	if err := conf.GetLoader().Decode(conf); err != nil {
+		return err
+	}
+	if conf.Interval == 0 {
+		return fmt.Errorf("The monitor requires a positive interval")
+	}
+
+	// load the client's configuration
+	conf.Client = new(clientapp.Config)
+	if err := conf.Client.Load(utils.ResolvePath(conf.ClientConfigPath, file),
+		"toml"); err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, dir := range conf.Client.AllDirectories() {
+		dirs[dir.Name] = true
+	}
+	for _, b := range conf.Bindings {
+		if b.Directory == "" {
+			b.Directory = conf.Client.Name
+		}
+		switch {
+		case b.Name == "" || b.Key == "":
+			return fmt.Errorf("A monitored binding requires a name and a key")
+		case !dirs[b.Directory]:
+			return fmt.Errorf("Unknown directory of the binding %q: %q",
+				b.Name, b.Directory)
+		}
+	}
+
+	// logger config
+	if conf.Logger != nil {
+		conf.Logger.Path = utils.ResolvePath(conf.Logger.Path, file)
+	}
+
+	return nil
+}
+
+// Save writes a monitor's configuration.
+func (conf *Config) Save() error {
+	return conf.GetLoader().Encode(conf)
+}
+
+// GetPath returns the monitor's configuration file path.
+func (conf *Config) GetPath() string {
+	return conf.Path
+}
@@ -0,0 +1,14 @@
+/*
+Package monitor implements a long-running CONIKS monitor, which
+monitors the bindings a user registered on the user's behalf.
+
+The monitor follows the directories of a CONIKS client's configuration.
+Every epoch, it sends a monitoring request for each of the user's
+bindings to its directory, and verifies that the directory has kept
+the binding unchanged and has not equivocated since the last epoch it
+monitored. Whenever a binding fails to verify, or the directory can't
+be reached, the monitor raises an Alert: it posts the alert to a
+webhook and runs a command, e.g. to show a desktop notification or
+to send an email.
+*/
+package monitor
@@ -0,0 +1,201 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+)
+
+// An Alert reports that the monitor failed to verify the binding
+// of Username in the directory Directory: Error is either the
+// consistency check error of the directory's response, or the error
+// which occurred while reaching the directory. Epoch is the latest
+// epoch of the directory the monitor has verified.
+type Alert struct {
+	Directory string
+	Username  string
+	Epoch     uint64
+	Error     string
+}
+
+// String returns the alert's message.
+func (a *Alert) String() string {
+	return fmt.Sprintf("Monitoring %q in the CONIKS directory %q failed after epoch %d: %s",
+		a.Username, a.Directory, a.Epoch, a.Error)
+}
+
+// A ConiksMonitor monitors the bindings of a user in the user's
+// CONIKS directories every epoch, and raises an Alert whenever
+// a binding fails to verify.
+type ConiksMonitor struct {
+	*application.ServerBase
+	dirs     clientapp.Directories
+	monitors map[string]*client.Monitor
+	bindings []*Binding
+	alerts   AlertConfig
+	timer    *application.EpochTimer
+	send     func(dir *clientapp.Directory, msg []byte) ([]byte, error)
+	notify   func(a *Alert) error
+}
+
+// NewConiksMonitor creates a new monitor of the bindings
+// specified in conf. Each directory's bindings are watched by a
+// client.Monitor of the directory's consistency state, from the
+// directory's initial STR.
+func NewConiksMonitor(conf *Config) *ConiksMonitor {
+	m := &ConiksMonitor{
+		ServerBase: application.NewServerBase(conf.CommonConfig,
+			"Monitoring", nil),
+		dirs:     clientapp.NewDirectories(conf.Client),
+		monitors: make(map[string]*client.Monitor),
+		bindings: conf.Bindings,
+		alerts:   conf.Alerts,
+		timer:    application.NewEpochTimer(conf.Interval),
+	}
+	for _, b := range conf.Bindings {
+		cm, ok := m.monitors[b.Directory]
+		if !ok {
+			cm = m.newMonitor(m.dirs[b.Directory])
+			m.monitors[b.Directory] = cm
+		}
+		cm.Watch(b.Name, []byte(b.Key))
+	}
+	m.send = sendToDirectory
+	m.notify = m.raise
+	return m
+}
+
+// newMonitor creates a client.Monitor of the consistency state of dir,
+// which sends its monitoring requests to dir using m.send.
+func (m *ConiksMonitor) newMonitor(dir *clientapp.Directory) *client.Monitor {
+	return client.NewMonitor(dir.CC,
+		func(r *protocol.MonitoringRequest) (*protocol.Response, error) {
+			msg, err := clientapp.CreateMonitoringMsg(r.Username,
+				r.StartEpoch, r.EndEpoch, r.KnownEpoch)
+			if err != nil {
+				return nil, err
+			}
+			res, err := m.send(dir, msg)
+			if err != nil {
+				return nil, err
+			}
+			return application.UnmarshalResponse(protocol.MonitoringType, res), nil
+		}, nil)
+}
+
+// Run monitors the bindings once, and then every epoch
+// in the background.
+func (m *ConiksMonitor) Run() {
+	m.Check()
+	m.RunInBackground(func() {
+		m.EpochUpdate(m.timer, func() error {
+			// a failed binding is monitored again at the next epoch
+			m.Check()
+			return nil
+		})
+	})
+}
+
+// Check monitors the bindings of each directory up to its latest
+// epoch (see client.Monitor.Check()), and raises an Alert for each
+// binding which fails to verify. It returns the raised alerts.
+func (m *ConiksMonitor) Check() []*Alert {
+	var names []string
+	for name := range m.monitors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var alerts []*Alert
+	for _, name := range names {
+		cm := m.monitors[name]
+		failed := make(map[string]bool)
+		for _, v := range cm.Check() {
+			a := &Alert{
+				Directory: name,
+				Username:  v.Name,
+				Epoch:     v.Epoch,
+				Error:     v.Err.Error(),
+			}
+			m.Logger().Error(a.String())
+			if err := m.notify(a); err != nil {
+				m.Logger().Error("Cannot raise the alert", "error", err.Error())
+			}
+			alerts = append(alerts, a)
+			failed[v.Name] = true
+		}
+		for _, b := range m.bindings {
+			if b.Directory != name || failed[b.Name] {
+				continue
+			}
+			str, _ := cm.Monitored(b.Name)
+			m.Logger().Info("Monitored binding", "username", b.Name,
+				"directory", b.Directory, "epoch", str.Epoch)
+		}
+	}
+	return alerts
+}
+
+// raise posts the alert a to the configured webhook, and runs the
+// configured command, if any. It returns the first error which
+// occurred, after trying both.
+func (m *ConiksMonitor) raise(a *Alert) error {
+	var errs []error
+	if m.alerts.WebhookURL != "" {
+		errs = append(errs, postAlert(m.alerts.WebhookURL, a))
+	}
+	if m.alerts.Command != "" {
+		errs = append(errs, runAlertCommand(m.alerts.Command, a))
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postAlert posts the JSON-encoded alert a to the webhook url.
+func postAlert(url string, a *Alert) error {
+	buf, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	res, err := http.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("Webhook replied %s", res.Status)
+	}
+	return nil
+}
+
+// runAlertCommand runs the shell command cmd, passing the message of
+// the alert a on its standard input and in the CONIKS_ALERT
+// environment variable.
+func runAlertCommand(cmd string, a *Alert) error {
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdin = strings.NewReader(a.String() + "\n")
+	c.Env = append(os.Environ(), "CONIKS_ALERT="+a.String())
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("Alert command failed: %v: %s", err, out)
+	}
+	return nil
+}
+
+// sendToDirectory sends msg to the address of dir, resolving its host
+// name under the directory's resolution policy.
+func sendToDirectory(dir *clientapp.Directory, msg []byte) ([]byte, error) {
+	return application.SendRequest(msg, dir.Address, nil, dir.Resolution)
+}
@@ -0,0 +1,172 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestMonitor creates a monitor of the given bindings in the
+// directory d, which is reached directly instead of over the network.
+// The alerts the monitor raises are appended to alerts.
+func newTestMonitor(t *testing.T, d *directory.ConiksDirectory,
+	alerts *[]*Alert, bindings ...*Binding) *ConiksMonitor {
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	initSTR := testutil.JSONSTR(t, d.LatestSTR())
+	client := &clientapp.Config{
+		DirectoryConfig: clientapp.DirectoryConfig{
+			Name:          clientapp.DefaultDirectoryName,
+			SigningPubKey: pk,
+			InitSTR:       initSTR,
+		},
+	}
+	for _, b := range bindings {
+		b.Directory = clientapp.DefaultDirectoryName
+	}
+	conf := &Config{
+		CommonConfig: &application.CommonConfig{
+			Logger: &application.LoggerConfig{
+				Environment: "development",
+			},
+		},
+		Client:   client,
+		Bindings: bindings,
+		Interval: 60,
+	}
+	m := NewConiksMonitor(conf)
+	m.send = func(dir *clientapp.Directory, msg []byte) ([]byte, error) {
+		req, err := application.UnmarshalRequest(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Type != protocol.MonitoringType {
+			t.Fatal("Unexpected request type", req.Type)
+		}
+		return application.MarshalResponse(d.Monitor(req.Request.(*protocol.MonitoringRequest)))
+	}
+	m.notify = func(a *Alert) error {
+		*alerts = append(*alerts, a)
+		return nil
+	}
+	return m
+}
+
+func TestMonitorBindings(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	// the static initial STR doesn't commit to the directory's tree
+	d.Update()
+	var alerts []*Alert
+	m := newTestMonitor(t, d, &alerts,
+		&Binding{Name: "alice", Key: "key"},
+		&Binding{Name: "bob", Key: "key"})
+
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	for round := 0; round < 3; round++ {
+		d.Update()
+		d.Update()
+		if round == 1 {
+			d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+		}
+		if got := m.Check(); len(got) != 0 {
+			t.Fatal("Expect no alerts, got", got[0])
+		}
+	}
+	for _, b := range m.bindings {
+		from, included := m.monitors[b.Directory].Monitored(b.Name)
+		if from.Epoch != d.LatestSTR().Epoch || !included {
+			t.Error(b.Name, "expect to be included and monitored up to epoch",
+				d.LatestSTR().Epoch, "got", included, from.Epoch)
+		}
+	}
+	if len(alerts) != 0 {
+		t.Fatal("Expect no alerts, got", alerts[0])
+	}
+}
+
+func TestMonitorAlerts(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	// the static initial STR doesn't commit to the directory's tree
+	d.Update()
+	var alerts []*Alert
+	m := newTestMonitor(t, d, &alerts, &Binding{Name: "alice", Key: "key"})
+
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	if got := m.Check(); len(got) != 0 {
+		t.Fatal("Expect no alerts, got", got[0])
+	}
+
+	// the directory can't be reached
+	send := m.send
+	m.send = func(dir *clientapp.Directory, msg []byte) ([]byte, error) {
+		return nil, errors.New("connection refused")
+	}
+	d.Update()
+	if got := m.Check(); len(got) != 1 || got[0].Error != "connection refused" {
+		t.Fatal("Expect an alert for the unreachable directory, got", got)
+	}
+
+	// the directory binds alice to another key
+	m.send = send
+	m.monitors[clientapp.DefaultDirectoryName].Watch("alice", []byte("another key"))
+	d.Update()
+	got := m.Check()
+	if len(got) != 1 || got[0].Username != "alice" ||
+		got[0].Directory != clientapp.DefaultDirectoryName ||
+		got[0].Error != protocol.CheckBindingsDiffer.Error() {
+		t.Fatal("Expect an alert for alice, got", got)
+	}
+	if len(alerts) != 2 || alerts[1] != got[0] {
+		t.Fatal("Expect the alerts to be raised, got", alerts)
+	}
+}
+
+func TestRaiseAlert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var posted Alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	out := path.Join(dir, "alert")
+	m := &ConiksMonitor{alerts: AlertConfig{
+		WebhookURL: ts.URL,
+		Command:    `cat > "` + out + `" && printf %s "$CONIKS_ALERT" >> "` + out + `"`,
+	}}
+	a := &Alert{Directory: "default", Username: "alice", Epoch: 2, Error: "bad"}
+	if err := m.raise(a); err != nil {
+		t.Fatal(err)
+	}
+	if posted != *a {
+		t.Error("Expect the webhook to receive", a, "got", posted)
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := a.String() + "\n" + a.String(); string(buf) != want {
+		t.Error("Expect the command to receive", want, "got", string(buf))
+	}
+
+	m.alerts = AlertConfig{Command: "exit 1"}
+	if err := m.raise(a); err == nil {
+		t.Error("Expect the failed command to be reported")
+	}
+}
@@ -0,0 +1,61 @@
+package application
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// The request permission classes group the request types by the party
+// which sends them and the server which handles them, so that each
+// listener of a server can accept the requests of some parties only.
+var (
+	// ClientLookupRequests are the requests which CONIKS clients send
+	// to a directory to look up and monitor keys.
+	ClientLookupRequests = []int{
+		protocol.KeyLookupType,
+		protocol.BatchKeyLookupType,
+		protocol.KeyLookupInEpochType,
+		protocol.MonitoringType,
+		protocol.KeyHistoryType,
+		protocol.PoliciesType,
+		protocol.EmptyRangeType,
+		protocol.SubtreeType,
+	}
+	// AuditorRequests are the requests which auditors and mirrors send
+	// to a directory to follow its STR history and the changes of its
+	// tree, and to sample its tree. Clients send them as well to fetch
+	// the STRs of past epochs.
+	AuditorRequests = []int{
+		protocol.STRType,
+		protocol.SampleType,
+		protocol.EpochDeltaType,
+	}
+	// AuditingRequests are the requests which CONIKS clients send to
+	// an auditor to fetch and cross-check a directory's STRs.
+	AuditingRequests = []int{
+		protocol.AuditType,
+		protocol.ObservationReportType,
+	}
+	// PushRequests are the requests which directories send to an
+	// auditor to push their new STRs.
+	PushRequests = []int{
+		protocol.STRPushType,
+	}
+	// GossipRequests are the requests which auditors send to the other
+	// auditors of the same directories to exchange the STRs they
+	// have observed.
+	GossipRequests = []int{
+		protocol.GossipType,
+	}
+)
+
+// Permissions returns the request permissions of a listener which
+// accepts the requests of the given classes, and no other request.
+func Permissions(classes ...[]int) map[int]bool {
+	perms := make(map[int]bool)
+	for _, class := range classes {
+		for _, reqType := range class {
+			perms[reqType] = true
+		}
+	}
+	return perms
+}
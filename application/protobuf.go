@@ -0,0 +1,824 @@
+// Implements the protobuf encoding of the messages between clients and
+// servers (see coniks.proto), which a server uses at the addresses
+// configured for it (see ServerAddress.Encoding). The proofs consist
+// mostly of hashes, which the JSON encoding expands into base64
+// strings, so that their protobuf encoding is much smaller.
+
+package application
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// These are the encodings of the messages a server exchanges at an
+// address, and a client with a directory. The empty encoding is
+// JSONEncoding.
+const (
+	JSONEncoding     = "json"
+	ProtobufEncoding = "protobuf"
+)
+
+// ValidateEncoding returns an error if encoding isn't one of the
+// message encodings.
+func ValidateEncoding(encoding string) error {
+	switch encoding {
+	case "", JSONEncoding, ProtobufEncoding:
+		return nil
+	default:
+		return fmt.Errorf("Unknown message encoding: %q", encoding)
+	}
+}
+
+// MarshalRequestWith returns the encoding of the client's request in
+// the message encoding encoding.
+func MarshalRequestWith(encoding string, reqType int, request interface{}) ([]byte, error) {
+	if encoding == ProtobufEncoding {
+		return MarshalRequestPB(reqType, request)
+	}
+	return MarshalRequest(reqType, request)
+}
+
+// UnmarshalRequestWith decodes the request msg encoded in the message
+// encoding encoding.
+func UnmarshalRequestWith(encoding string, msg []byte) (*protocol.Request, error) {
+	if encoding == ProtobufEncoding {
+		return UnmarshalRequestPB(msg)
+	}
+	return UnmarshalRequest(msg)
+}
+
+// MarshalResponseWith returns the encoding of the server's response
+// in the message encoding encoding.
+func MarshalResponseWith(encoding string, response *protocol.Response) ([]byte, error) {
+	if encoding == ProtobufEncoding {
+		return MarshalResponsePB(response)
+	}
+	return MarshalResponse(response)
+}
+
+// UnmarshalResponseWith decodes the response msg to the request type
+// t encoded in the message encoding encoding.
+func UnmarshalResponseWith(encoding string, t int, msg []byte) *protocol.Response {
+	if encoding == ProtobufEncoding {
+		return UnmarshalResponsePB(t, msg)
+	}
+	return UnmarshalResponse(t, msg)
+}
+
+// MarshalRequestPB returns a protobuf encoding of the client's request.
+func MarshalRequestPB(reqType int, request interface{}) ([]byte, error) {
+	e := new(pbEncoder)
+	e.int(1, int64(reqType))
+	switch req := request.(type) {
+	case *protocol.KeyLookupRequest:
+		e.message(2, func(e *pbEncoder) {
+			e.string(1, req.Username)
+		})
+	case *protocol.KeyLookupInEpochRequest:
+		e.message(3, func(e *pbEncoder) {
+			e.string(1, req.Username)
+			e.uint(2, req.Epoch)
+		})
+	case *protocol.MonitoringRequest:
+		e.message(4, func(e *pbEncoder) {
+			e.string(1, req.Username)
+			e.uint(2, req.StartEpoch)
+			e.uint(3, req.EndEpoch)
+			e.uint(4, req.KnownEpoch)
+		})
+	default:
+		content, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		e.bytes(15, content)
+	}
+	return e.buf, nil
+}
+
+// UnmarshalRequestPB parses a protobuf-encoded request msg and creates
+// the corresponding protocol.Request, which will be handled by the
+// server. As UnmarshalRequest(), it returns an error describing why
+// msg is malformed if its request type is unknown, if its content
+// doesn't match its request type, or if msg includes a field which its
+// request type doesn't have.
+func UnmarshalRequestPB(msg []byte) (*protocol.Request, error) {
+	req := new(protocol.Request)
+	var content *pbField
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			var t int64
+			t, err = f.int()
+			req.Type = int(t)
+		case 2, 3, 4, 15:
+			content = f
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Malformed request: %v", err)
+	}
+	request := newRequest(req.Type)
+	if request == nil {
+		return nil, fmt.Errorf("Malformed request: unknown request type %d", req.Type)
+	}
+	if content == nil {
+		return nil, errors.New("Malformed request: no request content")
+	}
+	switch r := request.(type) {
+	case *protocol.KeyLookupRequest:
+		err = content.expect(2)
+		if err == nil {
+			err = decodePB(content.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					r.Username, err = f.string()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		}
+	case *protocol.KeyLookupInEpochRequest:
+		err = content.expect(3)
+		if err == nil {
+			err = decodePB(content.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					r.Username, err = f.string()
+				case 2:
+					r.Epoch, err = f.uint()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		}
+	case *protocol.MonitoringRequest:
+		err = content.expect(4)
+		if err == nil {
+			err = decodePB(content.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					r.Username, err = f.string()
+				case 2:
+					r.StartEpoch, err = f.uint()
+				case 3:
+					r.EndEpoch, err = f.uint()
+				case 4:
+					r.KnownEpoch, err = f.uint()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		}
+	default:
+		err = content.expect(15)
+		if err == nil {
+			err = decodeStrict(content.b, request)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Malformed request of type %d: %v", req.Type, err)
+	}
+	req.Request = request
+	return req, nil
+}
+
+// MarshalResponsePB returns a protobuf encoding of the server's
+// response.
+func MarshalResponsePB(response *protocol.Response) ([]byte, error) {
+	e := new(pbEncoder)
+	e.int(1, int64(response.Error))
+	switch res := response.DirectoryResponse.(type) {
+	case nil:
+	case *protocol.DirectoryProof:
+		e.message(2, func(e *pbEncoder) {
+			encodeDirectoryProof(e, res)
+		})
+	default:
+		content, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		e.bytes(15, content)
+	}
+	return e.buf, nil
+}
+
+// UnmarshalResponsePB decodes the protobuf-encoded message msg into
+// a protocol.Response according to the given request type t, as
+// UnmarshalResponse() decodes a JSON-encoded message.
+func UnmarshalResponsePB(t int, msg []byte) *protocol.Response {
+	malformed := &protocol.Response{
+		Error: protocol.ErrMalformedMessage,
+	}
+	res := new(protocol.Response)
+	var content *pbField
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			var code int64
+			code, err = f.int()
+			res.Error = protocol.ErrorCode(code)
+		case 2, 15:
+			content = f
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	if err != nil {
+		return malformed
+	}
+
+	if content == nil {
+		err := res.Validate()
+		return &protocol.Response{
+			Error: err.(protocol.ErrorCode),
+		}
+	}
+	if content.num == 15 {
+		res.DirectoryResponse, err = unmarshalDirectoryResponse(t, content.b)
+	} else if isDirectoryProofType(t) {
+		res.DirectoryResponse, err = decodeDirectoryProof(content.b)
+	} else {
+		err = content.unknown()
+	}
+	if err != nil {
+		return malformed
+	}
+	return res
+}
+
+func encodeDirectoryProof(e *pbEncoder, df *protocol.DirectoryProof) {
+	for _, ap := range df.AP {
+		e.message(1, func(e *pbEncoder) {
+			encodeAuthPath(e, ap)
+		})
+	}
+	for _, str := range df.STR {
+		e.message(2, func(e *pbEncoder) {
+			encodeDirSTR(e, str)
+		})
+	}
+	if tb := df.TB; tb != nil {
+		e.message(3, func(e *pbEncoder) {
+			e.bytes(1, tb.Index)
+			e.bytes(2, tb.Value)
+			e.uint(3, tb.IssuedEpoch)
+			e.uint(4, tb.InclusionEpoch)
+			e.bytes(5, tb.PreviousValue)
+			e.bytes(6, tb.DirInitSTRHash[:])
+			e.bytes(7, tb.Signature)
+		})
+	}
+	if next := df.Continuation; next != nil {
+		e.message(4, func(e *pbEncoder) {
+			e.uint(1, next.NextEpoch)
+		})
+	}
+	if tp := df.Transition; tp != nil {
+		e.message(5, func(e *pbEncoder) {
+			e.uint(1, tp.Epoch)
+			if tp.STR != nil {
+				e.message(2, func(e *pbEncoder) {
+					encodeDirSTR(e, tp.STR)
+				})
+			}
+			if tp.Absence != nil {
+				e.message(3, func(e *pbEncoder) {
+					encodeAuthPath(e, tp.Absence)
+				})
+			}
+			if tp.Inclusion != nil {
+				e.message(4, func(e *pbEncoder) {
+					encodeAuthPath(e, tp.Inclusion)
+				})
+			}
+		})
+	}
+}
+
+func decodeDirectoryProof(msg []byte) (*protocol.DirectoryProof, error) {
+	df := new(protocol.DirectoryProof)
+	err := decodePB(msg, func(f *pbField) error {
+		if err := f.expectBytes(); err != nil {
+			return err
+		}
+		switch f.num {
+		case 1:
+			ap, err := decodeAuthPath(f.b)
+			df.AP = append(df.AP, ap)
+			return err
+		case 2:
+			str, err := decodeDirSTR(f.b)
+			df.STR = append(df.STR, str)
+			return err
+		case 3:
+			df.TB = new(protocol.TemporaryBinding)
+			return decodeTB(f.b, df.TB)
+		case 4:
+			df.Continuation = new(protocol.Continuation)
+			return decodePB(f.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					df.Continuation.NextEpoch, err = f.uint()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		case 5:
+			df.Transition = new(protocol.TransitionProof)
+			return decodeTransitionProof(f.b, df.Transition)
+		default:
+			return f.unknown()
+		}
+	})
+	return df, err
+}
+
+func decodeTB(msg []byte, tb *protocol.TemporaryBinding) error {
+	return decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			tb.Index, err = f.bytes()
+		case 2:
+			tb.Value, err = f.bytes()
+		case 3:
+			tb.IssuedEpoch, err = f.uint()
+		case 4:
+			tb.InclusionEpoch, err = f.uint()
+		case 5:
+			tb.PreviousValue, err = f.bytes()
+		case 6:
+			if err = f.expectBytes(); err == nil && len(f.b) != len(tb.DirInitSTRHash) {
+				err = fmt.Errorf("field %d has a wrong length", f.num)
+			}
+			copy(tb.DirInitSTRHash[:], f.b)
+		case 7:
+			tb.Signature, err = f.bytes()
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+}
+
+func decodeTransitionProof(msg []byte, tp *protocol.TransitionProof) error {
+	return decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			tp.Epoch, err = f.uint()
+		case 2:
+			if err = f.expectBytes(); err == nil {
+				tp.STR, err = decodeDirSTR(f.b)
+			}
+		case 3:
+			if err = f.expectBytes(); err == nil {
+				tp.Absence, err = decodeAuthPath(f.b)
+			}
+		case 4:
+			if err = f.expectBytes(); err == nil {
+				tp.Inclusion, err = decodeAuthPath(f.b)
+			}
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+}
+
+func encodeAuthPath(e *pbEncoder, ap *merkletree.AuthenticationPath) {
+	e.bytes(1, ap.TreeNonce)
+	for _, hash := range ap.PrunedTree {
+		e.bytes(2, hash)
+	}
+	e.bytes(3, ap.LookupIndex)
+	e.bytes(4, ap.VrfProof)
+	if n := ap.Leaf; n != nil {
+		e.message(5, func(e *pbEncoder) {
+			e.uint(1, uint64(n.Level))
+			e.bytes(2, n.Index)
+			e.bytes(3, n.Value)
+			e.bool(4, n.IsEmpty)
+			if c := n.Commitment; c != nil {
+				e.message(5, func(e *pbEncoder) {
+					e.bytes(1, c.Salt)
+					e.bytes(2, c.Value)
+				})
+			}
+		})
+	}
+}
+
+func decodeAuthPath(msg []byte) (*merkletree.AuthenticationPath, error) {
+	ap := new(merkletree.AuthenticationPath)
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			ap.TreeNonce, err = f.bytes()
+		case 2:
+			var hash []byte
+			hash, err = f.bytes()
+			ap.PrunedTree = append(ap.PrunedTree, hash)
+		case 3:
+			ap.LookupIndex, err = f.bytes()
+		case 4:
+			ap.VrfProof, err = f.bytes()
+		case 5:
+			if err = f.expectBytes(); err == nil {
+				ap.Leaf, err = decodeProofNode(f.b)
+			}
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	return ap, err
+}
+
+func decodeProofNode(msg []byte) (*merkletree.ProofNode, error) {
+	n := new(merkletree.ProofNode)
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			n.Level, err = f.uint32()
+		case 2:
+			n.Index, err = f.bytes()
+		case 3:
+			n.Value, err = f.bytes()
+		case 4:
+			n.IsEmpty, err = f.bool()
+		case 5:
+			if err = f.expectBytes(); err != nil {
+				return
+			}
+			n.Commitment = new(crypto.Commit)
+			err = decodePB(f.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					n.Commitment.Salt, err = f.bytes()
+				case 2:
+					n.Commitment.Value, err = f.bytes()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	return n, err
+}
+
+func encodeDirSTR(e *pbEncoder, str *protocol.DirSTR) {
+	if str.SignedTreeRoot != nil {
+		e.bytes(1, str.TreeHash)
+		e.uint(2, str.Epoch)
+		e.uint(3, str.PreviousEpoch)
+		e.bytes(4, str.PreviousSTRHash)
+		e.bytes(5, str.Signature)
+		e.uint(6, uint64(str.Version))
+		names := make([]string, 0, len(str.Extensions))
+		for name := range str.Extensions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ext := str.Extensions[name]
+			e.message(7, func(e *pbEncoder) {
+				e.string(1, name)
+				if ext != nil {
+					e.message(2, func(e *pbEncoder) {
+						e.bool(1, ext.Critical)
+						e.bytes(2, ext.Value)
+					})
+				}
+			})
+		}
+		e.bytes(9, str.CrossSignature)
+	}
+	if p := str.Policies; p != nil {
+		e.message(8, func(e *pbEncoder) {
+			e.string(1, p.Version)
+			e.string(2, p.HashID)
+			e.string(3, p.SaltScheme)
+			e.string(4, string(p.VrfAlgorithm))
+			e.bytes(5, p.VrfPublicKey)
+			e.uint(6, uint64(p.EpochDeadline))
+			e.bytes(7, p.BootstrapHash)
+			e.uint(8, uint64(p.NamespaceBits))
+			if cs := p.CipherSuite; cs != nil {
+				e.message(9, func(e *pbEncoder) {
+					e.string(1, cs.Signature)
+					e.string(2, string(cs.Vrf))
+					e.string(3, cs.Hash)
+				})
+			}
+			e.bytes(10, p.NextSigningKey)
+		})
+	}
+}
+
+func decodeDirSTR(msg []byte) (*protocol.DirSTR, error) {
+	str := &protocol.DirSTR{SignedTreeRoot: new(merkletree.SignedTreeRoot)}
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			str.TreeHash, err = f.bytes()
+		case 2:
+			str.Epoch, err = f.uint()
+		case 3:
+			str.PreviousEpoch, err = f.uint()
+		case 4:
+			str.PreviousSTRHash, err = f.bytes()
+		case 5:
+			str.Signature, err = f.bytes()
+		case 6:
+			str.Version, err = f.uint32()
+		case 7:
+			if err = f.expectBytes(); err == nil {
+				err = decodeExtension(f.b, str.SignedTreeRoot)
+			}
+		case 8:
+			if err = f.expectBytes(); err == nil {
+				str.Policies, err = decodePolicies(f.b)
+			}
+		case 9:
+			str.CrossSignature, err = f.bytes()
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	return str, err
+}
+
+// decodeExtension decodes the map entry msg of the extensions of str.
+func decodeExtension(msg []byte, str *merkletree.SignedTreeRoot) error {
+	var name string
+	var ext *merkletree.STRExtension
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			name, err = f.string()
+		case 2:
+			if err = f.expectBytes(); err != nil {
+				return
+			}
+			ext = new(merkletree.STRExtension)
+			err = decodePB(f.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					ext.Critical, err = f.bool()
+				case 2:
+					ext.Value, err = f.bytes()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	if str.Extensions == nil {
+		str.Extensions = make(map[string]*merkletree.STRExtension)
+	}
+	str.Extensions[name] = ext
+	return err
+}
+
+func decodePolicies(msg []byte) (*protocol.Policies, error) {
+	p := new(protocol.Policies)
+	err := decodePB(msg, func(f *pbField) (err error) {
+		switch f.num {
+		case 1:
+			p.Version, err = f.string()
+		case 2:
+			p.HashID, err = f.string()
+		case 3:
+			p.SaltScheme, err = f.string()
+		case 4:
+			var alg string
+			alg, err = f.string()
+			p.VrfAlgorithm = vrf.Algorithm(alg)
+		case 5:
+			p.VrfPublicKey, err = f.bytes()
+		case 6:
+			var deadline uint64
+			deadline, err = f.uint()
+			p.EpochDeadline = protocol.Timestamp(deadline)
+		case 7:
+			p.BootstrapHash, err = f.bytes()
+		case 8:
+			p.NamespaceBits, err = f.uint32()
+		case 9:
+			if err = f.expectBytes(); err != nil {
+				return
+			}
+			p.CipherSuite = new(protocol.CipherSuite)
+			err = decodePB(f.b, func(f *pbField) (err error) {
+				switch f.num {
+				case 1:
+					p.CipherSuite.Signature, err = f.string()
+				case 2:
+					var alg string
+					alg, err = f.string()
+					p.CipherSuite.Vrf = vrf.Algorithm(alg)
+				case 3:
+					p.CipherSuite.Hash, err = f.string()
+				default:
+					err = f.unknown()
+				}
+				return
+			})
+		case 10:
+			p.NextSigningKey, err = f.bytes()
+		default:
+			err = f.unknown()
+		}
+		return
+	})
+	return p, err
+}
+
+// The wire types of the protobuf fields this encoding uses.
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+// A pbEncoder appends the fields of a protobuf message to buf. The
+// scalar fields with their zero values are omitted, except for the
+// bytes fields, which are only omitted if they are nil (see
+// coniks.proto).
+type pbEncoder struct {
+	buf []byte
+}
+
+func (e *pbEncoder) key(num, wireType int) {
+	e.varint(uint64(num)<<3 | uint64(wireType))
+}
+
+func (e *pbEncoder) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (e *pbEncoder) uint(num int, v uint64) {
+	if v != 0 {
+		e.key(num, pbVarint)
+		e.varint(v)
+	}
+}
+
+func (e *pbEncoder) int(num int, v int64) {
+	e.uint(num, uint64(v))
+}
+
+func (e *pbEncoder) bool(num int, v bool) {
+	if v {
+		e.uint(num, 1)
+	}
+}
+
+func (e *pbEncoder) bytes(num int, v []byte) {
+	if v != nil {
+		e.key(num, pbBytes)
+		e.varint(uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	}
+}
+
+func (e *pbEncoder) string(num int, v string) {
+	if v != "" {
+		e.bytes(num, []byte(v))
+	}
+}
+
+// message appends the embedded message encoded by encode as the field
+// num, even if it is empty.
+func (e *pbEncoder) message(num int, encode func(e *pbEncoder)) {
+	sub := &pbEncoder{buf: []byte{}}
+	encode(sub)
+	e.bytes(num, sub.buf)
+}
+
+// A pbField is a field of a protobuf message: the varint v if its
+// wire type is pbVarint, or the bytes b if it is pbBytes.
+type pbField struct {
+	num      int
+	wireType int
+	v        uint64
+	b        []byte
+}
+
+// decodePB decodes the fields of the protobuf message msg, and passes
+// each of them to f, in order. It returns the first error of f, or an
+// error if msg is malformed.
+func decodePB(msg []byte, f func(*pbField) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 || key>>3 == 0 || key>>3 > math.MaxInt32 {
+			return errors.New("malformed field key")
+		}
+		msg = msg[n:]
+		field := &pbField{num: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case pbVarint:
+			field.v, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return fmt.Errorf("field %d is truncated", field.num)
+			}
+			msg = msg[n:]
+		case pbBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || l > uint64(len(msg)-n) {
+				return fmt.Errorf("field %d is truncated", field.num)
+			}
+			field.b, msg = msg[n:n+int(l)], msg[n+int(l):]
+		default:
+			return fmt.Errorf("field %d has the unsupported wire type %d",
+				field.num, field.wireType)
+		}
+		if err := f(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *pbField) unknown() error {
+	return fmt.Errorf("unknown field %d", f.num)
+}
+
+// expect returns an error unless f is the field num.
+func (f *pbField) expect(num int) error {
+	if f.num != num {
+		return f.unknown()
+	}
+	return f.expectBytes()
+}
+
+func (f *pbField) expectVarint() error {
+	if f.wireType != pbVarint {
+		return fmt.Errorf("field %d isn't a varint", f.num)
+	}
+	return nil
+}
+
+func (f *pbField) expectBytes() error {
+	if f.wireType != pbBytes {
+		return fmt.Errorf("field %d isn't length-delimited", f.num)
+	}
+	return nil
+}
+
+func (f *pbField) uint() (uint64, error) {
+	return f.v, f.expectVarint()
+}
+
+func (f *pbField) uint32() (uint32, error) {
+	if f.v > math.MaxUint32 {
+		return 0, fmt.Errorf("field %d overflows", f.num)
+	}
+	return uint32(f.v), f.expectVarint()
+}
+
+func (f *pbField) int() (int64, error) {
+	return int64(f.v), f.expectVarint()
+}
+
+func (f *pbField) bool() (bool, error) {
+	return f.v != 0, f.expectVarint()
+}
+
+// bytes returns a copy of the bytes of f, which is empty
+// rather than nil if f is empty.
+func (f *pbField) bytes() ([]byte, error) {
+	return append([]byte{}, f.b...), f.expectBytes()
+}
+
+func (f *pbField) string() (string, error) {
+	return string(f.b), f.expectBytes()
+}
@@ -0,0 +1,157 @@
+package application
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestProtobufRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		reqType int
+		request interface{}
+	}{
+		{"lookup", protocol.KeyLookupType, &protocol.KeyLookupRequest{Username: "alice"}},
+		{"lookup in epoch", protocol.KeyLookupInEpochType,
+			&protocol.KeyLookupInEpochRequest{Username: "alice", Epoch: 2}},
+		{"monitoring", protocol.MonitoringType,
+			&protocol.MonitoringRequest{Username: "alice", StartEpoch: 1, EndEpoch: 3, KnownEpoch: 1}},
+		{"registration", protocol.RegistrationType,
+			&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")}},
+		{"STR history", protocol.STRType, &protocol.STRHistoryRequest{StartEpoch: 1, Latest: true}},
+	} {
+		msg, err := MarshalRequestPB(tc.reqType, tc.request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := UnmarshalRequestPB(msg)
+		if err != nil {
+			t.Error(tc.name, "expect no error, got", err)
+			continue
+		}
+		if req.Type != tc.reqType || !reflect.DeepEqual(req.Request, tc.request) {
+			t.Error(tc.name, "expect", tc.request, "got", req.Request)
+		}
+	}
+}
+
+func TestUnmarshalRequestPBStrict(t *testing.T) {
+	lookup, _ := MarshalRequestPB(protocol.KeyLookupType, &protocol.KeyLookupRequest{Username: "alice"})
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+	}{
+		{"truncated", lookup[:len(lookup)-1]},
+		{"unknown field", append(append([]byte{}, lookup...), 0x30, 1)},
+		{"wrong wire type", []byte{0x0a, 0}},
+		{"unknown type", []byte{0x08, 99, 0x12, 0}},
+		{"no content", []byte{0x08, byte(protocol.KeyLookupType)}},
+		{"mismatched content", []byte{0x08, byte(protocol.KeyLookupInEpochType), 0x12, 0}},
+		{"unknown content field", []byte{0x08, byte(protocol.KeyLookupType), 0x12, 2, 0x10, 1}},
+	} {
+		if _, err := UnmarshalRequestPB(tc.msg); err == nil {
+			t.Error(tc.name, "expect an error")
+		}
+	}
+}
+
+func TestProtobufDirectoryProof(t *testing.T) {
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 10, true)
+	reg := d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	mon := d.Monitor(&protocol.MonitoringRequest{Username: "alice", StartEpoch: 0, EndEpoch: 1})
+	lookup := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	absent := d.KeyLookup(&protocol.KeyLookupRequest{Username: "bob"})
+
+	for _, tc := range []struct {
+		name    string
+		reqType int
+		res     *protocol.Response
+	}{
+		{"registration", protocol.RegistrationType, reg},
+		{"monitoring", protocol.MonitoringType, mon},
+		{"lookup", protocol.KeyLookupType, lookup},
+		{"absence", protocol.KeyLookupType, absent},
+	} {
+		msg, err := MarshalResponsePB(tc.res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := MarshalResponse(tc.res)
+		if len(msg) >= len(want) {
+			t.Error(tc.name, "expect a smaller encoding than", len(want), "got", len(msg))
+		}
+		got, _ := MarshalResponse(UnmarshalResponsePB(tc.reqType, msg))
+		if !bytes.Equal(got, want) {
+			t.Error(tc.name, "expect", string(want), "got", string(got))
+		}
+	}
+
+	// the client pins the decoded STR, as it pins a saved one
+	res := decodePBResponse(t, protocol.RegistrationType, reg)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := client.New(res.DirectoryProof().STR[0], true, pk)
+	if err := cc.HandleResponse(protocol.RegistrationType, res, "alice", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.HandleResponse(protocol.KeyLookupType, decodePBResponse(t, protocol.KeyLookupType, lookup),
+		"alice", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodePBResponse(t *testing.T, reqType int, res *protocol.Response) *protocol.Response {
+	msg, err := MarshalResponsePB(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return UnmarshalResponsePB(reqType, msg)
+}
+
+func TestProtobufJSONResponses(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	res := decodePBResponse(t, protocol.STRType,
+		d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 0, EndEpoch: 0}))
+	str := res.STRHistoryRange().STR[0]
+	if !bytes.Equal(d.LatestSTR().Serialize(), str.Serialize()) {
+		t.Fatal("Cannot unmarshal the STR history")
+	}
+
+	res = decodePBResponse(t, protocol.KeyLookupType, protocol.NewErrorResponse(protocol.ErrDirectory))
+	if res.Error != protocol.ErrDirectory || res.DirectoryResponse != nil {
+		t.Fatal("Expect", protocol.ErrDirectory, "got", res.Error)
+	}
+	// an error code which requires a proof
+	res = decodePBResponse(t, protocol.KeyLookupType, protocol.NewErrorResponse(protocol.ReqNameNotFound))
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestUnmarshalResponsePBStrict(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	msg, _ := MarshalResponsePB(d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"}))
+	e := new(pbEncoder)
+	e.int(1, int64(protocol.ReqSuccess))
+	e.bytes(15, []byte(`{"Transitions":[]}`))
+	for _, tc := range []struct {
+		name    string
+		reqType int
+		msg     []byte
+	}{
+		{"truncated", protocol.KeyLookupType, msg[:len(msg)-1]},
+		{"unknown field", protocol.KeyLookupType, append(append([]byte{}, msg...), 0x30, 1)},
+		{"not a directory proof", protocol.STRType, msg},
+		{"mismatched JSON content", protocol.KeyLookupType, e.buf},
+	} {
+		if res := UnmarshalResponsePB(tc.reqType, tc.msg); res.Error != protocol.ErrMalformedMessage {
+			t.Error(tc.name, "expect", protocol.ErrMalformedMessage, "got", res.Error)
+		}
+	}
+}
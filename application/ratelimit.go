@@ -0,0 +1,159 @@
+// Implements the rate limiting of the requests a ServerBase handles,
+// which protects its public connections, e.g., against the floods of
+// registrations squatting names.
+
+package application
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A RateLimit is a token bucket: a client may send up to Burst
+// requests at once, and then Rate requests per second on average.
+type RateLimit struct {
+	Rate  float64 `toml:"rate"`
+	Burst int     `toml:"burst"`
+}
+
+// RateLimits contains the rate limits of the requests a server
+// handles. PerIP limits the requests of each client IP address, and
+// PerName limits the requests modifying the binding of each username,
+// i.e. the registrations, key changes, aborts and deactivations,
+// regardless of the clients sending them. The requests received on
+// Unix sockets are only limited per name. A nil limit is disabled.
+type RateLimits struct {
+	PerIP   *RateLimit `toml:"per_ip,omitempty"`
+	PerName *RateLimit `toml:"per_name,omitempty"`
+}
+
+// sweepInterval is the interval at which a rateLimiter forgets the
+// buckets which have refilled.
+const sweepInterval = time.Minute
+
+// A rateLimiter maintains the token buckets of a RateLimit,
+// indexed by key.
+type rateLimiter struct {
+	limit RateLimit
+	clock utils.Clock
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit RateLimit, clock utils.Clock) *rateLimiter {
+	return &rateLimiter{
+		limit:     limit,
+		clock:     clock,
+		buckets:   make(map[string]*bucket),
+		lastSweep: clock.Now(),
+	}
+}
+
+// refill adds the tokens accrued by b since it was last used at now.
+func (rl *rateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * rl.limit.Rate
+	if b.tokens > float64(rl.limit.Burst) {
+		b.tokens = float64(rl.limit.Burst)
+	}
+	b.last = now
+}
+
+// allow takes a token from the bucket of key, and returns false if the
+// bucket is empty.
+func (rl *rateLimiter) allow(key string) bool {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := rl.clock.Now()
+	if now.Sub(rl.lastSweep) >= sweepInterval {
+		rl.sweep(now)
+	}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rl.limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	rl.refill(b, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets the buckets which have refilled, since a new bucket
+// is equivalent.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for key, b := range rl.buckets {
+		rl.refill(b, now)
+		if b.tokens >= float64(rl.limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// requestLimiters contains the rate limiters of a ServerBase.
+type requestLimiters struct {
+	perIP   *rateLimiter
+	perName *rateLimiter
+}
+
+func newRequestLimiters(limits *RateLimits, clock utils.Clock) *requestLimiters {
+	if limits == nil {
+		return nil
+	}
+	l := new(requestLimiters)
+	if limits.PerIP != nil {
+		l.perIP = newRateLimiter(*limits.PerIP, clock)
+	}
+	if limits.PerName != nil {
+		l.perName = newRateLimiter(*limits.PerName, clock)
+	}
+	return l
+}
+
+// allow returns false if the request req, received from the client
+// at the address remote, exceeds one of the rate limits.
+func (l *requestLimiters) allow(remote string, req *protocol.Request) bool {
+	if l == nil {
+		return true
+	}
+	if l.perIP != nil {
+		// the addresses of the clients of Unix sockets have no host
+		if host := peerHost(remote); host != "" && !l.perIP.allow(host) {
+			return false
+		}
+	}
+	if l.perName != nil {
+		if name, ok := modifiedName(req); ok && !l.perName.allow(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// modifiedName returns the username whose binding the request req
+// modifies, if any.
+func modifiedName(req *protocol.Request) (string, bool) {
+	switch msg := req.Request.(type) {
+	case *protocol.RegistrationRequest:
+		return msg.Username, true
+	case *protocol.KeyChangeRequest:
+		return msg.Username, true
+	case *protocol.KeyChangeAbortRequest:
+		return msg.Username, true
+	case *protocol.DeactivationRequest:
+		return msg.Username, true
+	}
+	return "", false
+}
@@ -0,0 +1,102 @@
+package application
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func registration(name string) *protocol.Request {
+	return &protocol.Request{
+		Type: protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{
+			Username: name,
+			Key:      []byte("key"),
+		},
+	}
+}
+
+func TestRateLimitPerIP(t *testing.T) {
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	l := newRequestLimiters(&RateLimits{
+		PerIP: &RateLimit{Rate: 1, Burst: 2},
+	}, clock)
+	lookup := &protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: "alice"},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		req    *protocol.Request
+		want   bool
+	}{
+		{"first", "192.0.2.1:1000", registration("alice"), true},
+		{"burst", "192.0.2.1:1001", lookup, true},
+		{"exceeded", "192.0.2.1:1002", registration("bob"), false},
+		{"other address", "192.0.2.2:1000", registration("carol"), true},
+		{"unix socket", "@", registration("dave"), true},
+	} {
+		if got := l.allow(tc.remote, tc.req); got != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", got)
+		}
+	}
+
+	// a token is refilled every second
+	clock.Advance(time.Second)
+	if !l.allow("192.0.2.1:1003", lookup) {
+		t.Error("Expect a refilled token")
+	}
+	if l.allow("192.0.2.1:1003", lookup) {
+		t.Error("Expect a single refilled token")
+	}
+}
+
+func TestRateLimitPerName(t *testing.T) {
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	l := newRequestLimiters(&RateLimits{
+		PerName: &RateLimit{Rate: 0.1, Burst: 1},
+	}, clock)
+	lookup := &protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: "alice"},
+	}
+
+	if !l.allow("192.0.2.1:1000", registration("alice")) {
+		t.Fatal("Expect the first registration to be allowed")
+	}
+	if l.allow("192.0.2.2:1000", registration("alice")) {
+		t.Fatal("Expect the name to be rate limited for every client")
+	}
+	if !l.allow("192.0.2.1:1000", registration("bob")) ||
+		!l.allow("192.0.2.1:1000", lookup) {
+		t.Fatal("Expect other names and lookups not to be rate limited")
+	}
+	clock.Advance(10 * time.Second)
+	if !l.allow("192.0.2.2:1000", registration("alice")) {
+		t.Fatal("Expect the name's token to be refilled")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	rl := newRateLimiter(RateLimit{Rate: 1, Burst: 1}, clock)
+	rl.allow("a")
+	clock.Advance(sweepInterval)
+	rl.allow("b")
+	if _, ok := rl.buckets["a"]; ok || len(rl.buckets) != 1 {
+		t.Fatal("Expect the refilled buckets to be forgotten")
+	}
+}
+
+func TestNoRateLimits(t *testing.T) {
+	l := newRequestLimiters(nil, utils.RealClock)
+	for i := 0; i < 10; i++ {
+		if !l.allow("192.0.2.1:1000", registration("alice")) {
+			t.Fatal("Expect no rate limit")
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Implements the client of the Rekor transparency logs in which the key
+// servers publish their STRs, and in which the clients and auditors
+// look them up (see package protocol/rekor).
+
+package application
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// rekorTimeout bounds the time of each request to a Rekor log.
+const rekorTimeout = 10 * time.Second
+
+// ErrNotRecorded indicates that a Rekor log doesn't record an STR,
+// i.e., that it has no valid entry for it.
+var ErrNotRecorded = errors.New("[coniks] The STR isn't recorded in the Rekor log")
+
+// A RekorConfig specifies a Rekor transparency log: URL is the address
+// of the log's API, e.g., "https://rekor.sigstore.dev", and
+// PublicKeyPath is the path to the log's PEM-encoded public key,
+// parsed into LogKey, with which the log's entries are verified.
+type RekorConfig struct {
+	URL           string           `toml:"url"`
+	PublicKeyPath string           `toml:"public_key_path"`
+	LogKey        *ecdsa.PublicKey `toml:"-"`
+}
+
+// Load reads the log's public key at the path specified in the given
+// config file.
+func (conf *RekorConfig) Load(file string) error {
+	if _, err := url.Parse(conf.URL); err != nil || conf.URL == "" {
+		return fmt.Errorf("Invalid URL of the Rekor log: %q", conf.URL)
+	}
+	buf, err := ioutil.ReadFile(utils.ResolvePath(conf.PublicKeyPath, file))
+	if err != nil {
+		return fmt.Errorf("Cannot read the public key of the Rekor log: %v", err)
+	}
+	conf.LogKey, err = rekor.ParsePublicKey(buf)
+	return err
+}
+
+// A RekorClient publishes and looks up STRs in a Rekor log.
+type RekorClient struct {
+	url    string
+	logKey *ecdsa.PublicKey
+	client *http.Client
+}
+
+// NewRekorClient creates a client of the Rekor log configured by conf.
+func NewRekorClient(conf *RekorConfig) *RekorClient {
+	return &RekorClient{
+		url:    strings.TrimSuffix(conf.URL, "/"),
+		logKey: conf.LogKey,
+		client: &http.Client{Timeout: rekorTimeout},
+	}
+}
+
+// Publish records the STR str, signed with the directory's signing key
+// pk, in the log, and returns the verified entry (see
+// rekor.Entry.Verify()). If the log already records str, Publish()
+// returns the existing entry.
+func (c *RekorClient) Publish(str *protocol.DirSTR, pk sign.PublicKey) (*rekor.Entry, error) {
+	proposed, err := rekor.ProposedEntry(str, pk)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Post(c.url+"/api/v1/log/entries", "application/json",
+		bytes.NewReader(proposed))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var e *rekor.Entry
+	switch res.StatusCode {
+	case http.StatusCreated:
+		if e, err = decodeEntries(res); err != nil {
+			return nil, err
+		}
+	case http.StatusConflict:
+		// the log returns the location of the existing entry
+		loc := res.Header.Get("Location")
+		if i := strings.LastIndex(loc, "/"); i >= 0 {
+			loc = loc[i+1:]
+		}
+		if e, err = c.entry(loc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unexpected status of the Rekor log: %s", res.Status)
+	}
+	if err := e.Verify(str, pk, c.logKey); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Lookup returns the entry of the log which records the STR str, signed
+// with the directory's signing key pk, with the proof of its inclusion
+// in the log (see rekor.Entry.VerifyInclusion()). It returns an
+// ErrNotRecorded if the log has no valid entry for str.
+func (c *RekorClient) Lookup(str *protocol.DirSTR, pk sign.PublicKey) (*rekor.Entry, error) {
+	query, err := json.Marshal(map[string]string{"hash": "sha256:" + rekor.Hash(str)})
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Post(c.url+"/api/v1/index/retrieve", "application/json",
+		bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status of the Rekor log: %s", res.Status)
+	}
+	var uuids []string
+	if err := json.NewDecoder(res.Body).Decode(&uuids); err != nil {
+		return nil, err
+	}
+	for _, uuid := range uuids {
+		e, err := c.entry(uuid)
+		if err != nil {
+			return nil, err
+		}
+		if e.VerifyInclusion(str, pk, c.logKey) == nil {
+			return e, nil
+		}
+	}
+	return nil, ErrNotRecorded
+}
+
+// entry fetches the entry of the log identified by uuid.
+func (c *RekorClient) entry(uuid string) (*rekor.Entry, error) {
+	res, err := c.client.Get(c.url + "/api/v1/log/entries/" + url.PathEscape(uuid))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status of the Rekor log: %s", res.Status)
+	}
+	return decodeEntries(res)
+}
+
+// decodeEntries decodes the entry in the body of the response res,
+// which maps the entry's UUID to the entry.
+func decodeEntries(res *http.Response) (*rekor.Entry, error) {
+	var entries map[string]*rekor.Entry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e != nil {
+			return e, nil
+		}
+	}
+	return nil, ErrNotRecorded
+}
@@ -0,0 +1,63 @@
+// Implements the retrieval of the randomness beacon values which a key
+// server mixes into its STRs (see protocol.BeaconExtension).
+
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// beaconTimeout bounds the time the server waits for the beacon
+// while updating its directory.
+const beaconTimeout = 2 * time.Second
+
+// drandBeacon returns a function fetching the latest round of the drand
+// beacon served over HTTP at url, e.g., "https://api.drand.sh".
+func drandBeacon(url string) func() (*protocol.BeaconValue, error) {
+	client := &http.Client{Timeout: beaconTimeout}
+	url = strings.TrimSuffix(url, "/") + "/public/latest"
+	return func() (*protocol.BeaconValue, error) {
+		res, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Unexpected status of the beacon: %s", res.Status)
+		}
+		var round struct {
+			Round      uint64 `json:"round"`
+			Randomness string `json:"randomness"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&round); err != nil {
+			return nil, err
+		}
+		randomness, err := hex.DecodeString(round.Randomness)
+		if err != nil || len(randomness) == 0 {
+			return nil, fmt.Errorf("Malformed randomness of the beacon's round %d", round.Round)
+		}
+		return &protocol.BeaconValue{
+			Round:      round.Round,
+			Randomness: randomness,
+		}, nil
+	}
+}
+
+// mixBeacon makes the directory's next STR include the latest value of
+// the server's randomness beacon. If the beacon is unavailable, the STR
+// includes no beacon value.
+func (server *ConiksServer) mixBeacon() {
+	b, err := server.beacon()
+	if err != nil {
+		server.Logger().Warn("Cannot fetch the randomness beacon", "error", err.Error())
+		b = nil
+	}
+	server.dir.SetBeacon(b)
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServerMixesBeacon(t *testing.T) {
+	available := true
+	beacon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/public/latest" || !available {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"round": 42, "randomness": "0a0b0c", "signature": "00"}`)
+	}))
+	defer beacon.Close()
+
+	server, _, _ := newTestServer(t, 60, false, "", os.TempDir())
+	server.beacon = drandBeacon(beacon.URL)
+	server.update()
+	b, err := server.dir.LatestSTR().Beacon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.Round != 42 || !bytes.Equal(b.Randomness, []byte{10, 11, 12}) {
+		t.Fatal("Expect the beacon's round 42, got", b)
+	}
+
+	// the STR is issued without a beacon value if the beacon is unavailable
+	available = false
+	server.update()
+	if b, err := server.dir.LatestSTR().Beacon(); b != nil || err != nil {
+		t.Fatal("Expect no beacon value, got", b, err)
+	}
+}
@@ -3,8 +3,11 @@ package server
 import (
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/protocol"
@@ -23,10 +26,94 @@ type Config struct {
 	Policies *Policies `toml:"policies"`
 	// Path to store the initial STR
 	InitSTRPath string `toml:"init_str_path"`
+	// LatestSTRPath is the path at which the server records the
+	// latest STR it has issued, so that it refuses to start with
+	// a directory which doesn't continue its history, e.g., if its
+	// database was restored from a backup (see Reinitialize()).
+	LatestSTRPath string `toml:"latest_str_path,omitempty"`
 	// Addresses contains the server's connections configuration.
 	Addresses []*Address `toml:"addresses"`
 	// The server's epoch interval for updating the directory
 	EpochDeadline protocol.Timestamp `toml:"epoch_deadline"`
+	// DatabasePath is the path to the database in which the server
+	// persists its directory. The directory is kept in memory only
+	// if no path is specified.
+	DatabasePath string `toml:"database_path,omitempty"`
+	// CheckpointInterval is the number of epochs between two
+	// checkpoints of the persisted directory.
+	CheckpointInterval uint64 `toml:"checkpoint_interval,omitempty"`
+	// LoadShedding indicates whether the server keeps serving key
+	// lookups from the previous snapshot while updating its directory,
+	// and asks the clients to retry all other requests later,
+	// instead of holding them until the update is done.
+	LoadShedding bool `toml:"load_shedding,omitempty"`
+	// HashWorkers is the number of goroutines hashing the server's
+	// tree at each epoch update (see directory.SetHashWorkers()).
+	// The tree is hashed sequentially by default.
+	HashWorkers int `toml:"hash_workers,omitempty"`
+	// Limits optionally bounds the size of the server's directory.
+	Limits *Limits `toml:"limits,omitempty"`
+	// Bots lists the account verification bots whose attestations
+	// the server accepts (see Address).
+	Bots []*Bot `toml:"bots,omitempty"`
+	// Identifiers optionally restricts the registrations of each
+	// identifier type (e.g., "device"), indexed by the type.
+	Identifiers map[string]*IdentifierPolicy `toml:"identifiers,omitempty"`
+	// Auditors lists the addresses of the auditors to which the server
+	// pushes each new STR right after issuing it (see
+	// protocol.STRPush), e.g., "tcp://auditor.example.org:3000".
+	Auditors []string `toml:"auditors,omitempty"`
+	// Rekor optionally specifies the Rekor transparency log in which
+	// the server publishes each new STR (see RekorConfig).
+	Rekor *RekorConfig `toml:"rekor,omitempty"`
+	// MetadataPath is the path to the database in which the server
+	// keeps operational data about its users (see MetadataStore),
+	// apart from its directory. No metadata is kept if no path is
+	// specified.
+	MetadataPath string `toml:"metadata_path,omitempty"`
+	// AdminAddress is the named Unix socket at which the server
+	// listens for the commands of its operator, e.g., to read and
+	// write the metadata store (see GetMetadataCommand), to export
+	// the aggregate shape of the directory's tree (see
+	// TreeStatsCommand), to freeze bindings (see FreezeCommand), or
+	// to rotate the signing key (see RotateSigningKeyCommand).
+	AdminAddress string `toml:"admin_address,omitempty"`
+	// StatsAddress is the TCP address, e.g., "127.0.0.1:8081", at
+	// which the server serves the aggregate shape of the directory's
+	// tree over HTTP (see TreeStatsPath), separately from the
+	// addresses of its clients. The endpoint is read-only and reveals
+	// no binding; it is disabled if no address is specified.
+	StatsAddress string `toml:"stats_address,omitempty"`
+	// BeaconURL is the HTTP endpoint of the drand randomness beacon
+	// whose latest value the server mixes into each STR (see
+	// protocol.BeaconExtension), e.g., "https://api.drand.sh".
+	BeaconURL string `toml:"beacon_url,omitempty"`
+}
+
+// A Bot describes an account verification bot running in detached mode
+// which the server trusts to verify the usernames ending in Suffix
+// (e.g., "@twitter"). KeyPath is the path to the bot's public key.
+type Bot struct {
+	Suffix  string `toml:"suffix"`
+	KeyPath string `toml:"key_path"`
+	key     sign.PublicKey
+}
+
+// Limits contains the soft and hard limits on the number of bindings
+// in the server's directory and on the number of registrations per
+// epoch (see directory.Limits). A limit of 0 is disabled.
+type Limits struct {
+	SoftMaxBindings              uint64 `toml:"soft_max_bindings,omitempty"`
+	MaxBindings                  uint64 `toml:"max_bindings,omitempty"`
+	SoftMaxRegistrationsPerEpoch uint64 `toml:"soft_max_registrations_per_epoch,omitempty"`
+	MaxRegistrationsPerEpoch     uint64 `toml:"max_registrations_per_epoch,omitempty"`
+}
+
+// An IdentifierPolicy restricts the registrations of the identifiers
+// of one type (see protocol.IdentifierPolicy).
+type IdentifierPolicy struct {
+	Disabled           bool `toml:"disabled,omitempty"`
+	RequireAttestation bool `toml:"require_attestation,omitempty"`
 }
 
 var _ application.AppConfig = (*Config)(nil)
@@ -71,23 +158,132 @@ func (conf *Config) Load(file, encoding string) error {
 
 	// load VRF key
 	vrfPath := utils.ResolvePath(conf.Policies.VRFKeyPath, file)
-	vrfKey, err := ioutil.ReadFile(vrfPath)
+	vrfKeyBytes, err := ioutil.ReadFile(vrfPath)
 	if err != nil {
 		return fmt.Errorf("Cannot read VRF key: %v", err)
 	}
-	if len(vrfKey) != vrf.PrivateKeySize {
-		return fmt.Errorf("VRF key must be 64 bytes (got %d)", len(vrfKey))
+	vrfKey, err := vrf.NewPrivateKey(conf.Policies.VRFAlgorithm, vrfKeyBytes)
+	if err != nil {
+		return fmt.Errorf("Cannot load VRF key: %v", err)
+	}
+
+	// load the commitment salt key, if any
+	if conf.Policies.SaltKeyPath != "" {
+		saltPath := utils.ResolvePath(conf.Policies.SaltKeyPath, file)
+		saltKey, err := ioutil.ReadFile(saltPath)
+		if err != nil {
+			return fmt.Errorf("Cannot read salt key: %v", err)
+		}
+		if len(saltKey) != crypto.SaltKeySize {
+			return fmt.Errorf("Salt key must be %d bytes (got %d)",
+				crypto.SaltKeySize, len(saltKey))
+		}
+		conf.Policies.saltKey = saltKey
+	}
+
+	if conf.Policies.HashSize != 0 && !crypto.ValidHashSize(conf.Policies.HashSize) {
+		return fmt.Errorf("Hash size must be between %d and %d bytes (got %d)",
+			crypto.MinHashSizeByte, crypto.HashSizeByte, conf.Policies.HashSize)
+	}
+	if conf.Policies.Hash != "" {
+		if _, err := hashers.NewPADHasher(conf.Policies.Hash); err != nil ||
+			strings.Contains(conf.Policies.Hash, "/") {
+			return fmt.Errorf("Unknown hash algorithm %q (expected one of %s)",
+				conf.Policies.Hash, strings.Join(hashers.Names(), ", "))
+		}
+	}
+
+	if conf.Policies.NamespaceBits > protocol.MaxNamespaceBits {
+		return fmt.Errorf("Namespace bits must be at most %d (got %d)",
+			protocol.MaxNamespaceBits, conf.Policies.NamespaceBits)
+	}
+	if conf.Policies.NamespaceBits > 0 {
+		vrfKey = protocol.NewNamespacedVRF(vrfKey, conf.Policies.NamespaceBits)
+	}
+
+	// load the bootstrap seed, if any
+	if conf.Policies.BootstrapPath != "" {
+		seed, err := application.LoadBootstrapSeed(conf.Policies.BootstrapPath, file)
+		if err != nil {
+			return err
+		}
+		pk, _ := sign.PrivateKey(signKey).Public()
+		if err := seed.Verify(pk); err != nil {
+			return fmt.Errorf("Cannot verify bootstrap seed: %v", err)
+		}
+		conf.Policies.bootstrap = seed
+	}
+
+	// load the bots' attestation keys
+	for _, bot := range conf.Bots {
+		botPath := utils.ResolvePath(bot.KeyPath, file)
+		botKey, err := ioutil.ReadFile(botPath)
+		if err != nil {
+			return fmt.Errorf("Cannot read key of bot %q: %v", bot.Suffix, err)
+		}
+		if len(botKey) != sign.PublicKeySize {
+			return fmt.Errorf("Key of bot %q must be %d bytes (got %d)",
+				bot.Suffix, sign.PublicKeySize, len(botKey))
+		}
+		bot.key = botKey
+	}
+
+	// load the authority keys of the name policies
+	for _, np := range conf.Policies.Names {
+		keyPath := utils.ResolvePath(np.AuthorityKeyPath, file)
+		key, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return fmt.Errorf("Cannot read authority key of name policy %q: %v",
+				np.Suffix, err)
+		}
+		if len(key) != sign.PublicKeySize {
+			return fmt.Errorf("Authority key of name policy %q must be %d bytes (got %d)",
+				np.Suffix, sign.PublicKeySize, len(key))
+		}
+		np.key = key
+	}
+
+	for _, hook := range conf.Webhooks {
+		if err := hook.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for t := range conf.Identifiers {
+		if !protocol.IdentifierType(t).Known() {
+			return fmt.Errorf("Unknown identifier type %q", t)
+		}
 	}
 
 	conf.Policies.vrfKey = vrfKey
 	conf.Policies.signKey = signKey
 	// also update path for TLS cert files
 	for _, addr := range conf.Addresses {
+		if err := application.ValidateEncoding(addr.Encoding); err != nil {
+			return err
+		}
 		addr.TLSCertPath = utils.ResolvePath(addr.TLSCertPath, file)
 		addr.TLSKeyPath = utils.ResolvePath(addr.TLSKeyPath, file)
 	}
 	// logger config
 	conf.Logger.Path = utils.ResolvePath(conf.Logger.Path, file)
+	if conf.DatabasePath != "" {
+		conf.DatabasePath = utils.ResolvePath(conf.DatabasePath, file)
+	}
+	if conf.MetadataPath != "" {
+		conf.MetadataPath = utils.ResolvePath(conf.MetadataPath, file)
+		if conf.MetadataPath == conf.DatabasePath {
+			return fmt.Errorf("The metadata store cannot share the directory's database")
+		}
+	}
+	if conf.AdminAddress != "" {
+		conf.AdminAddress = utils.ResolvePath(conf.AdminAddress, file)
+	}
+	if conf.Rekor != nil {
+		if err := conf.Rekor.Load(file); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,185 @@
+// Implements the checks which keep the epochs of a key server
+// continuous across restarts: a server never starts a history which
+// doesn't extend the hash chain its clients and auditors have verified,
+// unless its operator re-initializes the directory explicitly.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/storage/kv/leveldbkv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+var (
+	// ErrHistoryLost indicates that a key server would have to start
+	// a new directory, although it has issued STRs before, e.g.,
+	// since it doesn't persist its directory, or since its database
+	// was deleted.
+	ErrHistoryLost = errors.New("[coniksserver] The directory's history was lost; run `coniksserver reinit` to start a new directory")
+	// ErrHistoryRolledBack indicates that the directory a key server
+	// restored from its database is older than, or differs from, the
+	// latest STR the server has issued, e.g., since the database was
+	// restored from a backup.
+	ErrHistoryRolledBack = errors.New("[coniksserver] The directory's history was rolled back; run `coniksserver reinit` to start a new directory")
+)
+
+// initSTRPath returns the path to the initial STR of the server
+// configured by conf, or "" if conf doesn't save it.
+func initSTRPath(conf *Config) string {
+	if conf.InitSTRPath == "" {
+		return ""
+	}
+	return utils.ResolvePath(conf.InitSTRPath, conf.Path)
+}
+
+// latestSTRPath returns the path to the record of the latest STR
+// issued by the server configured by conf, or "" if conf doesn't keep
+// this record.
+func latestSTRPath(conf *Config) string {
+	if conf.LatestSTRPath == "" {
+		return ""
+	}
+	return utils.ResolvePath(conf.LatestSTRPath, conf.Path)
+}
+
+// loadLatestSTR returns the latest STR recorded at path,
+// or nil if there is none.
+func loadLatestSTR(path string) (*protocol.DirSTR, error) {
+	if path == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	str := new(protocol.DirSTR)
+	if err := json.Unmarshal(buf, str); err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+// saveLatestSTR records str as the latest STR at path, replacing the
+// previous record atomically, so that a crash never leaves a partial
+// record.
+func saveLatestSTR(path string, str *protocol.DirSTR) error {
+	if path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(str)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// checkContinuity checks that the directory dir, which the server
+// configured by conf restored from its database if restored is true,
+// or created from scratch otherwise, continues the history the server
+// issued before it was restarted.
+//
+// A new directory only starts a history if the server has issued no
+// STR before, i.e., if neither its initial STR nor its latest STR has
+// been saved; otherwise checkContinuity() returns an ErrHistoryLost.
+// A restored directory has to be at the epoch of the latest recorded
+// STR, with the same STR, or past it, since the record lags behind the
+// database if the server stopped right after an epoch update;
+// otherwise checkContinuity() returns an ErrHistoryRolledBack.
+func checkContinuity(conf *Config, dir *directory.ConiksDirectory, restored bool) error {
+	latest, err := loadLatestSTR(latestSTRPath(conf))
+	if err != nil {
+		return err
+	}
+	if !restored {
+		if path := initSTRPath(conf); latest != nil || path != "" && isFile(path) {
+			return ErrHistoryLost
+		}
+		return nil
+	}
+	if latest == nil {
+		return nil
+	}
+	str := dir.LatestSTR()
+	if str.Epoch < latest.Epoch || str.Epoch == latest.Epoch &&
+		!bytes.Equal(str.Serialize(), latest.Serialize()) {
+		return ErrHistoryRolledBack
+	}
+	return nil
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Reinitialize discards the directory of the key server configured by
+// conf, i.e., its database and its saved STRs, and creates a new
+// directory from scratch, which the server serves once it is
+// restarted. The new directory has a new identity (see
+// auditor.ComputeDirectoryIdentity()), since its initial STR commits
+// to a new tree: the clients and auditors of the server have to pin
+// the returned initial STR instead of the previous one.
+// If the server doesn't persist its directory, Reinitialize() only
+// discards the saved STRs and returns nil: the server then creates
+// the new directory, and saves its initial STR, once it is restarted.
+//
+// The server must not be running.
+func Reinitialize(conf *Config) (*protocol.DirSTR, error) {
+	if conf.DatabasePath != "" {
+		// fail if a server is running on the database
+		if _, err := os.Stat(conf.DatabasePath); err == nil {
+			db, err := leveldbkv.OpenReadOnlyDB(conf.DatabasePath)
+			if err != nil {
+				return nil, err
+			}
+			db.Close()
+		}
+	}
+	for _, path := range []string{initSTRPath(conf), latestSTRPath(conf)} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if conf.DatabasePath == "" {
+		return nil, nil
+	}
+	dir, err := newDirectory(conf)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(conf.DatabasePath); err != nil {
+		return nil, err
+	}
+	db := leveldbkv.OpenDB(conf.DatabasePath)
+	defer db.Close()
+	if err := dir.Persist(db, conf.CheckpointInterval); err != nil {
+		return nil, err
+	}
+	initSTR := dir.LatestSTR()
+	if path := initSTRPath(conf); path != "" {
+		if err := application.SaveSTR(path, initSTR); err != nil {
+			return nil, err
+		}
+	}
+	if err := saveLatestSTR(latestSTRPath(conf), initSTR); err != nil {
+		return nil, err
+	}
+	return initSTR, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+// expectPanic fails the test unless f panics with want.
+func expectPanic(t *testing.T, want error, f func()) {
+	defer func() {
+		if err := recover(); err != want {
+			t.Fatal("Expect", want, "got", err)
+		}
+	}()
+	f()
+}
+
+func TestHistoryLostWithoutDatabase(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, clock := newTestServer(t, 60, false, "", dir)
+	conf.InitSTRPath = path.Join(dir, "init.str")
+	conf.LatestSTRPath = path.Join(dir, "latest.str")
+	server = newConiksServer(conf, clock)
+	initSTR := server.dir.LatestSTR()
+	if err := server.update(); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := loadLatestSTR(conf.LatestSTRPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Epoch != 1 {
+		t.Fatal("Expect the STR of epoch", 1, "to be recorded, got", latest.Epoch)
+	}
+
+	// the server would restart at epoch 0
+	expectPanic(t, ErrHistoryLost, func() {
+		newConiksServer(conf, clock)
+	})
+	os.Remove(conf.LatestSTRPath)
+	expectPanic(t, ErrHistoryLost, func() {
+		newConiksServer(conf, clock)
+	})
+
+	if str, err := Reinitialize(conf); err != nil || str != nil {
+		t.Fatal("Expect the directory to be created by the server, got", str, err)
+	}
+	server = newConiksServer(conf, clock)
+	if server.dir.LatestSTR().Epoch != 0 {
+		t.Fatal("Expect a new directory at epoch 0")
+	}
+	if auditor.ComputeDirectoryIdentity(server.dir.LatestSTR()) ==
+		auditor.ComputeDirectoryIdentity(initSTR) {
+		t.Fatal("Expect a new directory identity")
+	}
+}
+
+func TestHistoryRolledBack(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	_, conf, clock := newTestServer(t, 60, false, "", dir)
+	conf.InitSTRPath = path.Join(dir, "init.str")
+	conf.LatestSTRPath = path.Join(dir, "latest.str")
+	conf.DatabasePath = path.Join(dir, "coniks.db")
+
+	server := newConiksServer(conf, clock)
+	if err := server.update(); err != nil {
+		t.Fatal(err)
+	}
+	server.db.Close()
+	// the record lags behind the database
+	server = newConiksServer(conf, clock)
+	if server.dir.LatestSTR().Epoch != 1 {
+		t.Fatal("Expect the directory to be restored at epoch", 1)
+	}
+	stale := server.dir.LatestSTR()
+	if err := server.update(); err != nil {
+		t.Fatal(err)
+	}
+	server.db.Close()
+
+	// the database is older than the latest STR
+	if err := saveLatestSTR(conf.LatestSTRPath, stale); err != nil {
+		t.Fatal(err)
+	}
+	server = newConiksServer(conf, clock)
+	server.db.Close()
+	latest, _ := loadLatestSTR(conf.LatestSTRPath)
+	latest.Epoch = 3
+	if err := saveLatestSTR(conf.LatestSTRPath, latest); err != nil {
+		t.Fatal(err)
+	}
+	expectPanic(t, ErrHistoryRolledBack, func() {
+		newConiksServer(conf, clock)
+	})
+	if _, err := DryRun(conf); err != ErrHistoryRolledBack {
+		t.Fatal("Expect", ErrHistoryRolledBack, "got", err)
+	}
+
+	initSTR, err := Reinitialize(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = newConiksServer(conf, clock)
+	defer server.db.Close()
+	if server.dir.LatestSTR().Epoch != 0 ||
+		auditor.ComputeDirectoryIdentity(server.dir.LatestSTR()) !=
+			auditor.ComputeDirectoryIdentity(initSTR) {
+		t.Fatal("Expect the re-initialized directory to be restored")
+	}
+}
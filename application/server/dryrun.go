@@ -0,0 +1,104 @@
+// Implements the dry-run mode, in which an operator vets a candidate
+// configuration of a key server before applying it in production.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/storage/kv/leveldbkv"
+)
+
+// A DryRunReport describes the directory a key server would serve
+// under a candidate configuration (see DryRun()).
+// Restored is set if the directory would be restored from the server's
+// database, in which case STR is the latest persisted STR; otherwise,
+// STR is the initial STR the server would issue. NextPolicies are the
+// policies the server's next STR would include.
+type DryRunReport struct {
+	Restored     bool
+	STR          *protocol.DirSTR
+	NextPolicies *protocol.Policies
+}
+
+// DryRun loads the directory a key server would serve under the
+// configuration conf, without binding any address, issuing any STR or
+// writing to the server's database, and returns a report of it.
+// It returns an error if an address, an auditor's address, a TLS
+// certificate, the bootstrap seed or the database of conf is unusable,
+// or if the directory wouldn't continue the server's history (see
+// checkContinuity()).
+//
+// The database is opened read-only (see leveldbkv.OpenReadOnlyDB()),
+// so DryRun() fails while a server is running on it.
+func DryRun(conf *Config) (*DryRunReport, error) {
+	for _, addr := range conf.Addresses {
+		if err := addr.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, addr := range conf.Auditors {
+		if err := validateAuditorAddress(addr); err != nil {
+			return nil, fmt.Errorf("Invalid auditor address %s: %v", addr, err)
+		}
+	}
+
+	report := new(DryRunReport)
+	var dir *directory.ConiksDirectory
+	if conf.DatabasePath != "" {
+		if _, err := os.Stat(conf.DatabasePath); err == nil {
+			db, err := leveldbkv.OpenReadOnlyDB(conf.DatabasePath)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot open database: %v", err)
+			}
+			defer db.Close()
+			dir, err = restoreDirectory(db, conf)
+			switch err {
+			case nil:
+				report.Restored = true
+			case merkletree.ErrNoCheckpoint:
+			default:
+				return nil, fmt.Errorf("Cannot restore directory: %v", err)
+			}
+		}
+	}
+	if dir == nil {
+		var err error
+		if dir, err = newDirectory(conf); err != nil {
+			return nil, fmt.Errorf("Cannot create directory: %v", err)
+		}
+	}
+	if err := checkContinuity(conf, dir, report.Restored); err != nil {
+		return nil, err
+	}
+	if err := setPolicies(dir, conf); err != nil {
+		return nil, err
+	}
+	report.STR = dir.LatestSTR()
+	report.NextPolicies = dir.NextPolicies()
+	return report, nil
+}
+
+// Write writes a human-readable summary of the report to w,
+// including the hash of the STR, the hash of its policies and the
+// hash of the next policies, along with the policy fields which would
+// change with the next STR.
+func (r *DryRunReport) Write(w io.Writer) {
+	state := "new"
+	if r.Restored {
+		state = "restored"
+	}
+	fmt.Fprintf(w, "Directory: %s at epoch %d\n", state, r.STR.Epoch)
+	fmt.Fprintf(w, "STR hash: %x\n", crypto.Digest(r.STR.Signature))
+	fmt.Fprintf(w, "Policies hash: %x\n", crypto.Digest(r.STR.Policies.Serialize()))
+	fmt.Fprintf(w, "Next policies hash: %x\n", crypto.Digest(r.NextPolicies.Serialize()))
+	if changed := r.STR.Policies.Diff(r.NextPolicies); len(changed) > 0 {
+		fmt.Fprintf(w, "Changed policies: %v\n", changed)
+	}
+}
@@ -0,0 +1,124 @@
+//go:build faults
+// +build faults
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils/faults"
+)
+
+func TestTruncatedResponse(t *testing.T) {
+	defer faults.Reset()
+	_, teardown := startServer(t, 60, true, "")
+	defer teardown()
+
+	faults.Inject(faults.TruncateResponse, &faults.Fault{})
+	rev, err := testutil.NewTCPClientDefault([]byte(keylookupMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := application.UnmarshalResponse(protocol.KeyLookupType, rev)
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+
+	// the server keeps serving its clients
+	faults.Clear(faults.TruncateResponse)
+	rev, err = testutil.NewTCPClientDefault([]byte(keylookupMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = application.UnmarshalResponse(protocol.KeyLookupType, rev)
+	if res.Error != protocol.ReqNameNotFound {
+		t.Fatal("Expect", protocol.ReqNameNotFound, "got", res.Error)
+	}
+}
+
+func TestDelayedUpdate(t *testing.T) {
+	defer faults.Reset()
+	server, clock, teardown := startServerWithClock(t, 1, true, "")
+	defer teardown()
+
+	faults.Inject(faults.UpdateDelay, &faults.Fault{Delay: 100 * time.Millisecond})
+	str0 := server.dir.LatestSTR()
+	advanceEpoch(t, server, clock)
+	str1 := server.dir.LatestSTR()
+	if str1.Epoch != 1 || !str1.VerifyHashChain(str0) {
+		t.Fatal("Expect next STR in hash chain")
+	}
+}
+
+func TestUpdateStorageOutage(t *testing.T) {
+	defer faults.Reset()
+	events := make(chan *application.WebhookEvent, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := new(application.WebhookEvent)
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	defer hook.Close()
+	// waitEvent waits for the webhook event of the next update attempt
+	waitEvent := func(event string) *application.WebhookEvent {
+		select {
+		case e := <-events:
+			if e.Event != event {
+				t.Fatal("Expect the event", event, "got", e)
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expect the event", event)
+		}
+		return nil
+	}
+
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	_, conf, clock := newTestServer(t, 16, false, "", dir)
+	conf.DatabasePath = path.Join(dir, "coniks.db")
+	conf.Webhooks = []*application.Webhook{{URL: hook.URL}}
+	server := newConiksServer(conf, clock)
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	str0 := server.dir.LatestSTR()
+	faults.Inject(faults.StorageWrite, &faults.Fault{})
+	clock.Advance(16 * time.Second)
+	e := waitEvent(application.EventUpdateFailed)
+	// wait until the retry has been scheduled
+	server.Lock()
+	if server.dir.LatestSTR() != str0 {
+		t.Fatal("Expect the server to keep serving the previous STR")
+	}
+	faults.Clear(faults.StorageWrite)
+	server.Unlock()
+
+	// the failed update is retried before the next epoch
+	retry, err := time.ParseDuration(e.Metadata["retry"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry >= 16*time.Second {
+		t.Fatal("Expect the update to be retried before the next epoch, got", retry)
+	}
+	clock.Advance(retry)
+	if e := waitEvent(application.EventEpoch); e.Epoch != 1 {
+		t.Fatal("Expect the STR of epoch 1, got", e.Epoch)
+	}
+	server.Lock()
+	defer server.Unlock()
+	if str1 := server.dir.LatestSTR(); str1.Epoch != 1 || !str1.VerifyHashChain(str0) {
+		t.Fatal("Expect next STR in hash chain")
+	}
+}
@@ -0,0 +1,56 @@
+// Implements the freezes of bindings by the server's operator, e.g.,
+// as moderation actions, through the server's admin socket (see
+// Config.AdminAddress).
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// FreezeCommand and UnfreezeCommand are the admin commands with which
+// an operator freezes the binding of a username, which the commands
+// take, and unfreezes it (see directory.ConiksDirectory.Freeze()).
+// The directory records the freeze in its next snapshot, so that the
+// clients can tell it from a silent change of the user's key.
+const (
+	FreezeCommand   = "freeze"
+	UnfreezeCommand = "unfreeze"
+)
+
+// handleFreezeCommand runs the FreezeCommand or UnfreezeCommand cmd,
+// and returns its reply, or the error which occurred while running
+// the command.
+func (server *ConiksServer) handleFreezeCommand(cmd string) string {
+	args := strings.Fields(cmd)
+	if len(args) != 2 {
+		return fmt.Sprintf("Usage: %s USERNAME", args[0])
+	}
+	name := args[1]
+	server.Lock()
+	defer server.Unlock()
+	freeze := server.dir.Freeze
+	if args[0] == UnfreezeCommand {
+		freeze = server.dir.Unfreeze
+	}
+	tb, err := freeze(name)
+	if err != nil {
+		return err.Error()
+	}
+	server.Logger().Info("Binding "+args[0]+" by the operator",
+		"username", name, "epoch", tb.InclusionEpoch)
+	return fmt.Sprintf("OK, the binding of %s is %s in epoch %d", name,
+		frozenState(tb), tb.InclusionEpoch)
+}
+
+// frozenState describes the state of the binding changed by the
+// freeze or unfreeze whose TB is tb.
+func frozenState(tb *protocol.TemporaryBinding) string {
+	if protocol.IsFrozen(tb.Value) {
+		return "frozen"
+	}
+	return "unfrozen"
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestFreezeAdminCommands(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, _ := newTestServer(t, 60, false, "", dir)
+	server.adminAddr = path.Join(dir, "admin.sock")
+	server.dir.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	server.update()
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	for _, tc := range []struct {
+		cmd   string
+		reply string
+	}{
+		{FreezeCommand, "Usage: freeze USERNAME"},
+		{FreezeCommand + " bob", protocol.ReqNameNotFound.Error()},
+		{UnfreezeCommand + " alice", "[directory] The binding isn't frozen"},
+		{FreezeCommand + " alice", "OK, the binding of alice is frozen in epoch 2"},
+		{FreezeCommand + " alice", protocol.ReqNameExisted.Error()},
+	} {
+		reply, err := application.SendAdminCommand(server.adminAddr, tc.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != tc.reply {
+			t.Error(tc.cmd, "expect", tc.reply, "got", reply)
+		}
+	}
+
+	server.update()
+	res := server.dir.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if !protocol.IsFrozen(res.DirectoryProof().AP[0].Leaf.Value) {
+		t.Fatal("Expect the freeze to be included")
+	}
+	reply, _ := application.SendAdminCommand(server.adminAddr, UnfreezeCommand+" alice")
+	if !strings.HasPrefix(reply, "OK, the binding of alice is unfrozen") {
+		t.Fatal("Unexpected reply", reply)
+	}
+}
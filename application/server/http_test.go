@@ -0,0 +1,191 @@
+package server
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestHTTPSTRHistory(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, _ := newTestServer(t, 60, true, "", dir)
+	conf.Addresses[0].HTTP = true
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+	server.dir.Update()
+	server.dir.Update()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	get := func(path, etag string) (*http.Response, *protocol.Response) {
+		req, err := http.NewRequest("GET", "https://127.0.0.1:3000"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode == http.StatusNotModified {
+			return res, nil
+		}
+		return res, application.UnmarshalResponse(protocol.STRType, body)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		status int
+		cache  string
+		strs   int
+	}{
+		{"past epochs", "/str?start=0&end=1", http.StatusOK,
+			"public, max-age=31536000, immutable", 2},
+		{"latest epoch", "/str?start=1&latest=true", http.StatusOK, "no-cache", 2},
+		{"future epochs", "/str?start=1&end=5", http.StatusOK, "no-cache", 2},
+		{"malformed range", "/str?start=3&end=1", http.StatusBadRequest, "no-store", 0},
+		{"missing end", "/str?start=0", http.StatusBadRequest, "no-store", 0},
+		{"auditing request", "/audit/" + strings.Repeat("00", crypto.HashSizeByte) + "?start=0&end=1", http.StatusBadRequest, "no-store", 0},
+	} {
+		res, msg := get(tc.path, "")
+		if res.StatusCode != tc.status {
+			t.Error(tc.name, "expect status", tc.status, "got", res.StatusCode)
+			continue
+		}
+		if cache := res.Header.Get("Cache-Control"); cache != tc.cache {
+			t.Error(tc.name, "expect", tc.cache, "got", cache)
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		strs := msg.STRHistoryRange().STR
+		if len(strs) != tc.strs {
+			t.Error(tc.name, "expect", tc.strs, "STRs, got", len(strs))
+		}
+		// the client's copy is still valid
+		if res, _ := get(tc.path, res.Header.Get("ETag")); res.StatusCode != http.StatusNotModified {
+			t.Error(tc.name, "expect status", http.StatusNotModified, "got", res.StatusCode)
+		}
+	}
+
+	if res, _ := get("/lookup", ""); res.StatusCode != http.StatusNotFound {
+		t.Fatal("Expect status", http.StatusNotFound, "got", res.StatusCode)
+	}
+}
+
+func TestHTTPRESTAPI(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, clock := newTestServer(t, 60, false, "", dir)
+	conf.Addresses[0].HTTP = true
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	do := func(method, path, contentType, accept, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, "https://127.0.0.1:3000"+path,
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		buf, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, buf
+	}
+
+	const registration = `{"Username": "alice", "Key": "a2V5"}`
+	res, body := do("POST", application.HTTPRegisterPath, "application/json", "", registration)
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("Expect status", http.StatusOK, "got", res.StatusCode, string(body))
+	}
+	if cache := res.Header.Get("Cache-Control"); cache != "no-store" {
+		t.Fatal("Expect", "no-store", "got", cache)
+	}
+	msg := application.UnmarshalResponse(protocol.RegistrationType, body)
+	if df := msg.DirectoryProof(); df == nil || df.TB == nil {
+		t.Fatal("Expect a temporary binding, got", msg.DirectoryResponse)
+	}
+	advanceEpoch(t, server, clock)
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		accept      string
+		body        string
+		status      int
+		reqType     int
+	}{
+		{"lookup", "GET", "/v1/lookup/alice", "", "", "", http.StatusOK, protocol.KeyLookupType},
+		{"lookup in epoch", "GET", "/v1/lookup/alice?epoch=1", "", "", "", http.StatusOK, protocol.KeyLookupInEpochType},
+		{"missing name", "GET", "/v1/lookup/bob", "", "", "", http.StatusNotFound, protocol.KeyLookupType},
+		{"monitoring", "GET", "/v1/monitor/alice?start=1&end=1", "", "", "", http.StatusOK, protocol.MonitoringType},
+		{"STR history", "GET", "/v1/str?start=0&end=1", "", "", "", http.StatusOK, protocol.STRType},
+		{"existing name", "POST", "/v1/register", "application/json", "", registration, http.StatusConflict, protocol.RegistrationType},
+		{"malformed registration", "POST", "/v1/register", "application/json", "", `{"Name": "bob"}`, http.StatusBadRequest, protocol.RegistrationType},
+		{"malformed epoch", "GET", "/v1/lookup/alice?epoch=x", "", "", "", http.StatusBadRequest, protocol.KeyLookupType},
+		{"unsupported media type", "POST", "/v1/register", "text/plain", "", registration, http.StatusUnsupportedMediaType, -1},
+		{"not acceptable", "GET", "/v1/lookup/alice", "", "text/html", "", http.StatusNotAcceptable, -1},
+		{"method not allowed", "POST", "/v1/lookup/alice", "application/json", "", "", http.StatusMethodNotAllowed, -1},
+		{"unknown endpoint", "GET", "/v1/unknown", "", "", "", http.StatusNotFound, -1},
+	} {
+		res, body := do(tc.method, tc.path, tc.contentType, tc.accept, tc.body)
+		if res.StatusCode != tc.status {
+			t.Error(tc.name, "expect status", tc.status, "got", res.StatusCode)
+			continue
+		}
+		if tc.reqType < 0 {
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+			t.Error(tc.name, "expect", "application/json", "got", ct)
+		}
+		msg := application.UnmarshalResponse(tc.reqType, body)
+		if tc.status == http.StatusOK && msg.Error != protocol.ReqSuccess {
+			t.Error(tc.name, "expect", protocol.ReqSuccess, "got", msg.Error)
+		}
+	}
+
+	// a client which prefers the protobuf encoding
+	res, body = do("GET", "/v1/lookup/alice", "", "application/json;q=0.5, application/x-protobuf", "")
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Fatal("Expect", "application/x-protobuf", "got", ct)
+	}
+	msg = application.UnmarshalResponsePB(protocol.KeyLookupType, body)
+	if msg.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", msg.Error)
+	}
+}
@@ -0,0 +1,185 @@
+// Implements the metadata store in which a key server's operator keeps
+// operational data about the server's users, e.g., the bot which
+// verified a user's registration. The store is kept apart from the
+// directory: the server never consults it to answer a request, so it
+// can't influence any proof the server issues.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/storage/kv/leveldbkv"
+)
+
+var (
+	// ErrNoMetadata indicates that the metadata store has no entry
+	// for a username.
+	ErrNoMetadata = errors.New("[coniksserver] No metadata for the username")
+)
+
+// The admin commands with which an operator reads and writes the
+// server's metadata store (see Config.AdminAddress).
+// GetMetadataCommand and DeleteMetadataCommand take a username, and
+// SetMetadataCommand takes a JSON-encoded UserMetadata.
+const (
+	GetMetadataCommand    = "get-metadata"
+	SetMetadataCommand    = "set-metadata"
+	DeleteMetadataCommand = "delete-metadata"
+)
+
+// UserMetadata is the operational data kept about the user Username.
+// Source is the suffix of the bot which attested the user's
+// registration, if any, ProofURL locates the proof of the user's
+// identity the bot verified, and Flags lists the operator's notes
+// about the user, e.g., abuse reports.
+type UserMetadata struct {
+	Username string
+	Source   string   `json:",omitempty"`
+	ProofURL string   `json:",omitempty"`
+	Flags    []string `json:",omitempty"`
+}
+
+// A MetadataStore keeps the UserMetadata of the server's users in its
+// own database, indexed by username.
+type MetadataStore struct {
+	lock sync.Mutex // serializes the updates
+	db   kv.DB
+}
+
+// OpenMetadataStore opens the metadata store whose database is at path,
+// creating it if necessary. The database must not be the one in which
+// the server persists its directory.
+func OpenMetadataStore(path string) *MetadataStore {
+	return &MetadataStore{db: leveldbkv.OpenDB(path)}
+}
+
+// Get returns the metadata of the user name,
+// or ErrNoMetadata if there is none.
+func (s *MetadataStore) Get(name string) (*UserMetadata, error) {
+	buf, err := s.db.Get([]byte(name))
+	if err == s.db.ErrNotFound() {
+		return nil, ErrNoMetadata
+	} else if err != nil {
+		return nil, err
+	}
+	md := new(UserMetadata)
+	if err := json.Unmarshal(buf, md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// Put stores md as the metadata of the user md.Username,
+// replacing the previous metadata, if any.
+func (s *MetadataStore) Put(md *UserMetadata) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.put(md)
+}
+
+func (s *MetadataStore) put(md *UserMetadata) error {
+	buf, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return s.db.Put([]byte(md.Username), buf)
+}
+
+// Update atomically updates the metadata of the user name with f,
+// starting from empty metadata if there is none.
+func (s *MetadataStore) Update(name string, f func(md *UserMetadata)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	md, err := s.Get(name)
+	if err == ErrNoMetadata {
+		md, err = &UserMetadata{Username: name}, nil
+	}
+	if err != nil {
+		return err
+	}
+	f(md)
+	return s.put(md)
+}
+
+// Delete removes the metadata of the user name.
+func (s *MetadataStore) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.db.Delete([]byte(name))
+}
+
+// Close closes the store's database.
+func (s *MetadataStore) Close() error {
+	return s.db.Close()
+}
+
+// handleAdminCommand runs the admin command cmd (see GetMetadataCommand)
+// against the metadata store, and returns its reply: the JSON-encoded
+// metadata for GetMetadataCommand, "OK" for the other commands, or the
+// error which occurred while running the command.
+func (s *MetadataStore) handleAdminCommand(cmd string) string {
+	args := strings.SplitN(cmd, " ", 2)
+	if len(args) != 2 || args[1] == "" {
+		return "Unknown command " + cmd
+	}
+	switch args[0] {
+	case GetMetadataCommand:
+		md, err := s.Get(args[1])
+		if err != nil {
+			return err.Error()
+		}
+		buf, err := json.Marshal(md)
+		if err != nil {
+			return err.Error()
+		}
+		return string(buf)
+	case SetMetadataCommand:
+		md := new(UserMetadata)
+		if err := json.Unmarshal([]byte(args[1]), md); err != nil {
+			return fmt.Sprintf("Malformed metadata: %v", err)
+		}
+		if md.Username == "" {
+			return "Malformed metadata: no username"
+		}
+		if err := s.Put(md); err != nil {
+			return err.Error()
+		}
+		return "OK"
+	case DeleteMetadataCommand:
+		if err := s.Delete(args[1]); err != nil {
+			return err.Error()
+		}
+		return "OK"
+	default:
+		return "Unknown command " + cmd
+	}
+}
+
+// recordSource records the suffix of the bot which attested the
+// registration of the user name in the metadata store, if any.
+// A failure is only logged, since the registration has already
+// succeeded.
+func (server *ConiksServer) recordSource(name string) {
+	if server.metadata == nil {
+		return
+	}
+	var source string
+	for _, suffix := range server.botSuffixes {
+		if strings.HasSuffix(name, suffix) && len(suffix) > len(source) {
+			source = suffix
+		}
+	}
+	err := server.metadata.Update(name, func(md *UserMetadata) {
+		md.Source = source
+	})
+	if err != nil {
+		server.Logger().Error("Cannot record the registration source",
+			"username", name, "error", err.Error())
+	}
+}
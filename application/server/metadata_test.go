@@ -0,0 +1,81 @@
+package server
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestMetadataAdminCommands(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, _ := newTestServer(t, 60, false, "", dir)
+	server.metadata = OpenMetadataStore(path.Join(dir, "metadata.db"))
+	server.adminAddr = path.Join(dir, "admin.sock")
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	for _, tc := range []struct {
+		cmd  string
+		want string
+	}{
+		{GetMetadataCommand + " alice", ErrNoMetadata.Error()},
+		{SetMetadataCommand + ` {"Username":"alice","Flags":["spam"]}`, "OK"},
+		{GetMetadataCommand + " alice", `{"Username":"alice","Flags":["spam"]}`},
+		{SetMetadataCommand + ` {"Flags":["spam"]}`, "Malformed metadata: no username"},
+		{DeleteMetadataCommand + " alice", "OK"},
+		{GetMetadataCommand + " alice", ErrNoMetadata.Error()},
+		{"restart", "Unknown command restart"},
+	} {
+		reply, err := application.SendAdminCommand(server.adminAddr, tc.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != tc.want {
+			t.Error(tc.cmd, "expect", tc.want, "got", reply)
+		}
+	}
+}
+
+func TestMetadataRecordsRegistrationSource(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, _, clock := newTestServer(t, 60, true, "", dir)
+	server.metadata = OpenMetadataStore(path.Join(dir, "metadata.db"))
+	defer server.metadata.Close()
+	botKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := botKey.Public()
+	server.dir.SetAttestationKeys(map[string]sign.PublicKey{"@twitter": pk})
+	server.botSuffixes = []string{"@twitter"}
+
+	name := "alice@twitter"
+	if err := server.metadata.Put(&UserMetadata{Username: name, ProofURL: "https://twitter.com/alice"}); err != nil {
+		t.Fatal(err)
+	}
+	res := server.handleAttestedRequests(&protocol.Request{
+		Type: protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{
+			Username:    name,
+			Key:         []byte{1, 2, 3},
+			Attestation: protocol.NewRegistrationAttestation(botKey, name, clock.Now().Add(time.Minute)),
+		},
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	md, err := server.metadata.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Source != "@twitter" || md.ProofURL != "https://twitter.com/alice" {
+		t.Fatal("Expect the registration source to be recorded, got", md)
+	}
+}
@@ -9,20 +9,77 @@ import (
 // Policies contains a server's CONIKS policies configuration
 // including paths to the VRF private key, the signing private
 // key and the epoch deadline value in seconds.
+// VRFAlgorithm selects the VRF construction of the VRF private key
+// (see vrf.Algorithm), and defaults to vrf.Ed25519SHA3Elligator.
+// SaltKeyPath optionally points to the master secret from which the
+// server derives its commitment salts (see crypto.DeriveSalt()),
+// instead of generating random salts.
+// PublishDocument indicates whether the server publishes its policy
+// document (see protocol.PolicyDocument).
+// Hash optionally selects the hash algorithm of the server's tree by
+// the name of a registered hasher, e.g., "SHA256" or "BLAKE2b"
+// (see directory.SetHasher()), and defaults to hashers.SHAKE128.
+// HashSize optionally truncates the hashes of the server's tree to
+// the given number of bytes (see directory.SetHashSize()), and defaults
+// to crypto.HashSizeByte.
+// BootstrapPath optionally points to a seed file signed with the
+// server's signing key (see protocol.BootstrapSeed), whose bindings
+// are included in the initial STR of a newly created directory.
+// NamespaceBits optionally maps the usernames of each provider, e.g.,
+// "alice@example.org", into the provider's subtree of the given depth
+// (see protocol.NewNamespacedVRF()). Since it changes the private
+// indices of these usernames, it can't be changed once the directory
+// contains any of them.
+// Names optionally reserves username suffixes to account verification
+// bots (see NamePolicy), and is published in the server's policy
+// document.
 type Policies struct {
-	EpochDeadline protocol.Timestamp `toml:"epoch_deadline"`
-	VRFKeyPath    string             `toml:"vrf_key_path"`
-	SignKeyPath   string             `toml:"sign_key_path"` // it should be a part of policies, see #47
-	vrfKey        vrf.PrivateKey
-	signKey       sign.PrivateKey
+	EpochDeadline   protocol.Timestamp `toml:"epoch_deadline"`
+	VRFAlgorithm    vrf.Algorithm      `toml:"vrf_algorithm,omitempty"`
+	VRFKeyPath      string             `toml:"vrf_key_path"`
+	SignKeyPath     string             `toml:"sign_key_path"` // it should be a part of policies, see #47
+	SaltKeyPath     string             `toml:"salt_key_path,omitempty"`
+	PublishDocument bool               `toml:"publish_document,omitempty"`
+	Hash            string             `toml:"hash,omitempty"`
+	HashSize        int                `toml:"hash_size,omitempty"`
+	BootstrapPath   string             `toml:"bootstrap_path,omitempty"`
+	NamespaceBits   uint32             `toml:"namespace_bits,omitempty"`
+	Names           []*NamePolicy      `toml:"names,omitempty"`
+	vrfKey          vrf.VRF
+	signKey         sign.PrivateKey
+	saltKey         []byte
+	bootstrap       *protocol.BootstrapSeed
+}
+
+// A NamePolicy reserves the usernames ending in Suffix (e.g.,
+// "@twitter") to the account verification bot whose public key is at
+// AuthorityKeyPath: the server only registers these usernames with an
+// attestation by this bot (see protocol.NamePolicy).
+type NamePolicy struct {
+	Suffix           string `toml:"suffix"`
+	AuthorityKeyPath string `toml:"authority_key_path"`
+	key              sign.PublicKey
+}
+
+// namePolicies returns the name policies of the policies p.
+func (p *Policies) namePolicies() protocol.NamePolicies {
+	policies := make(protocol.NamePolicies, 0, len(p.Names))
+	for _, np := range p.Names {
+		policies = append(policies, protocol.NamePolicy{
+			Suffix:    np.Suffix,
+			Authority: np.key,
+		})
+	}
+	return policies
 }
 
 // NewPolicies initializes a new Policies struct.
 func NewPolicies(epDeadline protocol.Timestamp, vrfKeyPath,
-	signKeyPath string, vrfKey vrf.PrivateKey,
+	signKeyPath string, vrfKey vrf.VRF,
 	signKey sign.PrivateKey) *Policies {
 	return &Policies{
 		EpochDeadline: epDeadline,
+		VRFAlgorithm:  vrfKey.Algorithm(),
 		VRFKeyPath:    vrfKeyPath,
 		SignKeyPath:   signKeyPath,
 		vrfKey:        vrfKey,
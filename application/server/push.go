@@ -0,0 +1,170 @@
+// This module implements the STR pushes of a key server, which
+// submits each new STR to its auditors right after issuing it
+// (see protocol.STRPush), and records the auditors' acknowledgements.
+
+package server
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+var (
+	// ErrUnknownScheme indicates that an auditor's address
+	// is neither a TCP nor a Unix socket address.
+	ErrUnknownScheme = errors.New("[coniksserver] Unknown scheme of the auditor's address")
+)
+
+// An AuditorAck records the latest acknowledgement of the STR pushes
+// a key server has received from one of its auditors.
+// Epoch is the latest epoch the auditor has acknowledged, and Time is
+// the time of the acknowledgement. Error is the error of the latest
+// push, e.g., a protocol.CheckBadSTR if the auditor has observed
+// a different STR for one of the pushed epochs, or nil.
+type AuditorAck struct {
+	Address string
+	Epoch   uint64
+	Time    time.Time
+	Error   error
+}
+
+// An strPusher pushes the STRs of a key server's directory
+// to the server's auditors.
+type strPusher struct {
+	dirInitHash [crypto.HashSizeByte]byte
+	send        func(addr string, msg []byte) ([]byte, error)
+
+	lock sync.Mutex
+	acks map[string]*AuditorAck
+	busy map[string]bool
+}
+
+func newSTRPusher(initSTR *protocol.DirSTR, addrs []string) *strPusher {
+	p := &strPusher{
+		dirInitHash: auditor.ComputeDirectoryIdentity(initSTR),
+		send:        sendToAuditor,
+		acks:        make(map[string]*AuditorAck, len(addrs)),
+		busy:        make(map[string]bool, len(addrs)),
+	}
+	for _, addr := range addrs {
+		p.acks[addr] = &AuditorAck{Address: addr}
+	}
+	return p
+}
+
+// pushSTRs pushes the directory's STRs to each auditor in the
+// background, starting at the first epoch the auditor hasn't
+// acknowledged yet. The directory must not be modified during the call.
+// An auditor which hasn't acknowledged the previous push yet is
+// skipped; it receives the STRs of this epoch with the next push.
+func (server *ConiksServer) pushSTRs() {
+	p := server.pusher
+	latest := server.dir.LatestSTR()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for addr, ack := range p.acks {
+		if p.busy[addr] {
+			continue
+		}
+		strs := []*protocol.DirSTR{latest}
+		if !ack.Time.IsZero() && ack.Epoch < latest.Epoch {
+			res := server.dir.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: ack.Epoch + 1,
+				EndEpoch:   latest.Epoch,
+			})
+			if res.Error == protocol.ReqSuccess {
+				strs = res.STRHistoryRange().STR
+			}
+		}
+		p.busy[addr] = true
+		addr := addr
+		server.RunInBackground(func() {
+			server.pushTo(addr, strs)
+		})
+	}
+}
+
+// pushTo pushes the STRs strs to the auditor at addr,
+// and records the auditor's acknowledgement.
+func (server *ConiksServer) pushTo(addr string, strs []*protocol.DirSTR) {
+	p := server.pusher
+	var ackEpoch uint64
+	msg, err := application.MarshalRequest(protocol.STRPushType,
+		&protocol.STRPush{DirInitSTRHash: p.dirInitHash, STR: strs})
+	if err == nil {
+		var buf []byte
+		if buf, err = p.send(addr, msg); err == nil {
+			res := application.UnmarshalResponse(protocol.STRPushType, buf)
+			if ack := res.STRPushAck(); ack != nil {
+				ackEpoch = ack.Epoch
+			}
+			if res.Error != protocol.ReqSuccess {
+				err = res.Error
+			}
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.busy[addr] = false
+	ack := p.acks[addr]
+	ack.Error = err
+	switch {
+	case err == protocol.CheckBadSTR:
+		server.Logger().Error("Auditor observed a different STR",
+			"auditor", addr, "epoch", ackEpoch)
+	case err != nil:
+		server.Logger().Warn("Cannot push STRs to the auditor",
+			"auditor", addr, "error", err.Error())
+		return
+	default:
+		server.Logger().Info("Auditor acknowledged STRs",
+			"auditor", addr, "epoch", ackEpoch)
+	}
+	ack.Epoch = ackEpoch
+	ack.Time = time.Now()
+}
+
+// AuditorAcks returns the latest acknowledgement of each auditor the
+// server pushes its STRs to, or nil if the server doesn't push its
+// STRs to any auditor.
+func (server *ConiksServer) AuditorAcks() []AuditorAck {
+	p := server.pusher
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	acks := make([]AuditorAck, 0, len(p.acks))
+	for _, ack := range p.acks {
+		acks = append(acks, *ack)
+	}
+	return acks
+}
+
+// sendToAuditor sends msg to the auditor at addr.
+func sendToAuditor(addr string, msg []byte) ([]byte, error) {
+	return application.SendRequest(msg, addr, nil, nil)
+}
+
+// validateAuditorAddress checks that addr is the URL of an auditor
+// to which the server can push its STRs.
+func validateAuditorAddress(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "tcp", "unix", "mem":
+		return nil
+	default:
+		return ErrUnknownScheme
+	}
+}
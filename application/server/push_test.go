@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+)
+
+// startPushingServer starts a test server which pushes its STRs to
+// an auditor reached directly instead of over the network.
+func startPushingServer(t *testing.T) (*ConiksServer, auditlog.ConiksAuditLog,
+	func(), func()) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	server, conf, clock := newTestServer(t, 60, false, "", dir)
+	server.pusher = newSTRPusher(server.dir.LatestSTR(), []string{"tcp://auditor"})
+
+	// the auditor observes the directory's initial STR
+	buf, _ := json.Marshal(server.dir.LatestSTR())
+	initSTR := new(protocol.DirSTR)
+	json.Unmarshal(buf, initSTR)
+	aud := auditlog.New()
+	pk, _ := conf.Policies.signKey.Public()
+	if err := aud.InitHistory("test-server", pk, []*protocol.DirSTR{initSTR}); err != nil {
+		t.Fatal(err)
+	}
+	server.pusher.send = func(addr string, msg []byte) ([]byte, error) {
+		req, err := application.UnmarshalRequest(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return application.MarshalResponse(aud.ReceiveSTRs(req.Request.(*protocol.STRPush)))
+	}
+
+	server.Run(conf.Addresses)
+	return server, aud, func() {
+			advanceEpoch(t, server, clock)
+		}, func() {
+			server.Shutdown()
+			teardown()
+		}
+}
+
+// waitForAck waits until the auditor has acknowledged the server's
+// latest epoch, and returns the acknowledgement.
+func waitForAck(t *testing.T, server *ConiksServer) AuditorAck {
+	timeout := time.After(5 * time.Second)
+	for {
+		ack := server.AuditorAcks()[0]
+		if ack.Epoch == server.dir.LatestSTR().Epoch {
+			return ack
+		}
+		select {
+		case <-timeout:
+			t.Fatal("Expect the auditor to acknowledge epoch", server.dir.LatestSTR().Epoch)
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestPushSTRs(t *testing.T) {
+	server, aud, advance, teardown := startPushingServer(t)
+	defer teardown()
+
+	for i := 0; i < 3; i++ {
+		advance()
+		ack := waitForAck(t, server)
+		if ack.Error != nil || ack.Time.IsZero() {
+			t.Fatal("Expect", nil, "got", ack.Error)
+		}
+	}
+	ep := aud.LatestObservedSTR(server.pusher.dirInitHash).Epoch
+	if ep != server.dir.LatestSTR().Epoch {
+		t.Fatal("Expect", server.dir.LatestSTR().Epoch, "got", ep)
+	}
+}
+
+func TestPushSTRsCatchUp(t *testing.T) {
+	server, _, advance, teardown := startPushingServer(t)
+	defer teardown()
+
+	advance()
+	waitForAck(t, server)
+	// the auditor misses the push of an epoch
+	send := server.pusher.send
+	server.pusher.send = func(addr string, msg []byte) ([]byte, error) {
+		return nil, ErrUnknownScheme
+	}
+	advance()
+	timeout := time.After(5 * time.Second)
+	for server.AuditorAcks()[0].Error == nil {
+		select {
+		case <-timeout:
+			t.Fatal("Expect the push to fail")
+		default:
+			runtime.Gosched()
+		}
+	}
+	server.pusher.send = send
+	advance()
+	if ack := waitForAck(t, server); ack.Error != nil || ack.Epoch != 3 {
+		t.Fatal("Expect the auditor to acknowledge epoch", 3, "got", ack.Epoch, ack.Error)
+	}
+}
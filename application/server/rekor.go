@@ -0,0 +1,169 @@
+// This module implements the publication of a key server's STRs in
+// a Rekor transparency log (see package protocol/rekor), which is an
+// independent witness of the directory's history for its clients and
+// auditors.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A RekorConfig specifies the Rekor log in which a key server publishes
+// each of its STRs, signed with the directory's signing key.
+// EntriesPath is the directory in which the server stores the log's
+// entry for each published epoch, with its inclusion proof
+// (see ConiksServer.RekorEntry()).
+type RekorConfig struct {
+	*application.RekorConfig
+	EntriesPath string `toml:"entries_path"`
+}
+
+// Load reads the log's public key and resolves the entries path
+// relative to the given config file.
+func (conf *RekorConfig) Load(file string) error {
+	if conf.RekorConfig == nil {
+		return fmt.Errorf("Missing URL of the Rekor log")
+	}
+	if err := conf.RekorConfig.Load(file); err != nil {
+		return err
+	}
+	if conf.EntriesPath == "" {
+		return fmt.Errorf("Missing entries path of the Rekor log")
+	}
+	conf.EntriesPath = utils.ResolvePath(conf.EntriesPath, file)
+	return os.MkdirAll(conf.EntriesPath, 0700)
+}
+
+// An rekorPublisher publishes the STRs of a key server's directory
+// in a Rekor log.
+type rekorPublisher struct {
+	client      *application.RekorClient
+	pk          sign.PublicKey
+	entriesPath string
+
+	lock sync.Mutex
+	busy bool
+	next uint64 // the first epoch which isn't published yet
+}
+
+func newRekorPublisher(conf *RekorConfig, pk sign.PublicKey,
+	next uint64) *rekorPublisher {
+	return &rekorPublisher{
+		client:      application.NewRekorClient(conf.RekorConfig),
+		pk:          pk,
+		entriesPath: conf.EntriesPath,
+		next:        next,
+	}
+}
+
+// publishSTRs publishes the directory's STRs which aren't published yet
+// in the background. The directory must not be modified during the
+// call. If the previous publication hasn't completed yet, its STRs are
+// published with the next call.
+func (server *ConiksServer) publishSTRs() {
+	p := server.publisher
+	latest := server.dir.LatestSTR()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.busy || p.next > latest.Epoch {
+		return
+	}
+	strs := []*protocol.DirSTR{latest}
+	if p.next < latest.Epoch {
+		res := server.dir.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: p.next,
+			EndEpoch:   latest.Epoch,
+		})
+		if res.Error == protocol.ReqSuccess {
+			strs = res.STRHistoryRange().STR
+		}
+	}
+	p.busy = true
+	server.RunInBackground(func() {
+		server.publish(strs)
+	})
+}
+
+// publish publishes the STRs strs in order, and stores the log's entry
+// for each of them, until one fails.
+func (server *ConiksServer) publish(strs []*protocol.DirSTR) {
+	p := server.publisher
+	next := strs[0].Epoch
+	var err error
+	for _, str := range strs {
+		var e *rekor.Entry
+		if e, err = p.client.Publish(str, p.pk); err != nil {
+			break
+		}
+		if err = p.saveEntry(str.Epoch, e); err != nil {
+			break
+		}
+		// the following STRs are signed with the key handed over to
+		if str.Policies != nil && str.Policies.NextSigningKey != nil {
+			p.pk = str.Policies.NextSigningKey
+		}
+		next = str.Epoch + 1
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.busy = false
+	if next > p.next {
+		p.next = next
+	}
+	if err != nil {
+		server.Logger().Warn("Cannot publish the STR in the Rekor log",
+			"epoch", next, "error", err.Error())
+		return
+	}
+	server.Logger().Info("Published STRs in the Rekor log", "epoch", next-1)
+}
+
+func (p *rekorPublisher) entryPath(epoch uint64) string {
+	return filepath.Join(p.entriesPath, fmt.Sprintf("%d.json", epoch))
+}
+
+// saveEntry atomically stores the log's entry e for the given epoch.
+func (p *rekorPublisher) saveEntry(epoch uint64, e *rekor.Entry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	path := p.entryPath(epoch)
+	if err := ioutil.WriteFile(path+".tmp", buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// RekorEntry returns the entry of the Rekor log which records the STR
+// for the given epoch, as stored by the server when it published the
+// STR. It returns an os.IsNotExist error if the server hasn't published
+// this STR, or doesn't publish its STRs.
+func (server *ConiksServer) RekorEntry(epoch uint64) (*rekor.Entry, error) {
+	p := server.publisher
+	if p == nil {
+		return nil, os.ErrNotExist
+	}
+	buf, err := ioutil.ReadFile(p.entryPath(epoch))
+	if err != nil {
+		return nil, err
+	}
+	e := new(rekor.Entry)
+	if err := json.Unmarshal(buf, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+)
+
+// waitForEntry waits until the server has stored the Rekor entry of the
+// given epoch, and returns it.
+func waitForEntry(t *testing.T, server *ConiksServer, epoch uint64) *rekor.Entry {
+	timeout := time.After(5 * time.Second)
+	for {
+		e, err := server.RekorEntry(epoch)
+		if err == nil {
+			return e
+		}
+		if !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		select {
+		case <-timeout:
+			t.Fatal("Expect the server to publish epoch", epoch)
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestPublishSTRs(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	log := rekor.NewTestLog(t)
+	url, keyPath, stop := testutil.NewRekorServer(t, log, dir)
+	defer stop()
+
+	server, conf, clock := newTestServer(t, 60, false, "", dir)
+	rekorConf := &RekorConfig{
+		RekorConfig: &application.RekorConfig{URL: url, PublicKeyPath: keyPath},
+		EntriesPath: "entries",
+	}
+	if err := rekorConf.Load(dir + "/config.toml"); err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := conf.Policies.signKey.Public()
+	server.publisher = newRekorPublisher(rekorConf, pk, 0)
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	waitForEntry(t, server, 0)
+	for i := 0; i < 3; i++ {
+		advanceEpoch(t, server, clock)
+		waitForEntry(t, server, server.dir.LatestSTR().Epoch)
+	}
+	client := application.NewRekorClient(rekorConf.RekorConfig)
+	res := server.dir.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 0,
+		EndEpoch:   server.dir.LatestSTR().Epoch,
+	})
+	for _, str := range res.STRHistoryRange().STR {
+		e := waitForEntry(t, server, str.Epoch)
+		if err := e.Verify(str, pk, rekorConf.LogKey); err != nil {
+			t.Fatal("Expect", nil, "got", err)
+		}
+		found, err := client.Lookup(str, pk)
+		if err != nil {
+			t.Fatal("Expect", nil, "got", err)
+		}
+		if found.LogIndex != e.LogIndex {
+			t.Fatal("Expect", e.LogIndex, "got", found.LogIndex)
+		}
+	}
+
+	// republishing an STR returns the existing entry
+	str := server.dir.LatestSTR()
+	e, err := client.Publish(str, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.LogIndex != int64(str.Epoch) {
+		t.Fatal("Expect", str.Epoch, "got", e.LogIndex)
+	}
+}
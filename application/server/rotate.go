@@ -0,0 +1,50 @@
+// Implements the rotation of the server's signing key by its operator
+// through the server's admin socket (see Config.AdminAddress).
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+)
+
+// RotateSigningKeyCommand is the admin command with which an operator
+// hands the signing of the directory's STRs over to the private key
+// stored at a path, which the command takes (see
+// directory.ConiksDirectory.RotateSigningKey()). The STR of the second
+// next epoch declares the new key and is signed with both keys, so
+// that the clients and auditors learn the new key from it. If the
+// server persists its directory, the rotation is stored in its
+// database, and the server resumes the handover after a restart, so
+// the server's configuration can keep the previous key until then.
+const RotateSigningKeyCommand = "rotate-signing-key"
+
+// handleRotateSigningKeyCommand runs the RotateSigningKeyCommand cmd,
+// and returns its reply, or the error which occurred while running
+// the command.
+func (server *ConiksServer) handleRotateSigningKeyCommand(cmd string) string {
+	args := strings.Fields(cmd)
+	if len(args) != 2 {
+		return fmt.Sprintf("Usage: %s PATH", RotateSigningKeyCommand)
+	}
+	key, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return fmt.Sprintf("Cannot read signing key: %v", err)
+	}
+	if len(key) != sign.PrivateKeySize {
+		return fmt.Sprintf("Signing key must be %d bytes (got %d)",
+			sign.PrivateKeySize, len(key))
+	}
+	server.Lock()
+	defer server.Unlock()
+	if err := server.dir.RotateSigningKey(key); err != nil {
+		return err.Error()
+	}
+	epoch := server.dir.LatestSTR().Epoch + 2
+	server.Logger().Info("Signing key rotated by the operator",
+		"epoch", epoch)
+	return fmt.Sprintf("OK, the STR of epoch %d hands the signing over to the new key", epoch)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+func TestRotateSigningKeyCommand(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	_, conf, clock := newTestServer(t, 60, false, "", dir)
+	conf.InitSTRPath = path.Join(dir, "init.str")
+	conf.LatestSTRPath = path.Join(dir, "latest.str")
+	conf.DatabasePath = path.Join(dir, "coniks.db")
+	key, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := key.Public()
+	keyPath := path.Join(dir, "next.sk")
+	if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pkPath := path.Join(dir, "next.pk")
+	if err := ioutil.WriteFile(pkPath, pk, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newConiksServer(conf, clock)
+	for _, tc := range []struct {
+		cmd   string
+		reply string
+	}{
+		{RotateSigningKeyCommand, "Usage: rotate-signing-key PATH"},
+		{RotateSigningKeyCommand + " " + pkPath,
+			"Signing key must be 64 bytes (got 32)"},
+		{RotateSigningKeyCommand + " " + keyPath,
+			"OK, the STR of epoch 2 hands the signing over to the new key"},
+		{RotateSigningKeyCommand + " " + keyPath,
+			merkletree.ErrRotationPending.Error()},
+	} {
+		if reply := server.handleAdminCommand(tc.cmd); reply != tc.reply {
+			t.Error(tc.cmd, "expect", tc.reply, "got", reply)
+		}
+	}
+
+	// the server resumes the handover after a restart with its
+	// previous key
+	for i := 0; i < 3; i++ {
+		if err := server.update(); err != nil {
+			t.Fatal(err)
+		}
+		server.db.Close()
+		server = newConiksServer(conf, clock)
+	}
+	defer server.db.Close()
+	str := server.dir.LatestSTR()
+	if str.Epoch != 3 || !pk.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Expect the STR of epoch 3 to be signed with the new key")
+	}
+}
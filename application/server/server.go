@@ -1,9 +1,18 @@
 package server
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
 	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/storage/kv/leveldbkv"
 	"github.com/coniks-sys/coniks-go/utils"
 )
 
@@ -13,14 +22,42 @@ import (
 // be run by a first-party identity provider or
 // a third-party communication service.
 //
-// Allowing registration has to be specified explicitly for each connection.
-// Other types of requests are allowed by default.
+// Allowing registration, and key changes, has to be specified explicitly
+// for each connection.
+// Other types of requests are allowed by default, except for the
+// requests of auditors and mirrors (see application.AuditorRequests),
+// which an address can deny. The requests which clients send to
+// auditors (see application.AuditingRequests) are never accepted.
 // One can think of a registration as a "write" to a key directory,
 // while the other request types are "reads".
 // So, by default, addresses are "read-only".
+// An address which requires attestations accepts the registrations
+// sent directly by the clients, provided that they include a valid
+// attestation by one of the account verification bots the server
+// trusts (see directory.RegisterWithAttestation()).
+// Unless the address is labeled explicitly, its listeners are labeled
+// "registration" if it allows registration, and "public" otherwise.
 type Address struct {
 	*application.ServerAddress
-	AllowRegistration bool `toml:"allow_registration,omitempty"`
+	AllowRegistration  bool `toml:"allow_registration,omitempty"`
+	RequireAttestation bool `toml:"require_attestation,omitempty"`
+	DenyAuditors       bool `toml:"deny_auditors,omitempty"`
+}
+
+// permissions returns the request permissions of the address addr.
+func (addr *Address) permissions() map[int]bool {
+	perms := application.Permissions(application.ClientLookupRequests)
+	if !addr.DenyAuditors {
+		perms = application.Permissions(application.ClientLookupRequests,
+			application.AuditorRequests)
+	}
+	perms[protocol.RegistrationType] = addr.AllowRegistration ||
+		addr.RequireAttestation
+	perms[protocol.KeyChangeType] = addr.AllowRegistration
+	perms[protocol.DeactivationType] = addr.AllowRegistration
+	// aborts are signed with the user's previous key
+	perms[protocol.KeyChangeAbortType] = true
+	return perms
 }
 
 // A ConiksServer represents a CONIKS key server.
@@ -32,22 +69,48 @@ type Address struct {
 type ConiksServer struct {
 	*application.ServerBase
 	dir        *directory.ConiksDirectory
+	db         kv.DB // nil if the directory isn't persisted
 	epochTimer *application.EpochTimer
+	hasBots    bool            // whether the server trusts any verification bot
+	pusher     *strPusher      // nil if the server doesn't push its STRs
+	publisher  *rekorPublisher // nil if the server doesn't publish its STRs
+
+	botSuffixes []string       // the suffixes of the usernames the bots verify
+	metadata    *MetadataStore // nil if the server keeps no metadata
+	adminAddr   string
+	admin       net.Listener // nil if the server has no admin socket
+
+	// statsAddr is the address of the read-only HTTP endpoint of the
+	// tree stats (see Config.StatsAddress), and stats its server,
+	// nil if the endpoint is disabled
+	statsAddr   string
+	stats       *http.Server
+	statsServed chan struct{}
+
+	// latestSTRPath is the path at which the server records the
+	// latest STR it has issued, "" if it keeps no record
+	latestSTRPath string
+
+	// beacon returns the latest value of the randomness beacon mixed
+	// into the STRs, nil if the server uses no beacon
+	beacon func() (*protocol.BeaconValue, error)
 }
 
 // NewConiksServer creates a new reference implementation of
 // a CONIKS key server.
 func NewConiksServer(conf *Config) *ConiksServer {
+	return newConiksServer(conf, utils.RealClock)
+}
+
+// newConiksServer creates a new key server whose epochs follow
+// the given clock.
+func newConiksServer(conf *Config, clock utils.Clock) *ConiksServer {
 	// determine this server's request permissions
 	perms := make(map[*application.ServerAddress]map[int]bool)
 
 	for i := 0; i < len(conf.Addresses); i++ {
 		addr := conf.Addresses[i]
-		perms[addr.ServerAddress] = make(map[int]bool)
-		perms[addr.ServerAddress][protocol.KeyLookupType] = true
-		perms[addr.ServerAddress][protocol.KeyLookupInEpochType] = true
-		perms[addr.ServerAddress][protocol.MonitoringType] = true
-		perms[addr.ServerAddress][protocol.RegistrationType] = addr.AllowRegistration
+		perms[addr.ServerAddress] = addr.permissions()
 	}
 
 	// create server instance
@@ -56,13 +119,128 @@ func NewConiksServer(conf *Config) *ConiksServer {
 
 	server := &ConiksServer{
 		ServerBase: sb,
-		dir: directory.New(
-			conf.Policies.EpochDeadline,
-			conf.Policies.vrfKey,
-			conf.Policies.signKey,
-			conf.LoadedHistoryLength,
-			true),
-		epochTimer: application.NewEpochTimer(conf.EpochDeadline),
+		epochTimer: application.NewEpochTimerWithClock(clock, conf.EpochDeadline),
+		hasBots:    len(conf.Bots) > 0,
+		adminAddr:  conf.AdminAddress,
+		statsAddr:  conf.StatsAddress,
+	}
+
+	if !server.restoreDirectory(conf) {
+		server.createDirectory(conf)
+	}
+	server.latestSTRPath = latestSTRPath(conf)
+	if err := saveLatestSTR(server.latestSTRPath, server.dir.LatestSTR()); err != nil {
+		panic(err)
+	}
+	server.dir.SetClock(clock)
+	server.dir.SetHashWorkers(conf.HashWorkers)
+	if err := setPolicies(server.dir, conf); err != nil {
+		panic(err)
+	}
+	if conf.Policies.saltKey != nil {
+		server.dir.AuditSalts(func(name string, epoch uint64) {
+			server.Logger().Warn("Commitment salt not derived from the salt key",
+				"username", name, "epoch", epoch)
+		})
+	}
+	if conf.Limits != nil {
+		server.dir.SetLimits(directory.Limits(*conf.Limits),
+			func(a *directory.LimitAlert) {
+				server.Logger().Warn("Directory reached a soft limit",
+					"limit", a.Limit, "value", a.Value,
+					"soft limit", a.SoftLimit, "epoch", a.Epoch)
+			})
+	}
+	if server.hasBots {
+		server.dir.SetAttestationKeys(attestationKeys(conf.Bots))
+		server.botSuffixes = botSuffixes(conf.Bots)
+	}
+	if conf.MetadataPath != "" {
+		server.metadata = OpenMetadataStore(conf.MetadataPath)
+	}
+	if conf.BeaconURL != "" {
+		server.beacon = drandBeacon(conf.BeaconURL)
+	}
+	if len(conf.Identifiers) > 0 {
+		policies := make(map[protocol.IdentifierType]protocol.IdentifierPolicy,
+			len(conf.Identifiers))
+		for t, p := range conf.Identifiers {
+			policies[protocol.IdentifierType(t)] = protocol.IdentifierPolicy(*p)
+		}
+		server.dir.SetIdentifierPolicies(policies)
+	}
+	if len(conf.Policies.Names) > 0 {
+		server.dir.SetNamePolicies(conf.Policies.namePolicies())
+	}
+	if conf.Policies.PublishDocument {
+		requireAttestation := false
+		for _, addr := range conf.Addresses {
+			requireAttestation = requireAttestation || addr.RequireAttestation
+		}
+		server.dir.PublishPolicyDocument(requireAttestation)
+	}
+	if conf.LoadShedding {
+		server.SetLoadShedding(server.snapshotHandler)
+	}
+	if len(conf.Auditors) > 0 {
+		initSTR := server.dir.LatestSTR()
+		if initSTR.Epoch != 0 {
+			var err error
+			initSTR, err = application.LoadInitSTR(conf.InitSTRPath, conf.Path)
+			if err != nil {
+				panic(err)
+			}
+		}
+		server.pusher = newSTRPusher(initSTR, conf.Auditors)
+	}
+	if conf.Rekor != nil {
+		pk, _ := conf.Policies.signKey.Public()
+		server.publisher = newRekorPublisher(conf.Rekor, pk,
+			server.dir.LatestSTR().Epoch)
+	}
+	return server
+}
+
+// snapshotHandler returns a request handler which serves key lookups,
+// including batched lookups, from a snapshot of the directory taken before the directory is
+// updated, and returns a message.NewErrorResponse(ReqRetryLater) for
+// all other requests.
+func (server *ConiksServer) snapshotHandler() func(req *protocol.Request) *protocol.Response {
+	snapshot := server.dir.Snapshot()
+	return func(req *protocol.Request) *protocol.Response {
+		switch msg := req.Request.(type) {
+		case *protocol.KeyLookupRequest:
+			return snapshot.KeyLookup(msg)
+		case *protocol.BatchKeyLookupRequest:
+			return snapshot.BatchKeyLookup(msg)
+		}
+		return protocol.NewErrorResponse(protocol.ReqRetryLater)
+	}
+}
+
+// createDirectory creates a new directory from scratch, pre-populated
+// with the bindings of the bootstrap seed, if any, and saves its
+// initial STR.
+func (server *ConiksServer) createDirectory(conf *Config) {
+	dir, err := newDirectory(conf)
+	if err != nil {
+		panic(err)
+	}
+	if err := checkContinuity(conf, dir, false); err != nil {
+		if server.db != nil {
+			server.db.Close()
+		}
+		panic(err)
+	}
+	server.dir = dir
+	if seed := conf.Policies.bootstrap; seed != nil {
+		server.Logger().Info("Directory bootstrapped",
+			"bindings", len(seed.Bindings))
+	}
+	if server.db != nil {
+		if err := server.dir.Persist(server.db, conf.CheckpointInterval); err != nil {
+			panic(err)
+		}
 	}
 
 	// save the initial STR to be used for initializing auditors
@@ -71,63 +249,268 @@ func NewConiksServer(conf *Config) *ConiksServer {
 	// persistent storage.
 	initSTRPath := utils.ResolvePath(conf.InitSTRPath, conf.Path)
 	application.SaveSTR(initSTRPath, server.dir.LatestSTR())
+}
 
-	return server
+// restoreDirectory opens the server's database, if the server is
+// configured to persist its directory, and restores the directory
+// from the latest checkpoint in the database.
+// It returns false if the directory has to be created from scratch.
+// It panics if the restored directory doesn't continue the history
+// the server has issued (see checkContinuity()).
+func (server *ConiksServer) restoreDirectory(conf *Config) bool {
+	if conf.DatabasePath == "" {
+		return false
+	}
+	server.db = leveldbkv.OpenDB(conf.DatabasePath)
+	dir, err := restoreDirectory(server.db, conf)
+	switch err {
+	case nil:
+		if err := checkContinuity(conf, dir, true); err != nil {
+			server.db.Close()
+			panic(err)
+		}
+		server.dir = dir
+		server.Logger().Info("Directory restored",
+			"epoch", dir.LatestSTR().Epoch)
+		return true
+	case merkletree.ErrNoCheckpoint:
+		return false
+	default:
+		panic(err)
+	}
 }
 
-// HandleRequests validates the request message and passes it to the
-// appropriate operation handler according to the request type.
-func (server *ConiksServer) HandleRequests(req *protocol.Request) *protocol.Response {
-	switch req.Type {
-	case protocol.RegistrationType:
-		if msg, ok := req.Request.(*protocol.RegistrationRequest); ok {
-			return server.dir.Register(msg)
+// newDirectory creates a new directory from scratch, following the
+// policies of conf, and pre-populated with the bindings of the
+// bootstrap seed, if any.
+func newDirectory(conf *Config) (*directory.ConiksDirectory, error) {
+	dir := directory.New(
+		conf.Policies.EpochDeadline,
+		conf.Policies.vrfKey,
+		conf.Policies.signKey,
+		conf.LoadedHistoryLength,
+		true)
+	if seed := conf.Policies.bootstrap; seed != nil {
+		// derive the salts of the seed's bindings from the salt key,
+		// as for all other bindings
+		if conf.Policies.saltKey != nil {
+			dir.SetSaltKey(conf.Policies.saltKey)
 		}
-	case protocol.KeyLookupType:
-		if msg, ok := req.Request.(*protocol.KeyLookupRequest); ok {
-			return server.dir.KeyLookup(msg)
+		if err := dir.Bootstrap(seed); err != nil {
+			return nil, err
 		}
-	case protocol.KeyLookupInEpochType:
-		if msg, ok := req.Request.(*protocol.KeyLookupInEpochRequest); ok {
-			return server.dir.KeyLookupInEpoch(msg)
+	}
+	return dir, nil
+}
+
+// restoreDirectory restores the directory persisted in db, following
+// the policies of conf (see directory.Restore()). If db was written by
+// a version which didn't record the directory's identity,
+// restoreDirectory records it from the initial STR saved at
+// conf.InitSTRPath.
+func restoreDirectory(db kv.DB, conf *Config) (*directory.ConiksDirectory, error) {
+	restore := func() (*directory.ConiksDirectory, error) {
+		return directory.Restore(db,
+			conf.CheckpointInterval,
+			conf.Policies.EpochDeadline,
+			conf.Policies.vrfKey,
+			conf.Policies.signKey,
+			conf.LoadedHistoryLength,
+			true)
+	}
+	dir, err := restore()
+	if err != directory.ErrNoIdentity {
+		return dir, err
+	}
+	initSTR, err := application.LoadInitSTR(conf.InitSTRPath, conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := directory.PersistIdentity(db, initSTR); err != nil {
+		return nil, err
+	}
+	return restore()
+}
+
+// setPolicies applies the hasher, the hash size and the salt key of
+// conf to the policies of the directory's next STR.
+func setPolicies(dir *directory.ConiksDirectory, conf *Config) error {
+	if conf.Policies.Hash != "" {
+		if err := dir.SetHasher(conf.Policies.Hash); err != nil {
+			return err
 		}
-	case protocol.MonitoringType:
-		if msg, ok := req.Request.(*protocol.MonitoringRequest); ok {
-			return server.dir.Monitor(msg)
+	}
+	if conf.Policies.HashSize != 0 {
+		if err := dir.SetHashSize(conf.Policies.HashSize); err != nil {
+			return err
 		}
 	}
+	if conf.Policies.saltKey != nil {
+		dir.SetSaltKey(conf.Policies.saltKey)
+	}
+	return nil
+}
+
+// HandleRequests validates the request message and passes it to the
+// appropriate operation handler according to the request type
+// (see directory.ConiksDirectory.Handle()).
+func (server *ConiksServer) HandleRequests(req *protocol.Request) *protocol.Response {
+	return server.dir.Handle(req)
+}
 
-	return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+// handleAttestedRequests passes the registration requests to
+// the directory's RegisterWithAttestation(), and all other requests
+// to HandleRequests().
+func (server *ConiksServer) handleAttestedRequests(req *protocol.Request) *protocol.Response {
+	if msg, ok := req.Request.(*protocol.RegistrationRequest); ok &&
+		req.Type == protocol.RegistrationType {
+		res := server.dir.RegisterWithAttestation(msg)
+		if res.Error == protocol.ReqSuccess {
+			server.recordSource(msg.Username)
+		}
+		return res
+	}
+	return server.HandleRequests(req)
 }
 
 // Run implements the main functionality of the key server.
 // It listens for all declared connections with corresponding
 // permissions.
 func (server *ConiksServer) Run(addrs []*Address) {
+	if server.publisher != nil {
+		server.publishSTRs()
+	}
 	server.RunInBackground(func() {
-		server.EpochUpdate(server.epochTimer, server.dir.Update)
+		server.EpochUpdate(server.epochTimer, server.update)
 	})
 
+	// set the verb before listening, since it's read by the
+	// listeners' goroutines
 	hasRegistrationPerm := false
 	for i := 0; i < len(addrs); i++ {
 		addr := addrs[i]
-		hasRegistrationPerm = hasRegistrationPerm || addr.AllowRegistration
-		if addr.AllowRegistration {
+		allowRegistration := addr.AllowRegistration || addr.RequireAttestation
+		hasRegistrationPerm = hasRegistrationPerm || allowRegistration
+		if allowRegistration {
 			server.Verb = "Accepting registrations"
 		}
-
-		server.ListenAndHandle(addr.ServerAddress, server.HandleRequests)
+	}
+	for i := 0; i < len(addrs); i++ {
+		addr := addrs[i]
+		label := addr.Label
+		if label == "" {
+			label = "public"
+			if addr.AllowRegistration || addr.RequireAttestation {
+				label = "registration"
+			}
+		}
+		handler := server.HandleRequests
+		if addr.RequireAttestation {
+			if !server.hasBots {
+				server.Logger().Warn("No bots configured, all registrations will be rejected",
+					"address", addr.Address)
+			}
+			handler = server.handleAttestedRequests
+		}
+		server.ListenAndHandleAs(addr.ServerAddress, label, handler)
 	}
 
 	if !hasRegistrationPerm {
 		server.Logger().Warn("None of the addresses permit registration")
 	}
 
+	if server.adminAddr != "" {
+		var err error
+		server.admin, err = application.ServeAdmin(server.adminAddr, server.handleAdminCommand)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if server.statsAddr != "" {
+		if err := server.serveTreeStats(server.statsAddr); err != nil {
+			panic(err)
+		}
+	}
+
 	server.RunInBackground(func() {
 		server.HotReload(server.updatePolicies)
 	})
 }
 
+// handleAdminCommand runs the command cmd received on the server's
+// admin socket, and returns its reply.
+func (server *ConiksServer) handleAdminCommand(cmd string) string {
+	switch strings.SplitN(cmd, " ", 2)[0] {
+	case TreeStatsCommand:
+		return server.handleTreeStatsCommand(cmd)
+	case FreezeCommand, UnfreezeCommand:
+		return server.handleFreezeCommand(cmd)
+	case RotateSigningKeyCommand:
+		return server.handleRotateSigningKeyCommand(cmd)
+	}
+	if server.metadata == nil {
+		return "No metadata store configured"
+	}
+	return server.metadata.handleAdminCommand(cmd)
+}
+
+// Shutdown closes the server's admin socket and metadata store, if any,
+// and stops the server.
+func (server *ConiksServer) Shutdown() error {
+	if server.admin != nil {
+		server.admin.Close()
+	}
+	server.stopTreeStats()
+	err := server.ServerBase.Shutdown()
+	if server.metadata != nil {
+		server.metadata.Close()
+	}
+	return err
+}
+
+// update updates the server's directory, notifies the server's
+// webhooks of the new STR (see notifyEpoch()), records the new STR as
+// the latest one (see Config.LatestSTRPath), pushes it to the server's
+// auditors, if any, and publishes it in the server's Rekor log, if any
+// (see Config.Rekor). The new STR includes the latest value of
+// the server's randomness beacon, if any.
+// If the new STR can't be persisted, update returns the error without
+// issuing it, and the server keeps serving the previous STR until
+// a retry succeeds (see application.ServerBase.EpochUpdate()).
+func (server *ConiksServer) update() error {
+	if server.beacon != nil {
+		server.mixBeacon()
+	}
+	prev := server.dir.LatestSTR()
+	if err := server.dir.Update(); err != nil {
+		return fmt.Errorf("Cannot persist the STR for epoch %d: %v",
+			server.dir.LatestSTR().Epoch+1, err)
+	}
+	server.notifyEpoch(prev, server.dir.LatestSTR())
+	if err := saveLatestSTR(server.latestSTRPath, server.dir.LatestSTR()); err != nil {
+		server.Logger().Error("Cannot record the latest STR",
+			"epoch", server.dir.LatestSTR().Epoch, "error", err.Error())
+	}
+	if server.pusher != nil {
+		server.pushSTRs()
+	}
+	if server.publisher != nil {
+		server.publishSTRs()
+	}
+	return nil
+}
+
+// notifyEpoch notifies the server's webhooks of the STR str issued
+// after the STR prev (see application.EventEpoch), and of the changes of
+// the policies between them, if any (see application.EventPolicyChange).
+func (server *ConiksServer) notifyEpoch(prev, str *protocol.DirSTR) {
+	server.Notify(application.NewSTREvent(application.EventEpoch, str, nil))
+	if changed := str.Policies.Diff(prev.Policies); len(changed) > 0 {
+		server.Notify(application.NewSTREvent(application.EventPolicyChange, str,
+			map[string]string{"changed": strings.Join(changed, ",")}))
+	}
+}
+
 func (server *ConiksServer) updatePolicies() {
 	// read server policies from config file
 	conf := &Config{}
@@ -139,5 +522,29 @@ func (server *ConiksServer) updatePolicies() {
 		return
 	}
 	server.dir.SetPolicies(conf.Policies.EpochDeadline)
+	// the bots' keys may have been rotated
+	server.dir.SetAttestationKeys(attestationKeys(conf.Bots))
+	server.botSuffixes = botSuffixes(conf.Bots)
+	server.hasBots = len(conf.Bots) > 0
+	server.dir.SetNamePolicies(conf.Policies.namePolicies())
 	server.Logger().Info("Policies reloaded!")
 }
+
+// botSuffixes returns the suffixes of the usernames the bots verify.
+func botSuffixes(bots []*Bot) []string {
+	suffixes := make([]string, 0, len(bots))
+	for _, bot := range bots {
+		suffixes = append(suffixes, bot.Suffix)
+	}
+	return suffixes
+}
+
+// attestationKeys returns the public keys of the bots,
+// indexed by the suffix of the usernames each bot verifies.
+func attestationKeys(bots []*Bot) map[string]sign.PublicKey {
+	keys := make(map[string]sign.PublicKey, len(bots))
+	for _, bot := range bots {
+		keys[bot.Suffix] = bot.key
+	}
+	return keys
+}
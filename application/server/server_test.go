@@ -3,17 +3,28 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path"
+	"reflect"
+	"runtime"
+	"strconv"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
 	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
 var registrationMsg = `
@@ -47,9 +58,9 @@ func newTestTCPAddress(dir string) *application.ServerAddress {
 
 // NewTestServer initializes a test CONIKS key server with the given
 // epoch deadline, registration bot usage useBot,
-// policies path, and directory.
+// policies path, and directory. The server's epochs follow a fake clock.
 func newTestServer(t *testing.T, epDeadline protocol.Timestamp, useBot bool,
-	policiesPath, dir string) (*ConiksServer, *Config) {
+	policiesPath, dir string) (*ConiksServer, *Config, *utils.FakeClock) {
 	signKey, err := sign.GenerateKey(nil)
 	if err != nil {
 		t.Fatal(err)
@@ -89,20 +100,46 @@ func newTestServer(t *testing.T, epDeadline protocol.Timestamp, useBot bool,
 		EpochDeadline: epDeadline,
 	}
 
-	return NewConiksServer(conf), conf
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	return newConiksServer(conf, clock), conf, clock
 }
 
 func startServer(t *testing.T, epDeadline protocol.Timestamp, useBot bool, policiesPath string) (*ConiksServer, func()) {
+	server, _, teardown := startServerWithClock(t, epDeadline, useBot, policiesPath)
+	return server, teardown
+}
+
+func startServerWithClock(t *testing.T, epDeadline protocol.Timestamp, useBot bool,
+	policiesPath string) (*ConiksServer, *utils.FakeClock, func()) {
 	dir, teardown := testutil.CreateTLSCertForTest(t)
 
-	server, conf := newTestServer(t, epDeadline, useBot, policiesPath, dir)
+	server, conf, clock := newTestServer(t, epDeadline, useBot, policiesPath, dir)
 	server.Run(conf.Addresses)
-	return server, func() {
+	return server, clock, func() {
 		server.Shutdown()
 		teardown()
 	}
 }
 
+// advanceEpoch advances the server's clock by one epoch deadline,
+// and waits until the server has issued the next STR.
+func advanceEpoch(t *testing.T, server *ConiksServer, clock *utils.FakeClock) {
+	epoch := server.dir.LatestSTR().Epoch
+	clock.Advance(time.Duration(server.dir.EpochDeadline()) * time.Second)
+	timeout := time.After(5 * time.Second)
+	for server.dir.LatestSTR().Epoch == epoch {
+		select {
+		case <-timeout:
+			t.Fatal("Expect the server to issue a new STR")
+		default:
+			runtime.Gosched()
+		}
+	}
+	// wait until the update has completed
+	server.Lock()
+	server.Unlock()
+}
+
 func TestServerStartStop(t *testing.T) {
 	_, teardown := startServer(t, 60, true, "")
 	defer teardown()
@@ -117,8 +154,116 @@ func TestServerReloadPoliciesWithError(t *testing.T) {
 		t.Fatal("Expect the server's policies not change")
 	}
 	// just to make sure the server's still running normally
-	timer := time.NewTimer(1 * time.Second)
-	<-timer.C
+	if _, err := testutil.NewTCPClientDefault([]byte(keylookupMsg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerBootstrap(t *testing.T) {
+	_, conf, clock := newTestServer(t, 60, false, "", os.TempDir())
+	seed := protocol.NewBootstrapSeed(conf.Policies.signKey,
+		[]*protocol.BootstrapBinding{{Username: "alice@twitter", Key: []byte{0, 1, 2}}})
+	conf.Policies.bootstrap = seed
+	conf.Policies.saltKey = make([]byte, crypto.SaltKeySize)
+	server := newConiksServer(conf, clock)
+
+	pk, _ := conf.Policies.signKey.Public()
+	str := server.dir.LatestSTR()
+	if err := seed.VerifyInitSTR(pk, str); err != nil {
+		t.Fatal(err)
+	}
+	if str.Policies.SaltScheme != crypto.SaltPRFID {
+		t.Fatal("Expect the seed's salts to be derived from the salt key")
+	}
+	res := server.HandleRequests(&protocol.Request{
+		Type:    protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{Username: "alice@twitter", Key: []byte{3}},
+	})
+	if res.Error != protocol.ReqNameExisted {
+		t.Fatal("Expect", protocol.ReqNameExisted, "got", res.Error)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	_, conf, clock := newTestServer(t, 60, false, "", dir)
+	conf.InitSTRPath = path.Join(dir, "init.str")
+	conf.DatabasePath = path.Join(dir, "coniks.db")
+
+	report, err := DryRun(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Restored || report.STR.Epoch != 0 {
+		t.Fatal("Expect a new directory at epoch 0")
+	}
+	if _, err := os.Stat(conf.InitSTRPath); !os.IsNotExist(err) {
+		t.Fatal("Expect no initial STR to be saved")
+	}
+	if _, err := os.Stat(conf.DatabasePath); !os.IsNotExist(err) {
+		t.Fatal("Expect no database to be created")
+	}
+
+	server := newConiksServer(conf, clock)
+	server.dir.Update()
+	server.db.Close()
+	conf.Policies.Hash = "BLAKE2b"
+	conf.Policies.HashSize = 16
+	report, err = DryRun(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Restored || report.STR.Epoch != 1 {
+		t.Fatal("Expect the directory to be restored at epoch 1")
+	}
+	// the hash algorithm is declared in the cipher suite as well
+	want := []string{protocol.PolicyHashID, protocol.PolicyCipherSuite}
+	if changed := report.STR.Policies.Diff(report.NextPolicies); !reflect.DeepEqual(changed, want) {
+		t.Fatal("Expect", want, "got", changed)
+	}
+	if want := "BLAKE2b/128"; report.NextPolicies.HashID != want {
+		t.Fatal("Expect", want, "got", report.NextPolicies.HashID)
+	}
+
+	conf.Auditors = []string{"http://auditor.example.org"}
+	if _, err := DryRun(conf); err == nil {
+		t.Fatal("Expect an invalid auditor's address to be rejected")
+	}
+}
+
+func TestServerInMemory(t *testing.T) {
+	// the whole request path runs without sockets nor TLS certificates
+	regAddress := testutil.MemConnection + "-registration"
+	server, conf, _ := newTestServer(t, 60, true, "", os.TempDir())
+	conf.Addresses[0].Address = testutil.MemConnection
+	conf.Addresses[1].Address = regAddress
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	for _, tc := range []struct {
+		name    string
+		address string
+		reqType int
+		msg     string
+		want    protocol.ErrorCode
+	}{
+		{"public registration", testutil.MemConnection, protocol.RegistrationType,
+			registrationMsg, protocol.ErrMalformedMessage},
+		{"registration", regAddress, protocol.RegistrationType,
+			registrationMsg, protocol.ReqSuccess},
+		{"lookup", testutil.MemConnection, protocol.KeyLookupType,
+			keylookupMsg, protocol.ReqSuccess},
+	} {
+		rev, err := testutil.NewMemClient([]byte(tc.msg), tc.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := application.UnmarshalResponse(tc.reqType, rev)
+		if res.Error != tc.want {
+			t.Fatal(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
 }
 
 func TestAcceptOutsideRegistrationRequests(t *testing.T) {
@@ -128,7 +273,7 @@ func TestAcceptOutsideRegistrationRequests(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	var response testutil.ExpectingDirProofResponse
+	var response protocol.Response
 	err = json.Unmarshal(rev, &response)
 	if err != nil {
 		t.Log(string(rev))
@@ -148,7 +293,7 @@ func TestBotSendsRegistration(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var response testutil.ExpectingDirProofResponse
+	var response protocol.Response
 	err = json.Unmarshal(rev, &response)
 	if err != nil {
 		t.Log(string(rev))
@@ -178,7 +323,7 @@ func TestSendsRegistrationFromOutside(t *testing.T) {
 }
 
 func TestUpdateDirectory(t *testing.T) {
-	server, teardown := startServer(t, 1, true, "")
+	server, clock, teardown := startServerWithClock(t, 1, true, "")
 	defer teardown()
 	str0 := server.dir.LatestSTR()
 	rs := createMultiRegistrationRequests(10)
@@ -188,22 +333,73 @@ func TestUpdateDirectory(t *testing.T) {
 			t.Fatal("Error while submitting registration request number", i, "to server")
 		}
 	}
-	timer := time.NewTimer(1 * time.Second)
-	<-timer.C
+	advanceEpoch(t, server, clock)
 	str1 := server.dir.LatestSTR()
 	if str0.Epoch != 0 || str1.Epoch != 1 || !str1.VerifyHashChain(str0) {
 		t.Fatal("Expect next STR in hash chain")
 	}
 }
 
+func TestUpdateNotifiesWebhooks(t *testing.T) {
+	events := make(chan *application.WebhookEvent, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := new(application.WebhookEvent)
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	defer hook.Close()
+
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	_, conf, clock := newTestServer(t, 60, false, "", dir)
+	conf.Webhooks = []*application.Webhook{{URL: hook.URL}}
+	server := newConiksServer(conf, clock)
+	defer server.Shutdown()
+
+	// the new policies are included in the STR after the next one
+	server.dir.SetPolicies(120)
+	for i := 0; i < 2; i++ {
+		if err := server.update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	strHash := fmt.Sprintf("%x", crypto.Digest(server.dir.LatestSTR().Signature))
+	for _, want := range []struct {
+		event string
+		epoch uint64
+	}{
+		{application.EventEpoch, 1},
+		{application.EventEpoch, 2},
+		{application.EventPolicyChange, 2},
+	} {
+		select {
+		case e := <-events:
+			if e.Event != want.event || e.Epoch != want.epoch {
+				t.Fatal("Expect the event", want.event, "of epoch", want.epoch, "got", e)
+			}
+			if e.Epoch == 2 && e.STRHash != strHash {
+				t.Fatal("Expect the STR hash", strHash, "got", e.STRHash)
+			}
+			if e.Event == application.EventPolicyChange &&
+				e.Metadata["changed"] != protocol.PolicyEpochDeadline {
+				t.Fatal("Expect the epoch deadline to change, got", e.Metadata)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expect the event", want.event, "of epoch", want.epoch)
+		}
+	}
+}
+
 func createMultiRegistrationRequests(N uint64) []*protocol.Request {
 	var rs []*protocol.Request
 	for i := uint64(0); i < N; i++ {
 		r := &protocol.Request{
 			Type: protocol.RegistrationType,
 			Request: &protocol.RegistrationRequest{
-				Username:               "user" + string(i),
-				Key:                    []byte("key" + string(i)),
+				Username:               "user" + strconv.FormatUint(i, 10),
+				Key:                    []byte("key" + strconv.FormatUint(i, 10)),
 				AllowPublicLookup:      true,
 				AllowUnsignedKeychange: true,
 			},
@@ -223,8 +419,8 @@ func TestRegisterDuplicateUserInOneEpoch(t *testing.T) {
 		t.Fatal("Error while submitting registration request")
 	}
 	rev = server.HandleRequests(r1)
-	response, ok := rev.DirectoryResponse.(*protocol.DirectoryProof)
-	if !ok {
+	response := rev.DirectoryProof()
+	if response == nil {
 		t.Fatal("Expect a directory proof response")
 	}
 	if rev.Error != protocol.ReqNameExisted {
@@ -239,18 +435,17 @@ func TestRegisterDuplicateUserInOneEpoch(t *testing.T) {
 }
 
 func TestRegisterDuplicateUserInDifferentEpoches(t *testing.T) {
-	server, teardown := startServer(t, 1, true, "")
+	server, clock, teardown := startServerWithClock(t, 1, true, "")
 	defer teardown()
 	r0 := createMultiRegistrationRequests(1)[0]
 	rev := server.HandleRequests(r0)
 	if rev.Error != protocol.ReqSuccess {
 		t.Fatal("Error while submitting registration request")
 	}
-	timer := time.NewTimer(2 * time.Second)
-	<-timer.C
+	advanceEpoch(t, server, clock)
 	rev = server.HandleRequests(r0)
-	response, ok := rev.DirectoryResponse.(*protocol.DirectoryProof)
-	if !ok {
+	response := rev.DirectoryProof()
+	if response == nil {
 		t.Fatal("Expect a directory proof response")
 	}
 	if rev.Error != protocol.ReqNameExisted {
@@ -298,7 +493,7 @@ func TestRegisterAndLookupInTheSameEpoch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var response testutil.ExpectingDirProofResponse
+	var response protocol.Response
 	err = json.Unmarshal(rev, &response)
 	if err != nil {
 		t.Fatal(err)
@@ -306,22 +501,16 @@ func TestRegisterAndLookupInTheSameEpoch(t *testing.T) {
 	if response.Error != protocol.ReqSuccess {
 		t.Fatal("Expect no error", "got", response.Error)
 	}
-	if response.DirectoryResponse.STR == nil {
+	if response.DirectoryProof().STR == nil {
 		t.Fatal("Expect the latets STR")
 	}
-
-	var str testutil.ExpectingSTR
-	err = json.Unmarshal(response.DirectoryResponse.STR[0], &str)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if str.Epoch != 0 {
+	if response.DirectoryProof().STR[0].Epoch != 0 {
 		t.Fatal("Expect STR with epoch", 0)
 	}
-	if response.DirectoryResponse.AP == nil {
+	if response.DirectoryProof().AP == nil {
 		t.Fatal("Expect a proof of absence")
 	}
-	if response.DirectoryResponse.TB == nil {
+	if response.DirectoryProof().TB == nil {
 		t.Fatal("Expect a TB")
 	}
 }
@@ -341,7 +530,7 @@ func TestRegisterAndLookup(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var res testutil.ExpectingDirProofResponse
+	var res protocol.Response
 	err = json.Unmarshal(rev, &res)
 	if err != nil {
 		t.Fatal(err)
@@ -349,22 +538,16 @@ func TestRegisterAndLookup(t *testing.T) {
 	if res.Error != protocol.ReqSuccess {
 		t.Fatal("Expect no error", "got", res.Error)
 	}
-	if res.DirectoryResponse.STR == nil {
+	if res.DirectoryProof().STR == nil {
 		t.Fatal("Expect the latets STR")
 	}
-
-	var str testutil.ExpectingSTR
-	err = json.Unmarshal(res.DirectoryResponse.STR[0], &str)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if str.Epoch == 0 {
+	if res.DirectoryProof().STR[0].Epoch == 0 {
 		t.Fatal("Expect STR with epoch > 0")
 	}
-	if res.DirectoryResponse.AP == nil {
+	if res.DirectoryProof().AP == nil {
 		t.Fatal("Expect a proof of inclusion")
 	}
-	if res.DirectoryResponse.TB != nil {
+	if res.DirectoryProof().TB != nil {
 		t.Fatal("Expect returned TB is nil")
 	}
 }
@@ -384,7 +567,7 @@ func TestKeyLookup(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var response testutil.ExpectingDirProofResponse
+	var response protocol.Response
 	err = json.Unmarshal(rev, &response)
 	if err != nil {
 		t.Fatal(err)
@@ -392,22 +575,16 @@ func TestKeyLookup(t *testing.T) {
 	if response.Error != protocol.ReqSuccess {
 		t.Fatal("Expect no error", "got", response.Error)
 	}
-	if response.DirectoryResponse.STR == nil {
+	if response.DirectoryProof().STR == nil {
 		t.Fatal("Expect the latets STR")
 	}
-
-	var str testutil.ExpectingSTR
-	err = json.Unmarshal(response.DirectoryResponse.STR[0], &str)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if str.Epoch == 0 {
+	if response.DirectoryProof().STR[0].Epoch == 0 {
 		t.Fatal("Expect STR with epoch > 0")
 	}
-	if response.DirectoryResponse.AP == nil {
+	if response.DirectoryProof().AP == nil {
 		t.Fatal("Expect a proof of inclusion")
 	}
-	if response.DirectoryResponse.TB != nil {
+	if response.DirectoryProof().TB != nil {
 		t.Fatal("Expect returned TB is nil")
 	}
 }
@@ -438,7 +615,7 @@ func TestKeyLookupInEpoch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var response testutil.ExpectingDirProofResponse
+	var response protocol.Response
 	err = json.Unmarshal(rev, &response)
 	if err != nil {
 		t.Fatal(err)
@@ -446,7 +623,7 @@ func TestKeyLookupInEpoch(t *testing.T) {
 	if response.Error != protocol.ReqNameNotFound {
 		t.Fatal("Expect error", protocol.ReqNameNotFound, "got", response.Error)
 	}
-	if len(response.DirectoryResponse.STR) != 3 {
+	if len(response.DirectoryProof().STR) != 3 {
 		t.Fatal("Expect", 3, "STRs in reponse")
 	}
 }
@@ -461,7 +638,7 @@ func TestMonitoring(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var regResponse testutil.ExpectingDirProofResponse
+	var regResponse protocol.Response
 	if err := json.Unmarshal(res, &regResponse); err != nil {
 		t.Fatal(err)
 	}
@@ -470,31 +647,389 @@ func TestMonitoring(t *testing.T) {
 		server.dir.Update()
 	}
 
-	var consistencyCheckMsg = `
+	// the STRs/APs which don't fit in a response are continued
+	// in the next one
+	var strs, aps int
+	for start := 1; start <= N; {
+		consistencyCheckMsg := fmt.Sprintf(`
 {
     "type": 3,
     "request": {
         "Username": "alice@twitter",
+        "StartEpoch": %d,
+        "EndEpoch": %d
+    }
+}
+`, start, N)
+		rev, err := testutil.NewTCPClientDefault([]byte(consistencyCheckMsg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var response protocol.Response
+		err = json.Unmarshal(rev, &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Error != protocol.ReqSuccess {
+			t.Fatal("Expect error", protocol.ReqSuccess, "got", response.Error)
+		}
+		df := response.DirectoryProof()
+		strs += len(df.STR)
+		aps += len(df.AP)
+		if df.Continuation == nil {
+			break
+		}
+		start = int(df.Continuation.NextEpoch)
+	}
+	if strs != N || aps != strs {
+		t.Fatal("Expect", N, "STRs/APs in reponse", "got", strs)
+	}
+}
+
+func TestSTRHistory(t *testing.T) {
+	server, teardown := startServer(t, 60, true, "")
+	defer teardown()
+
+	for i := 0; i < 3; i++ {
+		server.dir.Update()
+	}
+
+	var strHistoryMsg = `
+{
+    "type": 5,
+    "request": {
         "StartEpoch": 1,
-        "EndEpoch": 5
+        "EndEpoch": 10
     }
 }
 `
-	rev, err := testutil.NewTCPClientDefault([]byte(consistencyCheckMsg))
+	rev, err := testutil.NewTCPClientDefault([]byte(strHistoryMsg))
 	if err != nil {
 		t.Fatal(err)
 	}
+	response := application.UnmarshalResponse(protocol.STRType, rev)
+	if response.Error != protocol.ReqSuccess {
+		t.Fatal("Expect error", protocol.ReqSuccess, "got", response.Error)
+	}
+	strs := response.STRHistoryRange().STR
+	if len(strs) != 3 || strs[2].Epoch != 3 {
+		t.Fatal("Expect", 3, "STRs in reponse", "got", len(strs))
+	}
+}
 
-	var response testutil.ExpectingDirProofResponse
-	err = json.Unmarshal(rev, &response)
+func TestLoadSheddingDuringUpdate(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, _, _ := newTestServer(t, 60, true, "", dir)
+
+	req := createMultiRegistrationRequests(1)[0]
+	if res := server.HandleRequests(req); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	name := req.Request.(*protocol.RegistrationRequest).Username
+
+	// the handler serves requests while the directory is updating
+	handler := server.snapshotHandler()
+	server.dir.Update()
+
+	res := handler(&protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: name},
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	df := res.DirectoryProof()
+	if df.STR[0].Epoch != 0 || df.TB == nil {
+		t.Fatal("Expect the lookup to be served from the previous snapshot")
+	}
+
+	if res := handler(req); res.Error != protocol.ReqRetryLater {
+		t.Fatal("Expect", protocol.ReqRetryLater, "got", res.Error)
+	}
+}
+
+func TestLookupsDuringUpdate(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, clock := newTestServer(t, 60, true, "", dir)
+	server.SetLoadShedding(server.snapshotHandler)
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	register := func(name string) {
+		msg, _ := clientapp.CreateRegistrationMsg(name, []byte(name))
+		if _, err := testutil.NewUnixClientDefault(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := []string{"alice", "bob", "carol"}
+	for _, name := range names {
+		register(name)
+	}
+	advanceEpoch(t, server, clock)
+
+	lookup, _ := clientapp.CreateKeyLookupMsg(names[0])
+	batch, _ := clientapp.CreateBatchKeyLookupMsg(names)
+	// verify checks that each authentication path of the response
+	// verifies against the STR the response includes
+	verify := func(t int, msg []byte) error {
+		buf, err := testutil.NewTCPClientDefault(msg)
+		if err != nil {
+			return err
+		}
+		res := application.UnmarshalResponse(t, buf)
+		switch err := res.Validate(); err {
+		case nil, protocol.ReqNameNotFound:
+		case protocol.ReqRetryLater:
+			return nil
+		default:
+			return err
+		}
+		switch df := res.DirectoryResponse.(type) {
+		case *protocol.DirectoryProof:
+			return client.VerifyAuthPath(names[0], nil, df.AP[0], df.STR[0])
+		case *protocol.BatchKeyLookupProof:
+			for i, ap := range df.AP {
+				if err := client.VerifyAuthPath(names[i], nil, ap, df.STR); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 2)
+	for typ, msg := range map[int][]byte{
+		protocol.KeyLookupType:      lookup,
+		protocol.BatchKeyLookupType: batch,
+	} {
+		go func(typ int, msg []byte) {
+			for {
+				select {
+				case <-stop:
+					errs <- nil
+					return
+				default:
+				}
+				if err := verify(typ, msg); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(typ, msg)
+	}
+	for i := 0; i < 10; i++ {
+		register(fmt.Sprintf("user%d", i))
+		advanceEpoch(t, server, clock)
+	}
+	close(stop)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListenOnExtraAddresses(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, _ := newTestServer(t, 60, true, "", dir)
+	extra := "unix://" + path.Join(t.TempDir(), "extra.sock")
+	conf.Addresses[1].ExtraAddresses = []string{extra}
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+	// the default labels aren't written to the configuration
+	for _, addr := range conf.Addresses {
+		if addr.Label != "" {
+			t.Fatal("Expect the address's label to be unchanged, got", addr.Label)
+		}
+	}
+
+	if _, err := testutil.NewUnixClient([]byte(registrationMsg), extra); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testutil.NewTCPClientDefault([]byte(registrationMsg)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := server.ListenerStats()
+	if len(stats) != 3 {
+		t.Fatal("Expect", 3, "listeners, got", len(stats))
+	}
+	for i, want := range []struct {
+		label, address   string
+		requests, errors uint64
+	}{
+		{"public", testutil.PublicConnection, 1, 1},
+		{"registration", testutil.LocalConnection, 0, 0},
+		{"registration", extra, 1, 0},
+	} {
+		got := stats[i]
+		if got.Label != want.label || got.Address != want.address ||
+			got.Requests != want.requests || got.Errors != want.errors {
+			t.Fatal("Expect", want, "got", *got)
+		}
+	}
+}
+
+func TestProtobufAddress(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, _ := newTestServer(t, 60, true, "", dir)
+	conf.Addresses[0].Encoding = application.ProtobufEncoding
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	if _, err := testutil.NewUnixClientDefault([]byte(registrationMsg)); err != nil {
+		t.Fatal(err)
+	}
+	server.dir.Update()
+
+	// the JSON requests aren't understood at the address
+	rev, err := testutil.NewTCPClientDefault([]byte(keylookupMsg))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if response.Error != protocol.ReqSuccess {
-		t.Fatal("Expect error", protocol.ReqSuccess, "got", response.Error)
+	res := application.UnmarshalResponsePB(protocol.KeyLookupType, rev)
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+
+	req, err := application.MarshalRequestPB(protocol.KeyLookupType,
+		&protocol.KeyLookupRequest{Username: "alice@twitter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err = testutil.NewTCPClientDefault(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = application.UnmarshalResponsePB(protocol.KeyLookupType, rev)
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	df := res.DirectoryProof()
+	if key := df.AP[0].Leaf.Value; !bytes.Equal(key, []byte{0, 1, 2}) {
+		t.Fatal("Expect the registered key, got", key)
+	}
+}
+
+func TestRegisterWithAttestation(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, _, clock := newTestServer(t, 60, true, "", dir)
+	botKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := botKey.Public()
+	server.dir.SetAttestationKeys(map[string]sign.PublicKey{"@twitter": pk})
+
+	clock.Advance(time.Hour)
+	name := "alice@twitter"
+	expiry := clock.Now().Add(time.Minute)
+	register := func(handler func(*protocol.Request) *protocol.Response,
+		attestation *protocol.RegistrationAttestation) protocol.ErrorCode {
+		return handler(&protocol.Request{
+			Type: protocol.RegistrationType,
+			Request: &protocol.RegistrationRequest{
+				Username:    name,
+				Key:         []byte{1, 2, 3},
+				Attestation: attestation,
+			},
+		}).Error
+	}
+	if err := register(server.handleAttestedRequests, nil); err != protocol.ReqMissingAttestation {
+		t.Fatal("Expect", protocol.ReqMissingAttestation, "got", err)
+	}
+	// an invalid attestation is also rejected on the addresses
+	// which don't require attestations
+	invalid := protocol.NewRegistrationAttestation(botKey, name, clock.Now().Add(-time.Second))
+	if err := register(server.HandleRequests, invalid); err != protocol.ReqBadAttestation {
+		t.Fatal("Expect", protocol.ReqBadAttestation, "got", err)
+	}
+	valid := protocol.NewRegistrationAttestation(botKey, name, expiry)
+	if err := register(server.handleAttestedRequests, valid); err != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", err)
+	}
+
+	// other requests don't need an attestation
+	res := server.handleAttestedRequests(&protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: name},
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+}
+
+func TestAddressPermissions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		addr    *Address
+		reqType int
+		want    bool
+	}{
+		{"lookup", &Address{}, protocol.KeyLookupType, true},
+		{"auditor", &Address{}, protocol.STRType, true},
+		{"denied auditor", &Address{DenyAuditors: true}, protocol.STRType, false},
+		{"lookup with denied auditors", &Address{DenyAuditors: true}, protocol.KeyLookupType, true},
+		{"client auditing", &Address{}, protocol.AuditType, false},
+		{"observation report", &Address{}, protocol.ObservationReportType, false},
+		{"push", &Address{}, protocol.STRPushType, false},
+		{"read-only registration", &Address{}, protocol.RegistrationType, false},
+		{"registration", &Address{AllowRegistration: true}, protocol.RegistrationType, true},
+		{"attested registration", &Address{RequireAttestation: true}, protocol.RegistrationType, true},
+		{"key change abort", &Address{}, protocol.KeyChangeAbortType, true},
+	} {
+		if got := tc.addr.permissions()[tc.reqType]; got != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", got)
+		}
+	}
+}
+
+func TestDenyAuditors(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	_, conf, clock := newTestServer(t, 60, true, "", dir)
+	conf.Addresses[1].DenyAuditors = true
+	server := newConiksServer(conf, clock)
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	msg, err := clientapp.CreateSTRHistoryMsg(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		send func([]byte) ([]byte, error)
+		want protocol.ErrorCode
+	}{
+		{"public", testutil.NewTCPClientDefault, protocol.ReqSuccess},
+		{"denied", testutil.NewUnixClientDefault, protocol.ErrMalformedMessage},
+	} {
+		rev, err := tc.send(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := application.UnmarshalResponse(protocol.STRType, rev); res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+
+	msg, err = clientapp.CreateAuditingMsg([crypto.HashSizeByte]byte{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := testutil.NewTCPClientDefault(msg)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if len(response.DirectoryResponse.STR) != N ||
-		len(response.DirectoryResponse.AP) != len(response.DirectoryResponse.STR) {
-		t.Fatal("Expect", N, "STRs/APs in reponse", "got", len(response.DirectoryResponse.STR))
+	if res := application.UnmarshalResponse(protocol.AuditType, rev); res.Error != protocol.ErrMalformedMessage {
+		t.Error("Expect", protocol.ErrMalformedMessage, "got", res.Error)
 	}
 }
@@ -0,0 +1,130 @@
+// Implements the export of the aggregate shape of the server's tree,
+// e.g., for research on the growth of a directory, through the
+// server's admin socket (see Config.AdminAddress), and through an
+// optional read-only HTTP endpoint (see Config.StatsAddress).
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// TreeStatsCommand is the admin command with which an operator exports
+// the aggregate shape of the tree of the directory's snapshot at an
+// epoch, which the command takes. The reply is the JSON-encoded
+// merkletree.TreeStats, which includes neither an index nor a
+// commitment, so the operator can share it without revealing any
+// binding.
+const TreeStatsCommand = "tree-stats"
+
+// handleTreeStatsCommand runs the TreeStatsCommand cmd, and returns
+// its reply, or the error which occurred while running the command.
+func (server *ConiksServer) handleTreeStatsCommand(cmd string) string {
+	arg := strings.TrimSpace(strings.TrimPrefix(cmd, TreeStatsCommand))
+	epoch, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return fmt.Sprintf("Malformed epoch %q", arg)
+	}
+	server.RLock()
+	stats, err := server.dir.TreeStats(epoch)
+	server.RUnlock()
+	if err != nil {
+		return err.Error()
+	}
+	buf, err := json.Marshal(stats)
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+// TreeStatsPath is the path of the HTTP endpoint at which the server
+// serves the JSON-encoded merkletree.TreeStats of the directory's
+// snapshot at the epoch given by the query parameter epoch, e.g.,
+// "/v1/tree-stats?epoch=3", or at the latest epoch if the parameter
+// is omitted. The endpoint serves the GET and HEAD methods.
+const TreeStatsPath = "/v1/tree-stats"
+
+// serveTreeStats serves the TreeStatsPath endpoint over HTTP at the
+// TCP address addr in the background, until the server is shut down.
+func (server *ConiksServer) serveTreeStats(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server.stats = &http.Server{
+		Handler:      server.treeStatsHandler(),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	server.statsServed = make(chan struct{})
+	go func() {
+		defer close(server.statsServed)
+		if err := server.stats.Serve(ln); err != http.ErrServerClosed {
+			server.Logger().Error(err.Error(), "listener", "tree stats")
+		}
+	}()
+	return nil
+}
+
+// treeStatsHandler returns the HTTP handler of the TreeStatsPath
+// endpoint, which serves no other path.
+func (server *ConiksServer) treeStatsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(TreeStatsPath, server.serveTreeStatsRequest)
+	return mux
+}
+
+// stopTreeStats closes the TreeStatsPath endpoint, if any, and waits
+// for the requests being served.
+func (server *ConiksServer) stopTreeStats() {
+	if server.stats == nil {
+		return
+	}
+	server.stats.Shutdown(context.Background())
+	<-server.statsServed
+}
+
+// serveTreeStatsRequest writes the aggregate shape of the tree of the
+// directory's snapshot at the epoch requested by r (see TreeStatsPath).
+// It replies with the status code 400 if the epoch is malformed, and
+// 404 if the directory has no snapshot at this epoch.
+func (server *ConiksServer) serveTreeStatsRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	server.RLock()
+	defer server.RUnlock()
+	epoch := server.dir.LatestSTR().Epoch
+	if arg := r.URL.Query().Get("epoch"); arg != "" {
+		var err error
+		if epoch, err = strconv.ParseUint(arg, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Malformed epoch %q", arg),
+				http.StatusBadRequest)
+			return
+		}
+	}
+	stats, err := server.dir.TreeStats(epoch)
+	switch {
+	case err == merkletree.ErrSTRNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
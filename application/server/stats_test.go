@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestTreeStatsAdminCommand(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, conf, _ := newTestServer(t, 60, false, "", dir)
+	server.adminAddr = path.Join(dir, "admin.sock")
+	server.dir.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	server.update()
+	server.Run(conf.Addresses)
+	defer server.Shutdown()
+
+	reply, err := application.SendAdminCommand(server.adminAddr, TreeStatsCommand+" 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats merkletree.TreeStats
+	if err := json.Unmarshal([]byte(reply), &stats); err != nil {
+		t.Fatal(err, reply)
+	}
+	if stats.Epoch != 1 || stats.Leaves != 1 || stats.Depths[1] != 1 {
+		t.Fatal("Expect a single leaf at depth", 1, "got", reply)
+	}
+
+	for _, tc := range []struct {
+		cmd  string
+		want string
+	}{
+		{TreeStatsCommand + " 2", merkletree.ErrSTRNotFound.Error()},
+		{TreeStatsCommand + " one", `Malformed epoch "one"`},
+		{TreeStatsCommand, `Malformed epoch ""`},
+	} {
+		reply, err := application.SendAdminCommand(server.adminAddr, tc.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != tc.want {
+			t.Error(tc.cmd, "expect", tc.want, "got", reply)
+		}
+	}
+}
+
+func TestTreeStatsHTTPEndpoint(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+	server, _, _ := newTestServer(t, 60, false, "", dir)
+	// the endpoint is disabled by default
+	if server.statsAddr != "" {
+		t.Fatal("Expect no tree stats endpoint, got", server.statsAddr)
+	}
+	server.dir.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	server.update()
+	ts := httptest.NewServer(server.treeStatsHandler())
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		method, query string
+		status        int
+		epoch         uint64
+	}{
+		{http.MethodGet, "", http.StatusOK, 1},
+		{http.MethodGet, "?epoch=0", http.StatusOK, 0},
+		{http.MethodGet, "?epoch=2", http.StatusNotFound, 0},
+		{http.MethodGet, "?epoch=one", http.StatusBadRequest, 0},
+		{http.MethodPost, "", http.StatusMethodNotAllowed, 0},
+	} {
+		req, err := http.NewRequest(tc.method, ts.URL+TreeStatsPath+tc.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Error(tc.method, tc.query, "expect", tc.status, "got", res.StatusCode)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		// the reply contains only the aggregate stats
+		var stats merkletree.TreeStats
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&stats); err != nil {
+			t.Fatal(err, string(body))
+		}
+		if stats.Epoch != tc.epoch {
+			t.Error("Expect the stats of epoch", tc.epoch, "got", stats.Epoch)
+		}
+		if tc.epoch == 1 && (stats.Leaves != 1 || stats.Depths[1] != 1) {
+			t.Error("Expect a single leaf at depth", 1, "got", string(body))
+		}
+	}
+
+	res, err := http.Get(ts.URL + "/v1/lookup/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("Expect", http.StatusNotFound, "for another path, got", res.StatusCode)
+	}
+}
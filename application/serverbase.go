@@ -3,49 +3,122 @@ package application
 import (
 	"bytes"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net"
-	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+	"github.com/coniks-sys/coniks-go/utils/faults"
 )
 
-// EpochTimer consists of a `time.Timer` and the epoch deadline value.
+// EpochTimer consists of a `utils.Timer` and the epoch deadline value.
 type EpochTimer struct {
-	*time.Timer
+	utils.Timer
 	duration time.Duration
 }
 
 // NewEpochTimer initializes an epoch timer for running regular
 // update procedures every epoch.
 func NewEpochTimer(epDeadline protocol.Timestamp) *EpochTimer {
+	return NewEpochTimerWithClock(utils.RealClock, epDeadline)
+}
+
+// NewEpochTimerWithClock initializes an epoch timer which follows
+// the given clock, e.g. a utils.FakeClock in tests.
+func NewEpochTimerWithClock(clock utils.Clock, epDeadline protocol.Timestamp) *EpochTimer {
+	duration := time.Duration(epDeadline) * time.Second
 	return &EpochTimer{
-		Timer:    time.NewTimer(time.Duration(epDeadline) * time.Second),
-		duration: time.Duration(epDeadline) * time.Second,
+		Timer:    clock.NewTimer(duration),
+		duration: duration,
+	}
+}
+
+// retryDelay returns the delay after which a failed update is retried,
+// given the number of consecutive failures: a sixteenth of the epoch
+// deadline after the first failure, doubling after each failure, up
+// to the epoch deadline.
+func (t *EpochTimer) retryDelay(failures int) time.Duration {
+	d := t.duration / 16
+	for i := 1; i < failures && d < t.duration; i++ {
+		d *= 2
+	}
+	if d > t.duration {
+		d = t.duration
 	}
+	return d
 }
 
 // A ServerAddress describes a server's connection.
-// It supports two types of connections: a TCP connection ("tcp")
-// and a Unix socket connection ("unix").
+// It supports two types of connections: a TCP connection ("tcp",
+// or "tcp4" and "tcp6" to listen on IPv4 or IPv6 only)
+// and a Unix socket connection ("unix"). Tests may also use
+// in-memory connections ("mem", see utils.ListenMem()).
 //
 // Additionally, TCP connections must use TLS for added security,
 // and each is required to specify a TLS certificate and corresponding
 // private key.
+//
+// A connection may listen on several addresses, e.g., on both IPv4 and
+// IPv6 or on several network interfaces, which share the connection's
+// TLS certificate and permissions.
 type ServerAddress struct {
-	// Address is formatted as a url: scheme://address.
+	// Address is formatted as a url: scheme://address
+	// (see utils.ParseAddress()), e.g., "tcp://[::1]:3000".
 	Address string `toml:"address"`
+	// ExtraAddresses are the additional addresses the connection
+	// listens on, formatted as Address.
+	ExtraAddresses []string `toml:"extra_addresses,omitempty"`
+	// Label names the connection's role in the logs and the
+	// listener statistics, e.g., "registration".
+	Label string `toml:"label,omitempty"`
 	// TLSCertPath is a path to the server's TLS Certificate,
 	// which has to be set if the connection is TCP.
 	TLSCertPath string `toml:"cert,omitempty"`
 	// TLSKeyPath is a path to the server's TLS private key,
 	// which has to be set if the connection is TCP.
 	TLSKeyPath string `toml:"key,omitempty"`
+	// Resolution optionally restricts the resolution of the host
+	// names of the TCP addresses to IPv4 or IPv6.
+	Resolution *utils.ResolutionPolicy `toml:"resolution,omitempty"`
+	// Encoding is the encoding of the messages exchanged at the
+	// connection's addresses, either JSONEncoding (the default) or
+	// ProtobufEncoding. The clients of the connection have to use
+	// the same encoding.
+	Encoding string `toml:"encoding,omitempty"`
+	// HTTP makes the connection serve a REST API of the requests
+	// instead (over HTTPS on the TCP addresses), see HTTPRegisterPath
+	// and the other HTTP endpoints. Its responses are JSON-encoded,
+	// unless the clients prefer the protobuf encoding (see
+	// HTTPProtobufType).
+	HTTP bool `toml:"http,omitempty"`
+}
+
+// ListenerStats contains the statistics of a listener, i.e., of one
+// of the addresses of a ServerAddress.
+// Requests is the number of requests the listener has received, and
+// Errors is the number of those requests answered with an error.
+type ListenerStats struct {
+	Label    string
+	Address  string
+	Requests uint64
+	Errors   uint64
+}
+
+// listener counts the requests received at one of the addresses
+// of a ServerAddress.
+type listener struct {
+	requests uint64 // accessed atomically
+	errors   uint64 // accessed atomically
+	label    string
+	address  string
 }
 
 // A ServerBase represents the base features needed to implement
@@ -67,6 +140,18 @@ type ServerBase struct {
 	configFilePath string
 	configEncoding string
 	reloadChan     chan os.Signal
+
+	// newSnapshotHandler is set if the server sheds load while
+	// updating, and snapshotHandler holds the handler returned by
+	// newSnapshotHandler during each update.
+	newSnapshotHandler func() func(req *protocol.Request) *protocol.Response
+	snapshotHandler    atomic.Value
+
+	listenersLock sync.Mutex
+	listeners     []*listener
+
+	limiters *requestLimiters // nil if the requests aren't rate limited
+	webhooks *webhooks        // nil if the server has no webhooks
 }
 
 // NewServerBase creates a new generic CONIKS-ready server base.
@@ -77,71 +162,210 @@ func NewServerBase(conf *CommonConfig, listenVerb string,
 	sb.Verb = listenVerb
 	sb.acceptableReqs = perms
 	sb.logger = NewLogger(conf.Logger)
+	sb.limiters = newRequestLimiters(conf.RateLimits, utils.RealClock)
 	sb.stop = make(chan struct{})
+	if sb.webhooks = newWebhooks(conf.Webhooks, sb.logger); sb.webhooks != nil {
+		sb.RunInBackground(func() {
+			sb.webhooks.run(sb.stop)
+		})
+	}
 	sb.configFilePath = conf.Path
 	sb.configEncoding = conf.Encoding
 	sb.reloadChan = make(chan os.Signal, 1)
 	signal.Notify(sb.reloadChan, syscall.SIGUSR2)
+	sb.snapshotHandler.Store(noSnapshotHandler)
 	return sb
 }
 
+// noSnapshotHandler is stored in the ServerBase's snapshotHandler
+// while the server isn't updating.
+var noSnapshotHandler func(req *protocol.Request) *protocol.Response
+
+// SetLoadShedding enables the load-shedding mode of the server:
+// at the beginning of each update run by EpochUpdate(), before the
+// update waits for the lock, newHandler is called with the server
+// read-locked, and the returned handler serves all requests received
+// until the update is done without waiting for the lock. The returned handler must therefore be safe
+// to use concurrently with the update, e.g., it serves key lookups
+// from a snapshot of the state taken before the update, and asks
+// the clients to retry all other requests later.
+// This keeps the latency of requests bounded while the update holds
+// the lock.
+func (sb *ServerBase) SetLoadShedding(
+	newHandler func() func(req *protocol.Request) *protocol.Response) {
+	sb.newSnapshotHandler = newHandler
+}
+
 // ListenAndHandle implements the main functionality of a CONIKS-ready
 // server. It listens athe the given server address with corresponding
 // permissions, and takes the specified pre- and post-Listening actions.
 // It also supports hot-reloading the configuration by listening for
 // SIGUSR2 signal.
+// ListenAndHandle() listens on each of the server address's addresses
+// (see ServerAddress.ExtraAddresses), and keeps statistics for each of
+// these listeners (see ListenerStats()).
 func (sb *ServerBase) ListenAndHandle(addr *ServerAddress,
 	reqHandler func(req *protocol.Request) *protocol.Response) {
-	ln, tlsConfig := addr.resolveAndListen()
-	sb.waitStop.Add(1)
-	go func() {
-		sb.logger.Info(sb.Verb, "address", addr.Address)
-		sb.acceptRequests(addr, ln, tlsConfig, reqHandler)
-		sb.waitStop.Done()
-	}()
+	sb.ListenAndHandleAs(addr, addr.Label, reqHandler)
+}
+
+// ListenAndHandleAs is ListenAndHandle(), but labels the statistics
+// of addr's listeners with label instead of addr.Label, leaving addr
+// unchanged. An empty label defaults to addr.Address.
+func (sb *ServerBase) ListenAndHandleAs(addr *ServerAddress, label string,
+	reqHandler func(req *protocol.Request) *protocol.Response) {
+	sb.ListenAndHandleFrom(addr, label,
+		func(_ string, req *protocol.Request) *protocol.Response {
+			return reqHandler(req)
+		})
+}
+
+// ListenAndHandleFrom is ListenAndHandleAs(), but also passes
+// reqHandler the host of the peer which sent each request, i.e., the
+// client's IP address, e.g., to limit the requests of each peer.
+// The host is empty for the clients of Unix sockets.
+func (sb *ServerBase) ListenAndHandleFrom(addr *ServerAddress, label string,
+	reqHandler func(peer string, req *protocol.Request) *protocol.Response) {
+	if label == "" {
+		label = addr.Address
+	}
+	for _, address := range append([]string{addr.Address}, addr.ExtraAddresses...) {
+		ln, tlsConfig := addr.listen(address)
+		l := &listener{label: label, address: boundAddress(address, ln)}
+		sb.listenersLock.Lock()
+		sb.listeners = append(sb.listeners, l)
+		sb.listenersLock.Unlock()
+		sb.waitStop.Add(1)
+		go func() {
+			sb.logger.Info(sb.Verb, "address", l.address, "listener", l.label)
+			if addr.HTTP {
+				sb.serveHTTP(addr, l, ln, tlsConfig, reqHandler)
+			} else {
+				sb.acceptRequests(addr, l, ln, tlsConfig, reqHandler)
+			}
+			sb.waitStop.Done()
+		}()
+	}
+}
+
+// boundAddress returns the address of ln, which listens on address:
+// address itself, or, if address listens on the TCP port 0, e.g., in
+// tests, address with the port the system chose.
+func boundAddress(address string, ln net.Listener) string {
+	tcpaddr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok || !strings.HasSuffix(address, ":0") {
+		return address
+	}
+	return address[:strings.Index(address, "://")+len("://")] + tcpaddr.String()
+}
+
+// ListenerStats returns the statistics of all listeners of the
+// server, in the order in which they were started. The address of
+// a listener on the TCP port 0 includes the port the system chose.
+func (sb *ServerBase) ListenerStats() []*ListenerStats {
+	sb.listenersLock.Lock()
+	defer sb.listenersLock.Unlock()
+	stats := make([]*ListenerStats, 0, len(sb.listeners))
+	for _, l := range sb.listeners {
+		stats = append(stats, &ListenerStats{
+			Label:    l.label,
+			Address:  l.address,
+			Requests: atomic.LoadUint64(&l.requests),
+			Errors:   atomic.LoadUint64(&l.errors),
+		})
+	}
+	return stats
+}
+
+// Validate checks, without listening, that each of addr's addresses
+// is well-formed and consistent with addr's resolution policy, and
+// that addr's TLS certificate and private key can be loaded if addr
+// listens on TCP addresses, and that addr's message encoding is known,
+// and is JSONEncoding if addr serves HTTP requests.
+// Listening on a valid addr can then only fail if one of its addresses
+// is unavailable.
+func (addr *ServerAddress) Validate() error {
+	if err := ValidateEncoding(addr.Encoding); err != nil {
+		return err
+	}
+	if addr.HTTP && addr.Encoding == ProtobufEncoding {
+		return fmt.Errorf("The HTTP connection %s only supports the JSON encoding", addr.Address)
+	}
+	for _, address := range append([]string{addr.Address}, addr.ExtraAddresses...) {
+		network, _, err := utils.ParseAddress(address)
+		if err != nil {
+			return err
+		}
+		network, err = addr.Resolution.Network(network)
+		if err != nil {
+			return err
+		}
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			if _, err := tls.LoadX509KeyPair(addr.TLSCertPath, addr.TLSKeyPath); err != nil {
+				return fmt.Errorf("Cannot load TLS certificate of %s: %v", address, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (addr *ServerAddress) resolveAndListen() (ln net.Listener,
 	tlsConfig *tls.Config) {
-	u, err := url.Parse(addr.Address)
+	return addr.listen(addr.Address)
+}
+
+// listen listens on address, which is either addr.Address or one of
+// addr.ExtraAddresses, using addr's TLS certificate.
+func (addr *ServerAddress) listen(address string) (ln net.Listener,
+	tlsConfig *tls.Config) {
+	network, host, err := utils.ParseAddress(address)
+	if err != nil {
+		panic(err)
+	}
+	network, err = addr.Resolution.Network(network)
 	if err != nil {
 		panic(err)
 	}
-	switch u.Scheme {
-	case "tcp":
+	switch network {
+	case "tcp", "tcp4", "tcp6":
 		// force to use TLS
 		cer, err := tls.LoadX509KeyPair(addr.TLSCertPath, addr.TLSKeyPath)
 		if err != nil {
 			panic(err)
 		}
 		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cer}}
-		tcpaddr, err := net.ResolveTCPAddr(u.Scheme, u.Host)
+		tcpaddr, err := net.ResolveTCPAddr(network, host)
+		if err != nil {
+			panic(err)
+		}
+		ln, err = net.ListenTCP(network, tcpaddr)
 		if err != nil {
 			panic(err)
 		}
-		ln, err = net.ListenTCP(u.Scheme, tcpaddr)
+		return
+	case "mem":
+		ln, err = utils.ListenMem(host)
 		if err != nil {
 			panic(err)
 		}
 		return
-	case "unix":
-		unixaddr, err := net.ResolveUnixAddr(u.Scheme, u.Path)
+	default:
+		unixaddr, err := net.ResolveUnixAddr(network, host)
 		if err != nil {
 			panic(err)
 		}
-		ln, err = net.ListenUnix(u.Scheme, unixaddr)
+		ln, err = net.ListenUnix(network, unixaddr)
 		if err != nil {
 			panic(err)
 		}
 		return
-	default:
-		panic("Unknown network type")
 	}
 }
 
-func (sb *ServerBase) acceptRequests(addr *ServerAddress, ln net.Listener,
-	tlsConfig *tls.Config,
-	handler func(req *protocol.Request) *protocol.Response) {
+func (sb *ServerBase) acceptRequests(addr *ServerAddress, l *listener,
+	ln net.Listener, tlsConfig *tls.Config,
+	handler func(peer string, req *protocol.Request) *protocol.Response) {
 	defer ln.Close()
 	go func() {
 		<-sb.stop
@@ -164,7 +388,7 @@ func (sb *ServerBase) acceptRequests(addr *ServerAddress, ln net.Listener,
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				continue
 			}
-			sb.logger.Error(err.Error())
+			sb.logger.Error(err.Error(), "listener", l.label)
 			continue
 		}
 		if _, ok := ln.(*net.TCPListener); ok {
@@ -172,7 +396,7 @@ func (sb *ServerBase) acceptRequests(addr *ServerAddress, ln net.Listener,
 		}
 		sb.waitCloseConn.Add(1)
 		go func() {
-			sb.acceptClient(addr, conn, handler)
+			sb.acceptClient(addr, l, conn, handler)
 			sb.waitCloseConn.Done()
 		}()
 	}
@@ -192,8 +416,8 @@ func (sb *ServerBase) checkRequestType(addr *ServerAddress,
 	return nil
 }
 
-func (sb *ServerBase) acceptClient(addr *ServerAddress, conn net.Conn,
-	handler func(req *protocol.Request) *protocol.Response) {
+func (sb *ServerBase) acceptClient(addr *ServerAddress, l *listener,
+	conn net.Conn, handler func(peer string, req *protocol.Request) *protocol.Response) {
 	defer conn.Close()
 	conn.SetDeadline(time.Now().Add(5 * time.Second))
 
@@ -201,54 +425,96 @@ func (sb *ServerBase) acceptClient(addr *ServerAddress, conn net.Conn,
 	var response *protocol.Response
 	if _, err := io.CopyN(&buf, conn, 8192); err != nil && err != io.EOF {
 		sb.logger.Error(err.Error(),
-			"address", conn.RemoteAddr().String())
+			"address", conn.RemoteAddr().String(), "listener", l.label)
 		return
 	}
+	atomic.AddUint64(&l.requests, 1)
 
 	// unmarshalling
-	req, err := UnmarshalRequest(buf.Bytes())
+	req, err := UnmarshalRequestWith(addr.Encoding, buf.Bytes())
 	if err != nil {
+		sb.logger.Warn(err.Error(),
+			"address", conn.RemoteAddr().String(), "listener", l.label)
 		response = malformedClientMsg(err)
 	} else {
-		if err := sb.checkRequestType(addr, req.Type); err != nil {
-			response = malformedClientMsg(err)
-		} else {
-			switch req.Type {
-			case protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
-				sb.RLock()
-			default:
-				sb.Lock()
-			}
-
-			response = handler(req)
-
-			switch req.Type {
-			case protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
-				sb.RUnlock()
-			default:
-				sb.Unlock()
-			}
-
-			if response.Error != protocol.ReqSuccess {
-				sb.logger.Warn(response.Error.Error(),
-					"address", conn.RemoteAddr().String())
-			}
-		}
+		response = sb.handle(addr, l, conn.RemoteAddr().String(), req, handler)
+	}
+	if response.Error != protocol.ReqSuccess {
+		atomic.AddUint64(&l.errors, 1)
 	}
 
 	// marshalling
-	res, e := MarshalResponse(response)
+	res, e := MarshalResponseWith(addr.Encoding, response)
 	if e != nil {
 		panic(e)
 	}
+	if faults.Check(faults.TruncateResponse) != nil {
+		res = res[:len(res)/2]
+	}
 	_, err = conn.Write([]byte(res))
 	if err != nil {
 		sb.logger.Error(err.Error(),
-			"address", conn.RemoteAddr().String())
+			"address", conn.RemoteAddr().String(), "listener", l.label)
 		return
 	}
 }
 
+// handle passes the request req, received at addr from the client at
+// remote, along with the client's host, to handler with the server
+// locked according to the request type, or to the load-shedding
+// handler while the server is updating (see SetLoadShedding()). It returns a malformed message response if
+// req isn't acceptable at addr, and a
+// message.NewErrorResponse(ReqRateLimited) if req exceeds one of the
+// server's rate limits (see RateLimits), which it reports to the
+// server's webhooks (see EventRateLimited).
+func (sb *ServerBase) handle(addr *ServerAddress, l *listener, remote string,
+	req *protocol.Request,
+	handler func(peer string, req *protocol.Request) *protocol.Response) *protocol.Response {
+	if err := sb.checkRequestType(addr, req.Type); err != nil {
+		return malformedClientMsg(err)
+	}
+	if !sb.limiters.allow(remote, req) {
+		sb.logger.Warn(protocol.ReqRateLimited.Error(),
+			"address", remote, "listener", l.label)
+		sb.webhooks.notifyRateLimited(time.Now())
+		return protocol.NewErrorResponse(protocol.ReqRateLimited)
+	}
+	if shed := sb.snapshotHandler.Load().(func(*protocol.Request) *protocol.Response); shed != nil {
+		return shed(req)
+	}
+
+	// read-only requests are handled concurrently, and never
+	// concurrently with an update (see EpochUpdate())
+	readOnly := protocol.ReadOnly(req.Type)
+	if readOnly {
+		sb.RLock()
+	} else {
+		sb.Lock()
+	}
+	response := handler(peerHost(remote), req)
+	if readOnly {
+		sb.RUnlock()
+	} else {
+		sb.Unlock()
+	}
+
+	if response.Error != protocol.ReqSuccess {
+		sb.logger.Warn(response.Error.Error(),
+			"address", remote, "listener", l.label)
+	}
+	return response
+}
+
+// peerHost returns the host of the client address remote, which is
+// empty for the clients of Unix sockets.
+func peerHost(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
 // RunInBackground creates a new goroutine that calls function `f`.
 // It automatically increments the counter `sync.WaitGroup` of the
 // `ServerBase` and calls `Done` when the function execution is finished.
@@ -262,15 +528,56 @@ func (sb *ServerBase) RunInBackground(f func()) {
 
 // EpochUpdate runs function `f`, which is supposed to be a CONIK's update
 // procedure every epoch, following the given timer.
-func (sb *ServerBase) EpochUpdate(timer *EpochTimer, f func()) {
+// If `f` fails, EpochUpdate logs its error, notifies the server's
+// webhooks of it (see EventUpdateFailed), and retries it with an
+// exponential backoff (see EpochTimer.retryDelay()), instead of
+// waiting for the next epoch; `f` must then leave the server's state
+// unchanged, so that the server keeps serving its previous state.
+// If the load-shedding mode is enabled (see SetLoadShedding()),
+// requests received while `f` is running are served by the handler
+// created for this update.
+// A faults.UpdateDelay fault delays the update, or skips it
+// if the fault returns an error (see package faults).
+func (sb *ServerBase) EpochUpdate(timer *EpochTimer, f func() error) {
+	failures := 0
 	for {
 		select {
 		case <-sb.stop:
 			return
-		case <-timer.C:
+		case <-timer.C():
+			// the requests received while the update waits for the
+			// requests in flight are shed as well
+			if sb.newSnapshotHandler != nil {
+				sb.RLock()
+				sb.snapshotHandler.Store(sb.newSnapshotHandler())
+				sb.RUnlock()
+			}
 			sb.Lock()
-			f()
-			timer.Reset(timer.duration)
+			next := timer.duration
+			if err := faults.Check(faults.UpdateDelay); err != nil {
+				sb.logger.Warn("Skipping the epoch update", "error", err.Error())
+			} else if err := f(); err != nil {
+				failures++
+				next = timer.retryDelay(failures)
+				sb.logger.Error("Epoch update failed", "error", err.Error(),
+					"failures", failures, "retry", next.String())
+				sb.webhooks.notify(&WebhookEvent{
+					Event: EventUpdateFailed,
+					Metadata: map[string]string{
+						"error":    err.Error(),
+						"failures": fmt.Sprint(failures),
+						"retry":    next.String(),
+					},
+				})
+			} else {
+				if failures > 0 {
+					sb.logger.Info("Epoch update succeeded after failures",
+						"failures", failures)
+				}
+				failures = 0
+			}
+			timer.Reset(next)
+			sb.snapshotHandler.Store(noSnapshotHandler)
 			sb.Unlock()
 		}
 	}
@@ -290,6 +597,13 @@ func (sb *ServerBase) HotReload(f func()) {
 	}
 }
 
+// Notify notifies the server's webhooks of the event e in the
+// background (see Webhook). It does nothing if the server has no
+// webhooks.
+func (sb *ServerBase) Notify(e *WebhookEvent) {
+	sb.webhooks.notify(e)
+}
+
 // Logger returns the server base's logger instance.
 func (sb *ServerBase) Logger() *Logger {
 	return sb.logger
@@ -1,10 +1,14 @@
 package application
 
 import (
+	"net"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/application/testutil"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
 func TestResolveAndListen(t *testing.T) {
@@ -20,6 +24,19 @@ func TestResolveAndListen(t *testing.T) {
 	ln, _ := addr.resolveAndListen()
 	defer ln.Close()
 
+	// test restricting the resolution to IPv4
+	addr = &ServerAddress{
+		Address:     "tcp://localhost:0",
+		TLSCertPath: path.Join(dir, "server.pem"),
+		TLSKeyPath:  path.Join(dir, "server.key"),
+		Resolution:  &utils.ResolutionPolicy{Family: utils.FamilyIPv4},
+	}
+	ln, _ = addr.resolveAndListen()
+	defer ln.Close()
+	if ip := ln.Addr().(*net.TCPAddr).IP; ip.To4() == nil {
+		t.Fatal("Expect an IPv4 listener, got", ip)
+	}
+
 	// test Unix network
 	addr = &ServerAddress{
 		Address: testutil.LocalConnection,
@@ -38,3 +55,129 @@ func TestResolveAndListen(t *testing.T) {
 	}()
 	addr.resolveAndListen()
 }
+
+func TestServerAddressValidate(t *testing.T) {
+	dir, teardown := testutil.CreateTLSCertForTest(t)
+	defer teardown()
+
+	for _, tc := range []struct {
+		name  string
+		addr  *ServerAddress
+		valid bool
+	}{
+		{"tcp", &ServerAddress{
+			Address:     testutil.PublicConnection,
+			TLSCertPath: path.Join(dir, "server.pem"),
+			TLSKeyPath:  path.Join(dir, "server.key"),
+		}, true},
+		{"unix", &ServerAddress{Address: testutil.LocalConnection}, true},
+		{"tcp without certificate", &ServerAddress{Address: testutil.PublicConnection}, false},
+		{"malformed extra address", &ServerAddress{
+			Address:        testutil.LocalConnection,
+			ExtraAddresses: []string{"tcp://[127.0.0.1]:3000"},
+		}, false},
+		{"contradicting family", &ServerAddress{
+			Address:    "tcp6://[::1]:3000",
+			Resolution: &utils.ResolutionPolicy{Family: utils.FamilyIPv4},
+		}, false},
+	} {
+		if err := tc.addr.Validate(); (err == nil) != tc.valid {
+			t.Error(tc.name, "expect valid", tc.valid, "got", err)
+		}
+	}
+}
+
+func TestEpochTimerRetryDelay(t *testing.T) {
+	timer := NewEpochTimerWithClock(utils.NewFakeClock(time.Unix(0, 0)), 16)
+	for _, tc := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 16 * time.Second},
+		{10, 16 * time.Second},
+	} {
+		if got := timer.retryDelay(tc.failures); got != tc.want {
+			t.Error(tc.failures, "failures: expect", tc.want, "got", got)
+		}
+	}
+}
+
+func TestLoadSheddingDuringSlowUpdate(t *testing.T) {
+	addr := &ServerAddress{}
+	sb := NewServerBase(&CommonConfig{
+		Logger: &LoggerConfig{Environment: "development"},
+	}, "Testing", map[*ServerAddress]map[int]bool{
+		addr: {protocol.KeyLookupType: true},
+	})
+	snapshot := protocol.NewErrorResponse(protocol.ReqSuccess)
+	sb.SetLoadShedding(func() func(req *protocol.Request) *protocol.Response {
+		return func(req *protocol.Request) *protocol.Response {
+			return snapshot
+		}
+	})
+	latest := protocol.NewErrorResponse(protocol.ReqSuccess)
+	l := &listener{label: "test"}
+	req := &protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: "alice"},
+	}
+	// lookup expects the lookup to be answered with want without
+	// waiting for the update
+	lookup := func(want *protocol.Response) {
+		res := make(chan *protocol.Response, 1)
+		go func() {
+			res <- sb.handle(addr, l, "192.0.2.1:1000", req,
+				func(string, *protocol.Request) *protocol.Response {
+					return latest
+				})
+		}()
+		select {
+		case got := <-res:
+			if got != want {
+				t.Fatal("Expect the lookup to be answered from the snapshot:",
+					want == snapshot)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expect the lookup not to wait for the update")
+		}
+	}
+
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	timer := NewEpochTimerWithClock(clock, 60)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sb.EpochUpdate(timer, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		close(done)
+	}()
+
+	// a request in flight delays the update
+	sb.RLock()
+	clock.Advance(60 * time.Second)
+	shedding := func() bool {
+		return sb.snapshotHandler.Load().(func(*protocol.Request) *protocol.Response) != nil
+	}
+	for deadline := time.Now().Add(time.Second); !shedding(); {
+		if time.Now().After(deadline) {
+			t.Fatal("Expect the snapshot handler to be installed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	lookup(snapshot)
+	sb.RUnlock()
+
+	<-started
+	lookup(snapshot)
+	close(release)
+	sb.Shutdown()
+	<-done
+	lookup(latest)
+}
@@ -0,0 +1,260 @@
+// Generates the machine-readable description of the CONIKS protocol
+// which this implementation speaks, from the request types it decodes,
+// the error codes it defines and the state machine of the client's
+// consistency checks, so that the documentation and the conformance
+// suite can't drift from the implementation.
+
+package application
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+)
+
+// A ProtocolSpec is the machine-readable description of the protocol
+// (see NewProtocolSpec()). Its JSON encoding includes the JSON schemas
+// of the request and response messages, in the subset of JSON Schema
+// described by Schema, whose named types are defined in Definitions.
+type ProtocolSpec struct {
+	Version     string
+	Requests    []*RequestSpec
+	ErrorCodes  []*ErrorCodeSpec
+	States      []string
+	Transitions []*TransitionSpec
+	Definitions map[string]*Schema
+}
+
+// A RequestSpec describes a request type: its Type, the Name of its
+// message, whether it is ReadOnly (see protocol.ReadOnly()), the
+// request permission classes it belongs to (see ClientLookupRequests),
+// and the schemas of its message and of the DirectoryResponse of
+// its response, which is named Response, if the response carries one.
+type RequestSpec struct {
+	Type           int
+	Name           string
+	ReadOnly       bool
+	Classes        []string `json:",omitempty"`
+	Request        *Schema
+	Response       string  `json:",omitempty"`
+	ResponseSchema *Schema `json:",omitempty"`
+}
+
+// An ErrorCodeSpec describes an error code: its Code, the Name of its
+// constant, its Message, its Category, i.e., "request", "error" or
+// "check" (see protocol.ErrorCode), and whether a response with this
+// code carries no proof, so that the client skips its consistency
+// checks (see protocol.ErrorCode.SkipsChecks()).
+type ErrorCodeSpec struct {
+	Code        int
+	Name        string
+	Message     string
+	Category    string
+	SkipsChecks bool
+}
+
+// A TransitionSpec is an entry of the state table of a binding from
+// the client's point of view (see client.BindingState): the transition
+// from the state From to the state To is allowed if Error is empty,
+// and is otherwise reported with the consistency check error Error.
+type TransitionSpec struct {
+	From  string
+	To    string
+	Error string `json:",omitempty"`
+}
+
+// A Schema is the JSON schema of a message, in the subset of
+// JSON Schema needed to describe the CONIKS messages: byte strings are
+// strings with a base64 contentEncoding, and each named struct type
+// is defined once, and referenced by Ref.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// requestClasses names the request permission classes.
+var requestClasses = []struct {
+	name  string
+	types []int
+}{
+	{"ClientLookupRequests", ClientLookupRequests},
+	{"AuditorRequests", AuditorRequests},
+	{"AuditingRequests", AuditingRequests},
+	{"PushRequests", PushRequests},
+	{"GossipRequests", GossipRequests},
+}
+
+// NewProtocolSpec generates the description of the protocol: each
+// request type which UnmarshalRequest() decodes, each error code, and
+// the state table of the client's consistency checks.
+func NewProtocolSpec() *ProtocolSpec {
+	spec := &ProtocolSpec{
+		Version:     protocol.Version,
+		Definitions: make(map[string]*Schema),
+	}
+	// the request types are consecutive
+	for t := 0; newRequest(t) != nil; t++ {
+		msg := reflect.TypeOf(newRequest(t)).Elem()
+		req := &RequestSpec{
+			Type:     t,
+			Name:     msg.Name(),
+			ReadOnly: protocol.ReadOnly(t),
+			Request:  spec.schema(msg),
+		}
+		// the responses to observation reports carry an error code only
+		if t != protocol.ObservationReportType {
+			req.Response = responseType(t)
+			res := protocol.NewDirectoryResponse(req.Response)
+			req.ResponseSchema = spec.schema(reflect.TypeOf(res))
+		}
+		for _, class := range requestClasses {
+			for _, ct := range class.types {
+				if ct == t {
+					req.Classes = append(req.Classes, class.name)
+				}
+			}
+		}
+		spec.Requests = append(spec.Requests, req)
+	}
+	for _, e := range protocol.ErrorCodes() {
+		spec.ErrorCodes = append(spec.ErrorCodes, &ErrorCodeSpec{
+			Code:        int(e),
+			Name:        e.Name(),
+			Message:     e.Error(),
+			Category:    errorCategory(e),
+			SkipsChecks: e.SkipsChecks(),
+		})
+	}
+	states := client.BindingStates()
+	for _, from := range states {
+		spec.States = append(spec.States, from.String())
+		for _, to := range states {
+			tr := &TransitionSpec{From: from.String(), To: to.String()}
+			if err := client.CheckTransition(from, to); err != nil {
+				tr.Error = err.(protocol.ErrorCode).Name()
+			}
+			spec.Transitions = append(spec.Transitions, tr)
+		}
+	}
+	return spec
+}
+
+// ErrorCode returns the description of the error code e,
+// or nil if spec doesn't define e.
+func (spec *ProtocolSpec) ErrorCode(e protocol.ErrorCode) *ErrorCodeSpec {
+	for _, c := range spec.ErrorCodes {
+		if c.Code == int(e) {
+			return c
+		}
+	}
+	return nil
+}
+
+// errorCategory returns the category of the error code e, according
+// to the prefix of its name.
+func errorCategory(e protocol.ErrorCode) string {
+	switch name := e.Name(); {
+	case strings.HasPrefix(name, "Req"):
+		return "request"
+	case strings.HasPrefix(name, "Check"):
+		return "check"
+	default:
+		return "error"
+	}
+}
+
+// schema returns the schema of the JSON encoding of the values of
+// type t, and adds the named struct types it includes to
+// spec.Definitions.
+func (spec *ProtocolSpec) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min := 0
+		return &Schema{Type: "integer", Minimum: &min}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: spec.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: spec.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return spec.structSchema(t)
+		}
+		ref := &Schema{Ref: "#/definitions/" + t.Name()}
+		if _, ok := spec.Definitions[t.Name()]; !ok {
+			// reserve the name for the recursive types
+			spec.Definitions[t.Name()] = nil
+			spec.Definitions[t.Name()] = spec.structSchema(t)
+		}
+		return ref
+	default:
+		// e.g., an interface{} carrying any value
+		return &Schema{}
+	}
+}
+
+// structSchema returns the schema of the struct type t, whose exported
+// fields are encoded as by encoding/json: the fields of the embedded
+// structs are promoted, and the fields tagged omitempty are optional.
+func (spec *ProtocolSpec) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct && tag == "" {
+			embedded := spec.structSchema(ft)
+			for name, p := range embedded.Properties {
+				s.Properties[name] = p
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := f.Name
+		if opts[0] != "" {
+			name = opts[0]
+		}
+		s.Properties[name] = spec.schema(f.Type)
+		omitempty := false
+		for _, opt := range opts[1:] {
+			omitempty = omitempty || opt == "omitempty"
+		}
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
@@ -0,0 +1,62 @@
+package application
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestProtocolSpec(t *testing.T) {
+	spec := NewProtocolSpec()
+	if len(spec.Requests) != protocol.GossipType+1 {
+		t.Fatal("Expect", protocol.GossipType+1, "request types, got", len(spec.Requests))
+	}
+	for _, req := range spec.Requests {
+		if req.Request == nil || (req.ResponseSchema == nil) != (req.Response == "") {
+			t.Error("Missing schema of request type", req.Name)
+		}
+	}
+	reg := spec.Requests[protocol.RegistrationType]
+	if reg.Name != "RegistrationRequest" || reg.ReadOnly ||
+		reg.Response != "DirectoryProof" {
+		t.Fatal("Unexpected registration", reg)
+	}
+	def := spec.Definitions["RegistrationRequest"]
+	if def == nil || def.Properties["Key"].ContentEncoding != "base64" ||
+		len(def.Required) != 2 {
+		t.Fatal("Unexpected schema of the registration", def)
+	}
+	for name, def := range spec.Definitions {
+		if def == nil {
+			t.Error("Missing definition of", name)
+		}
+	}
+	lookup := spec.Requests[protocol.KeyLookupType]
+	if !lookup.ReadOnly || len(lookup.Classes) != 1 || lookup.Classes[0] != "ClientLookupRequests" {
+		t.Fatal("Unexpected key lookup", lookup)
+	}
+
+	if c := spec.ErrorCode(protocol.CheckBindingsDiffer); c == nil ||
+		c.Name != "CheckBindingsDiffer" || c.Category != "check" || c.SkipsChecks {
+		t.Fatal("Unexpected error code", c)
+	}
+	if c := spec.ErrorCode(protocol.ErrDirectory); c == nil || c.Category != "error" || !c.SkipsChecks {
+		t.Fatal("Unexpected error code", c)
+	}
+	if spec.ErrorCode(protocol.ErrorCode(0)) != nil {
+		t.Fatal("Expect no error code", 0)
+	}
+
+	if len(spec.Transitions) != len(spec.States)*len(spec.States) {
+		t.Fatal("Expect a complete state table, got", len(spec.Transitions), "transitions")
+	}
+	for _, tr := range spec.Transitions {
+		if tr.From == "Deactivated" && tr.To == "Included" && tr.Error != "CheckBindingsDiffer" {
+			t.Fatal("Unexpected transition", tr)
+		}
+	}
+	if _, err := json.Marshal(spec); err != nil {
+		t.Fatal(err)
+	}
+}
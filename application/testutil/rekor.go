@@ -0,0 +1,80 @@
+package testutil
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol/rekor"
+)
+
+// NewRekorServer serves the API of a Rekor log backed by the test log
+// l, i.e., the creation, the retrieval and the search of its entries,
+// and writes the log's public key in the directory dir.
+// It returns the URL of the server and the path to the log's public key,
+// and a function which stops the server.
+func NewRekorServer(t *testing.T, l *rekor.TestLog, dir string) (url, keyPath string,
+	teardown func()) {
+	der, err := x509.MarshalPKIXPublicKey(l.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath = path.Join(dir, "rekor.pub")
+	if err := ioutil.WriteFile(keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	const entriesPath = "/api/v1/log/entries"
+	mux := http.NewServeMux()
+	mux.HandleFunc(entriesPath, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || r.Method != http.MethodPost {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		uuid, e, existed, err := l.Add(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if existed {
+			w.Header().Set("Location", entriesPath+"/"+uuid)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]*rekor.Entry{uuid: e})
+	})
+	mux.HandleFunc(entriesPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		uuid := strings.TrimPrefix(r.URL.Path, entriesPath+"/")
+		e := l.Entry(uuid)
+		if e == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]*rekor.Entry{uuid: e})
+	})
+	mux.HandleFunc("/api/v1/index/retrieve", func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uuids := l.Search(strings.TrimPrefix(query.Hash, "sha256:"))
+		if uuids == nil {
+			uuids = []string{}
+		}
+		json.NewEncoder(w).Encode(uuids)
+	})
+	srv := httptest.NewServer(mux)
+	return srv.URL, keyPath, srv.Close
+}
@@ -11,25 +11,16 @@ package testutil
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/json"
-	"encoding/pem"
 	"io"
 	"io/ioutil"
-	"math/big"
 	"net"
-	"net/url"
 	"os"
-	"path"
 	"testing"
-	"time"
 
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
 const (
@@ -39,79 +30,15 @@ const (
 	PublicConnection = "tcp://127.0.0.1:3000"
 	// LocalConnection is the default address for Unix socket connections
 	LocalConnection = "unix:///tmp/conikstest.sock"
+	// MemConnection is the default address for in-memory connections,
+	// which need neither a socket nor a TLS certificate
+	MemConnection = "mem://conikstest"
 )
 
-type ExpectingDirProofResponse struct {
-	Error             protocol.ErrorCode
-	DirectoryResponse struct {
-		AP  []json.RawMessage
-		STR []json.RawMessage
-		TB  json.RawMessage
-	}
-}
-
-type ExpectingSTR struct {
-	Epoch uint64
-}
-
 // CreateTLSCert generates a new self-signed TLS certificate
-// and stores it in the path given by dir.
+// and stores it in the path given by dir (see utils.CreateTLSCert()).
 func CreateTLSCert(dir string) error {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return err
-	}
-
-	notBefore := time.Now()
-	notAfter := notBefore.Add(1 * time.Hour)
-
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	if err != nil {
-		return err
-	}
-
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"Coniks.org"},
-		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
-
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-		IsCA: true,
-	}
-	template.Subject.CommonName = "localhost"
-	template.IPAddresses = append(template.IPAddresses, net.ParseIP("127.0.0.1"))
-
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
-	if err != nil {
-		return err
-	}
-
-	certOut, err := os.Create(path.Join(dir, "server.pem"))
-	if err != nil {
-		return err
-	}
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	certOut.Close()
-
-	keyOut, err := os.OpenFile(path.Join(dir, "server.key"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
-	}
-
-	b, err := x509.MarshalECPrivateKey(priv)
-	if err != nil {
-		return err
-	}
-	pemBlock := &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
-	pem.Encode(keyOut, pemBlock)
-	keyOut.Close()
-	return nil
+	return utils.CreateTLSCert(dir)
 }
 
 // CreateTLSCertForTest generates a temporary self-signed TLS certificate
@@ -134,9 +61,17 @@ func CreateTLSCertForTest(t *testing.T) (string, func()) {
 // request msg to the server listening at the given address
 // via a TCP connection.
 func NewTCPClient(msg []byte, address string) ([]byte, error) {
+	return NewTCPClientWithPolicy(msg, address, nil)
+}
+
+// NewTCPClientWithPolicy creates a basic test client that sends
+// a given request msg to the server listening at the given address
+// via a TCP connection, resolving the server's host name under
+// the given policy (see utils.ResolutionPolicy).
+func NewTCPClientWithPolicy(msg []byte, address string,
+	policy *utils.ResolutionPolicy) ([]byte, error) {
 	conf := &tls.Config{InsecureSkipVerify: true}
-	u, _ := url.Parse(address)
-	conn, err := net.Dial(u.Scheme, u.Host)
+	conn, err := utils.Dial(address, policy)
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +91,7 @@ func NewTCPClient(msg []byte, address string) ([]byte, error) {
 	}
 
 	var buf bytes.Buffer
-	if _, err := io.CopyN(&buf, tlsConn, 8192); err != nil && err != io.EOF {
+	if _, err := io.CopyN(&buf, tlsConn, protocol.MaxResponseSize); err != nil && err != io.EOF {
 		return nil, err
 	}
 
@@ -174,22 +109,46 @@ func NewTCPClientDefault(msg []byte) ([]byte, error) {
 // request msg to the server listening at the given address
 // via a Unix socket connection.
 func NewUnixClient(msg []byte, address string) ([]byte, error) {
-	u, _ := url.Parse(address)
-	unixaddr := &net.UnixAddr{Name: u.Path, Net: u.Scheme}
-	conn, err := net.DialUnix(u.Scheme, nil, unixaddr)
+	network, name, err := utils.ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	unixaddr := &net.UnixAddr{Name: name, Net: network}
+	conn, err := net.DialUnix(network, nil, unixaddr)
+	if err != nil {
+		return nil, err
+	}
+	return send(conn, msg)
+}
+
+// NewMemClient creates a basic test client that sends a given
+// request msg to the server listening at the given in-memory address
+// "mem://name" (see utils.ListenMem()), without any socket.
+func NewMemClient(msg []byte, address string) ([]byte, error) {
+	conn, err := utils.Dial(address, nil)
 	if err != nil {
 		return nil, err
 	}
+	return send(conn, msg)
+}
+
+// send writes msg to conn, closes the sending direction of conn,
+// and returns the response read from conn.
+func send(conn net.Conn, msg []byte) ([]byte, error) {
 	defer conn.Close()
 
-	_, err = conn.Write([]byte(msg))
+	_, err := conn.Write([]byte(msg))
 	if err != nil {
 		return nil, err
 	}
 
-	conn.CloseWrite()
+	if c, ok := conn.(interface {
+		CloseWrite() error
+	}); ok {
+		c.CloseWrite()
+	}
 	var buf bytes.Buffer
-	if _, err := io.CopyN(&buf, conn, 8192); err != nil && err != io.EOF {
+	if _, err := io.CopyN(&buf, conn, protocol.MaxResponseSize); err != nil && err != io.EOF {
 		return nil, err
 	}
 
@@ -202,3 +161,18 @@ func NewUnixClient(msg []byte, address string) ([]byte, error) {
 func NewUnixClientDefault(msg []byte) ([]byte, error) {
 	return NewUnixClient(msg, LocalConnection)
 }
+
+// JSONSTR returns a copy of str decoded from its JSON encoding,
+// as an application loads it from its configuration or receives it
+// from a directory.
+func JSONSTR(t *testing.T, str *protocol.DirSTR) *protocol.DirSTR {
+	buf, err := json.Marshal(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := new(protocol.DirSTR)
+	if err := json.Unmarshal(buf, res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
@@ -0,0 +1,216 @@
+// Implements the webhooks through which a server notifies its operators
+// of its epoch events and anomalies, e.g., to page them or to post
+// them to a chat, without scraping the server's logs.
+
+package application
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// These are the events of which a server notifies its webhooks.
+const (
+	// EventEpoch reports that the server has issued a new STR.
+	EventEpoch = "epoch"
+	// EventUpdateFailed reports that an epoch update has failed,
+	// and will be retried (see ServerBase.EpochUpdate()).
+	EventUpdateFailed = "update_failed"
+	// EventPolicyChange reports that the policies of the new STR
+	// differ from the policies of the previous STR.
+	EventPolicyChange = "policy_change"
+	// EventRateLimited reports that the server has rejected requests
+	// exceeding its rate limits (see RateLimits). The server reports
+	// the rejected requests at most once per RateLimitedInterval.
+	EventRateLimited = "rate_limited"
+)
+
+// RateLimitedInterval is the minimum interval between two
+// EventRateLimited notifications of a server.
+const RateLimitedInterval = time.Minute
+
+// webhookTimeout bounds the time of each request to a webhook.
+const webhookTimeout = 10 * time.Second
+
+// webhookQueueSize is the number of events a server queues for its
+// webhooks. The events notified while the queue is full are dropped,
+// so that a slow webhook never delays the server.
+const webhookQueueSize = 64
+
+// A Webhook is a URL to which a server POSTs a JSON-encoded
+// WebhookEvent for each of the Events it notifies, or for every event
+// if Events is empty.
+type Webhook struct {
+	URL    string   `toml:"url"`
+	Events []string `toml:"events,omitempty"`
+}
+
+// Validate checks that the webhook's URL is an HTTP(S) URL,
+// and that its events are known.
+func (h *Webhook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid URL of the webhook: %q", h.URL)
+	}
+	for _, event := range h.Events {
+		switch event {
+		case EventEpoch, EventUpdateFailed, EventPolicyChange, EventRateLimited:
+		default:
+			return fmt.Errorf("Unknown event of the webhook %q: %q", h.URL, event)
+		}
+	}
+	return nil
+}
+
+// accepts returns whether the webhook h is notified of event.
+func (h *Webhook) accepts(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// A WebhookEvent is the payload a server POSTs to its webhooks.
+// Epoch and STRHash identify the STR the event refers to, if any:
+// STRHash is the hex-encoded hash of the STR's signature, with which
+// the next STR is chained. Metadata describes the event, e.g., the
+// error of a failed update.
+type WebhookEvent struct {
+	Event    string            `json:"event"`
+	Time     time.Time         `json:"time"`
+	Epoch    uint64            `json:"epoch,omitempty"`
+	STRHash  string            `json:"str_hash,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// NewSTREvent returns the WebhookEvent event referring to the STR str,
+// with the given metadata.
+func NewSTREvent(event string, str *protocol.DirSTR,
+	metadata map[string]string) *WebhookEvent {
+	return &WebhookEvent{
+		Event:    event,
+		Epoch:    str.Epoch,
+		STRHash:  hex.EncodeToString(crypto.Digest(str.Signature)),
+		Metadata: metadata,
+	}
+}
+
+// webhooks delivers the events of a server to its webhooks
+// in the background.
+type webhooks struct {
+	hooks  []*Webhook
+	client *http.Client
+	queue  chan *WebhookEvent
+	logger *Logger
+
+	// rateLimitedLock guards the rejected requests not reported yet
+	rateLimitedLock sync.Mutex
+	rateLimited     int
+	lastRateLimited time.Time
+}
+
+// newWebhooks returns the webhooks delivering events to hooks,
+// or nil if hooks is empty.
+func newWebhooks(hooks []*Webhook, logger *Logger) *webhooks {
+	if len(hooks) == 0 {
+		return nil
+	}
+	return &webhooks{
+		hooks:  hooks,
+		client: &http.Client{Timeout: webhookTimeout},
+		queue:  make(chan *WebhookEvent, webhookQueueSize),
+		logger: logger,
+	}
+}
+
+// notify queues the event e, stamped with the current time unless
+// it has a time, or drops it if the queue is full.
+func (w *webhooks) notify(e *WebhookEvent) {
+	if w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case w.queue <- e:
+	default:
+		w.logger.Warn("Webhook queue full, dropping the event", "event", e.Event)
+	}
+}
+
+// notifyRateLimited records a request rejected by the rate limits, and
+// notifies an EventRateLimited reporting the rejected requests
+// recorded since the previous notification, if it is older than
+// RateLimitedInterval.
+func (w *webhooks) notifyRateLimited(now time.Time) {
+	if w == nil {
+		return
+	}
+	w.rateLimitedLock.Lock()
+	w.rateLimited++
+	if now.Sub(w.lastRateLimited) < RateLimitedInterval {
+		w.rateLimitedLock.Unlock()
+		return
+	}
+	count := w.rateLimited
+	w.rateLimited = 0
+	w.lastRateLimited = now
+	w.rateLimitedLock.Unlock()
+	w.notify(&WebhookEvent{
+		Event:    EventRateLimited,
+		Time:     now,
+		Metadata: map[string]string{"requests": fmt.Sprint(count)},
+	})
+}
+
+// run delivers the queued events until stop is closed.
+func (w *webhooks) run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case e := <-w.queue:
+			for _, h := range w.hooks {
+				if !h.accepts(e.Event) {
+					continue
+				}
+				if err := w.post(h, e); err != nil {
+					w.logger.Warn("Cannot notify the webhook", "url", h.URL,
+						"event", e.Event, "error", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// post POSTs the event e to the webhook h.
+func (w *webhooks) post(h *Webhook, e *WebhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	res, err := w.client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("Unexpected status of the webhook: %s", res.Status)
+	}
+	return nil
+}
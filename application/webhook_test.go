@@ -0,0 +1,121 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// newTestWebhook returns a webhook server, which sends the events
+// it receives on the returned channel.
+func newTestWebhook(t *testing.T) (*httptest.Server, chan *WebhookEvent) {
+	events := make(chan *WebhookEvent, webhookQueueSize)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := new(WebhookEvent)
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+	}))
+	return ts, events
+}
+
+func receiveEvent(t *testing.T, events chan *WebhookEvent) *WebhookEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expect an event")
+		return nil
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hook *Webhook
+		ok   bool
+	}{
+		{"all events", &Webhook{URL: "https://example.org/hook"}, true},
+		{"some events", &Webhook{URL: "http://example.org/hook",
+			Events: []string{EventUpdateFailed, EventRateLimited}}, true},
+		{"unknown event", &Webhook{URL: "https://example.org/hook",
+			Events: []string{"reboot"}}, false},
+		{"not HTTP", &Webhook{URL: "tcp://example.org:3000"}, false},
+	} {
+		if err := tc.hook.Validate(); (err == nil) != tc.ok {
+			t.Error(tc.name, "expect valid", tc.ok, "got", err)
+		}
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	all, allEvents := newTestWebhook(t)
+	defer all.Close()
+	failures, failureEvents := newTestWebhook(t)
+	defer failures.Close()
+
+	sb := NewServerBase(&CommonConfig{
+		Logger: &LoggerConfig{Environment: "development"},
+		Webhooks: []*Webhook{
+			{URL: all.URL},
+			{URL: failures.URL, Events: []string{EventUpdateFailed}},
+		},
+	}, "Listen", nil)
+	defer sb.Shutdown()
+
+	sb.Notify(&WebhookEvent{Event: EventEpoch, Epoch: 1, STRHash: "00"})
+	if e := receiveEvent(t, allEvents); e.Event != EventEpoch || e.Epoch != 1 ||
+		e.STRHash != "00" || e.Time.IsZero() {
+		t.Fatal("Expect the epoch event, got", e)
+	}
+
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	timer := NewEpochTimerWithClock(clock, 60)
+	sb.RunInBackground(func() {
+		sb.EpochUpdate(timer, func() error {
+			return errors.New("disk full")
+		})
+	})
+	clock.Advance(time.Minute)
+	for _, events := range []chan *WebhookEvent{failureEvents, allEvents} {
+		if e := receiveEvent(t, events); e.Event != EventUpdateFailed ||
+			e.Metadata["error"] != "disk full" || e.Metadata["failures"] != "1" {
+			t.Fatal("Expect the failed update, got", e)
+		}
+	}
+	select {
+	case e := <-failureEvents:
+		t.Fatal("Expect only the failed updates, got", e)
+	default:
+	}
+}
+
+func TestWebhookRateLimited(t *testing.T) {
+	ts, events := newTestWebhook(t)
+	defer ts.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	w := newWebhooks([]*Webhook{{URL: ts.URL}},
+		NewLogger(&LoggerConfig{Environment: "development"}))
+	go w.run(stop)
+
+	now := time.Unix(0, 0)
+	w.notifyRateLimited(now)
+	if e := receiveEvent(t, events); e.Event != EventRateLimited ||
+		e.Metadata["requests"] != "1" {
+		t.Fatal("Expect a rate limited request, got", e)
+	}
+	for i := 0; i < 10; i++ {
+		w.notifyRateLimited(now.Add(time.Second))
+	}
+	w.notifyRateLimited(now.Add(RateLimitedInterval))
+	if e := receiveEvent(t, events); e.Metadata["requests"] != "11" {
+		t.Fatal("Expect the rate limited requests of the interval, got", e)
+	}
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// A completionCommand is used to generate the shell completion
+// script of a CONIKS executable.
+type completionCommand struct {
+	appName string
+}
+
+var _ cobraCommand = (*completionCommand)(nil)
+
+// NewCompletionCommand constructs a new CompletionCommand for the
+// given executable's appName.
+func NewCompletionCommand(appName string) *cobra.Command {
+	complCmd := &completionCommand{
+		appName: appName,
+	}
+	return complCmd.Build()
+}
+
+// Build constructs the cobra.Command according to the
+// CompletionCommand's settings.
+func (complCmd *completionCommand) Build() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "completion [bash|zsh|powershell]",
+		Short: "Generate the shell completion script of " + complCmd.appName + ".",
+		Long: `Generate the shell completion script of ` + complCmd.appName + `.
+
+To load the completions in the current bash shell, run
+	source <(` + complCmd.appName + ` completion bash)`,
+		ValidArgs: []string{"bash", "zsh", "powershell"},
+		Args:      cobra.ExactValidArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletion(os.Stdout)
+			case "zsh":
+				return root.GenZshCompletion(os.Stdout)
+			default:
+				return root.GenPowerShellCompletion(os.Stdout)
+			}
+		},
+	}
+	return &cmd
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"log"
+	"path"
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/auditor"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/utils"
+	"github.com/spf13/cobra"
+)
+
+// initCmd represents the init command
+var initCmd = cli.NewInitCommand("CONIKS auditor", initRunFunc)
+
+func init() {
+	RootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringP("dir", "d", ".", "Location of directory for storing generated files")
+	initCmd.Flags().BoolP("cert", "c", false, "Generate self-signed ssl keys/cert with sane defaults")
+}
+
+func initRunFunc(cmd *cobra.Command, args []string) {
+	dir := cmd.Flag("dir").Value.String()
+	mkConfig(dir)
+
+	cert, err := strconv.ParseBool(cmd.Flag("cert").Value.String())
+	if err == nil && cert {
+		utils.CreateTLSCert(dir)
+	}
+}
+
+func mkConfig(dir string) {
+	file := path.Join(dir, "config.toml")
+	dirs := []*auditor.DirectoryConfig{
+		&auditor.DirectoryConfig{
+			SignPubkeyPath: "../coniksserver/sign.pub",
+			InitSTRPath:    "../coniksserver/init.str",
+			Address:        "tcp://127.0.0.1:3000",
+		},
+	}
+
+	addrs := []*auditor.Address{
+		&auditor.Address{
+			ServerAddress: &application.ServerAddress{
+				Address:     "tcp://0.0.0.0:3002",
+				TLSCertPath: "server.pem",
+				TLSKeyPath:  "server.key",
+			},
+		},
+		&auditor.Address{
+			ServerAddress: &application.ServerAddress{
+				Address: "unix:///tmp/coniksauditor.sock",
+			},
+			AcceptPushes: true,
+		},
+	}
+
+	logger := &application.LoggerConfig{
+		EnableStacktrace: true,
+		Environment:      "development",
+		Path:             "coniksauditor.log",
+	}
+
+	conf := auditor.NewConfig(file, "toml", dirs, addrs, logger, 10)
+
+	if err := conf.Save(); err != nil {
+		log.Println(err)
+	}
+}
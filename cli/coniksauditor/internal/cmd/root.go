@@ -0,0 +1,23 @@
+// Package cmd implements the CLI commands for a CONIKS auditor.
+package cmd
+
+import (
+	"github.com/coniks-sys/coniks-go/cli"
+)
+
+// RootCmd represents the base "coniksauditor" command when called without any subcommands.
+var RootCmd = cli.NewRootCommand("coniksauditor",
+	"CONIKS auditor reference implementation in Go",
+	`
+________  _______  __    _  ___  ___   _  _______
+|       ||       ||  |  | ||   ||   | | ||       |
+|       ||   _   ||   |_| ||   ||   |_| ||  _____|
+|       ||  | |  ||       ||   ||      _|| |_____
+|      _||  |_|  ||  _    ||   ||     |_ |_____  |
+|     |_ |       || | |   ||   ||    _  | _____| |
+|_______||_______||_|  |__||___||___| |_||_______|
+`)
+
+func init() {
+	cli.AddLoggerFlags(RootCmd)
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/coniks-sys/coniks-go/application/auditor"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+// runCmd represents the run command
+var runCmd = cli.NewRunCommand("CONIKS auditor",
+	`Run a CONIKS auditor instance.
+
+This will look for config files with default names
+in the current directory if not specified differently.
+	`, run)
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+	cli.AddConfigFlag(runCmd, "auditor", "config.toml")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	confPath := cmd.Flag("config").Value.String()
+	conf := &auditor.Config{}
+	if err := conf.Load(confPath, "toml"); err != nil {
+		log.Fatal(err)
+	}
+	if err := cli.ApplyLoggerFlags(cmd, conf.Logger); err != nil {
+		log.Fatal(err)
+	}
+	aud, err := auditor.New(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// run the auditor until receiving an interrupt signal
+	aud.Run(conf.Addresses)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	<-ch
+	aud.Shutdown()
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/coniks-sys/coniks-go/application/auditor"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify-str",
+	Short: "Check an STR against the auditor's observed history.",
+	Long: `Check an STR obtained out-of-band (e.g., copied from a directory's website)
+against the STR history the auditor observes for the directory.
+
+The auditor first fetches and audits the directory's latest STRs, and then
+reports whether the given STR matches the STR the directory has issued
+for the same epoch. A divergence means that the directory equivocates.
+
+The directory is identified by the hex-encoded hash of its initial STR.
+Run verify-str with an unknown directory to list the audited directories.`,
+	Run: verify,
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+	cli.AddConfigFlag(verifyCmd, "auditor", "config.toml")
+	verifyCmd.Flags().StringP("file", "f", "str.json", "Path to the JSON-encoded STR to verify")
+	verifyCmd.Flags().StringP("dir", "d", "", "Hex-encoded hash of the directory's initial STR")
+}
+
+func verify(cmd *cobra.Command, args []string) {
+	conf := &auditor.Config{}
+	if err := conf.Load(cmd.Flag("config").Value.String(), "toml"); err != nil {
+		log.Fatal(err)
+	}
+	// the running auditor locks its database: audit the history
+	// from scratch instead
+	conf.DatabasePath = ""
+	aud, err := auditor.New(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var dirInitHash [crypto.HashSizeByte]byte
+	buf, err := hex.DecodeString(cmd.Flag("dir").Value.String())
+	copy(dirInitHash[:], buf)
+	if _, ok := aud.Directories()[dirInitHash]; err != nil ||
+		len(buf) != len(dirInitHash) || !ok {
+		fmt.Println("Unknown directory. The audited directories are:")
+		for h, addr := range aud.Directories() {
+			fmt.Println(hex.EncodeToString(h[:]), addr)
+		}
+		os.Exit(-1)
+	}
+
+	buf, err = ioutil.ReadFile(cmd.Flag("file").Value.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	str := new(protocol.DirSTR)
+	if err := json.Unmarshal(buf, str); err != nil {
+		log.Fatalf("Cannot parse STR: %v", err)
+	}
+
+	if err := aud.Sync(dirInitHash); err != nil {
+		fmt.Println("Cannot audit the directory's latest STRs:", err)
+		os.Exit(-1)
+	}
+	switch err := aud.VerifySTR(dirInitHash, str); err {
+	case nil:
+		fmt.Println("Match: the directory has issued this STR for epoch", str.Epoch)
+	case protocol.CheckBadSTR:
+		fmt.Println("Divergence: the directory has issued a different STR for epoch",
+			str.Epoch)
+		os.Exit(1)
+	default:
+		fmt.Println("Cannot verify the STR:", err)
+		os.Exit(-1)
+	}
+}
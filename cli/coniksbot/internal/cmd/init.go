@@ -3,9 +3,12 @@ package cmd
 import (
 	"log"
 	"path"
+	"strconv"
 
 	"github.com/coniks-sys/coniks-go/application/bots"
 	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -15,12 +18,30 @@ var initCmd = cli.NewInitCommand("CONIKS bot", mkBotConfig)
 func init() {
 	RootCmd.AddCommand(initCmd)
 	initCmd.Flags().StringP("dir", "d", ".", "Location of directory for storing generated files")
+	initCmd.Flags().Bool("detached", false, "Run the bot in detached mode, and generate its attestation key pair")
+	initCmd.Flags().String("provider", "twitter", "Identity provider whose accounts the bot verifies (twitter, xmpp, email or webhook)")
 }
 
 func mkBotConfig(cmd *cobra.Command, args []string) {
 	dir := cmd.Flag("dir").Value.String()
 	file := path.Join(dir, "botconfig.toml")
+	detached, _ := strconv.ParseBool(cmd.Flag("detached").Value.String())
 
+	switch provider := cmd.Flag("provider").Value.String(); provider {
+	case "twitter":
+		mkTwitterConfig(dir, file, detached)
+	case "xmpp":
+		mkXMPPConfig(dir, file, detached)
+	case "email":
+		mkEmailConfig(dir, file, detached)
+	case "webhook":
+		mkWebhookConfig(dir, file, detached)
+	default:
+		log.Fatalf("Unknown identity provider %q", provider)
+	}
+}
+
+func mkTwitterConfig(dir, file string, detached bool) {
 	oauth := bots.TwitterOAuth{
 		ConsumerKey:    "secret",
 		ConsumerSecret: "secret",
@@ -30,7 +51,75 @@ func mkBotConfig(cmd *cobra.Command, args []string) {
 
 	conf := bots.NewTwitterConfig(file, "toml", "/tmp/coniks.sock", "ConiksTorMess",
 		oauth)
+	if detached {
+		conf.Detached = true
+		conf.SignKeyPath = "attestation.priv"
+		conf.AttestationLifetime = bots.DefaultAttestationLifetime
+		mkAttestationKey(dir)
+	}
+	if err := conf.Save(); err != nil {
+		log.Print(err)
+	}
+}
+
+func mkXMPPConfig(dir, file string, detached bool) {
+	conf := bots.NewXMPPConfig(file, "toml", "/tmp/coniks.sock",
+		"xmpp.example.org:5222", "coniksbot@example.org", "secret")
+	if detached {
+		conf.Detached = true
+		conf.SignKeyPath = "attestation.priv"
+		conf.AttestationLifetime = bots.DefaultAttestationLifetime
+		mkAttestationKey(dir)
+	}
+	if err := conf.Save(); err != nil {
+		log.Print(err)
+	}
+}
+
+func mkEmailConfig(dir, file string, detached bool) {
+	conf := bots.NewEmailConfig(file, "toml", "/tmp/coniks.sock",
+		"imap.example.org:993", "smtp.example.org:587", "coniksbot@example.org", "secret")
+	if detached {
+		conf.Detached = true
+		conf.SignKeyPath = "attestation.priv"
+		conf.AttestationLifetime = bots.DefaultAttestationLifetime
+		mkAttestationKey(dir)
+	}
+	if err := conf.Save(); err != nil {
+		log.Print(err)
+	}
+}
+
+func mkWebhookConfig(dir, file string, detached bool) {
+	conf := bots.NewWebhookConfig(file, "toml", "/tmp/coniks.sock",
+		":8443", "server.pem", "server.key", "provider.pub", "@example.org")
+	if detached {
+		conf.Detached = true
+		conf.SignKeyPath = "attestation.priv"
+		conf.AttestationLifetime = bots.DefaultAttestationLifetime
+		mkAttestationKey(dir)
+	}
 	if err := conf.Save(); err != nil {
 		log.Print(err)
 	}
 }
+
+// mkAttestationKey generates the key pair with which a detached bot
+// signs its attestations. The public key attestation.pub has to be
+// copied to the CONIKS server (see its bots setting).
+func mkAttestationKey(dir string) {
+	sk, err := sign.GenerateKey(nil)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	pk, _ := sk.Public()
+	if err := utils.WriteFile(path.Join(dir, "attestation.priv"), sk, 0600); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := utils.WriteFile(path.Join(dir, "attestation.pub"), pk, 0600); err != nil {
+		log.Println(err)
+		return
+	}
+}
@@ -1,5 +1,7 @@
 // Package cmd provides the CLI commands for a CONIKS
-// account verification bot for Twitter accounts.
+// account verification bot for Twitter and XMPP accounts,
+// email addresses, and the registrations posted by any identity
+// provider to a webhook.
 package cmd
 
 import (
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/bots"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the credentials of a running CONIKS bot.",
+	Long: `Make a running CONIKS bot reload its configuration file, and switch
+to the Twitter OAuth credentials, the reserved handle and the attestation
+key it specifies, without dropping the registrations in flight.
+
+The bot must have been started with an admin_address in its config file.
+Sending SIGUSR2 to the bot's process has the same effect.`,
+	Run: rotate,
+}
+
+func init() {
+	RootCmd.AddCommand(rotateCmd)
+	cli.AddConfigFlag(rotateCmd, "bot", "botconfig.toml")
+}
+
+func rotate(cmd *cobra.Command, args []string) {
+	conf := &bots.TwitterConfig{}
+	if err := conf.Load(cmd.Flag("config").Value.String(), "toml"); err != nil {
+		log.Fatal(err)
+	}
+	if conf.AdminAddress == "" {
+		log.Fatal("The bot's config file doesn't specify an admin_address")
+	}
+	reply, err := application.SendAdminCommand(conf.AdminAddress, bots.RotateCommand)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(reply)
+	if reply != "OK" {
+		os.Exit(-1)
+	}
+}
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/coniks-sys/coniks-go/application/bots"
 	"github.com/coniks-sys/coniks-go/cli"
@@ -16,15 +18,34 @@ var runCmd = cli.NewRunCommand("CONIKS bot",
 
 This will look for config files with default names
 in the current directory if not specified differently.
+The --provider flag selects the identity provider whose
+accounts the bot verifies, and thus the format of the
+config file.
 	`, run)
 
 func init() {
 	RootCmd.AddCommand(runCmd)
-	runCmd.Flags().StringP("config", "c", "botconfig.toml", "Path to bot configuration file")
+	cli.AddConfigFlag(runCmd, "bot", "botconfig.toml")
+	runCmd.Flags().String("provider", "twitter", "Identity provider whose accounts the bot verifies (twitter, xmpp, email or webhook)")
 }
 
 func run(cmd *cobra.Command, args []string) {
 	confPath := cmd.Flag("config").Value.String()
+	switch provider := cmd.Flag("provider").Value.String(); provider {
+	case "twitter":
+		runTwitter(confPath)
+	case "xmpp":
+		runXMPP(confPath)
+	case "email":
+		runEmail(confPath)
+	case "webhook":
+		runWebhook(confPath)
+	default:
+		log.Fatalf("Unknown identity provider %q", provider)
+	}
+}
+
+func runTwitter(confPath string) {
 	conf := &bots.TwitterConfig{}
 	if err := conf.Load(confPath, "toml"); err != nil {
 		fmt.Println(err)
@@ -37,6 +58,86 @@ func run(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
+	bot.Run()
+	if conf.AdminAddress != "" {
+		ln, err := bots.ServeAdmin(conf.AdminAddress, bot)
+		if err != nil {
+			panic(err)
+		}
+		defer ln.Close()
+	}
+
+	// SIGUSR2 rotates the bot's credentials (see bots.Rotator)
+	rotate := make(chan os.Signal, 1)
+	signal.Notify(rotate, syscall.SIGUSR2)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	for {
+		select {
+		case <-rotate:
+			if err := bot.Rotate(); err != nil {
+				log.Printf("[registration bot] Rotation failed: %v", err)
+			}
+		case <-ch:
+			bot.Stop()
+			return
+		}
+	}
+}
+
+func runXMPP(confPath string) {
+	conf := &bots.XMPPConfig{}
+	if err := conf.Load(confPath, "toml"); err != nil {
+		fmt.Println(err)
+		fmt.Print("Couldn't load the bot's config-file.")
+		os.Exit(-1)
+	}
+
+	bot, err := bots.NewXMPPBot(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	bot.Run()
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	<-ch
+	bot.Stop()
+}
+
+func runEmail(confPath string) {
+	conf := &bots.EmailConfig{}
+	if err := conf.Load(confPath, "toml"); err != nil {
+		fmt.Println(err)
+		fmt.Print("Couldn't load the bot's config-file.")
+		os.Exit(-1)
+	}
+
+	bot, err := bots.NewEmailBot(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	bot.Run()
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	<-ch
+	bot.Stop()
+}
+
+func runWebhook(confPath string) {
+	conf := &bots.WebhookConfig{}
+	if err := conf.Load(confPath, "toml"); err != nil {
+		fmt.Println(err)
+		fmt.Print("Couldn't load the bot's config-file.")
+		os.Exit(-1)
+	}
+
+	bot, err := bots.NewWebhookBot(conf)
+	if err != nil {
+		panic(err)
+	}
+
 	bot.Run()
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
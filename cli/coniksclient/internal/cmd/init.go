@@ -24,6 +24,7 @@ func mkConfigOrExit(cmd *cobra.Command, args []string) {
 	conf := client.NewConfig(file, "toml", "../coniksserver/sign.pub",
 		"../../keyserver/coniksserver/init.str",
 		"tcp://127.0.0.1:3000", "tcp://127.0.0.1:3000")
+	conf.StatePath = "client.state"
 
 	if err := conf.Save(); err != nil {
 		fmt.Println("Couldn't save config. Error message: [" +
@@ -2,25 +2,42 @@ package cmd
 
 import (
 	"log"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/application"
 	clientapp "github.com/coniks-sys/coniks-go/application/client"
-	"github.com/coniks-sys/coniks-go/application/testutil"
 	"github.com/coniks-sys/coniks-go/cli"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
 	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/utils"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-const help = "- register [name] [key]:\r\n" +
+const help = "- register [name] [key] [directory]:\r\n" +
 	"	Register a new name-to-key binding on the CONIKS-server.\r\n" +
-	"- lookup [name]:\r\n" +
+	"	The name may be a typed identifier, e.g. device:[id] or service:[id].\r\n" +
+	"	The key of the name can later be changed without a signature.\r\n" +
+	"- change [name] [key] [directory]:\r\n" +
+	"	Change the key bound to one of your names. The new key takes\r\n" +
+	"	effect in the next epoch.\r\n" +
+	"- deactivate [name] [directory]:\r\n" +
+	"	Retire one of your names. From the next epoch on, the name is\r\n" +
+	"	bound to no key and can neither be changed nor registered again.\r\n" +
+	"- lookup [name] [directory]:\r\n" +
 	"	Lookup the key of some known contact or your own bindings.\r\n" +
+	"- catchup [directory]:\r\n" +
+	"	Catch up with the directory's latest STR, from the latest pinned\r\n" +
+	"	checkpoint if the client's verified STR is older.\r\n" +
+	"- witness [directory]:\r\n" +
+	"	Check that the directory's Rekor log records the latest verified STR.\r\n" +
+	"- directories:\r\n" +
+	"	List the configured directories. Commands which take an optional\r\n" +
+	"	[directory] are sent to the default directory if it is omitted.\r\n" +
 	"- enable timestamp:\r\n" +
 	"	Print timestamp of format <15:04:05.999999999> along with the result.\r\n" +
 	"- disable timestamp:\r\n" +
@@ -30,22 +47,30 @@ const help = "- register [name] [key]:\r\n" +
 	"- exit, q:\r\n" +
 	"	Close the REPL and exit the client."
 
-var runCmd = cli.NewRunCommand("CONIKS test client", "Run gives you a REPL, so that you can invoke commands to perform CONIKS operations including registration and key lookup. Currently, it supports:\n"+help, run)
+var runCmd = cli.NewRunCommand("CONIKS test client", "Run gives you a REPL, so that you can invoke commands to perform CONIKS operations including registration, key change and key lookup. Currently, it supports:\n"+help, run)
 
 func init() {
 	RootCmd.AddCommand(runCmd)
-	runCmd.Flags().StringP("config", "c", "config.toml",
-		"Config file for the client (contains the server's initial public key etc).")
+	cli.AddConfigFlag(runCmd, "client", "config.toml")
 	runCmd.Flags().BoolP("debug", "d", false, "Turn on debugging mode")
+	runCmd.Flags().Bool("telemetry", false,
+		"Report the verified STRs anonymously to the telemetry auditors of the directories")
 }
 
 func run(cmd *cobra.Command, args []string) {
 	isDebugging, _ := strconv.ParseBool(cmd.Flag("debug").Value.String())
 	conf := loadConfigOrExit(cmd)
-
-	// FIXME: right now we're passing the initSTR, but we should really
-	// be passing the latest pinned STR here
-	cc := client.New(conf.InitSTR, true, conf.SigningPubKey)
+	dirs := clientapp.NewDirectories(conf)
+	if err := dirs.LoadStates(); err != nil {
+		log.Fatal(err)
+	}
+	if telemetry, _ := strconv.ParseBool(cmd.Flag("telemetry").Value.String()); telemetry {
+		stop := make(chan struct{})
+		defer close(stop)
+		for _, dir := range dirs {
+			go dir.ReportObservations(utils.RealClock, stop, nil)
+		}
+	}
 
 	state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
 	if err != nil {
@@ -88,60 +113,143 @@ func run(cmd *cobra.Command, args []string) {
 			default:
 				writeLineInRawMode(term, "[!] Unrecognized command: "+line, isDebugging)
 			}
+		case "directories":
+			for _, dir := range conf.AllDirectories() {
+				writeLineInRawMode(term, "[+] "+dir.Name+": "+dir.Address, isDebugging)
+			}
 		case "register":
-			if len(args) != 3 {
+			if len(args) != 3 && len(args) != 4 {
 				writeLineInRawMode(term, "[!] Incorrect number of args to register.", isDebugging)
 				continue
 			}
-			msg := register(cc, conf, args[1], args[2])
+			dir, ok := selectDirectory(dirs, conf, args[3:])
+			if !ok {
+				writeLineInRawMode(term, "[!] Unknown directory: "+args[3], isDebugging)
+				continue
+			}
+			msg := register(dir, args[1], args[2])
+			writeLineInRawMode(term, "[+] "+msg, isDebugging)
+			saveState(term, dir, isDebugging)
+		case "change":
+			if len(args) != 3 && len(args) != 4 {
+				writeLineInRawMode(term, "[!] Incorrect number of args to change.", isDebugging)
+				continue
+			}
+			dir, ok := selectDirectory(dirs, conf, args[3:])
+			if !ok {
+				writeLineInRawMode(term, "[!] Unknown directory: "+args[3], isDebugging)
+				continue
+			}
+			msg := keyChange(dir, args[1], args[2])
+			writeLineInRawMode(term, "[+] "+msg, isDebugging)
+			saveState(term, dir, isDebugging)
+		case "deactivate":
+			if len(args) != 2 && len(args) != 3 {
+				writeLineInRawMode(term, "[!] Incorrect number of args to deactivate.", isDebugging)
+				continue
+			}
+			dir, ok := selectDirectory(dirs, conf, args[2:])
+			if !ok {
+				writeLineInRawMode(term, "[!] Unknown directory: "+args[2], isDebugging)
+				continue
+			}
+			msg := deactivate(dir, args[1])
 			writeLineInRawMode(term, "[+] "+msg, isDebugging)
+			saveState(term, dir, isDebugging)
 		case "lookup":
-			if len(args) != 2 {
+			if len(args) != 2 && len(args) != 3 {
 				writeLineInRawMode(term, "[!] Incorrect number of args to lookup.", isDebugging)
 				continue
 			}
-			msg := keyLookup(cc, conf, args[1])
+			dir, ok := selectDirectory(dirs, conf, args[2:])
+			if !ok {
+				writeLineInRawMode(term, "[!] Unknown directory: "+args[2], isDebugging)
+				continue
+			}
+			msg := keyLookup(dir, args[1])
 			writeLineInRawMode(term, "[+] "+msg, isDebugging)
+			saveState(term, dir, isDebugging)
+		case "catchup":
+			if len(args) != 1 && len(args) != 2 {
+				writeLineInRawMode(term, "[!] Incorrect number of args to catchup.", isDebugging)
+				continue
+			}
+			dir, ok := selectDirectory(dirs, conf, args[1:])
+			if !ok {
+				writeLineInRawMode(term, "[!] Unknown directory: "+args[1], isDebugging)
+				continue
+			}
+			writeLineInRawMode(term, "[+] "+catchUp(dir), isDebugging)
+			saveState(term, dir, isDebugging)
+		case "witness":
+			if len(args) != 1 && len(args) != 2 {
+				writeLineInRawMode(term, "[!] Incorrect number of args to witness.", isDebugging)
+				continue
+			}
+			dir, ok := selectDirectory(dirs, conf, args[1:])
+			if !ok {
+				writeLineInRawMode(term, "[!] Unknown directory: "+args[1], isDebugging)
+				continue
+			}
+			writeLineInRawMode(term, "[+] "+witness(dir), isDebugging)
 		default:
 			writeLineInRawMode(term, "[!] Unrecognized command: "+cmd, isDebugging)
 		}
 	}
 }
 
-func register(cc *client.ConsistencyChecks, conf *clientapp.Config, name string, key string) string {
-	req, err := clientapp.CreateRegistrationMsg(name, []byte(key))
+// selectDirectory returns the directory named by the optional
+// selector args, or the default directory if args is empty.
+func selectDirectory(dirs clientapp.Directories, conf *clientapp.Config,
+	args []string) (*clientapp.Directory, bool) {
+	name := conf.Name
+	if len(args) > 0 {
+		name = args[0]
+	}
+	dir, ok := dirs[name]
+	return dir, ok
+}
+
+// saveState saves the consistency state of dir after a command, so that
+// the next run of the client resumes from it.
+func saveState(term *terminal.Terminal, dir *clientapp.Directory, isDebugging bool) {
+	if err := dir.SaveState(); err != nil {
+		writeLineInRawMode(term, "[!] Couldn't save the client's state: "+err.Error(), isDebugging)
+	}
+}
+
+func register(dir *clientapp.Directory, name string, key string) string {
+	if _, err := protocol.ParseCanonicalIdentifier(name); err != nil {
+		return ("Invalid name: " + name)
+	}
+	req, err := clientapp.CreateUnsignedKeyChangeRegistrationMsg(name, []byte(key))
 	if err != nil {
 		return ("Couldn't marshal registration request!")
 	}
 
-	var res []byte
-	regAddress := conf.RegAddress
+	regAddress := dir.RegAddress
 	if regAddress == "" {
-		// fallback to conf.Address if empty
-		regAddress = conf.Address
+		// fallback to dir.Address if empty
+		regAddress = dir.Address
 	}
-	u, _ := url.Parse(regAddress)
-	switch u.Scheme {
-	case "tcp":
-		res, err = testutil.NewTCPClient(req, regAddress)
-		if err != nil {
-			return ("Error while receiving response: " + err.Error())
-		}
-	case "unix":
-		res, err = testutil.NewUnixClient(req, regAddress)
-		if err != nil {
-			return ("Error while receiving response: " + err.Error())
-		}
-	default:
-		return ("Invalid config!")
+	response, err := sendToDirectory(dir, protocol.RegistrationType, req, regAddress)
+	if err != nil {
+		return ("Error while receiving response: " + err.Error())
 	}
 
-	response := application.UnmarshalResponse(protocol.RegistrationType, res)
-	err = cc.HandleResponse(protocol.RegistrationType, response, name, []byte(key))
+	err = dir.CC.HandleResponse(protocol.RegistrationType, response, name, []byte(key))
+	if err == protocol.CheckUnconfirmedSTR {
+		err = confirmRegistration(dir, name)
+	}
 	switch err {
+	case protocol.CheckUnconfirmedSTR:
+		return ("No auditor confirmed the directory's STR in time, the registration was rejected.")
+	case protocol.CheckAuditorDisagrees:
+		return ("Error: " + err.Error() + ", the directory may be equivocating!")
+	case protocol.CheckUnsupportedSuite:
+		return ("Error: " + err.Error() + ". The directory uses algorithms this client doesn't support, try upgrading the client.")
 	case protocol.CheckBadSTR:
-		// FIXME: remove me
-		return ("Error: " + err.Error() + ". Maybe the client missed an epoch in between two commands, monitoring isn't supported yet.")
+		return ("Error: " + err.Error() + ". Maybe the client missed an epoch in between two commands, try catchup first.")
 	case nil:
 		switch response.Error {
 		case protocol.ReqSuccess:
@@ -152,7 +260,7 @@ func register(cc *client.ConsistencyChecks, conf *clientapp.Config, name string,
 	case protocol.CheckBindingsDiffer:
 		switch response.Error {
 		case protocol.ReqNameExisted:
-			return (`Are you trying to update your binding? Unfortunately, KeyChange isn't supported yet.`)
+			return (`Are you trying to update your binding? Use the change command instead.`)
 		case protocol.ReqSuccess:
 			recvKey, err := response.GetKey()
 			if err != nil {
@@ -166,39 +274,201 @@ func register(cc *client.ConsistencyChecks, conf *clientapp.Config, name string,
 	return ""
 }
 
-func keyLookup(cc *client.ConsistencyChecks, conf *clientapp.Config, name string) string {
-	req, err := clientapp.CreateKeyLookupMsg(name)
+func keyChange(dir *clientapp.Directory, name string, key string) string {
+	req, err := clientapp.CreateKeyChangeMsg(name, []byte(key))
 	if err != nil {
-		return ("Couldn't marshal key lookup request!")
+		return ("Couldn't marshal key change request!")
+	}
+
+	regAddress := dir.RegAddress
+	if regAddress == "" {
+		// fallback to dir.Address if empty
+		regAddress = dir.Address
+	}
+	response, err := sendToDirectory(dir, protocol.KeyChangeType, req, regAddress)
+	if err != nil {
+		return ("Error while receiving response: " + err.Error())
+	}
+
+	err = dir.CC.HandleKeyChangeResponse(&protocol.KeyChangeRequest{
+		Username: name,
+		Key:      []byte(key),
+	}, response)
+	switch err {
+	case nil:
+		return ("The key of " + name + " changes to [" + key + "] in the next epoch.")
+	case protocol.ReqNameNotFound:
+		return ("Name isn't registered, or its registration hasn't taken effect yet.")
+	case protocol.ReqNameExisted:
+		return ("A key change is already pending for this name.")
+	case protocol.ReqNameFrozen:
+		return ("The directory's operator has frozen the binding of this name.")
+	case protocol.ErrMalformedMessage:
+		return ("The directory rejected the change: the name was registered without allowing unsigned key changes.")
+	case protocol.CheckBadSTR:
+		return ("Error: " + err.Error() + ". Maybe the client missed an epoch in between two commands, try catchup first.")
+	default:
+		return ("Error: " + err.Error())
+	}
+}
+
+func deactivate(dir *clientapp.Directory, name string) string {
+	req, err := clientapp.CreateDeactivationMsg(name)
+	if err != nil {
+		return ("Couldn't marshal deactivation request!")
+	}
+
+	regAddress := dir.RegAddress
+	if regAddress == "" {
+		// fallback to dir.Address if empty
+		regAddress = dir.Address
+	}
+	response, err := sendToDirectory(dir, protocol.DeactivationType, req, regAddress)
+	if err != nil {
+		return ("Error while receiving response: " + err.Error())
+	}
+
+	err = dir.CC.HandleDeactivationResponse(&protocol.DeactivationRequest{
+		Username: name,
+	}, response)
+	switch err {
+	case nil:
+		return ("The binding of " + name + " is deactivated in the next epoch.")
+	case protocol.ReqNameNotFound:
+		return ("Name isn't registered, or its registration hasn't taken effect yet.")
+	case protocol.ReqNameExisted:
+		return ("A key change is already pending for this name.")
+	case protocol.ReqNameDeactivated:
+		return ("The name has already been deactivated.")
+	case protocol.ReqNameFrozen:
+		return ("The directory's operator has frozen the binding of this name.")
+	case protocol.ErrMalformedMessage:
+		return ("The directory rejected the deactivation: the name was registered without allowing unsigned key changes.")
+	default:
+		return ("Error: " + err.Error())
+	}
+}
+
+// confirmRegistration asks the directory's auditors to confirm the STR
+// of the registration of name, which awaits confirmation in strict mode,
+// until one of them confirms it or the confirmation timeout has passed.
+func confirmRegistration(dir *clientapp.Directory, name string) error {
+	dirInitHash := auditor.ComputeDirectoryIdentity(dir.InitSTR)
+	for {
+		for _, addr := range dir.Strict.Auditors {
+			str := dir.CC.Unconfirmed(name)
+			if str == nil {
+				break
+			}
+			req, err := clientapp.CreateAuditingMsg(dirInitHash, str.Epoch, str.Epoch)
+			if err != nil {
+				return err
+			}
+			res, err := clientapp.SendRequest(req, addr, dir.Resolution)
+			if err != nil {
+				// try the next auditor
+				continue
+			}
+			err = dir.CC.HandleConfirmation(application.UnmarshalResponse(protocol.AuditType, res))
+			if dir.CC.Unconfirmed(name) == nil {
+				return err
+			}
+		}
+		if err, ok := dir.CC.ExpireConfirmations()[name]; ok {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// sendToDirectory sends the JSON-encoded request req of type t to the
+// address addr of the directory dir, in the directory's message
+// encoding, and returns the decoded response.
+func sendToDirectory(dir *clientapp.Directory, t int, req []byte,
+	addr string) (*protocol.Response, error) {
+	req, err := dir.EncodeRequest(req)
+	if err != nil {
+		return nil, err
 	}
+	res, err := clientapp.SendRequest(req, addr, dir.Resolution)
+	if err != nil {
+		return nil, err
+	}
+	return dir.DecodeResponse(t, res), nil
+}
 
-	var res []byte
-	u, _ := url.Parse(conf.Address)
-	switch u.Scheme {
-	case "tcp":
-		res, err = testutil.NewTCPClient(req, conf.Address)
+// catchUp fetches and verifies the STRs the directory dir has issued
+// since the client's verified STR, or since the latest pinned
+// checkpoint if it is newer (see client.ConsistencyChecks.CatchUp()).
+func catchUp(dir *clientapp.Directory) string {
+	start := dir.CC.VerifiedSTR().Epoch
+	if cp := dir.CC.LatestCheckpoint(); cp != nil {
+		start = cp.Epoch
+	}
+	for {
+		req, err := clientapp.CreateLatestSTRHistoryMsg(start)
 		if err != nil {
-			return ("Error while receiving response: " + err.Error())
+			return ("Couldn't marshal STR history request!")
 		}
-	case "unix":
-		res, err = testutil.NewUnixClient(req, conf.Address)
+		response, err := sendToDirectory(dir, protocol.STRType, req, dir.Address)
 		if err != nil {
 			return ("Error while receiving response: " + err.Error())
 		}
+		if err := dir.CC.CatchUp(response); err != nil {
+			return ("Error: " + err.Error())
+		}
+		// the directory cuts the range short if it doesn't fit
+		// in a single response
+		c := response.STRHistoryRange().Continuation
+		if c == nil {
+			break
+		}
+		start = c.NextEpoch
+	}
+	return ("Caught up with epoch " +
+		strconv.FormatUint(dir.CC.VerifiedSTR().Epoch, 10) + ".")
+}
+
+func witness(dir *clientapp.Directory) string {
+	epoch := strconv.FormatUint(dir.CC.VerifiedSTR().Epoch, 10)
+	e, err := dir.Witness()
+	switch {
+	case err == application.ErrNotRecorded:
+		return ("Error: the Rekor log doesn't record the STR for epoch " + epoch +
+			", the directory may be equivocating!")
+	case err != nil:
+		return ("Couldn't look up the STR in the Rekor log: " + err.Error())
+	case e == nil:
+		return ("The directory has no Rekor log.")
 	default:
-		return ("Invalid config!")
+		return ("The Rekor log records the STR for epoch " + epoch +
+			" at index " + strconv.FormatInt(e.LogIndex, 10) + ".")
+	}
+}
+
+func keyLookup(dir *clientapp.Directory, name string) string {
+	req, err := clientapp.CreateKeyLookupMsg(name)
+	if err != nil {
+		return ("Couldn't marshal key lookup request!")
 	}
 
-	response := application.UnmarshalResponse(protocol.KeyLookupType, res)
-	if key, ok := cc.Bindings[name]; ok {
-		err = cc.HandleResponse(protocol.KeyLookupType, response, name, []byte(key))
+	response, err := sendToDirectory(dir, protocol.KeyLookupType, req, dir.Address)
+	if err != nil {
+		return ("Error while receiving response: " + err.Error())
+	}
+
+	if key, ok := dir.CC.Binding(name); ok {
+		err = dir.CC.HandleResponse(protocol.KeyLookupType, response, name, []byte(key))
 	} else {
-		err = cc.HandleResponse(protocol.KeyLookupType, response, name, nil)
+		err = dir.CC.HandleResponse(protocol.KeyLookupType, response, name, nil)
 	}
 	switch err {
 	case protocol.CheckBadSTR:
-		// FIXME: remove me
-		return ("Error: " + err.Error() + ". Maybe the client missed an epoch in between two commands, monitoring isn't supported yet.")
+		return ("Error: " + err.Error() + ". Maybe the client missed an epoch in between two commands, try catchup first.")
+	case protocol.CheckAuditorDisagrees:
+		return ("Error: " + err.Error() + ", the directory may be equivocating!")
+	case protocol.CheckUnsupportedSuite:
+		return ("Error: " + err.Error() + ". The directory uses algorithms this client doesn't support, try upgrading the client.")
 	case nil:
 		switch response.Error {
 		case protocol.ReqSuccess:
@@ -206,6 +476,9 @@ func keyLookup(cc *client.ConsistencyChecks, conf *clientapp.Config, name string
 			if err != nil {
 				return ("Cannot get the key from the response, error: " + err.Error())
 			}
+			if dir.CC.State(name) == client.Frozen {
+				return ("Found! Key bound to name is: [" + string(key) + "], frozen by the directory's operator.")
+			}
 			return ("Found! Key bound to name is: [" + string(key) + "]")
 		case protocol.ReqNameNotFound:
 			return ("Name isn't registered.")
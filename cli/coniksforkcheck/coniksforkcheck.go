@@ -0,0 +1,12 @@
+// Executable CONIKS fork detection tool. See README for
+// usage instructions.
+package main
+
+import (
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/cli/coniksforkcheck/internal/cmd"
+)
+
+func main() {
+	cli.Execute(cmd.RootCmd)
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/utils"
+	"github.com/spf13/cobra"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check [history A] [history B]",
+	Short: "Compare two STR histories of a directory.",
+	Long: `Compare two exports of a directory's STR history, e.g. obtained from two
+vantage points, or from an auditor and a client, and report the first
+epoch for which the directory has issued different STRs.
+
+Each history is a JSON file containing either a list of STRs, an STR
+history range, or a directory's or auditor's response containing an STR
+history range, for consecutive epochs. Both histories must be signed
+with the directory's public signing key.
+
+If the histories diverge, the conflicting STRs are written as an
+equivocation evidence bundle, and the command exits with status 1.`,
+	Args: cobra.ExactArgs(2),
+	Run:  check,
+}
+
+func init() {
+	RootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringP("key", "k", "sign.pub", "Path to the directory's public signing key")
+	checkCmd.Flags().StringP("out", "o", "", "Path to the evidence file (default: standard output)")
+}
+
+func check(cmd *cobra.Command, args []string) {
+	pk, err := application.LoadSigningPubKey(cmd.Flag("key").Value.String(), "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var histories [2][]*protocol.DirSTR
+	for i, file := range args {
+		if histories[i], err = loadHistory(file); err != nil {
+			log.Fatalf("Cannot load STR history %s: %v", file, err)
+		}
+	}
+
+	e, err := auditor.FindFork(pk, histories[0], histories[1])
+	switch {
+	case err == auditor.ErrNoOverlap:
+		fmt.Println("Cannot compare the histories: they have no epoch in common")
+		os.Exit(-1)
+	case err != nil:
+		fmt.Println("Invalid STR history:", err)
+		os.Exit(-1)
+	case e == nil:
+		fmt.Println("No fork: the histories agree on all their common epochs")
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Fork: the directory has issued different STRs for epoch", e.Epoch)
+	buf, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if out := cmd.Flag("out").Value.String(); out != "" {
+		if err := utils.WriteFile(out, buf, 0644); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		fmt.Println(string(buf))
+	}
+	os.Exit(1)
+}
+
+// loadHistory reads a JSON-encoded STR history from file, which is
+// either a list of STRs, a protocol.STRHistoryRange, or
+// a protocol.Response containing an STRHistoryRange.
+func loadHistory(file string) ([]*protocol.DirSTR, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var strs []*protocol.DirSTR
+	if err := json.Unmarshal(buf, &strs); err == nil {
+		return strs, nil
+	}
+	var r struct {
+		STR               []*protocol.DirSTR
+		DirectoryResponse *protocol.STRHistoryRange
+	}
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return nil, err
+	}
+	if r.DirectoryResponse != nil {
+		return r.DirectoryResponse.STR, nil
+	}
+	return r.STR, nil
+}
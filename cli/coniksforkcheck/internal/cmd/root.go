@@ -0,0 +1,20 @@
+// Package cmd implements the CLI commands for the CONIKS fork
+// detection tool.
+package cmd
+
+import (
+	"github.com/coniks-sys/coniks-go/cli"
+)
+
+// RootCmd represents the base "coniksforkcheck" command when called without any subcommands.
+var RootCmd = cli.NewRootCommand("coniksforkcheck",
+	"CONIKS directory fork detection tool in Go",
+	`
+________  _______  __    _  ___  ___   _  _______
+|       ||       ||  |  | ||   ||   | | ||       |
+|       ||   _   ||   |_| ||   ||   |_| ||  _____|
+|       ||  | |  ||       ||   ||      _|| |_____
+|      _||  |_|  ||  _    ||   ||     |_ |_____  |
+|     |_ |       || | |   ||   ||    _  | _____| |
+|_______||_______||_|  |__||___||___| |_||_______|
+`)
@@ -0,0 +1,12 @@
+// Executable CONIKS read-only mirror. See README for
+// usage instructions.
+package main
+
+import (
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/cli/coniksmirror/internal/cmd"
+)
+
+func main() {
+	cli.Execute(cmd.RootCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"log"
+	"path"
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/mirror"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/utils"
+	"github.com/spf13/cobra"
+)
+
+// initCmd represents the init command
+var initCmd = cli.NewInitCommand("CONIKS mirror", initRunFunc)
+
+func init() {
+	RootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringP("dir", "d", ".", "Location of directory for storing generated files")
+	initCmd.Flags().BoolP("cert", "c", false, "Generate self-signed ssl keys/cert with sane defaults")
+}
+
+func initRunFunc(cmd *cobra.Command, args []string) {
+	dir := cmd.Flag("dir").Value.String()
+	mkConfig(dir)
+
+	cert, err := strconv.ParseBool(cmd.Flag("cert").Value.String())
+	if err == nil && cert {
+		utils.CreateTLSCert(dir)
+	}
+}
+
+func mkConfig(dir string) {
+	file := path.Join(dir, "config.toml")
+	addrs := []*application.ServerAddress{
+		&application.ServerAddress{
+			Address:     "tcp://0.0.0.0:3001",
+			TLSCertPath: "server.pem",
+			TLSKeyPath:  "server.key",
+		},
+	}
+
+	logger := &application.LoggerConfig{
+		EnableStacktrace: true,
+		Environment:      "development",
+		Path:             "coniksmirror.log",
+	}
+
+	conf := mirror.NewConfig(file, "toml", "../coniksserver/sign.pub",
+		"../coniksserver/init.str", "tcp://127.0.0.1:3000", addrs,
+		logger, 1000000, 10)
+
+	if err := conf.Save(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/coniks-sys/coniks-go/application/mirror"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+// runCmd represents the run command
+var runCmd = cli.NewRunCommand("CONIKS mirror",
+	`Run a CONIKS mirror instance.
+
+This will look for config files with default names
+in the current directory if not specified differently.
+	`, run)
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+	cli.AddConfigFlag(runCmd, "mirror", "config.toml")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	confPath := cmd.Flag("config").Value.String()
+	conf := &mirror.Config{}
+	if err := conf.Load(confPath, "toml"); err != nil {
+		log.Fatal(err)
+	}
+	if err := cli.ApplyLoggerFlags(cmd, conf.Logger); err != nil {
+		log.Fatal(err)
+	}
+	m := mirror.NewConiksMirror(conf)
+
+	// run the mirror until receiving an interrupt signal
+	m.Run(conf.Addresses)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	<-ch
+	m.Shutdown()
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"log"
+	"path"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/monitor"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+// initCmd represents the init command
+var initCmd = cli.NewInitCommand("CONIKS monitor", initRunFunc)
+
+func init() {
+	RootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringP("dir", "d", ".", "Location of directory for storing generated files")
+}
+
+func initRunFunc(cmd *cobra.Command, args []string) {
+	dir := cmd.Flag("dir").Value.String()
+	mkConfig(dir)
+}
+
+func mkConfig(dir string) {
+	file := path.Join(dir, "config.toml")
+	bindings := []*monitor.Binding{
+		&monitor.Binding{
+			Name: "alice",
+			Key:  "alice's key",
+		},
+	}
+
+	logger := &application.LoggerConfig{
+		EnableStacktrace: true,
+		Environment:      "development",
+		Path:             "coniksmonitor.log",
+	}
+
+	alerts := monitor.AlertConfig{
+		Command: `notify-send "CONIKS" "$CONIKS_ALERT"`,
+	}
+
+	conf := monitor.NewConfig(file, "toml", "../coniksclient/config.toml",
+		bindings, logger, 60, alerts)
+
+	if err := conf.Save(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,11 @@
+package cmd
+
+import (
+	"github.com/coniks-sys/coniks-go/cli"
+)
+
+var versionCmd = cli.NewVersionCommand("coniksmonitor")
+
+func init() {
+	RootCmd.AddCommand(versionCmd)
+}
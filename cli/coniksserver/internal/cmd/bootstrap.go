@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapCmd represents the bootstrap command
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Sign a seed file of reserved bindings for a new CONIKS server.",
+	Long: `Sign a seed file of reserved bindings for a new CONIKS server.
+
+The bindings are read from a JSON list of {"Username": ..., "Key": ...}
+objects, with base64-encoded keys. Set the bootstrap_path field of the
+server's policies to the generated seed file to include these bindings
+in the initial STR of the server's directory.`,
+	Run: bootstrap,
+}
+
+func init() {
+	RootCmd.AddCommand(bootstrapCmd)
+	bootstrapCmd.Flags().StringP("bindings", "b", "bindings.json", "Path to the JSON list of reserved bindings")
+	bootstrapCmd.Flags().StringP("key", "k", "sign.priv", "Path to the server's signing key")
+	bootstrapCmd.Flags().StringP("out", "o", "bootstrap.json", "Path to the generated seed file")
+}
+
+func bootstrap(cmd *cobra.Command, args []string) {
+	buf, err := ioutil.ReadFile(cmd.Flag("bindings").Value.String())
+	if err != nil {
+		log.Fatalf("Cannot read bindings: %v", err)
+	}
+	var bindings []*protocol.BootstrapBinding
+	if err := json.Unmarshal(buf, &bindings); err != nil {
+		log.Fatalf("Cannot parse bindings: %v", err)
+	}
+	signKey, err := ioutil.ReadFile(cmd.Flag("key").Value.String())
+	if err != nil {
+		log.Fatalf("Cannot read signing key: %v", err)
+	}
+	if len(signKey) != sign.PrivateKeySize {
+		log.Fatalf("Signing key must be 64 bytes (got %d)", len(signKey))
+	}
+	seed := protocol.NewBootstrapSeed(signKey, bindings)
+	pk, _ := sign.PrivateKey(signKey).Public()
+	if err := seed.Verify(pk); err != nil {
+		log.Fatalf("Invalid bindings: %v", err)
+	}
+	if err := application.SaveBootstrapSeed(cmd.Flag("out").Value.String(), seed); err != nil {
+		log.Fatal(err)
+	}
+}
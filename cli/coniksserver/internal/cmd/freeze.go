@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/server"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+var freezeCmd = &cobra.Command{
+	Use:   "freeze USERNAME",
+	Short: "Freeze the binding of a username in a running CONIKS server.",
+	Long: `Freeze the binding of USERNAME in a running CONIKS server, e.g., as
+a moderation action: the server rejects the key changes and
+deactivations of USERNAME until the binding is unfrozen. The freeze
+takes effect in the next epoch, and is recorded in the directory, so
+that the clients can tell it from a silent change of the user's key.
+
+The server must have been started with an admin_address in its config
+file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendFreezeCommand(cmd, server.FreezeCommand, args[0])
+	},
+}
+
+var unfreezeCmd = &cobra.Command{
+	Use:   "unfreeze USERNAME",
+	Short: "Unfreeze the binding of a username in a running CONIKS server.",
+	Long: `Unfreeze the binding of USERNAME frozen with the freeze command.
+The binding can be changed again from the next epoch on.
+
+The server must have been started with an admin_address in its config
+file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sendFreezeCommand(cmd, server.UnfreezeCommand, args[0])
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(freezeCmd)
+	RootCmd.AddCommand(unfreezeCmd)
+	cli.AddConfigFlag(freezeCmd, "server", "config.toml")
+	cli.AddConfigFlag(unfreezeCmd, "server", "config.toml")
+}
+
+func sendFreezeCommand(cmd *cobra.Command, command, name string) {
+	conf := &server.Config{}
+	if err := conf.Load(cmd.Flag("config").Value.String(), "toml"); err != nil {
+		log.Fatal(err)
+	}
+	if conf.AdminAddress == "" {
+		log.Fatal("The server's config file doesn't specify an admin_address")
+	}
+	reply, err := application.SendAdminCommand(conf.AdminAddress, command+" "+name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.HasPrefix(reply, "OK") {
+		log.Fatal(reply)
+	}
+	fmt.Println(reply)
+}
@@ -1,14 +1,15 @@
 package cmd
 
 import (
+	"crypto/rand"
 	"log"
 	"path"
 	"strconv"
 
 	"github.com/coniks-sys/coniks-go/application"
 	"github.com/coniks-sys/coniks-go/application/server"
-	"github.com/coniks-sys/coniks-go/application/testutil"
 	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/utils"
@@ -22,21 +23,30 @@ func init() {
 	RootCmd.AddCommand(initCmd)
 	initCmd.Flags().StringP("dir", "d", ".", "Location of directory for storing generated files")
 	initCmd.Flags().BoolP("cert", "c", false, "Generate self-signed ssl keys/cert with sane defaults")
+	initCmd.Flags().String("vrf", string(vrf.Ed25519SHA3Elligator),
+		"VRF construction of the generated VRF key ("+
+			string(vrf.Ed25519SHA3Elligator)+" or "+string(vrf.VXEdDSA)+")")
+	initCmd.Flags().Bool("salt-key", false, "Generate a master secret from which the commitment salts are derived")
 }
 
 func initRunFunc(cmd *cobra.Command, args []string) {
 	dir := cmd.Flag("dir").Value.String()
-	mkConfig(dir)
+	alg := vrf.Algorithm(cmd.Flag("vrf").Value.String())
+	saltKey, _ := strconv.ParseBool(cmd.Flag("salt-key").Value.String())
+	mkConfig(dir, alg, saltKey)
 	mkSigningKey(dir)
-	mkVrfKey(dir)
+	mkVrfKey(dir, alg)
+	if saltKey {
+		mkSaltKey(dir)
+	}
 
 	cert, err := strconv.ParseBool(cmd.Flag("cert").Value.String())
 	if err == nil && cert {
-		testutil.CreateTLSCert(dir)
+		utils.CreateTLSCert(dir)
 	}
 }
 
-func mkConfig(dir string) {
+func mkConfig(dir string, alg vrf.Algorithm, saltKey bool) {
 	file := path.Join(dir, "config.toml")
 	addrs := []*server.Address{
 		&server.Address{
@@ -62,12 +72,17 @@ func mkConfig(dir string) {
 
 	policies := &server.Policies{
 		EpochDeadline: 60,
+		VRFAlgorithm:  alg,
 		VRFKeyPath:    "vrf.priv",
 		SignKeyPath:   "sign.priv",
 	}
+	if saltKey {
+		policies.SaltKeyPath = "salt.key"
+	}
 
 	conf := server.NewConfig(file, "toml", addrs, logger, 1000000, policies,
 		"init.str")
+	conf.LatestSTRPath = "latest.str"
 
 	if err := conf.Save(); err != nil {
 		log.Println(err)
@@ -91,18 +106,30 @@ func mkSigningKey(dir string) {
 	}
 }
 
-func mkVrfKey(dir string) {
-	sk, err := vrf.GenerateKey(nil)
+func mkVrfKey(dir string, alg vrf.Algorithm) {
+	sk, err := vrf.GenerateKeyFor(alg, nil)
 	if err != nil {
 		log.Print(err)
 		return
 	}
-	pk, _ := sk.Public()
-	if err := utils.WriteFile(path.Join(dir, "vrf.priv"), sk, 0600); err != nil {
+	pk, _ := sk.PublicKey()
+	if err := utils.WriteFile(path.Join(dir, "vrf.priv"), sk.Bytes(), 0600); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := utils.WriteFile(path.Join(dir, "vrf.pub"), pk.Bytes(), 0600); err != nil {
 		log.Println(err)
 		return
 	}
-	if err := utils.WriteFile(path.Join(dir, "vrf.pub"), pk, 0600); err != nil {
+}
+
+func mkSaltKey(dir string) {
+	key := make([]byte, crypto.SaltKeySize)
+	if _, err := rand.Read(key); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := utils.WriteFile(path.Join(dir, "salt.key"), key, 0600); err != nil {
 		log.Println(err)
 		return
 	}
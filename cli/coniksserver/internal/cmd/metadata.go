@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/server"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata (get|set|delete) ARG",
+	Short: "Read or write the metadata store of a running CONIKS server.",
+	Long: `Read or write the operational data a running CONIKS server keeps about
+its users, apart from its directory.
+
+get and delete take a username, and set takes a JSON object, e.g.,
+	coniksserver metadata set '{"Username": "alice@twitter", "Flags": ["spam"]}'
+
+The server must have been started with a metadata_path and an
+admin_address in its config file.`,
+	Args: cobra.ExactArgs(2),
+	Run:  metadata,
+}
+
+func init() {
+	RootCmd.AddCommand(metadataCmd)
+	cli.AddConfigFlag(metadataCmd, "server", "config.toml")
+}
+
+func metadata(cmd *cobra.Command, args []string) {
+	commands := map[string]string{
+		"get":    server.GetMetadataCommand,
+		"set":    server.SetMetadataCommand,
+		"delete": server.DeleteMetadataCommand,
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		log.Fatalf("Unknown metadata command %q", args[0])
+	}
+	conf := &server.Config{}
+	if err := conf.Load(cmd.Flag("config").Value.String(), "toml"); err != nil {
+		log.Fatal(err)
+	}
+	if conf.AdminAddress == "" {
+		log.Fatal("The server's config file doesn't specify an admin_address")
+	}
+	reply, err := application.SendAdminCommand(conf.AdminAddress,
+		command+" "+strings.Replace(args[1], "\n", " ", -1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(reply)
+	if args[0] != "get" && reply != "OK" {
+		os.Exit(-1)
+	}
+}
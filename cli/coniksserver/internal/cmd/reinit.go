@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/application/server"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/spf13/cobra"
+)
+
+var reinitCmd = &cobra.Command{
+	Use:   "reinit",
+	Short: "Discard a CONIKS server's directory and start a new one.",
+	Long: `Discard the directory of a stopped CONIKS server, i.e., its database,
+its initial STR and its latest STR, and create a new directory, which
+the server serves once it is started again.
+
+If the server doesn't persist its directory, the new directory is
+created when the server starts. The new directory has a new identity: its history doesn't extend the
+one the server's clients and auditors have verified, so they have to
+be given the new initial STR. The server refuses to start with a lost
+or rolled back history until it is re-initialized.
+
+The --force flag is required, since the previous directory is lost.`,
+	Run: reinit,
+}
+
+func init() {
+	RootCmd.AddCommand(reinitCmd)
+	cli.AddConfigFlag(reinitCmd, "server", "config.toml")
+	reinitCmd.Flags().Bool("force", false, "Discard the server's directory")
+}
+
+func reinit(cmd *cobra.Command, args []string) {
+	if force, _ := strconv.ParseBool(cmd.Flag("force").Value.String()); !force {
+		log.Fatal("Re-initializing discards the server's directory; run with --force to proceed")
+	}
+	conf := &server.Config{}
+	if err := conf.Load(cmd.Flag("config").Value.String(), "toml"); err != nil {
+		log.Fatal(err)
+	}
+	initSTR, err := server.Reinitialize(conf)
+	if err != nil {
+		log.Fatalf("Cannot re-initialize the directory: %v", err)
+	}
+	if initSTR == nil {
+		fmt.Println("The server creates a new directory when it starts. " +
+			"Distribute its new initial STR to the server's clients and auditors.")
+		return
+	}
+	fmt.Printf("New directory identity: %x\n", auditor.ComputeDirectoryIdentity(initSTR))
+	fmt.Println("Distribute the new initial STR to the server's clients and auditors.")
+}
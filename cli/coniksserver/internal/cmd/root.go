@@ -17,3 +17,7 @@ ________  _______  __    _  ___  ___   _  _______
 |     |_ |       || | |   ||   ||    _  | _____| |
 |_______||_______||_|  |__||___||___| |_||_______|
 `)
+
+func init() {
+	cli.AddLoggerFlags(RootCmd)
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/coniks-sys/coniks-go/application/server"
 	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/coniks-sys/coniks-go/utils/faults"
 	"github.com/spf13/cobra"
 )
 
@@ -23,8 +24,10 @@ in the current directory if not specified differently.
 
 func init() {
 	RootCmd.AddCommand(runCmd)
-	runCmd.Flags().StringP("config", "c", "config.toml", "Path to server configuration file")
+	cli.AddConfigFlag(runCmd, "server", "config.toml")
 	runCmd.Flags().BoolP("pid", "p", false, "Write down the process id to coniks.pid in the current working directory")
+	runCmd.Flags().String("chaos", "", "Inject the specified faults, e.g., \"storage-write=0.01,update-delay=5s\" (requires a build with the faults tag)")
+	runCmd.Flags().Bool("dry-run", false, "Validate the configuration and print the would-be STR and policies hashes, without starting the server")
 }
 
 func run(cmd *cobra.Command, args []string) {
@@ -34,11 +37,25 @@ func run(cmd *cobra.Command, args []string) {
 	if pid {
 		writePID()
 	}
+	if err := faults.Configure(cmd.Flag("chaos").Value.String()); err != nil {
+		log.Fatal(err)
+	}
 
 	conf := &server.Config{}
 	if err := conf.Load(confPath, "toml"); err != nil {
 		log.Fatal(err)
 	}
+	if err := cli.ApplyLoggerFlags(cmd, conf.Logger); err != nil {
+		log.Fatal(err)
+	}
+	if dryRun, _ := strconv.ParseBool(cmd.Flag("dry-run").Value.String()); dryRun {
+		report, err := server.DryRun(conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report.Write(os.Stdout)
+		return
+	}
 	serv := server.NewConiksServer(conf)
 
 	// run the server until receiving an interrupt signal
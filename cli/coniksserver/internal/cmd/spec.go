@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/spf13/cobra"
+)
+
+var specCmd = &cobra.Command{
+	Use:   "spec",
+	Short: "Print the machine-readable description of the CONIKS protocol.",
+	Long: `Print the machine-readable description of the CONIKS protocol which
+this server speaks as JSON: the schemas of its request and response
+messages, its error codes, and the state table of the bindings which the
+clients' consistency checks enforce. The description is generated from
+the implementation, so it can't drift from it.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		spec, err := json.MarshalIndent(application.NewProtocolSpec(), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(spec))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(specCmd)
+}
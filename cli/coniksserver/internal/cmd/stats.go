@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/application/server"
+	"github.com/coniks-sys/coniks-go/cli"
+	"github.com/spf13/cobra"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats EPOCH",
+	Short: "Export the aggregate shape of a running CONIKS server's tree.",
+	Long: `Export the aggregate shape of the tree of a running CONIKS server at
+EPOCH as JSON: the number of leaves, the number of leaves at each depth,
+and the number of leaves whose authentication path has each size, in
+bytes. The export includes no index nor commitment, so it reveals no
+binding of the directory.
+
+The server must have been started with an admin_address in its config
+file, and must still keep the snapshot of EPOCH in memory.`,
+	Args: cobra.ExactArgs(1),
+	Run:  stats,
+}
+
+func init() {
+	RootCmd.AddCommand(statsCmd)
+	cli.AddConfigFlag(statsCmd, "server", "config.toml")
+}
+
+func stats(cmd *cobra.Command, args []string) {
+	conf := &server.Config{}
+	if err := conf.Load(cmd.Flag("config").Value.String(), "toml"); err != nil {
+		log.Fatal(err)
+	}
+	if conf.AdminAddress == "" {
+		log.Fatal("The server's config file doesn't specify an admin_address")
+	}
+	reply, err := application.SendAdminCommand(conf.AdminAddress,
+		server.TreeStatsCommand+" "+args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.HasPrefix(reply, "{") {
+		log.Fatal(reply)
+	}
+	fmt.Println(reply)
+}
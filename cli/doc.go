@@ -2,4 +2,10 @@
 // kind of CONIKS command-line application/executable.
 // Currently, cli supports Cobra-based CLI applications, but could be
 // expanded to support other CLI commanders.
+//
+// All executables share the completion command, which generates their
+// shell completion script, and the --help-json flag, with which
+// external tooling reads the help of any command. The commands reading
+// a configuration file locate it with the --config flag, and the
+// executables which log accept the --log-level and --json flags.
 package cli
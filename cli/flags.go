@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+)
+
+// AddConfigFlag adds the --config (-c) flag, with which all CONIKS
+// executables locate their configuration file, to cmd.
+// defaultPath is the default path of the appName's configuration file.
+func AddConfigFlag(cmd *cobra.Command, appName, defaultPath string) {
+	cmd.Flags().StringP("config", "c", defaultPath,
+		"Path to "+appName+" configuration file")
+}
+
+// AddLoggerFlags adds the --log-level and --json flags to all
+// subcommands of the executable's root command, for the executables
+// which log (see ApplyLoggerFlags()).
+func AddLoggerFlags(root *cobra.Command) {
+	root.PersistentFlags().String("log-level", "",
+		"Minimum level of the logs (debug, info, warn or error), overriding the configuration file")
+	root.PersistentFlags().Bool("json", false, "Write the logs as JSON, one object per line")
+}
+
+// ApplyLoggerFlags overrides the logger configuration conf, loaded
+// from the configuration file, with the --log-level and --json flags
+// of cmd, if set. It returns an error if the log level is unknown.
+func ApplyLoggerFlags(cmd *cobra.Command, conf *application.LoggerConfig) error {
+	if conf == nil {
+		return nil
+	}
+	if level := cmd.Flag("log-level").Value.String(); level != "" {
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+			return fmt.Errorf("Unknown log level %q", level)
+		}
+		conf.Level = level
+	}
+	if json, _ := strconv.ParseBool(cmd.Flag("json").Value.String()); json {
+		conf.Encoding = "json"
+	}
+	return nil
+}
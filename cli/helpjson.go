@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// A commandHelp is the machine-readable help of a command, which the
+// --help-json flag prints for external tooling.
+type commandHelp struct {
+	Name     string
+	Use      string
+	Short    string
+	Long     string `json:",omitempty"`
+	Flags    []*flagHelp
+	Commands []*commandHelp `json:",omitempty"`
+}
+
+// A flagHelp is the machine-readable help of a flag. Global is set
+// if the flag is inherited by all subcommands.
+type flagHelp struct {
+	Name      string
+	Shorthand string `json:",omitempty"`
+	Type      string
+	Default   string
+	Usage     string
+	Global    bool
+}
+
+func newCommandHelp(cmd *cobra.Command) *commandHelp {
+	h := &commandHelp{
+		Name:  cmd.Name(),
+		Use:   cmd.UseLine(),
+		Short: cmd.Short,
+		Long:  cmd.Long,
+		Flags: []*flagHelp{},
+	}
+	local := cmd.LocalNonPersistentFlags()
+	visit := func(f *pflag.Flag) {
+		if f.Hidden {
+			return
+		}
+		h.Flags = append(h.Flags, &flagHelp{
+			Name:      f.Name,
+			Shorthand: f.Shorthand,
+			Type:      f.Value.Type(),
+			Default:   f.DefValue,
+			Usage:     f.Usage,
+			Global:    local.Lookup(f.Name) == nil,
+		})
+	}
+	cmd.LocalFlags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() {
+			h.Commands = append(h.Commands, newCommandHelp(sub))
+		}
+	}
+	return h
+}
+
+// writeHelpJSON writes the help of cmd and of its subcommands
+// to w, encoded in JSON.
+func writeHelpJSON(cmd *cobra.Command, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newCommandHelp(cmd))
+}
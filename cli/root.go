@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/spf13/cobra"
 )
@@ -30,15 +31,39 @@ func NewRootCommand(use, short, long string) *cobra.Command {
 
 // Build constructs the cobra.Command according to the
 // RootCommand's settings.
+// The root command adds the completion command and the --help-json
+// flag, which prints the help of any command in JSON, to the
+// executable.
 func (rootCmd *rootCommand) Build() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   rootCmd.use,
 		Short: rootCmd.short,
 		Long:  rootCmd.long,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			printHelpJSON(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
 	}
+	cmd.PersistentFlags().Bool("help-json", false, "Print the help of the command in JSON and exit")
+	cmd.AddCommand(NewCompletionCommand(rootCmd.use))
 	return &cmd
 }
 
+// printHelpJSON prints the help of cmd in JSON and exits
+// if the --help-json flag is set.
+func printHelpJSON(cmd *cobra.Command) {
+	if help, _ := strconv.ParseBool(cmd.Flag("help-json").Value.String()); !help {
+		return
+	}
+	if err := writeHelpJSON(cmd, os.Stdout); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+	os.Exit(0)
+}
+
 // Execute adds all subcommands (i.e. "init" and "run") to the RootCmd
 // and sets their flags appropriately.
 func Execute(rootCmd *cobra.Command) {
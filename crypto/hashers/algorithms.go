@@ -0,0 +1,80 @@
+package hashers
+
+import (
+	"crypto/sha256"
+	"hash"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"golang.org/x/crypto/blake2b"
+)
+
+// These are the names of the hash algorithms registered by this
+// package.
+const (
+	// SHAKE128 is the default hash algorithm (see crypto.Digest()).
+	SHAKE128 = crypto.HashID
+	// SHA256 is SHA-256, whose output is truncated to its first
+	// bytes.
+	SHA256 = "SHA256"
+	// BLAKE2b is BLAKE2b with the output size as parameter, so that
+	// the truncated outputs are unrelated to each other.
+	BLAKE2b = "BLAKE2b"
+)
+
+func init() {
+	RegisterPADHasher(SHAKE128, func(size int) PADHasher {
+		return &shake128{size: size}
+	})
+	RegisterPADHasher(SHA256, func(size int) PADHasher {
+		return &stdHasher{name: SHA256, size: size, new: sha256.New}
+	})
+	RegisterPADHasher(BLAKE2b, func(size int) PADHasher {
+		return &stdHasher{name: BLAKE2b, size: size, new: func() hash.Hash {
+			h, err := blake2b.New(size, nil)
+			if err != nil {
+				panic(err)
+			}
+			return h
+		}}
+	})
+}
+
+type shake128 struct {
+	size int
+}
+
+func (h *shake128) ID() string {
+	return IDWithSize(SHAKE128, h.size)
+}
+
+func (h *shake128) Size() int {
+	return h.size
+}
+
+func (h *shake128) Digest(ms ...[]byte) []byte {
+	return crypto.DigestSize(h.size, ms...)
+}
+
+// A stdHasher is a hasher of a hash.Hash, whose output is truncated to
+// size bytes.
+type stdHasher struct {
+	name string
+	size int
+	new  func() hash.Hash
+}
+
+func (h *stdHasher) ID() string {
+	return IDWithSize(h.name, h.size)
+}
+
+func (h *stdHasher) Size() int {
+	return h.size
+}
+
+func (h *stdHasher) Digest(ms ...[]byte) []byte {
+	d := h.new()
+	for _, m := range ms {
+		d.Write(m)
+	}
+	return d.Sum(nil)[:h.size]
+}
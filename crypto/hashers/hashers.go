@@ -0,0 +1,120 @@
+// Package hashers implements a registry of the hash algorithms with
+// which a PAD hashes the nodes of its tree, similar to
+// crypto.RegisterHash() in the standard library.
+//
+// A hasher is identified by the name of its algorithm, e.g.,
+// "SHAKE128", "SHA256" or "BLAKE2b", optionally followed by its output
+// size in bits if its output is truncated, e.g., "SHA256/128"
+// (see IDWithSize()). The identifier is recorded in the HashID of the
+// policies of each STR, so that the clients verify the authentication
+// paths with the hasher of the directory which issued them
+// (see NewPADHasher()).
+package hashers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+// A PADHasher hashes the nodes of a PAD's tree
+// (see merkletree.PAD.SetHasher()).
+type PADHasher interface {
+	// ID returns the identifier of the hasher (see IDWithSize()).
+	ID() string
+	// Size returns the size of the hasher's output in bytes.
+	Size() int
+	// Digest hashes all passed byte slices.
+	// The passed slices won't be mutated.
+	Digest(ms ...[]byte) []byte
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]func(size int) PADHasher)
+)
+
+// RegisterPADHasher registers the hash algorithm name, whose hasher
+// with an output of size bytes is returned by f. All hashers output
+// up to crypto.HashSizeByte bytes, and are truncated to any whole
+// number of bytes down to crypto.MinHashSizeByte.
+// RegisterPADHasher panics if name is already registered, or if it
+// contains a "/".
+func RegisterPADHasher(name string, f func(size int) PADHasher) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; ok || name == "" || strings.Contains(name, "/") {
+		panic("[hashers] Cannot register the hasher " + strconv.Quote(name))
+	}
+	registry[name] = f
+}
+
+// Names returns the sorted names of the registered hash algorithms.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IDWithSize returns the identifier of the hash algorithm name
+// truncated to size bytes, i.e., name followed by the output size in
+// bits, or name alone if size is crypto.HashSizeByte
+// (see crypto.HashIDWithSize()).
+func IDWithSize(name string, size int) string {
+	if size == crypto.HashSizeByte {
+		return name
+	}
+	return name + "/" + strconv.Itoa(size*8)
+}
+
+// NewPADHasher returns the hasher identified by id (see IDWithSize()).
+// It returns crypto.ErrUnsupportedHash if id doesn't name a registered
+// hash algorithm, or if the output size isn't valid
+// (see crypto.ValidHashSize()), so that a truncation to less than
+// crypto.MinHashSizeByte bytes is never accepted.
+func NewPADHasher(id string) (PADHasher, error) {
+	name, size := id, crypto.HashSizeByte
+	if i := strings.Index(id, "/"); i >= 0 {
+		bits, err := strconv.Atoi(id[i+1:])
+		if err != nil || bits%8 != 0 {
+			return nil, crypto.ErrUnsupportedHash
+		}
+		name, size = id[:i], bits/8
+	}
+	mu.RLock()
+	f, ok := registry[name]
+	mu.RUnlock()
+	if !ok || !crypto.ValidHashSize(size) || IDWithSize(name, size) != id {
+		return nil, crypto.ErrUnsupportedHash
+	}
+	return f(size), nil
+}
+
+// Default returns the default hasher, i.e., crypto.HashID, whose
+// output has crypto.HashSizeByte bytes (see crypto.Digest()).
+func Default() PADHasher {
+	return &shake128{size: crypto.HashSizeByte}
+}
+
+// WithSize returns the hasher of the same algorithm as h, truncated to
+// size bytes, or crypto.ErrUnsupportedHash if size isn't valid.
+func WithSize(h PADHasher, size int) (PADHasher, error) {
+	return NewPADHasher(IDWithSize(Name(h.ID()), size))
+}
+
+// Name returns the name of the hash algorithm of the hasher identifier
+// id, i.e., id without its output size (see IDWithSize()).
+func Name(id string) string {
+	if i := strings.Index(id, "/"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
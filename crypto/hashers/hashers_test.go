@@ -0,0 +1,89 @@
+package hashers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+func TestNewPADHasher(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		size int
+		err  error
+	}{
+		{SHAKE128, crypto.HashSizeByte, nil},
+		{crypto.HashIDWithSize(crypto.MinHashSizeByte), crypto.MinHashSizeByte, nil},
+		{SHA256, crypto.HashSizeByte, nil},
+		{"SHA256/128", 16, nil},
+		{BLAKE2b, crypto.HashSizeByte, nil},
+		{"BLAKE2b/192", 24, nil},
+		{"SHA256/64", 0, crypto.ErrUnsupportedHash},
+		{"SHA256/512", 0, crypto.ErrUnsupportedHash},
+		{"SHA256/256", 0, crypto.ErrUnsupportedHash},
+		{"SHA256/129", 0, crypto.ErrUnsupportedHash},
+		{"SHA256/0128", 0, crypto.ErrUnsupportedHash},
+		{"SHA256/", 0, crypto.ErrUnsupportedHash},
+		{"MD5", 0, crypto.ErrUnsupportedHash},
+		{"", 0, crypto.ErrUnsupportedHash},
+	} {
+		h, err := NewPADHasher(tc.id)
+		if err != tc.err {
+			t.Error(tc.id, "expect", tc.err, "got", err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if h.ID() != tc.id || h.Size() != tc.size || len(h.Digest([]byte("m"))) != tc.size {
+			t.Error(tc.id, "expect", tc.id, tc.size, "got", h.ID(), h.Size())
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	msg := []byte("test message")
+	if !bytes.Equal(Default().Digest(msg[:4], msg[4:]), crypto.Digest(msg)) {
+		t.Error("Expect the default hasher to be crypto.Digest()")
+	}
+	h, _ := NewPADHasher("SHA256/128")
+	want := sha256.Sum256(msg)
+	if !bytes.Equal(h.Digest(msg[:4], msg[4:]), want[:16]) {
+		t.Error("Expect a truncated SHA-256 digest")
+	}
+	full, _ := NewPADHasher(BLAKE2b)
+	truncated, _ := NewPADHasher("BLAKE2b/128")
+	if bytes.Equal(full.Digest(msg)[:16], truncated.Digest(msg)) {
+		t.Error("Expect the BLAKE2b output size to be a parameter")
+	}
+}
+
+func TestWithSize(t *testing.T) {
+	h, _ := NewPADHasher("SHA256/128")
+	h, err := WithSize(h, crypto.HashSizeByte)
+	if err != nil || h.ID() != SHA256 {
+		t.Fatal("Expect", SHA256, "got", h, err)
+	}
+	if _, err := WithSize(h, 8); err != crypto.ErrUnsupportedHash {
+		t.Fatal("Expect", crypto.ErrUnsupportedHash, "got", err)
+	}
+}
+
+func TestRegisterPADHasher(t *testing.T) {
+	if got, want := Names(), []string{BLAKE2b, SHA256, SHAKE128}; !reflect.DeepEqual(got, want) {
+		t.Fatal("Expect", want, "got", got)
+	}
+	for _, name := range []string{SHA256, "SHA256/128", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expect registering", name, "to panic")
+				}
+			}()
+			RegisterPADHasher(name, func(size int) PADHasher { return Default() })
+		}()
+	}
+}
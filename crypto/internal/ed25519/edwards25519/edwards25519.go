@@ -1332,14 +1332,17 @@ func GeScalarMult(r *ExtendedGroupElement, a *[32]byte, A *ExtendedGroupElement)
 // and b = b[0]+256*b[1]+...+256^31 b[31].
 // B is the Ed25519 base point (x,4/5) with x positive.
 func GeDoubleScalarMultVartime(r *ProjectiveGroupElement, a *[32]byte, A *ExtendedGroupElement, b *[32]byte) {
-	var aSlide, bSlide [256]int8
-	var Ai [8]CachedGroupElement // A,3A,5A,7A,9A,11A,13A,15A
+	var Ai [8]CachedGroupElement
+	GePrecompute(&Ai, A)
+	GeDoubleScalarMultPrecomputedVartime(r, a, &Ai, b)
+}
+
+// GePrecompute sets Ai to the odd multiples A,3A,5A,...,15A of A, so
+// that a fixed A can be multiplied repeatedly with
+// GeDoubleScalarMultPrecomputedVartime.
+func GePrecompute(Ai *[8]CachedGroupElement, A *ExtendedGroupElement) {
 	var t CompletedGroupElement
 	var u, A2 ExtendedGroupElement
-	var i int
-
-	slide(&aSlide, a)
-	slide(&bSlide, b)
 
 	A.ToCached(&Ai[0])
 	A.Double(&t)
@@ -1350,6 +1353,19 @@ func GeDoubleScalarMultVartime(r *ProjectiveGroupElement, a *[32]byte, A *Extend
 		t.ToExtended(&u)
 		u.ToCached(&Ai[i+1])
 	}
+}
+
+// GeDoubleScalarMultPrecomputedVartime sets r = a*A + b*B as
+// GeDoubleScalarMultVartime, where Ai contains the odd multiples of A
+// (see GePrecompute).
+func GeDoubleScalarMultPrecomputedVartime(r *ProjectiveGroupElement, a *[32]byte, Ai *[8]CachedGroupElement, b *[32]byte) {
+	var aSlide, bSlide [256]int8
+	var t CompletedGroupElement
+	var u ExtendedGroupElement
+	var i int
+
+	slide(&aSlide, a)
+	slide(&bSlide, b)
 
 	r.Zero()
 
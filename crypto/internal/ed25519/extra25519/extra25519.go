@@ -374,3 +374,26 @@ func HashToEdwards(out *edwards25519.ExtendedGroupElement, h *[32]byte) {
 		panic("HashToEdwards: point not on curve")
 	}
 }
+
+// Elligator maps the field element r to the x-coordinate of a point on
+// curve25519 (not on its twist) using the Elligator 2 map with the
+// non-square 2, i.e., u = -A/(1+2r^2), or -u-A if u isn't the
+// x-coordinate of a point on curve25519. This is the map used by
+// XEdDSA and VXEdDSA.
+func Elligator(u, r *edwards25519.FieldElement) {
+	var rr2 edwards25519.FieldElement
+	edwards25519.FeCopy(&rr2, r)
+	representativeToMontgomeryX(u, &rr2)
+}
+
+// MontgomeryXToEdwardsY converts the x-coordinate of a curve25519 point
+// into the y-coordinate of the corresponding Edwards point.
+func MontgomeryXToEdwardsY(y, u *edwards25519.FieldElement) {
+	montgomeryXToEdwardsY(y, u)
+}
+
+// EdwardsYToMontgomeryX converts the (affine) y-coordinate of an Edwards
+// point into the x-coordinate of the corresponding curve25519 point.
+func EdwardsYToMontgomeryX(u, y *edwards25519.FieldElement) {
+	edwardsToMontgomeryX(u, y)
+}
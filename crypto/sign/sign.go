@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"io"
 
+	"github.com/coniks-sys/coniks-go/utils/faults"
 	"golang.org/x/crypto/ed25519"
 )
 
@@ -45,8 +46,14 @@ func GenerateKey(rnd io.Reader) (PrivateKey, error) {
 // Sign returns a signature on the passed byte slice message using the
 // underlying private-key.
 // The passed slice won't be modified.
+// If a faults.Signature fault is injected, the returned signature
+// may be corrupted (see package faults).
 func (key PrivateKey) Sign(message []byte) []byte {
-	return ed25519.Sign(ed25519.PrivateKey(key), message)
+	sig := ed25519.Sign(ed25519.PrivateKey(key), message)
+	if faults.Check(faults.Signature) != nil {
+		sig[0] ^= 0xff
+	}
+	return sig
 }
 
 // Public derives the corresponding public-key from the underlying
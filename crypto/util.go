@@ -3,6 +3,10 @@ package crypto
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
 
 	"golang.org/x/crypto/sha3"
 )
@@ -10,22 +14,83 @@ import (
 const (
 	// HashSizeByte is the size of the hash output in bytes.
 	HashSizeByte = 32
+	// MinHashSizeByte is the minimum size of a truncated hash output
+	// in bytes (see DigestSize()). Shorter outputs don't provide
+	// a sufficient collision resistance.
+	MinHashSizeByte = 16
 	// HashID identifies the used hash as a string.
 	HashID = "SHAKE128"
+	// SaltKeySize is the size of the master secret from which
+	// DeriveSalt() derives commitment salts, in bytes.
+	SaltKeySize = 32
+	// SaltPRFID identifies the keyed PRF used by DeriveSalt()
+	// as a string.
+	SaltPRFID = "SHAKE128-PRF"
 )
 
+// ErrUnsupportedHash indicates that a hash identifier names
+// an unknown hash, or an output size which is not a whole number of
+// bytes between MinHashSizeByte and HashSizeByte.
+var ErrUnsupportedHash = errors.New("[crypto] Unsupported hash algorithm or output size")
+
+// saltPRFLabel separates the domain of DeriveSalt() from
+// other uses of Digest().
+var saltPRFLabel = []byte("coniks-commitment-salt")
+
 // Digest hashes all passed byte slices.
 // The passed slices won't be mutated.
 func Digest(ms ...[]byte) []byte {
+	return DigestSize(HashSizeByte, ms...)
+}
+
+// DigestSize is like Digest(), but returns an output of size bytes,
+// which must have been checked with ValidHashSize().
+func DigestSize(size int, ms ...[]byte) []byte {
 	h := sha3.NewShake128()
 	for _, m := range ms {
 		h.Write(m)
 	}
-	ret := make([]byte, HashSizeByte)
+	ret := make([]byte, size)
 	h.Read(ret)
 	return ret
 }
 
+// ValidHashSize returns true if the hash output size in bytes is
+// between MinHashSizeByte and HashSizeByte.
+func ValidHashSize(size int) bool {
+	return size >= MinHashSizeByte && size <= HashSizeByte
+}
+
+// HashIDWithSize returns the identifier of the hash truncated to size
+// bytes, i.e., HashID followed by the output size in bits, or HashID
+// alone if size is HashSizeByte.
+func HashIDWithSize(size int) string {
+	if size == HashSizeByte {
+		return HashID
+	}
+	return HashID + "/" + strconv.Itoa(size*8)
+}
+
+// HashSize returns the output size in bytes of the hash identified by
+// id (see HashIDWithSize()). It returns ErrUnsupportedHash if id doesn't
+// identify the used hash, or if the output size isn't valid
+// (see ValidHashSize()), so that a truncation to less than
+// MinHashSizeByte bytes is never accepted.
+func HashSize(id string) (int, error) {
+	if id == HashID {
+		return HashSizeByte, nil
+	}
+	if !strings.HasPrefix(id, HashID+"/") {
+		return 0, ErrUnsupportedHash
+	}
+	bits, err := strconv.Atoi(strings.TrimPrefix(id, HashID+"/"))
+	if err != nil || bits%8 != 0 || !ValidHashSize(bits/8) ||
+		HashIDWithSize(bits/8) != id {
+		return 0, ErrUnsupportedHash
+	}
+	return bits / 8, nil
+}
+
 // MakeRand returns a random slice of bytes.
 // It returns an error if there was a problem while generating
 // the random slice.
@@ -62,10 +127,30 @@ func NewCommit(stuff ...[]byte) (*Commit, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewCommitWithSalt(salt, stuff...), nil
+}
+
+// NewCommitWithSalt creates a new cryptographic commit to the passed
+// byte slices stuff using the given salt, e.g., a salt derived by
+// DeriveSalt(). The salt must be kept secret to hide the committed
+// values.
+func NewCommitWithSalt(salt []byte, stuff ...[]byte) *Commit {
 	return &Commit{
 		Salt:  salt,
 		Value: Digest(append([][]byte{salt}, stuff...)...),
-	}, nil
+	}
+}
+
+// DeriveSalt derives a commitment salt for the given epoch and index
+// from the master secret key, using SHAKE128 as a keyed PRF.
+// Unlike the salts created by NewCommit(), derived salts can be
+// recomputed from the master secret, which allows to recover and
+// audit the commitments, and don't depend on the system's PRNG
+// at the time of the commitment.
+func DeriveSalt(key []byte, epoch uint64, index []byte) []byte {
+	var ep [8]byte
+	binary.LittleEndian.PutUint64(ep[:], epoch)
+	return Digest(saltPRFLabel, key, ep[:], index)
 }
 
 // Verify verifies that the underlying commit c was a commit to the passed
@@ -52,3 +52,56 @@ func TestCommit(t *testing.T) {
 		t.Fatal("Commit doesn't verify!")
 	}
 }
+
+func TestDeriveSalt(t *testing.T) {
+	key := make([]byte, SaltKeySize)
+	index := []byte("index")
+	salt := DeriveSalt(key, 1, index)
+	if !bytes.Equal(salt, DeriveSalt(key, 1, index)) {
+		t.Fatal("Expect the derived salt to be reproducible")
+	}
+	if bytes.Equal(salt, DeriveSalt(key, 2, index)) ||
+		bytes.Equal(salt, DeriveSalt(key, 1, []byte("other"))) ||
+		bytes.Equal(salt, DeriveSalt([]byte("other key"), 1, index)) {
+		t.Fatal("Expect different salts for different inputs")
+	}
+	stuff := []byte("123")
+	if !NewCommitWithSalt(salt, stuff).Verify(stuff) {
+		t.Fatal("Commit doesn't verify!")
+	}
+}
+
+func TestDigestSize(t *testing.T) {
+	msg := []byte("test message")
+	d := DigestSize(MinHashSizeByte, msg)
+	if len(d) != MinHashSizeByte {
+		t.Fatal("Expect", MinHashSizeByte, "got", len(d))
+	}
+	// a truncated digest is a prefix of the full digest
+	if !bytes.Equal(d, Digest(msg)[:MinHashSizeByte]) {
+		t.Fatal("Expect the truncated digest to be a prefix of the digest")
+	}
+}
+
+func TestHashSize(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		size int
+		err  error
+	}{
+		{HashID, HashSizeByte, nil},
+		{HashIDWithSize(MinHashSizeByte), MinHashSizeByte, nil},
+		{HashIDWithSize(24), 24, nil},
+		{"SHAKE128/64", 0, ErrUnsupportedHash},
+		{"SHAKE128/512", 0, ErrUnsupportedHash},
+		{"SHAKE128/129", 0, ErrUnsupportedHash},
+		{"SHAKE128/0128", 0, ErrUnsupportedHash},
+		{"SHAKE128/256", 0, ErrUnsupportedHash},
+		{"SHA256/128", 0, ErrUnsupportedHash},
+	} {
+		size, err := HashSize(tc.id)
+		if size != tc.size || err != tc.err {
+			t.Error(tc.id, "expect", tc.size, tc.err, "got", size, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package vrf
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// An Algorithm identifies a VRF construction. The directory includes
+// the algorithm of its VRF key in its signed policies, so that clients
+// know how to verify the private indices of the directory's bindings.
+type Algorithm string
+
+// Ed25519SHA3Elligator identifies the VRF construction implemented by
+// this package. The empty Algorithm also identifies this construction,
+// since it precedes the tagging of VRF keys.
+const Ed25519SHA3Elligator Algorithm = "ed25519-sha3-elligator"
+
+var (
+	// ErrUnknownAlgorithm indicates that no VRF construction has been
+	// registered for an Algorithm.
+	ErrUnknownAlgorithm = errors.New("[vrf] Unknown VRF algorithm")
+	// ErrBadPrivateKey indicates that a private key is malformed.
+	ErrBadPrivateKey = errors.New("[vrf] Malformed VRF private key")
+	// ErrBadPublicKey indicates that a public key is malformed.
+	ErrBadPublicKey = errors.New("[vrf] Malformed VRF public key")
+)
+
+// A VRF is the private key of a verifiable random function
+// construction, which computes the VRF output for a message
+// and proves that the output is correct.
+type VRF interface {
+	// Algorithm returns the identifier of the VRF construction.
+	Algorithm() Algorithm
+	// Compute returns the VRF output for m.
+	Compute(m []byte) []byte
+	// Prove returns the VRF output for m, and a proof that the output
+	// is correct under the corresponding public key.
+	Prove(m []byte) (vrf, proof []byte)
+	// PublicKey returns the corresponding public key, and a boolean
+	// indicating if the operation was successful.
+	PublicKey() (Verifier, bool)
+	// Bytes returns the encoding of the private key.
+	Bytes() []byte
+}
+
+// A Verifier is the public key of a verifiable random function
+// construction, which verifies the VRF outputs computed with the
+// corresponding VRF private key.
+type Verifier interface {
+	// Algorithm returns the identifier of the VRF construction.
+	Algorithm() Algorithm
+	// Verify returns true iff vrf is the VRF output for m, as proven
+	// by proof.
+	Verify(m, vrf, proof []byte) bool
+	// Bytes returns the encoding of the public key.
+	Bytes() []byte
+}
+
+var _ VRF = PrivateKey(nil)
+var _ Verifier = PublicKey(nil)
+
+// A Construction provides the functions to generate and decode
+// the keys of a VRF construction.
+type Construction struct {
+	// GenerateKey creates a private key using rnd for randomness.
+	GenerateKey func(rnd io.Reader) (VRF, error)
+	// NewPrivateKey decodes an encoded private key.
+	NewPrivateKey func(sk []byte) (VRF, error)
+	// NewVerifier decodes an encoded public key.
+	NewVerifier func(pk []byte) (Verifier, error)
+}
+
+var (
+	algorithmsLock sync.RWMutex
+	algorithms     = map[Algorithm]*Construction{
+		Ed25519SHA3Elligator: &Construction{
+			GenerateKey: func(rnd io.Reader) (VRF, error) {
+				sk, err := GenerateKey(rnd)
+				if err != nil {
+					return nil, err
+				}
+				return sk, nil
+			},
+			NewPrivateKey: newPrivateKey,
+			NewVerifier:   newPublicKey,
+		},
+		VXEdDSA: &Construction{
+			GenerateKey: func(rnd io.Reader) (VRF, error) {
+				sk, err := GenerateVXEdDSAKey(rnd)
+				if err != nil {
+					return nil, err
+				}
+				return sk, nil
+			},
+			NewPrivateKey: newVXEdDSAPrivateKey,
+			NewVerifier:   newVXEdDSAPublicKey,
+		},
+	}
+)
+
+// RegisterAlgorithm makes the VRF construction alg available to
+// GenerateKeyFor(), NewPrivateKey() and NewVerifier().
+// RegisterAlgorithm() is supposed to be called from the init()
+// function of the package implementing the construction.
+func RegisterAlgorithm(alg Algorithm, c *Construction) {
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+	algorithms[alg] = c
+}
+
+// construction returns the registered VRF construction alg,
+// or ErrUnknownAlgorithm. The empty Algorithm identifies
+// Ed25519SHA3Elligator.
+func construction(alg Algorithm) (*Construction, error) {
+	if alg == "" {
+		alg = Ed25519SHA3Elligator
+	}
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+	c, ok := algorithms[alg]
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+	return c, nil
+}
+
+// Registered returns whether the VRF construction alg has been
+// registered. The empty Algorithm identifies Ed25519SHA3Elligator.
+func Registered(alg Algorithm) bool {
+	_, err := construction(alg)
+	return err == nil
+}
+
+// GenerateKeyFor creates a private key of the VRF construction alg
+// using rnd for randomness. If rnd is nil, crypto/rand is used.
+// It returns ErrUnknownAlgorithm if alg hasn't been registered.
+func GenerateKeyFor(alg Algorithm, rnd io.Reader) (VRF, error) {
+	c, err := construction(alg)
+	if err != nil {
+		return nil, err
+	}
+	return c.GenerateKey(rnd)
+}
+
+// NewPrivateKey decodes the encoded private key sk of the VRF
+// construction alg. It returns ErrUnknownAlgorithm if alg hasn't been
+// registered, or ErrBadPrivateKey if sk is malformed.
+func NewPrivateKey(alg Algorithm, sk []byte) (VRF, error) {
+	c, err := construction(alg)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewPrivateKey(sk)
+}
+
+// NewVerifier decodes the encoded public key pk of the VRF
+// construction alg. It returns ErrUnknownAlgorithm if alg hasn't been
+// registered, or ErrBadPublicKey if pk is malformed.
+func NewVerifier(alg Algorithm, pk []byte) (Verifier, error) {
+	c, err := construction(alg)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewVerifier(pk)
+}
+
+func newPrivateKey(sk []byte) (VRF, error) {
+	if len(sk) != PrivateKeySize {
+		return nil, ErrBadPrivateKey
+	}
+	return PrivateKey(sk), nil
+}
+
+func newPublicKey(pk []byte) (Verifier, error) {
+	if len(pk) != PublicKeySize {
+		return nil, ErrBadPublicKey
+	}
+	return PublicKey(pk), nil
+}
+
+// Algorithm returns Ed25519SHA3Elligator.
+func (sk PrivateKey) Algorithm() Algorithm {
+	return Ed25519SHA3Elligator
+}
+
+// Bytes returns the private key sk as a byte slice.
+func (sk PrivateKey) Bytes() []byte {
+	return sk
+}
+
+// PublicKey returns the public key corresponding to sk as a Verifier.
+func (sk PrivateKey) PublicKey() (Verifier, bool) {
+	pk, ok := sk.Public()
+	return pk, ok
+}
+
+// Algorithm returns Ed25519SHA3Elligator.
+func (pkBytes PublicKey) Algorithm() Algorithm {
+	return Ed25519SHA3Elligator
+}
+
+// Bytes returns the public key pkBytes as a byte slice.
+func (pkBytes PublicKey) Bytes() []byte {
+	return pkBytes
+}
@@ -0,0 +1,124 @@
+package vrf
+
+// This module implements the verification of the VRF outputs of a fixed
+// public key without allocating, e.g., for a client verifying the
+// private indices of a large contact list.
+
+import (
+	"bytes"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/coniks-sys/coniks-go/crypto/internal/ed25519/edwards25519"
+	"github.com/coniks-sys/coniks-go/crypto/internal/ed25519/extra25519"
+)
+
+// A PreparedVerifier verifies the VRF outputs of a fixed public key, as
+// PublicKey.Verify() does, without allocating: the public key is
+// decoded and its multiples are precomputed once, and the buffers of
+// the verification are reused across calls.
+// A PreparedVerifier isn't safe for concurrent use, so that each
+// goroutine verifying VRF outputs needs its own PreparedVerifier.
+type PreparedVerifier struct {
+	pk PublicKey
+	// the odd multiples P,3P,...,15P of the public key
+	pi [8]edwards25519.CachedGroupElement
+
+	// the scratch space of Verify(), which would otherwise escape to
+	// the heap through the hash
+	hash                sha3.ShakeHash
+	s, t, hxB, hB, hmb  [32]byte
+	vrf, ABytes, BBytes [32]byte
+	sH                  [64]byte
+	hm, ii, iic, B      edwards25519.ExtendedGroupElement
+	A, hmtP, iicP       edwards25519.ProjectiveGroupElement
+	zero, sRef          [32]byte
+}
+
+var _ Verifier = (*PreparedVerifier)(nil)
+
+// NewPreparedVerifier returns a PreparedVerifier of the public key pk.
+// It returns ErrBadPublicKey if pk isn't a point of the base group.
+func NewPreparedVerifier(pk PublicKey) (*PreparedVerifier, error) {
+	if len(pk) != PublicKeySize {
+		return nil, ErrBadPublicKey
+	}
+	v := &PreparedVerifier{
+		pk:   append(PublicKey{}, pk...),
+		hash: sha3.NewShake256(),
+	}
+	var pkB [PublicKeySize]byte
+	var P edwards25519.ExtendedGroupElement
+	copy(pkB[:], pk)
+	if !P.FromBytesBaseGroup(&pkB) {
+		return nil, ErrBadPublicKey
+	}
+	edwards25519.GePrecompute(&v.pi, &P)
+	return v, nil
+}
+
+// Algorithm returns Ed25519SHA3Elligator.
+func (v *PreparedVerifier) Algorithm() Algorithm {
+	return Ed25519SHA3Elligator
+}
+
+// Bytes returns the public key of v as a byte slice.
+func (v *PreparedVerifier) Bytes() []byte {
+	return v.pk
+}
+
+// Verify returns true iff vrfBytes=Compute(m) for the sk that
+// corresponds to the public key of v.
+func (v *PreparedVerifier) Verify(m, vrfBytes, proof []byte) bool {
+	if len(proof) != ProofSize || len(vrfBytes) != Size {
+		return false
+	}
+	copy(v.s[:], proof[:32])
+	copy(v.t[:], proof[32:64])
+	copy(v.hxB[:], proof[64:96])
+
+	v.hash.Reset()
+	v.hash.Write(v.hxB[:]) // const length
+	v.hash.Write(m)
+	v.hash.Read(v.vrf[:])
+	if !bytes.Equal(v.vrf[:], vrfBytes) {
+		return false
+	}
+
+	if !v.ii.FromBytesBaseGroup(&v.hxB) {
+		return false
+	}
+	edwards25519.GeDoubleScalarMultPrecomputedVartime(&v.A, &v.s, &v.pi, &v.t)
+	v.A.ToBytes(&v.ABytes)
+
+	// h = H1(m) = f(h(m))^8
+	v.hash.Reset()
+	v.hash.Write(m)
+	v.hash.Read(v.hmb[:])
+	extra25519.HashToEdwards(&v.hm, &v.hmb)
+	edwards25519.GeDouble(&v.hm, &v.hm)
+	edwards25519.GeDouble(&v.hm, &v.hm)
+	edwards25519.GeDouble(&v.hm, &v.hm)
+	v.hm.ToBytes(&v.hB)
+
+	edwards25519.GeDoubleScalarMultVartime(&v.hmtP, &v.t, &v.hm, &v.zero)
+	edwards25519.GeDoubleScalarMultVartime(&v.iicP, &v.s, &v.ii, &v.zero)
+	v.iicP.ToExtended(&v.iic)
+	v.hmtP.ToExtended(&v.B)
+	edwards25519.GeAdd(&v.B, &v.B, &v.iic)
+	v.B.ToBytes(&v.BBytes)
+
+	// sRef = H2(g, h, g^x, v, g^t·G^s,H1(m)^t·v^s, m), with v=H1(m)^x=h^x
+	v.hash.Reset()
+	v.hash.Write(edwards25519.BaseBytes[:])
+	v.hash.Write(v.hB[:])
+	v.hash.Write(v.pk)
+	v.hash.Write(v.hxB[:])
+	v.hash.Write(v.ABytes[:]) // const length (g^t*G^s)
+	v.hash.Write(v.BBytes[:]) // const length (H1(m)^t*v^s)
+	v.hash.Write(m)
+	v.hash.Read(v.sH[:])
+
+	edwards25519.ScReduce(&v.sRef, &v.sH)
+	return v.sRef == v.s
+}
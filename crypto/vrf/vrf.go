@@ -1,24 +1,23 @@
 // Package vrf implements a verifiable random function using the Edwards form
 // of Curve25519, SHA3 and the Elligator map.
 //
-//     E is Curve25519 (in Edwards coordinates), h is SHA3.
-//     f is the elligator map (bytes->E) that covers half of E.
-//     8 is the cofactor of E, the group order is 8*l for prime l.
-//     Setup : the prover publicly commits to a public key (P : E)
-//     H : names -> E
-//         H(n) = f(h(n))^8
-//     VRF : keys -> names -> vrfs
-//         VRF_x(n) = h(n, H(n)^x))
-//     Prove : keys -> names -> proofs
-//         Prove_x(n) = tuple(c=h(n, g^r, H(n)^r), t=r-c*x, ii=H(n)^x)
-//             where r = h(x, n) is used as a source of randomness
-//     Check : E -> names -> vrfs -> proofs -> bool
-//         Check(P, n, vrf, (c,t,ii)) = vrf == h(n, ii)
-//                                     && c == h(n, g^t*P^c, H(n)^t*ii^c)
+//	E is Curve25519 (in Edwards coordinates), h is SHA3.
+//	f is the elligator map (bytes->E) that covers half of E.
+//	8 is the cofactor of E, the group order is 8*l for prime l.
+//	Setup : the prover publicly commits to a public key (P : E)
+//	H : names -> E
+//	    H(n) = f(h(n))^8
+//	VRF : keys -> names -> vrfs
+//	    VRF_x(n) = h(n, H(n)^x))
+//	Prove : keys -> names -> proofs
+//	    Prove_x(n) = tuple(c=h(n, g^r, H(n)^r), t=r-c*x, ii=H(n)^x)
+//	        where r = h(x, n) is used as a source of randomness
+//	Check : E -> names -> vrfs -> proofs -> bool
+//	    Check(P, n, vrf, (c,t,ii)) = vrf == h(n, ii)
+//	                                && c == h(n, g^t*P^c, H(n)^t*ii^c)
 package vrf
 
 import (
-	"bytes"
 	"crypto/rand"
 	"errors"
 	"io"
@@ -172,60 +171,12 @@ func (sk PrivateKey) Prove(m []byte) (vrf, proof []byte) {
 }
 
 // Verify returns true iff vrf=Compute(m) for the sk that
-// corresponds to pk.
+// corresponds to pk. A client verifying many VRF outputs of the same
+// key should use a PreparedVerifier instead, which doesn't allocate.
 func (pkBytes PublicKey) Verify(m, vrfBytes, proof []byte) bool {
-	if len(proof) != ProofSize || len(vrfBytes) != Size || len(pkBytes) != PublicKeySize {
-		return false
-	}
-	var pk, s, sRef, t, vrf, hxB, hB, gB, ABytes, BBytes [32]byte
-	copy(vrf[:], vrfBytes)
-	copy(pk[:], pkBytes[:])
-	copy(s[:32], proof[:32])
-	copy(t[:32], proof[32:64])
-	copy(hxB[:], proof[64:96])
-
-	hash := sha3.NewShake256()
-	hash.Write(hxB[:]) // const length
-	hash.Write(m)
-	var hCheck [Size]byte
-	hash.Read(hCheck[:])
-	if !bytes.Equal(hCheck[:], vrf[:]) {
-		return false
-	}
-	hash.Reset()
-
-	var P, B, ii, iic edwards25519.ExtendedGroupElement
-	var A, hmtP, iicP edwards25519.ProjectiveGroupElement
-	if !P.FromBytesBaseGroup(&pk) {
-		return false
-	}
-	if !ii.FromBytesBaseGroup(&hxB) {
+	v, err := NewPreparedVerifier(pkBytes)
+	if err != nil {
 		return false
 	}
-	edwards25519.GeDoubleScalarMultVartime(&A, &s, &P, &t)
-	A.ToBytes(&ABytes)
-	gB = edwards25519.BaseBytes
-
-	h := hashToCurve(m) // h = H1(m)
-	h.ToBytes(&hB)
-	edwards25519.GeDoubleScalarMultVartime(&hmtP, &t, h, &[32]byte{})
-	edwards25519.GeDoubleScalarMultVartime(&iicP, &s, &ii, &[32]byte{})
-	iicP.ToExtended(&iic)
-	hmtP.ToExtended(&B)
-	edwards25519.GeAdd(&B, &B, &iic)
-	B.ToBytes(&BBytes)
-
-	var sH [64]byte
-	// sRef = H2(g, h, g^x, v, g^t·G^s,H1(m)^t·v^s, m), with v=H1(m)^x=h^x
-	hash.Write(gB[:])
-	hash.Write(hB[:])
-	hash.Write(pkBytes)
-	hash.Write(hxB[:])
-	hash.Write(ABytes[:]) // const length (g^t*G^s)
-	hash.Write(BBytes[:]) // const length (H1(m)^t*v^s)
-	hash.Write(m)
-	hash.Read(sH[:])
-
-	edwards25519.ScReduce(&sRef, &sH)
-	return sRef == s
+	return v.Verify(m, vrfBytes, proof)
 }
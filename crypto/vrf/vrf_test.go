@@ -45,6 +45,72 @@ func TestConvertPrivateKeyToPublicKey(t *testing.T) {
 	}
 }
 
+func TestNewVerifier(t *testing.T) {
+	sk, err := GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := sk.PublicKey()
+	alice := []byte("alice")
+	aliceVRF, aliceProof := sk.Prove(alice)
+
+	for _, alg := range []Algorithm{"", Ed25519SHA3Elligator} {
+		v, err := NewVerifier(alg, pk.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Algorithm() != sk.Algorithm() || !v.Verify(alice, aliceVRF, aliceProof) {
+			t.Error("Expect the decoded public key to verify the proof")
+		}
+	}
+	if _, err := NewVerifier("unknown", pk.Bytes()); err != ErrUnknownAlgorithm {
+		t.Fatal("Expect", ErrUnknownAlgorithm, "got", err)
+	}
+	if _, err := NewVerifier(Ed25519SHA3Elligator, pk.Bytes()[1:]); err != ErrBadPublicKey {
+		t.Fatal("Expect", ErrBadPublicKey, "got", err)
+	}
+}
+
+func TestPreparedVerifier(t *testing.T) {
+	sk, err := GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := sk.Public()
+	v, err := NewPreparedVerifier(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.Bytes(), pk) || v.Algorithm() != sk.Algorithm() {
+		t.Fatal("Expect the prepared verifier of", pk)
+	}
+	alice, bob := []byte("alice"), []byte("bob")
+	aliceVRF, aliceProof := sk.Prove(alice)
+	bobVRF, bobProof := sk.Prove(bob)
+	for i := 0; i < 2; i++ {
+		// the scratch space doesn't leak from one call to the next
+		if !v.Verify(alice, aliceVRF, aliceProof) || !v.Verify(bob, bobVRF, bobProof) {
+			t.Fatal("Expect the proofs to verify")
+		}
+		if v.Verify(alice, bobVRF, bobProof) || v.Verify(bob, bobVRF, aliceProof) {
+			t.Fatal("Expect a proof for another message not to verify")
+		}
+	}
+	if allocs := testing.AllocsPerRun(10, func() {
+		v.Verify(alice, aliceVRF, aliceProof)
+	}); allocs != 0 {
+		t.Fatal("Expect", 0, "allocations, got", allocs)
+	}
+
+	if _, err := NewPreparedVerifier(pk[1:]); err != ErrBadPublicKey {
+		t.Fatal("Expect", ErrBadPublicKey, "got", err)
+	}
+	// a point of small order
+	if _, err := NewPreparedVerifier(make(PublicKey, PublicKeySize)); err != ErrBadPublicKey {
+		t.Fatal("Expect", ErrBadPublicKey, "got", err)
+	}
+}
+
 func TestFlipBitForgery(t *testing.T) {
 	sk, err := GenerateKey(nil)
 	if err != nil {
@@ -165,8 +231,28 @@ func BenchmarkVerify(b *testing.B) {
 	aliceVRF := sk.Compute(alice)
 	_, aliceProof := sk.Prove(alice)
 	pk, _ := sk.Public()
+	b.ReportAllocs()
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 		pk.Verify(alice, aliceVRF, aliceProof)
 	}
 }
+
+func BenchmarkPreparedVerify(b *testing.B) {
+	sk, err := GenerateKey(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	alice := []byte("alice")
+	aliceVRF, aliceProof := sk.Prove(alice)
+	pk, _ := sk.Public()
+	v, err := NewPreparedVerifier(pk)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		v.Verify(alice, aliceVRF, aliceProof)
+	}
+}
@@ -0,0 +1,315 @@
+package vrf
+
+// This module implements the VXEdDSA verifiable random function
+// specified by Signal in "The XEdDSA and VXEdDSA Signature Schemes"
+// (https://signal.org/docs/specifications/xeddsa/), using curve25519
+// keys, SHA-512 and the Elligator 2 map.
+//
+//     hash_i(X) = SHA512(2^256 - 1 - i || X)
+//     calculate_key_pair(k) : A = kB with the sign bit cleared, and a
+//         such that A = aB
+//     Bv = hash_to_point(A || M)
+//     Prove : V = aBv, r = hash_3(a || V || Z), R = rB, Rv = rBv,
+//         h = hash_4(A || V || R || Rv || M), s = r + ha,
+//         proof = (V || h || s), where Z is 64 random bytes
+//     VRF : v = hash_5(cV), truncated to 32 bytes, where c = 8
+//     Verify : R = sB - hA, Rv = sBv - hV,
+//         h == hash_4(A || V || R || Rv || M)
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"io"
+
+	"github.com/coniks-sys/coniks-go/crypto/internal/ed25519/edwards25519"
+	"github.com/coniks-sys/coniks-go/crypto/internal/ed25519/extra25519"
+)
+
+// VXEdDSA identifies the VXEdDSA construction over curve25519.
+const VXEdDSA Algorithm = "vxeddsa-x25519-sha512"
+
+const (
+	VXEdDSAPrivateKeySize = 32
+	VXEdDSAPublicKeySize  = 32
+	VXEdDSAProofSize      = 32 + 32 + 32
+)
+
+// A VXEdDSAPrivateKey is a curve25519 private key.
+type VXEdDSAPrivateKey []byte
+
+// A VXEdDSAPublicKey is a curve25519 public key,
+// i.e., the Montgomery x-coordinate of a point.
+type VXEdDSAPublicKey []byte
+
+var _ VRF = VXEdDSAPrivateKey(nil)
+var _ Verifier = VXEdDSAPublicKey(nil)
+
+// GenerateVXEdDSAKey creates a curve25519 private key using rnd for
+// randomness. If rnd is nil, crypto/rand is used.
+func GenerateVXEdDSAKey(rnd io.Reader) (VXEdDSAPrivateKey, error) {
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	sk := make([]byte, VXEdDSAPrivateKeySize)
+	if _, err := io.ReadFull(rnd, sk); err != nil {
+		return nil, err
+	}
+	sk[0] &= 248
+	sk[31] &= 127
+	sk[31] |= 64
+	return sk, nil
+}
+
+func newVXEdDSAPrivateKey(sk []byte) (VRF, error) {
+	if len(sk) != VXEdDSAPrivateKeySize {
+		return nil, ErrBadPrivateKey
+	}
+	return VXEdDSAPrivateKey(sk), nil
+}
+
+func newVXEdDSAPublicKey(pk []byte) (Verifier, error) {
+	if len(pk) != VXEdDSAPublicKeySize {
+		return nil, ErrBadPublicKey
+	}
+	return VXEdDSAPublicKey(pk), nil
+}
+
+// Algorithm returns VXEdDSA.
+func (sk VXEdDSAPrivateKey) Algorithm() Algorithm {
+	return VXEdDSA
+}
+
+// Bytes returns the private key sk as a byte slice.
+func (sk VXEdDSAPrivateKey) Bytes() []byte {
+	return sk
+}
+
+// PublicKey returns the curve25519 public key corresponding to sk.
+func (sk VXEdDSAPrivateKey) PublicKey() (Verifier, bool) {
+	if len(sk) != VXEdDSAPrivateKeySize {
+		return nil, false
+	}
+	A, _ := sk.calculateKeyPair()
+	var y, u edwards25519.FieldElement
+	edwards25519.FeFromBytes(&y, &A)
+	extra25519.EdwardsYToMontgomeryX(&u, &y)
+	pk := new([VXEdDSAPublicKeySize]byte)
+	edwards25519.FeToBytes(pk, &u)
+	return VXEdDSAPublicKey(pk[:]), true
+}
+
+// calculateKeyPair returns the Edwards public key A corresponding to
+// sk with its sign bit cleared, and the scalar a such that A = aB.
+func (sk VXEdDSAPrivateKey) calculateKeyPair() (A, a [32]byte) {
+	var k [64]byte
+	copy(k[:], sk)
+	edwards25519.ScReduce(&a, &k)
+	var E edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&E, &a)
+	E.ToBytes(&A)
+	if A[31]>>7 == 1 {
+		edwards25519.ScNeg(&a, &a)
+		A[31] &= 127
+	}
+	return
+}
+
+// Compute generates the VRF value for the byte slice m using the
+// private key sk.
+func (sk VXEdDSAPrivateKey) Compute(m []byte) []byte {
+	A, a := sk.calculateKeyPair()
+	var V edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMult(&V, &a, vxeddsaHashToPoint(&A, m))
+	return vxeddsaOutput(&V)
+}
+
+// Prove returns the VRF value and a proof such that
+// Verify(m, vrf, proof) == true. The VRF value is the same as
+// returned by Compute(m), while the proof is randomized.
+func (sk VXEdDSAPrivateKey) Prove(m []byte) (vrf, proof []byte) {
+	var Z [64]byte
+	if _, err := io.ReadFull(rand.Reader, Z[:]); err != nil {
+		panic(err)
+	}
+	return sk.prove(m, &Z)
+}
+
+// prove returns the VRF value for m and its proof, randomized by the
+// 64 bytes Z.
+func (sk VXEdDSAPrivateKey) prove(m []byte, Z *[64]byte) (vrf, proof []byte) {
+	A, a := sk.calculateKeyPair()
+	Bv := vxeddsaHashToPoint(&A, m)
+	var V, R, Rv edwards25519.ExtendedGroupElement
+	var VBytes, RBytes, RvBytes, r, h, s [32]byte
+	edwards25519.GeScalarMult(&V, &a, Bv)
+	V.ToBytes(&VBytes)
+
+	vxeddsaHash(&r, 3, a[:], VBytes[:], Z[:])
+	edwards25519.GeScalarMultBase(&R, &r)
+	edwards25519.GeScalarMult(&Rv, &r, Bv)
+	R.ToBytes(&RBytes)
+	Rv.ToBytes(&RvBytes)
+	vxeddsaHash(&h, 4, A[:], VBytes[:], RBytes[:], RvBytes[:], m)
+	edwards25519.ScMulAdd(&s, &h, &a, &r)
+
+	proof = make([]byte, VXEdDSAProofSize)
+	copy(proof[:32], VBytes[:])
+	copy(proof[32:64], h[:])
+	copy(proof[64:96], s[:])
+	return vxeddsaOutput(&V), proof
+}
+
+// Algorithm returns VXEdDSA.
+func (pk VXEdDSAPublicKey) Algorithm() Algorithm {
+	return VXEdDSA
+}
+
+// Bytes returns the public key pk as a byte slice.
+func (pk VXEdDSAPublicKey) Bytes() []byte {
+	return pk
+}
+
+// Verify returns true iff vrf=Compute(m) for the sk that
+// corresponds to pk, as proven by proof.
+func (pk VXEdDSAPublicKey) Verify(m, vrf, proof []byte) bool {
+	if len(proof) != VXEdDSAProofSize || len(vrf) != Size ||
+		len(pk) != VXEdDSAPublicKeySize {
+		return false
+	}
+	var uBytes, ABytes, VBytes, h, s, minusH [32]byte
+	copy(uBytes[:], pk)
+	copy(VBytes[:], proof[:32])
+	copy(h[:], proof[32:64])
+	copy(s[:], proof[64:96])
+	if !scIsReduced(&h) || !scIsReduced(&s) {
+		return false
+	}
+
+	// convert the curve25519 public key into the Edwards point A
+	// with sign bit 0, rejecting non-canonical encodings
+	var u, y edwards25519.FieldElement
+	edwards25519.FeFromBytes(&u, &uBytes)
+	var uCheck [32]byte
+	edwards25519.FeToBytes(&uCheck, &u)
+	if uCheck != uBytes {
+		return false
+	}
+	extra25519.MontgomeryXToEdwardsY(&y, &u)
+	edwards25519.FeToBytes(&ABytes, &y)
+	var A, V, cA, cV edwards25519.ExtendedGroupElement
+	if !A.FromBytes(&ABytes) || !V.FromBytes(&VBytes) {
+		return false
+	}
+	Bv := vxeddsaHashToPoint(&ABytes, m)
+	mulByCofactor(&cA, &A)
+	mulByCofactor(&cV, &V)
+	if isNeutral(&cA) || isNeutral(&cV) || isNeutral(Bv) {
+		return false
+	}
+
+	// R = sB - hA, Rv = sBv - hV
+	edwards25519.ScNeg(&minusH, &h)
+	var R edwards25519.ProjectiveGroupElement
+	var sBv, hV, Rv edwards25519.ExtendedGroupElement
+	var RBytes, RvBytes, hCheck [32]byte
+	edwards25519.GeDoubleScalarMultVartime(&R, &minusH, &A, &s)
+	R.ToBytes(&RBytes)
+	edwards25519.GeScalarMult(&sBv, &s, Bv)
+	edwards25519.GeScalarMult(&hV, &minusH, &V)
+	edwards25519.GeAdd(&Rv, &sBv, &hV)
+	Rv.ToBytes(&RvBytes)
+
+	vxeddsaHash(&hCheck, 4, ABytes[:], VBytes[:], RBytes[:], RvBytes[:], m)
+	if hCheck != h {
+		return false
+	}
+	return bytes.Equal(vxeddsaOutput(&V), vrf)
+}
+
+// vxeddsaHash sets out = hash_i(parts...) (mod q).
+func vxeddsaHash(out *[32]byte, i byte, parts ...[]byte) {
+	h := sha512.New()
+	h.Write(vxeddsaLabel(i))
+	for _, p := range parts {
+		h.Write(p)
+	}
+	var digest [64]byte
+	h.Sum(digest[:0])
+	edwards25519.ScReduce(out, &digest)
+}
+
+// vxeddsaLabel returns the 32-byte little-endian encoding of
+// 2^256 - 1 - i, which prefixes the input of hash_i.
+func vxeddsaLabel(i byte) []byte {
+	label := bytes.Repeat([]byte{0xFF}, 32)
+	label[0] -= i
+	return label
+}
+
+// vxeddsaHashToPoint maps A || m to a point in the prime-order
+// subgroup: the low 255 bits of hash_2(A || m) are mapped to a
+// curve25519 point using Elligator 2, and its top bit is used as the
+// sign bit of the corresponding Edwards point, which is then
+// multiplied by the cofactor.
+func vxeddsaHashToPoint(A *[32]byte, m []byte) *edwards25519.ExtendedGroupElement {
+	h := sha512.New()
+	h.Write(vxeddsaLabel(2))
+	h.Write(A[:])
+	h.Write(m)
+	digest := h.Sum(nil)
+	var rBytes [32]byte
+	copy(rBytes[:], digest)
+	signBit := rBytes[31] >> 7
+	rBytes[31] &= 127
+
+	var r, u, y edwards25519.FieldElement
+	edwards25519.FeFromBytes(&r, &rBytes)
+	extra25519.Elligator(&u, &r)
+	extra25519.MontgomeryXToEdwardsY(&y, &u)
+	var P edwards25519.ExtendedGroupElement
+	if !P.FromParityAndY(signBit, &y) {
+		panic("[vrf] Elligator returned a point not on the curve")
+	}
+	mulByCofactor(&P, &P)
+	return &P
+}
+
+// vxeddsaOutput returns the VRF value for V, i.e. hash_5(cV)
+// truncated to Size bytes.
+func vxeddsaOutput(V *edwards25519.ExtendedGroupElement) []byte {
+	var cV edwards25519.ExtendedGroupElement
+	var cVBytes [32]byte
+	mulByCofactor(&cV, V)
+	cV.ToBytes(&cVBytes)
+	h := sha512.New()
+	h.Write(vxeddsaLabel(5))
+	h.Write(cVBytes[:])
+	return h.Sum(nil)[:Size]
+}
+
+func mulByCofactor(r, p *edwards25519.ExtendedGroupElement) {
+	edwards25519.GeDouble(r, p)
+	edwards25519.GeDouble(r, r)
+	edwards25519.GeDouble(r, r)
+}
+
+func isNeutral(p *edwards25519.ExtendedGroupElement) bool {
+	var b [32]byte
+	p.ToBytes(&b)
+	return b == [32]byte{1}
+}
+
+// scIsReduced returns whether the scalar s is less than
+// the group order l.
+func scIsReduced(s *[32]byte) bool {
+	for i := 31; i >= 0; i-- {
+		switch {
+		case s[i] < edwards25519.BasePointOrder[i]:
+			return true
+		case s[i] > edwards25519.BasePointOrder[i]:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package vrf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"testing"
+)
+
+func TestVXEdDSAHonestComplete(t *testing.T) {
+	sk, err := GenerateVXEdDSAKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, ok := sk.PublicKey()
+	if !ok {
+		t.Fatal("Couldn't obtain public key.")
+	}
+	for i := 0; i < 32; i++ {
+		m := []byte("alice" + strconv.Itoa(i))
+		vrf, proof := sk.Prove(m)
+		if !pk.Verify(m, vrf, proof) {
+			t.Fatal("Gen -> Prove -> Verify -> FALSE")
+		}
+		if !bytes.Equal(vrf, sk.Compute(m)) {
+			t.Fatal("Compute != Prove")
+		}
+		// the proof is randomized, but the VRF value isn't
+		vrf2, proof2 := sk.Prove(m)
+		if !bytes.Equal(vrf, vrf2) || bytes.Equal(proof, proof2) {
+			t.Fatal("Expect the same VRF value with a different proof")
+		}
+	}
+}
+
+func TestVXEdDSAFlipBitForgery(t *testing.T) {
+	sk, err := GenerateVXEdDSAKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := sk.PublicKey()
+	alice := []byte("alice")
+	vrf, proof := sk.Prove(alice)
+	for i := 0; i < 8*len(proof); i++ {
+		forged := append([]byte{}, proof...)
+		forged[i/8] ^= 1 << uint(i%8)
+		if pk.Verify(alice, vrf, forged) {
+			t.Fatalf("Flipping bit %d of the proof still verifies", i)
+		}
+	}
+	for i := 0; i < 8*len(vrf); i++ {
+		forged := append([]byte{}, vrf...)
+		forged[i/8] ^= 1 << uint(i%8)
+		if pk.Verify(alice, forged, proof) {
+			t.Fatalf("Flipping bit %d of the VRF value still verifies", i)
+		}
+	}
+	if pk.Verify([]byte("bob"), vrf, proof) {
+		t.Fatal("Expect the proof to be bound to the message")
+	}
+
+	other, err := GenerateVXEdDSAKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPk, _ := other.PublicKey()
+	if otherPk.Verify(alice, vrf, proof) {
+		t.Fatal("Expect the proof to be bound to the key")
+	}
+}
+
+func TestVXEdDSASerialization(t *testing.T) {
+	sk, err := GenerateKeyFor(VXEdDSA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sk.Algorithm() != VXEdDSA || len(sk.Bytes()) != VXEdDSAPrivateKeySize {
+		t.Fatal("Expect a", VXEdDSA, "private key")
+	}
+	decoded, err := NewPrivateKey(VXEdDSA, sk.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := sk.PublicKey()
+	decodedPk, err := NewVerifier(VXEdDSA, pk.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := []byte("alice")
+	vrf, proof := decoded.Prove(alice)
+	if !decodedPk.Verify(alice, vrf, proof) {
+		t.Fatal("Expect the decoded keys to be usable")
+	}
+
+	if _, err := NewPrivateKey(VXEdDSA, sk.Bytes()[1:]); err != ErrBadPrivateKey {
+		t.Fatal("Expect", ErrBadPrivateKey, "got", err)
+	}
+	if _, err := NewVerifier(VXEdDSA, pk.Bytes()[1:]); err != ErrBadPublicKey {
+		t.Fatal("Expect", ErrBadPublicKey, "got", err)
+	}
+	// keys of different constructions aren't interchangeable
+	edPk, err := NewVerifier(Ed25519SHA3Elligator, pk.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edPk.Verify(alice, vrf, proof) {
+		t.Fatal("Expect a VXEdDSA proof not to verify under another construction")
+	}
+}
+
+// The private and public keys are the X25519 keys of RFC 7748,
+// section 6.1, whose private keys are clamped as GenerateVXEdDSAKey()
+// does. The VRF values and proofs are randomized with 64 zero bytes.
+// FIXME: cross-check the VRF values and proofs against the test
+// vectors of Signal's reference implementation.
+var vxeddsaTestVectors = []struct {
+	sk, pk, m, vrf, proof string
+}{
+	{
+		sk:    "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a",
+		pk:    "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+		m:     "",
+		vrf:   "8bbfd4904c333beb1b2f463d4887ee9c4e3e8c213d397da214f6aeb8857f5fa0",
+		proof: "633bf51d00e8cd9ad21030f9935b2e48cb81b011bbc12435dfcd3c4c428608a4795284d58168ed9d39d6609756cc877b3128cf34778bd512041c5fd9841b630791eb084d9a96202eddaab3ef2482a53af98f922955bd8aeda010a8eea335810d",
+	},
+	{
+		sk:    "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a",
+		pk:    "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a",
+		m:     "alice",
+		vrf:   "6da62081ebe69191248e80aa8a468155e1fc03cc4ce472e7163469a23824bb12",
+		proof: "3b235aaab6850eb87bac173299ac947746bc79cce53ff762cd9e99fe422d10a0937e751197dcc9f479b51f33613474b14c44684923081dd564038061e02d0c05525f1d5cc2d481f2a332cc881422a7a68116f232ca9ea1c2e34abb35741e3205",
+	},
+	{
+		sk:    "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb",
+		pk:    "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f",
+		m:     "",
+		vrf:   "d70c67d6a57cf916d0e4d9f635ea9bad80cdf168e25d49b086bf5a4d7efb9b43",
+		proof: "3eaca846b38cc5997a96a9cc4abc64cba12b1250f749e5c6254a501b20544341c277be2b6f21c40527a99e999456e8627e89a6441153fa024cb15454ca58cc0185ed3f5c46db902f2c10de633c2b79b46e8a9d35a7559455fa6d50be8270b302",
+	},
+	{
+		sk:    "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb",
+		pk:    "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f",
+		m:     "alice",
+		vrf:   "904508934efe7f77a3542568ef168bf70fbcbaa7e0355a0f5b186070aadd7753",
+		proof: "08e723dbad554d3126fadc6fd3507f4b234dfdbb65ae9d733c861b44c4dc9119c8e8e46b11cee02ec07e3e715aa2d281060f27cebf399bd06d71946a5010840b5409063e9b4c829e6fdc16ddc32a2bef8220a286efe87cbb59ab352668788500",
+	},
+}
+
+func TestVXEdDSAKnownAnswers(t *testing.T) {
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for i, v := range vxeddsaTestVectors {
+		sk := VXEdDSAPrivateKey(unhex(v.sk))
+		sk[0] &= 248
+		sk[31] &= 127
+		sk[31] |= 64
+		pk, _ := sk.PublicKey()
+		if !bytes.Equal(pk.Bytes(), unhex(v.pk)) {
+			t.Fatalf("Vector %d: expect the public key %s, got %x", i, v.pk, pk.Bytes())
+		}
+		m := []byte(v.m)
+		var Z [64]byte
+		vrf, proof := sk.prove(m, &Z)
+		if !bytes.Equal(vrf, unhex(v.vrf)) || !bytes.Equal(sk.Compute(m), vrf) {
+			t.Fatalf("Vector %d: expect the VRF value %s, got %x", i, v.vrf, vrf)
+		}
+		if !bytes.Equal(proof, unhex(v.proof)) {
+			t.Fatalf("Vector %d: expect the proof %s, got %x", i, v.proof, proof)
+		}
+		if !pk.Verify(m, vrf, proof) {
+			t.Fatalf("Vector %d: expect the proof to verify", i)
+		}
+	}
+}
+
+func BenchmarkVXEdDSAProve(b *testing.B) {
+	sk, err := GenerateVXEdDSAKey(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	alice := []byte("alice")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		sk.Prove(alice)
+	}
+}
+
+func BenchmarkVXEdDSAVerify(b *testing.B) {
+	sk, err := GenerateVXEdDSAKey(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pk, _ := sk.PublicKey()
+	alice := []byte("alice")
+	vrf, proof := sk.Prove(alice)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		pk.Verify(alice, vrf, proof)
+	}
+}
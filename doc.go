@@ -0,0 +1,29 @@
+/*
+Package coniks is the entry point for applications which embed a CONIKS
+directory.
+
+An EmbeddedDirectory runs a CONIKS directory in-process, without a
+network: the host application passes it the same requests a CONIKS key
+server receives, decides itself when the directory's epochs end, and
+optionally persists the directory's snapshots to a key-value database.
+This is useful for tests, single-binary applications and research
+prototypes. For example:
+
+	dir, err := coniks.NewEmbeddedDirectory(coniks.Options{
+		EpochDeadline: 60,
+		OnUpdate: func(str *protocol.DirSTR) {
+			log.Println("new epoch", str.Epoch)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	msg, _ := client.CreateRegistrationMsg("alice", key)
+	res, _ := dir.HandleMessage(msg)
+	// ... later, e.g., from the host's scheduler
+	dir.Update()
+
+The other packages of this library implement the components of the
+CONIKS system, and may be imported individually (see README.md).
+*/
+package coniks
@@ -0,0 +1,209 @@
+// This module implements a CONIKS directory which runs in the process
+// of a host application, instead of behind a key server's listeners.
+
+package coniks
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/application"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// DefaultHistoryLength is the number of snapshots an EmbeddedDirectory
+// keeps in memory if Options.HistoryLength isn't set.
+const DefaultHistoryLength = 1000
+
+var (
+	// ErrMissingKeys indicates that an EmbeddedDirectory persisted to
+	// a database has been created without its VRF and signing keys.
+	// A persisted directory can only be restored with the keys it has
+	// been created with, so it doesn't generate its own keys.
+	ErrMissingKeys = errors.New("[coniks] Persisted directory requires its keys")
+)
+
+// Options configures an EmbeddedDirectory.
+//
+// EpochDeadline is the epoch deadline the directory includes in its
+// STRs. The directory doesn't update itself when the deadline passes;
+// the host application calls EmbeddedDirectory.Update() instead,
+// e.g., from its own scheduler (see EmbeddedDirectory.NextEpoch()).
+//
+// VRFKey and SignKey are the directory's private keys. If both are
+// nil and DB is nil, the directory generates fresh keys, which is
+// convenient for tests and prototypes.
+//
+// HistoryLength is the number of snapshots the directory keeps in
+// memory, and defaults to DefaultHistoryLength.
+//
+// DB optionally persists the directory's snapshots. If DB contains a
+// checkpoint, the directory is restored from it, otherwise the new
+// directory writes its first checkpoint to DB. A new checkpoint is
+// written every CheckpointInterval epochs (see directory.Restore()).
+//
+// OnUpdate, if set, is called with the new STR after each update,
+// e.g., to publish the STR or to save it for the clients. Clock, if
+// set, replaces the clock the directory uses to compute its next
+// epoch.
+type Options struct {
+	EpochDeadline      protocol.Timestamp
+	VRFKey             vrf.VRF
+	SignKey            sign.PrivateKey
+	HistoryLength      uint64
+	DB                 kv.DB
+	CheckpointInterval uint64
+	OnUpdate           func(str *protocol.DirSTR)
+	Clock              utils.Clock
+}
+
+// An EmbeddedDirectory is a CONIKS directory which a host application
+// runs in-process, without a network. It handles the same requests as
+// a CONIKS key server, either as protocol.Request values
+// (see Handle()) or as their JSON encoding (see HandleMessage()), so
+// that the clients and auditors built on this library can talk to it
+// directly.
+//
+// All methods of an EmbeddedDirectory are safe for concurrent use.
+type EmbeddedDirectory struct {
+	sync.RWMutex
+	dir      *directory.ConiksDirectory
+	onUpdate func(str *protocol.DirSTR)
+}
+
+// NewEmbeddedDirectory creates an EmbeddedDirectory configured with
+// opts, or restores it from opts.DB.
+// It returns ErrMissingKeys if opts.DB is set but either key isn't,
+// and any error returned by the database otherwise.
+func NewEmbeddedDirectory(opts Options) (*EmbeddedDirectory, error) {
+	if opts.HistoryLength == 0 {
+		opts.HistoryLength = DefaultHistoryLength
+	}
+	if opts.VRFKey == nil && opts.SignKey == nil && opts.DB == nil {
+		var err error
+		if opts.VRFKey, err = vrf.GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+		if opts.SignKey, err = sign.GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+	if opts.VRFKey == nil || opts.SignKey == nil {
+		return nil, ErrMissingKeys
+	}
+
+	var dir *directory.ConiksDirectory
+	if opts.DB != nil {
+		var err error
+		dir, err = directory.Restore(opts.DB, opts.CheckpointInterval,
+			opts.EpochDeadline, opts.VRFKey, opts.SignKey,
+			opts.HistoryLength, true)
+		if err != nil && err != merkletree.ErrNoCheckpoint {
+			return nil, err
+		}
+	}
+	if dir == nil {
+		dir = directory.New(opts.EpochDeadline, opts.VRFKey, opts.SignKey,
+			opts.HistoryLength, true)
+		if opts.DB != nil {
+			if err := dir.Persist(opts.DB, opts.CheckpointInterval); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.Clock != nil {
+		dir.SetClock(opts.Clock)
+	}
+	return &EmbeddedDirectory{
+		dir:      dir,
+		onUpdate: opts.OnUpdate,
+	}, nil
+}
+
+// Handle passes the request req to the appropriate operation handler
+// of the directory according to the request type (see
+// directory.ConiksDirectory.Handle()), and returns the directory's
+// response. Requests which don't modify the directory are
+// handled concurrently.
+func (e *EmbeddedDirectory) Handle(req *protocol.Request) *protocol.Response {
+	if protocol.ReadOnly(req.Type) {
+		e.RLock()
+		defer e.RUnlock()
+	} else {
+		e.Lock()
+		defer e.Unlock()
+	}
+
+	return e.dir.Handle(req)
+}
+
+// HandleMessage decodes the JSON-encoded request msg, e.g., created
+// by one of the application/client Create*Msg() functions, handles it
+// (see Handle()) and returns the JSON encoding of the response.
+// A malformed msg results in an encoded
+// protocol.NewErrorResponse(protocol.ErrMalformedMessage), as it would
+// for a key server.
+func (e *EmbeddedDirectory) HandleMessage(msg []byte) ([]byte, error) {
+	req, err := application.UnmarshalRequest(msg)
+	if err != nil {
+		return application.MarshalResponse(
+			protocol.NewErrorResponse(protocol.ErrMalformedMessage))
+	}
+	return application.MarshalResponse(e.Handle(req))
+}
+
+// Update ends the current epoch of the directory (see
+// directory.ConiksDirectory.Update()), and calls the OnUpdate hook
+// with the new STR, if one is set. If the directory is persisted, the
+// new snapshot has been written to the database when Update() returns.
+// If the database fails, Update() returns its error instead, and the
+// directory keeps serving the STR of the previous epoch; the host
+// application may retry the update.
+func (e *EmbeddedDirectory) Update() (*protocol.DirSTR, error) {
+	e.Lock()
+	err := e.dir.Update()
+	str := e.dir.LatestSTR()
+	e.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if e.onUpdate != nil {
+		e.onUpdate(str)
+	}
+	return str, nil
+}
+
+// NextEpoch returns the time by which the host application is
+// expected to call Update(), according to the directory's epoch
+// deadline.
+func (e *EmbeddedDirectory) NextEpoch() time.Time {
+	e.RLock()
+	defer e.RUnlock()
+	return e.dir.NextEpoch()
+}
+
+// LatestSTR returns the directory's latest STR, e.g., to initialize
+// a client (see protocol/client.New()) or an auditor.
+func (e *EmbeddedDirectory) LatestSTR() *protocol.DirSTR {
+	e.RLock()
+	defer e.RUnlock()
+	return e.dir.LatestSTR()
+}
+
+// Configure calls f with the underlying directory while no request or
+// update is being handled, so that the host application can change
+// the directory's settings, e.g., its limits or its policy document
+// (see directory.ConiksDirectory).
+func (e *EmbeddedDirectory) Configure(f func(dir *directory.ConiksDirectory)) {
+	e.Lock()
+	defer e.Unlock()
+	f(e.dir)
+}
@@ -0,0 +1,211 @@
+package coniks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/application"
+	clientapp "github.com/coniks-sys/coniks-go/application/client"
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/client"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+var (
+	alice = "alice"
+	key   = []byte("key")
+)
+
+func staticOptions() Options {
+	return Options{
+		EpochDeadline: 1,
+		VRFKey:        crypto.NewStaticTestVRFKey(),
+		SignKey:       crypto.NewStaticTestSigningKey(),
+	}
+}
+
+func TestEmbeddedDirectory(t *testing.T) {
+	var published []uint64
+	opts := staticOptions()
+	opts.OnUpdate = func(str *protocol.DirSTR) {
+		published = append(published, str.Epoch)
+	}
+	dir, err := NewEmbeddedDirectory(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir.Update()
+	// initialize the client with the encoded STR, as a remote client would
+	msg, _ := clientapp.CreateSTRHistoryMsg(1, 1)
+	buf, err := dir.HandleMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := application.UnmarshalResponse(protocol.STRType, buf)
+	pk, _ := opts.SignKey.Public()
+	cc := client.New(res.STRHistoryRange().STR[0], true, pk)
+
+	msg, _ = clientapp.CreateRegistrationMsg(alice, key)
+	if buf, err = dir.HandleMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	res = application.UnmarshalResponse(protocol.RegistrationType, buf)
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	dir.Update()
+	req := &protocol.Request{
+		Type:    protocol.KeyLookupType,
+		Request: &protocol.KeyLookupRequest{Username: alice},
+	}
+	if err := cc.HandleResponse(protocol.KeyLookupType, dir.Handle(req), alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 || published[1] != dir.LatestSTR().Epoch {
+		t.Fatal("Expect", []uint64{1, 2}, "got", published)
+	}
+
+	buf, err = dir.HandleMessage([]byte("malformed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := application.UnmarshalResponse(protocol.KeyLookupType, buf); res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestEmbeddedDirectoryGeneratesKeys(t *testing.T) {
+	dir, err := NewEmbeddedDirectory(Options{EpochDeadline: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &protocol.Request{
+		Type:    protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{Username: alice, Key: key},
+	}
+	if res := dir.Handle(req); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+}
+
+func TestEmbeddedDirectoryRestore(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		if _, err := NewEmbeddedDirectory(Options{DB: db}); err != ErrMissingKeys {
+			t.Fatal("Expect", ErrMissingKeys, "got", err)
+		}
+
+		opts := staticOptions()
+		opts.DB = db
+		dir, err := NewEmbeddedDirectory(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &protocol.Request{
+			Type:    protocol.RegistrationType,
+			Request: &protocol.RegistrationRequest{Username: alice, Key: key},
+		}
+		dir.Handle(req)
+		str, err := dir.Update()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := NewEmbeddedDirectory(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.LatestSTR(); got.Epoch != str.Epoch ||
+			string(got.Signature) != string(str.Signature) {
+			t.Fatal("Expect the restored directory's latest STR to be", str.Epoch)
+		}
+		lookup := &protocol.Request{
+			Type:    protocol.KeyLookupType,
+			Request: &protocol.KeyLookupRequest{Username: alice},
+		}
+		if res := restored.Handle(lookup); res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+	})
+}
+
+// verifyProofs checks that each authentication path of the response res
+// for the names verifies against the STR res includes for its epoch.
+func verifyProofs(res *protocol.Response, names []string) error {
+	if err := res.Validate(); err != nil && err != protocol.ReqNameNotFound {
+		return err
+	}
+	switch df := res.DirectoryResponse.(type) {
+	case *protocol.DirectoryProof:
+		for i, ap := range df.AP {
+			if err := client.VerifyAuthPath(names[0], nil, ap, df.STR[i]); err != nil {
+				return err
+			}
+		}
+	case *protocol.BatchKeyLookupProof:
+		for i, ap := range df.AP {
+			if err := client.VerifyAuthPath(names[i], nil, ap, df.STR); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestEmbeddedDirectoryLookupsDuringUpdate(t *testing.T) {
+	dir, err := NewEmbeddedDirectory(staticOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	register := func(name string) {
+		dir.Handle(&protocol.Request{
+			Type:    protocol.RegistrationType,
+			Request: &protocol.RegistrationRequest{Username: name, Key: []byte(name)},
+		})
+	}
+	names := []string{alice, "bob", "carol"}
+	for _, name := range names {
+		register(name)
+	}
+	dir.Update()
+
+	requests := []*protocol.Request{
+		{Type: protocol.KeyLookupType, Request: &protocol.KeyLookupRequest{Username: alice}},
+		{Type: protocol.BatchKeyLookupType, Request: &protocol.BatchKeyLookupRequest{Usernames: names}},
+		{Type: protocol.MonitoringType, Request: &protocol.MonitoringRequest{
+			Username: alice, StartEpoch: 1, EndEpoch: 100}},
+	}
+	stop := make(chan struct{})
+	errs := make(chan error, len(requests))
+	for _, req := range requests {
+		go func(req *protocol.Request) {
+			for {
+				select {
+				case <-stop:
+					errs <- nil
+					return
+				default:
+				}
+				if err := verifyProofs(dir.Handle(req), names); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(req)
+	}
+	// each update changes the tree the lookups are served from
+	for i := 0; i < 50; i++ {
+		register(fmt.Sprintf("user%d", i))
+		if _, err := dir.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	for range requests {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+// This module implements the persistence of a PAD, which allows a key
+// server to restore its PAD quickly upon a restart. The PAD
+// periodically writes a checkpoint, i.e., a serialized copy of its tree
+// and latest STR, to a key-value database. All changes made to the PAD
+// after the latest checkpoint are appended to a write-ahead log (WAL).
+// Restoring the PAD then only requires rebuilding the tree from the
+// latest checkpoint and replaying the WAL's tail, instead of replaying
+// the entire registration history. Every STR the PAD issues is also
+// kept in the database, apart from the checkpoints and the WAL, so that
+// the PAD's STR history outlives both the in-memory snapshots and
+// restarts (see PAD.LoadSTR()). The latest signing key rotation is
+// recorded as well, along with the private keys involved, so that the
+// database must be kept as secret as the PAD's signing key (see
+// PAD.RotateSigningKey()).
+
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+)
+
+var (
+	// ErrNoCheckpoint indicates that the database doesn't contain
+	// a checkpoint from which a PAD can be restored.
+	ErrNoCheckpoint = errors.New("[merkletree] No checkpoint found")
+	// ErrBadCheckpoint indicates that the tree restored from the
+	// checkpoint and the WAL is inconsistent with the persisted STRs.
+	ErrBadCheckpoint = errors.New("[merkletree] Checkpoint is inconsistent with the latest STR")
+)
+
+var (
+	checkpointKey = []byte("checkpoint")
+	walPrefix     = []byte("wal")
+	strPrefix     = []byte("str")
+	rotationKey   = []byte("rotation")
+)
+
+// persistedLeaf is the serialized form of a user leaf node.
+// It includes the leaf's commitment so that restoring the leaf
+// results in the same leaf hash, and the epoch in which the leaf
+// is first included, from which its commitment salt may be derived.
+type persistedLeaf struct {
+	Key        string
+	Value      []byte
+	Index      []byte
+	Commitment *crypto.Commit
+	Epoch      uint64 `json:",omitempty"`
+}
+
+// newPersistedLeaf creates a new leaf for the index-to-value binding
+// to be included in epoch, and commits to the key and value.
+// The commitment's salt is derived from saltKey (see crypto.DeriveSalt())
+// if saltKey isn't nil, and is random otherwise.
+func newPersistedLeaf(index []byte, key string, value []byte,
+	epoch uint64, saltKey []byte) (*persistedLeaf, error) {
+	var commitment *crypto.Commit
+	if saltKey != nil {
+		commitment = crypto.NewCommitWithSalt(
+			crypto.DeriveSalt(saltKey, epoch, index), []byte(key), value)
+	} else {
+		var err error
+		commitment, err = crypto.NewCommit([]byte(key), value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &persistedLeaf{
+		Key:        key,
+		Value:      append([]byte{}, value...), // make a copy of value
+		Index:      index,
+		Commitment: commitment,
+		Epoch:      epoch,
+	}, nil
+}
+
+// setLeaf inserts or updates the leaf in the tree m.
+func (m *MerkleTree) setLeaf(leaf *persistedLeaf) {
+	m.insertNode(leaf.Index, &userLeafNode{
+		key:        leaf.Key,
+		value:      leaf.Value,
+		index:      leaf.Index,
+		commitment: leaf.Commitment,
+		epoch:      leaf.Epoch,
+	})
+}
+
+// persistedSTR is the serialized form of a signed tree root.
+// AssocData contains the STR's associated data, encoded by the
+// encodeAd function passed to PAD.Persist(). HashID identifies the
+// hasher of the STR's tree unless it is the default hasher, possibly
+// truncated (see PAD.SetHasher()).
+type persistedSTR struct {
+	*SignedTreeRoot
+	AssocData []byte
+	HashID    string `json:",omitempty"`
+}
+
+// A walEntry is either a leaf set in the pending tree,
+// or an STR issued by the PAD.
+type walEntry struct {
+	Leaf *persistedLeaf `json:",omitempty"`
+	STR  *persistedSTR  `json:",omitempty"`
+}
+
+type checkpoint struct {
+	Nonce  []byte
+	Leaves []*persistedLeaf
+	STR    *persistedSTR
+}
+
+// rotation records a signing key rotation requested at Epoch, i.e.,
+// when the STR of Epoch was the latest STR: the STR of Epoch+2 is
+// signed with SignKey and cross-signed with NextSignKey, which signs
+// all the following STRs.
+type rotation struct {
+	Epoch       uint64
+	SignKey     sign.PrivateKey
+	NextSignKey sign.PrivateKey
+}
+
+// A padStore writes a PAD's checkpoints and WAL entries to db.
+type padStore struct {
+	db       kv.DB
+	interval uint64
+	seq      uint64
+	encodeAd func(AssocData) ([]byte, error)
+	decodeAd func([]byte) (AssocData, error)
+}
+
+// Persist enables the persistence of the PAD to db, and immediately
+// writes a checkpoint of the PAD.
+// From now on, every change to the PAD is appended to the WAL,
+// and a new checkpoint replaces the WAL every interval epochs.
+// encodeAd is used to serialize the STRs' associated data, which is
+// decoded again by decodeAd when an STR is loaded (see LoadSTR()),
+// and by the decodeAd function passed to RestorePAD().
+func (pad *PAD) Persist(db kv.DB, interval uint64,
+	encodeAd func(AssocData) ([]byte, error),
+	decodeAd func([]byte) (AssocData, error)) error {
+	if interval == 0 {
+		interval = 1
+	}
+	pad.store = &padStore{
+		db:       db,
+		interval: interval,
+		encodeAd: encodeAd,
+		decodeAd: decodeAd,
+	}
+	return pad.checkpoint(pad.latestSTR)
+}
+
+// checkpoint writes the PAD's tree and the STR latest to the database,
+// along with latest's entry in the STR history, and drops the WAL.
+// Since checkpoint is called right after issuing latest, the tree's
+// hash equals the tree hash of latest.
+func (pad *PAD) checkpoint(latest *SignedTreeRoot) error {
+	str, err := pad.store.serializeSTR(latest)
+	if err != nil {
+		return err
+	}
+	cp := &checkpoint{
+		Nonce: pad.tree.nonce,
+		STR:   str,
+	}
+	pad.tree.visitLeafNodes(func(n *userLeafNode) {
+		cp.Leaves = append(cp.Leaves, &persistedLeaf{
+			Key:        n.key,
+			Value:      n.value,
+			Index:      n.index,
+			Commitment: n.commitment,
+			Epoch:      n.epoch,
+		})
+	})
+	buf, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	strBuf, err := json.Marshal(str)
+	if err != nil {
+		return err
+	}
+
+	// replace the WAL and the previous checkpoint atomically
+	b := pad.store.db.NewBatch()
+	iter := pad.store.db.NewIterator(kv.BytesPrefix(walPrefix))
+	for ok := iter.First(); ok; ok = iter.Next() {
+		b.Delete(append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	b.Put(checkpointKey, buf)
+	b.Put(strKey(latest.Epoch), strBuf)
+	if err := pad.store.db.Write(b); err != nil {
+		return err
+	}
+	pad.store.seq = 0
+	return nil
+}
+
+// logLeaf appends the leaf to the WAL along with the pending writes
+// of b, if b isn't nil.
+func (s *padStore) logLeaf(leaf *persistedLeaf, b kv.Batch) error {
+	if b == nil {
+		b = s.db.NewBatch()
+	}
+	return s.append(&walEntry{Leaf: leaf}, b)
+}
+
+// logSTR appends the STR str to the WAL, and adds str to the
+// STR history in the same write.
+func (s *padStore) logSTR(str *SignedTreeRoot) error {
+	pstr, err := s.serializeSTR(str)
+	if err != nil {
+		return err
+	}
+	strBuf, err := json.Marshal(pstr)
+	if err != nil {
+		return err
+	}
+	b := s.db.NewBatch()
+	b.Put(strKey(str.Epoch), strBuf)
+	return s.append(&walEntry{STR: pstr}, b)
+}
+
+// logRotation records the rotation r, replacing the previous one.
+func (s *padStore) logRotation(r *rotation) error {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(rotationKey, buf)
+}
+
+// append writes entry to the WAL along with the pending writes of b,
+// atomically.
+func (s *padStore) append(entry *walEntry, b kv.Batch) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b.Put(walKey(s.seq), buf)
+	if err := s.db.Write(b); err != nil {
+		return err
+	}
+	s.seq++
+	return nil
+}
+
+// loadSTR reads the STR of epoch from the STR history. The returned
+// STR has no snapshot of the tree.
+func (s *padStore) loadSTR(epoch uint64) (*SignedTreeRoot, error) {
+	buf, err := s.db.Get(strKey(epoch))
+	if err == s.db.ErrNotFound() {
+		return nil, ErrSTRNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var pstr persistedSTR
+	if err := json.Unmarshal(buf, &pstr); err != nil || pstr.SignedTreeRoot == nil {
+		return nil, ErrBadCheckpoint
+	}
+	ad, err := s.decodeAd(pstr.AssocData)
+	if err != nil {
+		return nil, ErrBadCheckpoint
+	}
+	str := pstr.SignedTreeRoot
+	str.Ad = ad
+	return str, nil
+}
+
+func (s *padStore) serializeSTR(str *SignedTreeRoot) (*persistedSTR, error) {
+	ad, err := s.encodeAd(str.Ad)
+	if err != nil {
+		return nil, err
+	}
+	pstr := &persistedSTR{SignedTreeRoot: str, AssocData: ad}
+	if str.tree != nil && !isDefaultHasher(str.tree.hasher) {
+		pstr.HashID = str.tree.hasher.ID()
+	}
+	return pstr, nil
+}
+
+// isDefaultHasher returns whether h is the default hasher, possibly
+// truncated, whose identifier restoreSTR() infers from the size of the
+// tree hash.
+func isDefaultHasher(h hashers.PADHasher) bool {
+	d, err := hashers.WithSize(hashers.Default(), h.Size())
+	return err == nil && d.ID() == h.ID()
+}
+
+// walKey returns the database key of the WAL entry seq.
+// The sequence number is encoded in big endian format so that
+// iterating over the WAL returns the entries in order.
+func walKey(seq uint64) []byte {
+	key := make([]byte, len(walPrefix)+8)
+	copy(key, walPrefix)
+	binary.BigEndian.PutUint64(key[len(walPrefix):], seq)
+	return key
+}
+
+// strKey returns the database key of the STR of epoch
+// in the STR history.
+func strKey(epoch uint64) []byte {
+	key := make([]byte, len(strPrefix)+8)
+	copy(key, strPrefix)
+	binary.BigEndian.PutUint64(key[len(strPrefix):], epoch)
+	return key
+}
+
+// RestorePAD reconstructs a PAD from the latest checkpoint and the
+// WAL persisted in db, and keeps persisting the PAD's changes to db
+// afterwards, as configured by interval and encodeAd
+// (see PAD.Persist()).
+// ad is the associated data the restored PAD uses to issue its next
+// STR, and decodeAd decodes the associated data of the persisted STRs.
+// The remaining parameters are the same as for NewPAD().
+//
+// RestorePAD() verifies the integrity of the restored PAD: each leaf
+// must commit to its key and value, and its index must be the key's
+// private index, the hash of the rebuilt tree must equal the tree hash
+// of each persisted STR at the time it was issued, the STRs must form a
+// valid hash chain, and the latest STR's signature must be valid under
+// signKey. Otherwise, RestorePAD() returns ErrBadCheckpoint.
+// If db records a signing key rotation (see PAD.RotateSigningKey()),
+// the restored PAD resumes the handover instead: the keys of the
+// rotation replace signKey, and the latest STR must be signed, and
+// cross-signed if it hands over to the new key, accordingly.
+// If db doesn't contain a checkpoint, RestorePAD() returns
+// ErrNoCheckpoint.
+func RestorePAD(db kv.DB, interval uint64, ad AssocData,
+	signKey sign.PrivateKey, vrfKey vrf.VRF, len uint64,
+	encodeAd func(AssocData) ([]byte, error),
+	decodeAd func([]byte) (AssocData, error)) (*PAD, error) {
+	if ad == nil {
+		panic("[merkletree] PAD must be created with non-nil associated data")
+	}
+	buf, err := db.Get(checkpointKey)
+	if err == db.ErrNotFound() {
+		return nil, ErrNoCheckpoint
+	} else if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(buf, &cp); err != nil || cp.STR == nil {
+		return nil, ErrBadCheckpoint
+	}
+
+	tree := newMerkleTree(cp.Nonce)
+	// verify each leaf before inserting it into the tree, since a leaf's
+	// key and value aren't committed to by the tree hash directly
+	restoreLeaf := func(leaf *persistedLeaf) error {
+		index, _ := vrfKey.Prove([]byte(leaf.Key))
+		if leaf.Commitment == nil || !bytes.Equal(index, leaf.Index) ||
+			!leaf.Commitment.Verify([]byte(leaf.Key), leaf.Value) {
+			return ErrBadCheckpoint
+		}
+		tree.setLeaf(leaf)
+		return nil
+	}
+	for _, leaf := range cp.Leaves {
+		if err := restoreLeaf(leaf); err != nil {
+			return nil, err
+		}
+	}
+	latest, err := restoreSTR(tree, cp.STR, decodeAd)
+	if err != nil {
+		return nil, err
+	}
+
+	var seq uint64
+	iter := db.NewIterator(kv.BytesPrefix(walPrefix))
+	for ok := iter.First(); ok; ok = iter.Next() {
+		var entry walEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			iter.Release()
+			return nil, ErrBadCheckpoint
+		}
+		switch {
+		case entry.Leaf != nil:
+			if err := restoreLeaf(entry.Leaf); err != nil {
+				iter.Release()
+				return nil, err
+			}
+		case entry.STR != nil:
+			str, err := restoreSTR(tree, entry.STR, decodeAd)
+			if err != nil {
+				iter.Release()
+				return nil, err
+			}
+			if !str.VerifyHashChain(latest) {
+				iter.Release()
+				return nil, ErrBadCheckpoint
+			}
+			latest = str
+		}
+		seq++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	signKey, nextSignKey, crossSignKey, err := restoreRotation(db, latest, signKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pad := &PAD{
+		signKey:      signKey,
+		nextSignKey:  nextSignKey,
+		crossSignKey: crossSignKey,
+		vrfKey:       vrfKey,
+		vrfCache:     newVRFCache(vrfKey),
+		tree:         tree,
+		snapshots:    make(map[uint64]*SignedTreeRoot, len),
+		loadedEpochs: make([]uint64, 0, len),
+		latestSTR:    latest,
+		ad:           ad,
+		store: &padStore{
+			db:       db,
+			interval: interval,
+			seq:      seq,
+			encodeAd: encodeAd,
+			decodeAd: decodeAd,
+		},
+	}
+	if pad.store.interval == 0 {
+		pad.store.interval = 1
+	}
+	pad.snapshots[latest.Epoch] = latest
+	pad.loadedEpochs = append(pad.loadedEpochs, latest.Epoch)
+	return pad, nil
+}
+
+// persist writes the newly issued STR str to the database: str is
+// either appended to the WAL, or included in a new checkpoint if
+// the checkpoint interval has elapsed.
+func (pad *PAD) persist(str *SignedTreeRoot) error {
+	if str.Epoch%pad.store.interval == 0 {
+		return pad.checkpoint(str)
+	}
+	return pad.store.logSTR(str)
+}
+
+// restoreRotation resumes the signing key rotation recorded in db, if
+// any, at the stage reached by the latest STR, and verifies the
+// signatures of latest. It returns the signing key of the restored PAD
+// and its pending keys (see PAD.RotateSigningKey()).
+func restoreRotation(db kv.DB, latest *SignedTreeRoot, signKey sign.PrivateKey) (
+	sk, next, cross sign.PrivateKey, err error) {
+	sk = signKey
+	signer := signKey
+	buf, err := db.Get(rotationKey)
+	switch {
+	case err == nil:
+		var r rotation
+		if err := json.Unmarshal(buf, &r); err != nil || latest.Epoch < r.Epoch ||
+			len(r.SignKey) != sign.PrivateKeySize ||
+			len(r.NextSignKey) != sign.PrivateKeySize {
+			return nil, nil, nil, ErrBadCheckpoint
+		}
+		signer = r.SignKey
+		switch latest.Epoch - r.Epoch {
+		case 0:
+			sk, next = r.SignKey, r.NextSignKey
+		case 1:
+			sk, cross = r.SignKey, r.NextSignKey
+		case 2:
+			// latest is signed with both keys
+			pk, _ := r.NextSignKey.Public()
+			if !pk.Verify(latest.Serialize(), latest.CrossSignature) {
+				return nil, nil, nil, ErrBadCheckpoint
+			}
+			sk = r.NextSignKey
+		default:
+			sk, signer = r.NextSignKey, r.NextSignKey
+		}
+	case err != db.ErrNotFound():
+		return nil, nil, nil, err
+	}
+	pk, ok := signer.Public()
+	if !ok || !pk.Verify(latest.Serialize(), latest.Signature) {
+		return nil, nil, nil, ErrBadCheckpoint
+	}
+	return sk, next, cross, nil
+}
+
+// restoreSTR reconstructs the STR pstr, and verifies that pstr commits
+// to the current state of tree. The nodes of tree are hashed with the
+// hasher of pstr, i.e., the default hasher truncated to the size of
+// pstr's tree hash unless pstr has a HashID (see PAD.SetHasher()).
+func restoreSTR(tree *MerkleTree, pstr *persistedSTR,
+	decodeAd func([]byte) (AssocData, error)) (*SignedTreeRoot, error) {
+	if pstr.SignedTreeRoot == nil {
+		return nil, ErrBadCheckpoint
+	}
+	var h hashers.PADHasher
+	var err error
+	if pstr.HashID != "" {
+		h, err = hashers.NewPADHasher(pstr.HashID)
+	} else {
+		h, err = hashers.WithSize(hashers.Default(), len(pstr.TreeHash))
+	}
+	if err != nil || h.Size() != len(pstr.TreeHash) {
+		return nil, ErrBadCheckpoint
+	}
+	tree.setHasher(h)
+	ad, err := decodeAd(pstr.AssocData)
+	if err != nil {
+		return nil, ErrBadCheckpoint
+	}
+	tree.recomputeHash()
+	if !bytes.Equal(tree.hash, pstr.TreeHash) {
+		return nil, ErrBadCheckpoint
+	}
+	str := pstr.SignedTreeRoot
+	str.tree = tree.Clone()
+	str.Ad = ad
+	return str, nil
+}
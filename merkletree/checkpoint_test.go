@@ -0,0 +1,453 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func encodeTestAd(ad AssocData) ([]byte, error) {
+	return ad.Serialize(), nil
+}
+
+func decodeTestAd(buf []byte) (AssocData, error) {
+	return TestAd{string(buf)}, nil
+}
+
+func restoreTestPAD(db kv.DB) (*PAD, error) {
+	return RestorePAD(db, 2, TestAd{"abc"}, signKey, vrfKey, 10,
+		encodeTestAd, decodeTestAd)
+}
+
+func TestRestorePAD(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		// epochs 1 and 2 are logged, epoch 2 is also checkpointed
+		for i := 0; i < 5; i++ {
+			key := keyPrefix + strconv.Itoa(i)
+			if err := pad.Set(key, append(valuePrefix, byte(i))); err != nil {
+				t.Fatal(err)
+			}
+			if i%2 == 1 {
+				pad.Update(nil)
+			}
+		}
+
+		restored, err := restoreTestPAD(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.LatestSTR().Epoch != pad.LatestSTR().Epoch ||
+			!bytes.Equal(restored.LatestSTR().Signature, pad.LatestSTR().Signature) {
+			t.Fatal("Expect the same latest STR")
+		}
+		pad.tree.recomputeHash()
+		restored.tree.recomputeHash()
+		if !bytes.Equal(restored.tree.hash, pad.tree.hash) {
+			t.Fatal("Expect the same pending tree")
+		}
+
+		var pending []string
+		restored.VisitPending(func(key string, value []byte) {
+			pending = append(pending, key)
+		})
+		if len(pending) != 1 || pending[0] != keyPrefix+"4" {
+			t.Fatal("Expect only", keyPrefix+"4", "to be pending, got", pending)
+		}
+
+		// the restored PAD keeps persisting its changes
+		restored.Update(nil)
+		again, err := restoreTestPAD(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.LatestSTR().Epoch != 3 {
+			t.Fatal("Expect epoch", 3, "got", again.LatestSTR().Epoch)
+		}
+		ap, err := again.Lookup(keyPrefix + "4")
+		if err != nil || ap.ProofType() != ProofOfInclusion {
+			t.Fatal("Expect a proof of inclusion")
+		}
+	})
+}
+
+func TestRestorePADNoCheckpoint(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		if _, err := restoreTestPAD(db); err != ErrNoCheckpoint {
+			t.Fatal("Expect", ErrNoCheckpoint, "got", err)
+		}
+	})
+}
+
+func TestRestorePADBadCheckpoint(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 10, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Set(keyPrefix, valuePrefix); err != nil {
+			t.Fatal(err)
+		}
+		pad.Update(nil)
+
+		// tamper with the logged leaf
+		buf, err := db.Get(walKey(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = bytes.Replace(buf, []byte(keyPrefix), []byte("yek"), 1)
+		if err := db.Put(walKey(0), buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := restoreTestPAD(db); err != ErrBadCheckpoint {
+			t.Fatal("Expect", ErrBadCheckpoint, "got", err)
+		}
+	})
+}
+
+func TestLoadSTR(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		var sigs [][]byte
+		sigs = append(sigs, pad.LatestSTR().Signature)
+		for i := 0; i < 5; i++ {
+			if err := pad.Set(keyPrefix+strconv.Itoa(i), valuePrefix); err != nil {
+				t.Fatal(err)
+			}
+			pad.Update(TestAd{strconv.Itoa(i)})
+			sigs = append(sigs, pad.LatestSTR().Signature)
+		}
+		if pad.GetSTR(0) != nil {
+			t.Fatal("Expect the snapshot of epoch", 0, "to be removed from memory")
+		}
+
+		restored, err := restoreTestPAD(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []*PAD{pad, restored} {
+			var prev *SignedTreeRoot
+			for ep, sig := range sigs {
+				str, err := p.LoadSTR(uint64(ep))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if str.Epoch != uint64(ep) || !bytes.Equal(str.Signature, sig) {
+					t.Fatal("Expect the STR of epoch", ep)
+				}
+				if prev != nil && !str.VerifyHashChain(prev) {
+					t.Fatal("Expect the loaded STRs to form a hash chain")
+				}
+				prev = str
+			}
+		}
+		if str, err := restored.LoadSTR(3); err != nil ||
+			!bytes.Equal(str.Ad.Serialize(), TestAd{"1"}.Serialize()) {
+			t.Fatal("Expect the associated data of epoch", 3, "to be loaded")
+		}
+	})
+
+	pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	pad.Update(nil)
+	if _, err := pad.LoadSTR(0); err != ErrSTRNotFound {
+		t.Fatal("Expect", ErrSTRNotFound, "got", err)
+	}
+}
+
+func TestRestorePADDerivedSalts(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 10, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Set(keyPrefix+"0", valuePrefix); err != nil {
+			t.Fatal(err)
+		}
+		saltKey := make([]byte, 32)
+		pad.SetSaltKey(saltKey)
+		if err := pad.Set(keyPrefix+"1", valuePrefix); err != nil {
+			t.Fatal(err)
+		}
+		pad.Update(nil)
+
+		restored, err := restoreTestPAD(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ap, err := restored.Lookup(keyPrefix + "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		salt := crypto.DeriveSalt(saltKey, 1, ap.LookupIndex)
+		if !bytes.Equal(ap.Leaf.Commitment.Salt, salt) {
+			t.Fatal("Expect the salt to be derived from the salt key")
+		}
+		// only the binding set before the salt key fails the audit
+		restored.SetSaltKey(saltKey)
+		var bad []string
+		restored.AuditSalts(func(key string, epoch uint64) {
+			bad = append(bad, key)
+		})
+		if len(bad) != 1 || bad[0] != keyPrefix+"0" {
+			t.Fatal("Expect", keyPrefix+"0", "to fail the audit, got", bad)
+		}
+	})
+}
+
+func TestRestorePADTruncatedHashes(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		// the hash size changes between two checkpoints
+		for i := 0; i < 3; i++ {
+			if i == 1 {
+				if err := pad.SetHashSize(crypto.MinHashSizeByte); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := pad.Set(keyPrefix+strconv.Itoa(i), valuePrefix); err != nil {
+				t.Fatal(err)
+			}
+			pad.Update(nil)
+		}
+
+		restored, err := restoreTestPAD(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.HashSize() != crypto.MinHashSizeByte {
+			t.Fatal("Expect", crypto.MinHashSizeByte, "got", restored.HashSize())
+		}
+		if !bytes.Equal(restored.LatestSTR().Signature, pad.LatestSTR().Signature) {
+			t.Fatal("Expect the same latest STR")
+		}
+	})
+}
+
+func TestRestorePADHasher(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		h, err := hashers.NewPADHasher("SHA256/192")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the hasher changes between two checkpoints
+		for i := 0; i < 4; i++ {
+			if i == 1 {
+				pad.SetHasher(h)
+			}
+			if err := pad.Set(keyPrefix+strconv.Itoa(i), valuePrefix); err != nil {
+				t.Fatal(err)
+			}
+			pad.Update(nil)
+		}
+
+		restored, err := restoreTestPAD(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Hasher().ID() != h.ID() {
+			t.Fatal("Expect", h.ID(), "got", restored.Hasher().ID())
+		}
+		if !bytes.Equal(restored.LatestSTR().Signature, pad.LatestSTR().Signature) {
+			t.Fatal("Expect the same latest STR")
+		}
+	})
+}
+
+func TestRestorePADRotation(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		pad.Update(nil)
+		newKey, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.RotateSigningKey(newKey); err != nil {
+			t.Fatal(err)
+		}
+
+		// restore the PAD at each stage of the handover
+		msg := []byte("msg")
+		for i, ad := range []AssocData{TestAd{"handover"}, TestAd{"abc"}, nil, nil} {
+			restored, err := restoreTestPAD(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, crossSigned := pad.PendingRotation()
+			rkey, rcrossSigned := restored.PendingRotation()
+			if !bytes.Equal(rkey, key) || rcrossSigned != crossSigned {
+				t.Error("Expect the pending rotation to be restored at stage", i)
+			}
+			if !bytes.Equal(restored.Sign(msg), pad.Sign(msg)) {
+				t.Error("Expect the restored PAD to sign with the same key at stage", i)
+			}
+			pad.Update(ad)
+		}
+	})
+}
+
+func TestRestorePADBadRotation(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+			t.Fatal(err)
+		}
+		newKey, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.RotateSigningKey(newKey); err != nil {
+			t.Fatal(err)
+		}
+		pad.Update(TestAd{"handover"})
+		pad.Update(TestAd{"abc"})
+
+		// the STR handing over to the new key isn't cross-signed by it
+		otherKey, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pad.store.logRotation(&rotation{
+			SignKey:     signKey,
+			NextSignKey: otherKey,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := restoreTestPAD(db); err != ErrBadCheckpoint {
+			t.Fatal("Expect", ErrBadCheckpoint, "got", err)
+		}
+	})
+}
+
+var errOutage = errors.New("storage outage")
+
+// outageDB fails the writes to the wrapped database while down is set.
+type outageDB struct {
+	kv.DB
+	down bool
+}
+
+func (db *outageDB) Put(key, value []byte) error {
+	if db.down {
+		return errOutage
+	}
+	return db.DB.Put(key, value)
+}
+
+func (db *outageDB) Write(b kv.Batch) error {
+	if db.down {
+		return errOutage
+	}
+	return db.DB.Write(b)
+}
+
+func TestUpdateStorageOutage(t *testing.T) {
+	// the STR for epoch 1 is logged, and the STR for epoch 2 is checkpointed
+	for _, epoch := range []uint64{1, 2} {
+		utils.WithDB(func(ldb kv.DB) {
+			db := &outageDB{DB: ldb}
+			pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pad.Persist(db, 2, encodeTestAd, decodeTestAd); err != nil {
+				t.Fatal(err)
+			}
+			for pad.LatestSTR().Epoch+1 < epoch {
+				if err := pad.Update(nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := pad.Set(keyPrefix, valuePrefix); err != nil {
+				t.Fatal(err)
+			}
+			prev := pad.LatestSTR()
+
+			db.down = true
+			if err := pad.Update(nil); err != errOutage {
+				t.Fatal("Expect", errOutage, "got", err)
+			}
+			if pad.LatestSTR() != prev {
+				t.Fatal("Expect the previous STR to remain the latest STR for epoch", epoch)
+			}
+			db.down = false
+			restored, err := restoreTestPAD(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored.LatestSTR().Signature, prev.Signature) {
+				t.Fatal("Expect the unpersisted STR for epoch", epoch, "not to be issued")
+			}
+
+			// the update succeeds once the storage recovers
+			if err := pad.Update(nil); err != nil {
+				t.Fatal(err)
+			}
+			str := pad.LatestSTR()
+			if str.Epoch != epoch || !str.VerifyHashChain(prev) {
+				t.Fatal("Expect the STR for epoch", epoch, "to extend the hash chain")
+			}
+			restored, err = restoreTestPAD(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored.LatestSTR().Signature, str.Signature) {
+				t.Fatal("Expect the STR for epoch", epoch, "to be persisted")
+			}
+			ap, err := restored.Lookup(keyPrefix)
+			if err != nil || ap.ProofType() != ProofOfInclusion {
+				t.Fatal("Expect a proof of inclusion for", keyPrefix)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package merkletree
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+// A TreeDelta lists the leaves of the tree of an epoch whose bindings
+// are new or changed since the previous epoch, in the order of their
+// indices. Each leaf omits its value and commitment salt, as a sampled
+// leaf does (see MerkleTree.Sample()), so that the delta reveals no
+// binding. The leaves pushed down by the new leaves are omitted as well,
+// since their level follows from the indices of the leaves.
+// A mirror holding the leaves of the previous epoch's tree inserts the
+// delta's leaves into it, and recomputes the tree's hash from the tree
+// nonce TreeNonce, to obtain the tree hash of the epoch's STR.
+type TreeDelta struct {
+	TreeNonce []byte
+	Leaves    []*ProofNode
+}
+
+// Delta returns the delta of the tree m since the tree prev, or since
+// the empty tree if prev is nil (see TreeDelta).
+// The subtrees m shares with prev (see Clone()) are skipped, so that
+// Delta takes time in the number of nodes changed since prev, rather
+// than in the size of m.
+func (m *MerkleTree) Delta(prev *MerkleTree) *TreeDelta {
+	d := &TreeDelta{TreeNonce: m.nonce}
+	var prevRoot merkleNode
+	if prev != nil {
+		prevRoot = prev.root
+	}
+	diffLeaves(m.root, prevRoot, func(n *userLeafNode) {
+		d.Leaves = append(d.Leaves, &ProofNode{
+			Level: n.level,
+			Index: append([]byte{}, n.index...),
+			Commitment: &crypto.Commit{
+				Value: append([]byte{}, n.commitment.Value...),
+			},
+		})
+	})
+	return d
+}
+
+// diffLeaves calls f for each leaf of the subtree n, in order, whose
+// binding isn't a binding of the subtree prev at the same position,
+// which may be nil if there is no such subtree.
+func diffLeaves(n, prev merkleNode, f func(*userLeafNode)) {
+	if n == prev {
+		return
+	}
+	switch n := n.(type) {
+	case *userLeafNode:
+		if p, ok := prev.(*userLeafNode); !ok || !sameBinding(n, p) {
+			f(n)
+		}
+	case *interiorNode:
+		switch p := prev.(type) {
+		case *interiorNode:
+			diffLeaves(n.leftChild, p.leftChild, f)
+			diffLeaves(n.rightChild, p.rightChild, f)
+		case *userLeafNode:
+			// the leaf p has been pushed down into n
+			visitULNsInternal(n, func(leaf *userLeafNode) {
+				if !sameBinding(leaf, p) {
+					f(leaf)
+				}
+			})
+		default:
+			visitULNsInternal(n, f)
+		}
+	}
+}
+
+// sameBinding returns whether the leaves n and p commit to the same
+// binding at the same index.
+func sameBinding(n, p *userLeafNode) bool {
+	return bytes.Equal(n.index, p.index) &&
+		bytes.Equal(n.commitment.Value, p.commitment.Value)
+}
+
+// DeltaInEpoch returns the delta of the snapshot at epoch since the
+// snapshot at the previous epoch, or since the empty tree for the
+// first epoch (see MerkleTree.Delta()).
+// It returns ErrSTRNotFound if either snapshot has been removed from
+// memory.
+func (pad *PAD) DeltaInEpoch(epoch uint64) (*TreeDelta, error) {
+	str := pad.GetSTR(epoch)
+	if str == nil {
+		return nil, ErrSTRNotFound
+	}
+	if epoch == 0 {
+		return str.tree.Delta(nil), nil
+	}
+	prev := pad.GetSTR(epoch - 1)
+	if prev == nil {
+		return nil, ErrSTRNotFound
+	}
+	return str.tree.Delta(prev.tree), nil
+}
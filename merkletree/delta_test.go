@@ -0,0 +1,105 @@
+package merkletree
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+// applyDelta inserts the leaves of d into a clone of m, as a mirror
+// would, and returns the hash of the resulting tree.
+func applyDelta(m *MerkleTree, d *TreeDelta) []byte {
+	mirror := m.Clone()
+	for _, leaf := range d.Leaves {
+		mirror.insertNode(leaf.Index, &userLeafNode{
+			index:      leaf.Index,
+			commitment: &crypto.Commit{Value: leaf.Commitment.Value},
+		})
+	}
+	mirror.recomputeHash()
+	return mirror.hash
+}
+
+func TestTreeDelta(t *testing.T) {
+	m1, err := NewMerkleTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := m1.Set(staticVRFKey.Compute([]byte(key)), key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := m1.Delta(nil); len(d.Leaves) != 100 {
+		t.Fatal("Expect", 100, "leaves", "got", len(d.Leaves))
+	}
+
+	m2 := m1.Clone()
+	// 10 new bindings and a changed one
+	for i := 100; i < 110; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := m2.Set(staticVRFKey.Compute([]byte(key)), key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m2.Set(staticVRFKey.Compute([]byte("key"+strconv.Itoa(0))), "key"+strconv.Itoa(0),
+		[]byte("new value")); err != nil {
+		t.Fatal(err)
+	}
+	m2.recomputeHash()
+
+	d := m2.Delta(m1)
+	if len(d.Leaves) != 11 {
+		t.Fatal("Expect", 11, "leaves", "got", len(d.Leaves))
+	}
+	if !bytes.Equal(d.TreeNonce, m2.nonce) {
+		t.Fatal("Expect the tree nonce", m2.nonce, "got", d.TreeNonce)
+	}
+	for i, leaf := range d.Leaves {
+		if leaf.Value != nil || leaf.Commitment.Salt != nil {
+			t.Fatal("Expect the leaves' bindings to be omitted")
+		}
+		if i > 0 && bytes.Compare(d.Leaves[i-1].Index, leaf.Index) >= 0 {
+			t.Fatal("Expect the leaves in the order of their indices")
+		}
+	}
+	if h := applyDelta(m1, d); !bytes.Equal(h, m2.hash) {
+		t.Fatal("Expect the mirrored tree hash", m2.hash, "got", h)
+	}
+	if d := m2.Delta(m2.Clone()); len(d.Leaves) != 0 {
+		t.Fatal("Expect an empty delta, got", len(d.Leaves), "leaves")
+	}
+}
+
+func TestPADDeltaInEpoch(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set("alice", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	if err := pad.Set("bob", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+
+	d, err := pad.DeltaInEpoch(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Leaves) != 1 || !bytes.Equal(d.Leaves[0].Index, pad.Index("bob")) {
+		t.Fatal("Expect bob's leaf only")
+	}
+	if h := applyDelta(pad.GetSTR(1).tree, d); !bytes.Equal(h, pad.GetSTR(2).TreeHash) {
+		t.Fatal("Expect the mirrored tree hash", pad.GetSTR(2).TreeHash, "got", h)
+	}
+	// the snapshot of epoch 0 has been removed from memory
+	if _, err := pad.DeltaInEpoch(1); err != ErrSTRNotFound {
+		t.Fatal("Expect", ErrSTRNotFound, "got", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Implements the hand-rolled JSON encoding of the authentication paths,
+// which are the bulk of the proofs a directory returns. The encoding is
+// identical to the encoding of encoding/json, without its reflection.
+
+package merkletree
+
+import (
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// MarshalJSON implements the json.Marshaler interface.
+func (ap *AuthenticationPath) MarshalJSON() ([]byte, error) {
+	return ap.AppendJSON(nil), nil
+}
+
+// AppendJSON appends the JSON encoding of ap to b,
+// or null if ap is nil.
+func (ap *AuthenticationPath) AppendJSON(b []byte) []byte {
+	if ap == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, `{"TreeNonce":`...)
+	b = utils.AppendJSONBytes(b, ap.TreeNonce)
+	b = append(b, `,"PrunedTree":`...)
+	b = utils.AppendJSONByteArrays(b, ap.PrunedTree)
+	b = append(b, `,"LookupIndex":`...)
+	b = utils.AppendJSONBytes(b, ap.LookupIndex)
+	b = append(b, `,"VrfProof":`...)
+	b = utils.AppendJSONBytes(b, ap.VrfProof)
+	b = append(b, `,"Leaf":`...)
+	b = ap.Leaf.appendJSON(b)
+	return append(b, '}')
+}
+
+func (n *ProofNode) appendJSON(b []byte) []byte {
+	if n == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, `{"Level":`...)
+	b = strconv.AppendUint(b, uint64(n.Level), 10)
+	b = append(b, `,"Index":`...)
+	b = utils.AppendJSONBytes(b, n.Index)
+	b = append(b, `,"Value":`...)
+	b = utils.AppendJSONBytes(b, n.Value)
+	b = append(b, `,"IsEmpty":`...)
+	b = strconv.AppendBool(b, n.IsEmpty)
+	b = append(b, `,"Commitment":`...)
+	b = appendCommitJSON(b, n.Commitment)
+	return append(b, '}')
+}
+
+func appendCommitJSON(b []byte, c *crypto.Commit) []byte {
+	if c == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, `{"Salt":`...)
+	b = utils.AppendJSONBytes(b, c.Salt)
+	b = append(b, `,"Value":`...)
+	b = utils.AppendJSONBytes(b, c.Value)
+	return append(b, '}')
+}
@@ -3,8 +3,10 @@ package merkletree
 import (
 	"bytes"
 	"errors"
+	"sync/atomic"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/utils"
 )
 
@@ -25,28 +27,89 @@ const (
 )
 
 // MerkleTree represents the Merkle prefix tree data structure,
-// which includes the root node, its hash, and a random tree-specific
-// nonce.
+// which includes the root node, its hash, a random tree-specific
+// nonce, and the hasher of its nodes.
+//
+// A tree and its clones share their nodes (see Clone()): the nodes
+// are copied on write, i.e., a change to a tree copies the interior
+// nodes on the path to the changed leaf, unless they were created
+// by the tree since it was last cloned. Hence the snapshots of a PAD
+// share their unchanged subtrees, and the memory used by a snapshot
+// grows with the number of leaves changed in its epoch rather than
+// with the size of the tree.
 type MerkleTree struct {
-	nonce []byte
-	root  *interiorNode
-	hash  []byte
+	nonce  []byte
+	root   *interiorNode
+	hash   []byte
+	hasher hashers.PADHasher
+	gen    uint64 // the generation of the nodes the tree may change in place
+}
+
+// generations counts the generations of the nodes of all trees.
+var generations uint64
+
+// nextGeneration returns a generation that no node belongs to yet.
+func nextGeneration() uint64 {
+	return atomic.AddUint64(&generations, 1)
 }
 
 // NewMerkleTree returns an empty Merkle prefix tree
 // with a secure random nonce. The tree root is an interior node
 // and its children are two empty leaf nodes.
 func NewMerkleTree() (*MerkleTree, error) {
-	root := newInteriorNode(nil, 0, []bool{})
 	nonce, err := crypto.MakeRand()
 	if err != nil {
 		return nil, err
 	}
-	m := &MerkleTree{
-		nonce: nonce,
-		root:  root,
+	return newMerkleTree(nonce), nil
+}
+
+// newMerkleTree returns an empty Merkle prefix tree with the nonce.
+func newMerkleTree(nonce []byte) *MerkleTree {
+	gen := nextGeneration()
+	return &MerkleTree{
+		nonce:  nonce,
+		root:   newInteriorNode(0, []bool{}, gen),
+		hasher: hashers.Default(),
+		gen:    gen,
+	}
+}
+
+// own returns the interior node n if the tree m may change it in
+// place, and otherwise a copy of n which m may change.
+func (m *MerkleTree) own(n *interiorNode) *interiorNode {
+	if n.gen == m.gen {
+		return n
 	}
-	return m, nil
+	c := *n
+	c.gen = m.gen
+	return &c
+}
+
+// setHasher makes the tree m hash its nodes with the hasher h.
+// The cached hashes are dropped, so that the tree's hash is recomputed
+// with h. The interior nodes shared with the clones of m are copied,
+// so that the clones keep their hashes.
+func (m *MerkleTree) setHasher(h hashers.PADHasher) {
+	if h.ID() == m.hasher.ID() {
+		return
+	}
+	m.hasher = h
+	m.hash = nil
+	var clear func(n merkleNode) merkleNode
+	clear = func(n merkleNode) merkleNode {
+		in, ok := n.(*interiorNode)
+		if !ok {
+			return n
+		}
+		in = m.own(in)
+		in.leftHash = nil
+		in.rightHash = nil
+		in.leftChild = clear(in.leftChild)
+		in.rightChild = clear(in.rightChild)
+		return in
+	}
+	m.root = clear(m.root).(*interiorNode)
 }
 
 // Get returns an AuthenticationPath used as a proof
@@ -72,15 +135,15 @@ func (m *MerkleTree) Get(lookupIndex []byte) *AuthenticationPath {
 			break
 		}
 		direction := lookupIndexBits[depth]
-		var hashArr [crypto.HashSizeByte]byte
+		var hash []byte
 		if direction {
-			copy(hashArr[:], nodePointer.(*interiorNode).leftHash)
+			hash = append(hash, nodePointer.(*interiorNode).leftHash...)
 			nodePointer = nodePointer.(*interiorNode).rightChild
 		} else {
-			copy(hashArr[:], nodePointer.(*interiorNode).rightHash)
+			hash = append(hash, nodePointer.(*interiorNode).rightHash...)
 			nodePointer = nodePointer.(*interiorNode).leftChild
 		}
-		authPath.PrunedTree = append(authPath.PrunedTree, hashArr)
+		authPath.PrunedTree = append(authPath.PrunedTree, hash)
 		depth++
 	}
 
@@ -142,73 +205,50 @@ func (m *MerkleTree) Set(index []byte, key string, value []byte) error {
 	return nil
 }
 
+// insertNode inserts the leaf toAdd at index into the tree m, or
+// replaces the leaf with the same index. The leaves are never changed
+// in place: a leaf pushed down by toAdd is copied, so that the clones
+// of m sharing the leaf are unaffected (see own()).
 func (m *MerkleTree) insertNode(index []byte, toAdd *userLeafNode) {
 	indexBits := utils.ToBits(index)
-	var depth uint32 // = 0
-	var nodePointer merkleNode
-	nodePointer = m.root
+	m.root = m.own(m.root)
+	parent := m.root
 
-insertLoop:
-	for {
-		switch nodePointer.(type) {
+	for depth := uint32(0); ; depth++ {
+		direction := indexBits[depth]
+		var child merkleNode
+		if direction { // go right
+			parent.rightHash = nil
+			child = parent.rightChild
+		} else { // go left
+			parent.leftHash = nil
+			child = parent.leftChild
+		}
+		switch n := child.(type) {
+		case *emptyNode:
+			toAdd.level = depth + 1
+			parent.setChild(direction, toAdd)
+			return
 		case *userLeafNode:
-			// reached a "bottom" of the tree.
-			// add a new interior node and push the previous leaf down
-			// then continue insertion
-			currentNodeUL := nodePointer.(*userLeafNode)
-			if currentNodeUL.parent == nil {
-				panic(ErrInvalidTree)
-			}
-
-			if bytes.Equal(currentNodeUL.index, toAdd.index) {
+			if bytes.Equal(n.index, toAdd.index) {
 				// replace the value
-				toAdd.parent = currentNodeUL.parent
-				toAdd.level = currentNodeUL.level
-				*currentNodeUL = *toAdd
+				toAdd.level = n.level
+				parent.setChild(direction, toAdd)
 				return
 			}
-
-			newInteriorNode := newInteriorNode(currentNodeUL.parent, depth, indexBits[:depth])
-
-			direction := utils.GetNthBit(currentNodeUL.index, depth)
-			if direction {
-				newInteriorNode.rightChild = currentNodeUL
-			} else {
-				newInteriorNode.leftChild = currentNodeUL
-			}
-			currentNodeUL.level = depth + 1
-			currentNodeUL.parent = newInteriorNode
-			if newInteriorNode.parent.(*interiorNode).leftChild == nodePointer {
-				newInteriorNode.parent.(*interiorNode).leftChild = newInteriorNode
-			} else {
-				newInteriorNode.parent.(*interiorNode).rightChild = newInteriorNode
-			}
-			nodePointer = newInteriorNode
+			// reached a "bottom" of the tree.
+			// add a new interior node and push the previous leaf down
+			// then continue insertion
+			newNode := newInteriorNode(depth+1, indexBits[:depth+1], m.gen)
+			pushed := *n
+			pushed.level = depth + 2
+			newNode.setChild(utils.GetNthBit(n.index, depth+1), &pushed)
+			parent.setChild(direction, newNode)
+			parent = newNode
 		case *interiorNode:
-			currentNodeI := nodePointer.(*interiorNode)
-			direction := indexBits[depth]
-			if direction { // go right
-				currentNodeI.rightHash = nil
-				if currentNodeI.rightChild.isEmpty() {
-					currentNodeI.rightChild = toAdd
-					toAdd.level = depth + 1
-					toAdd.parent = currentNodeI
-					break insertLoop
-				} else {
-					nodePointer = currentNodeI.rightChild
-				}
-			} else { // go left
-				currentNodeI.leftHash = nil
-				if currentNodeI.leftChild.isEmpty() {
-					currentNodeI.leftChild = toAdd
-					toAdd.level = depth + 1
-					toAdd.parent = currentNodeI
-					break insertLoop
-				} else {
-					nodePointer = currentNodeI.leftChild
-				}
-			}
-			depth += 1
+			n = m.own(n)
+			parent.setChild(direction, n)
+			parent = n
 		default:
 			panic(ErrInvalidTree)
 		}
@@ -243,13 +283,28 @@ func (m *MerkleTree) recomputeHash() {
 	m.hash = m.root.hash(m)
 }
 
-// Clone returns a copy of the tree m.
+// recomputeHashParallel recomputes the hash of m as recomputeHash()
+// does, hashing independent subtrees with up to workers goroutines.
+func (m *MerkleTree) recomputeHashParallel(workers int) {
+	m.hash = m.root.hashParallel(m, workers)
+}
+
+// Clone returns a copy of the tree m, which shares the nodes of m.
 // Any later change to the original tree m does not affect the cloned tree,
 // and vice versa.
+// Clone recomputes the hash of m first, so that the shared nodes are
+// never changed by hashing either tree. Hence it takes time in the
+// number of nodes changed since m was last hashed, rather than in the
+// size of m.
 func (m *MerkleTree) Clone() *MerkleTree {
+	m.recomputeHash()
+	// neither tree may change the shared nodes from now on
+	m.gen = nextGeneration()
 	return &MerkleTree{
-		nonce: m.nonce,
-		root:  m.root.clone(nil).(*interiorNode),
-		hash:  append([]byte{}, m.hash...),
+		nonce:  m.nonce,
+		root:   m.root,
+		hash:   m.hash,
+		hasher: m.hasher,
+		gen:    nextGeneration(),
 	}
 }
@@ -2,12 +2,24 @@ package merkletree
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/utils"
 	"golang.org/x/crypto/sha3"
 )
 
+// truncatedHasher returns the default hasher truncated to size bytes.
+func truncatedHasher(t *testing.T, size int) hashers.PADHasher {
+	h, err := hashers.WithSize(hashers.Default(), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
 // TODO: When #178 is merged, 3 tests below should be removed.
 func TestOneEntry(t *testing.T) {
 	m, err := NewMerkleTree()
@@ -283,4 +295,114 @@ func TestTreeClone(t *testing.T) {
 	if !bytes.Equal(ap.Leaf.Value, []byte("value2")) {
 		t.Error(key2, "value mismatch\n")
 	}
+
+	// the original tree is unchanged
+	if ap := m1.Get(index2); ap.Leaf.Value != nil {
+		t.Error("Expect", key2, "to be absent from the original tree")
+	}
+	if bytes.Equal(m1.hash, m2.hash) {
+		t.Error("Expect the trees' hashes to differ")
+	}
+}
+
+// interiorNodes returns the set of the interior nodes of the tree m.
+func interiorNodes(m *MerkleTree) map[*interiorNode]bool {
+	nodes := make(map[*interiorNode]bool)
+	var visit func(n merkleNode)
+	visit = func(n merkleNode) {
+		if in, ok := n.(*interiorNode); ok {
+			nodes[in] = true
+			visit(in.leftChild)
+			visit(in.rightChild)
+		}
+	}
+	visit(m.root)
+	return nodes
+}
+
+func TestTreeCloneSharesNodes(t *testing.T) {
+	m1, err := NewMerkleTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := m1.Set(staticVRFKey.Compute([]byte(key)), key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m2 := m1.Clone()
+	hash1 := m1.hash
+
+	key := "key" + strconv.Itoa(100)
+	index := staticVRFKey.Compute([]byte(key))
+	if err := m2.Set(index, key, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	m2.recomputeHash()
+
+	// only the interior nodes on the path to the new leaf are copied
+	nodes1 := interiorNodes(m1)
+	var copied uint32
+	for n := range interiorNodes(m2) {
+		if !nodes1[n] {
+			copied++
+		}
+	}
+	if level := m2.Get(index).Leaf.Level; copied > level {
+		t.Error("Expect at most", level, "copied interior nodes", "got", copied)
+	}
+
+	m1.recomputeHash()
+	if !bytes.Equal(m1.hash, hash1) {
+		t.Fatal("Expect the original tree's hash to be unchanged")
+	}
+	m2.setHasher(truncatedHasher(t, crypto.MinHashSizeByte))
+	m2.recomputeHash()
+	m1.recomputeHash()
+	if !bytes.Equal(m1.hash, hash1) {
+		t.Fatal("Expect the clone's hash size not to affect the original tree")
+	}
+}
+
+func TestTreeHashParallel(t *testing.T) {
+	m, err := NewMerkleTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := m.Set(staticVRFKey.Compute([]byte(key)), key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.recomputeHash()
+	want := m.hash
+
+	for _, workers := range []int{0, 2, 3, 8} {
+		// clear the cached hashes
+		m.setHasher(truncatedHasher(t, crypto.MinHashSizeByte))
+		m.setHasher(hashers.Default())
+		m.recomputeHashParallel(workers)
+		if !bytes.Equal(m.hash, want) {
+			t.Error(workers, "workers:", "expect", want, "got", m.hash)
+		}
+	}
+
+	// only the paths to the new leaves need to be hashed
+	m2 := m.Clone()
+	for i := 1000; i < 1010; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := m2.Set(staticVRFKey.Compute([]byte(key)), key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m2.recomputeHashParallel(4)
+	got := m2.hash
+	m2.setHasher(truncatedHasher(t, crypto.MinHashSizeByte))
+	m2.setHasher(hashers.Default())
+	m2.recomputeHash()
+	if !bytes.Equal(got, m2.hash) {
+		t.Fatal("Expect", m2.hash, "got", got)
+	}
 }
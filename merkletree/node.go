@@ -1,17 +1,22 @@
 package merkletree
 
 import (
+	"sync"
+
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/utils"
 )
 
 type node struct {
-	parent merkleNode
-	level  uint32
+	level uint32
 }
 
+// An interiorNode may only be changed in place by the tree of the
+// same generation (see MerkleTree.own()). User leaves and empty nodes
+// are never changed once they are in a tree.
 type interiorNode struct {
 	node
+	gen        uint64
 	leftChild  merkleNode
 	rightChild merkleNode
 	leftHash   []byte
@@ -24,6 +29,7 @@ type userLeafNode struct {
 	value      []byte
 	index      []byte
 	commitment *crypto.Commit
+	epoch      uint64 // epoch in which the leaf is first included
 }
 
 type emptyNode struct {
@@ -31,7 +37,7 @@ type emptyNode struct {
 	index []byte
 }
 
-func newInteriorNode(parent merkleNode, level uint32, prefixBits []bool) *interiorNode {
+func newInteriorNode(level uint32, prefixBits []bool, gen uint64) *interiorNode {
 	prefixLeft := append([]bool(nil), prefixBits...)
 	prefixLeft = append(prefixLeft, false)
 	prefixRight := append([]bool(nil), prefixBits...)
@@ -51,24 +57,31 @@ func newInteriorNode(parent merkleNode, level uint32, prefixBits []bool) *interi
 	}
 	newNode := &interiorNode{
 		node: node{
-			parent: parent,
-			level:  level,
+			level: level,
 		},
+		gen:        gen,
 		leftChild:  leftBranch,
 		rightChild: rightBranch,
 		leftHash:   nil,
 		rightHash:  nil,
 	}
-	leftBranch.parent = newNode
-	rightBranch.parent = newNode
 
 	return newNode
 }
 
+// setChild replaces the right child of n if direction is true,
+// and its left child otherwise.
+func (n *interiorNode) setChild(direction bool, child merkleNode) {
+	if direction {
+		n.rightChild = child
+	} else {
+		n.leftChild = child
+	}
+}
+
 type merkleNode interface {
 	isEmpty() bool
 	hash(*MerkleTree) []byte
-	clone(*interiorNode) merkleNode
 }
 
 var _ merkleNode = (*userLeafNode)(nil)
@@ -82,11 +95,47 @@ func (n *interiorNode) hash(m *MerkleTree) []byte {
 	if n.rightHash == nil {
 		n.rightHash = n.rightChild.hash(m)
 	}
-	return crypto.Digest(n.leftHash, n.rightHash)
+	return m.hasher.Digest(n.leftHash, n.rightHash)
+}
+
+// hashParallel computes the hash of n as hash() does, but hashes the
+// two subtrees of n in separate goroutines if both need to be hashed,
+// splitting the workers among them, until a single worker is left.
+// The subtrees share no node which needs to be hashed, since such
+// nodes are owned by the tree being hashed (see MerkleTree.Clone()).
+func (n *interiorNode) hashParallel(m *MerkleTree, workers int) []byte {
+	if workers <= 1 {
+		return n.hash(m)
+	}
+	switch {
+	case n.leftHash == nil && n.rightHash == nil:
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.leftHash = hashSubtree(n.leftChild, m, workers/2)
+		}()
+		n.rightHash = hashSubtree(n.rightChild, m, workers-workers/2)
+		wg.Wait()
+	case n.leftHash == nil:
+		n.leftHash = hashSubtree(n.leftChild, m, workers)
+	case n.rightHash == nil:
+		n.rightHash = hashSubtree(n.rightChild, m, workers)
+	}
+	return m.hasher.Digest(n.leftHash, n.rightHash)
+}
+
+// hashSubtree computes the hash of the subtree rooted at n
+// with up to workers goroutines (see interiorNode.hashParallel()).
+func hashSubtree(n merkleNode, m *MerkleTree, workers int) []byte {
+	if in, ok := n.(*interiorNode); ok {
+		return in.hashParallel(m, workers)
+	}
+	return n.hash(m)
 }
 
 func (n *userLeafNode) hash(m *MerkleTree) []byte {
-	return crypto.Digest(
+	return m.hasher.Digest(
 		[]byte{LeafIdentifier},               // K_leaf
 		[]byte(m.nonce),                      // K_n
 		[]byte(n.index),                      // i
@@ -96,7 +145,7 @@ func (n *userLeafNode) hash(m *MerkleTree) []byte {
 }
 
 func (n *emptyNode) hash(m *MerkleTree) []byte {
-	return crypto.Digest(
+	return m.hasher.Digest(
 		[]byte{EmptyBranchIdentifier},        // K_empty
 		[]byte(m.nonce),                      // K_n
 		[]byte(n.index),                      // i
@@ -104,47 +153,6 @@ func (n *emptyNode) hash(m *MerkleTree) []byte {
 	)
 }
 
-func (n *interiorNode) clone(parent *interiorNode) merkleNode {
-	newNode := &interiorNode{
-		node: node{
-			parent: parent,
-			level:  n.level,
-		},
-		leftHash:  append([]byte{}, n.leftHash...),
-		rightHash: append([]byte{}, n.rightHash...),
-	}
-	if n.leftChild == nil ||
-		n.rightChild == nil {
-		panic(ErrInvalidTree)
-	}
-	newNode.leftChild = n.leftChild.clone(newNode)
-	newNode.rightChild = n.rightChild.clone(newNode)
-	return newNode
-}
-
-func (n *userLeafNode) clone(parent *interiorNode) merkleNode {
-	return &userLeafNode{
-		node: node{
-			parent: parent,
-			level:  n.level,
-		},
-		key:        n.key,
-		value:      n.value,
-		index:      append([]byte{}, n.index...), // make a copy of index
-		commitment: n.commitment,
-	}
-}
-
-func (n *emptyNode) clone(parent *interiorNode) merkleNode {
-	return &emptyNode{
-		node: node{
-			parent: parent,
-			level:  n.level,
-		},
-		index: append([]byte{}, n.index...), // make a copy of index
-	}
-}
-
 func (n *userLeafNode) isEmpty() bool {
 	return false
 }
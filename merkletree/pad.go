@@ -5,8 +5,10 @@ import (
 	"errors"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/storage/kv"
 )
 
 var (
@@ -14,26 +16,42 @@ var (
 	// memory, because the maximum number of cached PAD snapshots
 	// has been exceeded.
 	ErrSTRNotFound = errors.New("[merkletree] STR not found")
+	// ErrBootstrapped indicates that the PAD cannot be bootstrapped,
+	// because it has already been updated, persisted or populated.
+	ErrBootstrapped = errors.New("[merkletree] PAD can only be bootstrapped while empty at epoch 0")
+	// ErrRotationPending indicates that the PAD is still handing its
+	// signing over to the key of a previous rotation.
+	ErrRotationPending = errors.New("[merkletree] A signing key rotation is already pending")
 )
 
 // A PAD represents a persistent authenticated dictionary,
 // and includes the underlying MerkleTree, cached snapshots,
 // the latest SignedTreeRoot, two key pairs for signing and VRF
 // computation, and additional developer-specified AssocData.
+// If persistence is enabled (see PAD.Persist()), the PAD also writes
+// its checkpoints and WAL to a key-value database.
 type PAD struct {
 	signKey      sign.PrivateKey
-	vrfKey       vrf.PrivateKey
+	vrfKey       vrf.VRF
 	tree         *MerkleTree // will be used to create the next STR
 	snapshots    map[uint64]*SignedTreeRoot
 	loadedEpochs []uint64 // slice of epochs in snapshots
 	latestSTR    *SignedTreeRoot
 	ad           AssocData
+	store        *padStore // nil if the PAD isn't persisted
+	vrfCache     *vrfCache
+	saltKey      []byte // nil if the commitment salts are random
+	extensions   map[string]*STRExtension
+	hasher       hashers.PADHasher // the hasher set by SetHasher(), nil once applied
+	hashWorkers  int               // the number of goroutines hashing the tree
+	nextSignKey  sign.PrivateKey   // the key set by RotateSigningKey(), nil once applied
+	crossSignKey sign.PrivateKey   // the successor of signKey cross-signing the next STR
 }
 
 // NewPAD creates new PAD with the given associated data ad,
 // signing key pair signKey, VRF key pair vrfKey, and the
 // maximum capacity for the snapshot cache len.
-func NewPAD(ad AssocData, signKey sign.PrivateKey, vrfKey vrf.PrivateKey, len uint64) (*PAD, error) {
+func NewPAD(ad AssocData, signKey sign.PrivateKey, vrfKey vrf.VRF, len uint64) (*PAD, error) {
 	if ad == nil {
 		panic("[merkletree] PAD must be created with non-nil associated data")
 	}
@@ -41,6 +59,7 @@ func NewPAD(ad AssocData, signKey sign.PrivateKey, vrfKey vrf.PrivateKey, len ui
 	pad := new(PAD)
 	pad.signKey = signKey
 	pad.vrfKey = vrfKey
+	pad.vrfCache = newVRFCache(vrfKey)
 	pad.tree, err = NewMerkleTree()
 	if err != nil {
 		return nil, err
@@ -52,7 +71,9 @@ func NewPAD(ad AssocData, signKey sign.PrivateKey, vrfKey vrf.PrivateKey, len ui
 	return pad, nil
 }
 
-func (pad *PAD) signTreeRoot(epoch uint64) {
+// signTreeRoot signs the current state of the tree as the STR for epoch,
+// chained to the latest STR, if any.
+func (pad *PAD) signTreeRoot(epoch uint64) *SignedTreeRoot {
 	var prevHash []byte
 	if pad.latestSTR == nil {
 		var err error
@@ -65,20 +86,59 @@ func (pad *PAD) signTreeRoot(epoch uint64) {
 	} else {
 		prevHash = crypto.Digest(pad.latestSTR.Signature)
 	}
-	pad.tree.recomputeHash()
+	pad.tree.recomputeHashParallel(pad.hashWorkers)
 	m := pad.tree.Clone()
-	pad.latestSTR = NewSTR(pad.signKey, pad.ad, m, epoch, prevHash)
+	str := NewSTRWithExtensions(pad.signKey, pad.ad, m, epoch,
+		prevHash, pad.extensions)
+	if pad.crossSignKey != nil {
+		str.CrossSignature = pad.crossSignKey.Sign(str.Serialize())
+	}
+	return str
 }
 
-func (pad *PAD) updateInternal(ad AssocData, epoch uint64) {
+// updateInternal issues the STR for epoch. If the PAD is persisted,
+// the STR only becomes the latest STR once it has been written to the
+// database; otherwise, updateInternal returns the database's error and
+// leaves the PAD unchanged.
+func (pad *PAD) updateInternal(ad AssocData, epoch uint64) error {
 	// Create STR with the `ad` that was used in the prev. Set()
 	// operation.
-	pad.signTreeRoot(epoch)
-	pad.snapshots[epoch] = pad.latestSTR
+	str := pad.signTreeRoot(epoch)
+	if pad.store != nil {
+		if err := pad.persist(str); err != nil {
+			return err
+		}
+	}
+	// delete older str(s) as needed
+	if len(pad.loadedEpochs) == cap(pad.loadedEpochs) {
+		n := cap(pad.loadedEpochs) / 2
+		for i := 0; i < n; i++ {
+			delete(pad.snapshots, pad.loadedEpochs[i])
+		}
+		pad.loadedEpochs = append(pad.loadedEpochs[:0], pad.loadedEpochs[n:]...)
+	}
+	pad.latestSTR = str
+	pad.snapshots[epoch] = str
 	pad.loadedEpochs = append(pad.loadedEpochs, epoch)
+	// the cross-signed STR hands the signing over to the new key
+	if pad.crossSignKey != nil {
+		pad.signKey = pad.crossSignKey
+		pad.crossSignKey = nil
+	}
 	if ad != nil { // update the `ad` if necessary
 		pad.ad = ad
 	}
+	// the hasher changes along with the `ad` declaring it
+	if pad.hasher != nil {
+		pad.tree.setHasher(pad.hasher)
+		pad.hasher = nil
+	}
+	// and the STR including this `ad` is cross-signed by the new key
+	if pad.nextSignKey != nil {
+		pad.crossSignKey = pad.nextSignKey
+		pad.nextSignKey = nil
+	}
+	return nil
 }
 
 // Update generates a new snapshot of the tree.
@@ -86,25 +146,207 @@ func (pad *PAD) updateInternal(ad AssocData, epoch uint64) {
 // Specifically, it extends the hash chain by issuing
 // a new signed tree root. It may remove some older signed tree roots from
 // memory if the cached PAD snapshots exceeded the maximum capacity.
+// It also clears the VRF outputs cached during the previous epoch.
 // ad should be nil if the PAD's associated data ad do not change.
-func (pad *PAD) Update(ad AssocData) {
-	// delete older str(s) as needed
-	if len(pad.loadedEpochs) == cap(pad.loadedEpochs) {
-		n := cap(pad.loadedEpochs) / 2
-		for i := 0; i < n; i++ {
-			delete(pad.snapshots, pad.loadedEpochs[i])
-		}
-		pad.loadedEpochs = append(pad.loadedEpochs[:0], pad.loadedEpochs[n:]...)
+//
+// If the PAD is persisted, the new STR is issued only once it has been
+// durably written to the database. If the write fails, Update()
+// returns the database's error and leaves the PAD unchanged: the
+// latest STR remains the STR of the previous epoch, and the update can
+// be retried.
+func (pad *PAD) Update(ad AssocData) error {
+	if err := pad.updateInternal(ad, pad.latestSTR.Epoch+1); err != nil {
+		return err
 	}
-	pad.updateInternal(ad, pad.latestSTR.Epoch+1)
+	pad.vrfCache.reset()
+	return nil
 }
 
 // Set computes the private index for the given key using
 // the current VRF private key to create a new index-to-value binding,
 // and inserts it into the PAD's underlying Merkle tree. This ensures
 // the index-to-value binding will be included in the next PAD snapshot.
+// If the PAD is persisted, the binding is appended to the WAL
+// before being inserted into the tree.
 func (pad *PAD) Set(key string, value []byte) error {
-	return pad.tree.Set(pad.Index(key), key, value)
+	return pad.SetWith(key, value, nil)
+}
+
+// SetWith is like Set(), but if the PAD is persisted, the pending
+// writes of b are written along with the binding's WAL entry,
+// atomically. b may be nil.
+func (pad *PAD) SetWith(key string, value []byte, b kv.Batch) error {
+	index := pad.Index(key)
+	leaf, err := newPersistedLeaf(index, key, value,
+		pad.latestSTR.Epoch+1, pad.saltKey)
+	if err != nil {
+		return err
+	}
+	if pad.store != nil {
+		if err := pad.store.logLeaf(leaf, b); err != nil {
+			return err
+		}
+	}
+	pad.tree.setLeaf(leaf)
+	return nil
+}
+
+// Bootstrap pre-populates an empty PAD with the given key-to-value
+// bindings, and reissues the STR for epoch 0 with the associated data
+// ad, so that the bindings are included in the PAD's initial snapshot
+// instead of the next one. The bindings' commitment salts are derived
+// for epoch 0 if the PAD has a salt key (see PAD.SetSaltKey()).
+// Bootstrap() returns ErrBootstrapped if the PAD has been updated,
+// persisted or populated since its creation, i.e., if the initial STR
+// may have been published.
+func (pad *PAD) Bootstrap(ad AssocData, bindings map[string][]byte) error {
+	if pad.latestSTR.Epoch != 0 || pad.store != nil || pad.Len() != 0 {
+		return ErrBootstrapped
+	}
+	for key, value := range bindings {
+		leaf, err := newPersistedLeaf(pad.Index(key), key, value, 0, pad.saltKey)
+		if err != nil {
+			return err
+		}
+		pad.tree.setLeaf(leaf)
+	}
+	pad.ad = ad
+	pad.latestSTR = nil
+	pad.latestSTR = pad.signTreeRoot(0)
+	pad.snapshots[0] = pad.latestSTR
+	return nil
+}
+
+// SetSTRExtensions sets the extensions included in each STR the PAD
+// issues from now on (see STRExtension). A nil exts removes all
+// extensions. The PAD keeps a reference to exts, which must not be
+// modified.
+func (pad *PAD) SetSTRExtensions(exts map[string]*STRExtension) {
+	pad.extensions = exts
+}
+
+// SetHashSize truncates the hashes of the nodes of the PAD's tree to
+// size bytes, e.g., to reduce the size of the authentication paths,
+// keeping the hash algorithm of the PAD's hasher (see SetHasher()).
+// It returns crypto.ErrUnsupportedHash if size isn't between
+// crypto.MinHashSizeByte and crypto.HashSizeByte.
+func (pad *PAD) SetHashSize(size int) error {
+	h := pad.hasher
+	if h == nil {
+		h = pad.tree.hasher
+	}
+	h, err := hashers.WithSize(h, size)
+	if err != nil {
+		return err
+	}
+	pad.SetHasher(h)
+	return nil
+}
+
+// SetHasher makes the PAD hash the nodes of its tree with the hasher
+// h, e.g., to use another hash algorithm than hashers.Default(), or to
+// reduce the size of the authentication paths with a truncated hash.
+// As for the associated data passed to the next Update(), the hasher
+// applies to the STRs issued after the next one.
+// The hasher must be declared in the associated data of the STRs
+// (e.g., see protocol.Policies.HashID), so that the clients verify the
+// authentication paths with the right hasher.
+func (pad *PAD) SetHasher(h hashers.PADHasher) {
+	pad.hasher = h
+}
+
+// HashSize returns the size in bytes of the hashes of the nodes of
+// the PAD's tree, which may differ from the size set by SetHashSize()
+// until the next Update().
+func (pad *PAD) HashSize() int {
+	return pad.tree.hasher.Size()
+}
+
+// Hasher returns the hasher of the nodes of the PAD's tree, which may
+// differ from the hasher set by SetHasher() until the next Update().
+func (pad *PAD) Hasher() hashers.PADHasher {
+	return pad.tree.hasher
+}
+
+// RotateSigningKey makes the PAD hand the signing of its STRs over to
+// key along with the ad passed to the next Update(), which should
+// declare the new key to the PAD's verifiers: the STR including this
+// ad is signed with both the PAD's signing key and key (see
+// SignedTreeRoot.CrossSignature), and the following STRs and messages
+// are only signed with key.
+//
+// If the PAD is persisted, the rotation is written to the database
+// along with both keys, so that a restored PAD resumes the handover
+// where it stopped (see RestorePAD()); RotateSigningKey() returns the
+// database's error otherwise. It returns ErrRotationPending if the
+// handover to a previous key is still in progress.
+func (pad *PAD) RotateSigningKey(key sign.PrivateKey) error {
+	if pad.nextSignKey != nil || pad.crossSignKey != nil {
+		return ErrRotationPending
+	}
+	if pad.store != nil {
+		if err := pad.store.logRotation(&rotation{
+			Epoch:       pad.latestSTR.Epoch,
+			SignKey:     pad.signKey,
+			NextSignKey: key,
+		}); err != nil {
+			return err
+		}
+	}
+	pad.nextSignKey = key
+	return nil
+}
+
+// PendingRotation returns the public key the PAD is handing the
+// signing of its STRs over to (see RotateSigningKey()), and whether
+// the next STR is already cross-signed by this key, i.e., whether the
+// PAD's current ad declares the key. PendingRotation returns a nil key
+// once the cross-signed STR has been issued.
+func (pad *PAD) PendingRotation() (key sign.PublicKey, crossSigned bool) {
+	switch {
+	case pad.nextSignKey != nil:
+		key, _ = pad.nextSignKey.Public()
+	case pad.crossSignKey != nil:
+		key, _ = pad.crossSignKey.Public()
+		crossSigned = true
+	}
+	return
+}
+
+// SetHashWorkers makes the PAD recompute the hash of its tree with up
+// to n goroutines when issuing an STR (see Update()), each hashing an
+// independent subtree, e.g., to make use of multiple cores when many
+// bindings are set in each epoch. A n less than 2 restores the
+// sequential hashing. The hash of the tree doesn't depend on n.
+func (pad *PAD) SetHashWorkers(n int) {
+	pad.hashWorkers = n
+}
+
+// SetSaltKey makes the PAD derive the commitment salt of each binding
+// set from now on from the master secret key, the epoch in which the
+// binding will be included and the binding's private index
+// (see crypto.DeriveSalt()), instead of generating a random salt.
+// A nil key restores random salts.
+// The PAD keeps a reference to key, which must not be modified.
+func (pad *PAD) SetSaltKey(key []byte) {
+	pad.saltKey = key
+}
+
+// AuditSalts checks the commitment salt of each binding in the PAD's
+// pending tree against the salt derived from the PAD's salt key
+// (see PAD.SetSaltKey()), and calls f for each binding whose salt
+// wasn't derived from the salt key, e.g., because the binding has been
+// set before the salt key.
+func (pad *PAD) AuditSalts(f func(key string, epoch uint64)) {
+	if pad.saltKey == nil {
+		return
+	}
+	pad.tree.visitLeafNodes(func(n *userLeafNode) {
+		salt := crypto.DeriveSalt(pad.saltKey, n.epoch, n.index)
+		if !bytes.Equal(salt, n.commitment.Salt) {
+			f(n.key, n.epoch)
+		}
+	})
 }
 
 // Lookup searches the requested key in the latest snapshot of the PAD,
@@ -124,12 +366,35 @@ func (pad *PAD) LookupInEpoch(key string, epoch uint64) (*AuthenticationPath, er
 	if str == nil {
 		return nil, ErrSTRNotFound
 	}
+	return pad.LookupInSTR(key, str), nil
+}
+
+// LookupInSTR searches the requested key in the snapshot of the
+// signed tree root str, which must have been issued by this PAD.
+// Since a snapshot is never modified once its STR has been issued,
+// LookupInSTR() is safe to call concurrently with Set() and Update().
+func (pad *PAD) LookupInSTR(key string, str *SignedTreeRoot) *AuthenticationPath {
 	// TODO: If the vrf key is rotated, we'd need to use the key
 	// corresponding to the `epoch` here.  See #120
 	lookupIndex, proof := pad.computePrivateIndex(key, pad.vrfKey)
 	ap := str.tree.Get(lookupIndex)
 	ap.VrfProof = proof
-	return ap, nil
+	return ap
+}
+
+// GetEmptyRangeInEpoch returns a proof that the snapshot at the
+// requested epoch doesn't contain any binding whose private index
+// starts with the first bits bits of prefix (see
+// MerkleTree.GetEmptyRange()).
+// It returns ErrSTRNotFound if the signed tree root of the requested
+// epoch has been removed from memory.
+func (pad *PAD) GetEmptyRangeInEpoch(prefix []byte, bits uint32,
+	epoch uint64) (*EmptyRangeProof, error) {
+	str := pad.GetSTR(epoch)
+	if str == nil {
+		return nil, ErrSTRNotFound
+	}
+	return str.tree.GetEmptyRange(prefix, bits)
 }
 
 // GetSTR returns the signed tree root of the requested epoch.
@@ -142,11 +407,58 @@ func (pad *PAD) GetSTR(epoch uint64) *SignedTreeRoot {
 	return pad.snapshots[epoch]
 }
 
+// LoadSTR returns the signed tree root of epoch, which must not be
+// greater than the latest epoch. If the snapshot of epoch has been
+// removed from memory, the STR is read from the PAD's database, if
+// persistence is enabled (see Persist()); such an STR doesn't include
+// the snapshot, so it can't be used to look up a key (see
+// LookupInEpoch()). LoadSTR() returns ErrSTRNotFound if the STR is
+// neither in memory nor in the database.
+func (pad *PAD) LoadSTR(epoch uint64) (*SignedTreeRoot, error) {
+	if str := pad.GetSTR(epoch); str != nil {
+		return str, nil
+	}
+	if pad.store == nil {
+		return nil, ErrSTRNotFound
+	}
+	return pad.store.loadSTR(epoch)
+}
+
 // LatestSTR returns the latest signed tree root of the PAD.
 func (pad *PAD) LatestSTR() *SignedTreeRoot {
 	return pad.latestSTR
 }
 
+// AssocData returns the associated data the PAD includes in its next
+// signed tree root, i.e., the associated data passed to the latest
+// Update().
+func (pad *PAD) AssocData() AssocData {
+	return pad.ad
+}
+
+// VisitPending calls f for each key-value binding which has been
+// set in the PAD since the latest snapshot, i.e., which will be
+// included in the next snapshot.
+func (pad *PAD) VisitPending(f func(key string, value []byte)) {
+	pad.tree.visitLeafNodes(func(n *userLeafNode) {
+		ap := pad.latestSTR.tree.Get(n.index)
+		if ap.Leaf.IsEmpty || !bytes.Equal(ap.Leaf.Index, n.index) ||
+			!bytes.Equal(ap.Leaf.Commitment.Value, n.commitment.Value) {
+			f(n.key, n.value)
+		}
+	})
+}
+
+// Len returns the number of key-value bindings in the PAD, including
+// the bindings pending inclusion in the next snapshot.
+func (pad *PAD) Len() uint64 {
+	var n uint64
+	pad.tree.visitLeafNodes(func(*userLeafNode) {
+		n++
+	})
+	return n
+}
+
 // Sign uses the _current_ signing key underlying the PAD to sign msg.
 func (pad *PAD) Sign(msg ...[]byte) []byte {
 	return pad.signKey.Sign(bytes.Join(msg, nil))
@@ -159,6 +471,12 @@ func (pad *PAD) Index(key string) []byte {
 	return index
 }
 
+// IndexWithProof is like Index(), but also returns the VRF proof of
+// the private index.
+func (pad *PAD) IndexWithProof(key string) (index, proof []byte) {
+	return pad.computePrivateIndex(key, pad.vrfKey)
+}
+
 // reshuffle recomputes indices of keys and store them with their values
 // in new tree with new new position; swaps pad.tree if everything worked
 // out. If there is any error on the way (lack of entropy for randomness)
@@ -168,6 +486,7 @@ func (pad *PAD) reshuffle() {
 	if err != nil {
 		panic(err)
 	}
+	newTree.hasher = pad.tree.hasher
 	pad.tree.visitLeafNodes(func(n *userLeafNode) {
 		if err := newTree.Set(pad.Index(n.key), n.key, n.value); err != nil {
 			panic(err)
@@ -176,7 +495,6 @@ func (pad *PAD) reshuffle() {
 	pad.tree = newTree
 }
 
-func (pad *PAD) computePrivateIndex(key string, vrfKey vrf.PrivateKey) (index, proof []byte) {
-	index, proof = vrfKey.Prove([]byte(key))
-	return
+func (pad *PAD) computePrivateIndex(key string, vrfKey vrf.VRF) (index, proof []byte) {
+	return pad.vrfCache.prove(key, vrfKey)
 }
@@ -210,6 +210,169 @@ func TestAssocDataChange(t *testing.T) {
 	}
 }
 
+func TestPADBootstrap(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindings := map[string][]byte{
+		"staff": []byte("staff key"),
+		"bot":   []byte("bot key"),
+	}
+	if err := pad.Bootstrap(TestAd{"seed"}, bindings); err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+	str := pad.LatestSTR()
+	if str.Epoch != 0 || pad.GetSTR(0) != str || len(pad.loadedEpochs) != 1 {
+		t.Fatal("Expect the bootstrapped STR to replace the initial STR")
+	}
+	if !bytes.Equal(str.Ad.Serialize(), []byte("seed")) ||
+		!pk.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Expect the bootstrapped STR to be signed with the new associated data")
+	}
+	for key, value := range bindings {
+		ap, err := pad.LookupInEpoch(key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ap.Verify([]byte(key), value, str.TreeHash); err != nil {
+			t.Fatal("Expect", key, "to be included at epoch 0, got", err)
+		}
+	}
+
+	// the initial STR cannot be replaced once populated or updated
+	if err := pad.Bootstrap(TestAd{""}, nil); err != ErrBootstrapped {
+		t.Fatal("Expect", ErrBootstrapped, "got", err)
+	}
+	pad, err = NewPAD(TestAd{""}, signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	if err := pad.Bootstrap(TestAd{""}, bindings); err != ErrBootstrapped {
+		t.Fatal("Expect", ErrBootstrapped, "got", err)
+	}
+}
+
+func TestPADRotateSigningKey(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPK, _ := signKey.Public()
+	newPK, _ := newKey.Public()
+
+	if err := pad.RotateSigningKey(newKey); err != nil {
+		t.Fatal(err)
+	}
+	// the STR of this update still includes the previous ad
+	pad.Update(TestAd{"handover"})
+	if err := pad.RotateSigningKey(signKey); err != ErrRotationPending {
+		t.Fatal("Expect", ErrRotationPending, "got", err)
+	}
+	pad.Update(TestAd{""})
+	pad.Update(nil)
+
+	for _, tc := range []struct {
+		epoch  uint64
+		signer sign.PublicKey
+		cross  bool
+	}{
+		{1, oldPK, false},
+		{2, oldPK, true},
+		{3, newPK, false},
+	} {
+		str := pad.GetSTR(tc.epoch)
+		if !tc.signer.Verify(str.Serialize(), str.Signature) {
+			t.Error("Expect STR", tc.epoch, "to be signed with the expected key")
+		}
+		cross := newPK.Verify(str.Serialize(), str.CrossSignature)
+		if cross != tc.cross || tc.cross != bytes.Equal(str.Ad.Serialize(), []byte("handover")) {
+			t.Error("Expect STR", tc.epoch, "cross-signed:", tc.cross)
+		}
+	}
+	if msg := []byte("msg"); !newPK.Verify(msg, pad.Sign(msg)) {
+		t.Error("Expect the messages to be signed with the new key")
+	}
+}
+
+func TestPADVRFCache(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := pad.Index("key")
+	if !bytes.Equal(pad.Index("key"), index) || len(pad.vrfCache.entries) != 1 {
+		t.Fatal("Expect the private index to be cached")
+	}
+
+	// the cache is cleared at each epoch
+	pad.Update(nil)
+	if len(pad.vrfCache.entries) != 0 {
+		t.Fatal("Expect the cache to be cleared")
+	}
+
+	// and invalidated when the VRF key changes
+	pad.Index("key")
+	newKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad.vrfKey = newKey
+	want, _ := newKey.Prove([]byte("key"))
+	if got := pad.Index("key"); !bytes.Equal(got, want) {
+		t.Fatal("Expect the index computed with the new VRF key")
+	}
+}
+
+// A blockingVRF blocks the proofs for the key "slow" until released.
+type blockingVRF struct {
+	vrf.VRF
+	proving chan struct{}
+	release chan struct{}
+}
+
+func (k *blockingVRF) Prove(m []byte) ([]byte, []byte) {
+	if string(m) == "slow" {
+		close(k.proving)
+		<-k.release
+	}
+	return k.VRF.Prove(m)
+}
+
+func TestPADVRFCacheConcurrentProofs(t *testing.T) {
+	key := &blockingVRF{
+		VRF:     vrfKey,
+		proving: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	pad, err := NewPAD(TestAd{""}, signKey, key, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []byte)
+	go func() {
+		done <- pad.Index("slow")
+	}()
+	<-key.proving
+	// the other keys are looked up while the slow proof is computed
+	if want := vrfKey.Compute([]byte("fast")); !bytes.Equal(pad.Index("fast"), want) {
+		t.Fatal("Expect the index of", "fast")
+	}
+	close(key.release)
+	if want := vrfKey.Compute([]byte("slow")); !bytes.Equal(<-done, want) {
+		t.Fatal("Expect the index of", "slow")
+	}
+	if len(pad.vrfCache.entries) != 2 {
+		t.Fatal("Expect both indices to be cached")
+	}
+}
+
 func TestNewPADMissingAssocData(t *testing.T) {
 	defer func() {
 		if r := recover(); r == nil {
@@ -268,18 +431,21 @@ func BenchmarkCreateLargePAD(b *testing.B) {
 //
 // Benchmarks which can be used produce data similar to Figure 7. in Section 5.
 //
-func BenchmarkPADUpdate100K(b *testing.B) { benchPADUpdate(b, 100000) }
-func BenchmarkPADUpdate500K(b *testing.B) { benchPADUpdate(b, 500000) }
+func BenchmarkPADUpdate100K(b *testing.B) { benchPADUpdate(b, 100000, 1) }
+func BenchmarkPADUpdate500K(b *testing.B) { benchPADUpdate(b, 500000, 1) }
 
 // make sure you have enough memory/cpu power if you want to run the benchmarks
 // below; also give the benchmarks enough time to finish using the -timeout flag
-func BenchmarkPADUpdate1M(b *testing.B)   { benchPADUpdate(b, 1000000) }
-func BenchmarkPADUpdate2_5M(b *testing.B) { benchPADUpdate(b, 2500000) }
-func BenchmarkPADUpdate5M(b *testing.B)   { benchPADUpdate(b, 5000000) }
-func BenchmarkPADUpdate7_5M(b *testing.B) { benchPADUpdate(b, 7500000) }
-func BenchmarkPADUpdate10M(b *testing.B)  { benchPADUpdate(b, 10000000) }
+func BenchmarkPADUpdate1M(b *testing.B)   { benchPADUpdate(b, 1000000, 1) }
+func BenchmarkPADUpdate2_5M(b *testing.B) { benchPADUpdate(b, 2500000, 1) }
+func BenchmarkPADUpdate5M(b *testing.B)   { benchPADUpdate(b, 5000000, 1) }
+func BenchmarkPADUpdate7_5M(b *testing.B) { benchPADUpdate(b, 7500000, 1) }
+func BenchmarkPADUpdate10M(b *testing.B)  { benchPADUpdate(b, 10000000, 1) }
+
+func BenchmarkPADUpdate100KParallel(b *testing.B) { benchPADUpdate(b, 100000, 4) }
+func BenchmarkPADUpdate1MParallel(b *testing.B)   { benchPADUpdate(b, 1000000, 4) }
 
-func benchPADUpdate(b *testing.B, entries uint64) {
+func benchPADUpdate(b *testing.B, entries uint64, workers int) {
 	keyPrefix := "key"
 	valuePrefix := []byte("value")
 	snapLen := uint64(10)
@@ -288,18 +454,9 @@ func benchPADUpdate(b *testing.B, entries uint64) {
 	if err != nil {
 		b.Fatal(err)
 	}
+	pad.SetHashWorkers(workers)
 	// build the tree once:
 	pad.Update(nil)
-
-	// Insert 1000 additional entries (as described in section 5.3):
-	var i uint64
-	for i = 0; i < 1000; i++ {
-		key := keyPrefix + string(i+entries)
-		value := append(valuePrefix, byte(i+entries))
-		if err := pad.Set(key, value); err != nil {
-			b.Fatal(err)
-		}
-	}
 	// clone current PAD's state:
 	orgTree := pad.tree.Clone()
 	b.ResetTimer()
@@ -308,6 +465,15 @@ func benchPADUpdate(b *testing.B, entries uint64) {
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
 		pad.tree = orgTree.Clone()
+		// Insert 1000 additional entries (as described in section 5.3):
+		var j uint64
+		for j = 0; j < 1000; j++ {
+			key := keyPrefix + strconv.FormatUint(j+entries, 10)
+			value := append(valuePrefix, byte(j+entries))
+			if err := pad.Set(key, value); err != nil {
+				b.Fatal(err)
+			}
+		}
 		b.StartTimer()
 		pad.Update(nil)
 	}
@@ -348,9 +514,9 @@ func benchPADLookup(b *testing.B, entries uint64) {
 		b.StopTimer()
 		var key string
 		if n < int(entries) {
-			key = keyPrefix + string(n)
+			key = keyPrefix + strconv.Itoa(n)
 		} else {
-			key = keyPrefix + string(n%int(entries))
+			key = keyPrefix + strconv.Itoa(n%int(entries))
 		}
 		b.StartTimer()
 		_, err := pad.Lookup(key)
@@ -360,6 +526,44 @@ func benchPADLookup(b *testing.B, entries uint64) {
 	}
 }
 
+// Benchmarks comparing repeated lookups and registrations of the same
+// name within an epoch, with and without the PAD's VRF cache.
+func BenchmarkPADLookupHotName(b *testing.B)        { benchPADHotName(b, false, false) }
+func BenchmarkPADLookupHotNameNoCache(b *testing.B) { benchPADHotName(b, false, true) }
+func BenchmarkPADRegisterHotName(b *testing.B)      { benchPADHotName(b, true, false) }
+func BenchmarkPADRegisterHotNameNoCache(b *testing.B) {
+	benchPADHotName(b, true, true)
+}
+
+func benchPADHotName(b *testing.B, register, noCache bool) {
+	pad, err := createPadSimple(1000, keyPrefix, valuePrefix, 10)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pad.Update(nil)
+	key := keyPrefix + "hot"
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if noCache {
+			b.StopTimer()
+			pad.vrfCache.reset()
+			b.StartTimer()
+		}
+		// a registration looks up the name, issues a TB
+		// and inserts the new binding
+		if _, err := pad.Lookup(key); err != nil {
+			b.Fatal(err)
+		}
+		if register {
+			pad.Index(key)
+			if err := pad.Set(key, valuePrefix); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
 // creates a PAD containing a tree with N entries (+ potential emptyLeafNodes)
 // each key value pair has the form (keyPrefix+string(i), valuePrefix+string(i))
 // for i = 0,...,N
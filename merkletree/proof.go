@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/utils"
 )
 
@@ -20,6 +21,10 @@ var (
 	// ErrUnequalTreeHashes indicates that the hash computed from the authentication path
 	// and the hash taken from the signed tree root are different.
 	ErrUnequalTreeHashes = errors.New("[merkletree] The hashes computed from the authentication path and the STR are unequal")
+	// ErrMalformedAuthPath indicates that the authentication path is
+	// missing its leaf node or the leaf's commitment, or that its
+	// indices or pruned tree are shorter than the leaf's level.
+	ErrMalformedAuthPath = errors.New("[merkletree] Malformed authentication path")
 )
 
 // ProofNode can be a user node or an empty node,
@@ -36,10 +41,10 @@ type ProofNode struct {
 	Commitment *crypto.Commit
 }
 
-func (n *ProofNode) hash(treeNonce []byte) []byte {
+func (n *ProofNode) hash(treeNonce []byte, h hashers.PADHasher) []byte {
 	if n.IsEmpty {
 		// empty leaf node
-		return crypto.Digest(
+		return h.Digest(
 			[]byte{EmptyBranchIdentifier},        // K_empty
 			[]byte(treeNonce),                    // K_n
 			[]byte(n.Index),                      // i
@@ -47,7 +52,7 @@ func (n *ProofNode) hash(treeNonce []byte) []byte {
 		)
 	} else {
 		// user leaf node
-		return crypto.Digest(
+		return h.Digest(
 			[]byte{LeafIdentifier},               // K_leaf
 			[]byte(treeNonce),                    // K_n
 			[]byte(n.Index),                      // i
@@ -67,6 +72,19 @@ const (
 	ProofOfInclusion
 )
 
+// A PrunedTree lists the hashes of the siblings of the nodes along
+// a path of the tree, from the root's children down. The hashes are
+// encoded in JSON as arrays of numbers rather than base64 strings,
+// which is the encoding of the clients decoding them into fixed-size
+// arrays of crypto.HashSizeByte bytes. encoding/json decodes both
+// encodings.
+type PrunedTree [][]byte
+
+// MarshalJSON implements the json.Marshaler interface.
+func (t PrunedTree) MarshalJSON() ([]byte, error) {
+	return utils.AppendJSONByteArrays(nil, t), nil
+}
+
 // AuthenticationPath is a pruned tree containing
 // the prefix path between the corresponding leaf node
 // (of type ProofNode) and the root. This is a proof
@@ -75,23 +93,25 @@ const (
 // equals the lookup index.
 type AuthenticationPath struct {
 	TreeNonce   []byte
-	PrunedTree  [][crypto.HashSizeByte]byte
+	PrunedTree  PrunedTree
 	LookupIndex []byte
 	VrfProof    []byte
 	Leaf        *ProofNode
 	proofType   ProofType
 }
 
-func (ap *AuthenticationPath) authPathHash() []byte {
-	hash := ap.Leaf.hash(ap.TreeNonce)
+// authPathHash recomputes the tree's root node from ap with the
+// hasher h.
+func (ap *AuthenticationPath) authPathHash(h hashers.PADHasher) []byte {
+	hash := ap.Leaf.hash(ap.TreeNonce, h)
 	indexBits := utils.ToBits(ap.Leaf.Index)
 	depth := ap.Leaf.Level
 	for depth > 0 {
 		depth -= 1
 		if indexBits[depth] { // right child
-			hash = crypto.Digest(ap.PrunedTree[depth][:], hash)
+			hash = h.Digest(ap.PrunedTree[depth], hash)
 		} else {
-			hash = crypto.Digest(hash, ap.PrunedTree[depth][:])
+			hash = h.Digest(hash, ap.PrunedTree[depth])
 		}
 	}
 	return hash
@@ -105,17 +125,40 @@ func (ap *AuthenticationPath) authPathHash() []byte {
 // Finally, it recomputes the tree's root node from ap,
 // and compares it to treeHash, which is taken from a STR.
 // Specifically, treeHash has to come from the STR whose tree returns ap.
+// The tree's nodes are hashed with the default hasher, truncated to
+// the size of treeHash, which must have been checked against the hash
+// size declared in the STR's policies (see VerifyWith()).
 //
 // This should be called after the VRF index is verified successfully.
+// Verify returns ErrMalformedAuthPath instead of panicking if ap isn't
+// well-formed, so that it can be called on untrusted input.
 func (ap *AuthenticationPath) Verify(key, value, treeHash []byte) error {
+	return ap.VerifyWith(defaultHasher(treeHash), key, value, treeHash)
+}
+
+// defaultHasher returns the default hasher truncated to the size of
+// treeHash, with which the methods verifying a proof against treeHash
+// hash the tree's nodes unless a hasher is passed. If the size isn't
+// valid, the proof is rejected as malformed, since the size of
+// treeHash then differs from the size of the returned hasher.
+func defaultHasher(treeHash []byte) hashers.PADHasher {
+	if h, err := hashers.WithSize(hashers.Default(), len(treeHash)); err == nil {
+		return h
+	}
+	return hashers.Default()
+}
+
+// VerifyWith is Verify(), but hashes the tree's nodes with the hasher
+// h, which has to be the hasher declared in the policies of the STR
+// (see hashers.NewPADHasher()). It returns ErrMalformedAuthPath if the
+// size of treeHash isn't the size of h.
+func (ap *AuthenticationPath) VerifyWith(h hashers.PADHasher, key, value, treeHash []byte) error {
+	if len(treeHash) != h.Size() || !ap.wellFormed(len(treeHash)) {
+		return ErrMalformedAuthPath
+	}
 	if ap.ProofType() == ProofOfAbsence {
-		// Check if i and j match in the first l bits
-		indexBits := utils.ToBits(ap.Leaf.Index)
-		lookupIndexBits := utils.ToBits(ap.LookupIndex)
-		for i := 0; i < int(ap.Leaf.Level); i++ {
-			if indexBits[i] != lookupIndexBits[i] {
-				return ErrIndicesMismatch
-			}
+		if !ap.matchesLookupIndex() {
+			return ErrIndicesMismatch
 		}
 		// expect the value is nil since we suppressed
 		// the salt & value (see Get())
@@ -132,17 +175,56 @@ func (ap *AuthenticationPath) Verify(key, value, treeHash []byte) error {
 		}
 	}
 
-	if !bytes.Equal(treeHash, ap.authPathHash()) {
+	if !bytes.Equal(treeHash, ap.authPathHash(h)) {
 		return ErrUnequalTreeHashes
 	}
 	return nil
 }
 
+// matchesLookupIndex returns true if the leaf index and the lookup
+// index match in the first l bits, with l the Level of the leaf.
+func (ap *AuthenticationPath) matchesLookupIndex() bool {
+	indexBits := utils.ToBits(ap.Leaf.Index)
+	lookupIndexBits := utils.ToBits(ap.LookupIndex)
+	for i := 0; i < int(ap.Leaf.Level); i++ {
+		if indexBits[i] != lookupIndexBits[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// wellFormed checks that all fields of ap that Verify() dereferences
+// or indexes are present and long enough for the leaf's level, and
+// that the hashes of the pruned tree have the size hashSize.
+func (ap *AuthenticationPath) wellFormed(hashSize int) bool {
+	if ap == nil || ap.Leaf == nil || !crypto.ValidHashSize(hashSize) {
+		return false
+	}
+	level := int(ap.Leaf.Level)
+	if level > len(ap.PrunedTree) || level > len(ap.Leaf.Index)*8 ||
+		level > len(ap.LookupIndex)*8 {
+		return false
+	}
+	for _, hash := range ap.PrunedTree[:level] {
+		if len(hash) != hashSize {
+			return false
+		}
+	}
+	return ap.Leaf.IsEmpty || ap.Leaf.Commitment != nil
+}
+
 // ProofType returns the type of ap. It does a comparison
 // between the leaf index and the lookup index to determine
 // the proof type, and sets ap's proof type the first time this
 // method called, memoizing the proof type for subsequent calls.
+// The type of an authentication path without a leaf node is
+// undetermined, i.e., it is neither a ProofOfAbsence nor
+// a ProofOfInclusion.
 func (ap *AuthenticationPath) ProofType() ProofType {
+	if ap == nil || ap.Leaf == nil {
+		return undeterminedProof
+	}
 	if ap.proofType == undeterminedProof {
 		if bytes.Equal(ap.LookupIndex, ap.Leaf.Index) {
 			ap.proofType = ProofOfInclusion
@@ -2,11 +2,14 @@ package merkletree
 
 import (
 	"bytes"
+	"encoding/json"
 	"math/rand"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/utils"
 )
 
@@ -148,3 +151,158 @@ func TestProofVerificationErrors(t *testing.T) {
 		t.Error("Expect", ErrIndicesMismatch, "got", err)
 	}
 }
+
+func TestVerifyMalformedProof(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	index, key, value := tuple[0].index, tuple[0].key, tuple[0].value
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(ap *AuthenticationPath)
+	}{
+		{"no leaf", func(ap *AuthenticationPath) { ap.Leaf = nil }},
+		{"no commitment", func(ap *AuthenticationPath) { ap.Leaf.Commitment = nil }},
+		{"short pruned tree", func(ap *AuthenticationPath) { ap.PrunedTree = ap.PrunedTree[:0] }},
+		{"short lookup index", func(ap *AuthenticationPath) { ap.LookupIndex = nil }},
+		{"truncated pruned tree", func(ap *AuthenticationPath) { ap.PrunedTree[0] = ap.PrunedTree[0][:16] }},
+	} {
+		proof := m.Get(index)
+		tc.tamper(proof)
+		if err := proof.Verify([]byte(key), value, m.hash); err != ErrMalformedAuthPath {
+			t.Error(tc.name, "expect", ErrMalformedAuthPath, "got", err)
+		}
+	}
+	var nilProof *AuthenticationPath
+	if nilProof.ProofType() == ProofOfAbsence || nilProof.ProofType() == ProofOfInclusion {
+		t.Error("Expect an undetermined proof type")
+	}
+}
+
+func TestVerifyProofTruncatedHashes(t *testing.T) {
+	m, tests := setupTestProofs(t)
+	m.setHasher(truncatedHasher(t, crypto.MinHashSizeByte))
+	m.recomputeHash()
+	if len(m.hash) != crypto.MinHashSizeByte {
+		t.Fatal("Expect", crypto.MinHashSizeByte, "got", len(m.hash))
+	}
+
+	for _, tt := range tests {
+		proof := m.Get(tt.index)
+		if len(proof.PrunedTree[0]) != crypto.MinHashSizeByte {
+			t.Fatal("Expect", crypto.MinHashSizeByte, "got", len(proof.PrunedTree[0]))
+		}
+		if err := proof.Verify([]byte(tt.key), tt.value, m.hash); err != nil {
+			t.Error(tt.key, "expect", nil, "got", err)
+		}
+	}
+
+	// the hashes can't be truncated below the minimum size
+	proof := m.Get(tests[0].index)
+	for i := range proof.PrunedTree {
+		proof.PrunedTree[i] = proof.PrunedTree[i][:8]
+	}
+	if err := proof.Verify([]byte(tests[0].key), tests[0].value, m.hash[:8]); err != ErrMalformedAuthPath {
+		t.Error("Expect", ErrMalformedAuthPath, "got", err)
+	}
+	pad := StaticPAD(t, TestAd{"abc"})
+	if err := pad.SetHashSize(8); err != crypto.ErrUnsupportedHash {
+		t.Error("Expect", crypto.ErrUnsupportedHash, "got", err)
+	}
+}
+
+func TestVerifyProofWithHasher(t *testing.T) {
+	m, tests := setupTestProofs(t)
+	for _, id := range []string{hashers.SHA256, hashers.BLAKE2b, "BLAKE2b/128"} {
+		h, err := hashers.NewPADHasher(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.setHasher(h)
+		m.recomputeHash()
+		other, _ := hashers.WithSize(hashers.Default(), h.Size())
+		for _, tt := range tests {
+			proof := m.Get(tt.index)
+			if err := proof.VerifyWith(h, []byte(tt.key), tt.value, m.hash); err != nil {
+				t.Error(id, tt.key, "expect", nil, "got", err)
+			}
+			// the clients have to verify with the STR's hasher
+			if err := proof.Verify([]byte(tt.key), tt.value, m.hash); err != ErrUnequalTreeHashes {
+				t.Error(id, tt.key, "expect", ErrUnequalTreeHashes, "got", err)
+			}
+			if err := proof.VerifyWith(other, []byte(tt.key), tt.value, m.hash); err != ErrUnequalTreeHashes {
+				t.Error(id, tt.key, "expect", ErrUnequalTreeHashes, "got", err)
+			}
+		}
+		// the hasher's size has to match the tree hash
+		if h.Size() != crypto.HashSizeByte {
+			proof := m.Get(tests[0].index)
+			if err := proof.VerifyWith(hashers.Default(), []byte(tests[0].key),
+				tests[0].value, m.hash); err != ErrMalformedAuthPath {
+				t.Error(id, "expect", ErrMalformedAuthPath, "got", err)
+			}
+		}
+	}
+}
+
+// plainAuthPath is encoded by the reflection of encoding/json.
+type plainAuthPath AuthenticationPath
+
+func TestAuthenticationPathJSON(t *testing.T) {
+	m, tests := setupTestProofs(t)
+	proofs := []*AuthenticationPath{{}, {PrunedTree: [][]byte{nil, {}}, Leaf: &ProofNode{}}}
+	for _, tt := range tests {
+		proofs = append(proofs, m.Get(tt.index))
+	}
+	for _, ap := range proofs {
+		want, err := json.Marshal((*plainAuthPath)(ap))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(ap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Error("Expect", string(want), "got", string(got))
+		}
+	}
+	if got := (*AuthenticationPath)(nil).AppendJSON(nil); string(got) != "null" {
+		t.Error("Expect null", "got", string(got))
+	}
+}
+
+func TestPrunedTreeJSON(t *testing.T) {
+	m, tests := setupTestProofs(t)
+	ap := m.Get(tests[0].index)
+	buf, err := json.Marshal(ap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the clients decoding the hashes into fixed-size arrays
+	var fixed struct {
+		PrunedTree [][crypto.HashSizeByte]byte
+	}
+	if err := json.Unmarshal(buf, &fixed); err != nil {
+		t.Fatal(err)
+	}
+	if len(fixed.PrunedTree) != len(ap.PrunedTree) ||
+		!bytes.Equal(fixed.PrunedTree[0][:], ap.PrunedTree[0]) {
+		t.Fatal("Expect", ap.PrunedTree, "got", fixed.PrunedTree)
+	}
+	got := new(AuthenticationPath)
+	if err := json.Unmarshal(buf, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Verify([]byte(tests[0].key), tests[0].value, m.hash) != nil {
+		t.Fatal("Expect the decoded proof to verify")
+	}
+	// the hashes encoded as base64 strings are decoded as well
+	var hashes PrunedTree
+	if err := json.Unmarshal([]byte(`["AQID",[4,5]]`), &hashes); err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || !bytes.Equal(hashes[0], []byte{1, 2, 3}) ||
+		!bytes.Equal(hashes[1], []byte{4, 5}) {
+		t.Fatal("Unexpected hashes", hashes)
+	}
+}
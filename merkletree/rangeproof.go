@@ -0,0 +1,170 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// MaxRangePrefixBits is the maximum length in bits of the index prefix
+// of an EmptyRangeProof. It bounds the size of the proof, which
+// contains at most one hash per bit of the prefix.
+const MaxRangePrefixBits = 32
+
+var (
+	// ErrRangeNotEmpty indicates that the tree contains a leaf whose
+	// index starts with the requested prefix, so that the absence of
+	// leaves in this range cannot be proven.
+	ErrRangeNotEmpty = errors.New("[merkletree] The index range is not empty")
+	// ErrMalformedRange indicates that an index prefix is empty, or
+	// longer than MaxRangePrefixBits or than the prefix's bytes.
+	ErrMalformedRange = errors.New("[merkletree] Malformed index range")
+)
+
+// An EmptyRangeProof proves that a tree doesn't contain any leaf whose
+// index starts with the first PrefixBits bits of Prefix.
+// It is a pruned tree containing the path between the root and the
+// node at which the prefix leaves the tree: either an empty branch
+// whose index is a prefix of the range's prefix, or a user leaf whose
+// index shares the first Level bits with the prefix, but not the whole
+// prefix. The leaf's value and commitment salt are omitted, as in
+// a proof of absence (see MerkleTree.Get()).
+type EmptyRangeProof struct {
+	Prefix     []byte
+	PrefixBits uint32
+	TreeNonce  []byte
+	PrunedTree PrunedTree
+	Leaf       *ProofNode
+}
+
+// validRange returns true if the first bits of prefix form a valid
+// index range. The whole index space isn't a valid range, since the
+// root node is never empty.
+func validRange(prefix []byte, bits uint32) bool {
+	return bits > 0 && bits <= MaxRangePrefixBits && int(bits) <= len(prefix)*8
+}
+
+// GetEmptyRange returns an EmptyRangeProof proving that the tree m
+// doesn't contain any leaf whose index starts with the first bits bits
+// of prefix. It returns ErrRangeNotEmpty if the tree contains such
+// a leaf, and ErrMalformedRange if bits is 0, or greater than
+// MaxRangePrefixBits or than the length of prefix in bits.
+func (m *MerkleTree) GetEmptyRange(prefix []byte, bits uint32) (*EmptyRangeProof, error) {
+	if !validRange(prefix, bits) {
+		return nil, ErrMalformedRange
+	}
+	prefixBits := utils.ToBits(prefix)
+	proof := &EmptyRangeProof{
+		Prefix:     prefix,
+		PrefixBits: bits,
+		TreeNonce:  m.nonce,
+	}
+
+	var nodePointer merkleNode = m.root
+	for depth := uint32(0); ; depth++ {
+		switch n := nodePointer.(type) {
+		case *emptyNode:
+			proof.Leaf = &ProofNode{
+				Level:   n.level,
+				Index:   n.index,
+				IsEmpty: true,
+			}
+			return proof, nil
+		case *userLeafNode:
+			if hasPrefix(n.index, prefixBits[:bits]) {
+				return nil, ErrRangeNotEmpty
+			}
+			proof.Leaf = &ProofNode{
+				Level: n.level,
+				Index: n.index,
+				Commitment: &crypto.Commit{
+					Value: n.commitment.Value,
+				},
+			}
+			return proof, nil
+		case *interiorNode:
+			if depth == bits {
+				// all leaves below the node are in the range
+				return nil, ErrRangeNotEmpty
+			}
+			var hash []byte
+			if prefixBits[depth] {
+				hash = append(hash, n.leftHash...)
+				nodePointer = n.rightChild
+			} else {
+				hash = append(hash, n.rightHash...)
+				nodePointer = n.leftChild
+			}
+			proof.PrunedTree = append(proof.PrunedTree, hash)
+		default:
+			panic(ErrInvalidTree)
+		}
+	}
+}
+
+// Verify checks that the proof p is well-formed, that its node is
+// where the prefix leaves the tree (see EmptyRangeProof), and
+// recomputes the tree's root node from p, which it compares to
+// treeHash, taken from the STR of the tree which returned p, using
+// hashes of the size of treeHash (see AuthenticationPath.Verify()).
+// Verify returns ErrMalformedRange if the range is malformed,
+// ErrMalformedAuthPath if p is otherwise malformed, ErrIndicesMismatch
+// if the node's index doesn't share its first Level bits with the
+// prefix, ErrRangeNotEmpty if the node is a leaf in the range, and
+// ErrUnequalTreeHashes if the hashes don't match.
+func (p *EmptyRangeProof) Verify(treeHash []byte) error {
+	return p.VerifyWith(defaultHasher(treeHash), treeHash)
+}
+
+// VerifyWith is Verify(), but hashes the tree's nodes with the hasher h
+// (see AuthenticationPath.VerifyWith()).
+func (p *EmptyRangeProof) VerifyWith(h hashers.PADHasher, treeHash []byte) error {
+	if p == nil || !validRange(p.Prefix, p.PrefixBits) {
+		return ErrMalformedRange
+	}
+	n := p.Leaf
+	if n == nil || int(n.Level) != len(p.PrunedTree) ||
+		n.Level > p.PrefixBits || int(n.Level) > len(n.Index)*8 ||
+		(!n.IsEmpty && (n.Commitment == nil || n.Value != nil)) ||
+		len(treeHash) != h.Size() || !crypto.ValidHashSize(len(treeHash)) {
+		return ErrMalformedAuthPath
+	}
+	for _, hash := range p.PrunedTree {
+		if len(hash) != len(treeHash) {
+			return ErrMalformedAuthPath
+		}
+	}
+	prefixBits := utils.ToBits(p.Prefix)[:p.PrefixBits]
+	if !hasPrefix(n.Index, prefixBits[:n.Level]) {
+		return ErrIndicesMismatch
+	}
+	if !n.IsEmpty && hasPrefix(n.Index, prefixBits) {
+		return ErrRangeNotEmpty
+	}
+
+	ap := &AuthenticationPath{
+		TreeNonce:  p.TreeNonce,
+		PrunedTree: p.PrunedTree,
+		Leaf:       n,
+	}
+	if !bytes.Equal(treeHash, ap.authPathHash(h)) {
+		return ErrUnequalTreeHashes
+	}
+	return nil
+}
+
+// hasPrefix returns true if the index starts with the bits prefix.
+func hasPrefix(index []byte, prefix []bool) bool {
+	if len(prefix) > len(index)*8 {
+		return false
+	}
+	for i, bit := range prefix {
+		if utils.GetNthBit(index, uint32(i)) != bit {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package merkletree
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// prefixOf returns the first bits bits of index, flipping the last one
+// if flip is set.
+func prefixOf(index []byte, bits int, flip bool) []byte {
+	prefix := utils.ToBits(index)[:bits]
+	if flip {
+		prefix[bits-1] = !prefix[bits-1]
+	}
+	return utils.ToBytes(prefix)
+}
+
+func TestEmptyRangeProof(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	index := tuple[0].index
+	level := int(m.Get(index).Leaf.Level)
+
+	for _, tc := range []struct {
+		name   string
+		prefix []byte
+		bits   int
+		want   error
+	}{
+		{"leaf in range", prefixOf(index, level, false), level, ErrRangeNotEmpty},
+		{"subtree in range", prefixOf(index, level-1, false), level - 1, ErrRangeNotEmpty},
+		{"next to leaf", prefixOf(index, level+1, true), level + 1, nil},
+		{"below leaf", prefixOf(index, level+4, true), level + 4, nil},
+		{"whole index space", prefixOf(index, 1, false), 0, ErrMalformedRange},
+		{"too long", prefixOf(index, MaxRangePrefixBits+1, false), MaxRangePrefixBits + 1, ErrMalformedRange},
+	} {
+		proof, err := m.GetEmptyRange(tc.prefix, uint32(tc.bits))
+		if err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if err := proof.Verify(m.hash); err != nil {
+			t.Error(tc.name, "expect", nil, "got", err)
+		}
+		if len(proof.PrunedTree) > tc.bits {
+			t.Error(tc.name, "expect at most", tc.bits, "hashes, got", len(proof.PrunedTree))
+		}
+	}
+}
+
+func TestEmptyRangeProofVerificationErrors(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	index := tuple[0].index
+	level := int(m.Get(index).Leaf.Level)
+
+	// the range below the leaf is proven by the leaf itself
+	proof, err := m.GetEmptyRange(prefixOf(index, level+4, true), uint32(level+4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Leaf.IsEmpty {
+		t.Fatal("Expect the proof to end at the leaf")
+	}
+
+	// a leaf in the range doesn't prove its absence
+	proof.Prefix = prefixOf(index, level+4, false)
+	if err := proof.Verify(m.hash); err != ErrRangeNotEmpty {
+		t.Error("Expect", ErrRangeNotEmpty, "got", err)
+	}
+	// the node must share the beginning of the prefix
+	proof.Prefix = prefixOf(index, level, true)
+	proof.PrefixBits = uint32(level)
+	if err := proof.Verify(m.hash); err != ErrIndicesMismatch {
+		t.Error("Expect", ErrIndicesMismatch, "got", err)
+	}
+	proof.Prefix = prefixOf(index, level+4, true)
+	proof.PrefixBits = uint32(level + 4)
+	proof.PrunedTree = proof.PrunedTree[1:]
+	if err := proof.Verify(m.hash); err != ErrMalformedAuthPath {
+		t.Error("Expect", ErrMalformedAuthPath, "got", err)
+	}
+	var nilProof *EmptyRangeProof
+	if err := nilProof.Verify(m.hash); err != ErrMalformedRange {
+		t.Error("Expect", ErrMalformedRange, "got", err)
+	}
+
+	proof, _ = m.GetEmptyRange(prefixOf(index, level+4, true), uint32(level+4))
+	proof.Leaf.Commitment.Value[0]++
+	if err := proof.Verify(m.hash); err != ErrUnequalTreeHashes {
+		t.Error("Expect", ErrUnequalTreeHashes, "got", err)
+	}
+	proof.Leaf.Commitment.Value[0]--
+}
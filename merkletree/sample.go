@@ -0,0 +1,84 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+)
+
+// ErrMalformedIndex indicates that a sampled lookup index
+// isn't the size of a VRF output.
+var ErrMalformedIndex = errors.New("[merkletree] Malformed lookup index")
+
+// Sample returns the authentication path of the tree m for the lookup
+// index, as Get() does, but omits the value and commitment salt of the
+// leaf even if the leaf's index is the lookup index. Sampling a random
+// index thus reveals no binding of the tree, but the returned path
+// still commits to the tree's root (see AuthenticationPath.VerifySample()).
+// The index must be vrf.Size bytes long.
+func (m *MerkleTree) Sample(index []byte) *AuthenticationPath {
+	ap := m.Get(index)
+	if !ap.Leaf.IsEmpty {
+		ap.Leaf.Value = nil
+		ap.Leaf.Commitment.Salt = nil
+	}
+	return ap
+}
+
+// SampleInEpoch returns the authentication paths of the snapshot at
+// the requested epoch for each of the lookup indices (see
+// MerkleTree.Sample()), in order.
+// It returns ErrMalformedIndex if an index isn't vrf.Size bytes long,
+// and ErrSTRNotFound if the signed tree root of the requested epoch has
+// been removed from memory.
+func (pad *PAD) SampleInEpoch(indices [][]byte, epoch uint64) ([]*AuthenticationPath, error) {
+	for _, index := range indices {
+		if len(index) != vrf.Size {
+			return nil, ErrMalformedIndex
+		}
+	}
+	str := pad.GetSTR(epoch)
+	if str == nil {
+		return nil, ErrSTRNotFound
+	}
+	aps := make([]*AuthenticationPath, 0, len(indices))
+	for _, index := range indices {
+		aps = append(aps, str.tree.Sample(index))
+	}
+	return aps, nil
+}
+
+// VerifySample verifies an authentication path returned by
+// MerkleTree.Sample(), without knowing the binding of its leaf, if any.
+// It checks that the leaf is the node of the tree in which the lookup
+// index ends, i.e., that the indices match in the first l bits with l
+// the Level of the leaf, and that the leaf's value is omitted. Finally,
+// it recomputes the tree's root node from ap, and compares it to
+// treeHash, as Verify() does.
+//
+// An auditor sampling random indices thus checks that the tree of an
+// STR actually contains the nodes the directory serves, without
+// learning any binding.
+func (ap *AuthenticationPath) VerifySample(treeHash []byte) error {
+	return ap.VerifySampleWith(defaultHasher(treeHash), treeHash)
+}
+
+// VerifySampleWith is VerifySample(), but hashes the tree's nodes with
+// the hasher h (see VerifyWith()).
+func (ap *AuthenticationPath) VerifySampleWith(h hashers.PADHasher, treeHash []byte) error {
+	if len(treeHash) != h.Size() || !ap.wellFormed(len(treeHash)) {
+		return ErrMalformedAuthPath
+	}
+	if !ap.matchesLookupIndex() {
+		return ErrIndicesMismatch
+	}
+	if ap.Leaf.Value != nil {
+		return ErrBindingsDiffer
+	}
+	if !bytes.Equal(treeHash, ap.authPathHash(h)) {
+		return ErrUnequalTreeHashes
+	}
+	return nil
+}
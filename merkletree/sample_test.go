@@ -0,0 +1,76 @@
+package merkletree
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+func TestSample(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	random, err := crypto.MakeRand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an included index, an index sharing its prefix, and a random one
+	for _, index := range [][]byte{tuple[0].index, tuple[len(tuple)-1].index, random} {
+		ap := m.Sample(index)
+		if ap.Leaf.Value != nil || (!ap.Leaf.IsEmpty && ap.Leaf.Commitment.Salt != nil) {
+			t.Fatal("Expect the sampled leaf's binding to be omitted")
+		}
+		if err := ap.VerifySample(m.hash); err != nil {
+			t.Fatal("Expect", nil, "got", err)
+		}
+	}
+
+	ap := m.Sample(tuple[0].index)
+	ap.LookupIndex = append([]byte{}, ap.LookupIndex...)
+	ap.LookupIndex[0] ^= 0x80
+	if err := ap.VerifySample(m.hash); err != ErrIndicesMismatch {
+		t.Fatal("Expect", ErrIndicesMismatch, "got", err)
+	}
+	ap = m.Sample(tuple[0].index)
+	ap.Leaf.Commitment.Value = append([]byte{}, ap.Leaf.Commitment.Value...)
+	ap.Leaf.Commitment.Value[0] ^= 1
+	if err := ap.VerifySample(m.hash); err != ErrUnequalTreeHashes {
+		t.Fatal("Expect", ErrUnequalTreeHashes, "got", err)
+	}
+	ap = m.Get(tuple[0].index)
+	if err := ap.VerifySample(m.hash); err != ErrBindingsDiffer {
+		t.Fatal("Expect", ErrBindingsDiffer, "got", err)
+	}
+}
+
+func TestPADSampleInEpoch(t *testing.T) {
+	pad, err := NewPAD(TestAd{""}, signKey, vrfKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	index := pad.Index("key")
+	if _, err := pad.SampleInEpoch([][]byte{index[:1]}, 1); err != ErrMalformedIndex {
+		t.Fatal("Expect", ErrMalformedIndex, "got", err)
+	}
+	aps, err := pad.SampleInEpoch([][]byte{index}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aps) != 1 || aps[0].ProofType() != ProofOfInclusion {
+		t.Fatal("Expect the path of the included leaf")
+	}
+	if err := aps[0].VerifySample(pad.GetSTR(1).TreeHash); err != nil {
+		t.Fatal(err)
+	}
+	// the leaf was absent at epoch 0
+	aps, err = pad.SampleInEpoch([][]byte{index}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aps[0].VerifySample(pad.GetSTR(0).TreeHash); err != nil ||
+		aps[0].ProofType() != ProofOfAbsence {
+		t.Fatal("Expect a verified proof of absence, got", err)
+	}
+}
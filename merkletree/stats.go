@@ -0,0 +1,46 @@
+package merkletree
+
+// TreeStats describes the shape of the tree of the STR of Epoch in
+// aggregate, e.g., for research on the growth of a directory.
+// It includes neither the index nor the commitment of any leaf, so it
+// reveals no binding of the tree.
+// Leaves is the number of user leaves of the tree. Depths maps each
+// depth to the number of user leaves at that depth, and ProofSizes
+// maps each size in bytes of the pruned tree of an authentication path
+// to the number of user leaves whose authentication path has that size.
+type TreeStats struct {
+	Epoch      uint64
+	Leaves     uint64
+	Depths     map[uint32]uint64
+	ProofSizes map[int]uint64
+}
+
+// Stats returns the TreeStats of the tree m.
+// The pruned tree of the authentication path of a leaf contains one
+// hash per level above the leaf, so its size is the leaf's depth times
+// the size of the tree's hashes (see PAD.SetHasher()).
+func (m *MerkleTree) Stats() *TreeStats {
+	stats := &TreeStats{
+		Depths:     make(map[uint32]uint64),
+		ProofSizes: make(map[int]uint64),
+	}
+	m.visitLeafNodes(func(n *userLeafNode) {
+		stats.Leaves++
+		stats.Depths[n.level]++
+		stats.ProofSizes[int(n.level)*m.hasher.Size()]++
+	})
+	return stats
+}
+
+// StatsInEpoch returns the TreeStats of the snapshot at the
+// requested epoch, or ErrSTRNotFound if the snapshot has been
+// removed from memory.
+func (pad *PAD) StatsInEpoch(epoch uint64) (*TreeStats, error) {
+	str := pad.GetSTR(epoch)
+	if str == nil {
+		return nil, ErrSTRNotFound
+	}
+	stats := str.tree.Stats()
+	stats.Epoch = str.Epoch
+	return stats, nil
+}
@@ -0,0 +1,47 @@
+package merkletree
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	stats := m.Stats()
+	if stats.Leaves != uint64(N) {
+		t.Fatal("Expect", N, "leaves, got", stats.Leaves)
+	}
+	for _, mp := range tuple[:N] {
+		ap := m.Get(mp.index)
+		if stats.Depths[ap.Leaf.Level] == 0 ||
+			stats.ProofSizes[len(ap.PrunedTree)*m.hasher.Size()] == 0 {
+			t.Fatal("Expect the depth and proof size of", mp.key, "to be counted")
+		}
+	}
+	var leaves uint64
+	for _, n := range stats.Depths {
+		leaves += n
+	}
+	if leaves != stats.Leaves {
+		t.Fatal("Expect the depth histogram to count", stats.Leaves, "leaves, got", leaves)
+	}
+}
+
+func TestStatsInEpoch(t *testing.T) {
+	pad, err := NewPAD(TestAd{"abc"}, signKey, vrfKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set(keyPrefix, valuePrefix); err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	stats, err := pad.StatsInEpoch(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Epoch != 1 || stats.Leaves != 1 || stats.Depths[1] != 1 {
+		t.Fatal("Expect a single leaf at depth", 1, "got", stats)
+	}
+	pad.Update(nil)
+	if _, err := pad.StatsInEpoch(0); err != ErrSTRNotFound {
+		t.Fatal("Expect", ErrSTRNotFound, "got", err)
+	}
+}
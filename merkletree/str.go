@@ -2,6 +2,7 @@ package merkletree
 
 import (
 	"bytes"
+	"sort"
 
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
@@ -13,6 +14,22 @@ type AssocData interface {
 	Serialize() []byte
 }
 
+// STRVersion is the version of the structured STR header which
+// NewSTR() signs. Version 0 denotes the unversioned serialization of
+// the STRs issued before the header was introduced, which can still
+// be verified but doesn't commit to any extensions.
+const STRVersion = 1
+
+// An STRExtension is an additional field of a signed tree root, e.g.,
+// an auditor's countersignature or a timestamp, which is committed to
+// by the STR's signature.
+// A verifier which doesn't understand an extension must reject the STR
+// if the extension is Critical, and may ignore it otherwise.
+type STRExtension struct {
+	Critical bool
+	Value    []byte
+}
+
 // SignedTreeRoot represents a signed tree root (STR), which is generated
 // at the beginning of every epoch.
 // Signed tree roots contain the current root node,
@@ -20,6 +37,11 @@ type AssocData interface {
 // previous STR, its signature, and developer-specified associated data.
 // The epoch number is a counter from 0, and increases by 1
 // when a new signed tree root is issued by the PAD.
+// Version is the version of the STR's header, and Extensions maps the
+// names of the STR's extensions to their values.
+// CrossSignature is the signature of the STR by the successor of the
+// PAD's signing key, if the STR hands the signing over to this key
+// (see PAD.RotateSigningKey()), and is empty otherwise.
 type SignedTreeRoot struct {
 	tree            *MerkleTree
 	TreeHash        []byte
@@ -27,13 +49,24 @@ type SignedTreeRoot struct {
 	PreviousEpoch   uint64
 	PreviousSTRHash []byte
 	Signature       []byte
-	Ad              AssocData `json:"-"`
+	Ad              AssocData                `json:"-"`
+	Version         uint32                   `json:",omitempty"`
+	Extensions      map[string]*STRExtension `json:",omitempty"`
+	CrossSignature  []byte                   `json:",omitempty"`
 }
 
 // NewSTR constructs a SignedTreeRoot with the given signing key pair,
 // associated data, MerkleTree, epoch, previous STR hash, and
 // digitally signs the STR using the given signing key.
 func NewSTR(key sign.PrivateKey, ad AssocData, m *MerkleTree, epoch uint64, prevHash []byte) *SignedTreeRoot {
+	return NewSTRWithExtensions(key, ad, m, epoch, prevHash, nil)
+}
+
+// NewSTRWithExtensions constructs a SignedTreeRoot as NewSTR() does,
+// and additionally commits to the given extensions exts in the STR's
+// header.
+func NewSTRWithExtensions(key sign.PrivateKey, ad AssocData, m *MerkleTree,
+	epoch uint64, prevHash []byte, exts map[string]*STRExtension) *SignedTreeRoot {
 	prevEpoch := epoch - 1
 	if epoch == 0 {
 		prevEpoch = 0
@@ -45,6 +78,8 @@ func NewSTR(key sign.PrivateKey, ad AssocData, m *MerkleTree, epoch uint64, prev
 		PreviousEpoch:   prevEpoch,
 		PreviousSTRHash: prevHash,
 		Ad:              ad,
+		Version:         STRVersion,
+		Extensions:      exts,
 	}
 	bytesPreSig := str.Serialize()
 	str.Signature = key.Sign(bytesPreSig)
@@ -61,19 +96,65 @@ func (str *SignedTreeRoot) Serialize() []byte {
 	return append(str.SerializeInternal(), str.Ad.Serialize()...)
 }
 
-// SerializeInternal serializes the signed tree root into
-// a specified format.
+// SerializeInternal serializes the signed tree root's header into
+// a specified format. Since version 1, the header starts with its
+// version and ends with the hash of the STR's extensions.
 func (str *SignedTreeRoot) SerializeInternal() []byte {
 	var strBytes []byte
+	if str.Version > 0 {
+		strBytes = append(strBytes, utils.UInt32ToBytes(str.Version)...) // header version
+	}
 	strBytes = append(strBytes, utils.ULongToBytes(str.Epoch)...) // t - epoch number
 	if str.Epoch > 0 {
 		strBytes = append(strBytes, utils.ULongToBytes(str.PreviousEpoch)...) // t_prev - previous epoch number
 	}
 	strBytes = append(strBytes, str.TreeHash...)        // root
 	strBytes = append(strBytes, str.PreviousSTRHash...) // previous STR hash
+	if str.Version > 0 {
+		strBytes = append(strBytes, str.hashExtensions()...) // extensions
+	}
 	return strBytes
 }
 
+// hashExtensions hashes the STR's extensions sorted by name, so that
+// the hash doesn't depend on the order of the extensions in the map.
+func (str *SignedTreeRoot) hashExtensions() []byte {
+	names := make([]string, 0, len(str.Extensions))
+	for name := range str.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var bs [][]byte
+	for _, name := range names {
+		ext := str.Extensions[name]
+		if ext == nil {
+			ext = &STRExtension{}
+		}
+		critical := []byte{0}
+		if ext.Critical {
+			critical[0] = 1
+		}
+		bs = append(bs,
+			utils.ULongToBytes(uint64(len(name))), []byte(name),
+			critical,
+			utils.ULongToBytes(uint64(len(ext.Value))), ext.Value)
+	}
+	return crypto.Digest(bs...)
+}
+
+// UnknownCriticalExtensions returns the names of the STR's critical
+// extensions for which known returns false, in sorted order.
+func (str *SignedTreeRoot) UnknownCriticalExtensions(known func(name string) bool) []string {
+	var unknown []string
+	for name, ext := range str.Extensions {
+		if ext != nil && ext.Critical && !known(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
 // VerifyHashChain computes the hash of savedSTR's signature,
 // and compares it to the hash of previous STR included
 // in the issued STR. The hash chain is valid if
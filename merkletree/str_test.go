@@ -1,6 +1,7 @@
 package merkletree
 
 import (
+	"strconv"
 	"testing"
 )
 
@@ -16,7 +17,7 @@ func TestVerifyHashChain(t *testing.T) {
 	pk, _ := pad.signKey.Public()
 
 	for i := uint64(1); i < N; i++ {
-		key := keyPrefix + string(i)
+		key := keyPrefix + strconv.FormatUint(i, 10)
 		value := append(valuePrefix, byte(i))
 		if err := pad.Set(key, value); err != nil {
 			t.Fatal(err)
@@ -36,3 +37,61 @@ func TestVerifyHashChain(t *testing.T) {
 		savedSTR = str
 	}
 }
+
+func TestSTRExtensions(t *testing.T) {
+	pad, err := NewPAD(TestAd{"abc"}, staticSigningKey, staticVRFKey, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := pad.signKey.Public()
+	pad.SetSTRExtensions(map[string]*STRExtension{
+		"timestamp": {Value: []byte{1}},
+		"cosign":    {Critical: true, Value: []byte{2}},
+	})
+	pad.Update(nil)
+	str := pad.LatestSTR()
+	if str.Version != STRVersion {
+		t.Fatal("Expect version", STRVersion, "got", str.Version)
+	}
+	if !pk.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Invalid STR signature")
+	}
+
+	// the signature commits to the extensions
+	tampered := *str
+	tampered.Extensions = map[string]*STRExtension{
+		"timestamp": {Value: []byte{1}},
+		"cosign":    {Value: []byte{2}},
+	}
+	if pk.Verify(tampered.Serialize(), tampered.Signature) {
+		t.Fatal("Expect the extensions to be signed")
+	}
+
+	unknown := str.UnknownCriticalExtensions(func(name string) bool {
+		return name == "timestamp"
+	})
+	if len(unknown) != 1 || unknown[0] != "cosign" {
+		t.Fatal("Expect", "cosign", "to be unknown, got", unknown)
+	}
+}
+
+func TestVerifyUnversionedSTR(t *testing.T) {
+	m := staticTree(t)
+	m.recomputeHash()
+	str := &SignedTreeRoot{
+		tree:            m,
+		TreeHash:        m.hash,
+		PreviousSTRHash: []byte{},
+		Ad:              TestAd{"abc"},
+	}
+	str.Signature = staticSigningKey.Sign(str.Serialize())
+	versioned := NewSTR(staticSigningKey, TestAd{"abc"}, m, 0, []byte{})
+
+	pk, _ := staticSigningKey.Public()
+	if !pk.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Expect an unversioned STR to verify")
+	}
+	if pk.Verify(versioned.Serialize(), str.Signature) {
+		t.Fatal("Expect the header version to be signed")
+	}
+}
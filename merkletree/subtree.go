@@ -0,0 +1,165 @@
+package merkletree
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A SubtreeProof proves the hash of the subtree of a tree which
+// contains all leaves whose index starts with the first PrefixBits bits
+// of Prefix, e.g., the subtree of a provider's namespace. Comparing the
+// hashes of a subtree across epochs reveals whether any leaf of the
+// subtree has changed, without revealing the leaves.
+// The proof is a pruned tree containing the path between the root and
+// the subtree. If the subtree's root is an interior node, Hash is its
+// hash. Otherwise, the path leaves the tree at Leaf, either an empty
+// branch or a user leaf, as in an EmptyRangeProof, and the subtree
+// contains at most this leaf.
+type SubtreeProof struct {
+	Prefix     []byte
+	PrefixBits uint32
+	TreeNonce  []byte
+	PrunedTree [][]byte
+	Hash       []byte     `json:",omitempty"`
+	Leaf       *ProofNode `json:",omitempty"`
+}
+
+// GetSubtree returns a SubtreeProof of the subtree of the tree m which
+// contains all leaves whose index starts with the first bits bits of
+// prefix. It returns ErrMalformedRange if bits is 0, or greater than
+// MaxRangePrefixBits or than the length of prefix in bits.
+func (m *MerkleTree) GetSubtree(prefix []byte, bits uint32) (*SubtreeProof, error) {
+	if !validRange(prefix, bits) {
+		return nil, ErrMalformedRange
+	}
+	prefixBits := utils.ToBits(prefix)
+	proof := &SubtreeProof{
+		Prefix:     prefix,
+		PrefixBits: bits,
+		TreeNonce:  m.nonce,
+	}
+
+	var nodePointer merkleNode = m.root
+	var hash []byte
+	for depth := uint32(0); ; depth++ {
+		switch n := nodePointer.(type) {
+		case *emptyNode:
+			proof.Leaf = &ProofNode{
+				Level:   n.level,
+				Index:   n.index,
+				IsEmpty: true,
+			}
+			return proof, nil
+		case *userLeafNode:
+			proof.Leaf = &ProofNode{
+				Level: n.level,
+				Index: n.index,
+				Commitment: &crypto.Commit{
+					Value: n.commitment.Value,
+				},
+			}
+			return proof, nil
+		case *interiorNode:
+			if depth == bits {
+				proof.Hash = hash
+				return proof, nil
+			}
+			if prefixBits[depth] {
+				proof.PrunedTree = append(proof.PrunedTree, append([]byte{}, n.leftHash...))
+				hash = append([]byte{}, n.rightHash...)
+				nodePointer = n.rightChild
+			} else {
+				proof.PrunedTree = append(proof.PrunedTree, append([]byte{}, n.rightHash...))
+				hash = append([]byte{}, n.leftHash...)
+				nodePointer = n.leftChild
+			}
+		default:
+			panic(ErrInvalidTree)
+		}
+	}
+}
+
+// Verify checks that the proof p is well-formed, that its leaf, if
+// any, is where the prefix leaves the tree (see SubtreeProof), and
+// recomputes the tree's root node from p, which it compares to
+// treeHash, taken from the STR of the tree which returned p, using
+// hashes of the size of treeHash (see AuthenticationPath.Verify()).
+// Verify returns ErrMalformedRange if the prefix is malformed,
+// ErrMalformedAuthPath if p is otherwise malformed, ErrIndicesMismatch
+// if the leaf's index doesn't share its first Level bits with the
+// prefix, and ErrUnequalTreeHashes if the hashes don't match.
+func (p *SubtreeProof) Verify(treeHash []byte) error {
+	return p.VerifyWith(defaultHasher(treeHash), treeHash)
+}
+
+// VerifyWith is Verify(), but hashes the tree's nodes with the hasher h
+// (see AuthenticationPath.VerifyWith()).
+func (p *SubtreeProof) VerifyWith(h hashers.PADHasher, treeHash []byte) error {
+	if p == nil || !validRange(p.Prefix, p.PrefixBits) {
+		return ErrMalformedRange
+	}
+	if len(treeHash) != h.Size() || !crypto.ValidHashSize(len(treeHash)) ||
+		(p.Hash == nil) == (p.Leaf == nil) {
+		return ErrMalformedAuthPath
+	}
+	for _, hash := range p.PrunedTree {
+		if len(hash) != len(treeHash) {
+			return ErrMalformedAuthPath
+		}
+	}
+	prefixBits := utils.ToBits(p.Prefix)[:p.PrefixBits]
+
+	if n := p.Leaf; n != nil {
+		if int(n.Level) != len(p.PrunedTree) || n.Level > p.PrefixBits ||
+			int(n.Level) > len(n.Index)*8 ||
+			(!n.IsEmpty && (n.Commitment == nil || n.Value != nil)) {
+			return ErrMalformedAuthPath
+		}
+		if !hasPrefix(n.Index, prefixBits[:n.Level]) {
+			return ErrIndicesMismatch
+		}
+		ap := &AuthenticationPath{
+			TreeNonce:  p.TreeNonce,
+			PrunedTree: p.PrunedTree,
+			Leaf:       n,
+		}
+		if !bytes.Equal(treeHash, ap.authPathHash(h)) {
+			return ErrUnequalTreeHashes
+		}
+		return nil
+	}
+
+	if len(p.PrunedTree) != int(p.PrefixBits) || len(p.Hash) != len(treeHash) {
+		return ErrMalformedAuthPath
+	}
+	hash := p.Hash
+	for depth := len(prefixBits) - 1; depth >= 0; depth-- {
+		if prefixBits[depth] { // right child
+			hash = h.Digest(p.PrunedTree[depth], hash)
+		} else {
+			hash = h.Digest(hash, p.PrunedTree[depth])
+		}
+	}
+	if !bytes.Equal(treeHash, hash) {
+		return ErrUnequalTreeHashes
+	}
+	return nil
+}
+
+// GetSubtreeInEpoch returns a proof of the subtree of the snapshot at
+// the requested epoch which contains all bindings whose private index
+// starts with the first bits bits of prefix (see
+// MerkleTree.GetSubtree()).
+// It returns ErrSTRNotFound if the signed tree root of the requested
+// epoch has been removed from memory.
+func (pad *PAD) GetSubtreeInEpoch(prefix []byte, bits uint32,
+	epoch uint64) (*SubtreeProof, error) {
+	str := pad.GetSTR(epoch)
+	if str == nil {
+		return nil, ErrSTRNotFound
+	}
+	return str.tree.GetSubtree(prefix, bits)
+}
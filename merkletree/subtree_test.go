@@ -0,0 +1,88 @@
+package merkletree
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestSubtreeProof(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	index := tuple[0].index
+	level := int(m.Get(index).Leaf.Level)
+
+	for _, tc := range []struct {
+		name     string
+		prefix   []byte
+		bits     int
+		interior bool
+		want     error
+	}{
+		{"interior node", prefixOf(index, level-1, false), level - 1, true, nil},
+		{"leaf", prefixOf(index, level, false), level, false, nil},
+		{"below leaf", prefixOf(index, level+4, true), level + 4, false, nil},
+		{"whole index space", prefixOf(index, 1, false), 0, false, ErrMalformedRange},
+		{"too long", prefixOf(index, MaxRangePrefixBits+1, false), MaxRangePrefixBits + 1, false, ErrMalformedRange},
+	} {
+		proof, err := m.GetSubtree(tc.prefix, uint32(tc.bits))
+		if err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (proof.Hash != nil) != tc.interior {
+			t.Error(tc.name, "expect an interior node", tc.interior, "got", proof.Hash != nil)
+		}
+		if err := proof.Verify(m.hash); err != nil {
+			t.Error(tc.name, "expect", nil, "got", err)
+		}
+	}
+
+	proof, err := m.GetSubtree(prefixOf(index, level-1, false), uint32(level-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Hash[0] ^= 1
+	if err := proof.Verify(m.hash); err != ErrUnequalTreeHashes {
+		t.Fatal("Expect", ErrUnequalTreeHashes, "got", err)
+	}
+	proof.Leaf = &ProofNode{IsEmpty: true}
+	if err := proof.Verify(m.hash); err != ErrMalformedAuthPath {
+		t.Fatal("Expect", ErrMalformedAuthPath, "got", err)
+	}
+}
+
+func TestSubtreeHashOnlyChangesWithItsLeaves(t *testing.T) {
+	m, tuple := setupTestProofs(t)
+	prefix := prefixOf(tuple[0].index, 1, false)
+	before, err := m.GetSubtree(prefix, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// set a leaf outside the subtree
+	for i := 0; ; i++ {
+		key := "outside" + string(rune('a'+i))
+		index := staticVRFKey.Compute([]byte(key))
+		if utils.GetNthBit(index, 0) != utils.GetNthBit(prefix, 0) {
+			if err := m.Set(index, key, []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	m.recomputeHash()
+	after, err := m.GetSubtree(prefix, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := after.Verify(m.hash); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before.Hash, after.Hash) {
+		t.Fatal("Expect the subtree's hash to be unchanged")
+	}
+}
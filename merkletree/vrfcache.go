@@ -0,0 +1,83 @@
+package merkletree
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+)
+
+// vrfCacheSize is the maximum number of VRF outputs
+// a PAD caches per epoch.
+const vrfCacheSize = 1 << 16
+
+type vrfOutput struct {
+	index []byte
+	proof []byte
+}
+
+// A vrfCache caches the private indices and VRF proofs a PAD computes
+// for its keys using its current VRF key, since the same keys are
+// often looked up and registered repeatedly within an epoch.
+// The cache is cleared at each epoch, and whenever the VRF key
+// changes. A vrfCache is safe for concurrent use, as lookups may be
+// performed concurrently.
+type vrfCache struct {
+	sync.Mutex
+	// alg and key identify the VRF key of the cached outputs
+	alg     vrf.Algorithm
+	key     []byte
+	entries map[string]*vrfOutput
+}
+
+func newVRFCache(vrfKey vrf.VRF) *vrfCache {
+	return &vrfCache{
+		alg:     vrfKey.Algorithm(),
+		key:     vrfKey.Bytes(),
+		entries: make(map[string]*vrfOutput),
+	}
+}
+
+// prove returns the VRF output and proof for key under vrfKey,
+// from the cache if possible. The proof is computed without holding
+// the cache's lock, so that the lookups of other keys aren't
+// serialized behind it.
+func (c *vrfCache) prove(key string, vrfKey vrf.VRF) (index, proof []byte) {
+	c.Lock()
+	if !c.cachesKey(vrfKey) {
+		// the VRF key has been rotated
+		c.alg, c.key = vrfKey.Algorithm(), vrfKey.Bytes()
+		c.entries = make(map[string]*vrfOutput)
+	}
+	out, ok := c.entries[key]
+	c.Unlock()
+	if ok {
+		return out.index, out.proof
+	}
+
+	index, proof = vrfKey.Prove([]byte(key))
+
+	c.Lock()
+	defer c.Unlock()
+	// the key may have been rotated in the meantime
+	if c.cachesKey(vrfKey) && len(c.entries) < vrfCacheSize {
+		c.entries[key] = &vrfOutput{index, proof}
+	}
+	return
+}
+
+// cachesKey returns whether the cached outputs have been computed
+// with vrfKey. It compares the encodings of the private keys, which,
+// unlike their public keys, don't take a scalar multiplication to
+// derive.
+// cachesKey must be called with the cache locked.
+func (c *vrfCache) cachesKey(vrfKey vrf.VRF) bool {
+	return vrfKey.Algorithm() == c.alg && bytes.Equal(vrfKey.Bytes(), c.key)
+}
+
+// reset drops all cached VRF outputs.
+func (c *vrfCache) reset() {
+	c.Lock()
+	c.entries = make(map[string]*vrfOutput)
+	c.Unlock()
+}
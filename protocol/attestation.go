@@ -0,0 +1,98 @@
+// Defines the registration attestations issued by an account
+// verification bot running in detached mode
+
+package protocol
+
+import (
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// attestationLabel separates the signatures on attestations
+// from the bot's signatures on any other data.
+const attestationLabel = "coniks-registration-attestation"
+
+// An AttestationRequest is a message with a username as a string that
+// a CONIKS client sends to an account verification bot running in
+// detached mode, to obtain a RegistrationAttestation for the username.
+//
+// The response to a successful request is the RegistrationAttestation.
+type AttestationRequest struct {
+	Username string
+}
+
+// A RegistrationAttestation consists of a Username, the Expiry time
+// (in seconds since the Unix epoch) after which the attestation is no
+// longer valid, and a digital Signature of these fields by an account
+// verification bot.
+//
+// An attestation states that the bot has verified that the client
+// controls the account for Username with the bot's identity provider.
+// Instead of forwarding the client's registration, and thus carrying
+// the client's key material, a bot running in detached mode only
+// returns an attestation, which the client then includes in the
+// RegistrationRequest it sends directly to the CONIKS server.
+type RegistrationAttestation struct {
+	Username  string
+	Expiry    uint64
+	Signature []byte
+}
+
+var _ DirectoryResponse = (*RegistrationAttestation)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*RegistrationAttestation) ResponseType() string { return "RegistrationAttestation" }
+
+func (a *RegistrationAttestation) validate() error {
+	if len(a.Signature) == 0 {
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// NewRegistrationAttestation creates a new attestation for username,
+// which is valid until expiry, signed with signKey.
+func NewRegistrationAttestation(signKey sign.PrivateKey, username string,
+	expiry time.Time) *RegistrationAttestation {
+	a := &RegistrationAttestation{
+		Username: username,
+		Expiry:   uint64(expiry.Unix()),
+	}
+	a.Signature = signKey.Sign(a.Serialize())
+	return a
+}
+
+// NewAttestationResponse creates the response message an account
+// verification bot running in detached mode sends to a client upon
+// an AttestationRequest, and returns a Response containing
+// the attestation a.
+func NewAttestationResponse(a *RegistrationAttestation) *Response {
+	return &Response{
+		Error:             ReqSuccess,
+		DirectoryResponse: a,
+	}
+}
+
+// Serialize serializes the attestation into
+// a specified format for signing.
+func (a *RegistrationAttestation) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, []byte(attestationLabel)...)
+	bs = append(bs, utils.ULongToBytes(uint64(len(a.Username)))...)
+	bs = append(bs, []byte(a.Username)...)
+	bs = append(bs, utils.ULongToBytes(a.Expiry)...)
+	return bs
+}
+
+// Verify returns true if a is a valid attestation for username at the
+// time now, signed by the bot whose public key is pk.
+func (a *RegistrationAttestation) Verify(pk sign.PublicKey, username string,
+	now time.Time) bool {
+	if a == nil || len(pk) != sign.PublicKeySize || a.Username != username ||
+		uint64(now.Unix()) > a.Expiry {
+		return false
+	}
+	return pk.Verify(a.Serialize(), a.Signature)
+}
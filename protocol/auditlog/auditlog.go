@@ -2,10 +2,13 @@
 // maintains.
 // An audit log is a mirror of many CONIKS key directories' STR history,
 // allowing CONIKS clients to audit the CONIKS directories.
+// Old epochs of a history may be pruned into an archive (see prune.go).
 
 package auditlog
 
 import (
+	"bytes"
+
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
@@ -14,8 +17,24 @@ import (
 
 type directoryHistory struct {
 	*auditor.AudState
-	addr      string
-	snapshots map[uint64]*protocol.DirSTR
+	addr         string
+	signKey      sign.PublicKey
+	initSTR      *protocol.DirSTR
+	dirInitHash  [crypto.HashSizeByte]byte
+	snapshots    map[uint64]*protocol.DirSTR
+	observations map[uint64]*epochObservations
+
+	// oldest is the oldest epoch in snapshots; the STRs of all
+	// previous epochs have been pruned into the archives of the
+	// pruned ranges (see Prune())
+	oldest uint64
+	pruned []*PrunedRange
+
+	// flushed is the first epoch whose STR hasn't been written to the
+	// database yet, and flushedOldest the oldest epoch in the database
+	// (see Flush())
+	flushed       uint64
+	flushedOldest uint64
 }
 
 // A ConiksAuditLog maintains the histories
@@ -34,9 +53,13 @@ func newDirectoryHistory(addr string,
 	initSTR *protocol.DirSTR) *directoryHistory {
 	a := auditor.New(signKey, initSTR)
 	h := &directoryHistory{
-		AudState:  a,
-		addr:      addr,
-		snapshots: make(map[uint64]*protocol.DirSTR),
+		AudState:     a,
+		addr:         addr,
+		signKey:      signKey,
+		initSTR:      initSTR,
+		dirInitHash:  auditor.ComputeDirectoryIdentity(initSTR),
+		snapshots:    make(map[uint64]*protocol.DirSTR),
+		observations: make(map[uint64]*epochObservations),
 	}
 	h.updateVerifiedSTR(initSTR)
 	return h
@@ -72,7 +95,7 @@ func (h *directoryHistory) Audit(msg *protocol.Response) error {
 		return err
 	}
 
-	strs := msg.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := msg.STRHistoryRange()
 
 	// audit the STRs
 	// if strs.STR is somehow malformed or invalid (e.g. strs.STR
@@ -81,6 +104,9 @@ func (h *directoryHistory) Audit(msg *protocol.Response) error {
 	if err := h.AuditDirectory(strs.STR); err != nil {
 		return err
 	}
+	if err := strs.Verify(); err != nil {
+		return err
+	}
 
 	// TODO: we should be storing inconsistent STRs nonetheless
 	// so clients can detect inconsistencies -- or auditors
@@ -165,12 +191,18 @@ func (l ConiksAuditLog) InitHistory(addr string, signKey sign.PublicKey,
 // at StartEpoch > EndEpoch is considered
 // malformed and causes GetObservedSTRs() to return a
 // message.NewErrorResponse(ErrMalformedMessage).
-// GetObservedSTRs() returns a message.NewSTRHistoryRange(strs).
+// GetObservedSTRs() returns a message.NewPartialSTRHistoryRange(strs, next).
 // strs is a list of STRs for the epoch range [StartEpoch, EndEpoch];
 // if StartEpoch == EndEpoch, the list returned is of length 1.
+// If the STRs of the whole range would exceed protocol.MaxResponseSize,
+// the range ends at the last STR which fits, and next continues the
+// range at the following epoch; otherwise, next is nil.
 // If the auditor doesn't have any history entries for the requested CONIKS
 // directory, GetObservedSTRs() returns a
 // message.NewErrorResponse(ReqUnknownDirectory).
+// If the requested range includes pruned epochs (see Prune()) and the
+// pruned STRs cannot be fetched from the archive, GetObservedSTRs()
+// returns a message.NewErrorResponse(ErrAuditLog).
 func (l ConiksAuditLog) GetObservedSTRs(req *protocol.AuditingRequest) *protocol.Response {
 	// make sure we have a history for the requested directory in the log
 	h, ok := l.get(req.DirInitSTRHash)
@@ -183,11 +215,86 @@ func (l ConiksAuditLog) GetObservedSTRs(req *protocol.AuditingRequest) *protocol
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
 
-	var strs []*protocol.DirSTR
-	for ep := req.StartEpoch; ep <= req.EndEpoch; ep++ {
-		str := h.snapshots[ep]
-		strs = append(strs, str)
+	strs, err := h.getRange(req.StartEpoch, req.EndEpoch)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrAuditLog)
+	}
+	var next *protocol.Continuation
+	budget := protocol.NewResponseBudget()
+	for i, str := range strs {
+		if !budget.Spend(str) {
+			next = &protocol.Continuation{NextEpoch: str.Epoch}
+			strs = strs[:i]
+			break
+		}
+	}
+	return protocol.NewPartialSTRHistoryRange(strs, next)
+}
+
+// Update audits the range of STRs in the response msg received from
+// the CONIKS directory identified by dirInitHash, and inserts them into
+// the directory's history if the checks pass (see Audit()).
+// Update() returns a ReqUnknownDirectory if the auditor doesn't have
+// any history entries for this directory.
+func (l ConiksAuditLog) Update(dirInitHash [crypto.HashSizeByte]byte,
+	msg *protocol.Response) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	return h.Audit(msg)
+}
+
+// LatestObservedSTR returns the latest STR the auditor has verified for
+// the CONIKS directory identified by dirInitHash, or nil if the auditor
+// doesn't have any history entries for this directory.
+func (l ConiksAuditLog) LatestObservedSTR(dirInitHash [crypto.HashSizeByte]byte) *protocol.DirSTR {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil
 	}
+	return h.VerifiedSTR()
+}
 
-	return protocol.NewSTRHistoryRange(strs)
+// VerifySTR checks an STR str obtained out-of-band, e.g. copied by
+// a user from the directory's website, against the observed history
+// of the CONIKS directory identified by dirInitHash. This allows
+// users to check manually whether the directory equivocates.
+//
+// VerifySTR() returns nil if str is the STR the auditor has observed
+// for the same epoch. It returns a CheckBadSignature if str isn't signed
+// by the directory, and a CheckBadSTR if the directory has issued
+// a different STR for the same epoch.
+// If the auditor doesn't have any history entries for the requested
+// directory, VerifySTR() returns a ReqUnknownDirectory. An STR for an
+// epoch the auditor hasn't observed yet causes VerifySTR() to return an
+// ErrMalformedMessage. If str is for a pruned epoch (see Prune()) which
+// cannot be fetched from the archive, VerifySTR() returns an ErrAuditLog.
+func (l ConiksAuditLog) VerifySTR(dirInitHash [crypto.HashSizeByte]byte,
+	str *protocol.DirSTR) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	if str == nil || str.SignedTreeRoot == nil {
+		return protocol.ErrMalformedMessage
+	}
+	if err := str.CheckHeader(); err != nil {
+		return err
+	}
+	if !h.VerifySignature(str) {
+		return protocol.CheckBadSignature
+	}
+	if str.Epoch > h.VerifiedSTR().Epoch {
+		return protocol.ErrMalformedMessage
+	}
+	observed, err := h.getRange(str.Epoch, str.Epoch)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(observed[0].Signature, str.Signature) ||
+		!bytes.Equal(observed[0].Serialize(), str.Serialize()) {
+		return protocol.CheckBadSTR
+	}
+	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/coniks-sys/coniks-go/crypto"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
 
 func TestInsertEmptyHistory(t *testing.T) {
@@ -61,7 +62,7 @@ func TestAuditLogBadEpochRange(t *testing.T) {
 		t.Fatalf("Error occurred while fetching STR history: %s", resp.Error)
 	}
 
-	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := resp.STRHistoryRange()
 	if len(strs.STR) != 2 {
 		t.Fatalf("Expect 2 STRs from directory, got %d", len(strs.STR))
 	}
@@ -102,7 +103,7 @@ func TestGetLatestObservedSTR(t *testing.T) {
 		t.Fatal("Unable to get latest observed STR")
 	}
 
-	obs := res.DirectoryResponse.(*protocol.STRHistoryRange)
+	obs := res.STRHistoryRange()
 	if len(obs.STR) == 0 {
 		t.Fatal("Expect returned STR to be not nil")
 	}
@@ -127,7 +128,7 @@ func TestGetObservedSTRInEpoch(t *testing.T) {
 		t.Fatal("Unable to get latest range of STRs")
 	}
 
-	obs := res.DirectoryResponse.(*protocol.STRHistoryRange)
+	obs := res.STRHistoryRange()
 	if len(obs.STR) == 0 {
 		t.Fatal("Expect returned STR to be not nil")
 	}
@@ -156,7 +157,7 @@ func TestGetObservedSTRMultipleEpochs(t *testing.T) {
 		t.Fatalf("Unable to get latest range of STRs, got %s", res.Error)
 	}
 
-	obs := res.DirectoryResponse.(*protocol.STRHistoryRange)
+	obs := res.STRHistoryRange()
 	if len(obs.STR) != 2 {
 		t.Fatal("Unexpected number of returned STRs")
 	}
@@ -187,7 +188,7 @@ func TestGetObservedSTRMultipleEpochs(t *testing.T) {
 		t.Fatal("Unable to get new latest STRs")
 	}
 
-	obs = res.DirectoryResponse.(*protocol.STRHistoryRange)
+	obs = res.STRHistoryRange()
 	if len(obs.STR) != 1 {
 		t.Fatal("Unexpected number of new latest STRs")
 	}
@@ -251,7 +252,7 @@ func TestVerifyHashChainBadPrevSTRHash(t *testing.T) {
 	d.Update()
 
 	// modify the latest STR so that the consistency check fails
-	str := d.LatestSTR()
+	str := *d.LatestSTR()
 	str2 := *str.SignedTreeRoot
 	str2.PreviousSTRHash = append([]byte{}, str.PreviousSTRHash...)
 	str2.PreviousSTRHash[0]++
@@ -276,7 +277,7 @@ func TestVerifyHashChainBadPrevEpoch(t *testing.T) {
 	d.Update()
 
 	// modify the latest STR so that the consistency check fails
-	str := d.LatestSTR()
+	str := *d.LatestSTR()
 	str2 := *str.SignedTreeRoot
 	str2.PreviousEpoch++
 	str.SignedTreeRoot = &str2
@@ -300,7 +301,7 @@ func TestVerifyHashChainBadCurEpoch(t *testing.T) {
 	d.Update()
 
 	// modify the latest STR so that the consistency check fails
-	str := d.LatestSTR()
+	str := *d.LatestSTR()
 	str2 := *str.SignedTreeRoot
 	str2.Epoch++
 	str.SignedTreeRoot = &str2
@@ -330,7 +331,7 @@ func TestSTRHistoryRequestLatest(t *testing.T) {
 		t.Fatalf("Error occurred getting the latest STR from the directory: %s", resp.Error)
 	}
 
-	str := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	str := resp.STRHistoryRange()
 	if len(str.STR) != 1 {
 		t.Fatalf("Expected 1 STR from directory, got %d", len(str.STR))
 	}
@@ -362,7 +363,7 @@ func TestSTRHistoryRequestRangeLatest(t *testing.T) {
 		t.Fatalf("Error occurred getting the latest STR from the directory: %s", resp.Error)
 	}
 
-	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := resp.STRHistoryRange()
 	if len(strs.STR) != 4 {
 		t.Fatalf("Expect 4 STRs from directory, got %d", len(strs.STR))
 	}
@@ -398,7 +399,7 @@ func TestSTRHistoryRequestInEpoch(t *testing.T) {
 		t.Fatalf("Error occurred getting the latest STR from the directory: %s", resp.Error)
 	}
 
-	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := resp.STRHistoryRange()
 	if len(strs.STR) != 2 {
 		t.Fatalf("Expect 2 STRs from directory, got %d", len(strs.STR))
 	}
@@ -416,3 +417,44 @@ func TestSTRHistoryRequestInEpoch(t *testing.T) {
 		t.Fatalf("Error occurred auditing the latest STR: %s", err.Error())
 	}
 }
+
+func TestVerifySTR(t *testing.T) {
+	// create basic test directory and audit log with 4 STRs
+	_, aud, hist := NewTestAuditLog(t, 3)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	if err := aud.VerifySTR(dirInitHash, hist[2]); err != nil {
+		t.Fatal("Expect", nil, "got", err)
+	}
+
+	// a directory equivocating with the same signing key
+	fork := directory.NewTestDirectory(t)
+	fork.Update()
+	fork.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key")})
+	fork.Update()
+	if err := aud.VerifySTR(dirInitHash, fork.LatestSTR()); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	str := *hist[1]
+	str2 := *str.SignedTreeRoot
+	str2.Signature = append([]byte{}, str.Signature...)
+	str2.Signature[0]++
+	str.SignedTreeRoot = &str2
+	if err := aud.VerifySTR(dirInitHash, &str); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	fork.Update()
+	fork.Update()
+	if err := aud.VerifySTR(dirInitHash, fork.LatestSTR()); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	var unknown [crypto.HashSizeByte]byte
+	if err := aud.VerifySTR(unknown, hist[0]); err != protocol.ReqUnknownDirectory {
+		t.Fatal("Expect", protocol.ReqUnknownDirectory, "got", err)
+	}
+}
@@ -0,0 +1,140 @@
+// This module implements the gossip between the auditors of the same
+// directory, in which each auditor sends its peers the latest STR it
+// has observed, and checks the STRs the peers have observed for the
+// same epochs against its own history. Since the STRs are hash-chained,
+// two auditors which have been shown different histories by the
+// directory (a split view) observe different STRs for every epoch
+// after the fork, including the latest epoch they have both observed.
+
+package auditlog
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// Gossip returns the gossip message the auditor sends to its peers for
+// the directory identified by dirInitHash, which includes the latest
+// STR the auditor has observed for this directory, or nil if the
+// auditor doesn't have any history entries for the directory.
+func (l ConiksAuditLog) Gossip(dirInitHash [crypto.HashSizeByte]byte) *protocol.Gossip {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil
+	}
+	return &protocol.Gossip{
+		DirInitSTRHash: dirInitHash,
+		STR:            []*protocol.DirSTR{h.VerifiedSTR()},
+	}
+}
+
+// ReceiveGossip checks the STRs which a peer auditor has observed for
+// a CONIKS directory, included in the Gossip req, against the observed
+// history of this directory, and returns a protocol.Response.
+// The response (which is a protocol.NewSTRHistoryRange() of the STRs
+// the auditor has observed for the gossiped epochs, followed by its
+// latest observed STR) is sent back to the peer.
+//
+// The gossiped STRs for epochs the auditor has already observed are
+// checked as in VerifySTR(), while the STRs for later epochs are
+// ignored. If the directory has issued a different STR for one of the
+// gossiped epochs, ReceiveGossip() sets the error code of the response
+// to CheckBadSTR, so that the peer can verify the evidence of the split
+// view (see VerifyGossip()).
+//
+// If the auditor doesn't have any history entries for the requested
+// CONIKS directory, ReceiveGossip() returns a
+// message.NewErrorResponse(ReqUnknownDirectory). A gossip which doesn't
+// contain between 1 and protocol.MaxGossipSTRs well-formed STRs in
+// ascending order of their epochs causes ReceiveGossip() to return a
+// message.NewErrorResponse(ErrMalformedMessage), and one which contains
+// an STR that isn't signed by the directory a
+// message.NewErrorResponse(CheckBadSignature).
+func (l ConiksAuditLog) ReceiveGossip(req *protocol.Gossip) *protocol.Response {
+	h, ok := l.get(req.DirInitSTRHash)
+	if !ok {
+		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory)
+	}
+	if len(req.STR) > protocol.MaxGossipSTRs ||
+		protocol.NewSTRHistoryRange(req.STR).Validate() != nil {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	for i := 1; i < len(req.STR); i++ {
+		if req.STR[i].Epoch <= req.STR[i-1].Epoch {
+			return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+		}
+	}
+
+	latest := h.VerifiedSTR()
+	code := protocol.ReqSuccess
+	var observed []*protocol.DirSTR
+	for _, str := range req.STR {
+		if str.Epoch > latest.Epoch {
+			break
+		}
+		switch err := l.VerifySTR(req.DirInitSTRHash, str); err {
+		case nil:
+		case protocol.CheckBadSTR:
+			code = protocol.CheckBadSTR
+		default:
+			return protocol.NewErrorResponse(toErrorCode(err))
+		}
+		strs, err := h.getRange(str.Epoch, str.Epoch)
+		if err != nil {
+			return protocol.NewErrorResponse(toErrorCode(err))
+		}
+		observed = append(observed, strs[0])
+	}
+	if len(observed) == 0 || observed[len(observed)-1].Epoch != latest.Epoch {
+		observed = append(observed, latest)
+	}
+	res := protocol.NewSTRHistoryRange(observed)
+	res.Error = code
+	return res
+}
+
+// VerifyGossip verifies the response msg of a peer auditor to the
+// auditor's Gossip for the directory identified by dirInitHash (see
+// ReceiveGossip()): the STRs the peer has observed for the epochs the
+// auditor has observed as well are checked against the observed
+// history as in VerifySTR(), while the STRs for later epochs are
+// ignored.
+//
+// VerifyGossip() returns a CheckBadSTR if the peer has observed
+// a different STR for one of these epochs, i.e., if the directory
+// presents different views of its history to the auditor and its peer,
+// and a CheckBadSignature if one of the peer's STRs isn't signed by the
+// directory. If the peer responds with an error code, VerifyGossip()
+// returns this error code, e.g. a ReqUnknownDirectory if the peer
+// doesn't audit the directory, except for a CheckBadSTR which isn't
+// backed by the peer's STRs, which is considered malformed.
+func (l ConiksAuditLog) VerifyGossip(dirInitHash [crypto.HashSizeByte]byte,
+	msg *protocol.Response) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	if msg.Error != protocol.ReqSuccess && msg.Error != protocol.CheckBadSTR {
+		return msg.Error
+	}
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	strs := msg.STRHistoryRange()
+	if strs == nil {
+		return protocol.ErrMalformedMessage
+	}
+	for _, str := range strs.STR {
+		if str.Epoch > h.VerifiedSTR().Epoch {
+			continue
+		}
+		if err := l.VerifySTR(dirInitHash, str); err != nil {
+			return err
+		}
+	}
+	if msg.Error == protocol.CheckBadSTR {
+		// the peer's STRs don't show the split view it reports
+		return protocol.ErrMalformedMessage
+	}
+	return nil
+}
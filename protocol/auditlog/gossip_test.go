@@ -0,0 +1,112 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestPeer creates the audit log of a peer auditor which has
+// observed the STRs hist of the same directory.
+func newTestPeer(t *testing.T, hist []*protocol.DirSTR) ConiksAuditLog {
+	peer := New()
+	pk, _ := staticSigningKey.Public()
+	if err := peer.InitHistory("test-server", pk, hist); err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+func TestGossip(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 2)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	// the peer lags behind the auditor
+	peer := newTestPeer(t, hist[:2])
+
+	res := peer.ReceiveGossip(aud.Gossip(dirInitHash))
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if strs := res.STRHistoryRange().STR; len(strs) != 1 || strs[0].Epoch != 1 {
+		t.Fatal("Expect the peer's latest STR, got", strs)
+	}
+	if err := aud.VerifyGossip(dirInitHash, res); err != nil {
+		t.Fatal(err)
+	}
+
+	res = aud.ReceiveGossip(peer.Gossip(dirInitHash))
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if strs := res.STRHistoryRange().STR; len(strs) != 2 ||
+		strs[0].Epoch != 1 || strs[1].Epoch != 2 {
+		t.Fatal("Expect the STRs of epochs 1 and 2, got", strs)
+	}
+	if err := peer.VerifyGossip(dirInitHash, res); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGossipSplitView(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// the directory shows the peer a fork of its history
+	fork := directory.NewTestDirectory(t)
+	fork.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	fork.Update()
+	peer := newTestPeer(t, []*protocol.DirSTR{hist[0], fork.LatestSTR()})
+
+	res := peer.ReceiveGossip(aud.Gossip(dirInitHash))
+	if res.Error != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", res.Error)
+	}
+	if err := aud.VerifyGossip(dirInitHash, res); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	// a peer reporting a split view must back it with its STRs
+	res = aud.ReceiveGossip(aud.Gossip(dirInitHash))
+	res.Error = protocol.CheckBadSTR
+	if err := aud.VerifyGossip(dirInitHash, res); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestReceiveGossipBadRequest(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	root := *d.LatestSTR().SignedTreeRoot
+	root.Signature = append([]byte{}, root.Signature...)
+	root.Signature[0] ^= 1
+	forged := &protocol.DirSTR{SignedTreeRoot: &root, Policies: d.LatestSTR().Policies}
+	var unknown [crypto.HashSizeByte]byte
+	tooMany := make([]*protocol.DirSTR, protocol.MaxGossipSTRs+1)
+	for i := range tooMany {
+		tooMany[i] = hist[1]
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  *protocol.Gossip
+		want protocol.ErrorCode
+	}{
+		{"unknown directory", &protocol.Gossip{DirInitSTRHash: unknown,
+			STR: hist}, protocol.ReqUnknownDirectory},
+		{"no STR", &protocol.Gossip{DirInitSTRHash: dirInitHash},
+			protocol.ErrMalformedMessage},
+		{"too many STRs", &protocol.Gossip{DirInitSTRHash: dirInitHash,
+			STR: tooMany}, protocol.ErrMalformedMessage},
+		{"unordered STRs", &protocol.Gossip{DirInitSTRHash: dirInitHash,
+			STR: []*protocol.DirSTR{hist[1], hist[0]}}, protocol.ErrMalformedMessage},
+		{"forged STR", &protocol.Gossip{DirInitSTRHash: dirInitHash,
+			STR: []*protocol.DirSTR{forged}}, protocol.CheckBadSignature},
+	} {
+		if res := aud.ReceiveGossip(tc.req); res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// This module implements the pruning of the directory histories in
+// an audit log. Pruning moves a range of old STRs into an archive, and
+// replaces it in the history with a compact summary of the range
+// signed by the auditor, i.e., its first and last STRs and
+// a commitment to the entire range. The auditor can still answer
+// queries about the pruned epochs by fetching the segments of the
+// range which include them from the archive, and checking these
+// against the summary.
+
+package auditlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+)
+
+// An Archive stores the STRs an audit log has pruned from the history
+// of a directory, identified by the hash of its initial STR.
+// An Archive doesn't need to be trusted: the audit log verifies all
+// STRs fetched from the archive against the summary of the pruned range.
+type Archive interface {
+	// Store archives the range of consecutive STRs strs.
+	Store(dirInitHash [crypto.HashSizeByte]byte, strs []*protocol.DirSTR) error
+	// Fetch returns the archived STRs for the epochs [first, last].
+	Fetch(dirInitHash [crypto.HashSizeByte]byte, first, last uint64) ([]*protocol.DirSTR, error)
+}
+
+// segmentSize is the number of consecutive STRs of a pruned range
+// committed to by each segment commitment of its summary, which bounds
+// the number of STRs fetched from the archive to answer a query.
+const segmentSize = 16
+
+// prunedRangeLabel prefixes the serialization of the summaries
+// an auditor signs.
+const prunedRangeLabel = "coniks-pruned-range"
+
+// A PrunedRange summarizes a range of consecutive STRs which has been
+// pruned from a directory history. First and Last are the first and
+// the last STR of the range. Segments commit to each segment of
+// segmentSize STRs in the range (see commitChain()), and
+// ChainCommitment commits to all segments. Signature is the auditor's
+// signature of the summary (see Serialize()).
+type PrunedRange struct {
+	First           *protocol.DirSTR
+	Last            *protocol.DirSTR
+	Segments        [][]byte
+	ChainCommitment []byte
+	Signature       []byte
+
+	// archive stores the STRs of the range
+	archive Archive
+}
+
+// newPrunedRange creates the summary of the range of consecutive STRs
+// strs, archived in archive, and signs it with the auditor's signing
+// key signKey.
+func newPrunedRange(strs []*protocol.DirSTR, archive Archive,
+	signKey sign.PrivateKey) *PrunedRange {
+	r := &PrunedRange{
+		First:   strs[0],
+		Last:    strs[len(strs)-1],
+		archive: archive,
+	}
+	for i := 0; i < len(strs); i += segmentSize {
+		end := i + segmentSize
+		if end > len(strs) {
+			end = len(strs)
+		}
+		r.Segments = append(r.Segments, commitChain(strs[i:end]))
+	}
+	r.ChainCommitment = crypto.Digest(r.Segments...)
+	r.Signature = signKey.Sign(r.Serialize())
+	return r
+}
+
+// commitChain returns the commitment to the range of STRs strs, which
+// is the hash of the hashes of each STR's serialization and signature.
+func commitChain(strs []*protocol.DirSTR) []byte {
+	hashes := make([][]byte, 0, len(strs))
+	for _, str := range strs {
+		hashes = append(hashes, crypto.Digest(str.Serialize(), str.Signature))
+	}
+	return crypto.Digest(hashes...)
+}
+
+// Serialize serializes the summary r into a specified format
+// for signing.
+func (r *PrunedRange) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, []byte(prunedRangeLabel)...)
+	bs = append(bs, crypto.Digest(r.First.Serialize(), r.First.Signature)...)
+	bs = append(bs, crypto.Digest(r.Last.Serialize(), r.Last.Signature)...)
+	bs = append(bs, r.ChainCommitment...)
+	return bs
+}
+
+// Verify checks that r is signed by the auditor whose public signing
+// key is pk, and that ChainCommitment commits to the segments of r.
+func (r *PrunedRange) Verify(pk sign.PublicKey) bool {
+	if r.First == nil || r.Last == nil || r.Last.Epoch < r.First.Epoch ||
+		uint64(len(r.Segments)) != (r.Last.Epoch-r.First.Epoch)/segmentSize+1 ||
+		!bytes.Equal(crypto.Digest(r.Segments...), r.ChainCommitment) {
+		return false
+	}
+	return pk.Verify(r.Serialize(), r.Signature)
+}
+
+// fetchSegment fetches the segment i of r from the archive, and
+// checks it against its commitment.
+func (r *PrunedRange) fetchSegment(dirInitHash [crypto.HashSizeByte]byte,
+	i uint64) ([]*protocol.DirSTR, error) {
+	first := r.First.Epoch + i*segmentSize
+	last := first + segmentSize - 1
+	if last > r.Last.Epoch {
+		last = r.Last.Epoch
+	}
+	strs, err := r.archive.Fetch(dirInitHash, first, last)
+	if err != nil || uint64(len(strs)) != last-first+1 {
+		return nil, protocol.ErrAuditLog
+	}
+	for j, str := range strs {
+		if str == nil || str.SignedTreeRoot == nil || str.Policies == nil ||
+			str.Epoch != first+uint64(j) {
+			return nil, protocol.ErrAuditLog
+		}
+	}
+	if !bytes.Equal(commitChain(strs), r.Segments[i]) {
+		return nil, protocol.ErrAuditLog
+	}
+	return strs, nil
+}
+
+// Prune moves the STRs the auditor has observed for the CONIKS directory
+// identified by dirInitHash before the epoch before into archive, and
+// replaces them in the directory's history with a PrunedRange signed
+// with the auditor's signing key signKey.
+// Prune() keeps using archive to answer queries about the epochs it
+// has pruned; each call may use a different archive.
+//
+// Prune() returns a ReqUnknownDirectory if the auditor doesn't have any
+// history entries for this directory, and an ErrMalformedMessage if
+// before is greater than the latest observed epoch, since the latest
+// observed STR is never pruned, or if all STRs before this epoch have
+// already been pruned. If archive fails to store the range, Prune()
+// returns an ErrAuditLog and keeps the STRs in the history.
+func (l ConiksAuditLog) Prune(dirInitHash [crypto.HashSizeByte]byte,
+	before uint64, archive Archive, signKey sign.PrivateKey) error {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return protocol.ReqUnknownDirectory
+	}
+	if before > h.VerifiedSTR().Epoch || before <= h.oldest {
+		return protocol.ErrMalformedMessage
+	}
+
+	strs := make([]*protocol.DirSTR, 0, before-h.oldest)
+	for ep := h.oldest; ep < before; ep++ {
+		strs = append(strs, h.snapshots[ep])
+	}
+	if err := archive.Store(dirInitHash, strs); err != nil {
+		return protocol.ErrAuditLog
+	}
+	h.pruned = append(h.pruned, newPrunedRange(strs, archive, signKey))
+	for ep := h.oldest; ep < before; ep++ {
+		delete(h.snapshots, ep)
+	}
+	h.oldest = before
+	return nil
+}
+
+// PrunedRanges returns the summaries of the ranges of STRs pruned from
+// the history of the CONIKS directory identified by dirInitHash, in
+// chronological order, or nil if the auditor doesn't have any history
+// entries for this directory.
+func (l ConiksAuditLog) PrunedRanges(dirInitHash [crypto.HashSizeByte]byte) []*PrunedRange {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil
+	}
+	return h.pruned
+}
+
+// getRange returns the observed STRs for the epochs [start, end],
+// fetching the pruned STRs from the archive. Only the segments of
+// the pruned ranges which include the requested epochs are fetched.
+// getRange() assumes that end is at most the latest observed epoch.
+// It returns an ErrAuditLog if the archive fails to return a segment,
+// or returns a segment which doesn't match its commitment.
+func (h *directoryHistory) getRange(start, end uint64) ([]*protocol.DirSTR, error) {
+	var strs []*protocol.DirSTR
+	// the pruned ranges are consecutive and in chronological order
+	i := sort.Search(len(h.pruned), func(i int) bool {
+		return h.pruned[i].Last.Epoch >= start
+	})
+	for ; i < len(h.pruned) && h.pruned[i].First.Epoch <= end; i++ {
+		r := h.pruned[i]
+		from, to := r.First.Epoch, r.Last.Epoch
+		if start > from {
+			from = start
+		}
+		if end < to {
+			to = end
+		}
+		firstSeg := (from - r.First.Epoch) / segmentSize
+		lastSeg := (to - r.First.Epoch) / segmentSize
+		for seg := firstSeg; seg <= lastSeg; seg++ {
+			archived, err := r.fetchSegment(h.dirInitHash, seg)
+			if err != nil {
+				return nil, err
+			}
+			first := archived[0].Epoch
+			lo, hi := from, to
+			if lo < first {
+				lo = first
+			}
+			if last := archived[len(archived)-1].Epoch; hi > last {
+				hi = last
+			}
+			strs = append(strs, archived[lo-first:hi-first+1]...)
+		}
+	}
+	if start < h.oldest {
+		start = h.oldest
+	}
+	for ep := start; ep <= end; ep++ {
+		strs = append(strs, h.snapshots[ep])
+	}
+	return strs, nil
+}
+
+// A KVArchive is an Archive which stores the pruned STRs in
+// a key-value database.
+type KVArchive struct {
+	db kv.DB
+}
+
+var _ Archive = (*KVArchive)(nil)
+
+// NewKVArchive returns a new KVArchive which stores the pruned
+// STRs in db.
+func NewKVArchive(db kv.DB) *KVArchive {
+	return &KVArchive{db: db}
+}
+
+// archiveKey returns the database key of the archived STR for epoch
+// of the directory dirInitHash.
+func archiveKey(dirInitHash [crypto.HashSizeByte]byte, epoch uint64) []byte {
+	key := make([]byte, crypto.HashSizeByte+8)
+	copy(key, dirInitHash[:])
+	binary.BigEndian.PutUint64(key[crypto.HashSizeByte:], epoch)
+	return key
+}
+
+// Store implements Archive.Store().
+func (a *KVArchive) Store(dirInitHash [crypto.HashSizeByte]byte, strs []*protocol.DirSTR) error {
+	b := a.db.NewBatch()
+	for _, str := range strs {
+		buf, err := json.Marshal(str)
+		if err != nil {
+			return err
+		}
+		b.Put(archiveKey(dirInitHash, str.Epoch), buf)
+	}
+	return a.db.Write(b)
+}
+
+// Fetch implements Archive.Fetch().
+func (a *KVArchive) Fetch(dirInitHash [crypto.HashSizeByte]byte,
+	first, last uint64) ([]*protocol.DirSTR, error) {
+	var strs []*protocol.DirSTR
+	for ep := first; ep <= last; ep++ {
+		buf, err := a.db.Get(archiveKey(dirInitHash, ep))
+		if err != nil {
+			return nil, err
+		}
+		str := new(protocol.DirSTR)
+		if err := json.Unmarshal(buf, str); err != nil {
+			return nil, err
+		}
+		str.Ad = str.Policies
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
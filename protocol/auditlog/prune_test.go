@@ -0,0 +1,227 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// auditorKey signs the summaries of the pruned ranges.
+var auditorKey, _ = sign.GenerateKey(nil)
+
+// countingArchive wraps an Archive, and counts the STRs it returns.
+type countingArchive struct {
+	Archive
+	fetched *int
+}
+
+func (a countingArchive) Fetch(dirInitHash [crypto.HashSizeByte]byte,
+	first, last uint64) ([]*protocol.DirSTR, error) {
+	strs, err := a.Archive.Fetch(dirInitHash, first, last)
+	*a.fetched += len(strs)
+	return strs, err
+}
+
+// tamperingArchive wraps an Archive, and modifies the tree hash of
+// the last STR it returns.
+type tamperingArchive struct {
+	Archive
+}
+
+func (a tamperingArchive) Fetch(dirInitHash [crypto.HashSizeByte]byte,
+	first, last uint64) ([]*protocol.DirSTR, error) {
+	strs, err := a.Archive.Fetch(dirInitHash, first, last)
+	if err != nil {
+		return nil, err
+	}
+	tampered := strs[len(strs)-1]
+	str := *tampered.SignedTreeRoot
+	str.TreeHash = append([]byte{}, str.TreeHash...)
+	str.TreeHash[0]++
+	tampered.SignedTreeRoot = &str
+	return strs, nil
+}
+
+func TestPruneAndQuery(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		// create basic test directory and audit log with 11 STRs
+		_, aud, hist := NewTestAuditLog(t, 10)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+		archive := NewKVArchive(db)
+
+		if err := aud.Prune(dirInitHash, 4, archive, auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := aud.Prune(dirInitHash, 7, archive, auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		pruned := aud.PrunedRanges(dirInitHash)
+		if len(pruned) != 2 || pruned[0].First.Epoch != 0 ||
+			pruned[0].Last.Epoch != 3 || pruned[1].First.Epoch != 4 ||
+			pruned[1].Last.Epoch != 6 {
+			t.Fatal("Unexpected pruned ranges", pruned)
+		}
+
+		// a range spanning both pruned ranges and the kept STRs
+		res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			StartEpoch:     2,
+			EndEpoch:       8})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		obs := res.STRHistoryRange()
+		if len(obs.STR) != 7 {
+			t.Fatal("Expect", 7, "STRs, got", len(obs.STR))
+		}
+		for i, str := range obs.STR {
+			if str.Epoch != uint64(i+2) {
+				t.Fatal("Expect epoch", i+2, "got", str.Epoch)
+			}
+		}
+
+		if err := aud.VerifySTR(dirInitHash, hist[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := aud.VerifySTR(dirInitHash, hist[2]); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPruneSignedSummary(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		_, aud, hist := NewTestAuditLog(t, 2*segmentSize+2)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+		if err := aud.Prune(dirInitHash, 2*segmentSize+1, NewKVArchive(db), auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		r := aud.PrunedRanges(dirInitHash)[0]
+		if len(r.Segments) != 3 {
+			t.Fatal("Expect", 3, "segments, got", len(r.Segments))
+		}
+		pk, _ := auditorKey.Public()
+		if !r.Verify(pk) {
+			t.Fatal("Expect the summary to verify")
+		}
+		dirKey, _ := staticSigningKey.Public()
+		if r.Verify(dirKey) {
+			t.Fatal("Expect the summary not to verify with another key")
+		}
+		tampered := *r
+		tampered.Segments = append([][]byte{r.Segments[1]}, r.Segments[1:]...)
+		if tampered.Verify(pk) {
+			t.Fatal("Expect a tampered summary not to verify")
+		}
+	})
+}
+
+func TestPruneFetchesSegments(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		_, aud, hist := NewTestAuditLog(t, 3*segmentSize)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+		var fetched int
+		archive := countingArchive{NewKVArchive(db), &fetched}
+		if err := aud.Prune(dirInitHash, 3*segmentSize, archive, auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		// a single pruned epoch only fetches its segment
+		if err := aud.VerifySTR(dirInitHash, hist[segmentSize+1]); err != nil {
+			t.Fatal(err)
+		}
+		if fetched != segmentSize {
+			t.Fatal("Expect", segmentSize, "fetched STRs, got", fetched)
+		}
+		// a range across two segments fetches both
+		fetched = 0
+		res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			StartEpoch:     segmentSize - 1,
+			EndEpoch:       segmentSize})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		if strs := res.STRHistoryRange().STR; len(strs) != 2 ||
+			strs[0].Epoch != segmentSize-1 || strs[1].Epoch != segmentSize {
+			t.Fatal("Unexpected STR history range")
+		}
+		if fetched != 2*segmentSize {
+			t.Fatal("Expect", 2*segmentSize, "fetched STRs, got", fetched)
+		}
+	})
+}
+
+func TestPruneIntoSeveralArchives(t *testing.T) {
+	utils.WithDB(func(db1 kv.DB) {
+		utils.WithDB(func(db2 kv.DB) {
+			_, aud, hist := NewTestAuditLog(t, 6)
+			dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+			if err := aud.Prune(dirInitHash, 3, NewKVArchive(db1), auditorKey); err != nil {
+				t.Fatal(err)
+			}
+			if err := aud.Prune(dirInitHash, 5, NewKVArchive(db2), auditorKey); err != nil {
+				t.Fatal(err)
+			}
+			// each range is fetched from the archive it has been pruned into
+			for _, str := range hist[:5] {
+				if err := aud.VerifySTR(dirInitHash, str); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+func TestPruneTamperedArchive(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		_, aud, hist := NewTestAuditLog(t, 5)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+		archive := tamperingArchive{NewKVArchive(db)}
+		if err := aud.Prune(dirInitHash, 3, archive, auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		res := aud.GetObservedSTRs(&protocol.AuditingRequest{
+			DirInitSTRHash: dirInitHash,
+			StartEpoch:     1,
+			EndEpoch:       4})
+		if res.Error != protocol.ErrAuditLog {
+			t.Fatal("Expect", protocol.ErrAuditLog, "got", res.Error)
+		}
+		if err := aud.VerifySTR(dirInitHash, hist[1]); err != protocol.ErrAuditLog {
+			t.Fatal("Expect", protocol.ErrAuditLog, "got", err)
+		}
+		// the STRs which haven't been pruned are served regardless
+		if err := aud.VerifySTR(dirInitHash, hist[4]); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPruneBadEpoch(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		_, aud, hist := NewTestAuditLog(t, 3)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+		archive := NewKVArchive(db)
+
+		var unknown [crypto.HashSizeByte]byte
+		if err := aud.Prune(unknown, 1, archive, auditorKey); err != protocol.ReqUnknownDirectory {
+			t.Fatal("Expect", protocol.ReqUnknownDirectory, "got", err)
+		}
+		// the latest observed STR is never pruned
+		if err := aud.Prune(dirInitHash, 4, archive, auditorKey); err != protocol.ErrMalformedMessage {
+			t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+		}
+		if err := aud.Prune(dirInitHash, 2, archive, auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := aud.Prune(dirInitHash, 2, archive, auditorKey); err != protocol.ErrMalformedMessage {
+			t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+		}
+	})
+}
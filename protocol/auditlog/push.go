@@ -0,0 +1,67 @@
+// This module implements the auditor's side of the STR pushes, in which
+// a CONIKS directory submits each new STR to its auditors right after
+// issuing it, instead of waiting for the auditors to fetch it.
+// This shortens the window in which a directory can equivocate
+// without being detected by an auditor.
+
+package auditlog
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// ReceiveSTRs audits the STRs pushed by a CONIKS directory in the
+// STRPush req, inserts the STRs which extend the directory's history
+// into the history, and returns a protocol.Response.
+// The response (which is a protocol.NewSTRPushAck()) is sent back to
+// the directory.
+//
+// The pushed STRs for epochs the auditor has already observed are
+// checked against the observed history (see VerifySTR()). If the
+// directory has issued a different STR for one of these epochs, or
+// if the remaining STRs don't extend the observed history consistently
+// (see Audit()), ReceiveSTRs() acknowledges the push with the error
+// code of the failed check, and doesn't insert any of the STRs.
+// If the pushed STRs skip some epochs the auditor hasn't observed yet,
+// ReceiveSTRs() acknowledges the latest observed epoch without auditing
+// the STRs, so that the directory pushes the missing STRs next time.
+//
+// If the auditor doesn't have any history entries for the requested
+// CONIKS directory, ReceiveSTRs() returns a
+// message.NewErrorResponse(ReqUnknownDirectory), and a push which
+// doesn't contain any well-formed STR causes ReceiveSTRs() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+func (l ConiksAuditLog) ReceiveSTRs(req *protocol.STRPush) *protocol.Response {
+	h, ok := l.get(req.DirInitSTRHash)
+	if !ok {
+		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory)
+	}
+	if err := protocol.NewSTRHistoryRange(req.STR).Validate(); err != nil {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	latest := h.VerifiedSTR().Epoch
+	fresh := req.STR
+	for len(fresh) > 0 && fresh[0].Epoch <= latest {
+		if err := l.VerifySTR(req.DirInitSTRHash, fresh[0]); err != nil {
+			return protocol.NewSTRPushAck(latest, toErrorCode(err))
+		}
+		fresh = fresh[1:]
+	}
+	if len(fresh) == 0 || fresh[0].Epoch != latest+1 {
+		return protocol.NewSTRPushAck(latest, protocol.ReqSuccess)
+	}
+	if err := h.Audit(protocol.NewSTRHistoryRange(fresh)); err != nil {
+		return protocol.NewSTRPushAck(latest, toErrorCode(err))
+	}
+	return protocol.NewSTRPushAck(h.VerifiedSTR().Epoch, protocol.ReqSuccess)
+}
+
+// toErrorCode returns err if it is a protocol.ErrorCode,
+// and an ErrAuditLog otherwise.
+func toErrorCode(err error) protocol.ErrorCode {
+	if e, ok := err.(protocol.ErrorCode); ok {
+		return e
+	}
+	return protocol.ErrAuditLog
+}
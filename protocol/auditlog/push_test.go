@@ -0,0 +1,90 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestReceiveSTRs(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// the push includes the latest STR the auditor has observed
+	strs := []*protocol.DirSTR{d.LatestSTR()}
+	d.Update()
+	strs = append(strs, d.LatestSTR())
+	d.Update()
+	strs = append(strs, d.LatestSTR())
+
+	res := aud.ReceiveSTRs(&protocol.STRPush{DirInitSTRHash: dirInitHash, STR: strs})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if ack := res.STRPushAck(); ack.Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect", d.LatestSTR().Epoch, "got", ack.Epoch)
+	}
+	if aud.LatestObservedSTR(dirInitHash).Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the pushed STRs to be inserted into the history")
+	}
+}
+
+func TestReceiveSTRsGap(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	latest := d.LatestSTR().Epoch
+
+	d.Update()
+	d.Update()
+	res := aud.ReceiveSTRs(&protocol.STRPush{
+		DirInitSTRHash: dirInitHash,
+		STR:            []*protocol.DirSTR{d.LatestSTR()},
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if ack := res.STRPushAck(); ack.Epoch != latest {
+		t.Fatal("Expect", latest, "got", ack.Epoch)
+	}
+}
+
+func TestReceiveSTRsEquivocation(t *testing.T) {
+	_, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// the directory pushes another STR for an observed epoch
+	fork := directory.NewTestDirectory(t)
+	fork.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	fork.Update()
+	res := aud.ReceiveSTRs(&protocol.STRPush{
+		DirInitSTRHash: dirInitHash,
+		STR:            []*protocol.DirSTR{fork.LatestSTR()},
+	})
+	if res.Error != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", res.Error)
+	}
+	if ack := res.STRPushAck(); ack.Epoch != hist[1].Epoch {
+		t.Fatal("Expect", hist[1].Epoch, "got", ack.Epoch)
+	}
+}
+
+func TestReceiveSTRsBadRequest(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 1)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	var unknown [crypto.HashSizeByte]byte
+	res := aud.ReceiveSTRs(&protocol.STRPush{
+		DirInitSTRHash: unknown,
+		STR:            []*protocol.DirSTR{d.LatestSTR()},
+	})
+	if res.Error != protocol.ReqUnknownDirectory {
+		t.Fatal("Expect", protocol.ReqUnknownDirectory, "got", res.Error)
+	}
+	res = aud.ReceiveSTRs(&protocol.STRPush{DirInitSTRHash: dirInitHash})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
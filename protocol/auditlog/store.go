@@ -0,0 +1,163 @@
+// This module implements the persistence of an audit log, so that an
+// auditor can be restarted without losing its view of the histories of
+// the directories it audits. Each directory history is written to
+// a key-value database as a record of the directory (its address,
+// pinned signing key, the rotations of its signing key, its initial STR
+// and pruned ranges), and a record of each observed STR which hasn't
+// been pruned.
+
+package auditlog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+)
+
+var (
+	historyPrefix  = []byte("auditlog/history/")
+	observedPrefix = []byte("auditlog/str/")
+)
+
+// A persistedHistory is the record of a directory history,
+// besides the observed STRs.
+type persistedHistory struct {
+	Addr      string
+	SignKey   sign.PublicKey
+	Handovers []auditor.Handover `json:",omitempty"`
+	InitSTR   *protocol.DirSTR
+	Oldest    uint64
+	Pruned    []*PrunedRange `json:",omitempty"`
+}
+
+// historyKey returns the database key of the history of the directory
+// dirInitHash.
+func historyKey(dirInitHash [crypto.HashSizeByte]byte) []byte {
+	return append(append([]byte{}, historyPrefix...), dirInitHash[:]...)
+}
+
+// observedKey returns the database key of the observed STR for epoch
+// of the directory dirInitHash.
+func observedKey(dirInitHash [crypto.HashSizeByte]byte, epoch uint64) []byte {
+	key := make([]byte, len(observedPrefix)+crypto.HashSizeByte+8)
+	n := copy(key, observedPrefix)
+	n += copy(key[n:], dirInitHash[:])
+	binary.BigEndian.PutUint64(key[n:], epoch)
+	return key
+}
+
+// Flush writes the changes of the directory histories of the audit log
+// l since the previous Flush() to db, in a single atomic write: the
+// histories of the new directories, the newly observed STRs, and the
+// pruned ranges (see Prune()), whose STRs are deleted from db.
+// The observation reports of the clients (see ReportObservation())
+// aren't written. If the write fails, Flush() returns the database's
+// error, and the next Flush() writes the same changes again.
+func (l ConiksAuditLog) Flush(db kv.DB) error {
+	b := db.NewBatch()
+	for dirInitHash, h := range l {
+		buf, err := json.Marshal(&persistedHistory{
+			Addr:      h.addr,
+			SignKey:   h.signKey,
+			Handovers: h.Handovers(),
+			InitSTR:   h.initSTR,
+			Oldest:    h.oldest,
+			Pruned:    h.pruned,
+		})
+		if err != nil {
+			return err
+		}
+		b.Put(historyKey(dirInitHash), buf)
+		for ep := h.flushedOldest; ep < h.oldest && ep < h.flushed; ep++ {
+			b.Delete(observedKey(dirInitHash, ep))
+		}
+		start := h.flushed
+		if start < h.oldest {
+			start = h.oldest
+		}
+		for ep := start; ep <= h.VerifiedSTR().Epoch; ep++ {
+			buf, err := json.Marshal(h.snapshots[ep])
+			if err != nil {
+				return err
+			}
+			b.Put(observedKey(dirInitHash, ep), buf)
+		}
+	}
+	if err := db.Write(b); err != nil {
+		return err
+	}
+	for _, h := range l {
+		h.flushed = h.VerifiedSTR().Epoch + 1
+		h.flushedOldest = h.oldest
+	}
+	return nil
+}
+
+// Load reads the audit log written to db by Flush(). archive is the
+// archive into which the histories have been pruned, if any (see
+// Prune()), which serves all pruned ranges of the loaded histories.
+// As for the histories initialized by InitHistory(), the
+// STRs read from db aren't audited again.
+// Load() returns an empty audit log if db contains no audit log, and
+// the database's error if db can't be read.
+func Load(db kv.DB, archive Archive) (ConiksAuditLog, error) {
+	l := New()
+	iter := db.NewIterator(kv.BytesPrefix(historyPrefix))
+	defer iter.Release()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		ph := new(persistedHistory)
+		if err := json.Unmarshal(iter.Value(), ph); err != nil {
+			return nil, err
+		}
+		if ph.InitSTR == nil || ph.InitSTR.Epoch != 0 {
+			return nil, protocol.ErrMalformedMessage
+		}
+		h := newDirectoryHistory(ph.Addr, ph.SignKey, ph.InitSTR)
+		// the handovers of the pruned epochs aren't replayed
+		for _, ho := range ph.Handovers {
+			h.AddHandover(ho)
+		}
+		if ph.Oldest > 0 {
+			delete(h.snapshots, 0)
+		}
+		h.oldest, h.pruned = ph.Oldest, ph.Pruned
+		for _, r := range h.pruned {
+			r.archive = archive
+		}
+		if err := h.loadSTRs(db); err != nil {
+			return nil, err
+		}
+		h.flushed = h.VerifiedSTR().Epoch + 1
+		h.flushedOldest = h.oldest
+		l.set(h.dirInitHash, h)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadSTRs reads the observed STRs of the directory history h from db,
+// starting at its oldest epoch, in chronological order. The STRs are
+// decoded as the auditor receives them from the directory, so that
+// the directory's STRs compare equal to the restored ones.
+func (h *directoryHistory) loadSTRs(db kv.DB) error {
+	iter := db.NewIterator(&kv.Range{
+		Start: observedKey(h.dirInitHash, h.oldest),
+		Limit: kv.IncrementKey(observedKey(h.dirInitHash, ^uint64(0))),
+	})
+	defer iter.Release()
+	for ok := iter.First(); ok; ok = iter.Next() {
+		str := new(protocol.DirSTR)
+		if err := json.Unmarshal(iter.Value(), str); err != nil {
+			return err
+		}
+		h.updateVerifiedSTR(str)
+	}
+	return iter.Error()
+}
@@ -0,0 +1,96 @@
+package auditlog
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// checkHistory checks that the audit log aud has observed the STRs
+// hist of the directory dirInitHash.
+func checkHistory(t *testing.T, aud ConiksAuditLog, hist []*protocol.DirSTR) {
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+	latest := hist[len(hist)-1]
+	if str := aud.LatestObservedSTR(dirInitHash); str == nil ||
+		str.Epoch != latest.Epoch {
+		t.Fatal("Expect the latest observed epoch", latest.Epoch, "got", str)
+	}
+	for _, str := range hist {
+		if err := aud.VerifySTR(dirInitHash, str); err != nil {
+			t.Fatal("Expect the STR of epoch", str.Epoch, "got", err)
+		}
+	}
+}
+
+func TestFlushAndLoad(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		d, aud, hist := NewTestAuditLog(t, 3)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+		if err := aud.Flush(db); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHistory(t, loaded, hist)
+
+		// the loaded log keeps auditing the directory
+		d.Update()
+		hist = append(hist, d.LatestSTR())
+		if err := loaded.Update(dirInitHash,
+			protocol.NewSTRHistoryRange(hist[len(hist)-1:])); err != nil {
+			t.Fatal(err)
+		}
+		if err := loaded.Flush(db); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err = Load(db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkHistory(t, loaded, hist)
+	})
+}
+
+func TestLoadEmpty(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		aud, err := Load(db, nil)
+		if err != nil || len(aud) != 0 {
+			t.Fatal("Expect an empty audit log, got", aud, err)
+		}
+	})
+}
+
+func TestFlushPruned(t *testing.T) {
+	utils.WithDB(func(db kv.DB) {
+		_, aud, hist := NewTestAuditLog(t, 10)
+		dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+		archive := NewKVArchive(db)
+		if err := aud.Flush(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := aud.Prune(dirInitHash, 4, archive, auditorKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := aud.Flush(db); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get(observedKey(dirInitHash, 3)); err != db.ErrNotFound() {
+			t.Fatal("Expect the pruned STRs to be deleted, got", err)
+		}
+
+		loaded, err := Load(db, archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pruned := loaded.PrunedRanges(dirInitHash); len(pruned) != 1 ||
+			pruned[0].Last.Epoch != 3 {
+			t.Fatal("Expect the pruned range [0, 3], got", pruned)
+		}
+		checkHistory(t, loaded, hist)
+	})
+}
@@ -0,0 +1,176 @@
+// This module implements the aggregation of anonymous STR observation
+// reports that opted-in CONIKS clients send to an auditor.
+// Aggregating the STRs that different clients have observed for the same
+// epoch allows the auditor to detect partitioning attacks, in which
+// a directory feeds some of its clients a forked STR history.
+
+package auditlog
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+const (
+	// MaxReportsPerEpoch is the maximum number of observation reports
+	// an auditor accepts for a single epoch of a directory.
+	MaxReportsPerEpoch = 1 << 16
+
+	// MaxReportsPerPeer is the maximum number of observation reports
+	// an auditor accepts for a single epoch of a directory from a
+	// single peer, e.g., from the clients behind the same IP address.
+	// Since the reporter tags are chosen by the clients, this limit
+	// keeps any single peer from exhausting MaxReportsPerEpoch.
+	MaxReportsPerPeer = 16
+
+	// ReportWindow is the number of most recent epochs for which an
+	// auditor keeps aggregated observation reports.
+	ReportWindow = 64
+)
+
+// epochObservations aggregates the reports received for a single epoch.
+// It only keeps the number of reports per observed STR hash, the
+// reporter tags seen in this epoch to discard duplicate reports, and
+// the number of reports per peer, which isn't linked to the reports.
+type epochObservations struct {
+	counts map[[crypto.HashSizeByte]byte]uint64
+	tags   map[[crypto.HashSizeByte]byte]bool
+	peers  map[string]int
+	total  uint64
+}
+
+func newEpochObservations() *epochObservations {
+	return &epochObservations{
+		counts: make(map[[crypto.HashSizeByte]byte]uint64),
+		tags:   make(map[[crypto.HashSizeByte]byte]bool),
+		peers:  make(map[string]int),
+	}
+}
+
+// A PartitionEvidence summarizes the observation reports for an epoch
+// in which the reporting clients, or the reporting clients and the
+// auditor, have observed different STRs for the same directory.
+// Counts maps each observed STR hash to the number of clients
+// which reported it.
+type PartitionEvidence struct {
+	Epoch    uint64
+	Verified [crypto.HashSizeByte]byte
+	Counts   map[[crypto.HashSizeByte]byte]uint64
+}
+
+// ReportObservation aggregates the observation report req received from
+// a CONIKS client at the address peer, e.g., the client's IP address,
+// into the history of the directory indicated in the report, and
+// returns a protocol.Response.
+// The response (which only includes the error code) is sent back to
+// the client.
+//
+// If the auditor doesn't have any history entries for the requested CONIKS
+// directory, ReportObservation() returns a
+// message.NewErrorResponse(ReqUnknownDirectory).
+// A report for an epoch greater than the latest observed epoch of
+// this directory plus one, or older than the aggregation window,
+// is considered malformed and causes ReportObservation() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// ReportObservation() returns a message.NewErrorResponse(ReqRateLimited)
+// if the report's reporter tag has already been seen in the same epoch,
+// or if the auditor has received MaxReportsPerPeer reports from peer,
+// or MaxReportsPerEpoch reports in total, for this epoch already.
+func (l ConiksAuditLog) ReportObservation(req *protocol.ObservationReport,
+	peer string) *protocol.Response {
+	h, ok := l.get(req.DirInitSTRHash)
+	if !ok {
+		return protocol.NewErrorResponse(protocol.ReqUnknownDirectory)
+	}
+
+	latest := h.VerifiedSTR().Epoch
+	if req.Epoch > latest+1 ||
+		(latest >= ReportWindow && req.Epoch <= latest-ReportWindow) {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	obs := h.observations[req.Epoch]
+	if obs == nil {
+		obs = newEpochObservations()
+		h.observations[req.Epoch] = obs
+		h.pruneObservations()
+	}
+	if obs.tags[req.ReporterTag] || obs.peers[peer] >= MaxReportsPerPeer ||
+		obs.total >= MaxReportsPerEpoch {
+		return protocol.NewErrorResponse(protocol.ReqRateLimited)
+	}
+	obs.tags[req.ReporterTag] = true
+	obs.peers[peer]++
+	obs.counts[req.STRHash]++
+	obs.total++
+
+	return protocol.NewErrorResponse(protocol.ReqSuccess)
+}
+
+// DetectPartitions returns the evidence for all epochs within the
+// aggregation window in which the clients' observation reports for the
+// directory dirInitHash disagree, either among each other, or with the
+// STR the auditor has verified for the same epoch.
+// The evidence is sorted by epoch in ascending order.
+// DetectPartitions() returns a nil slice if the auditor doesn't have
+// any history entries for the requested directory.
+func (l ConiksAuditLog) DetectPartitions(dirInitHash [crypto.HashSizeByte]byte) []*PartitionEvidence {
+	h, ok := l.get(dirInitHash)
+	if !ok {
+		return nil
+	}
+
+	var evidence []*PartitionEvidence
+	for ep, obs := range h.observations {
+		var verified [crypto.HashSizeByte]byte
+		str, known := h.snapshots[ep]
+		if !known && ep < h.oldest {
+			// the epoch has been pruned, see Prune()
+			if strs, err := h.getRange(ep, ep); err == nil {
+				str, known = strs[0], true
+			}
+		}
+		if known {
+			copy(verified[:], crypto.Digest(str.Signature))
+		}
+		diverged := len(obs.counts) > 1
+		for strHash := range obs.counts {
+			if known && !bytes.Equal(strHash[:], verified[:]) {
+				diverged = true
+			}
+		}
+		if !diverged {
+			continue
+		}
+		counts := make(map[[crypto.HashSizeByte]byte]uint64, len(obs.counts))
+		for strHash, n := range obs.counts {
+			counts[strHash] = n
+		}
+		evidence = append(evidence, &PartitionEvidence{
+			Epoch:    ep,
+			Verified: verified,
+			Counts:   counts,
+		})
+	}
+	sort.Slice(evidence, func(i, j int) bool {
+		return evidence[i].Epoch < evidence[j].Epoch
+	})
+	return evidence
+}
+
+// pruneObservations drops the aggregated reports for all epochs which
+// have fallen out of the aggregation window.
+func (h *directoryHistory) pruneObservations() {
+	latest := h.VerifiedSTR().Epoch
+	if latest < ReportWindow {
+		return
+	}
+	for ep := range h.observations {
+		if ep <= latest-ReportWindow {
+			delete(h.observations, ep)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package auditlog
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+)
+
+func newTestReport(dirInitHash [crypto.HashSizeByte]byte, str *protocol.DirSTR,
+	reporter string) *protocol.ObservationReport {
+	report := &protocol.ObservationReport{
+		DirInitSTRHash: dirInitHash,
+		Epoch:          str.Epoch,
+	}
+	copy(report.STRHash[:], crypto.Digest(str.Signature))
+	copy(report.ReporterTag[:], crypto.Digest([]byte(reporter)))
+	return report
+}
+
+func TestReportObservationConsistent(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 2)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	for _, reporter := range []string{"alice", "bob", "carol"} {
+		res := aud.ReportObservation(newTestReport(dirInitHash, d.LatestSTR(), reporter), reporter)
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+	}
+	if ev := aud.DetectPartitions(dirInitHash); len(ev) != 0 {
+		t.Fatal("Expect no partition evidence, got", len(ev))
+	}
+}
+
+func TestReportObservationDetectPartition(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 2)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	str := d.LatestSTR()
+	aud.ReportObservation(newTestReport(dirInitHash, str, "alice"), "alice")
+
+	// a forked STR for the same epoch
+	forked := *str.SignedTreeRoot
+	forked.Signature = append([]byte{}, str.Signature...)
+	forked.Signature[0]++
+	res := aud.ReportObservation(newTestReport(dirInitHash,
+		&protocol.DirSTR{SignedTreeRoot: &forked, Policies: str.Policies}, "bob"), "bob")
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+
+	ev := aud.DetectPartitions(dirInitHash)
+	if len(ev) != 1 {
+		t.Fatal("Expect evidence for 1 epoch, got", len(ev))
+	}
+	if ev[0].Epoch != str.Epoch || len(ev[0].Counts) != 2 {
+		t.Fatal("Unexpected partition evidence", ev[0])
+	}
+}
+
+func TestReportObservationRateLimit(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	report := newTestReport(dirInitHash, d.LatestSTR(), "alice")
+	if res := aud.ReportObservation(report, "alice"); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	// the same reporter tag in the same epoch is dropped
+	if res := aud.ReportObservation(report, "bob"); res.Error != protocol.ReqRateLimited {
+		t.Fatal("Expect", protocol.ReqRateLimited, "got", res.Error)
+	}
+}
+
+func TestReportObservationPeerLimit(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	// a single peer can't exhaust the reports of the epoch with
+	// reporter tags of its choice
+	for i := 0; i <= MaxReportsPerPeer; i++ {
+		report := newTestReport(dirInitHash, d.LatestSTR(), "tag"+strconv.Itoa(i))
+		want := protocol.ReqSuccess
+		if i == MaxReportsPerPeer {
+			want = protocol.ReqRateLimited
+		}
+		if res := aud.ReportObservation(report, "mallory"); res.Error != want {
+			t.Fatal("Expect", want, "got", res.Error)
+		}
+	}
+	report := newTestReport(dirInitHash, d.LatestSTR(), "alice")
+	if res := aud.ReportObservation(report, "alice"); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	// and its quota is renewed in the next epoch
+	d.Update()
+	report = newTestReport(dirInitHash, d.LatestSTR(), "tag0")
+	if res := aud.ReportObservation(report, "mallory"); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+}
+
+func TestReportObservationBadRequest(t *testing.T) {
+	d, aud, hist := NewTestAuditLog(t, 0)
+	dirInitHash := auditor.ComputeDirectoryIdentity(hist[0])
+
+	var unknown [crypto.HashSizeByte]byte
+	res := aud.ReportObservation(newTestReport(unknown, d.LatestSTR(), "alice"), "alice")
+	if res.Error != protocol.ReqUnknownDirectory {
+		t.Fatal("Expect", protocol.ReqUnknownDirectory, "got", res.Error)
+	}
+
+	report := newTestReport(dirInitHash, d.LatestSTR(), "alice")
+	report.Epoch = d.LatestSTR().Epoch + 2
+	if res := aud.ReportObservation(report, "alice"); res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
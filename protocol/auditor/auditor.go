@@ -6,6 +6,8 @@ package auditor
 
 import (
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/protocol"
@@ -18,58 +20,241 @@ type Auditor interface {
 }
 
 // AudState verifies the hash chain of a specific directory.
+// Its verified STR may be read and updated concurrently.
 type AudState struct {
 	signKey     sign.PublicKey
+	lock        sync.RWMutex // guards verifiedSTR and the handovers
 	verifiedSTR *protocol.DirSTR
+	// handovers lists the rotations of the directory's signing key
+	// the AudState has verified, in chronological order
+	handovers []Handover
+	// handoverSTRs lists the cross-signed STRs declaring the verified
+	// handovers, in chronological order
+	handoverSTRs []*protocol.DirSTR
+
+	// observe is called after each signature verification,
+	// see ObserveSignatures()
+	observe func(elapsed time.Duration, valid bool)
+	// beacon checks the randomness beacon values of the STRs,
+	// see SetBeaconVerifier()
+	beacon protocol.BeaconVerifier
 }
 
 var _ Auditor = (*AudState)(nil)
 
+// A Handover records that a directory signs its STRs with Key from
+// Epoch on, i.e., from the epoch following the cross-signed STR whose
+// policies declare Key (see protocol.DirSTR.VerifyHandover()).
+type Handover struct {
+	Epoch uint64
+	Key   sign.PublicKey
+}
+
 // New instantiates a new auditor state from a persistance storage.
+// signKey is the key which signs verified; if the policies of verified
+// hand the signing over to a new key, the AudState verifies the
+// following STRs with the new key.
 func New(signKey sign.PublicKey, verified *protocol.DirSTR) *AudState {
 	a := &AudState{
 		signKey:     signKey,
 		verifiedSTR: verified,
 	}
+	a.recordHandover(verified)
 	return a
 }
 
-// Verify verifies a signature sig on message using the underlying
-// public-key of the AudState.
+// Verify verifies a signature sig on message using the directory's
+// current signing key (see SigningKey()).
 func (a *AudState) Verify(message, sig []byte) bool {
-	return a.signKey.Verify(message, sig)
+	return a.verify(a.SigningKey(), message, sig)
+}
+
+// VerifySignature verifies the signature of the STR str using the
+// signing key of the directory at str's epoch, so that the STRs issued
+// before a rotation of the key still verify.
+func (a *AudState) VerifySignature(str *protocol.DirSTR) bool {
+	return a.verify(a.signingKeyAt(str.Epoch), str.Serialize(), str.Signature)
+}
+
+func (a *AudState) verify(key sign.PublicKey, message, sig []byte) bool {
+	if a.observe == nil {
+		return key.Verify(message, sig)
+	}
+	start := time.Now()
+	valid := key.Verify(message, sig)
+	a.observe(time.Since(start), valid)
+	return valid
+}
+
+// SigningKey returns the directory's current signing key, i.e., the
+// key which signs the STR following the verified STR, along with the
+// directory's temporary bindings and policy documents.
+func (a *AudState) SigningKey() sign.PublicKey {
+	return a.signingKeyAt(a.VerifiedSTR().Epoch + 1)
+}
+
+// signingKeyAt returns the key which signs the directory's STR for
+// epoch.
+func (a *AudState) signingKeyAt(epoch uint64) sign.PublicKey {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	key := a.signKey
+	for _, h := range a.handovers {
+		if h.Epoch > epoch {
+			break
+		}
+		key = h.Key
+	}
+	return key
+}
+
+// Handovers returns the rotations of the directory's signing key the
+// AudState has verified, e.g., to persist them along with the verified
+// STR.
+func (a *AudState) Handovers() []Handover {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return append([]Handover(nil), a.handovers...)
+}
+
+// AddHandover records the rotation h of the directory's signing key,
+// e.g., read from a persistent storage; the caller is responsible for
+// its authenticity. A handover for an epoch which already has one
+// replaces it.
+func (a *AudState) AddHandover(h Handover) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	i := 0
+	for i < len(a.handovers) && a.handovers[i].Epoch < h.Epoch {
+		i++
+	}
+	if i < len(a.handovers) && a.handovers[i].Epoch == h.Epoch {
+		a.handovers[i] = h
+		return
+	}
+	a.handovers = append(a.handovers, Handover{})
+	copy(a.handovers[i+1:], a.handovers[i:])
+	a.handovers[i] = h
+}
+
+// HandoverSTRs returns the cross-signed STRs declaring the rotations
+// of the directory's signing key the AudState has verified, e.g., to
+// persist them along with the verified STR (see RestoreHandover()).
+func (a *AudState) HandoverSTRs() []*protocol.DirSTR {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return append([]*protocol.DirSTR(nil), a.handoverSTRs...)
+}
+
+// RestoreHandover records the rotation of the directory's signing key
+// declared by the STR str, e.g., read from a persistent storage, after
+// verifying str's signature with the key the AudState trusts at str's
+// epoch and str's cross signature (see protocol.DirSTR.VerifyHandover()).
+// The STRs must thus be restored in chronological order, starting from
+// the pinned signing key. It returns a CheckBadSignature if either
+// signature is invalid, and an ErrMalformedMessage if str is malformed
+// or doesn't declare a rotation.
+func (a *AudState) RestoreHandover(str *protocol.DirSTR) error {
+	if str == nil || str.SignedTreeRoot == nil || str.Policies == nil ||
+		len(str.Policies.NextSigningKey) == 0 {
+		return protocol.ErrMalformedMessage
+	}
+	if !a.VerifySignature(str) {
+		return protocol.CheckBadSignature
+	}
+	if err := str.VerifyHandover(); err != nil {
+		return err
+	}
+	a.recordHandover(str)
+	return nil
+}
+
+// recordHandover records the rotation of the signing key declared by
+// the policies of str, if any.
+func (a *AudState) recordHandover(str *protocol.DirSTR) {
+	if str.Policies == nil || len(str.Policies.NextSigningKey) == 0 {
+		return
+	}
+	a.AddHandover(Handover{Epoch: str.Epoch + 1, Key: str.Policies.NextSigningKey})
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	i := 0
+	for i < len(a.handoverSTRs) && a.handoverSTRs[i].Epoch < str.Epoch {
+		i++
+	}
+	if i < len(a.handoverSTRs) && a.handoverSTRs[i].Epoch == str.Epoch {
+		a.handoverSTRs[i] = str
+		return
+	}
+	a.handoverSTRs = append(a.handoverSTRs, nil)
+	copy(a.handoverSTRs[i+1:], a.handoverSTRs[i:])
+	a.handoverSTRs[i] = str
+}
+
+// ObserveSignatures sets the function called with the duration and the
+// result of each signature verification of the AudState, including the
+// verifications of the STRs' signatures, e.g. to collect metrics.
+func (a *AudState) ObserveSignatures(observe func(elapsed time.Duration, valid bool)) {
+	a.observe = observe
+}
+
+// SetBeaconVerifier makes the AudState check that the randomness beacon
+// value included in each new STR, if any, is genuine according to v
+// (see protocol.VerifyBeaconChain()). Without a verifier, the AudState
+// only checks that the beacon rounds of consecutive STRs are in order.
+func (a *AudState) SetBeaconVerifier(v protocol.BeaconVerifier) {
+	a.beacon = v
 }
 
 // VerifiedSTR returns the newly verified STR.
 func (a *AudState) VerifiedSTR() *protocol.DirSTR {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
 	return a.verifiedSTR
 }
 
-// Update updates the auditor's verifiedSTR to newSTR
+// Update updates the auditor's verifiedSTR to newSTR, and records the
+// rotation of the signing key newSTR declares, if any.
 func (a *AudState) Update(newSTR *protocol.DirSTR) {
+	a.recordHandover(newSTR)
+	a.lock.Lock()
+	defer a.lock.Unlock()
 	a.verifiedSTR = newSTR
 }
 
 // compareWithVerified checks whether the received STR is the same as
 // the verified STR in the AudState using reflect.DeepEqual().
 func (a *AudState) compareWithVerified(str *protocol.DirSTR) error {
-	if reflect.DeepEqual(a.verifiedSTR, str) {
+	if reflect.DeepEqual(a.VerifiedSTR(), str) {
 		return nil
 	}
 	return protocol.CheckBadSTR
 }
 
 // verifySTRConsistency checks the consistency between 2 snapshots.
-// It uses the signing key signKey to verify the STR's signature.
-// The signKey param either comes from a client's
-// pinned signing key in its consistency state,
-// or an auditor's pinned signing key in its history.
+// It verifies the STR's signature with the directory's signing key at
+// the STR's epoch, which is either a client's or an auditor's pinned
+// signing key, or a key the directory has handed the signing over to
+// in a previous STR. If the STR hands the signing over to a new key,
+// verifySTRConsistency() checks the STR's cross signature, and records
+// the new key once the STR is consistent.
 func (a *AudState) verifySTRConsistency(prevSTR, str *protocol.DirSTR) error {
+	// the STR's cipher suite declares its signature scheme
+	if err := str.CheckHeader(); err != nil {
+		return err
+	}
 	// verify STR's signature
-	if !a.signKey.Verify(str.Serialize(), str.Signature) {
+	if !a.VerifySignature(str) {
 		return protocol.CheckBadSignature
 	}
+	if err := str.VerifyHandover(); err != nil {
+		return err
+	}
+	if err := protocol.VerifyBeaconChain(prevSTR, str, a.beacon); err != nil {
+		return err
+	}
 	if str.VerifyHashChain(prevSTR) {
+		a.recordHandover(str)
 		return nil
 	}
 
@@ -91,15 +276,16 @@ func (a *AudState) CheckSTRAgainstVerified(str *protocol.DirSTR) error {
 	// Maybe it has something to do w/ #81 and client
 	// transitioning between epochs.
 	// Try to verify w/ what's been saved
+	verified := a.VerifiedSTR()
 	switch {
-	case str.Epoch == a.verifiedSTR.Epoch:
+	case str.Epoch == verified.Epoch:
 		// Checking an STR in the same epoch
 		if err := a.compareWithVerified(str); err != nil {
 			return err
 		}
-	case str.Epoch == a.verifiedSTR.Epoch+1:
+	case str.Epoch == verified.Epoch+1:
 		// Otherwise, expect that we've entered a new epoch
-		if err := a.verifySTRConsistency(a.verifiedSTR, str); err != nil {
+		if err := a.verifySTRConsistency(verified, str); err != nil {
 			return err
 		}
 	default:
@@ -1,9 +1,12 @@
 package auditor
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/directory"
 )
@@ -22,7 +25,7 @@ func TestAuditBadSTRSignature(t *testing.T) {
 	d.Update()
 
 	// modify the latest STR so that the consistency check fails
-	str := d.LatestSTR()
+	str := *d.LatestSTR()
 	str2 := *str.SignedTreeRoot
 	str2.Signature = append([]byte{}, str.Signature...)
 	str2.Signature[0]++
@@ -30,7 +33,7 @@ func TestAuditBadSTRSignature(t *testing.T) {
 
 	// try to audit a new STR with a bad signature:
 	// case signature verification failure in verifySTRConsistency()
-	err := aud.AuditDirectory([]*protocol.DirSTR{str})
+	err := aud.AuditDirectory([]*protocol.DirSTR{&str})
 	if err != protocol.CheckBadSignature {
 		t.Error("Expect", protocol.CheckBadSignature, "got", err)
 	}
@@ -44,7 +47,7 @@ func TestAuditBadSameEpoch(t *testing.T) {
 	// create a generic auditor state
 	aud := New(pk, d.LatestSTR())
 
-	str := d.LatestSTR()
+	str := *d.LatestSTR()
 	// modify the pinned STR so that the consistency check should fail.
 	str2 := *str.SignedTreeRoot
 	str2.Signature = append([]byte{}, str.Signature...)
@@ -53,7 +56,7 @@ func TestAuditBadSameEpoch(t *testing.T) {
 
 	// try to audit a diverging STR for the same epoch
 	// case compareWithVerified() == false in checkAgainstVerified()
-	err := aud.AuditDirectory([]*protocol.DirSTR{str})
+	err := aud.AuditDirectory([]*protocol.DirSTR{&str})
 	if err != protocol.CheckBadSTR {
 		t.Error("Expect", protocol.CheckBadSTR, "got", err)
 	}
@@ -90,7 +93,7 @@ func TestAuditBadNewSTREpoch(t *testing.T) {
 		StartEpoch: uint64(2),
 		EndEpoch:   uint64(2)})
 
-	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := resp.STRHistoryRange()
 	err = aud.AuditDirectory(strs.STR)
 	if err != protocol.CheckBadSTR {
 		t.Error("str.Epoch < verified.Epoch - Expect", protocol.CheckBadSTR, "got", err)
@@ -123,7 +126,7 @@ func TestAuditMalformedSTRRange(t *testing.T) {
 		t.Fatalf("Error occurred getting the latest STR from the directory: %s", resp.Error)
 	}
 
-	strs := resp.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := resp.STRHistoryRange()
 	// make a malformed range
 	strs.STR[2] = nil
 
@@ -134,3 +137,170 @@ func TestAuditMalformedSTRRange(t *testing.T) {
 		t.Error("Expect", protocol.ErrMalformedMessage, "got", err1)
 	}
 }
+
+type fakeBeaconVerifier map[uint64]bool
+
+func (v fakeBeaconVerifier) VerifyBeacon(b *protocol.BeaconValue) bool {
+	return v[b.Round]
+}
+
+func TestAuditBeacon(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.SetBeacon(&protocol.BeaconValue{Round: 5, Randomness: []byte{5}})
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+	aud.SetBeaconVerifier(fakeBeaconVerifier{5: true, 6: true})
+
+	for _, tc := range []struct {
+		name   string
+		beacon *protocol.BeaconValue
+		want   error
+	}{
+		{"same round", &protocol.BeaconValue{Round: 5, Randomness: []byte{5}}, nil},
+		{"next round", &protocol.BeaconValue{Round: 6, Randomness: []byte{6}}, nil},
+		{"no beacon", nil, nil},
+		{"unverified round", &protocol.BeaconValue{Round: 7, Randomness: []byte{7}}, protocol.CheckBadBeacon},
+	} {
+		d.SetBeacon(tc.beacon)
+		d.Update()
+		if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != tc.want {
+			t.Fatal(tc.name, "expect", tc.want, "got", err)
+		}
+		if tc.want == nil {
+			aud.Update(d.LatestSTR())
+		}
+	}
+
+	// the rounds of consecutive STRs must be in order
+	aud = New(pk, d.LatestSTR())
+	d.SetBeacon(&protocol.BeaconValue{Round: 8, Randomness: []byte{8}})
+	d.Update()
+	aud.Update(d.LatestSTR())
+	d.SetBeacon(&protocol.BeaconValue{Round: 6, Randomness: []byte{6}})
+	d.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != protocol.CheckBadBeacon {
+		t.Fatal("Expect", protocol.CheckBadBeacon, "got", err)
+	}
+}
+
+func TestAuditSTRExtensions(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+
+	// unknown non-critical extensions are ignored
+	d.SetSTRExtensions(map[string]*merkletree.STRExtension{
+		"timestamp": {Value: []byte{1}},
+	})
+	d.Update()
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+		t.Fatal(err)
+	}
+	aud.Update(d.LatestSTR())
+
+	d.SetSTRExtensions(map[string]*merkletree.STRExtension{
+		"cosign": {Critical: true, Value: []byte{2}},
+	})
+	d.Update()
+	err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()})
+	if err != protocol.CheckUnsupportedSTR {
+		t.Fatal("Expect", protocol.CheckUnsupportedSTR, "got", err)
+	}
+}
+
+func TestAuditUnsupportedCipherSuite(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Update()
+	pk, _ := staticSigningKey.Public()
+	aud := New(pk, d.LatestSTR())
+
+	d.Update()
+	str := *d.LatestSTR()
+	policies := *str.Policies
+	policies.CipherSuite = &protocol.CipherSuite{
+		Signature: "ed448",
+		Vrf:       policies.CipherSuite.Vrf,
+		Hash:      policies.CipherSuite.Hash,
+	}
+	str.Policies = &policies
+	// the signature scheme is unknown, so the suite is checked before
+	// the STR's signature
+	err := aud.AuditDirectory([]*protocol.DirSTR{&str})
+	if err != protocol.CheckUnsupportedSuite {
+		t.Fatal("Expect", protocol.CheckUnsupportedSuite, "got", err)
+	}
+	if err := aud.AuditDirectory([]*protocol.DirSTR{d.LatestSTR()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditSigningKeyRotation(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	oldPK, _ := staticSigningKey.Public()
+	initSTR := d.LatestSTR()
+	key, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := key.Public()
+	if err := d.RotateSigningKey(key); err != nil {
+		t.Fatal(err)
+	}
+	// the STR for epoch 2 hands the signing over to the new key
+	for e := 0; e < 4; e++ {
+		d.Update()
+	}
+	strs := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   4,
+	}).STRHistoryRange().STR
+
+	// audit the handover within a range
+	aud := New(oldPK, initSTR)
+	if err := aud.AuditDirectory(strs); err != nil {
+		t.Fatal(err)
+	}
+	for _, str := range strs {
+		aud.Update(str)
+	}
+	if !bytes.Equal(aud.SigningKey(), pk) {
+		t.Fatal("Expect the new signing key")
+	}
+	if !aud.VerifySignature(strs[0]) || !aud.VerifySignature(strs[3]) {
+		t.Fatal("Expect the STRs to verify with the keys of their epochs")
+	}
+	if h := aud.Handovers(); len(h) != 1 || h[0].Epoch != 3 ||
+		!bytes.Equal(h[0].Key, pk) {
+		t.Fatal("Unexpected handovers", h)
+	}
+
+	// audit the handover one epoch at a time
+	aud = New(oldPK, initSTR)
+	for _, str := range strs {
+		if err := aud.AuditDirectory([]*protocol.DirSTR{str}); err != nil {
+			t.Fatal(err)
+		}
+		aud.Update(str)
+	}
+	if !bytes.Equal(aud.SigningKey(), pk) {
+		t.Fatal("Expect the new signing key")
+	}
+
+	// the STRs signed with the new key aren't accepted without the
+	// cross-signed STR
+	aud = New(oldPK, strs[0])
+	str := *strs[1]
+	str2 := *str.SignedTreeRoot
+	str2.CrossSignature = append([]byte{}, str.CrossSignature...)
+	str2.CrossSignature[0]++
+	str.SignedTreeRoot = &str2
+	err = aud.AuditDirectory([]*protocol.DirSTR{&str, strs[2]})
+	if err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	if h := aud.Handovers(); len(h) != 0 {
+		t.Fatal("Expect no handovers, got", h)
+	}
+}
@@ -20,7 +20,7 @@ func TestComputeDirectoryIdentity(t *testing.T) {
 		str  *protocol.DirSTR
 		want []byte
 	}{
-		{"normal", str0, hex2bin("fd0584f79054f8113f21e5450e0ad21c9221fc159334c7bc1644e3e2a0fb5060")},
+		{"normal", str0, hex2bin("c94685be213f37d8b2267e25f38145ab46492e33da7246b1d077c365df458b0e")},
 		{"panic", str1, []byte{}},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
@@ -0,0 +1,143 @@
+// This module implements the detection of forks in a directory's STR
+// history, i.e. of a directory presenting different STRs for the same
+// epoch to different parties, by comparing two exports of the history.
+
+package auditor
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// ErrNoOverlap indicates that two STR histories don't have any epoch
+// in common, so that they can't be compared.
+var ErrNoOverlap = errors.New("[coniks] The STR histories have no epoch in common")
+
+// An EquivocationEvidence proves that a directory has issued two
+// different STRs for the same epoch Epoch. STR contains both STRs,
+// which are signed by the directory. Common is the last STR which both
+// forks of the history share, i.e. the STR for the epoch before Epoch
+// to which both STRs are hash-chained, or nil if the compared histories
+// already diverge at their first common epoch.
+// Anyone who knows the directory's public signing key can check the
+// evidence with Verify().
+type EquivocationEvidence struct {
+	Epoch  uint64
+	Common *protocol.DirSTR `json:",omitempty"`
+	STR    [2]*protocol.DirSTR
+}
+
+// FindFork compares the STR histories a and b of the directory whose
+// public signing key is pk, and returns the evidence of the directory's
+// equivocation at the first epoch for which the histories contain
+// different STRs, or nil if the histories agree on all their common
+// epochs.
+//
+// Each history must be a range of STRs for consecutive epochs, forming
+// a hash chain signed by the directory. Otherwise, FindFork() returns
+// the consistency check error of the invalid history (see
+// VerifySTRRange()), or an ErrMalformedMessage if the history is empty
+// or has a gap. It returns ErrNoOverlap if the histories have no epoch
+// in common.
+func FindFork(pk sign.PublicKey, a, b []*protocol.DirSTR) (*EquivocationEvidence, error) {
+	for _, h := range [][]*protocol.DirSTR{a, b} {
+		if err := verifyHistory(pk, h); err != nil {
+			return nil, err
+		}
+	}
+	first, last := a[0].Epoch, a[len(a)-1].Epoch
+	if b[0].Epoch > first {
+		first = b[0].Epoch
+	}
+	if b[len(b)-1].Epoch < last {
+		last = b[len(b)-1].Epoch
+	}
+	if first > last {
+		return nil, ErrNoOverlap
+	}
+
+	for ep := first; ep <= last; ep++ {
+		strA, strB := a[ep-a[0].Epoch], b[ep-b[0].Epoch]
+		if sameSTR(strA, strB) {
+			continue
+		}
+		e := &EquivocationEvidence{
+			Epoch: ep,
+			STR:   [2]*protocol.DirSTR{strA, strB},
+		}
+		if ep > first {
+			e.Common = a[ep-1-a[0].Epoch]
+		}
+		return e, nil
+	}
+	return nil, nil
+}
+
+// Verify checks that the evidence e proves the equivocation of the
+// directory whose public signing key is pk: both STRs must be signed by
+// the directory, be for the epoch e.Epoch and differ. If e.Common is
+// set, it must be the directory's STR for the previous epoch, and both
+// STRs must be hash-chained to it. Verify() returns the consistency
+// check error of the first failing check, or a CheckBadSTR if the
+// STRs are equal.
+func (e *EquivocationEvidence) Verify(pk sign.PublicKey) error {
+	for _, str := range e.STR {
+		if !validSTR(str) || str.Epoch != e.Epoch {
+			return protocol.ErrMalformedMessage
+		}
+		if !pk.Verify(str.Serialize(), str.Signature) {
+			return protocol.CheckBadSignature
+		}
+	}
+	if sameSTR(e.STR[0], e.STR[1]) {
+		return protocol.CheckBadSTR
+	}
+	if e.Common == nil {
+		return nil
+	}
+	if !validSTR(e.Common) || e.Common.Epoch+1 != e.Epoch {
+		return protocol.ErrMalformedMessage
+	}
+	if !pk.Verify(e.Common.Serialize(), e.Common.Signature) {
+		return protocol.CheckBadSignature
+	}
+	for _, str := range e.STR {
+		if !str.VerifyHashChain(e.Common) {
+			return protocol.CheckBadSTR
+		}
+	}
+	return nil
+}
+
+// verifyHistory checks that the STRs h form a hash chain of
+// consecutive epochs signed with pk.
+func verifyHistory(pk sign.PublicKey, h []*protocol.DirSTR) error {
+	if len(h) == 0 || !validSTR(h[0]) {
+		return protocol.ErrMalformedMessage
+	}
+	for i := 1; i < len(h); i++ {
+		if !validSTR(h[i]) || h[i].Epoch != h[i-1].Epoch+1 {
+			return protocol.ErrMalformedMessage
+		}
+	}
+	if !pk.Verify(h[0].Serialize(), h[0].Signature) {
+		return protocol.CheckBadSignature
+	}
+	return New(pk, h[0]).VerifySTRRange(h[0], h[1:])
+}
+
+// validSTR returns false if str is missing its signed tree root or
+// its policies.
+func validSTR(str *protocol.DirSTR) bool {
+	return str != nil && str.SignedTreeRoot != nil && str.Policies != nil
+}
+
+// sameSTR returns true if the STRs a and b have the same contents and
+// signature.
+func sameSTR(a, b *protocol.DirSTR) bool {
+	return bytes.Equal(a.Signature, b.Signature) &&
+		bytes.Equal(a.Serialize(), b.Serialize())
+}
@@ -0,0 +1,111 @@
+package auditor
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// forkedHistories returns the STR histories of two copies of the same
+// directory, which diverge at epoch 3.
+func forkedHistories(t *testing.T) ([]*protocol.DirSTR, []*protocol.DirSTR) {
+	var a, b []*protocol.DirSTR
+	utils.WithDB(func(db kv.DB) {
+		d := directory.New(1, crypto.NewStaticTestVRFKey(), staticSigningKey, 10, true)
+		if err := d.Persist(db, 1); err != nil {
+			t.Fatal(err)
+		}
+		a = append(a, d.LatestSTR())
+		for i := 0; i < 2; i++ {
+			d.Update()
+			a = append(a, d.LatestSTR())
+		}
+		fork, err := directory.Restore(db, 1, 1, crypto.NewStaticTestVRFKey(),
+			staticSigningKey, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, a...)
+		d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+		fork.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("other key")})
+		for i := 0; i < 2; i++ {
+			d.Update()
+			fork.Update()
+			a = append(a, d.LatestSTR())
+			b = append(b, fork.LatestSTR())
+		}
+	})
+	return a, b
+}
+
+func TestFindFork(t *testing.T) {
+	pk, _ := staticSigningKey.Public()
+	a, b := forkedHistories(t)
+
+	e, err := FindFork(pk, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e == nil || e.Epoch != 3 || e.Common.Epoch != 2 {
+		t.Fatal("Expect a fork at epoch", 3, "got", e)
+	}
+	if err := e.Verify(pk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the histories only overlap after the fork
+	e, err = FindFork(pk, a[:4], b[3:])
+	if err != nil || e == nil || e.Epoch != 3 || e.Common != nil {
+		t.Fatal("Expect a fork at epoch", 3, "without common STR, got", e, err)
+	}
+	if err := e.Verify(pk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the histories agree before the fork
+	if e, err := FindFork(pk, a[:3], b[1:3]); e != nil || err != nil {
+		t.Fatal("Expect no fork, got", e, err)
+	}
+	if _, err := FindFork(pk, a[:2], b[3:]); err != ErrNoOverlap {
+		t.Fatal("Expect", ErrNoOverlap, "got", err)
+	}
+}
+
+func TestFindForkBadHistories(t *testing.T) {
+	pk, _ := staticSigningKey.Public()
+	a, b := forkedHistories(t)
+
+	for _, tc := range []struct {
+		name string
+		h    []*protocol.DirSTR
+		want error
+	}{
+		{"empty", nil, protocol.ErrMalformedMessage},
+		{"gap", []*protocol.DirSTR{a[0], a[2]}, protocol.ErrMalformedMessage},
+		{"not chained", []*protocol.DirSTR{a[2], b[3], a[4]}, protocol.CheckBadSTR},
+	} {
+		if _, err := FindFork(pk, a, tc.h); err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+		}
+	}
+
+	// the evidence doesn't hold with a forged STR
+	e, _ := FindFork(pk, a, b)
+	str := *e.STR[1]
+	str2 := *str.SignedTreeRoot
+	str2.Signature = append([]byte{}, str.Signature...)
+	str2.Signature[0]++
+	str.SignedTreeRoot = &str2
+	e.STR[1] = &str
+	if err := e.Verify(pk); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+	e.STR[1] = e.STR[0]
+	if err := e.Verify(pk); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+}
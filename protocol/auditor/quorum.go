@@ -0,0 +1,127 @@
+// Implements the trust policies with which a relying party decides
+// whether the auditors it trusts, possibly in other trust domains,
+// vouch for an STR (see protocol.Countersignature).
+
+package auditor
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// ErrMalformedQuorum indicates that a quorum can never be reached,
+// or that one of its members is neither an auditor nor a quorum.
+var ErrMalformedQuorum = errors.New("[coniks] Malformed quorum of auditors")
+
+// A Quorum is a trust policy over a set of auditors. It is reached
+// when the Members vouching for an STR have a total weight of at least
+// Threshold. A member is either an auditor, which vouches for the STRs
+// it has countersigned, or a nested quorum, which vouches for the STRs
+// for which it is reached, e.g., to require "2 of these 5 auditors of
+// our organization, and 1 of these 3 auditors of our partners".
+type Quorum struct {
+	Members   []*QuorumMember
+	Threshold uint64
+}
+
+// A QuorumMember is the auditor whose public key is Key, or the nested
+// quorum Quorum, which counts with Weight (1 if Weight is 0) towards
+// the threshold of its parent quorum.
+type QuorumMember struct {
+	Name   string `json:",omitempty"`
+	Key    sign.PublicKey
+	Quorum *Quorum
+	Weight uint64
+}
+
+// NewThresholdQuorum returns the quorum which is reached when at least
+// threshold of the auditors whose public keys are keys vouch for an
+// STR.
+func NewThresholdQuorum(threshold uint64, keys ...sign.PublicKey) *Quorum {
+	q := &Quorum{Threshold: threshold}
+	for _, k := range keys {
+		q.Members = append(q.Members, &QuorumMember{Key: k})
+	}
+	return q
+}
+
+func (m *QuorumMember) weight() uint64 {
+	if m.Weight == 0 {
+		return 1
+	}
+	return m.Weight
+}
+
+// Validate checks that the quorum q, and all its nested quorums, are
+// well-formed, i.e., that each member is either an auditor's public key
+// or a nested quorum, and that the threshold is positive and can be
+// reached. It returns ErrMalformedQuorum otherwise.
+func (q *Quorum) Validate() error {
+	var total uint64
+	for _, m := range q.Members {
+		switch {
+		case m == nil || (m.Key == nil) == (m.Quorum == nil):
+			return ErrMalformedQuorum
+		case m.Key != nil && len(m.Key) != sign.PublicKeySize:
+			return ErrMalformedQuorum
+		case m.Quorum != nil:
+			if err := m.Quorum.Validate(); err != nil {
+				return err
+			}
+		}
+		total += m.weight()
+	}
+	if q.Threshold == 0 || q.Threshold > total {
+		return ErrMalformedQuorum
+	}
+	return nil
+}
+
+// reached returns whether q is reached for an STR countersigned by
+// the auditors whose keys are in signers.
+func (q *Quorum) reached(signers []sign.PublicKey) bool {
+	var total uint64
+	for _, m := range q.Members {
+		if m.Quorum != nil && m.Quorum.reached(signers) ||
+			m.Key != nil && containsKey(signers, m.Key) {
+			total += m.weight()
+		}
+	}
+	return total >= q.Threshold
+}
+
+func containsKey(keys []sign.PublicKey, key sign.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyCountersignatures checks that the quorum q of auditors vouches
+// for the STR str, given the countersignatures sigs on str collected
+// from the auditors. Invalid countersignatures, and countersignatures
+// of auditors which aren't members of q, are ignored; an auditor listed
+// several times in q counts for each of its memberships.
+// VerifyCountersignatures() returns ErrMalformedQuorum if q is
+// malformed (see Validate()), and a CheckNoQuorum if q isn't reached.
+func VerifyCountersignatures(q *Quorum, str *protocol.DirSTR,
+	sigs []*protocol.Countersignature) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	var signers []sign.PublicKey
+	for _, c := range sigs {
+		if c != nil && !containsKey(signers, c.Key) && c.Verify(str) {
+			signers = append(signers, c.Key)
+		}
+	}
+	if !q.reached(signers) {
+		return protocol.CheckNoQuorum
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package auditor
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestVerifyCountersignatures(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	str := d.LatestSTR()
+	var keys []sign.PrivateKey
+	var pks []sign.PublicKey
+	for i := 0; i < 5; i++ {
+		k, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, _ := k.Public()
+		keys = append(keys, k)
+		pks = append(pks, pk)
+	}
+	countersign := func(signers ...int) []*protocol.Countersignature {
+		var sigs []*protocol.Countersignature
+		for _, i := range signers {
+			sigs = append(sigs, protocol.NewCountersignature(keys[i], str))
+		}
+		return sigs
+	}
+	d.Update()
+	forged := protocol.NewCountersignature(keys[1], d.LatestSTR())
+
+	twoOfThree := NewThresholdQuorum(2, pks[0], pks[1], pks[2])
+	nested := &Quorum{
+		Members: []*QuorumMember{
+			{Quorum: twoOfThree},
+			{Quorum: NewThresholdQuorum(1, pks[3], pks[4])},
+		},
+		Threshold: 2,
+	}
+	weighted := &Quorum{
+		Members: []*QuorumMember{
+			{Key: pks[0], Weight: 2},
+			{Key: pks[1]},
+			{Key: pks[2]},
+		},
+		Threshold: 2,
+	}
+	for _, tc := range []struct {
+		name string
+		q    *Quorum
+		sigs []*protocol.Countersignature
+		want error
+	}{
+		{"2 of 3", twoOfThree, countersign(0, 2), nil},
+		{"1 of 3", twoOfThree, countersign(1), protocol.CheckNoQuorum},
+		{"duplicate signer", twoOfThree, countersign(1, 1), protocol.CheckNoQuorum},
+		{"untrusted signer", twoOfThree, countersign(1, 3), protocol.CheckNoQuorum},
+		{"countersignature on another STR", twoOfThree,
+			append(countersign(0), forged), protocol.CheckNoQuorum},
+		{"nested", nested, countersign(0, 1, 4), nil},
+		{"nested without partner", nested, countersign(0, 1, 2), protocol.CheckNoQuorum},
+		{"heavy signer", weighted, countersign(0), nil},
+		{"light signer", weighted, countersign(1), protocol.CheckNoQuorum},
+		{"unreachable threshold", NewThresholdQuorum(3, pks[0], pks[1]),
+			countersign(0, 1), ErrMalformedQuorum},
+		{"zero threshold", NewThresholdQuorum(0, pks[0]), nil, ErrMalformedQuorum},
+		{"empty member", &Quorum{Members: []*QuorumMember{{}}, Threshold: 1},
+			nil, ErrMalformedQuorum},
+	} {
+		if err := VerifyCountersignatures(tc.q, str, tc.sigs); err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+		}
+	}
+}
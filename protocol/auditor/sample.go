@@ -0,0 +1,83 @@
+// Implements the sampling of a directory's tree, with which an auditor
+// checks that the STRs it has verified commit to the tree the
+// directory serves.
+
+package auditor
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A Sampler chooses the lookup indices at which an auditor samples the
+// tree of a verified STR (see protocol.SampleRequest). The indices must
+// be unpredictable by the directory, so that a directory whose STR
+// doesn't commit to the tree it serves is detected with a probability
+// growing with the number of samples.
+type Sampler interface {
+	Sample(str *protocol.DirSTR) ([][]byte, error)
+}
+
+// A RandomSampler is a Sampler which chooses as many lookup indices
+// as its value, up to protocol.MaxSampleSize, uniformly at random.
+type RandomSampler int
+
+var _ Sampler = RandomSampler(0)
+
+// Sample returns s uniformly random lookup indices.
+func (s RandomSampler) Sample(*protocol.DirSTR) ([][]byte, error) {
+	n := int(s)
+	if n > protocol.MaxSampleSize {
+		n = protocol.MaxSampleSize
+	}
+	indices := make([][]byte, n)
+	for i := range indices {
+		indices[i] = make([]byte, vrf.Size)
+		if _, err := rand.Read(indices[i]); err != nil {
+			return nil, err
+		}
+	}
+	return indices, nil
+}
+
+// VerifySample verifies the response res of a directory to the
+// protocol.SampleRequest for the lookup indices at the epoch of str,
+// which is an STR the auditor has verified. It checks that res includes
+// str itself, and an authentication path for each of the indices, in
+// order, whose root is the tree hash of str (see
+// merkletree.AuthenticationPath.VerifySample()).
+//
+// VerifySample() returns the error code of res if the request failed,
+// an ErrMalformedMessage if res is malformed, a CheckBadSTR if res
+// doesn't include str, and a CheckBadAuthPath if an authentication
+// path doesn't verify, i.e., if str doesn't commit to the tree the
+// directory serves.
+func VerifySample(str *protocol.DirSTR, indices [][]byte,
+	res *protocol.Response) error {
+	if err := res.Validate(); err != nil {
+		return err
+	}
+	sp := res.SampleProof()
+	if sp == nil || len(sp.AP) != len(indices) {
+		return protocol.ErrMalformedMessage
+	}
+	if !sameSTR(sp.STR, str) {
+		return protocol.CheckBadSTR
+	}
+	hasher, err := str.Policies.Hasher()
+	if err != nil || len(str.TreeHash) != hasher.Size() {
+		return protocol.CheckUnsupportedSTR
+	}
+	for i, ap := range sp.AP {
+		if !bytes.Equal(ap.LookupIndex, indices[i]) {
+			return protocol.ErrMalformedMessage
+		}
+		if err := ap.VerifySampleWith(hasher, str.TreeHash); err != nil {
+			return protocol.CheckBadAuthPath
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package auditor
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestVerifySample(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	str := d.LatestSTR()
+
+	indices, err := RandomSampler(protocol.MaxSampleSize + 1).Sample(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indices) != protocol.MaxSampleSize {
+		t.Fatal("Expect", protocol.MaxSampleSize, "indices, got", len(indices))
+	}
+	res := d.Sample(&protocol.SampleRequest{Indices: indices, Epoch: str.Epoch})
+	if err := VerifySample(str, indices, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySample(str, indices[1:], res); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// a directory serving a tree to which the verified STR
+	// doesn't commit
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	d.Update()
+	res = d.Sample(&protocol.SampleRequest{Indices: indices, Epoch: str.Epoch + 1})
+	if err := VerifySample(str, indices, res); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	res.SampleProof().STR = str
+	if err := VerifySample(str, indices, res); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Defines the messages with which a CONIKS client looks up the
+// bindings of many usernames at once
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/merkletree"
+
+// MaxBatchSize is the maximum number of usernames
+// a BatchKeyLookupRequest may include.
+const MaxBatchSize = 64
+
+// A BatchKeyLookupRequest is a message with a list of usernames that
+// a CONIKS client sends to a CONIKS directory to retrieve the public
+// keys bound to each of the Usernames at the latest epoch, e.g., the
+// keys of a user's whole contact list, in a single round trip.
+// Usernames must contain between 1 and MaxBatchSize non-empty
+// usernames.
+//
+// The response to a successful request is a BatchKeyLookupProof.
+type BatchKeyLookupRequest struct {
+	Usernames []string
+}
+
+// A BatchKeyLookupProof response includes, for each of the requested
+// usernames, in order, the authentication path AP, the temporary
+// binding TB, if any, and the error code Errors of its key lookup
+// (i.e., ReqSuccess or ReqNameNotFound), as a DirectoryProof for
+// a KeyLookupRequest would, and the signed tree root STR for the
+// latest epoch, which all the authentication paths share.
+type BatchKeyLookupProof struct {
+	AP     []*merkletree.AuthenticationPath
+	STR    *DirSTR
+	TB     []*TemporaryBinding
+	Errors []ErrorCode
+}
+
+var _ DirectoryResponse = (*BatchKeyLookupProof)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*BatchKeyLookupProof) ResponseType() string { return "BatchKeyLookupProof" }
+
+func (p *BatchKeyLookupProof) validate() error {
+	if p.STR == nil || len(p.AP) == 0 || !validSTRs([]*DirSTR{p.STR}) ||
+		len(p.TB) != len(p.AP) || len(p.Errors) != len(p.AP) {
+		return ErrMalformedMessage
+	}
+	for i, ap := range p.AP {
+		if ap == nil || ap.Leaf == nil ||
+			p.Errors[i] != ReqSuccess && p.Errors[i] != ReqNameNotFound {
+			return ErrMalformedMessage
+		}
+	}
+	return nil
+}
+
+// NewBatchKeyLookupProof creates the response message a CONIKS
+// directory sends to a client upon a BatchKeyLookupRequest, and returns
+// a Response containing a BatchKeyLookupProof struct.
+// directory.BatchKeyLookup() passes the authentication paths ap, the
+// temporary bindings tb and the error codes e of the lookups of the
+// requested usernames, and the signed tree root for the latest epoch
+// str.
+func NewBatchKeyLookupProof(ap []*merkletree.AuthenticationPath, str *DirSTR,
+	tb []*TemporaryBinding, e []ErrorCode) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &BatchKeyLookupProof{
+			AP:     ap,
+			STR:    str,
+			TB:     tb,
+			Errors: e,
+		},
+	}
+}
+
+// Proof returns the response to a KeyLookupRequest for the i-th
+// requested username included in the BatchKeyLookupProof df, i.e.,
+// a DirectoryProof with its authentication path, STR and TB.
+// It returns nil if df doesn't include a lookup at index i.
+func (df *BatchKeyLookupProof) Proof(i int) *Response {
+	if i < 0 || i >= len(df.AP) || i >= len(df.TB) || i >= len(df.Errors) {
+		return nil
+	}
+	return NewKeyLookupProof(df.AP[i], df.STR, df.TB[i], df.Errors[i])
+}
@@ -0,0 +1,93 @@
+// Defines the STR extension with which a directory mixes the values
+// of a public randomness beacon (e.g., drand) into its STRs
+
+package protocol
+
+import (
+	"encoding/binary"
+
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// BeaconExtension is the name of the STR extension (see
+// merkletree.STRExtension) including the latest value of a public
+// randomness beacon the directory has observed when issuing the STR.
+// Since the value of a beacon round is unpredictable before the round's
+// time, the STR cannot have been issued before this time, which binds
+// the directory's epochs to external time: a directory rewriting its
+// history must issue the rewritten STRs with the beacon values of their
+// original epochs, whose rounds are in the past by then, and which the
+// verifiers can compare with the times at which they observed the STRs.
+// The extension isn't critical, so that the clients which don't know
+// it keep working.
+const BeaconExtension = "randomness-beacon"
+
+// A BeaconValue is the output Randomness of a public randomness beacon
+// for its round Round.
+type BeaconValue struct {
+	Round      uint64
+	Randomness []byte
+}
+
+// Serialize serializes the beacon value into the value of
+// a BeaconExtension.
+func (b *BeaconValue) Serialize() []byte {
+	return append(utils.ULongToBytes(b.Round), b.Randomness...)
+}
+
+// ParseBeaconValue parses the value of a BeaconExtension, and returns
+// an ErrMalformedMessage if it doesn't include any randomness.
+func ParseBeaconValue(buf []byte) (*BeaconValue, error) {
+	if len(buf) <= 8 {
+		return nil, ErrMalformedMessage
+	}
+	return &BeaconValue{
+		Round:      binary.LittleEndian.Uint64(buf[:8]),
+		Randomness: append([]byte{}, buf[8:]...),
+	}, nil
+}
+
+// Beacon returns the beacon value the STR commits to, or nil if the
+// STR has no BeaconExtension. It returns an ErrMalformedMessage if
+// the extension is malformed.
+func (str *DirSTR) Beacon() (*BeaconValue, error) {
+	ext := str.Extensions[BeaconExtension]
+	if ext == nil {
+		return nil, nil
+	}
+	return ParseBeaconValue(ext.Value)
+}
+
+// A BeaconVerifier checks that a beacon value is the genuine output
+// of the beacon for its round, e.g., by verifying the beacon's
+// signature on the round or by fetching the round from the beacon.
+type BeaconVerifier interface {
+	VerifyBeacon(b *BeaconValue) bool
+}
+
+// VerifyBeaconChain checks the beacon value of str against the beacon
+// value of the previous STR prevSTR, if both STRs include one: the
+// round of str must not be older than the round of prevSTR. If v isn't
+// nil, it also checks that the beacon value of str is genuine (see
+// BeaconVerifier).
+// VerifyBeaconChain() returns a CheckBadBeacon if a check fails,
+// or an ErrMalformedMessage if an extension is malformed.
+// A directory may omit the beacon value from some STRs, e.g.,
+// if the beacon was unavailable when the STR was issued.
+func VerifyBeaconChain(prevSTR, str *DirSTR, v BeaconVerifier) error {
+	b, err := str.Beacon()
+	if err != nil || b == nil {
+		return err
+	}
+	if v != nil && !v.VerifyBeacon(b) {
+		return CheckBadBeacon
+	}
+	prev, err := prevSTR.Beacon()
+	if err != nil {
+		return err
+	}
+	if prev != nil && b.Round < prev.Round {
+		return CheckBadBeacon
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Defines the seed files from which a directory is bootstrapped
+// with reserved bindings at epoch 0
+
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// bootstrapLabel separates the signatures on bootstrap seeds
+// from the directory's signatures on any other data.
+const bootstrapLabel = "coniks-bootstrap-seed"
+
+// A BootstrapBinding is a name-to-key binding reserved
+// by the directory's operator, e.g., for a staff account or the
+// registration bot's own key.
+type BootstrapBinding struct {
+	Username string
+	Key      []byte
+}
+
+// A BootstrapSeed lists the Bindings with which a directory is
+// pre-populated before it issues its STR for epoch 0, along with the
+// directory's Signature on them.
+//
+// The hash of the seed (see Hash()) is recorded in the BootstrapHash
+// of the directory's policies, so that the clients and auditors holding
+// the seed can check that the initial STR commits to exactly these
+// bindings (see VerifyInitSTR()).
+type BootstrapSeed struct {
+	Bindings  []*BootstrapBinding
+	Signature []byte
+}
+
+// NewBootstrapSeed creates a new seed for the given bindings,
+// signed with the directory's signing key signKey.
+func NewBootstrapSeed(signKey sign.PrivateKey,
+	bindings []*BootstrapBinding) *BootstrapSeed {
+	s := &BootstrapSeed{Bindings: bindings}
+	s.Signature = signKey.Sign(s.Serialize())
+	return s
+}
+
+// Serialize serializes the seed's bindings, in order,
+// into a specified format for signing and hashing.
+func (s *BootstrapSeed) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, []byte(bootstrapLabel)...)
+	bs = append(bs, utils.ULongToBytes(uint64(len(s.Bindings)))...)
+	for _, b := range s.Bindings {
+		bs = append(bs, utils.ULongToBytes(uint64(len(b.Username)))...)
+		bs = append(bs, []byte(b.Username)...)
+		bs = append(bs, utils.ULongToBytes(uint64(len(b.Key)))...)
+		bs = append(bs, b.Key...)
+	}
+	return bs
+}
+
+// Hash returns the hash of the seed, which covers its bindings and
+// its signature.
+func (s *BootstrapSeed) Hash() []byte {
+	return crypto.Digest(s.Serialize(), s.Signature)
+}
+
+// Verify checks that the seed is well-formed, i.e., that it binds each
+// of its usernames exactly once, to a non-empty key, and that it is
+// signed by the directory whose public key is pk.
+// It returns an ErrMalformedMessage or a CheckBadSignature otherwise.
+func (s *BootstrapSeed) Verify(pk sign.PublicKey) error {
+	names := make(map[string]bool, len(s.Bindings))
+	for _, b := range s.Bindings {
+		if b == nil || b.Username == "" || len(b.Key) == 0 || names[b.Username] {
+			return ErrMalformedMessage
+		}
+		names[b.Username] = true
+	}
+	if !pk.Verify(s.Serialize(), s.Signature) {
+		return CheckBadSignature
+	}
+	return nil
+}
+
+// VerifyInitSTR verifies the seed (see Verify()) against the public
+// key pk of the directory, and checks that str is the directory's
+// initial STR and records the hash of the seed in its policies.
+// It returns a CheckBadBootstrap if str wasn't bootstrapped from the
+// seed.
+//
+// The bindings of a verified seed can then be looked up, as any
+// other binding, in the STR for epoch 0.
+func (s *BootstrapSeed) VerifyInitSTR(pk sign.PublicKey, str *DirSTR) error {
+	if err := s.Verify(pk); err != nil {
+		return err
+	}
+	if str.Epoch != 0 || !bytes.Equal(str.Policies.BootstrapHash, s.Hash()) {
+		return CheckBadBootstrap
+	}
+	return nil
+}
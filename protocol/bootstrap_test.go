@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+func TestBootstrapSeedVerify(t *testing.T) {
+	signKey := crypto.NewStaticTestSigningKey()
+	pk, _ := signKey.Public()
+	vrfKey, _ := crypto.NewStaticTestVRFKey().PublicKey()
+	staff := &BootstrapBinding{Username: "staff", Key: []byte("key")}
+	seed := NewBootstrapSeed(signKey, []*BootstrapBinding{staff})
+
+	str := &DirSTR{SignedTreeRoot: &merkletree.SignedTreeRoot{},
+		Policies: NewPolicies(1, vrfKey)}
+	if err := seed.VerifyInitSTR(pk, str); err != CheckBadBootstrap {
+		t.Fatal("Expect", CheckBadBootstrap, "got", err)
+	}
+	str.Policies.BootstrapHash = seed.Hash()
+	if err := seed.VerifyInitSTR(pk, str); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		bindings []*BootstrapBinding
+		resign   bool
+		want     error
+	}{
+		{"tampered", []*BootstrapBinding{{Username: "staff", Key: []byte("other")}},
+			false, CheckBadSignature},
+		{"duplicate", []*BootstrapBinding{staff, staff}, true, ErrMalformedMessage},
+		{"empty key", []*BootstrapBinding{{Username: "staff"}}, true, ErrMalformedMessage},
+	} {
+		s := &BootstrapSeed{Bindings: tc.bindings, Signature: seed.Signature}
+		if tc.resign {
+			s = NewBootstrapSeed(signKey, tc.bindings)
+		}
+		if err := s.Verify(pk); err != tc.want {
+			t.Error(tc.name, "Expect", tc.want, "got", err)
+		}
+	}
+}
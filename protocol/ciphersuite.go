@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+)
+
+// A CipherSuite identifies the cryptographic algorithms of a directory:
+// the signature scheme of its signing key, the VRF construction of its
+// private indices and the hash algorithm of its tree, regardless of the
+// size to which the hashes are truncated (see hashers.Name()).
+//
+// The directory declares its cipher suite in its signed policies, so
+// that a client can tell that it doesn't support the algorithms of
+// a directory before verifying any of its STRs or proofs, and the
+// directory can migrate to other algorithms along with its policies.
+type CipherSuite struct {
+	Signature string
+	Vrf       vrf.Algorithm
+	Hash      string
+}
+
+// NewCipherSuite returns the cipher suite of the policies p, i.e.,
+// the VRF construction and the hash algorithm p declares, along with
+// the signature scheme implemented by crypto/sign.
+func NewCipherSuite(p *Policies) *CipherSuite {
+	return &CipherSuite{
+		Signature: SignatureEd25519,
+		Vrf:       vrfAlgorithm(p),
+		Hash:      hashers.Name(p.HashID),
+	}
+}
+
+// Supported returns true if this implementation supports all
+// algorithms of the cipher suite cs.
+func (cs *CipherSuite) Supported() bool {
+	if cs.Signature != SignatureEd25519 || !vrf.Registered(cs.Vrf) ||
+		hashers.Name(cs.Hash) != cs.Hash {
+		return false
+	}
+	_, err := hashers.NewPADHasher(cs.Hash)
+	return err == nil
+}
+
+// Serialize serializes the cipher suite cs for signing the tree root
+// along with the policies (see Policies.Serialize()).
+func (cs *CipherSuite) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, []byte(cs.Signature)...)
+	bs = append(bs, []byte(cs.Vrf)...)
+	bs = append(bs, []byte(cs.Hash)...)
+	return bs
+}
+
+// CheckCipherSuite checks that this implementation supports the cipher
+// suite declared by the policies p, and returns a CheckUnsupportedSuite
+// otherwise. It returns ErrMalformedMessage if the cipher suite
+// contradicts the VRF construction or the hash algorithm declared by p.
+// The policies which don't declare a cipher suite predate it, and use
+// the algorithms declared by their other fields.
+func (p *Policies) CheckCipherSuite() error {
+	if p.CipherSuite == nil {
+		return nil
+	}
+	if !p.CipherSuite.Supported() {
+		return CheckUnsupportedSuite
+	}
+	if p.CipherSuite.Vrf != vrfAlgorithm(p) ||
+		p.CipherSuite.Hash != hashers.Name(p.HashID) {
+		return ErrMalformedMessage
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Implements the client's automatic audits, in which the client asks
+// a set of pinned auditors for the STR they have observed for the epoch
+// of its verified STR each time it verifies a registration or a key
+// lookup. A directory which shows the client a different STR than
+// to its auditors is thus detected right away, rather than whenever
+// the client's user happens to cross-check the STR.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// An AuditSender sends the AuditingRequest req to the auditor at the
+// address addr, and returns the auditor's response, e.g. over the
+// network.
+type AuditSender func(addr string, req *protocol.AuditingRequest) (*protocol.Response, error)
+
+// SetAuditors pins the auditors at the addresses addrs, which the
+// client asks to audit its verified STR after each registration or key
+// lookup (see HandleResponse() and Audit()), sending the auditing
+// requests with send. Passing no address disables the automatic audits.
+// The auditors need the directory's identity to find its history, so
+// that a client created from a later STR than the initial one must set
+// it (see SetDirectoryIdentity()).
+func (cc *ConsistencyChecks) SetAuditors(send AuditSender, addrs ...string) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.auditors = append([]string{}, addrs...)
+	cc.sendAudit = send
+}
+
+// Audit asks each pinned auditor (see SetAuditors()) for the STR it has
+// observed for the epoch of the verified STR, and checks the auditor's
+// response against the verified STR (see CheckEquivocation()).
+//
+// Audit() returns a CheckAuditorDisagrees if an auditor has observed
+// a different STR for this epoch, i.e., if the directory presents
+// different views of its history to the client and to the auditor.
+// Otherwise, it returns nil if at least one auditor has confirmed the
+// verified STR, and the error of the last auditor if none has, e.g.,
+// if the auditors haven't observed this epoch yet or can't be reached.
+// Audit() returns a ReqUnknownDirectory if the client doesn't know the
+// directory's identity, and does nothing if no auditor is pinned.
+func (cc *ConsistencyChecks) Audit() error {
+	cc.lock.Lock()
+	addrs, send := cc.auditors, cc.sendAudit
+	id, epoch := cc.identity, cc.VerifiedSTR().Epoch
+	cc.unlock()
+	if len(addrs) == 0 {
+		return nil
+	}
+	if id == nil {
+		return protocol.ReqUnknownDirectory
+	}
+
+	req := &protocol.AuditingRequest{
+		DirInitSTRHash: *id,
+		StartEpoch:     epoch,
+		EndEpoch:       epoch,
+	}
+	var err error
+	confirmed := false
+	for _, addr := range addrs {
+		res, e := send(addr, req)
+		if e == nil {
+			e = cc.checkAudit(epoch, res)
+		}
+		switch e {
+		case nil:
+			confirmed = true
+		case protocol.CheckAuditorDisagrees:
+			return e
+		default:
+			err = e
+		}
+	}
+	if confirmed {
+		return nil
+	}
+	return err
+}
+
+// checkAudit checks the response msg of an auditor to an auditing
+// request for the epoch of the verified STR, unless the verified STR
+// has moved past epoch in the meantime, in which case msg doesn't
+// confirm anything. An STR of the auditor which isn't signed by the
+// directory causes checkAudit() to return a CheckBadSignature.
+func (cc *ConsistencyChecks) checkAudit(epoch uint64, msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if cc.VerifiedSTR().Epoch != epoch {
+		return protocol.CheckUnconfirmedSTR
+	}
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	strs := msg.STRHistoryRange()
+	if strs == nil || len(strs.STR) == 0 ||
+		strs.STR[len(strs.STR)-1].Epoch != epoch {
+		return protocol.ErrMalformedMessage
+	}
+	// only an STR signed by the directory proves its equivocation
+	str := strs.STR[len(strs.STR)-1]
+	if !cc.VerifySignature(str) {
+		return protocol.CheckBadSignature
+	}
+	if err := cc.checkEquivocation(msg); err != nil {
+		if err == protocol.CheckBadSTR {
+			return protocol.CheckAuditorDisagrees
+		}
+		return err
+	}
+	return nil
+}
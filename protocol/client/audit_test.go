@@ -0,0 +1,88 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/auditlog"
+	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestAuditLog returns the audit log of an auditor which has
+// observed the STRs strs of a directory.
+func newTestAuditLog(t *testing.T, strs ...*protocol.DirSTR) auditlog.ConiksAuditLog {
+	aud := auditlog.New()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	if err := aud.InitHistory("test-server", pk, strs); err != nil {
+		t.Fatal(err)
+	}
+	return aud
+}
+
+// auditSender returns an AuditSender which passes the auditing requests
+// to the audit log of each auditor, and fails for the other addresses.
+func auditSender(auditors map[string]auditlog.ConiksAuditLog) AuditSender {
+	return func(addr string, req *protocol.AuditingRequest) (*protocol.Response, error) {
+		aud, ok := auditors[addr]
+		if !ok {
+			return nil, protocol.ErrDirectory
+		}
+		return aud.GetObservedSTRs(req), nil
+	}
+}
+
+func TestAuditOnLookup(t *testing.T) {
+	d := directory.NewTestDirectory(t)
+	initSTR := d.LatestSTR()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(initSTR, true, pk)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	d.Update()
+
+	// the auditor follows the directory, while another one is down
+	aud := newTestAuditLog(t, initSTR, d.LatestSTR())
+	cc.SetAuditors(auditSender(map[string]auditlog.ConiksAuditLog{"auditor": aud}),
+		"down", "auditor")
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the auditor lags behind the directory
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Audit(); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestAuditDetectsEquivocation(t *testing.T) {
+	d, cc := newTestClient(t)
+	initSTR := getSTR(t, d, 0)
+	cc.SetDirectoryIdentity(auditor.ComputeDirectoryIdentity(initSTR))
+	// the directory shows the auditor a fork of its history
+	fork := directory.NewTestDirectory(t)
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	fork.Update()
+	forked := newTestAuditLog(t, initSTR, fork.LatestSTR())
+	honest := newTestAuditLog(t, initSTR, d.LatestSTR())
+
+	cc.SetAuditors(auditSender(map[string]auditlog.ConiksAuditLog{
+		"honest": honest,
+		"forked": forked,
+	}), "honest", "forked")
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != protocol.CheckAuditorDisagrees {
+		t.Fatal("Expect", protocol.CheckAuditorDisagrees, "got", err)
+	}
+
+	cc.SetAuditors(nil)
+	if err := cc.Audit(); err != nil {
+		t.Fatal("Expect no audit, got", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Implements the verification of the responses to batched key lookups.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// HandleBatchResponse verifies the directory's response msg to
+// a BatchKeyLookupRequest for the usernames names, in order.
+// It returns an error if msg is malformed or doesn't include a lookup
+// for each name. Otherwise, the lookup of each name is checked as
+// HandleResponse() checks a key lookup response, against the key the
+// client has already verified for the name, if any: the STR the lookups
+// share is thus only verified once, by the first lookup.
+// HandleBatchResponse() returns the consistency check error of each
+// name whose lookup fails the checks.
+func (cc *ConsistencyChecks) HandleBatchResponse(msg *protocol.Response,
+	names []string) (map[string]error, error) {
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	df := msg.BatchKeyLookupProof()
+	if df == nil || len(df.AP) != len(names) {
+		return nil, protocol.ErrMalformedMessage
+	}
+
+	cc.lock.Lock()
+	defer cc.unlock()
+	results := make(map[string]error)
+	for i, name := range names {
+		key := cc.Bindings[name]
+		if err := cc.handleResponse(protocol.KeyLookupType, df.Proof(i),
+			name, key, false); err != nil {
+			results[name] = err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestHandleBatchResponse(t *testing.T) {
+	d, cc := newTestClient(t)
+	var names []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("contact%d", i)
+		names = append(names, name)
+		d.Register(&protocol.RegistrationRequest{
+			Username: name,
+			Key:      []byte(name),
+		})
+	}
+	d.Update()
+
+	unregistered := "stranger"
+	names = append(names, unregistered)
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: names})
+	results, err := cc.HandleBatchResponse(res, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatal("Expect no failed lookup, got", results)
+	}
+	for _, name := range names[:10] {
+		if key, ok := cc.Binding(name); !ok || string(key) != name {
+			t.Error(name, "expect", name, "got", string(key))
+		}
+	}
+	if _, ok := cc.Binding(unregistered); ok {
+		t.Error("Expect no binding for", unregistered)
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the verified STR to be updated")
+	}
+
+	// the lookups are checked against the verified bindings
+	d.Update()
+	res = d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: names})
+	res.BatchKeyLookupProof().AP[0].Leaf.Value = []byte("forged")
+	results, err = cc.HandleBatchResponse(res, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[names[0]] != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "for", names[0], "got", results)
+	}
+
+	if _, err := cc.HandleBatchResponse(res, names[:1]); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Implements the pinning of a CONIKS directory's checkpoints, i.e.
+// historical STRs the client trusts in addition to its verified STR,
+// e.g. distributed with the client's configuration. A client far behind
+// the directory can then catch up from its latest checkpoint rather
+// than from its verified STR, and a directory can't present the client
+// any history which doesn't pass through its checkpoints, even with
+// a compromised signing key.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// PinCheckpoints pins the STRs strs as checkpoints of the directory.
+// Any STR the client verifies afterwards for the epoch of a checkpoint
+// has to be this checkpoint, and the client may catch up with the
+// directory from a checkpoint newer than its verified STR (see
+// CatchUp() and LatestCheckpoint()). The checkpoints are part of the
+// client's configuration, and aren't saved with its consistency state
+// (see SaveState()).
+// PinCheckpoints() returns a CheckBadSignature if a checkpoint isn't
+// signed by the directory, and a CheckBadSTR if it conflicts with the
+// verified STR or with another checkpoint of the same epoch; in either
+// case, no checkpoint is pinned.
+func (cc *ConsistencyChecks) PinCheckpoints(strs ...*protocol.DirSTR) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	pinned := make(map[uint64]*protocol.DirSTR, len(strs))
+	for _, str := range strs {
+		if str == nil || str.SignedTreeRoot == nil {
+			return protocol.ErrMalformedMessage
+		}
+		if !cc.VerifySignature(str) {
+			return protocol.CheckBadSignature
+		}
+		if other, ok := pinned[str.Epoch]; ok && !sameSTR(other, str) {
+			return protocol.CheckBadSTR
+		}
+		if other, ok := cc.checkpoints[str.Epoch]; ok && !sameSTR(other, str) {
+			return protocol.CheckBadSTR
+		}
+		if v := cc.VerifiedSTR(); v.Epoch == str.Epoch && !sameSTR(v, str) {
+			return protocol.CheckBadSTR
+		}
+		pinned[str.Epoch] = str
+	}
+	for ep, str := range pinned {
+		cc.checkpoints[ep] = str
+	}
+	return nil
+}
+
+// LatestCheckpoint returns the latest checkpoint which is newer than
+// the verified STR, from which the client catches up with the directory
+// (see CatchUp()), or nil if there is no such checkpoint.
+func (cc *ConsistencyChecks) LatestCheckpoint() *protocol.DirSTR {
+	cc.lock.Lock()
+	defer cc.unlock()
+	var latest *protocol.DirSTR
+	for _, str := range cc.checkpoints {
+		if str.Epoch > cc.VerifiedSTR().Epoch &&
+			(latest == nil || str.Epoch > latest.Epoch) {
+			latest = str
+		}
+	}
+	return latest
+}
+
+// CatchUp verifies the directory's response msg to an STR history
+// request, and updates the verified STR to the latest STR in msg.
+// The range of STRs in msg has to be hash-chained, and either include
+// or directly follow the verified STR, or pass through a checkpoint
+// newer than the verified STR (see PinCheckpoints()), in which case
+// the client skips the epochs in between. A client which has missed
+// many epochs thus requests the STRs from its latest checkpoint on,
+// if any (see LatestCheckpoint()), and from its verified epoch
+// otherwise.
+// CatchUp() returns a CheckBadSTR if the range doesn't pass through
+// the verified STR or a newer checkpoint, or if it conflicts with
+// a checkpoint.
+func (cc *ConsistencyChecks) CatchUp(msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	strs := msg.STRHistoryRange()
+	if strs == nil || len(strs.STR) == 0 {
+		return protocol.ErrMalformedMessage
+	}
+	if err := strs.Verify(); err != nil {
+		return err
+	}
+	return cc.auditSTRRange(strs.STR)
+}
+
+// passesCheckpoint returns whether the hash-chained range of STRs strs
+// includes a checkpoint newer than the verified STR.
+func (cc *ConsistencyChecks) passesCheckpoint(strs []*protocol.DirSTR) bool {
+	verified := cc.VerifiedSTR().Epoch
+	for _, str := range strs {
+		if str.Epoch > verified && cc.checkpoints[str.Epoch] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPinned checks that the STR str is the checkpoint of its epoch,
+// if any, and returns a CheckBadSTR otherwise.
+func (cc *ConsistencyChecks) checkPinned(str *protocol.DirSTR) error {
+	if pinned, ok := cc.checkpoints[str.Epoch]; ok && !sameSTR(pinned, str) {
+		return protocol.CheckBadSTR
+	}
+	return nil
+}
+
+// AuditDirectory audits the STRs strs against the verified STR (see
+// auditor.AudState.AuditDirectory()), and checks that they don't
+// conflict with the pinned checkpoints.
+func (cc *ConsistencyChecks) AuditDirectory(strs []*protocol.DirSTR) error {
+	if err := cc.AudState.AuditDirectory(strs); err != nil {
+		return err
+	}
+	for _, str := range strs {
+		if err := cc.checkPinned(str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckSTRAgainstVerified checks the STR str against the verified STR
+// (see auditor.AudState.CheckSTRAgainstVerified()), and checks that it
+// doesn't conflict with the pinned checkpoints.
+func (cc *ConsistencyChecks) CheckSTRAgainstVerified(str *protocol.DirSTR) error {
+	if err := cc.AudState.CheckSTRAgainstVerified(str); err != nil {
+		return err
+	}
+	return cc.checkPinned(str)
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// getSTR returns the STR d has issued for epoch.
+func getSTR(t *testing.T, d *directory.ConiksDirectory, epoch uint64) *protocol.DirSTR {
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: epoch, EndEpoch: epoch})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal(res.Error)
+	}
+	return res.STRHistoryRange().STR[0]
+}
+
+func TestCatchUpFromCheckpoint(t *testing.T) {
+	d, cc := newTestClient(t)
+	for i := 0; i < 7; i++ {
+		d.Update()
+	}
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 6, Latest: true})
+	if err := cc.CatchUp(res); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	if err := cc.PinCheckpoints(getSTR(t, d, 4), getSTR(t, d, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if str := cc.LatestCheckpoint(); str == nil || str.Epoch != 6 {
+		t.Fatal("Expect the checkpoint of epoch", 6, "got", str)
+	}
+	if err := cc.CatchUp(res); err != nil {
+		t.Fatal(err)
+	}
+	if ep := cc.VerifiedSTR().Epoch; ep != d.LatestSTR().Epoch {
+		t.Fatal("Expect epoch", d.LatestSTR().Epoch, "got", ep)
+	}
+	if str := cc.LatestCheckpoint(); str != nil {
+		t.Fatal("Expect no newer checkpoint, got", str.Epoch)
+	}
+
+	// the client keeps following the directory from its verified STR
+	d.Update()
+	res = d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: cc.VerifiedSTR().Epoch, Latest: true})
+	if err := cc.CatchUp(res); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointRejectsFork(t *testing.T) {
+	d, cc := newTestClient(t)
+	// a fork of d signed with the same key
+	fork := directory.NewTestDirectory(t)
+	fork.Update()
+	fork.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	for i := 0; i < 5; i++ {
+		d.Update()
+		fork.Update()
+	}
+	if err := cc.PinCheckpoints(getSTR(t, d, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fork's history doesn't pass through the checkpoint
+	res := fork.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 4, Latest: true})
+	if err := cc.CatchUp(res); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	res = fork.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 1, Latest: true})
+	if err := cc.CatchUp(res); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if ep := cc.VerifiedSTR().Epoch; ep != 1 {
+		t.Fatal("Expect epoch", 1, "got", ep)
+	}
+}
+
+func TestPinCheckpointsErrors(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Update()
+	root := *getSTR(t, d, 2).SignedTreeRoot
+	root.Signature = append([]byte{}, root.Signature...)
+	root.Signature[0] ^= 1
+	forged := protocol.DirSTR{SignedTreeRoot: &root, Policies: d.LatestSTR().Policies}
+	other := directory.NewTestDirectory(t)
+	other.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	other.Update()
+
+	for _, tc := range []struct {
+		name string
+		strs []*protocol.DirSTR
+		want error
+	}{
+		{"forged", []*protocol.DirSTR{&forged}, protocol.CheckBadSignature},
+		{"conflicts with the verified STR", []*protocol.DirSTR{other.LatestSTR()},
+			protocol.CheckBadSTR},
+		{"conflicting checkpoints", []*protocol.DirSTR{getSTR(t, d, 1),
+			other.LatestSTR()}, protocol.CheckBadSTR},
+		{"nil", []*protocol.DirSTR{nil}, protocol.ErrMalformedMessage},
+	} {
+		if err := cc.PinCheckpoints(tc.strs...); err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+		}
+	}
+	if str := cc.LatestCheckpoint(); str != nil {
+		t.Fatal("Expect no checkpoint, got", str.Epoch)
+	}
+}
@@ -7,11 +7,16 @@ package client
 
 import (
 	"bytes"
+	"sync"
+	"time"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
 	"github.com/coniks-sys/coniks-go/protocol/auditor"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
 // ConsistencyChecks stores the latest consistency check
@@ -25,20 +30,68 @@ import (
 // This ConsistencyChecks instance will then be used to verify
 // subsequent responses from the ConiksDirectory to any
 // client request.
+//
+// The methods of a ConsistencyChecks are safe for concurrent use:
+// each of them runs atomically with respect to the others. The event
+// handlers (see SetTransitionHandler() and SetEpochHandler()) are
+// called once the method emitting the events has released the
+// ConsistencyChecks, so they may call its methods, and may run
+// concurrently with each other. The Bindings and TBs maps must not be
+// accessed directly while the ConsistencyChecks is in use by other
+// goroutines (see Binding() and TB()).
 type ConsistencyChecks struct {
 	// the auditor state stores the latest verified signed tree root
 	// as well as the server's signing key
 	*auditor.AudState
 	Bindings map[string][]byte
 
+	// lock serializes the methods, and events holds the notifications
+	// of the event handlers emitted while it is held, see unlock()
+	lock   sync.Mutex
+	events []func()
+
+	// the state of each binding, see BindingState
+	states       map[string]BindingState
+	onTransition func(*Transition)
+	onNewEpoch   func(*EpochEvent)
+
 	// extensions settings
 	useTBs bool
 	TBs    map[string]*protocol.TemporaryBinding
+	// the directory's identity, which the TBs must commit to,
+	// or nil if unknown, see SetDirectoryIdentity()
+	identity *[crypto.HashSizeByte]byte
+
+	// the key changes pending for each name, see PendingKeyChange()
+	changes map[string]*pendingChange
+
+	// the pinned checkpoints, indexed by epoch, see PinCheckpoints()
+	checkpoints map[uint64]*protocol.DirSTR
+
+	// the pinned auditors, see SetAuditors()
+	auditors  []string
+	sendAudit AuditSender
+
+	// the strict mode settings, nil if the strict mode is disabled,
+	// and the registrations awaiting confirmation, see Unconfirmed()
+	strict      *StrictMode
+	unconfirmed map[string]*unconfirmedRegistration
+
+	// verifiedAt is the time at which the client verified
+	// the latest verified STR, according to clock
+	clock      utils.Clock
+	verifiedAt time.Time
+
+	// onDivergence is called with the divergences of the reference
+	// verifier, see SetReferenceCheck()
+	onDivergence func(*Divergence)
 }
 
 // New creates an instance of ConsistencyChecks using
 // a CONIKS directory's pinned STR at epoch 0, or
 // the consistency state read from persistent storage.
+// The directory's identity is computed from a pinned STR at epoch 0;
+// otherwise, it should be set with SetDirectoryIdentity().
 func New(savedSTR *protocol.DirSTR, useTBs bool, signKey sign.PublicKey) *ConsistencyChecks {
 	// TODO: see #110
 	if !useTBs {
@@ -48,29 +101,170 @@ func New(savedSTR *protocol.DirSTR, useTBs bool, signKey sign.PublicKey) *Consis
 	cc := &ConsistencyChecks{
 		AudState: a,
 		Bindings: make(map[string][]byte),
+		states:   make(map[string]BindingState),
 		useTBs:   useTBs,
 		TBs:      nil,
+		changes:  make(map[string]*pendingChange),
+		clock:    utils.RealClock,
+
+		checkpoints: make(map[uint64]*protocol.DirSTR),
+		unconfirmed: make(map[string]*unconfirmedRegistration),
 	}
+	cc.verifiedAt = cc.clock.Now()
+	cc.ObserveSignatures(metrics.Signature.observe)
 	if useTBs {
 		cc.TBs = make(map[string]*protocol.TemporaryBinding)
 	}
+	if savedSTR.Epoch == 0 {
+		id := auditor.ComputeDirectoryIdentity(savedSTR)
+		cc.identity = &id
+	}
 	return cc
 }
 
+// SetDirectoryIdentity sets the identity of the directory, i.e. the
+// hash of its initial STR (see auditor.ComputeDirectoryIdentity()),
+// e.g. if the client was created from a later STR. The client then
+// rejects the TBs which don't commit to this identity with
+// a CheckBadPromise. Without an identity, the client can't detect
+// a TB replayed from another directory with the same signing key.
+func (cc *ConsistencyChecks) SetDirectoryIdentity(id [crypto.HashSizeByte]byte) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.identity = &id
+}
+
+// checkIdentity returns whether tb commits to the directory's
+// identity, if the client knows it.
+func (cc *ConsistencyChecks) checkIdentity(tb *protocol.TemporaryBinding) bool {
+	return cc.identity == nil || tb.DirInitSTRHash == *cc.identity
+}
+
+// SetClock sets the clock the client uses to determine whether its
+// verified STR is stale, e.g. a utils.FakeClock in tests.
+// The verified STR is considered to be verified at the clock's
+// current time.
+func (cc *ConsistencyChecks) SetClock(clock utils.Clock) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.clock = clock
+	cc.verifiedAt = clock.Now()
+}
+
+// Stale returns true if the directory must have issued a newer STR than
+// the client's verified STR, i.e. if more than the verified STR's epoch
+// deadline has passed since the client verified it. The client should
+// then fetch the directory's latest STR, e.g. by monitoring its bindings.
+func (cc *ConsistencyChecks) Stale() bool {
+	cc.lock.Lock()
+	defer cc.unlock()
+	deadline := time.Duration(cc.VerifiedSTR().Policies.EpochDeadline) * time.Second
+	return cc.clock.Now().After(cc.verifiedAt.Add(deadline))
+}
+
+// An EpochEvent is the event emitted by a ConsistencyChecks each time
+// it verifies an STR for a newer epoch than its verified STR, e.g.,
+// in a lookup or monitoring response.
+// PolicyChanges lists the names of the policy fields which differ
+// between the STRs of OldEpoch and NewEpoch (see protocol.Policies.Diff()).
+type EpochEvent struct {
+	OldEpoch      uint64
+	NewEpoch      uint64
+	PolicyChanges []string
+}
+
+// SetEpochHandler registers a function that is called with the
+// corresponding EpochEvent each time the client's verified STR moves
+// to a new epoch, so that the host application can, e.g., refresh its
+// UI or re-encrypt data for changed keys.
+// Passing a nil handler disables the notifications.
+func (cc *ConsistencyChecks) SetEpochHandler(handler func(*EpochEvent)) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.onNewEpoch = handler
+}
+
+// unlock releases the ConsistencyChecks, and then notifies the event
+// handlers of the events emitted while it was held, in order.
+func (cc *ConsistencyChecks) unlock() {
+	events := cc.events
+	cc.events = nil
+	cc.lock.Unlock()
+	for _, notify := range events {
+		notify()
+	}
+}
+
+// emit queues the notification of an event handler until the
+// ConsistencyChecks is released (see unlock()).
+func (cc *ConsistencyChecks) emit(notify func()) {
+	cc.events = append(cc.events, notify)
+}
+
+// Binding returns the key bound to uname which the client has
+// verified, if any. A deactivated binding (see Deactivated) has no key,
+// while a frozen binding (see Frozen) keeps its key.
+func (cc *ConsistencyChecks) Binding(uname string) ([]byte, bool) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	key, ok := cc.Bindings[uname]
+	if protocol.IsTombstone(key) {
+		return nil, false
+	}
+	if protocol.IsFrozen(key) {
+		return protocol.FrozenKey(key), true
+	}
+	return key, ok
+}
+
+// TB returns the TB for uname which the client has verified and which
+// awaits its inclusion in the directory, or nil.
+func (cc *ConsistencyChecks) TB(uname string) *protocol.TemporaryBinding {
+	cc.lock.Lock()
+	defer cc.unlock()
+	return cc.TBs[uname]
+}
+
+// updateVerifiedSTR updates the client's verified STR to str,
+// and records the time and notifies the epoch handler if str is
+// for a new epoch.
+func (cc *ConsistencyChecks) updateVerifiedSTR(str *protocol.DirSTR) {
+	old := cc.VerifiedSTR()
+	cc.Update(str)
+	if str.Epoch <= old.Epoch {
+		return
+	}
+	cc.verifiedAt = cc.clock.Now()
+	if handler := cc.onNewEpoch; handler != nil {
+		ev := &EpochEvent{
+			OldEpoch:      old.Epoch,
+			NewEpoch:      str.Epoch,
+			PolicyChanges: str.Policies.Diff(old.Policies),
+		}
+		cc.emit(func() { handler(ev) })
+	}
+}
+
 // CheckEquivocation checks for possible equivocation between
 // an auditors' observed STRs and the client's own view.
 // CheckEquivocation() first verifies the STR range received
-// in msg if msg contains more than 1 STR, and
+// in msg and its policy transitions if msg contains more than 1 STR, and
 // then checks the most recent STR in msg against
 // the cc.verifiedSTR.
 // CheckEquivocation() is called when a client receives a response to a
-// message.AuditingRequest from an auditor.
+// message.AuditingRequest from an auditor (see also Audit()).
 func (cc *ConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	return cc.checkEquivocation(msg)
+}
+
+func (cc *ConsistencyChecks) checkEquivocation(msg *protocol.Response) error {
 	if err := msg.Validate(); err != nil {
 		return err
 	}
 
-	strs := msg.DirectoryResponse.(*protocol.STRHistoryRange)
+	strs := msg.STRHistoryRange()
 
 	// verify the hashchain of the received STRs
 	// if we get more than 1 in our range
@@ -78,6 +272,9 @@ func (cc *ConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
 		if err := cc.VerifySTRRange(strs.STR[0], strs.STR[1:]); err != nil {
 			return err
 		}
+		if err := strs.Verify(); err != nil {
+			return err
+		}
 	}
 
 	// TODO: if the auditor has returned a more recent STR,
@@ -91,38 +288,101 @@ func (cc *ConsistencyChecks) CheckEquivocation(msg *protocol.Response) error {
 // If the status code is not in the Errors array, it means
 // the directory has successfully handled the request.
 // The verifier will then check the consistency (i.e. binding validity
-// and non-equivocation) of the response.
+// and non-equivocation) of the response, and whether the binding for
+// uname may move from its current state into the state indicated by the
+// response (see BindingState).
 //
-// HandleResponse() will panic if it is called with an int
-// that isn't a valid/known request type.
+// HandleResponse() only supports responses to registrations and key
+// lookups, and returns an ErrUnsupportedRequest for any other request
+// type (see HandleMonitoringResponse() and VerifyKeyHistory()).
 //
-// Note that the consistency state will be updated regardless of
-// whether the checks pass / fail, since a response message contains
+// Note that the verified STR will be updated as soon as the STRs in msg
+// pass the non-equivocation checks, regardless of whether the
+// remaining checks pass / fail, since a response message contains
 // cryptographic proof of having been issued nonetheless.
+// The state of the binding for uname, cc.Bindings and cc.TBs are only
+// updated if all checks pass.
+//
+// In strict mode (see SetStrictMode()), a registration response whose
+// STR is newer than the verified STR neither updates the verified STR
+// nor the binding for uname. If all checks pass, the registration
+// awaits an auditor's confirmation of its STR, and HandleResponse()
+// returns a CheckUnconfirmedSTR (see HandleConfirmation()).
+//
+// If the client has pinned auditors (see SetAuditors()), the verified
+// STR is audited once all checks pass (see Audit()), and
+// HandleResponse() returns a CheckAuditorDisagrees if an auditor has
+// observed a different STR; the binding for uname is updated all the
+// same. An audit in which no auditor could confirm the verified STR,
+// e.g. because the auditors haven't observed its epoch yet, doesn't
+// fail the response.
 func (cc *ConsistencyChecks) HandleResponse(requestType int, msg *protocol.Response,
 	uname string, key []byte) error {
+	cc.lock.Lock()
+	err := cc.handleResponse(requestType, msg, uname, key, false)
+	cc.unlock()
+	if err != nil {
+		return err
+	}
+	if err := cc.Audit(); err == protocol.CheckAuditorDisagrees {
+		return err
+	}
+	return nil
+}
+
+// handleResponse implements HandleResponse(). If verified is set, the
+// authentication path of msg has already been verified for the binding
+// of uname to key (see PrefetchBindings()). In the differential test
+// mode, the response is also checked by the reference verifier (see
+// SetReferenceCheck()).
+func (cc *ConsistencyChecks) handleResponse(requestType int, msg *protocol.Response,
+	uname string, key []byte, verified bool) error {
+	if cc.onDivergence == nil {
+		return cc.checkResponse(requestType, msg, uname, key, verified)
+	}
+	savedSTR, signKey := cc.VerifiedSTR(), cc.SigningKey()
+	err := cc.checkResponse(requestType, msg, uname, key, verified)
+	cc.compareWithReference(requestType, msg, uname, key, savedSTR, signKey, err)
+	return err
+}
+
+func (cc *ConsistencyChecks) checkResponse(requestType int, msg *protocol.Response,
+	uname string, key []byte, verified bool) error {
 	if err := msg.Validate(); err != nil {
 		return err
 	}
 	switch requestType {
-	case protocol.RegistrationType, protocol.KeyLookupType, protocol.KeyLookupInEpochType, protocol.MonitoringType:
-		if _, ok := msg.DirectoryResponse.(*protocol.DirectoryProof); !ok {
+	case protocol.RegistrationType, protocol.KeyLookupType:
+		if msg.DirectoryProof() == nil {
 			return protocol.ErrMalformedMessage
 		}
 	default:
-		panic("[coniks] Unknown request type")
+		return protocol.ErrUnsupportedRequest
 	}
-	if err := cc.updateSTR(requestType, msg); err != nil {
+	df := msg.DirectoryProof()
+	hold := cc.awaitsConfirmation(requestType, df.STR[0])
+	if hold {
+		if err := cc.AuditDirectory(df.STR[:1]); err != nil {
+			return err
+		}
+	} else if err := cc.updateSTR(requestType, msg); err != nil {
+		return err
+	}
+	if err := cc.checkConsistency(requestType, msg, uname, key, verified); err != nil {
 		return err
 	}
-	if err := cc.checkConsistency(requestType, msg, uname, key); err != nil {
+	next := nextState(msg.Error, df)
+	if err := cc.checkTransition(uname, next); err != nil {
 		return err
 	}
-	if err := cc.updateTBs(requestType, msg, uname, key); err != nil {
+	if err := cc.checkTBs(requestType, msg, uname, key); err != nil {
 		return err
 	}
-	recvKey, _ := msg.GetKey()
-	cc.Bindings[uname] = recvKey
+	if hold {
+		cc.holdRegistration(uname, next, df)
+		return protocol.CheckUnconfirmedSTR
+	}
+	cc.updateBinding(uname, next, df)
 	return nil
 }
 
@@ -130,7 +390,7 @@ func (cc *ConsistencyChecks) updateSTR(requestType int, msg *protocol.Response)
 	var str *protocol.DirSTR
 	switch requestType {
 	case protocol.RegistrationType, protocol.KeyLookupType:
-		str = msg.DirectoryResponse.(*protocol.DirectoryProof).STR[0]
+		str = msg.DirectoryProof().STR[0]
 		// The initial STR is pinned in the client
 		// so cc.verifiedSTR should never be nil
 		// FIXME: use STR slice from Response msg
@@ -139,32 +399,32 @@ func (cc *ConsistencyChecks) updateSTR(requestType int, msg *protocol.Response)
 		}
 
 	default:
-		panic("[coniks] Unknown request type")
+		return protocol.ErrUnsupportedRequest
 	}
 
 	// And update the saved STR
-	cc.Update(str)
+	cc.updateVerifiedSTR(str)
 
 	return nil
 }
 
 func (cc *ConsistencyChecks) checkConsistency(requestType int, msg *protocol.Response,
-	uname string, key []byte) error {
+	uname string, key []byte, verified bool) error {
 	var err error
 	switch requestType {
 	case protocol.RegistrationType:
 		err = cc.verifyRegistration(msg, uname, key)
 	case protocol.KeyLookupType:
-		err = cc.verifyKeyLookup(msg, uname, key)
+		err = cc.verifyKeyLookup(msg, uname, key, verified)
 	default:
-		panic("[coniks] Unknown request type")
+		return protocol.ErrUnsupportedRequest
 	}
 	return err
 }
 
 func (cc *ConsistencyChecks) verifyRegistration(msg *protocol.Response,
 	uname string, key []byte) error {
-	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	df := msg.DirectoryProof()
 	// FIXME: should explicitly validate that
 	// len(df.AP) == len(df.STR) == 1
 	ap := df.AP[0]
@@ -179,12 +439,12 @@ func (cc *ConsistencyChecks) verifyRegistration(msg *protocol.Response,
 		return protocol.ErrMalformedMessage
 	}
 
-	return verifyAuthPath(uname, key, ap, str)
+	return VerifyAuthPath(uname, key, ap, str)
 }
 
 func (cc *ConsistencyChecks) verifyKeyLookup(msg *protocol.Response,
-	uname string, key []byte) error {
-	df := msg.DirectoryResponse.(*protocol.DirectoryProof)
+	uname string, key []byte, verified bool) error {
+	df := msg.DirectoryProof()
 	// FIXME: should explicitly validate that
 	// len(df.AP) == len(df.STR) == 1
 	ap := df.AP[0]
@@ -193,20 +453,63 @@ func (cc *ConsistencyChecks) verifyKeyLookup(msg *protocol.Response,
 	proofType := ap.ProofType()
 	switch {
 	case msg.Error == protocol.ReqNameNotFound && proofType == merkletree.ProofOfAbsence:
-	// FIXME: This would be changed when we support key changes
 	case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfInclusion:
 	case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfAbsence && cc.useTBs:
 	default:
 		return protocol.ErrMalformedMessage
 	}
 
-	return verifyAuthPath(uname, key, ap, str)
+	if verified {
+		return nil
+	}
+	return VerifyAuthPath(uname, key, ap, str)
 }
 
-func verifyAuthPath(uname string, key []byte, ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
+// authPathErrors maps each error returned by
+// merkletree.AuthenticationPath.Verify() to the corresponding
+// consistency check error.
+var authPathErrors = map[error]protocol.ErrorCode{
+	merkletree.ErrBindingsDiffer:         protocol.CheckBindingsDiffer,
+	merkletree.ErrUnverifiableCommitment: protocol.CheckBadCommitment,
+	merkletree.ErrIndicesMismatch:        protocol.CheckBadLookupIndex,
+	merkletree.ErrUnequalTreeHashes:      protocol.CheckBadAuthPath,
+	merkletree.ErrMalformedAuthPath:      protocol.ErrMalformedMessage,
+}
+
+// VerifyAuthPath verifies the authentication path ap for the binding
+// of uname to key against the STR str, i.e., it verifies the VRF proof
+// of ap's lookup index and the authentication path itself.
+// If key is nil, the key included in ap is accepted (TOFU). If ap
+// includes the frozen binding of uname to key (see protocol.Frozen()),
+// the binding is accepted as well, since the directory's operator
+// froze it without changing its key.
+// Both are verified under the policies included in str, so that
+// a proof for a past epoch is verified with the VRF public key in force
+// at this epoch, and with the hash size declared in str's policies.
+// VerifyAuthPath() returns a CheckUnsupportedSTR if the hash algorithm
+// or hash size of str's policies isn't supported by this client.
+//
+// VerifyAuthPath() never panics, so that it can be embedded in
+// long-running applications: it returns an ErrMalformedMessage if
+// ap or str is incomplete, and the consistency check error
+// corresponding to the failed verification otherwise.
+// An unexpected error from the merkletree package is reported as
+// a CheckBadAuthPath.
+func VerifyAuthPath(uname string, key []byte, ap *merkletree.AuthenticationPath,
+	str *protocol.DirSTR) error {
+	if ap == nil || ap.Leaf == nil || str == nil ||
+		str.SignedTreeRoot == nil || str.Policies == nil {
+		return protocol.ErrMalformedMessage
+	}
+	hasher, err := strHasher(str)
+	if err != nil {
+		return err
+	}
 	// verify VRF Index
-	vrfKey := str.Policies.VrfPublicKey
-	if !vrfKey.Verify([]byte(uname), ap.LookupIndex, ap.VrfProof) {
+	start := time.Now()
+	valid := str.Policies.VerifyVrf([]byte(uname), ap.LookupIndex, ap.VrfProof)
+	metrics.VRF.observe(time.Since(start), valid)
+	if !valid {
 		return protocol.CheckBadVRFProof
 	}
 
@@ -215,70 +518,104 @@ func verifyAuthPath(uname string, key []byte, ap *merkletree.AuthenticationPath,
 		// Accept the received key as TOFU
 		key = ap.Leaf.Value
 	}
+	if protocol.IsFrozen(ap.Leaf.Value) && bytes.Equal(protocol.FrozenKey(ap.Leaf.Value), key) {
+		key = ap.Leaf.Value
+	}
 
-	switch err := ap.Verify([]byte(uname), key, str.TreeHash); err {
-	case merkletree.ErrBindingsDiffer:
-		return protocol.CheckBindingsDiffer
-	case merkletree.ErrUnverifiableCommitment:
-		return protocol.CheckBadCommitment
-	case merkletree.ErrIndicesMismatch:
-		return protocol.CheckBadLookupIndex
-	case merkletree.ErrUnequalTreeHashes:
-		return protocol.CheckBadAuthPath
-	case nil:
+	start = time.Now()
+	err = ap.VerifyWith(hasher, []byte(uname), key, str.TreeHash)
+	metrics.AuthPath.observe(time.Since(start), err == nil)
+	if err == nil {
 		return nil
-	default:
-		panic("[coniks] Unknown error: " + err.Error())
 	}
+	if code, ok := authPathErrors[err]; ok {
+		return code
+	}
+	return protocol.CheckBadAuthPath
 }
 
-func (cc *ConsistencyChecks) updateTBs(requestType int, msg *protocol.Response,
+// strHasher returns the hasher declared in the policies of str (see
+// protocol.Policies.Hasher()), with which the authentication paths are
+// verified, after checking that the tree hash of str has its size.
+// It returns a CheckUnsupportedSTR if the hash algorithm isn't
+// supported or if the hashes are truncated below
+// crypto.MinHashSizeByte, and an ErrMalformedMessage if the tree hash
+// doesn't have the declared size.
+func strHasher(str *protocol.DirSTR) (hashers.PADHasher, error) {
+	h, err := str.Policies.Hasher()
+	if err != nil {
+		return nil, protocol.CheckUnsupportedSTR
+	}
+	if len(str.TreeHash) != h.Size() {
+		return nil, protocol.ErrMalformedMessage
+	}
+	return h, nil
+}
+
+// checkTBs verifies the TB returned in msg, or that the binding
+// included in msg fulfills a previously returned TB.
+// For a key lookup, it also verifies the key changes pending for uname
+// (see verifyKeyChange()).
+func (cc *ConsistencyChecks) checkTBs(requestType int, msg *protocol.Response,
 	uname string, key []byte) error {
 	if !cc.useTBs {
 		return nil
 	}
+	df := msg.DirectoryProof()
+	ap := df.AP[0]
+	str := df.STR[0]
+	proofType := ap.ProofType()
 	switch requestType {
 	case protocol.RegistrationType:
-		df := msg.DirectoryResponse.(*protocol.DirectoryProof)
-		if df.AP[0].ProofType() == merkletree.ProofOfAbsence {
-			if err := cc.verifyReturnedPromise(df, key); err != nil {
-				return err
-			}
-			cc.TBs[uname] = df.TB
+		if proofType == merkletree.ProofOfAbsence {
+			return cc.verifyPendingPromise(uname, df, key)
 		}
-		return nil
 
 	case protocol.KeyLookupType:
-		df := msg.DirectoryResponse.(*protocol.DirectoryProof)
-		ap := df.AP[0]
-		str := df.STR[0]
-		proofType := ap.ProofType()
 		switch {
 		case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfInclusion:
 			if err := cc.verifyFulfilledPromise(uname, str, ap); err != nil {
 				return err
 			}
-			delete(cc.TBs, uname)
-
+			return cc.verifyKeyChange(uname, str, ap, df.TB)
 		case msg.Error == protocol.ReqSuccess && proofType == merkletree.ProofOfAbsence:
-			if err := cc.verifyReturnedPromise(df, key); err != nil {
-				return err
-			}
-			cc.TBs[uname] = df.TB
+			return cc.verifyPendingPromise(uname, df, key)
 		}
 
 	default:
-		panic("[coniks] Unknown request type")
+		return protocol.ErrUnsupportedRequest
 	}
 	return nil
 }
 
+// updateBinding moves the binding for uname into the verified state
+// next, and updates the client's bindings and TBs accordingly.
+// The caller must have verified df and checked that the transition
+// into next is allowed.
+func (cc *ConsistencyChecks) updateBinding(uname string, next BindingState,
+	df *protocol.DirectoryProof) {
+	switch next {
+	case Included, Deactivated, Frozen:
+		cc.Bindings[uname] = df.AP[0].Leaf.Value
+		delete(cc.TBs, uname)
+		cc.updateKeyChange(uname, df.STR[0], df.TB)
+	case Promised:
+		cc.Bindings[uname] = df.TB.Value
+		cc.TBs[uname] = df.TB
+	case Unregistered:
+		delete(cc.Bindings, uname)
+	}
+	cc.transition(uname, next, df.STR[0].Epoch)
+}
+
 // verifyFulfilledPromise verifies issued TBs were inserted
 // in the directory as promised.
 func (cc *ConsistencyChecks) verifyFulfilledPromise(uname string, str *protocol.DirSTR,
 	ap *merkletree.AuthenticationPath) error {
-	// FIXME: Which epoch did this lookup happen in?
 	if tb, ok := cc.TBs[uname]; ok {
+		if str.Epoch < tb.InclusionEpoch {
+			return protocol.CheckBadPromise
+		}
 		if !bytes.Equal(ap.LookupIndex, tb.Index) ||
 			!bytes.Equal(ap.Leaf.Value, tb.Value) {
 			return protocol.CheckBrokenPromise
@@ -287,6 +624,21 @@ func (cc *ConsistencyChecks) verifyFulfilledPromise(uname string, str *protocol.
 	return nil
 }
 
+// verifyPendingPromise validates the promise returned in df, and
+// verifies that a promise previously returned for uname is still
+// pending, i.e., that the epoch of the STR in df precedes
+// the promised inclusion epoch.
+func (cc *ConsistencyChecks) verifyPendingPromise(uname string,
+	df *protocol.DirectoryProof, key []byte) error {
+	if err := cc.verifyReturnedPromise(df, key); err != nil {
+		return err
+	}
+	if tb, ok := cc.TBs[uname]; ok && df.STR[0].Epoch >= tb.InclusionEpoch {
+		return protocol.CheckBrokenPromise
+	}
+	return nil
+}
+
 // verifyReturnedPromise validates a returned promise.
 // Note that the directory returns a promise iff the returned proof is
 // _a proof of absence_.
@@ -303,7 +655,8 @@ func (cc *ConsistencyChecks) verifyReturnedPromise(df *protocol.DirectoryProof,
 	str := df.STR[0]
 	tb := df.TB
 
-	if tb == nil {
+	// a proof of absence never comes with a key change
+	if tb == nil || tb.IsKeyChange() {
 		return protocol.CheckBadPromise
 	}
 
@@ -312,7 +665,13 @@ func (cc *ConsistencyChecks) verifyReturnedPromise(df *protocol.DirectoryProof,
 		return protocol.CheckBadSignature
 	}
 
-	if !bytes.Equal(tb.Index, ap.LookupIndex) {
+	// the directory only returns TBs issued in the epoch of str,
+	// which promise the binding's inclusion in the next epoch,
+	// and commit to the directory's identity.
+	if !bytes.Equal(tb.Index, ap.LookupIndex) ||
+		tb.IssuedEpoch != str.Epoch ||
+		tb.InclusionEpoch != tb.IssuedEpoch+1 ||
+		!cc.checkIdentity(tb) {
 		return protocol.CheckBadPromise
 	}
 
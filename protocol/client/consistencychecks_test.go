@@ -1 +1,422 @@
 package client
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestVerifyPromiseEpochs(t *testing.T) {
+	signKey := crypto.NewStaticTestSigningKey()
+	for _, tc := range []struct {
+		name   string
+		resign bool
+		issued uint64
+		want   error
+	}{
+		{"tampered epochs", false, 0, protocol.CheckBadSignature},
+		{"bad issued epoch", true, 0, protocol.CheckBadPromise},
+	} {
+		d, cc := newTestClient(t)
+		res := d.Register(&protocol.RegistrationRequest{
+			Username: alice,
+			Key:      key,
+		})
+		df := res.DirectoryProof()
+		tb := *df.TB
+		tb.IssuedEpoch = tc.issued
+		tb.InclusionEpoch = tc.issued + 1
+		if tc.resign {
+			tb.Signature = signKey.Sign(tb.Serialize(df.STR[0].Signature))
+		}
+		df.TB = &tb
+		if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != tc.want {
+			t.Errorf("%s: expect %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestVerifyTBDirectoryIdentity(t *testing.T) {
+	signKey := crypto.NewStaticTestSigningKey()
+	for _, tc := range []struct {
+		name     string
+		identity [crypto.HashSizeByte]byte
+		want     error
+	}{
+		{"same directory", [crypto.HashSizeByte]byte{}, nil},
+		{"other directory", [crypto.HashSizeByte]byte{1}, protocol.CheckBadPromise},
+	} {
+		d, cc := newTestClient(t)
+		cc.SetDirectoryIdentity(d.Identity())
+		res := d.Register(&protocol.RegistrationRequest{
+			Username: alice,
+			Key:      key,
+		})
+		df := res.DirectoryProof()
+		if tc.identity != ([crypto.HashSizeByte]byte{}) {
+			// a TB issued by another directory with the same key
+			tb := *df.TB
+			tb.DirInitSTRHash = tc.identity
+			tb.Signature = signKey.Sign(tb.Serialize(df.STR[0].Signature))
+			df.TB = &tb
+		}
+		if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != tc.want {
+			t.Errorf("%s: expect %v, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestVerifyPendingPromise(t *testing.T) {
+	d, cc := newTestClient(t)
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if tb := cc.TBs[alice]; tb.IssuedEpoch != 1 || tb.InclusionEpoch != 2 {
+		t.Fatal("Unexpected TB epochs", tb.IssuedEpoch, tb.InclusionEpoch)
+	}
+
+	// a directory which keeps returning a TB after the inclusion epoch
+	// has broken its promise
+	d.Update()
+	stale := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	df := stale.DirectoryProof()
+	if df.TB != nil {
+		t.Fatal("Expect the binding to be included")
+	}
+	res = d.Register(&protocol.RegistrationRequest{
+		Username: "bob",
+		Key:      key,
+	})
+	tb := *res.DirectoryProof().TB
+	tb.Index = df.AP[0].LookupIndex
+	tb.Signature = crypto.NewStaticTestSigningKey().Sign(tb.Serialize(df.STR[0].Signature))
+	df.TB = &tb
+	if err := cc.verifyPendingPromise(alice, df, key); err != protocol.CheckBrokenPromise {
+		t.Fatal("Expect", protocol.CheckBrokenPromise, "got", err)
+	}
+}
+
+func TestStaleVerifiedSTR(t *testing.T) {
+	d, cc := newTestClient(t)
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	cc.SetClock(clock)
+
+	// the test directory's epoch deadline is 1 second
+	clock.Advance(time.Second)
+	if cc.Stale() {
+		t.Fatal("Expect the verified STR not to be stale yet")
+	}
+	clock.Advance(time.Second)
+	if !cc.Stale() {
+		t.Fatal("Expect the verified STR to be stale")
+	}
+
+	// verifying a newer STR refreshes the client's view
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cc.Stale() {
+		t.Fatal("Expect the verified STR not to be stale")
+	}
+}
+
+func TestVerifyVXEdDSADirectory(t *testing.T) {
+	vrfKey, err := vrf.GenerateVXEdDSAKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := directory.New(1, vrfKey, crypto.NewStaticTestSigningKey(), 10, true)
+	if alg := d.LatestSTR().Policies.VrfAlgorithm; alg != vrf.VXEdDSA {
+		t.Fatal("Expect", vrf.VXEdDSA, "in the policies, got", alg)
+	}
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(d.LatestSTR(), true, pk)
+
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyTruncatedHashes(t *testing.T) {
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 10, true)
+	if err := d.SetHashSize(crypto.MinHashSizeByte); err != nil {
+		t.Fatal(err)
+	}
+	// the hashes are truncated along with the policies of the next epoch
+	d.Update()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(d.LatestSTR(), true, pk)
+
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	df := res.DirectoryProof()
+	if len(df.STR[0].TreeHash) != crypto.MinHashSizeByte {
+		t.Fatal("Expect", crypto.MinHashSizeByte, "got", len(df.STR[0].TreeHash))
+	}
+	for _, tc := range []struct {
+		hashID string
+		want   error
+	}{
+		// the hashes are truncated below the minimum size
+		{"SHAKE128/64", protocol.CheckUnsupportedSTR},
+		// the policies don't match the size of the tree hash
+		{crypto.HashID, protocol.ErrMalformedMessage},
+	} {
+		str := *df.STR[0]
+		policies := *str.Policies
+		policies.HashID = tc.hashID
+		str.Policies = &policies
+		if err := VerifyAuthPath(alice, key, df.AP[0], &str); err != tc.want {
+			t.Error(tc.hashID, "expect", tc.want, "got", err)
+		}
+	}
+}
+
+func TestVerifyDirectoryHasher(t *testing.T) {
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 10, true)
+	if err := d.SetHasher("SHA256/192"); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(d.LatestSTR(), true, pk)
+
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the authentication path only verifies with the directory's hasher
+	df := res.DirectoryProof()
+	for _, tc := range []struct {
+		hashID string
+		want   error
+	}{
+		{"SHA256/192", nil},
+		{"BLAKE2b/192", protocol.CheckBadAuthPath},
+		{"MD5", protocol.CheckUnsupportedSTR},
+	} {
+		str := *df.STR[0]
+		policies := *str.Policies
+		policies.HashID = tc.hashID
+		str.Policies = &policies
+		if err := VerifyAuthPath(alice, key, df.AP[0], &str); err != tc.want {
+			t.Error(tc.hashID, "expect", tc.want, "got", err)
+		}
+	}
+}
+
+// TestAuthPathErrorsExhaustive parses the source of the merkletree
+// package, and checks that each error returned by the methods of
+// merkletree.AuthenticationPath, in any file of the package, is mapped
+// to a consistency check error, so that a new verification error can't
+// be added without handling it in VerifyAuthPath().
+func TestAuthPathErrorsExhaustive(t *testing.T) {
+	known := map[string]error{
+		"ErrBindingsDiffer":         merkletree.ErrBindingsDiffer,
+		"ErrUnverifiableCommitment": merkletree.ErrUnverifiableCommitment,
+		"ErrIndicesMismatch":        merkletree.ErrIndicesMismatch,
+		"ErrUnequalTreeHashes":      merkletree.ErrUnequalTreeHashes,
+		"ErrMalformedAuthPath":      merkletree.ErrMalformedAuthPath,
+	}
+	files, err := filepath.Glob("../../merkletree/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	returned := make(map[string]bool)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || fd.Body == nil {
+				continue
+			}
+			recv, ok := fd.Recv.List[0].Type.(*ast.StarExpr)
+			if !ok {
+				continue
+			}
+			if id, ok := recv.X.(*ast.Ident); !ok || id.Name != "AuthenticationPath" {
+				continue
+			}
+			ast.Inspect(fd.Body, func(n ast.Node) bool {
+				ret, ok := n.(*ast.ReturnStmt)
+				if !ok {
+					return true
+				}
+				for _, res := range ret.Results {
+					if id, ok := res.(*ast.Ident); ok && strings.HasPrefix(id.Name, "Err") {
+						returned[id.Name] = true
+					}
+				}
+				return true
+			})
+		}
+	}
+	for name := range returned {
+		if _, ok := authPathErrors[known[name]]; !ok {
+			t.Error("Unmapped merkletree error", name)
+		}
+	}
+	if len(returned) != len(authPathErrors) {
+		t.Fatal("Expect", len(returned), "mapped errors, got", len(authPathErrors))
+	}
+}
+
+func TestVerifyMalformedAuthPath(t *testing.T) {
+	d, _ := newTestClient(t)
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	df := res.DirectoryProof()
+	ap := *df.AP[0]
+	ap.Leaf = nil
+	for _, tc := range []struct {
+		name string
+		ap   *merkletree.AuthenticationPath
+		str  *protocol.DirSTR
+	}{
+		{"nil auth path", nil, df.STR[0]},
+		{"no leaf", &ap, df.STR[0]},
+		{"nil STR", df.AP[0], nil},
+		{"no policies", df.AP[0], &protocol.DirSTR{SignedTreeRoot: df.STR[0].SignedTreeRoot}},
+	} {
+		if err := VerifyAuthPath(alice, key, tc.ap, tc.str); err != protocol.ErrMalformedMessage {
+			t.Error(tc.name, "expect", protocol.ErrMalformedMessage, "got", err)
+		}
+	}
+}
+
+func TestHandleResponseUnsupportedRequest(t *testing.T) {
+	d, cc := newTestClient(t)
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	for reqType := protocol.RegistrationType; reqType <= protocol.KeyHistoryType+1; reqType++ {
+		err := cc.HandleResponse(reqType, res, alice, key)
+		supported := reqType == protocol.RegistrationType ||
+			reqType == protocol.KeyLookupType
+		if supported == (err == protocol.ErrUnsupportedRequest) {
+			t.Error("Unexpected result for request type", reqType, "got", err)
+		}
+	}
+	res.DirectoryResponse = &protocol.STRHistoryRange{}
+	res.Error = protocol.ReqSuccess
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestConcurrentResponses(t *testing.T) {
+	d, cc := newTestClient(t)
+	// the handlers may run concurrently
+	var lock sync.Mutex
+	var transitions, epochs int
+	cc.SetTransitionHandler(func(tr *Transition) {
+		// handlers may call back into the ConsistencyChecks
+		if cc.State(tr.Name) != tr.To {
+			t.Error("Expect state", tr.To, "for", tr.Name)
+		}
+		lock.Lock()
+		transitions++
+		lock.Unlock()
+	})
+	cc.SetEpochHandler(func(*EpochEvent) {
+		cc.Stale()
+		lock.Lock()
+		epochs++
+		lock.Unlock()
+	})
+
+	var names []string
+	var regs, lookups []*protocol.Response
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("user%d", i)
+		names = append(names, name)
+		regs = append(regs, d.Register(&protocol.RegistrationRequest{
+			Username: name,
+			Key:      key,
+		}))
+	}
+	d.Update()
+	for _, name := range names {
+		lookups = append(lookups, d.KeyLookup(&protocol.KeyLookupRequest{Username: name}))
+	}
+
+	run := func(requestType int, responses []*protocol.Response) {
+		var wg sync.WaitGroup
+		for i := range names {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := cc.HandleResponse(requestType, responses[i], names[i], key); err != nil {
+					t.Error(names[i], "expect", nil, "got", err)
+				}
+				cc.Binding(names[i])
+				cc.VerifiedSTR()
+			}(i)
+		}
+		wg.Wait()
+	}
+	run(protocol.RegistrationType, regs)
+	run(protocol.KeyLookupType, lookups)
+
+	for _, name := range names {
+		if got := cc.State(name); got != Included {
+			t.Error(name, "expect", Included, "got", got)
+		}
+	}
+	if transitions != 2*len(names) || epochs != 1 {
+		t.Fatal("Expect", 2*len(names), "transitions and 1 epoch, got",
+			transitions, "and", epochs)
+	}
+}
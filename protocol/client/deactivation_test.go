@@ -0,0 +1,88 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestDeactivation(t *testing.T) {
+	d, cc := newTestClient(t)
+	userKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := userKey.Public()
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: pk})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, pk); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deactivation must be signed with the current key
+	forged := &protocol.DeactivationRequest{Username: alice}
+	if res := d.Deactivate(forged); res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+	req := protocol.NewDeactivationRequest(userKey, alice, pk)
+	if err := cc.HandleDeactivationResponse(req, d.Deactivate(req)); err != nil {
+		t.Fatal(err)
+	}
+	if tb := cc.PendingKeyChange(alice); tb == nil || !protocol.IsTombstone(tb.Value) {
+		t.Fatal("Expect a pending deactivation")
+	}
+
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cc.State(alice) != Deactivated {
+		t.Fatal("Expect", Deactivated, "got", cc.State(alice))
+	}
+	if key, ok := cc.Binding(alice); ok {
+		t.Fatal("Expect no key for a deactivated binding, got", key)
+	}
+	if err := cc.HandleDeactivationResponse(req, d.Deactivate(req)); err != protocol.ReqNameDeactivated {
+		t.Fatal("Expect", protocol.ReqNameDeactivated, "got", err)
+	}
+
+	// a deactivated binding can't be revived
+	if err := cc.checkTransition(alice, Included); err != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "got", err)
+	}
+}
+
+func TestDeactivationAbort(t *testing.T) {
+	d, cc := newTestClient(t)
+	userKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := userKey.Public()
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: pk})
+	d.Update()
+
+	req := protocol.NewDeactivationRequest(userKey, alice, pk)
+	if err := cc.HandleDeactivationResponse(req, d.Deactivate(req)); err != nil {
+		t.Fatal(err)
+	}
+	abort := protocol.NewKeyChangeAbortRequest(userKey, alice, cc.PendingKeyChange(alice))
+	if err := cc.HandleKeyChangeAbortResponse(alice, d.AbortKeyChange(abort)); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cc.State(alice) != Included {
+		t.Fatal("Expect", Included, "got", cc.State(alice))
+	}
+}
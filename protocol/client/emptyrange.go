@@ -0,0 +1,69 @@
+// Implements the verification of a CONIKS directory's proof that its
+// tree doesn't contain any leaf within a range of lookup indices.
+
+package client
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// HandleEmptyRangeResponse verifies the directory's response msg to
+// the request req for a proof that the directory's tree at the epoch
+// req.Epoch doesn't contain any leaf whose lookup index starts with the
+// requested prefix.
+//
+// The STRs in msg are verified as for HandleKeyLookupInEpochResponse(),
+// including the STRs requested with fetch if the directory has cut the
+// range short, and the proof is then verified against the tree hash of
+// the STR for req.Epoch (see merkletree.EmptyRangeProof.Verify()).
+// HandleEmptyRangeResponse() returns an ErrMalformedMessage if the
+// proof or the first STR in msg doesn't match req, and
+// a CheckBadAuthPath if the proof doesn't prove that the range is empty.
+func (cc *ConsistencyChecks) HandleEmptyRangeResponse(req *protocol.EmptyRangeRequest,
+	msg *protocol.Response,
+	fetch func(*protocol.STRHistoryRequest) (*protocol.Response, error)) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	p := msg.EmptyRangeProof()
+	if p == nil || p.STR[0].Epoch != req.Epoch ||
+		p.Proof.PrefixBits != req.PrefixBits ||
+		!bytes.Equal(p.Proof.Prefix, req.Prefix) {
+		return protocol.ErrMalformedMessage
+	}
+
+	strs, err := cc.completeSTRRange(p.STR, p.Continuation, fetch)
+	if err != nil {
+		return err
+	}
+	if err := strs[0].CheckHeader(); err != nil {
+		return err
+	}
+	if !cc.VerifySignature(strs[0]) {
+		return protocol.CheckBadSignature
+	}
+	if err := cc.auditSTRRange(strs); err != nil {
+		return err
+	}
+	hasher, err := strHasher(strs[0])
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = p.Proof.VerifyWith(hasher, strs[0].TreeHash)
+	metrics.AuthPath.observe(time.Since(start), err == nil)
+	switch err {
+	case nil:
+		return nil
+	case merkletree.ErrMalformedRange, merkletree.ErrMalformedAuthPath:
+		return protocol.ErrMalformedMessage
+	default:
+		return protocol.CheckBadAuthPath
+	}
+}
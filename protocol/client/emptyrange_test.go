@@ -0,0 +1,45 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestEmptyRange(t *testing.T) {
+	d, cc := newTestPastClient(t, 3)
+	// the tree was empty before alice's registration
+	req := &protocol.EmptyRangeRequest{Prefix: []byte{0}, PrefixBits: 1, Epoch: 0}
+	if err := cc.HandleEmptyRangeResponse(req, d.ProveEmptyRange(req), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice's half of the index space isn't empty, the other one is
+	req.Epoch = 1
+	res := d.ProveEmptyRange(req)
+	if res.Error == protocol.ReqSuccess {
+		req.Prefix = []byte{0x80}
+		res = d.ProveEmptyRange(req)
+	}
+	if res.Error != protocol.ReqRangeNotEmpty {
+		t.Fatal("Expect", protocol.ReqRangeNotEmpty, "got", res.Error)
+	}
+	req.Prefix[0] ^= 0x80
+	res = d.ProveEmptyRange(req)
+	if err := cc.HandleEmptyRangeResponse(req, res, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the directory claims the other half is empty
+	p := res.EmptyRangeProof()
+	req.Prefix[0] ^= 0x80
+	p.Proof.Prefix = req.Prefix
+	if err := cc.HandleEmptyRangeResponse(req, res, nil); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+	// the proof isn't for the requested range
+	req.Prefix = []byte{0x40}
+	if err := cc.HandleEmptyRangeResponse(req, res, nil); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
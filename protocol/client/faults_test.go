@@ -0,0 +1,28 @@
+//go:build faults
+// +build faults
+
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils/faults"
+)
+
+func TestCorruptedSignatures(t *testing.T) {
+	defer faults.Reset()
+	d, cc := newTestClient(t)
+	faults.Inject(faults.Signature, &faults.Fault{})
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	d.Update()
+	faults.Clear(faults.Signature)
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+}
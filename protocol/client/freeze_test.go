@@ -0,0 +1,80 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestFreeze(t *testing.T) {
+	d, cc := newTestClient(t)
+	key := []byte("key")
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+
+	// the pending freeze is announced with the directory's TB
+	if _, err := d.Freeze(alice); err != nil {
+		t.Fatal(err)
+	}
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if tb := cc.PendingKeyChange(alice); tb == nil || !protocol.IsFrozen(tb.Value) {
+		t.Fatal("Expect a pending freeze")
+	}
+
+	// the frozen binding keeps the key the client expects
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if cc.State(alice) != Frozen {
+		t.Fatal("Expect", Frozen, "got", cc.State(alice))
+	}
+	if got, ok := cc.Binding(alice); !ok || !bytes.Equal(got, key) {
+		t.Fatal("Expect the frozen key, got", got)
+	}
+	req := &protocol.KeyChangeRequest{Username: alice, Key: []byte("new")}
+	if err := cc.HandleKeyChangeResponse(req, d.KeyChange(req)); err != protocol.ReqNameFrozen {
+		t.Fatal("Expect", protocol.ReqNameFrozen, "got", err)
+	}
+
+	if _, err := d.Unfreeze(alice); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if cc.State(alice) != Included {
+		t.Fatal("Expect", Included, "got", cc.State(alice))
+	}
+}
+
+func TestFrozenBindingOfOtherKey(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: []byte("key")})
+	d.Update()
+	if _, err := d.Freeze(alice); err != nil {
+		t.Fatal(err)
+	}
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+
+	// the freeze of another key than the expected one is a change
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice,
+		[]byte("other")); err != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "got", err)
+	}
+}
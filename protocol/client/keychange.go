@@ -0,0 +1,242 @@
+// Implements the verification of the two-phase key changes of
+// a CONIKS directory. A key change is first pending: the directory
+// returns a TB promising the change in the next epoch, and the user
+// can contest the change with the previous key until then. The client
+// checks that the directory either commits or rolls back each pending
+// change it knows about, accordingly.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A pendingChange is a key change the client has verified the TB of,
+// and whether the client has verified that the change was aborted.
+type pendingChange struct {
+	tb      *protocol.TemporaryBinding
+	aborted bool
+}
+
+// PendingKeyChange returns the TB of the key change pending for uname
+// which the client has verified, e.g., in a key lookup, or nil.
+// The client can contest the change while ContestWindowOpen() returns
+// true (see protocol.NewKeyChangeAbortRequest()).
+func (cc *ConsistencyChecks) PendingKeyChange(uname string) *protocol.TemporaryBinding {
+	cc.lock.Lock()
+	defer cc.unlock()
+	return cc.pendingKeyChange(uname)
+}
+
+func (cc *ConsistencyChecks) pendingKeyChange(uname string) *protocol.TemporaryBinding {
+	if c := cc.changes[uname]; c != nil && !c.aborted {
+		return c.tb
+	}
+	return nil
+}
+
+// ContestWindowOpen returns whether the pending key change for uname
+// can still be aborted, i.e., whether the client's verified STR
+// precedes the epoch in which the change takes effect.
+func (cc *ConsistencyChecks) ContestWindowOpen(uname string) bool {
+	cc.lock.Lock()
+	defer cc.unlock()
+	tb := cc.pendingKeyChange(uname)
+	return tb != nil && cc.VerifiedSTR().Epoch < tb.InclusionEpoch
+}
+
+// HandleKeyChangeResponse verifies the directory's response msg to the
+// key change request req.
+// As for HandleResponse(), the verified STR is updated as soon as the
+// STR in msg passes the non-equivocation checks.
+// HandleKeyChangeResponse() then returns the error code of msg if the
+// directory didn't accept the change. Otherwise, it verifies the proof
+// of inclusion of the current binding for req.Username (the bound key
+// is accepted as TOFU if the client doesn't know it yet), and that the
+// returned TB promises the change from this key to req.Key in the next
+// epoch. If all checks pass, the change is pending
+// (see PendingKeyChange()).
+func (cc *ConsistencyChecks) HandleKeyChangeResponse(req *protocol.KeyChangeRequest,
+	msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	return cc.handleKeyChange(req.Username, req.Key, msg)
+}
+
+// HandleDeactivationResponse verifies the directory's response msg to
+// the deactivation request req, as HandleKeyChangeResponse() verifies
+// the response to a key change to the protocol.Tombstone. If all checks
+// pass, the deactivation is pending (see PendingKeyChange()), and the
+// binding is Deactivated once the client has verified the inclusion
+// of the Tombstone (see State()).
+// HandleDeactivationResponse() returns a ReqNameDeactivated if the
+// binding has already been deactivated.
+func (cc *ConsistencyChecks) HandleDeactivationResponse(req *protocol.DeactivationRequest,
+	msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	return cc.handleKeyChange(req.Username, protocol.Tombstone, msg)
+}
+
+// handleKeyChange implements HandleKeyChangeResponse() for the change
+// of the key bound to uname to key.
+func (cc *ConsistencyChecks) handleKeyChange(uname string, key []byte,
+	msg *protocol.Response) error {
+	df, err := cc.verifyChangeSTR(msg)
+	if err != nil {
+		return err
+	}
+	if msg.Error != protocol.ReqSuccess {
+		return msg.Error
+	}
+	ap, str := df.AP[0], df.STR[0]
+	if ap.ProofType() != merkletree.ProofOfInclusion {
+		return protocol.ErrMalformedMessage
+	}
+	if err := VerifyAuthPath(uname, cc.Bindings[uname], ap, str); err != nil {
+		return err
+	}
+	if err := cc.verifyKeyChangeTB(str, ap, df.TB); err != nil {
+		return err
+	}
+	if !bytes.Equal(df.TB.Value, key) {
+		return protocol.CheckBindingsDiffer
+	}
+	cc.Bindings[uname] = ap.Leaf.Value
+	cc.changes[uname] = &pendingChange{tb: df.TB}
+	return nil
+}
+
+// HandleKeyChangeAbortResponse verifies the directory's response msg to
+// the client's request to abort the pending key change for uname.
+// The directory must have accepted the abort within the contest window,
+// i.e., in an epoch preceding the change's inclusion epoch, and must
+// keep the binding to the previous key, which is verified by the
+// proof of inclusion in msg. The client then expects the directory to
+// keep the previous key in the snapshot of the inclusion epoch.
+// HandleKeyChangeAbortResponse() returns a ReqNoPendingChange if the
+// client doesn't know about a pending change for uname.
+func (cc *ConsistencyChecks) HandleKeyChangeAbortResponse(uname string,
+	msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	tb := cc.pendingKeyChange(uname)
+	if tb == nil {
+		return protocol.ReqNoPendingChange
+	}
+	df, err := cc.verifyChangeSTR(msg)
+	if err != nil {
+		return err
+	}
+	if msg.Error != protocol.ReqSuccess {
+		return msg.Error
+	}
+	ap, str := df.AP[0], df.STR[0]
+	if str.Epoch >= tb.InclusionEpoch {
+		return protocol.CheckBadPromise
+	}
+	if ap.ProofType() != merkletree.ProofOfInclusion {
+		return protocol.ErrMalformedMessage
+	}
+	if err := VerifyAuthPath(uname, tb.PreviousValue, ap, str); err != nil {
+		return err
+	}
+	cc.changes[uname].aborted = true
+	return nil
+}
+
+// verifyChangeSTR validates the response msg to a key change request
+// or abort, and audits and updates the verified STR with the STR in msg.
+func (cc *ConsistencyChecks) verifyChangeSTR(msg *protocol.Response) (*protocol.DirectoryProof, error) {
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	df := msg.DirectoryProof()
+	if df == nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	str := df.STR[0]
+	if err := cc.AuditDirectory([]*protocol.DirSTR{str}); err != nil {
+		return nil, err
+	}
+	cc.updateVerifiedSTR(str)
+	return df, nil
+}
+
+// verifyKeyChangeTB validates the TB tb of a pending key change,
+// returned along with the proof of inclusion ap of the current
+// binding in the snapshot of str.
+func (cc *ConsistencyChecks) verifyKeyChangeTB(str *protocol.DirSTR,
+	ap *merkletree.AuthenticationPath, tb *protocol.TemporaryBinding) error {
+	if tb == nil || !tb.IsKeyChange() {
+		return protocol.CheckBadPromise
+	}
+	if !cc.Verify(tb.Serialize(str.Signature), tb.Signature) {
+		return protocol.CheckBadSignature
+	}
+	// the change is issued in the epoch of str, from the key
+	// included in str, and takes effect in the next epoch
+	if !bytes.Equal(tb.Index, ap.LookupIndex) ||
+		!bytes.Equal(tb.PreviousValue, ap.Leaf.Value) ||
+		tb.IssuedEpoch != str.Epoch ||
+		tb.InclusionEpoch != tb.IssuedEpoch+1 ||
+		!cc.checkIdentity(tb) {
+		return protocol.CheckBadPromise
+	}
+	return nil
+}
+
+// verifyKeyChange checks the proof of inclusion ap for uname in the
+// snapshot of str against the key changes the client knows about.
+// Before the inclusion epoch of a pending change, the directory must
+// still include the previous key. From the inclusion epoch on, it must
+// include the new key, or the previous key if the change was aborted.
+// If the directory returns the TB tb of a pending change,
+// verifyKeyChange() also validates tb.
+func (cc *ConsistencyChecks) verifyKeyChange(uname string, str *protocol.DirSTR,
+	ap *merkletree.AuthenticationPath, tb *protocol.TemporaryBinding) error {
+	if c := cc.changes[uname]; c != nil {
+		switch {
+		case str.Epoch < c.tb.InclusionEpoch:
+			if !bytes.Equal(ap.Leaf.Value, c.tb.PreviousValue) {
+				return protocol.CheckBadPromise
+			}
+			// the directory must not keep returning an aborted change
+			if c.aborted && tb != nil && bytes.Equal(tb.Signature, c.tb.Signature) {
+				return protocol.CheckBrokenPromise
+			}
+		case c.aborted:
+			if !bytes.Equal(ap.Leaf.Value, c.tb.PreviousValue) {
+				return protocol.CheckBrokenPromise
+			}
+		default:
+			if !bytes.Equal(ap.Leaf.Value, c.tb.Value) {
+				return protocol.CheckBrokenPromise
+			}
+		}
+	}
+	if tb != nil {
+		return cc.verifyKeyChangeTB(str, ap, tb)
+	}
+	return nil
+}
+
+// updateKeyChange records the pending key change tb for uname returned
+// along with a verified proof of inclusion in the snapshot of str,
+// and forgets the changes which have taken effect or have been
+// rolled back by str's epoch. A new change requested after an abort
+// replaces the aborted change.
+func (cc *ConsistencyChecks) updateKeyChange(uname string, str *protocol.DirSTR,
+	tb *protocol.TemporaryBinding) {
+	c := cc.changes[uname]
+	if c != nil && str.Epoch >= c.tb.InclusionEpoch {
+		delete(cc.changes, uname)
+		c = nil
+	}
+	if tb != nil && (c == nil || c.aborted) {
+		cc.changes[uname] = &pendingChange{tb: tb}
+	}
+}
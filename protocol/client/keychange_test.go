@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestKeyChange registers alice with a signing key, and returns
+// the client's verified key change request and TB.
+func newTestKeyChange(t *testing.T) (*directory.ConiksDirectory, *ConsistencyChecks,
+	sign.PrivateKey, *protocol.TemporaryBinding) {
+	d, cc := newTestClient(t)
+	userKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := userKey.Public()
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: pk})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, pk); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+
+	req := protocol.NewKeyChangeRequest(userKey, alice, pk, key)
+	if err := cc.HandleKeyChangeResponse(req, d.KeyChange(req)); err != nil {
+		t.Fatal(err)
+	}
+	tb := cc.PendingKeyChange(alice)
+	if tb == nil || !cc.ContestWindowOpen(alice) {
+		t.Fatal("Expect a pending key change")
+	}
+	return d, cc, userKey, tb
+}
+
+func TestKeyChangeCommit(t *testing.T) {
+	d, cc, _, _ := newTestKeyChange(t)
+
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cc.PendingKeyChange(alice) != nil || cc.ContestWindowOpen(alice) ||
+		!bytes.Equal(cc.Bindings[alice], key) {
+		t.Fatal("Expect the key change to have taken effect")
+	}
+}
+
+func TestKeyChangeAbort(t *testing.T) {
+	d, cc, userKey, tb := newTestKeyChange(t)
+
+	req := protocol.NewKeyChangeAbortRequest(userKey, alice, tb)
+	if err := cc.HandleKeyChangeAbortResponse(alice, d.AbortKeyChange(req)); err != nil {
+		t.Fatal(err)
+	}
+	if cc.PendingKeyChange(alice) != nil {
+		t.Fatal("Expect the key change to be aborted")
+	}
+	if err := cc.HandleKeyChangeAbortResponse(alice, d.AbortKeyChange(req)); err != protocol.ReqNoPendingChange {
+		t.Fatal("Expect", protocol.ReqNoPendingChange, "got", err)
+	}
+
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cc.Bindings[alice], tb.PreviousValue) {
+		t.Fatal("Expect the previous key to be kept")
+	}
+}
+
+func TestKeyChangeBrokenRollback(t *testing.T) {
+	d, cc, _, _ := newTestKeyChange(t)
+	// the client has verified an abort the directory didn't apply
+	cc.changes[alice].aborted = true
+
+	d.Update()
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != protocol.CheckBrokenPromise {
+		t.Fatal("Expect", protocol.CheckBrokenPromise, "got", err)
+	}
+}
+
+func TestKeyChangeAbortAfterInclusion(t *testing.T) {
+	d, cc, userKey, tb := newTestKeyChange(t)
+	req := protocol.NewKeyChangeAbortRequest(userKey, alice, tb)
+	res := d.AbortKeyChange(req)
+
+	// an abort response for the epoch in which the change
+	// takes effect is too late
+	d.Update()
+	df := res.DirectoryProof()
+	df.STR[0] = d.LatestSTR()
+	if err := cc.HandleKeyChangeAbortResponse(alice, res); err != protocol.CheckBadPromise {
+		t.Fatal("Expect", protocol.CheckBadPromise, "got", err)
+	}
+}
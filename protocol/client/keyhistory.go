@@ -0,0 +1,125 @@
+// Implements the verification of a CONIKS directory's response to
+// a key history request.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A KeyHistoryEntry is a verified binding of a username at an epoch.
+// Key is nil if the username wasn't registered at Epoch.
+type KeyHistoryEntry struct {
+	Epoch uint64
+	Key   []byte
+}
+
+// VerifyKeyHistory verifies the directory's response msg to the key
+// history request req, and returns the verified key history of
+// req.Username: an entry for req.StartEpoch, followed by an entry for
+// each epoch in which the binding changed, in chronological order.
+//
+// Each STR in msg must be signed by the directory, and each
+// authentication path must verify against the STR of the same epoch.
+// VerifyKeyHistory() returns an ErrMalformedMessage if the response
+// doesn't start at req.StartEpoch, if its epochs aren't strictly
+// increasing, or if two consecutive entries prove the same binding.
+//
+// Note that VerifyKeyHistory() cannot detect a directory which omits
+// a change from the history. Verifying that the binding remained
+// unchanged between two entries requires monitoring these epochs.
+// VerifyKeyHistory() doesn't change the state of the consistency checks.
+func (cc *ConsistencyChecks) VerifyKeyHistory(req *protocol.KeyHistoryRequest,
+	msg *protocol.Response) ([]*KeyHistoryEntry, error) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	df := msg.DirectoryProof()
+	if df == nil || len(df.AP) != len(df.STR) ||
+		df.STR[0].Epoch != req.StartEpoch {
+		return nil, protocol.ErrMalformedMessage
+	}
+
+	var history []*KeyHistoryEntry
+	for i, str := range df.STR {
+		if i > 0 && str.Epoch <= df.STR[i-1].Epoch {
+			return nil, protocol.ErrMalformedMessage
+		}
+		if err := str.CheckHeader(); err != nil {
+			return nil, err
+		}
+		if !cc.VerifySignature(str) {
+			return nil, protocol.CheckBadSignature
+		}
+		ap := df.AP[i]
+		if err := VerifyAuthPath(req.Username, nil, ap, str); err != nil {
+			return nil, err
+		}
+		var key []byte
+		if ap.ProofType() == merkletree.ProofOfInclusion {
+			key = ap.Leaf.Value
+		}
+		if i > 0 && sameKey(history[i-1].Key, key) {
+			return nil, protocol.ErrMalformedMessage
+		}
+		history = append(history, &KeyHistoryEntry{
+			Epoch: str.Epoch,
+			Key:   key,
+		})
+	}
+	return history, nil
+}
+
+// KeyHistory returns the verified key history of req.Username for the
+// whole epoch range of req, following the continuations of the
+// directory's partial responses (see protocol.Continuation). fetch
+// sends a key history request to the directory and returns its
+// response. KeyHistory() verifies each response with
+// VerifyKeyHistory(), and merges the partial histories.
+// It returns an ErrMalformedMessage if a continuation doesn't continue
+// the range after the response's last entry.
+// The ConsistencyChecks aren't locked while fetch runs.
+func (cc *ConsistencyChecks) KeyHistory(req *protocol.KeyHistoryRequest,
+	fetch func(*protocol.KeyHistoryRequest) (*protocol.Response, error)) ([]*KeyHistoryEntry, error) {
+	r := *req
+	var history []*KeyHistoryEntry
+	for {
+		msg, err := fetch(&r)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := cc.VerifyKeyHistory(&r, msg)
+		if err != nil {
+			return nil, err
+		}
+		// a continued history starts with the binding at its start
+		// epoch, which may be the last binding of the previous part
+		if len(history) > 0 && sameKey(history[len(history)-1].Key, entries[0].Key) {
+			entries = entries[1:]
+		}
+		history = append(history, entries...)
+		df := msg.DirectoryProof()
+		if df.Continuation == nil {
+			return history, nil
+		}
+		next := df.Continuation.NextEpoch
+		if next <= df.STR[len(df.STR)-1].Epoch || next > r.EndEpoch {
+			return nil, protocol.ErrMalformedMessage
+		}
+		r.StartEpoch = next
+	}
+}
+
+// sameKey returns whether k1 and k2 are the same key,
+// distinguishing the absence of a key (nil) from an empty key.
+func sameKey(k1, k2 []byte) bool {
+	if k1 == nil || k2 == nil {
+		return k1 == nil && k2 == nil
+	}
+	return bytes.Equal(k1, k2)
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestVerifyKeyHistory(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+
+	req := &protocol.KeyHistoryRequest{
+		Username:   alice,
+		StartEpoch: 1,
+		EndEpoch:   4,
+	}
+	res := d.KeyHistory(req)
+	history, err := cc.VerifyKeyHistory(req, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatal("Expect", 2, "entries, got", len(history))
+	}
+	if history[0].Epoch != 1 || history[0].Key != nil {
+		t.Fatal("Expect no key in epoch", 1)
+	}
+	if history[1].Epoch != 2 || !bytes.Equal(history[1].Key, key) {
+		t.Fatal("Expect", key, "in epoch", 2)
+	}
+
+	// a directory cannot repeat an unchanged binding
+	df := res.DirectoryProof()
+	last := d.KeyHistory(&protocol.KeyHistoryRequest{
+		Username:   alice,
+		StartEpoch: 4,
+		EndEpoch:   4,
+	}).DirectoryProof()
+	res = protocol.NewKeyHistoryProof(
+		append(df.AP, last.AP[0]),
+		append(df.STR, last.STR[0]), nil)
+	if _, err := cc.VerifyKeyHistory(req, res); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// the history must start at the requested epoch
+	req.StartEpoch = 2
+	if _, err := cc.VerifyKeyHistory(req, d.KeyHistory(&protocol.KeyHistoryRequest{
+		Username:   alice,
+		StartEpoch: 1,
+		EndEpoch:   4,
+	})); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestKeyHistoryContinuation(t *testing.T) {
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 100, true)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key,
+		AllowUnsignedKeychange: true})
+	d.Update()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(d.LatestSTR(), true, pk)
+	// the key changes in each epoch
+	for i := 0; i < 20; i++ {
+		d.KeyChange(&protocol.KeyChangeRequest{Username: alice, Key: []byte{byte(i)}})
+		d.Update()
+	}
+
+	fetches := 0
+	req := &protocol.KeyHistoryRequest{Username: alice, StartEpoch: 1, EndEpoch: 21}
+	history, err := cc.KeyHistory(req, func(r *protocol.KeyHistoryRequest) (*protocol.Response, error) {
+		fetches++
+		return d.KeyHistory(r), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches < 2 {
+		t.Fatal("Expect the history to span several responses, got", fetches)
+	}
+	if len(history) != 21 {
+		t.Fatal("Expect", 21, "entries, got", len(history))
+	}
+	for i, entry := range history {
+		if entry.Epoch != uint64(i+1) {
+			t.Fatal("Expect epoch", i+1, "got", entry.Epoch)
+		}
+	}
+}
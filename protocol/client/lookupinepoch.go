@@ -0,0 +1,111 @@
+// Implements the verification of a CONIKS directory's response to
+// a key lookup in a past epoch. The authentication path for a past
+// epoch must be verified under the policies (e.g., the VRF public key)
+// the directory used in that epoch, which the client obtains from the
+// STR of that epoch once it has linked this STR to its verified history.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// HandleKeyLookupInEpochResponse verifies the directory's response msg
+// to the key lookup request req for the binding of req.Username to key
+// in the past epoch req.Epoch. If key is nil, the key included in msg
+// is accepted.
+//
+// The STR for req.Epoch must be signed by the directory, and the STRs
+// in msg must form a hash chain which includes cc.VerifiedSTR() or
+// directly precedes it. If the directory has cut the range of STRs
+// short (see protocol.Continuation), the missing STRs up to the
+// verified epoch are requested from the directory with fetch, which
+// sends an STR history request and returns its response.
+// The authentication path is then verified under the policies included
+// in the STR for req.Epoch, i.e., the VRF public key and hash algorithm
+// in force at this epoch (see VerifyAuthPath()).
+//
+// As for HandleMonitoringResponse(), the verified STR is updated as
+// soon as the STRs pass the non-equivocation checks, and the state of
+// the binding for req.Username doesn't change.
+// HandleKeyLookupInEpochResponse() returns an ErrMalformedMessage if
+// the first STR in msg isn't for req.Epoch, if the STRs aren't for
+// consecutive epochs, or if the error code of msg doesn't match the
+// returned proof type.
+func (cc *ConsistencyChecks) HandleKeyLookupInEpochResponse(req *protocol.KeyLookupInEpochRequest,
+	msg *protocol.Response, key []byte,
+	fetch func(*protocol.STRHistoryRequest) (*protocol.Response, error)) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	df := msg.DirectoryProof()
+	if df == nil || len(df.AP) != 1 || df.STR[0].Epoch != req.Epoch {
+		return protocol.ErrMalformedMessage
+	}
+	ap := df.AP[0]
+	switch {
+	case msg.Error == protocol.ReqSuccess && ap.ProofType() == merkletree.ProofOfInclusion:
+	case msg.Error == protocol.ReqNameNotFound && ap.ProofType() == merkletree.ProofOfAbsence:
+	default:
+		return protocol.ErrMalformedMessage
+	}
+
+	strs, err := cc.completeSTRRange(df.STR, df.Continuation, fetch)
+	if err != nil {
+		return err
+	}
+	if err := strs[0].CheckHeader(); err != nil {
+		return err
+	}
+	// the policies of the first STR are only covered by its signature
+	if !cc.VerifySignature(strs[0]) {
+		return protocol.CheckBadSignature
+	}
+	if err := cc.auditSTRRange(strs); err != nil {
+		return err
+	}
+	return VerifyAuthPath(req.Username, key, ap, strs[0])
+}
+
+// completeSTRRange checks that the STRs strs are for consecutive
+// epochs, and appends the STRs the directory has omitted after the
+// continuation next, up to the epoch of cc.VerifiedSTR(), which it
+// requests with fetch.
+func (cc *ConsistencyChecks) completeSTRRange(strs []*protocol.DirSTR,
+	next *protocol.Continuation,
+	fetch func(*protocol.STRHistoryRequest) (*protocol.Response, error)) ([]*protocol.DirSTR, error) {
+	verified := cc.VerifiedSTR().Epoch
+	for {
+		for i := 1; i < len(strs); i++ {
+			if strs[i].Epoch != strs[i-1].Epoch+1 {
+				return nil, protocol.ErrMalformedMessage
+			}
+		}
+		last := strs[len(strs)-1].Epoch
+		if next == nil || last >= verified || fetch == nil {
+			return strs, nil
+		}
+		if next.NextEpoch != last+1 {
+			return nil, protocol.ErrMalformedMessage
+		}
+		msg, err := fetch(&protocol.STRHistoryRequest{
+			StartEpoch: next.NextEpoch,
+			EndEpoch:   verified,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := msg.Validate(); err != nil {
+			return nil, err
+		}
+		r := msg.STRHistoryRange()
+		if r == nil {
+			return nil, protocol.ErrMalformedMessage
+		}
+		strs = append(strs, r.STR...)
+		next = r.Continuation
+	}
+}
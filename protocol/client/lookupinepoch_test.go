@@ -0,0 +1,100 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newTestPastClient returns a directory in which alice has been
+// registered n epochs ago, and a client which has verified the
+// directory's latest STR.
+func newTestPastClient(t *testing.T, n int) (*directory.ConiksDirectory, *ConsistencyChecks) {
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 100, true)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	for i := 0; i < n; i++ {
+		d.Update()
+	}
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	return d, New(d.LatestSTR(), true, pk)
+}
+
+func TestKeyLookupInEpoch(t *testing.T) {
+	d, cc := newTestPastClient(t, 3)
+	req := &protocol.KeyLookupInEpochRequest{Username: alice, Epoch: 1}
+	if err := cc.HandleKeyLookupInEpochResponse(req, d.KeyLookupInEpoch(req), key, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice wasn't registered yet
+	req.Epoch = 0
+	res := d.KeyLookupInEpoch(req)
+	if res.Error != protocol.ReqNameNotFound {
+		t.Fatal("Expect", protocol.ReqNameNotFound, "got", res.Error)
+	}
+	if err := cc.HandleKeyLookupInEpochResponse(req, res, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyLookupInEpochContinuation(t *testing.T) {
+	d, cc := newTestPastClient(t, 40)
+	req := &protocol.KeyLookupInEpochRequest{Username: alice, Epoch: 1}
+	res := d.KeyLookupInEpoch(req)
+	if res.DirectoryProof().Continuation == nil {
+		t.Fatal("Expect the range of STRs to be cut short")
+	}
+
+	// the range doesn't reach the verified STR
+	if err := cc.HandleKeyLookupInEpochResponse(req, res, key, nil); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+
+	fetches := 0
+	fetch := func(r *protocol.STRHistoryRequest) (*protocol.Response, error) {
+		fetches++
+		return d.GetSTRHistory(r), nil
+	}
+	if err := cc.HandleKeyLookupInEpochResponse(req, res, key, fetch); err != nil {
+		t.Fatal(err)
+	}
+	if fetches == 0 {
+		t.Fatal("Expect the missing STRs to be fetched")
+	}
+}
+
+func TestKeyLookupInEpochHistoricalPolicies(t *testing.T) {
+	d, cc := newTestPastClient(t, 3)
+	req := &protocol.KeyLookupInEpochRequest{Username: alice, Epoch: 1}
+	res := d.KeyLookupInEpoch(req)
+	df := res.DirectoryProof()
+
+	// the directory claims another VRF key for the past epoch
+	str := *df.STR[0]
+	policies := *str.Policies
+	policies.VrfPublicKey = append([]byte{}, policies.VrfPublicKey...)
+	policies.VrfPublicKey[0]++
+	str.Policies = &policies
+	df.STR[0] = &str
+	if err := cc.HandleKeyLookupInEpochResponse(req, res, key, nil); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	// the hash algorithm of the past epoch isn't supported
+	policies.VrfPublicKey = df.STR[1].Policies.VrfPublicKey
+	policies.HashID = "unknown"
+	if err := VerifyAuthPath(alice, key, df.AP[0], &str); err != protocol.CheckUnsupportedSTR {
+		t.Fatal("Expect", protocol.CheckUnsupportedSTR, "got", err)
+	}
+}
+
+func TestKeyLookupInEpochMalformed(t *testing.T) {
+	d, cc := newTestPastClient(t, 3)
+	req := &protocol.KeyLookupInEpochRequest{Username: alice, Epoch: 1}
+	res := d.KeyLookupInEpoch(&protocol.KeyLookupInEpochRequest{Username: alice, Epoch: 2})
+	if err := cc.HandleKeyLookupInEpochResponse(req, res, key, nil); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
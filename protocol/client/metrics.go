@@ -0,0 +1,149 @@
+// Implements the metrics on the cost of the cryptographic
+// verifications done by CONIKS clients, which let application
+// developers budget these costs on their target hardware.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// A VerificationTimer counts the verifications of one kind, the
+// verifications which failed, and their total duration.
+// It is safe for concurrent use.
+type VerificationTimer struct {
+	count    uint64
+	failures uint64
+	nanos    uint64
+}
+
+// observe records a verification which took elapsed and whose result
+// is valid.
+func (t *VerificationTimer) observe(elapsed time.Duration, valid bool) {
+	atomic.AddUint64(&t.count, 1)
+	atomic.AddUint64(&t.nanos, uint64(elapsed))
+	if !valid {
+		atomic.AddUint64(&t.failures, 1)
+	}
+}
+
+// Count returns the number of verifications.
+func (t *VerificationTimer) Count() uint64 {
+	return atomic.LoadUint64(&t.count)
+}
+
+// Failures returns the number of verifications which failed.
+func (t *VerificationTimer) Failures() uint64 {
+	return atomic.LoadUint64(&t.failures)
+}
+
+// Total returns the total duration of the verifications.
+func (t *VerificationTimer) Total() time.Duration {
+	return time.Duration(atomic.LoadUint64(&t.nanos))
+}
+
+// Mean returns the mean duration of a verification,
+// or 0 if there hasn't been any verification.
+func (t *VerificationTimer) Mean() time.Duration {
+	count := t.Count()
+	if count == 0 {
+		return 0
+	}
+	return t.Total() / time.Duration(count)
+}
+
+// VerificationMetrics collects the metrics of all clients in the
+// process, for each kind of verification:
+// the VRF proofs of the lookup indices (VRF), the authentication paths
+// and other proofs against the tree hash (AuthPath), and the
+// signatures of the directory on its STRs, temporary bindings and
+// policy documents (Signature).
+//
+// VerificationMetrics implements the expvar.Var interface, so that
+// the metrics can be exported with expvar.Publish(), and
+// WritePrometheus() writes them in the Prometheus text format.
+type VerificationMetrics struct {
+	VRF       VerificationTimer
+	AuthPath  VerificationTimer
+	Signature VerificationTimer
+}
+
+var metrics VerificationMetrics
+
+// Metrics returns the verification metrics of the clients
+// in the process.
+func Metrics() *VerificationMetrics {
+	return &metrics
+}
+
+// timers returns the timers of m along with the names of their
+// verification kinds.
+func (m *VerificationMetrics) timers() []struct {
+	name  string
+	timer *VerificationTimer
+} {
+	return []struct {
+		name  string
+		timer *VerificationTimer
+	}{
+		{"vrf", &m.VRF},
+		{"auth_path", &m.AuthPath},
+		{"signature", &m.Signature},
+	}
+}
+
+// String returns a JSON encoding of the metrics m,
+// as required by expvar.Var.
+func (m *VerificationMetrics) String() string {
+	type timer struct {
+		Count    uint64
+		Failures uint64
+		TotalNs  int64
+	}
+	out := make(map[string]timer)
+	for _, t := range m.timers() {
+		out[t.name] = timer{
+			Count:    t.timer.Count(),
+			Failures: t.timer.Failures(),
+			TotalNs:  int64(t.timer.Total()),
+		}
+	}
+	buf, _ := json.Marshal(out)
+	return string(buf)
+}
+
+// WritePrometheus writes the metrics m to w in the Prometheus text
+// exposition format, e.g. to be served by an application's
+// metrics endpoint.
+func (m *VerificationMetrics) WritePrometheus(w io.Writer) error {
+	for _, metric := range []struct {
+		name, help string
+		value      func(*VerificationTimer) string
+	}{
+		{"coniks_client_verifications_total",
+			"Number of verifications done by CONIKS clients.",
+			func(t *VerificationTimer) string { return fmt.Sprint(t.Count()) }},
+		{"coniks_client_verification_failures_total",
+			"Number of failed verifications done by CONIKS clients.",
+			func(t *VerificationTimer) string { return fmt.Sprint(t.Failures()) }},
+		{"coniks_client_verification_seconds_total",
+			"Total duration of the verifications done by CONIKS clients.",
+			func(t *VerificationTimer) string { return fmt.Sprint(t.Total().Seconds()) }},
+	} {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n",
+			metric.name, metric.help, metric.name); err != nil {
+			return err
+		}
+		for _, t := range m.timers() {
+			if _, err := fmt.Fprintf(w, "%s{check=%q} %s\n",
+				metric.name, t.name, metric.value(t.timer)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestVerificationMetrics(t *testing.T) {
+	d, cc := newTestPastClient(t, 1)
+	m := Metrics()
+	vrf, authPath, sigs := m.VRF.Count(), m.AuthPath.Count(), m.Signature.Count()
+	failures := m.VRF.Failures()
+
+	req := &protocol.KeyLookupInEpochRequest{Username: alice, Epoch: 1}
+	if err := cc.HandleKeyLookupInEpochResponse(req, d.KeyLookupInEpoch(req), key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.VRF.Count() <= vrf || m.AuthPath.Count() <= authPath ||
+		m.Signature.Count() <= sigs {
+		t.Fatal("Expect the verifications to be counted")
+	}
+	if m.VRF.Failures() != failures {
+		t.Fatal("Expect", failures, "got", m.VRF.Failures())
+	}
+
+	// the directory returns a bad VRF proof
+	res := d.KeyLookupInEpoch(req)
+	ap := res.DirectoryProof().AP[0]
+	ap.VrfProof = append([]byte{}, ap.VrfProof...)
+	ap.VrfProof[0]++
+	if err := VerifyAuthPath(alice, key, ap, d.LatestSTR()); err != protocol.CheckBadVRFProof {
+		t.Fatal("Expect", protocol.CheckBadVRFProof, "got", err)
+	}
+	if m.VRF.Failures() != failures+1 {
+		t.Fatal("Expect", failures+1, "got", m.VRF.Failures())
+	}
+}
+
+func TestVerificationMetricsExport(t *testing.T) {
+	m := new(VerificationMetrics)
+	m.VRF.observe(3, true)
+	m.VRF.observe(5, false)
+	if m.VRF.Mean() != 4 {
+		t.Fatal("Expect", 4, "got", m.VRF.Mean())
+	}
+
+	var out map[string]struct {
+		Count    uint64
+		Failures uint64
+		TotalNs  int64
+	}
+	if err := json.Unmarshal([]byte(m.String()), &out); err != nil {
+		t.Fatal(err)
+	}
+	if vrf := out["vrf"]; vrf.Count != 2 || vrf.Failures != 1 || vrf.TotalNs != 8 {
+		t.Fatal("Unexpected VRF metrics", vrf)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`coniks_client_verifications_total{check="vrf"} 2`,
+		`coniks_client_verification_failures_total{check="vrf"} 1`,
+		`coniks_client_verifications_total{check="signature"} 0`,
+		"# TYPE coniks_client_verification_seconds_total counter",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Fatal("Expect", line, "in", buf.String())
+		}
+	}
+}
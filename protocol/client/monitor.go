@@ -0,0 +1,240 @@
+// Implements a background monitor of the bindings a CONIKS client has
+// verified, which monitors them every epoch, so that the client detects
+// an equivocating directory without looking them up itself.
+
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// DefaultMonitorInterval is the interval at which a Monitor monitors
+// the bindings if the directory's policies don't specify an epoch
+// deadline.
+const DefaultMonitorInterval = time.Minute
+
+// A Violation is the event emitted by a Monitor each time it fails to
+// verify the binding for Name: Err is either the consistency check
+// error of the directory's response, or the error which occurred while
+// fetching it. Epoch is the epoch of the client's verified STR.
+type Violation struct {
+	Name  string
+	Epoch uint64
+	Err   error
+}
+
+// A Monitor monitors the bindings of a ConsistencyChecks in the
+// background: once started, it wakes up every epoch, according to the
+// epoch deadline of the verified STR and the client's clock (see
+// SetClock()), and monitors each verified, promised or watched binding
+// (see Watch()) up to the directory's latest epoch (see Check()).
+type Monitor struct {
+	cc          *ConsistencyChecks
+	fetch       func(*protocol.MonitoringRequest) (*protocol.Response, error)
+	onViolation func(*Violation)
+
+	// lock serializes the checks, from holds the verified STR up
+	// to which each binding has been monitored, included the bindings
+	// whose inclusion the monitor has verified, and watched the keys
+	// of the bindings added by Watch()
+	lock     sync.Mutex
+	from     map[string]*protocol.DirSTR
+	included map[string]bool
+	watched  map[string][]byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewMonitor creates a Monitor of the bindings of cc. fetch sends
+// a monitoring request to the directory and returns its response, and
+// onViolation is called with the corresponding Violation each time
+// a binding fails to verify.
+func NewMonitor(cc *ConsistencyChecks,
+	fetch func(*protocol.MonitoringRequest) (*protocol.Response, error),
+	onViolation func(*Violation)) *Monitor {
+	return &Monitor{
+		cc:          cc,
+		fetch:       fetch,
+		onViolation: onViolation,
+		from:        make(map[string]*protocol.DirSTR),
+		included:    make(map[string]bool),
+		watched:     make(map[string][]byte),
+	}
+}
+
+// Watch adds the binding of name to key to the monitored bindings,
+// e.g., a binding the user registered with another client, which cc
+// hasn't verified itself. The binding is monitored from the verified
+// STR when it is first watched; watching it again only changes the
+// expected key.
+func (m *Monitor) Watch(name string, key []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.watched[name] = key
+	if _, ok := m.from[name]; !ok {
+		m.from[name] = m.cc.VerifiedSTR()
+	}
+}
+
+// Monitored returns the verified STR up to which the binding of name
+// has been monitored, or nil if it hasn't been monitored yet, and
+// whether the monitor has verified its inclusion.
+func (m *Monitor) Monitored(name string) (*protocol.DirSTR, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.from[name], m.included[name] || m.cc.State(name) == Included
+}
+
+// Start starts monitoring the bindings in a new goroutine, every epoch
+// until Stop() is called. A Monitor can only be started once.
+func (m *Monitor) Start() {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	m.cc.lock.Lock()
+	clock := m.cc.clock
+	m.cc.unlock()
+	go m.run(clock.NewTimer(m.interval()))
+}
+
+// Stop stops the monitor started by Start(), and waits for the
+// bindings being monitored, if any.
+func (m *Monitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *Monitor) run(timer utils.Timer) {
+	defer close(m.done)
+	for {
+		select {
+		case <-m.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+			m.Check()
+			timer.Reset(m.interval())
+		}
+	}
+}
+
+// interval returns the epoch deadline of the verified STR.
+func (m *Monitor) interval() time.Duration {
+	deadline := m.cc.VerifiedSTR().Policies.EpochDeadline
+	if deadline == 0 {
+		return DefaultMonitorInterval
+	}
+	return time.Duration(deadline) * time.Second
+}
+
+// Check monitors each binding the client has verified (see Binding()),
+// has been promised (see TB()) or watches (see Watch()), from the verified STR it was last
+// monitored up to, or the verified STR when the monitor first sees it,
+// up to the directory's latest epoch (see ConsistencyChecks.Monitor()).
+// The responses thus also prove that the promises whose inclusion epoch
+// has passed have been kept, and that the included bindings haven't
+// been removed since, which would fail with a CheckBindingsDiffer.
+// Check() emits a Violation for each binding which fails to verify,
+// and returns them; a failed binding is monitored again from the same
+// epoch at the next check.
+func (m *Monitor) Check() []*Violation {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var violations []*Violation
+	for _, name := range m.names() {
+		if err := m.monitor(name); err != nil {
+			v := &Violation{
+				Name:  name,
+				Epoch: m.cc.VerifiedSTR().Epoch,
+				Err:   err,
+			}
+			if m.onViolation != nil {
+				m.onViolation(v)
+			}
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// names returns the names of the verified, promised and watched
+// bindings, in order.
+func (m *Monitor) names() []string {
+	m.cc.lock.Lock()
+	defer m.cc.unlock()
+	var names []string
+	for name := range m.cc.Bindings {
+		names = append(names, name)
+	}
+	for name := range m.cc.TBs {
+		if _, ok := m.cc.Bindings[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range m.watched {
+		_, bound := m.cc.Bindings[name]
+		if _, promised := m.cc.TBs[name]; !bound && !promised {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// monitor monitors the binding for name from the STR it was last
+// monitored up to, which the directory omits from its responses.
+// The key of a watched binding takes precedence over the client's.
+func (m *Monitor) monitor(name string) error {
+	from, ok := m.from[name]
+	if !ok {
+		from = m.cc.VerifiedSTR()
+	}
+	key, ok := m.watched[name]
+	if !ok {
+		key, ok = m.cc.Binding(name)
+	}
+	if tb := m.cc.TB(name); !ok && tb != nil {
+		key = tb.Value
+	}
+	included := m.included[name] || m.cc.State(name) == Included
+
+	req := &protocol.MonitoringRequest{
+		Username:   name,
+		StartEpoch: from.Epoch,
+		EndEpoch:   ^uint64(0),
+		KnownEpoch: from.Epoch,
+	}
+	var aps []*merkletree.AuthenticationPath
+	err := m.cc.Monitor(req, key, []*protocol.DirSTR{from},
+		func(r *protocol.MonitoringRequest) (*protocol.Response, error) {
+			msg, err := m.fetch(r)
+			if err != nil {
+				return nil, err
+			}
+			if df := msg.DirectoryProof(); df != nil {
+				aps = append(aps, df.AP...)
+			}
+			return msg, nil
+		})
+	if err != nil {
+		return err
+	}
+	// the client accepts proofs of absence while a binding is promised
+	for _, ap := range aps {
+		switch {
+		case ap.ProofType() == merkletree.ProofOfInclusion:
+			included = true
+		case included:
+			return protocol.CheckBindingsDiffer
+		}
+	}
+	m.included[name] = included
+	m.from[name] = m.cc.VerifiedSTR()
+	return nil
+}
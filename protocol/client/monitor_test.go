@@ -0,0 +1,122 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestMonitorVerifiesPromises(t *testing.T) {
+	d, cc := newTestClient(t)
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMonitor(cc, func(req *protocol.MonitoringRequest) (*protocol.Response, error) {
+		return d.Monitor(req), nil
+	}, nil)
+	if vs := m.Check(); len(vs) != 0 {
+		t.Fatal("Unexpected violation", vs[0].Err)
+	}
+	d.Update()
+	if vs := m.Check(); len(vs) != 0 {
+		t.Fatal("Unexpected violation", vs[0].Err)
+	}
+	if got := cc.State(alice); got != Included {
+		t.Fatal("Expect", Included, "got", got)
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect", d.LatestSTR().Epoch, "got", cc.VerifiedSTR().Epoch)
+	}
+}
+
+func TestMonitorReportsViolations(t *testing.T) {
+	d, cc := newTestClient(t)
+	// the directory removed bob's binding
+	cc.Bindings["bob"] = key
+	cc.states["bob"] = Included
+	errFetch := errors.New("unreachable directory")
+	var reported []*Violation
+	m := NewMonitor(cc, func(req *protocol.MonitoringRequest) (*protocol.Response, error) {
+		if req.Username == "carol" {
+			return nil, errFetch
+		}
+		return d.Monitor(req), nil
+	}, func(v *Violation) {
+		reported = append(reported, v)
+	})
+	cc.Bindings["carol"] = key
+
+	vs := m.Check()
+	if len(vs) != 2 || len(reported) != 2 {
+		t.Fatal("Expect", 2, "violations, got", len(vs))
+	}
+	if vs[0].Name != "bob" || vs[0].Err != protocol.CheckBindingsDiffer {
+		t.Error("Expect", protocol.CheckBindingsDiffer, "for bob, got", vs[0].Err)
+	}
+	if vs[1].Name != "carol" || vs[1].Err != errFetch {
+		t.Error("Expect", errFetch, "for carol, got", vs[1].Err)
+	}
+}
+
+func TestMonitorRunsEveryEpoch(t *testing.T) {
+	d, cc := newTestClient(t)
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	cc.SetClock(clock)
+	cc.Bindings[alice] = nil
+	fetched := make(chan uint64, 1)
+	m := NewMonitor(cc, func(req *protocol.MonitoringRequest) (*protocol.Response, error) {
+		fetched <- req.StartEpoch
+		return d.Monitor(req), nil
+	}, nil)
+	m.Start()
+	defer m.Stop()
+
+	d.Update()
+	clock.Advance(time.Duration(d.LatestSTR().Policies.EpochDeadline) * time.Second)
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("Expect the monitor to run after an epoch")
+	}
+}
+
+func TestMonitorWatchedBindings(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	m := NewMonitor(cc, func(req *protocol.MonitoringRequest) (*protocol.Response, error) {
+		return d.Monitor(req), nil
+	}, nil)
+	m.Watch(alice, key)
+	if str, _ := m.Monitored(alice); str != cc.VerifiedSTR() {
+		t.Fatal("Expect the binding to be monitored from the verified STR")
+	}
+
+	d.Update()
+	if vs := m.Check(); len(vs) != 0 {
+		t.Fatal("Unexpected violation", vs[0].Err)
+	}
+	str, included := m.Monitored(alice)
+	if str.Epoch != d.LatestSTR().Epoch || !included {
+		t.Fatal("Expect alice to be included and monitored up to epoch",
+			d.LatestSTR().Epoch, "got", included, str.Epoch)
+	}
+
+	// the directory binds alice to another key
+	m.Watch(alice, []byte("another key"))
+	d.Update()
+	vs := m.Check()
+	if len(vs) != 1 || vs[0].Name != alice || vs[0].Err != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "for alice, got", vs)
+	}
+	if got, _ := m.Monitored(alice); got != str {
+		t.Error("Expect the failed binding to be monitored again from epoch", str.Epoch)
+	}
+}
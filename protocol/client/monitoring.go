@@ -0,0 +1,219 @@
+// Implements the verification of a CONIKS directory's response to
+// a monitoring request. The directory may omit the STRs the client
+// has already verified, in which case the client stitches its locally
+// verified STRs with the STRs included in the response.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// HandleMonitoringResponse verifies the directory's response msg to the
+// monitoring request req for the binding of uname to key.
+// known contains the STRs the client has already verified for the
+// epochs [req.StartEpoch, req.KnownEpoch] in chronological order, which
+// the directory omits from its response (see directory.Monitor()).
+// The client then verifies the hash chain of the stitched STR range,
+// its consistency with cc.VerifiedSTR(), and the authentication path
+// for uname in each epoch against the STR of the same epoch.
+//
+// As for HandleResponse(), the verified STR is updated as soon as the
+// STRs pass the non-equivocation checks.
+// If the response includes the proof of the binding's first inclusion
+// (see protocol.TransitionProof), the client verifies it as well.
+// If the binding for uname is promised, and the monitored range
+// includes the promised inclusion epoch, the response must prove the
+// binding's inclusion in this epoch with the promised key, and the
+// client then moves the binding into the Included state. Otherwise,
+// monitoring doesn't change the state of the binding for uname.
+// HandleMonitoringResponse() returns an ErrMalformedMessage if the
+// STRs in msg and known don't cover the epochs of the response's
+// authentication paths, and a CheckBrokenPromise if the response
+// doesn't prove that the promise has been kept.
+func (cc *ConsistencyChecks) HandleMonitoringResponse(req *protocol.MonitoringRequest,
+	msg *protocol.Response, key []byte, known []*protocol.DirSTR) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	df := msg.DirectoryProof()
+	if df == nil {
+		return protocol.ErrMalformedMessage
+	}
+	strs, err := stitchSTRs(req, df, known)
+	if err != nil {
+		return err
+	}
+	if err := cc.auditSTRRange(strs); err != nil {
+		return err
+	}
+	for i, ap := range df.AP {
+		if err := VerifyAuthPath(req.Username, key, ap, strs[i]); err != nil {
+			return err
+		}
+	}
+	return cc.verifyTransition(req.Username, key, df.Transition, strs)
+}
+
+// verifyTransition verifies the proof t that uname was first included
+// in the epoch t.Epoch, where strs are the verified STRs of the
+// monitored range, and checks it against the TB issued for uname,
+// if any. t may be nil if the range doesn't include the epoch in which
+// uname was first included. If the TB's promise has been kept,
+// verifyTransition() moves the binding for uname into the Included
+// state.
+func (cc *ConsistencyChecks) verifyTransition(uname string, key []byte,
+	t *protocol.TransitionProof, strs []*protocol.DirSTR) error {
+	tb := cc.TBs[uname]
+	first, last := strs[0].Epoch, strs[len(strs)-1].Epoch
+	if t == nil {
+		if tb != nil && tb.InclusionEpoch >= first && tb.InclusionEpoch <= last {
+			return protocol.CheckBrokenPromise
+		}
+		return nil
+	}
+	if t.Epoch == 0 || t.Epoch < first || t.Epoch > last ||
+		t.STR == nil || t.STR.SignedTreeRoot == nil ||
+		t.STR.Epoch+1 != t.Epoch ||
+		t.Absence == nil || t.Absence.Leaf == nil ||
+		t.Inclusion == nil || t.Inclusion.Leaf == nil ||
+		t.Absence.ProofType() != merkletree.ProofOfAbsence ||
+		t.Inclusion.ProofType() != merkletree.ProofOfInclusion {
+		return protocol.ErrMalformedMessage
+	}
+	str := strs[t.Epoch-first]
+	if !str.VerifyHashChain(t.STR) {
+		return protocol.CheckBadSTR
+	}
+	if !cc.VerifySignature(t.STR) {
+		return protocol.CheckBadSignature
+	}
+	if err := VerifyAuthPath(uname, key, t.Absence, t.STR); err != nil {
+		return err
+	}
+	if err := VerifyAuthPath(uname, key, t.Inclusion, str); err != nil {
+		return err
+	}
+
+	if tb == nil {
+		return nil
+	}
+	if tb.InclusionEpoch != t.Epoch ||
+		!bytes.Equal(t.Inclusion.LookupIndex, tb.Index) ||
+		!bytes.Equal(t.Inclusion.Leaf.Value, tb.Value) {
+		return protocol.CheckBrokenPromise
+	}
+	cc.Bindings[uname] = tb.Value
+	delete(cc.TBs, uname)
+	cc.transition(uname, Included, t.Epoch)
+	return nil
+}
+
+// Monitor monitors the binding of req.Username to key for the whole
+// epoch range of req, following the continuations of the directory's
+// partial responses (see protocol.Continuation). fetch sends
+// a monitoring request to the directory and returns its response.
+// Monitor() verifies each response with HandleMonitoringResponse(),
+// and passes it the STRs in known for the remaining range.
+// It returns an ErrMalformedMessage if a continuation doesn't continue
+// the range right after the response's last epoch.
+// The ConsistencyChecks aren't locked while fetch runs.
+func (cc *ConsistencyChecks) Monitor(req *protocol.MonitoringRequest, key []byte,
+	known []*protocol.DirSTR,
+	fetch func(*protocol.MonitoringRequest) (*protocol.Response, error)) error {
+	r := *req
+	for {
+		msg, err := fetch(&r)
+		if err != nil {
+			return err
+		}
+		if err := cc.HandleMonitoringResponse(&r, msg, key, known); err != nil {
+			return err
+		}
+		df := msg.DirectoryProof()
+		if df.Continuation == nil {
+			return nil
+		}
+		next := df.Continuation.NextEpoch
+		if next != r.StartEpoch+uint64(len(df.AP)) || next > r.EndEpoch {
+			return protocol.ErrMalformedMessage
+		}
+		if n := next - r.StartEpoch; n < uint64(len(known)) {
+			known = known[n:]
+		} else {
+			known = nil
+		}
+		if r.KnownEpoch < next {
+			r.KnownEpoch = 0
+		}
+		r.StartEpoch = next
+	}
+}
+
+// stitchSTRs returns the STR for each authentication path in df,
+// taking the STRs the directory has omitted from known.
+func stitchSTRs(req *protocol.MonitoringRequest, df *protocol.DirectoryProof,
+	known []*protocol.DirSTR) ([]*protocol.DirSTR, error) {
+	omitted := uint64(len(df.AP) - len(df.STR))
+	if len(df.STR) > len(df.AP) || omitted > uint64(len(known)) {
+		return nil, protocol.ErrMalformedMessage
+	}
+	strs := append(append([]*protocol.DirSTR{}, known[:omitted]...), df.STR...)
+	for i, str := range strs {
+		if str == nil || str.Epoch != req.StartEpoch+uint64(i) {
+			return nil, protocol.ErrMalformedMessage
+		}
+	}
+	// the directory only omits STRs up to the known epoch
+	if omitted > 0 && strs[omitted-1].Epoch > req.KnownEpoch {
+		return nil, protocol.ErrMalformedMessage
+	}
+	return strs, nil
+}
+
+// auditSTRRange verifies the hash chain of the range of STRs strs, and
+// checks that the range either includes cc.VerifiedSTR(), directly
+// follows it, or passes through a newer checkpoint (see
+// PinCheckpoints()), and that it doesn't conflict with any checkpoint.
+// If the checks pass, auditSTRRange() updates the verified STR to the
+// latest STR in strs.
+func (cc *ConsistencyChecks) auditSTRRange(strs []*protocol.DirSTR) error {
+	if err := cc.VerifySTRRange(strs[0], strs[1:]); err != nil {
+		return err
+	}
+	for _, str := range strs {
+		if err := cc.checkPinned(str); err != nil {
+			return err
+		}
+	}
+	verified := cc.VerifiedSTR().Epoch
+	first := strs[0].Epoch
+	last := strs[len(strs)-1]
+	switch {
+	case verified >= first && verified <= last.Epoch:
+		if err := cc.CheckSTRAgainstVerified(strs[verified-first]); err != nil {
+			return err
+		}
+	case first == verified+1:
+		if err := cc.CheckSTRAgainstVerified(strs[0]); err != nil {
+			return err
+		}
+	case cc.passesCheckpoint(strs):
+		// the range is anchored by the checkpoint, which commits to
+		// the first STR's signature but not to its content
+		if !cc.VerifySignature(strs[0]) {
+			return protocol.CheckBadSignature
+		}
+	default:
+		return protocol.CheckBadSTR
+	}
+	if last.Epoch > verified {
+		cc.updateVerifiedSTR(last)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestHandleMonitoringResponse(t *testing.T) {
+	d, cc := newTestClient(t)
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+	// the STRs of epochs [1, 3] the client has verified via auditing
+	hist := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 1, EndEpoch: 3})
+	known := hist.STRHistoryRange().STR
+
+	req := &protocol.MonitoringRequest{
+		Username:   alice,
+		StartEpoch: 1,
+		EndEpoch:   4,
+		KnownEpoch: 3,
+	}
+	res = d.Monitor(req)
+	if n := len(res.DirectoryProof().STR); n != 1 {
+		t.Fatal("Expect", 1, "STR in the response, got", n)
+	}
+	if err := cc.HandleMonitoringResponse(req, res, key, known[:1]); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+
+	// a tampered known STR breaks the hash chain
+	forged := *known[1]
+	forgedSTR := *forged.SignedTreeRoot
+	forgedSTR.TreeHash = append([]byte{}, forgedSTR.TreeHash...)
+	forgedSTR.TreeHash[0]++
+	forged.SignedTreeRoot = &forgedSTR
+	bad := []*protocol.DirSTR{known[0], &forged, known[2]}
+	if err := cc.HandleMonitoringResponse(req, res, key, bad); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+
+	if err := cc.HandleMonitoringResponse(req, res, key, known); err != nil {
+		t.Fatal(err)
+	}
+	if ep := cc.VerifiedSTR().Epoch; ep != 4 {
+		t.Fatal("Expect verified epoch", 4, "got", ep)
+	}
+
+	// without known STRs, the directory returns all STRs
+	d.Update()
+	req = &protocol.MonitoringRequest{
+		Username:   alice,
+		StartEpoch: 4,
+		EndEpoch:   5,
+	}
+	if err := cc.HandleMonitoringResponse(req, d.Monitor(req), key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ep := cc.VerifiedSTR().Epoch; ep != 5 {
+		t.Fatal("Expect verified epoch", 5, "got", ep)
+	}
+}
+
+func TestMonitorContinuation(t *testing.T) {
+	d := directory.New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 100, true)
+	d.Update()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(d.LatestSTR(), true, pk)
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		d.Update()
+	}
+
+	fetches := 0
+	req := &protocol.MonitoringRequest{Username: alice, StartEpoch: 2, EndEpoch: 31}
+	err := cc.Monitor(req, key, nil, func(r *protocol.MonitoringRequest) (*protocol.Response, error) {
+		fetches++
+		return d.Monitor(r), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches < 2 {
+		t.Fatal("Expect the range to span several responses, got", fetches)
+	}
+	if ep := cc.VerifiedSTR().Epoch; ep != 31 {
+		t.Fatal("Expect verified epoch", 31, "got", ep)
+	}
+
+	// a continuation must continue the range
+	d.Update()
+	req = &protocol.MonitoringRequest{Username: alice, StartEpoch: 31, EndEpoch: 32}
+	err = cc.Monitor(req, key, nil, func(r *protocol.MonitoringRequest) (*protocol.Response, error) {
+		res := d.Monitor(r)
+		res.DirectoryProof().Continuation =
+			&protocol.Continuation{NextEpoch: r.StartEpoch}
+		return res, nil
+	})
+	if err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
+
+func TestMonitoringClosesPromise(t *testing.T) {
+	d, cc := newTestClient(t)
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if cc.State(alice) != Promised {
+		t.Fatal("Expect", Promised, "got", cc.State(alice))
+	}
+	d.Update()
+	d.Update()
+
+	// the first monitoring call after the registration
+	req := &protocol.MonitoringRequest{Username: alice, StartEpoch: 2, EndEpoch: 3}
+	monitor := func(tamper func(*protocol.TransitionProof) *protocol.TransitionProof) error {
+		res := d.Monitor(req)
+		df := res.DirectoryProof()
+		df.Transition = tamper(df.Transition)
+		return cc.HandleMonitoringResponse(req, res, key, nil)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(*protocol.TransitionProof) *protocol.TransitionProof
+		want   error
+	}{
+		{"missing transition", func(*protocol.TransitionProof) *protocol.TransitionProof {
+			return nil
+		}, protocol.CheckBrokenPromise},
+		{"wrong epoch", func(tr *protocol.TransitionProof) *protocol.TransitionProof {
+			tr.Epoch = 3
+			return tr
+		}, protocol.ErrMalformedMessage},
+		{"absence as inclusion", func(tr *protocol.TransitionProof) *protocol.TransitionProof {
+			tr.Absence = tr.Inclusion
+			return tr
+		}, protocol.ErrMalformedMessage},
+		{"forged STR", func(tr *protocol.TransitionProof) *protocol.TransitionProof {
+			forged := *tr.STR
+			forgedSTR := *forged.SignedTreeRoot
+			forgedSTR.TreeHash = append([]byte{}, forgedSTR.TreeHash...)
+			forgedSTR.TreeHash[0]++
+			forged.SignedTreeRoot = &forgedSTR
+			tr.STR = &forged
+			return tr
+		}, protocol.CheckBadSignature},
+		{"unchained STR", func(tr *protocol.TransitionProof) *protocol.TransitionProof {
+			forged := *tr.STR
+			forgedSTR := *forged.SignedTreeRoot
+			forgedSTR.Signature = append([]byte{}, forgedSTR.Signature...)
+			forgedSTR.Signature[0]++
+			forged.SignedTreeRoot = &forgedSTR
+			tr.STR = &forged
+			return tr
+		}, protocol.CheckBadSTR},
+	} {
+		if err := monitor(tc.tamper); err != tc.want {
+			t.Fatal(tc.name, "expect", tc.want, "got", err)
+		}
+		if cc.State(alice) != Promised {
+			t.Fatal(tc.name, "expect", Promised, "got", cc.State(alice))
+		}
+	}
+
+	var transitions []*Transition
+	cc.SetTransitionHandler(func(tr *Transition) {
+		transitions = append(transitions, tr)
+	})
+	if err := monitor(func(tr *protocol.TransitionProof) *protocol.TransitionProof {
+		return tr
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cc.State(alice) != Included || cc.TBs[alice] != nil ||
+		len(transitions) != 1 || transitions[0].Epoch != 2 {
+		t.Fatal("Expect the promise to be closed at epoch", 2)
+	}
+}
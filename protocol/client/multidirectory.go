@@ -0,0 +1,192 @@
+// Implements the tracking of several CONIKS directories by a single
+// client, e.g., a messaging client whose contacts are registered with
+// different providers, each of which runs its own directory.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+var (
+	// ErrUnknownDirectory indicates that a MultiClient doesn't track
+	// the directory with the given identity.
+	ErrUnknownDirectory = errors.New("[coniks] Unknown directory")
+	// ErrDirectoryExists indicates that a MultiClient already tracks
+	// the directory with the given identity.
+	ErrDirectoryExists = errors.New("[coniks] The directory is already tracked")
+	// ErrNoIdentity indicates that the identity of a directory isn't
+	// known, i.e., that its client was created from an STR other than
+	// the directory's initial STR, and that its identity hasn't been
+	// set (see SetDirectoryIdentity()).
+	ErrNoIdentity = errors.New("[coniks] The directory's identity is unknown")
+)
+
+// DirectoryIdentity returns the identity of the directory, i.e. the
+// hash of its initial STR, and false if the client doesn't know it
+// (see SetDirectoryIdentity()).
+func (cc *ConsistencyChecks) DirectoryIdentity() ([crypto.HashSizeByte]byte, bool) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if cc.identity == nil {
+		return [crypto.HashSizeByte]byte{}, false
+	}
+	return *cc.identity, true
+}
+
+// A MultiClient tracks several CONIKS directories, each of which is
+// identified by the hash of its initial STR
+// (see auditor.ComputeDirectoryIdentity()), rather than by an address
+// or a name which its operator may change. The client keeps
+// an independent ConsistencyChecks for each directory, with its own
+// verified STR and verified bindings, so that a response of one
+// directory is never checked against, nor affects, the state of
+// another.
+//
+// The methods of a MultiClient are safe for concurrent use, and the
+// directories' ConsistencyChecks may be used concurrently, e.g., by
+// a Monitor for each directory.
+type MultiClient struct {
+	lock sync.RWMutex
+	dirs map[[crypto.HashSizeByte]byte]*ConsistencyChecks
+}
+
+// NewMultiClient creates a MultiClient which tracks no directory.
+func NewMultiClient() *MultiClient {
+	return &MultiClient{
+		dirs: make(map[[crypto.HashSizeByte]byte]*ConsistencyChecks),
+	}
+}
+
+// AddDirectory starts tracking the directory whose pinned initial STR
+// is initSTR and whose pinned signing key is signKey, and returns its
+// identity and its new ConsistencyChecks, which the caller may
+// configure further, e.g., with SetAuditors().
+// AddDirectory() returns an ErrNoIdentity if initSTR isn't the STR of
+// epoch 0, and an ErrDirectoryExists if the directory is already
+// tracked.
+func (mc *MultiClient) AddDirectory(initSTR *protocol.DirSTR,
+	signKey sign.PublicKey) ([crypto.HashSizeByte]byte, *ConsistencyChecks, error) {
+	if initSTR.Epoch != 0 {
+		return [crypto.HashSizeByte]byte{}, nil, ErrNoIdentity
+	}
+	cc := New(initSTR, true, signKey)
+	id, err := mc.Track(cc)
+	if err != nil {
+		return id, nil, err
+	}
+	return id, cc, nil
+}
+
+// Track starts tracking the directory whose consistency state is cc,
+// e.g., a state restored with LoadState(), and returns its identity.
+// Track() returns an ErrNoIdentity if cc doesn't know the directory's
+// identity, and an ErrDirectoryExists if the directory is already
+// tracked.
+func (mc *MultiClient) Track(cc *ConsistencyChecks) ([crypto.HashSizeByte]byte, error) {
+	id, ok := cc.DirectoryIdentity()
+	if !ok {
+		return id, ErrNoIdentity
+	}
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if _, ok := mc.dirs[id]; ok {
+		return id, ErrDirectoryExists
+	}
+	mc.dirs[id] = cc
+	return id, nil
+}
+
+// Untrack stops tracking the directory with identity id, if any.
+func (mc *MultiClient) Untrack(id [crypto.HashSizeByte]byte) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	delete(mc.dirs, id)
+}
+
+// Directory returns the ConsistencyChecks of the directory with
+// identity id, or nil if the directory isn't tracked.
+func (mc *MultiClient) Directory(id [crypto.HashSizeByte]byte) *ConsistencyChecks {
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+	return mc.dirs[id]
+}
+
+// Directories returns the identities of the tracked directories,
+// in increasing order.
+func (mc *MultiClient) Directories() [][crypto.HashSizeByte]byte {
+	mc.lock.RLock()
+	ids := make([][crypto.HashSizeByte]byte, 0, len(mc.dirs))
+	for id := range mc.dirs {
+		ids = append(ids, id)
+	}
+	mc.lock.RUnlock()
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	return ids
+}
+
+// HandleResponse verifies the response msg of the directory with
+// identity id against the directory's consistency state
+// (see ConsistencyChecks.HandleResponse()). It returns an
+// ErrUnknownDirectory if the directory isn't tracked.
+func (mc *MultiClient) HandleResponse(id [crypto.HashSizeByte]byte, requestType int,
+	msg *protocol.Response, uname string, key []byte) error {
+	cc := mc.Directory(id)
+	if cc == nil {
+		return ErrUnknownDirectory
+	}
+	return cc.HandleResponse(requestType, msg, uname, key)
+}
+
+// HandleMonitoringResponse verifies the monitoring response msg of the
+// directory with identity id against the directory's consistency state
+// (see ConsistencyChecks.HandleMonitoringResponse()). It returns an
+// ErrUnknownDirectory if the directory isn't tracked.
+func (mc *MultiClient) HandleMonitoringResponse(id [crypto.HashSizeByte]byte,
+	req *protocol.MonitoringRequest, msg *protocol.Response, key []byte,
+	known []*protocol.DirSTR) error {
+	cc := mc.Directory(id)
+	if cc == nil {
+		return ErrUnknownDirectory
+	}
+	return cc.HandleMonitoringResponse(req, msg, key, known)
+}
+
+// Bindings returns the key bound to uname in each tracked directory in
+// which the client has verified a binding for uname (see
+// ConsistencyChecks.Binding()), indexed by the directory's identity.
+// The same username may be bound to different keys in different
+// directories, which don't share a namespace.
+func (mc *MultiClient) Bindings(uname string) map[[crypto.HashSizeByte]byte][]byte {
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+	keys := make(map[[crypto.HashSizeByte]byte][]byte)
+	for id, cc := range mc.dirs {
+		if key, ok := cc.Binding(uname); ok {
+			keys[id] = key
+		}
+	}
+	return keys
+}
+
+// Stale returns the identities of the tracked directories whose
+// verified STR is stale (see ConsistencyChecks.Stale()), in increasing
+// order, so that the client can fetch their latest STRs.
+func (mc *MultiClient) Stale() [][crypto.HashSizeByte]byte {
+	var stale [][crypto.HashSizeByte]byte
+	for _, id := range mc.Directories() {
+		if cc := mc.Directory(id); cc != nil && cc.Stale() {
+			stale = append(stale, id)
+		}
+	}
+	return stale
+}
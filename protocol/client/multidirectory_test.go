@@ -0,0 +1,96 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// newIndependentDirectory creates a directory with its own signing key.
+// Unlike directory.NewTestDirectory(), whose directories all share the
+// same static initial STR, each directory has its own identity, and
+// its initial STR commits to its tree.
+func newIndependentDirectory(t *testing.T) (*directory.ConiksDirectory, sign.PublicKey) {
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := signKey.Public()
+	return directory.New(1, crypto.NewStaticTestVRFKey(), signKey, 10, true), pk
+}
+
+func TestMultiClient(t *testing.T) {
+	mc := NewMultiClient()
+	d1, pk1 := newIndependentDirectory(t)
+	d2, pk2 := newIndependentDirectory(t)
+	id1, _, err := mc.AddDirectory(d1.LatestSTR(), pk1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, cc2, err := mc.AddDirectory(d2.LatestSTR(), pk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id2 {
+		t.Fatal("Expect different identities for different directories")
+	}
+	if _, _, err := mc.AddDirectory(d1.LatestSTR(), pk1); err != ErrDirectoryExists {
+		t.Fatal("Expect", ErrDirectoryExists, "got", err)
+	}
+	if ids := mc.Directories(); len(ids) != 2 {
+		t.Fatal("Expect 2 directories, got", len(ids))
+	}
+
+	// the same name is bound to different keys in each directory
+	key1, key2 := []byte("key1"), []byte("key2")
+	res := d1.Register(&protocol.RegistrationRequest{Username: alice, Key: key1})
+	if err := mc.HandleResponse(id1, protocol.RegistrationType, res, alice, key1); err != nil {
+		t.Fatal(err)
+	}
+	res = d2.Register(&protocol.RegistrationRequest{Username: alice, Key: key2})
+	if err := mc.HandleResponse(id2, protocol.RegistrationType, res, alice, key2); err != nil {
+		t.Fatal(err)
+	}
+	d1.Update()
+	res = d1.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := mc.HandleResponse(id1, protocol.KeyLookupType, res, alice, key1); err != nil {
+		t.Fatal(err)
+	}
+	keys := mc.Bindings(alice)
+	if len(keys) != 2 || string(keys[id1]) != string(key1) ||
+		string(keys[id2]) != string(key2) {
+		t.Fatal("Expect a binding of", alice, "in each directory, got", keys)
+	}
+
+	// the verified STRs are independent
+	if epoch := mc.Directory(id1).VerifiedSTR().Epoch; epoch != 1 {
+		t.Fatal("Expect epoch 1, got", epoch)
+	}
+	if epoch := cc2.VerifiedSTR().Epoch; epoch != 0 {
+		t.Fatal("Expect epoch 0, got", epoch)
+	}
+	// a response of a directory fails the checks of another
+	if err := mc.HandleResponse(id2, protocol.KeyLookupType, res, alice, key1); err == nil {
+		t.Fatal("Expect the response of", id1, "to fail the checks of", id2)
+	}
+
+	mc.Untrack(id2)
+	if err := mc.HandleResponse(id2, protocol.KeyLookupType, res, alice, key2); err != ErrUnknownDirectory {
+		t.Fatal("Expect", ErrUnknownDirectory, "got", err)
+	}
+	if _, err := mc.Track(cc2); err != nil {
+		t.Fatal(err)
+	}
+
+	// a client created from a later STR has no identity
+	d1.Update()
+	if _, _, err := NewMultiClient().AddDirectory(d1.LatestSTR(), pk1); err != ErrNoIdentity {
+		t.Fatal("Expect", ErrNoIdentity, "got", err)
+	}
+	if _, err := NewMultiClient().Track(New(d1.LatestSTR(), true, pk1)); err != ErrNoIdentity {
+		t.Fatal("Expect", ErrNoIdentity, "got", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Implements the persistence of a CONIKS client's consistency state,
+// so that a client keeps verifying the directory's hash chain and its
+// users' bindings across restarts.
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// stateVersion is the version of the format of the saved consistency
+// states, which LoadState() checks.
+const stateVersion = 1
+
+// ErrMalformedState indicates that a saved consistency state can't be
+// restored: it is malformed, it was saved in an unknown format, or its
+// verified STR isn't signed by the directory's signing key.
+var ErrMalformedState = errors.New("[coniks] Malformed or foreign consistency state")
+
+// savedState is the format of a saved consistency state.
+// It includes the verified STR and the time it was verified at, the
+// cross-signed STRs declaring the rotations of the directory's signing
+// key the client has verified, the directory's identity, if known, and the state of each binding.
+// The handlers, the clock and the strict mode settings are part of the
+// client's configuration, and aren't saved.
+type savedState struct {
+	Version     int
+	STR         *protocol.DirSTR
+	VerifiedAt  time.Time
+	Handovers   []*protocol.DirSTR         `json:",omitempty"`
+	Identity    *[crypto.HashSizeByte]byte `json:",omitempty"`
+	Bindings    map[string][]byte
+	States      map[string]BindingState
+	TBs         map[string]*protocol.TemporaryBinding `json:",omitempty"`
+	Changes     map[string]*savedChange               `json:",omitempty"`
+	Unconfirmed map[string]*savedRegistration         `json:",omitempty"`
+}
+
+// savedChange is the format of a saved pendingChange.
+type savedChange struct {
+	TB      *protocol.TemporaryBinding
+	Aborted bool
+}
+
+// savedRegistration is the format of a saved unconfirmedRegistration.
+type savedRegistration struct {
+	DF       *protocol.DirectoryProof
+	Next     BindingState
+	Deadline time.Time
+}
+
+// SaveState writes the consistency state of cc to the file at path,
+// replacing the previous state atomically, so that a crash never
+// leaves a partial state. The client can be restored from this file
+// with LoadState().
+func SaveState(path string, cc *ConsistencyChecks) error {
+	cc.lock.Lock()
+	s := &savedState{
+		Version:     stateVersion,
+		STR:         cc.VerifiedSTR(),
+		VerifiedAt:  cc.verifiedAt,
+		Handovers:   cc.HandoverSTRs(),
+		Identity:    cc.identity,
+		Bindings:    cc.Bindings,
+		States:      cc.states,
+		TBs:         cc.TBs,
+		Changes:     make(map[string]*savedChange, len(cc.changes)),
+		Unconfirmed: make(map[string]*savedRegistration, len(cc.unconfirmed)),
+	}
+	for name, c := range cc.changes {
+		s.Changes[name] = &savedChange{TB: c.tb, Aborted: c.aborted}
+	}
+	for name, r := range cc.unconfirmed {
+		s.Unconfirmed[name] = &savedRegistration{DF: r.df, Next: r.next,
+			Deadline: r.deadline}
+	}
+	buf, err := json.Marshal(s)
+	cc.unlock()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadState restores a client from the consistency state saved at path
+// by SaveState(), using the directory's pinned signing key signKey,
+// along with the keys the directory has handed the signing over to
+// since then. Each saved handover STR is verified again, starting from
+// signKey (see auditor.AudState.RestoreHandover()), so that the saved
+// state can't install a signing key the directory hasn't handed over to.
+// It returns an error satisfying os.IsNotExist() if there is no saved
+// state, in which case the client should be created with New(), or
+// ErrMalformedState if the saved state can't be restored.
+// The restored client uses the real clock and no strict mode nor event
+// handlers, which the caller sets as it does for a new client.
+func LoadState(path string, useTBs bool, signKey sign.PublicKey) (*ConsistencyChecks, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s savedState
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, ErrMalformedState
+	}
+	if s.Version != stateVersion || s.STR == nil ||
+		s.STR.SignedTreeRoot == nil || s.STR.Policies == nil {
+		return nil, ErrMalformedState
+	}
+
+	cc := New(s.STR, useTBs, signKey)
+	for _, str := range s.Handovers {
+		if err := cc.RestoreHandover(str); err != nil {
+			return nil, ErrMalformedState
+		}
+	}
+	if !cc.VerifySignature(s.STR) || s.STR.VerifyHandover() != nil {
+		return nil, ErrMalformedState
+	}
+	cc.verifiedAt = s.VerifiedAt
+	if s.Identity != nil {
+		cc.identity = s.Identity
+	}
+	for name, key := range s.Bindings {
+		cc.Bindings[name] = key
+	}
+	for name, state := range s.States {
+		cc.states[name] = state
+	}
+	if useTBs {
+		for name, tb := range s.TBs {
+			cc.TBs[name] = tb
+		}
+	}
+	for name, c := range s.Changes {
+		cc.changes[name] = &pendingChange{tb: c.TB, aborted: c.Aborted}
+	}
+	for name, r := range s.Unconfirmed {
+		cc.unconfirmed[name] = &unconfirmedRegistration{df: r.DF,
+			next: r.Next, deadline: r.Deadline}
+	}
+	return cc, nil
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func withStateFile(t *testing.T, f func(file string)) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f(path.Join(dir, "client.state"))
+}
+
+func TestSaveAndLoadState(t *testing.T) {
+	withStateFile(t, func(file string) {
+		d, cc := newTestClient(t)
+		res := d.Register(&protocol.RegistrationRequest{
+			Username: alice,
+			Key:      key,
+		})
+		if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := SaveState(file, cc); err != nil {
+			t.Fatal(err)
+		}
+
+		pk, _ := crypto.NewStaticTestSigningKey().Public()
+		restored, err := LoadState(file, true, pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.VerifiedSTR().Epoch != cc.VerifiedSTR().Epoch {
+			t.Fatal("Expect", cc.VerifiedSTR().Epoch, "got", restored.VerifiedSTR().Epoch)
+		}
+		if got := restored.State(alice); got != Promised {
+			t.Fatal("Expect", Promised, "got", got)
+		}
+		if tb := restored.TB(alice); tb == nil || !bytes.Equal(tb.Signature, cc.TB(alice).Signature) {
+			t.Fatal("Expect the TB to be restored")
+		}
+
+		// the restored client keeps verifying the hash chain and the TB
+		d.Update()
+		res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := restored.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.State(alice); got != Included {
+			t.Fatal("Expect", Included, "got", got)
+		}
+		if got, _ := restored.Binding(alice); !bytes.Equal(got, key) {
+			t.Fatal("Expect", key, "got", got)
+		}
+	})
+}
+
+func TestSaveAndLoadStateAfterRotation(t *testing.T) {
+	withStateFile(t, func(file string) {
+		d, cc := newTestClient(t)
+		cc.SetReferenceCheck(func(dv *Divergence) {
+			t.Error("Unexpected divergence", dv)
+		})
+		res := d.Register(&protocol.RegistrationRequest{
+			Username: alice,
+			Key:      key,
+		})
+		if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+			t.Fatal(err)
+		}
+
+		sk, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newPK, _ := sk.Public()
+		if err := d.RotateSigningKey(sk); err != nil {
+			t.Fatal(err)
+		}
+		// the client learns the new key from the cross-signed STR
+		for e := 0; e < 3; e++ {
+			d.Update()
+			res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+			if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(cc.SigningKey(), newPK) {
+			t.Fatal("Expect the new signing key")
+		}
+		// the promises are signed with the new key as well
+		res = d.Register(&protocol.RegistrationRequest{
+			Username: "bob",
+			Key:      key,
+		})
+		if err := cc.HandleResponse(protocol.RegistrationType, res, "bob", key); err != nil {
+			t.Fatal(err)
+		}
+		if err := SaveState(file, cc); err != nil {
+			t.Fatal(err)
+		}
+
+		// the client is restored with the pinned key
+		pk, _ := crypto.NewStaticTestSigningKey().Public()
+		restored, err := LoadState(file, true, pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Update()
+		res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "bob"})
+		if err := restored.HandleResponse(protocol.KeyLookupType, res, "bob", key); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.State("bob"); got != Included {
+			t.Fatal("Expect", Included, "got", got)
+		}
+
+		// a handover which the directory hasn't signed is rejected
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s savedState
+		if err := json.Unmarshal(buf, &s); err != nil {
+			t.Fatal(err)
+		}
+		forged, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forgedPK, _ := forged.Public()
+		str := *s.Handovers[0].SignedTreeRoot
+		policies := *s.Handovers[0].Policies
+		policies.NextSigningKey = forgedPK
+		handover := &protocol.DirSTR{SignedTreeRoot: &str, Policies: &policies}
+		str.CrossSignature = forged.Sign(handover.Serialize())
+		s.Handovers[0] = handover
+		if buf, err = json.Marshal(&s); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, buf, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadState(file, true, pk); err != ErrMalformedState {
+			t.Fatal("Expect", ErrMalformedState, "got", err)
+		}
+	})
+}
+
+func TestLoadStateRejectsForeignState(t *testing.T) {
+	withStateFile(t, func(file string) {
+		pk, _ := crypto.NewStaticTestSigningKey().Public()
+		if _, err := LoadState(file, true, pk); !os.IsNotExist(err) {
+			t.Fatal("Expect a missing state, got", err)
+		}
+
+		_, cc := newTestClient(t)
+		if err := SaveState(file, cc); err != nil {
+			t.Fatal(err)
+		}
+		sk, err := sign.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherPK, _ := sk.Public()
+		if _, err := LoadState(file, true, otherPK); err != ErrMalformedState {
+			t.Fatal("Expect", ErrMalformedState, "got", err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadState(file, true, pk); err != ErrMalformedState {
+			t.Fatal("Expect", ErrMalformedState, "got", err)
+		}
+	})
+}
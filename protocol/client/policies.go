@@ -0,0 +1,34 @@
+// Implements the verification of a CONIKS directory's policy document.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// HandlePoliciesResponse verifies the directory's response msg to
+// a policies request, and returns the directory's policy document.
+// As for HandleResponse(), the verified STR is updated as soon as the
+// STR in msg passes the non-equivocation checks.
+// HandlePoliciesResponse() then verifies the directory's signature of
+// the document, and that the STR commits to the document
+// (see protocol.SignedPolicyDocument.Decode()).
+func (cc *ConsistencyChecks) HandlePoliciesResponse(msg *protocol.Response) (*protocol.PolicyDocument, error) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	p := msg.PolicyDocumentProof()
+	if p == nil {
+		return nil, protocol.ErrMalformedMessage
+	}
+	if err := cc.AuditDirectory([]*protocol.DirSTR{p.STR}); err != nil {
+		return nil, err
+	}
+	cc.updateVerifiedSTR(p.STR)
+	if !cc.Verify(p.Document.Serialize(), p.Document.Signature) {
+		return nil, protocol.CheckBadSignature
+	}
+	return p.Document.Decode(p.STR)
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestHandlePoliciesResponse(t *testing.T) {
+	d, cc := newTestClient(t)
+	d.PublishPolicyDocument(false)
+	if _, err := cc.HandlePoliciesResponse(d.GetPolicies(&protocol.PoliciesRequest{})); err != protocol.ReqNoPolicyDocument {
+		t.Fatal("Expect", protocol.ReqNoPolicyDocument, "got", err)
+	}
+
+	d.Update()
+	res := d.GetPolicies(&protocol.PoliciesRequest{})
+	doc, err := cc.HandlePoliciesResponse(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.EpochDeadline != d.EpochDeadline() {
+		t.Fatal("Expect", d.EpochDeadline(), "got", doc.EpochDeadline)
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the verified STR to be updated")
+	}
+
+	p := res.PolicyDocumentProof()
+	tampered := *p.Document
+	tampered.Document = append([]byte{}, p.Document.Document...)
+	tampered.Document[len(tampered.Document)-2]++
+	p.Document = &tampered
+	if _, err := cc.HandlePoliciesResponse(res); err != protocol.CheckBadSignature {
+		t.Fatal("Expect", protocol.CheckBadSignature, "got", err)
+	}
+}
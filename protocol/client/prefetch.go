@@ -0,0 +1,87 @@
+// Implements the prefetching of the bindings of many names, e.g.
+// a user's contact list when a messaging application starts.
+
+package client
+
+import (
+	"sync"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// DefaultPrefetchParallelism is the number of lookups
+// PrefetchBindings() keeps in flight if its parallelism isn't positive.
+const DefaultPrefetchParallelism = 8
+
+// PrefetchBindings looks up the binding of each name in names, e.g.
+// the user's contact list at the start of a session, so that the
+// client has verified them before the user needs them. fetch sends
+// a key lookup request to the directory and returns its response.
+//
+// Up to parallelism lookups are in flight at once, and the
+// authentication paths of their responses are verified concurrently.
+// Each response is then checked as HandleResponse() checks a key
+// lookup response, against the key the client has already verified
+// for the name, if any; these checks are serialized since they update
+// the verified STR and the verified bindings (see Binding()).
+//
+// PrefetchBindings() returns the result for each name whose lookup
+// failed: the error of fetch, or the consistency check error of its
+// response. Note that a response whose STR is older than the verified
+// STR fails with a CheckBadSTR, e.g., if the directory issued a new
+// STR during the prefetch, in which case the lookup can be retried.
+func (cc *ConsistencyChecks) PrefetchBindings(names []string, parallelism int,
+	fetch func(*protocol.KeyLookupRequest) (*protocol.Response, error)) map[string]error {
+	if parallelism <= 0 {
+		parallelism = DefaultPrefetchParallelism
+	}
+	var lock sync.Mutex
+	results := make(map[string]error)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for _, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := cc.prefetchBinding(name, fetch); err != nil {
+				lock.Lock()
+				results[name] = err
+				lock.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	return results
+}
+
+// prefetchBinding looks up the binding of name with fetch, verifies
+// the authentication path of the response without holding the
+// ConsistencyChecks, and then checks the response.
+func (cc *ConsistencyChecks) prefetchBinding(name string,
+	fetch func(*protocol.KeyLookupRequest) (*protocol.Response, error)) error {
+	msg, err := fetch(&protocol.KeyLookupRequest{Username: name})
+	if err != nil {
+		return err
+	}
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	df := msg.DirectoryProof()
+	if df == nil {
+		return protocol.ErrMalformedMessage
+	}
+	key, _ := cc.Binding(name)
+	if err := VerifyAuthPath(name, key, df.AP[0], df.STR[0]); err != nil {
+		return err
+	}
+
+	cc.lock.Lock()
+	defer cc.unlock()
+	// the binding may have been verified in the meantime
+	if k := cc.Bindings[name]; !sameKey(k, key) {
+		return cc.handleResponse(protocol.KeyLookupType, msg, name, k, false)
+	}
+	return cc.handleResponse(protocol.KeyLookupType, msg, name, key, true)
+}
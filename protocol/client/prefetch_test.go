@@ -0,0 +1,61 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+func TestPrefetchBindings(t *testing.T) {
+	d, cc := newTestClient(t)
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("contact%d", i)
+		names = append(names, name)
+		d.Register(&protocol.RegistrationRequest{
+			Username: name,
+			Key:      []byte(name),
+		})
+	}
+	d.Update()
+
+	errFetch := errors.New("unreachable directory")
+	unregistered, failed := "stranger", "offline"
+	names = append(names, unregistered, failed)
+	results := cc.PrefetchBindings(names, 4,
+		func(req *protocol.KeyLookupRequest) (*protocol.Response, error) {
+			if req.Username == failed {
+				return nil, errFetch
+			}
+			return d.KeyLookup(req), nil
+		})
+	if len(results) != 1 || results[failed] != errFetch {
+		t.Fatal("Expect only", failed, "to fail, got", results)
+	}
+	for _, name := range names[:20] {
+		if key, ok := cc.Binding(name); !ok || string(key) != name {
+			t.Error(name, "expect", name, "got", string(key))
+		}
+	}
+	if _, ok := cc.Binding(unregistered); ok {
+		t.Error("Expect no binding for", unregistered)
+	}
+	if cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the verified STR to be updated")
+	}
+
+	// prefetching again checks the responses against the verified bindings
+	d.Update()
+	results = cc.PrefetchBindings(names[:20], 0, func(req *protocol.KeyLookupRequest) (*protocol.Response, error) {
+		res := d.KeyLookup(req)
+		if req.Username == names[0] {
+			res.DirectoryProof().AP[0].Leaf.Value = []byte("forged")
+		}
+		return res, nil
+	})
+	if len(results) != 1 || results[names[0]] != protocol.CheckBindingsDiffer {
+		t.Fatal("Expect", protocol.CheckBindingsDiffer, "for", names[0], "got", results)
+	}
+}
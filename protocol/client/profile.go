@@ -0,0 +1,35 @@
+// Implements the helpers with which a client reads the profiles
+// (see profile.Profile) bound to the usernames it has verified.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol/profile"
+)
+
+// Profile returns the profile bound to uname which the client has
+// verified (see Binding()), once it has checked that the profile is
+// signed by its master key and hasn't expired according to the
+// client's clock (see profile.Profile.Verify()), so that the caller
+// can use the keys of its devices.
+// Profile() returns nil and no error if the client hasn't verified
+// a binding for uname, a profile.ErrNotProfile if uname is bound to
+// an opaque key, and the errors of profile.Parse() and
+// profile.Profile.Verify() otherwise.
+func (cc *ConsistencyChecks) Profile(uname string) (*profile.Profile, error) {
+	key, ok := cc.Binding(uname)
+	if !ok {
+		return nil, nil
+	}
+	p, err := profile.Parse(key)
+	if err != nil {
+		return nil, err
+	}
+	cc.lock.Lock()
+	now := cc.clock.Now()
+	cc.unlock()
+	if err := p.Verify(now); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
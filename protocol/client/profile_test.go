@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/profile"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func TestProfile(t *testing.T) {
+	d, cc := newTestClient(t)
+	clock := utils.NewFakeClock(time.Now())
+	cc.SetClock(clock)
+	masterKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.New(masterKey, []*profile.Device{
+		{ID: "phone", Key: []byte("phone key")},
+	}, clock.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := cc.Profile(alice); p != nil || err != nil {
+		t.Fatal("Expect no profile, got", p, err)
+	}
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: p.Marshal()})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, p.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := cc.Profile(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev := verified.Device("phone"); dev == nil || string(dev.Key) != "phone key" {
+		t.Fatal("Expect the phone's key, got", dev)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if _, err := cc.Profile(alice); err != profile.ErrExpired {
+		t.Fatal("Expect", profile.ErrExpired, "got", err)
+	}
+
+	res = d.Register(&protocol.RegistrationRequest{Username: "bob", Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, "bob", key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.Profile("bob"); err != profile.ErrNotProfile {
+		t.Fatal("Expect", profile.ErrNotProfile, "got", err)
+	}
+}
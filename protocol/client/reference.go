@@ -0,0 +1,298 @@
+// Implements a reference verifier of the directory's responses, which
+// recomputes the STRs' signatures and hash chain, the authentication
+// paths' roots and the promises' signatures in the most straightforward
+// way, without any of the client's optimizations, and the differential
+// test mode which runs it alongside the client's checks.
+
+package client
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// A Divergence is reported in the differential test mode (see
+// SetReferenceCheck()) when the client's checks and the reference
+// verifier (see ReferenceVerify()) disagree on the response to
+// a request of type RequestType for Username: Checks is the result of
+// the client's checks and Reference the result of the reference
+// verifier. Epoch is the epoch of the response's STR.
+type Divergence struct {
+	RequestType int
+	Username    string
+	Epoch       uint64
+	Checks      error
+	Reference   error
+}
+
+// referenceChecks contains the errors of the client's checks which the
+// reference verifier also checks for. The client's checks rejecting
+// a response with any other error, e.g., a CheckBrokenPromise which
+// depends on the client's state, isn't a divergence.
+var referenceChecks = map[error]bool{
+	protocol.CheckBadSignature:   true,
+	protocol.CheckBadVRFProof:    true,
+	protocol.CheckBadCommitment:  true,
+	protocol.CheckBadLookupIndex: true,
+	protocol.CheckBadAuthPath:    true,
+	protocol.CheckBadSTR:         true,
+}
+
+// SetReferenceCheck enables the differential test mode: each response
+// to a registration or key lookup is then also checked by the reference
+// verifier (see ReferenceVerify()) against the verified STR, and
+// onDivergence is called with the corresponding Divergence each time
+// the client accepts a response which the reference verifier rejects,
+// or rejects a response with one of the errors of the reference
+// verifier's checks while the reference verifier accepts it.
+// The reference verifier is slow, so the differential test mode is
+// meant for tests, e.g., to check that the client's optimizations don't
+// make it accept an invalid response.
+// Passing a nil onDivergence disables the differential test mode.
+func (cc *ConsistencyChecks) SetReferenceCheck(onDivergence func(*Divergence)) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.onDivergence = onDivergence
+}
+
+// compareWithReference checks the response msg with the reference
+// verifier against the STR savedSTR the client had verified before
+// checking msg, and the directory's signing key signKey at that time,
+// and emits a Divergence if its result differs from the result err of
+// the client's checks.
+func (cc *ConsistencyChecks) compareWithReference(requestType int,
+	msg *protocol.Response, uname string, key []byte,
+	savedSTR *protocol.DirSTR, signKey sign.PublicKey, err error) {
+	ref := ReferenceVerify(requestType, msg, uname, key, savedSTR, signKey)
+	accepted := err == nil || err == protocol.CheckUnconfirmedSTR
+	if accepted == (ref == nil) || !accepted && !referenceChecks[err] {
+		return
+	}
+	d := &Divergence{
+		RequestType: requestType,
+		Username:    uname,
+		Checks:      err,
+		Reference:   ref,
+	}
+	if df := msg.DirectoryProof(); df != nil && len(df.STR) > 0 &&
+		df.STR[0] != nil && df.STR[0].SignedTreeRoot != nil {
+		d.Epoch = df.STR[0].Epoch
+	}
+	onDivergence := cc.onDivergence
+	cc.emit(func() { onDivergence(d) })
+}
+
+// ReferenceVerify verifies the directory's response msg to
+// a registration or a key lookup for the binding of uname to key
+// against the verified STR savedSTR, as a reference for the client's
+// checks (see ConsistencyChecks.HandleResponse()). signKey is the
+// directory's signing key which signs the STR following savedSTR
+// (see auditor.AudState.SigningKey()). If key is nil, the key included
+// in msg is accepted (TOFU).
+//
+// ReferenceVerify() checks that the response's STR is savedSTR, or
+// that it is signed and extends the hash chain of savedSTR, that the
+// response's authentication path is a valid proof of inclusion or
+// absence for uname under the tree hash of this STR, and that the
+// promise returned with a proof of absence, if any, is signed and
+// promises the binding's inclusion in the next epoch. If the STR hands
+// the signing over to a new key, the promise is signed with the new
+// key, and so is the STR's cross signature. It doesn't check
+// the response against the client's previously verified bindings and
+// promises. It returns the corresponding consistency check error, or
+// an ErrMalformedMessage, if a check fails.
+func ReferenceVerify(requestType int, msg *protocol.Response, uname string,
+	key []byte, savedSTR *protocol.DirSTR, signKey sign.PublicKey) error {
+	if msg == nil {
+		return protocol.ErrMalformedMessage
+	}
+	df := msg.DirectoryProof()
+	if df == nil || len(df.STR) != 1 || len(df.AP) != 1 {
+		return protocol.ErrMalformedMessage
+	}
+	str, ap := df.STR[0], df.AP[0]
+	if err := referenceVerifySTR(str, savedSTR, signKey); err != nil {
+		return err
+	}
+	if next := str.Policies.NextSigningKey; len(next) == sign.PublicKeySize {
+		signKey = next
+	}
+	if ap == nil || ap.Leaf == nil {
+		return protocol.ErrMalformedMessage
+	}
+
+	inclusion := bytes.Equal(ap.LookupIndex, ap.Leaf.Index)
+	promised := false
+	switch {
+	case requestType == protocol.RegistrationType && msg.Error == protocol.ReqSuccess:
+		if inclusion {
+			return protocol.ErrMalformedMessage
+		}
+		promised = true
+	case requestType == protocol.RegistrationType && msg.Error == protocol.ReqNameExisted:
+		promised = !inclusion
+	case requestType == protocol.KeyLookupType && msg.Error == protocol.ReqSuccess:
+		promised = !inclusion
+	case requestType == protocol.KeyLookupType && msg.Error == protocol.ReqNameNotFound:
+		if inclusion {
+			return protocol.ErrMalformedMessage
+		}
+	default:
+		return protocol.ErrMalformedMessage
+	}
+
+	if err := referenceVerifyAuthPath(uname, key, ap, str); err != nil {
+		return err
+	}
+	if !promised {
+		return nil
+	}
+	return referenceVerifyPromise(df.TB, key, ap, str, signKey)
+}
+
+// referenceVerifySTR checks that str is the verified STR savedSTR, or
+// that str is signed with signKey, extends the hash chain of savedSTR
+// by one epoch, and is cross-signed by the new signing key it declares,
+// if any.
+func referenceVerifySTR(str, savedSTR *protocol.DirSTR, signKey sign.PublicKey) error {
+	if str == nil || str.SignedTreeRoot == nil || str.Policies == nil {
+		return protocol.ErrMalformedMessage
+	}
+	switch {
+	case str.Epoch == savedSTR.Epoch:
+		if !bytes.Equal(str.Serialize(), savedSTR.Serialize()) ||
+			!bytes.Equal(str.Signature, savedSTR.Signature) {
+			return protocol.CheckBadSTR
+		}
+	case str.Epoch == savedSTR.Epoch+1:
+		if !signKey.Verify(str.Serialize(), str.Signature) {
+			return protocol.CheckBadSignature
+		}
+		if err := str.VerifyHandover(); err != nil {
+			return err
+		}
+		if str.PreviousEpoch != savedSTR.Epoch ||
+			!bytes.Equal(str.PreviousSTRHash, crypto.Digest(savedSTR.Signature)) {
+			return protocol.CheckBadSTR
+		}
+	default:
+		return protocol.CheckBadSTR
+	}
+	return nil
+}
+
+// referenceVerifyAuthPath checks the VRF proof of the lookup index of
+// ap, and recomputes the root of the tree from the leaf of ap, one
+// level at a time, for the binding of uname to key.
+func referenceVerifyAuthPath(uname string, key []byte,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR) error {
+	hasher, err := str.Policies.Hasher()
+	if err != nil {
+		return protocol.CheckUnsupportedSTR
+	}
+	size := hasher.Size()
+	if len(str.TreeHash) != size {
+		return protocol.ErrMalformedMessage
+	}
+	if !str.Policies.VerifyVrf([]byte(uname), ap.LookupIndex, ap.VrfProof) {
+		return protocol.CheckBadVRFProof
+	}
+
+	leaf := ap.Leaf
+	level := int(leaf.Level)
+	if level > len(ap.PrunedTree) || level > 8*len(leaf.Index) ||
+		level > 8*len(ap.LookupIndex) {
+		return protocol.ErrMalformedMessage
+	}
+	// bit returns the i-th bit of index, from the most significant bit
+	bit := func(index []byte, i int) byte {
+		return (index[i/8] >> uint(7-i%8)) & 1
+	}
+
+	var hash []byte
+	if bytes.Equal(ap.LookupIndex, leaf.Index) {
+		// a proof of inclusion opens the commitment to the binding
+		if key == nil {
+			key = leaf.Value
+		}
+		if !bytes.Equal(leaf.Value, key) {
+			return protocol.CheckBindingsDiffer
+		}
+		if leaf.Commitment == nil {
+			return protocol.ErrMalformedMessage
+		}
+		if !bytes.Equal(leaf.Commitment.Value,
+			crypto.Digest(leaf.Commitment.Salt, []byte(uname), key)) {
+			return protocol.CheckBadCommitment
+		}
+		hash = hasher.Digest([]byte{merkletree.LeafIdentifier},
+			ap.TreeNonce, leaf.Index, utils.UInt32ToBytes(leaf.Level),
+			leaf.Commitment.Value)
+	} else {
+		// a proof of absence ends at an empty node or at another
+		// user's leaf, on the path to the lookup index
+		for i := 0; i < level; i++ {
+			if bit(leaf.Index, i) != bit(ap.LookupIndex, i) {
+				return protocol.CheckBadLookupIndex
+			}
+		}
+		if leaf.Value != nil {
+			return protocol.CheckBindingsDiffer
+		}
+		if leaf.IsEmpty {
+			hash = hasher.Digest([]byte{merkletree.EmptyBranchIdentifier},
+				ap.TreeNonce, leaf.Index, utils.UInt32ToBytes(leaf.Level))
+		} else {
+			if leaf.Commitment == nil {
+				return protocol.ErrMalformedMessage
+			}
+			hash = hasher.Digest([]byte{merkletree.LeafIdentifier},
+				ap.TreeNonce, leaf.Index, utils.UInt32ToBytes(leaf.Level),
+				leaf.Commitment.Value)
+		}
+	}
+
+	for i := level - 1; i >= 0; i-- {
+		sibling := ap.PrunedTree[i]
+		if len(sibling) != size {
+			return protocol.ErrMalformedMessage
+		}
+		if bit(leaf.Index, i) == 1 {
+			hash = hasher.Digest(sibling, hash)
+		} else {
+			hash = hasher.Digest(hash, sibling)
+		}
+	}
+	if !bytes.Equal(hash, str.TreeHash) {
+		return protocol.CheckBadAuthPath
+	}
+	return nil
+}
+
+// referenceVerifyPromise checks that the promise tb, returned with the
+// proof of absence ap, is signed with signKey for the STR str, and
+// promises the inclusion of the binding of ap's lookup index to key in
+// the epoch following str's.
+func referenceVerifyPromise(tb *protocol.TemporaryBinding, key []byte,
+	ap *merkletree.AuthenticationPath, str *protocol.DirSTR,
+	signKey sign.PublicKey) error {
+	if tb == nil || tb.IsKeyChange() {
+		return protocol.CheckBadPromise
+	}
+	if !signKey.Verify(tb.Serialize(str.Signature), tb.Signature) {
+		return protocol.CheckBadSignature
+	}
+	if !bytes.Equal(tb.Index, ap.LookupIndex) ||
+		tb.IssuedEpoch != str.Epoch || tb.InclusionEpoch != str.Epoch+1 {
+		return protocol.CheckBadPromise
+	}
+	if key != nil && !bytes.Equal(tb.Value, key) {
+		return protocol.CheckBindingsDiffer
+	}
+	return nil
+}
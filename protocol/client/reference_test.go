@@ -0,0 +1,153 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// flip returns a copy of bs with its first byte flipped.
+func flip(bs []byte) []byte {
+	res := append([]byte{}, bs...)
+	res[0] ^= 1
+	return res
+}
+
+// registeredClient returns a client which has verified the registration
+// of alice, and the response to the lookup of name in the next epoch,
+// whose STR and authentication path are copies which can be tampered
+// with.
+func registeredClient(t *testing.T, name string) (*ConsistencyChecks, *protocol.Response) {
+	d, cc := newTestClient(t)
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	return cc, lookupCopy(d, name)
+}
+
+func lookupCopy(d *directory.ConiksDirectory, name string) *protocol.Response {
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+	df := res.DirectoryProof()
+	str := *df.STR[0].SignedTreeRoot
+	ap := *df.AP[0]
+	leaf := *ap.Leaf
+	if leaf.Commitment != nil {
+		commit := *leaf.Commitment
+		leaf.Commitment = &commit
+	}
+	ap.Leaf = &leaf
+	ap.PrunedTree = append([][]byte{}, ap.PrunedTree...)
+	return &protocol.Response{
+		Error: res.Error,
+		DirectoryResponse: &protocol.DirectoryProof{
+			AP:  []*merkletree.AuthenticationPath{&ap},
+			STR: []*protocol.DirSTR{{SignedTreeRoot: &str, Policies: df.STR[0].Policies}},
+			TB:  df.TB,
+		},
+	}
+}
+
+func TestReferenceVerify(t *testing.T) {
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	for _, tc := range []struct {
+		name   string
+		lookup string
+		key    []byte
+		tamper func(df *protocol.DirectoryProof)
+		want   error
+	}{
+		{"inclusion", alice, key, nil, nil},
+		{"absence", "bob", nil, nil, nil},
+		{"TOFU", alice, nil, nil, nil},
+		{"other key", alice, []byte("other"), nil, protocol.CheckBindingsDiffer},
+		{"bad STR signature", alice, key, func(df *protocol.DirectoryProof) {
+			df.STR[0].Signature = flip(df.STR[0].Signature)
+		}, protocol.CheckBadSignature},
+		{"tampered hash chain", alice, key, func(df *protocol.DirectoryProof) {
+			df.STR[0].PreviousEpoch++
+		}, protocol.CheckBadSignature},
+		{"bad VRF proof", alice, key, func(df *protocol.DirectoryProof) {
+			df.AP[0].VrfProof = flip(df.AP[0].VrfProof)
+		}, protocol.CheckBadVRFProof},
+		{"bad commitment", alice, key, func(df *protocol.DirectoryProof) {
+			df.AP[0].Leaf.Commitment.Salt = flip(df.AP[0].Leaf.Commitment.Salt)
+		}, protocol.CheckBadCommitment},
+		{"bad auth path", alice, key, func(df *protocol.DirectoryProof) {
+			df.AP[0].PrunedTree[0] = flip(df.AP[0].PrunedTree[0])
+		}, protocol.CheckBadAuthPath},
+		{"bad tree nonce", "bob", nil, func(df *protocol.DirectoryProof) {
+			df.AP[0].TreeNonce = flip(df.AP[0].TreeNonce)
+		}, protocol.CheckBadAuthPath},
+		{"missing leaf", alice, key, func(df *protocol.DirectoryProof) {
+			df.AP[0].Leaf = nil
+		}, protocol.ErrMalformedMessage},
+	} {
+		cc, res := registeredClient(t, tc.lookup)
+		if tc.tamper != nil {
+			tc.tamper(res.DirectoryProof())
+		}
+		err := ReferenceVerify(protocol.KeyLookupType, res, tc.lookup, tc.key,
+			cc.VerifiedSTR(), pk)
+		if err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+		}
+		// the client's checks agree with the reference verifier
+		if checks := cc.HandleResponse(protocol.KeyLookupType, res, tc.lookup,
+			tc.key); (checks == nil) != (err == nil) {
+			t.Error(tc.name, "expect the client's checks to return", err, "got", checks)
+		}
+	}
+}
+
+func TestReferenceCheck(t *testing.T) {
+	d, cc := newTestClient(t)
+	var divergences []*Divergence
+	cc.SetReferenceCheck(func(d *Divergence) {
+		divergences = append(divergences, d)
+	})
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	if err := cc.HandleResponse(protocol.KeyLookupType,
+		d.KeyLookup(&protocol.KeyLookupRequest{Username: alice}), alice, key); err != nil {
+		t.Fatal(err)
+	}
+	res = lookupCopy(d, alice)
+	df := res.DirectoryProof()
+	df.AP[0].PrunedTree[0] = flip(df.AP[0].PrunedTree[0])
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != protocol.CheckBadAuthPath {
+		t.Fatal("Expect", protocol.CheckBadAuthPath, "got", err)
+	}
+	if len(divergences) != 0 {
+		t.Fatal("Expect no divergence, got", divergences[0])
+	}
+
+	// an optimization which wrongly skips the verification of
+	// a prefetched authentication path is caught
+	cc.lock.Lock()
+	err := cc.handleResponse(protocol.KeyLookupType, res, alice, key, true)
+	cc.unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divergences) != 1 {
+		t.Fatal("Expect", 1, "divergence, got", len(divergences))
+	}
+	if dv := divergences[0]; dv.Checks != nil || dv.Reference != protocol.CheckBadAuthPath ||
+		dv.Username != alice || dv.Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Unexpected divergence", dv)
+	}
+}
@@ -0,0 +1,182 @@
+// Defines the states a name-to-key binding can be in from the point
+// of view of a CONIKS client, and the transitions between these states
+// which the client's consistency checks allow.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A BindingState indicates what a CONIKS client has verified about
+// a name-to-key binding in a CONIKS directory.
+type BindingState int
+
+// These constants indicate the state of a binding.
+// A binding that the client hasn't seen any verified proof for, or for
+// which the directory has returned a proof of absence without a
+// temporary binding, is Unregistered.
+// A binding for which the directory has returned a valid temporary
+// binding, but which isn't included in the directory yet, is Promised.
+// A binding for which the directory has returned a valid proof of
+// inclusion is Included, unless the included value is the
+// protocol.Tombstone, in which case the binding is Deactivated, or
+// a frozen value, in which case the binding is Frozen by the
+// directory's operator (see protocol.Frozen()).
+const (
+	Unregistered BindingState = iota
+	Promised
+	Included
+	Deactivated
+	Frozen
+)
+
+var stateNames = map[BindingState]string{
+	Unregistered: "Unregistered",
+	Promised:     "Promised",
+	Included:     "Included",
+	Deactivated:  "Deactivated",
+	Frozen:       "Frozen",
+}
+
+// String returns the name of the state s.
+func (s BindingState) String() string {
+	return stateNames[s]
+}
+
+// transitions defines the state machine of a binding. It maps each
+// (current, next) state pair to nil if the transition is allowed,
+// or to the consistency check error the client reports otherwise.
+// Since the directory does not support deletions, an included
+// binding can only leave the Included state to be Deactivated, which
+// it then never leaves, and a promised binding must eventually be
+// included. Key changes keep a binding Included, and a deactivation
+// is a key change to the protocol.Tombstone (see PendingKeyChange()).
+// The directory's operator may freeze an included binding, and
+// unfreeze it, which moves it between the Included and Frozen states.
+// A frozen binding can't be deactivated, but the client may not see
+// the epoch in which it was unfrozen before its deactivation.
+var transitions = map[BindingState]map[BindingState]error{
+	Unregistered: {
+		Unregistered: nil,
+		Promised:     nil,
+		Included:     nil,
+		Deactivated:  nil,
+		Frozen:       nil,
+	},
+	Promised: {
+		Unregistered: protocol.CheckBrokenPromise,
+		Promised:     nil,
+		Included:     nil,
+		Deactivated:  protocol.CheckBrokenPromise,
+		Frozen:       protocol.CheckBrokenPromise,
+	},
+	Included: {
+		Unregistered: protocol.CheckBindingsDiffer,
+		Promised:     protocol.CheckBindingsDiffer,
+		Included:     nil,
+		Deactivated:  nil,
+		Frozen:       nil,
+	},
+	Deactivated: {
+		Unregistered: protocol.CheckBindingsDiffer,
+		Promised:     protocol.CheckBindingsDiffer,
+		Included:     protocol.CheckBindingsDiffer,
+		Deactivated:  nil,
+		Frozen:       protocol.CheckBindingsDiffer,
+	},
+	Frozen: {
+		Unregistered: protocol.CheckBindingsDiffer,
+		Promised:     protocol.CheckBindingsDiffer,
+		Included:     nil,
+		Deactivated:  nil,
+		Frozen:       nil,
+	},
+}
+
+// BindingStates returns all the states a binding can be in, in
+// increasing order, e.g., for the machine-readable description of
+// the protocol.
+func BindingStates() []BindingState {
+	states := make([]BindingState, 0, len(stateNames))
+	for s := Unregistered; int(s) < len(stateNames); s++ {
+		states = append(states, s)
+	}
+	return states
+}
+
+// CheckTransition returns the consistency check error the client
+// reports if a binding moves from the state from to the state next,
+// or nil if this transition is allowed.
+func CheckTransition(from, next BindingState) error {
+	return transitions[from][next]
+}
+
+// A Transition is the event emitted by a ConsistencyChecks each time
+// a verified response changes the state of the binding for Name.
+// Epoch is the epoch of the STR included in the verified response.
+type Transition struct {
+	Name  string
+	From  BindingState
+	To    BindingState
+	Epoch uint64
+}
+
+// State returns the current state of the binding for uname.
+func (cc *ConsistencyChecks) State(uname string) BindingState {
+	cc.lock.Lock()
+	defer cc.unlock()
+	return cc.states[uname]
+}
+
+// SetTransitionHandler registers a function that is called with the
+// corresponding Transition each time the state of a binding changes.
+// Passing a nil handler disables the notifications.
+func (cc *ConsistencyChecks) SetTransitionHandler(handler func(*Transition)) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.onTransition = handler
+}
+
+// nextState determines the state the binding is in according to a
+// validated DirectoryProof df with the error code e.
+func nextState(e protocol.ErrorCode, df *protocol.DirectoryProof) BindingState {
+	switch {
+	case df.AP[0].ProofType() == merkletree.ProofOfInclusion &&
+		protocol.IsTombstone(df.AP[0].Leaf.Value):
+		return Deactivated
+	case df.AP[0].ProofType() == merkletree.ProofOfInclusion &&
+		protocol.IsFrozen(df.AP[0].Leaf.Value):
+		return Frozen
+	case df.AP[0].ProofType() == merkletree.ProofOfInclusion:
+		return Included
+	case df.TB != nil && e != protocol.ReqNameNotFound:
+		return Promised
+	default:
+		return Unregistered
+	}
+}
+
+// checkTransition returns the error associated with moving the binding
+// for uname from its current state to next, or nil if this transition
+// is allowed.
+func (cc *ConsistencyChecks) checkTransition(uname string, next BindingState) error {
+	return transitions[cc.states[uname]][next]
+}
+
+// transition moves the binding for uname into state next,
+// and notifies the transition handler if the state has changed.
+func (cc *ConsistencyChecks) transition(uname string, next BindingState, epoch uint64) {
+	prev := cc.states[uname]
+	cc.states[uname] = next
+	if handler := cc.onTransition; prev != next && handler != nil {
+		tr := &Transition{
+			Name:  uname,
+			From:  prev,
+			To:    next,
+			Epoch: epoch,
+		}
+		cc.emit(func() { handler(tr) })
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+var (
+	alice = "alice"
+	key   = []byte("key")
+)
+
+func newTestClient(t *testing.T) (*directory.ConiksDirectory, *ConsistencyChecks) {
+	d := directory.NewTestDirectory(t)
+	// the static initial STR doesn't commit to the directory's tree
+	d.Update()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	return d, New(d.LatestSTR(), true, pk)
+}
+
+func TestTransitionTableIsExhaustive(t *testing.T) {
+	for from := range stateNames {
+		for to := range stateNames {
+			if _, ok := transitions[from][to]; !ok {
+				t.Errorf("Missing transition %s -> %s", from, to)
+			}
+		}
+	}
+}
+
+func TestBindingStateTransitions(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	var events []*Transition
+	cc.SetTransitionHandler(func(tr *Transition) {
+		events = append(events, tr)
+	})
+
+	for _, tc := range []struct {
+		name      string
+		update    bool
+		reqType   int
+		key       []byte
+		wantState BindingState
+		wantEvent bool
+	}{
+		{"lookup unregistered", false, protocol.KeyLookupType, nil, Unregistered, false},
+		{"register", false, protocol.RegistrationType, key, Promised, true},
+		{"lookup promised", false, protocol.KeyLookupType, key, Promised, false},
+		{"register existing promise", false, protocol.RegistrationType, key, Promised, false},
+		{"lookup fulfilled promise", true, protocol.KeyLookupType, key, Included, true},
+		{"register existing binding", false, protocol.RegistrationType, key, Included, false},
+		{"lookup included", true, protocol.KeyLookupType, nil, Included, false},
+	} {
+		if tc.update {
+			d.Update()
+		}
+		var res *protocol.Response
+		switch tc.reqType {
+		case protocol.RegistrationType:
+			res = d.Register(&protocol.RegistrationRequest{
+				Username: alice,
+				Key:      key,
+			})
+		case protocol.KeyLookupType:
+			res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		}
+		n := len(events)
+		if err := cc.HandleResponse(tc.reqType, res, alice, tc.key); err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.name, err)
+		}
+		if got := cc.State(alice); got != tc.wantState {
+			t.Errorf("%s: expect state %s, got %s", tc.name, tc.wantState, got)
+		}
+		if got := len(events) > n; got != tc.wantEvent {
+			t.Errorf("%s: expect event %v, got %v", tc.name, tc.wantEvent, got)
+		}
+	}
+	if len(events) != 2 ||
+		events[0].From != Unregistered || events[0].To != Promised ||
+		events[1].From != Promised || events[1].To != Included ||
+		events[1].Epoch != 2 {
+		t.Error("Unexpected sequence of transitions")
+	}
+	if !bytes.Equal(cc.Bindings[alice], key) {
+		t.Error("Expect the verified binding to be saved")
+	}
+	if _, ok := cc.TBs[alice]; ok {
+		t.Error("Expect the fulfilled TB to be removed")
+	}
+}
+
+func TestBindingStateIllegalTransitions(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		state BindingState
+		want  error
+	}{
+		{"broken promise", Promised, protocol.CheckBrokenPromise},
+		{"removed binding", Included, protocol.CheckBindingsDiffer},
+	} {
+		d, cc := newTestClient(t)
+		cc.states[alice] = tc.state
+		cc.Bindings[alice] = key
+
+		// the directory returns a proof of absence for alice
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+		if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, nil); err != tc.want {
+			t.Errorf("%s: expect %v, got %v", tc.name, tc.want, err)
+		}
+		// a failed check must not change the binding's state
+		if got := cc.State(alice); got != tc.state {
+			t.Errorf("%s: expect state %s, got %s", tc.name, tc.state, got)
+		}
+		if !bytes.Equal(cc.Bindings[alice], key) {
+			t.Errorf("%s: expect the binding to be unchanged", tc.name)
+		}
+	}
+}
+
+func TestEpochEvents(t *testing.T) {
+	d, cc := newTestClient(t)
+
+	var events []*EpochEvent
+	cc.SetEpochHandler(func(ev *EpochEvent) {
+		events = append(events, ev)
+	})
+
+	res := d.Register(&protocol.RegistrationRequest{
+		Username: alice,
+		Key:      key,
+	})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatal("Expect no event for the verified epoch, got", len(events))
+	}
+
+	// a lookup in the next epoch
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: alice})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, alice, key); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].OldEpoch != 1 || events[0].NewEpoch != 2 ||
+		len(events[0].PolicyChanges) != 0 {
+		t.Fatal("Unexpected epoch events", events)
+	}
+
+	// monitoring across a policy change
+	d.SetPolicies(2)
+	d.Update()
+	d.Update()
+	req := &protocol.MonitoringRequest{
+		Username:   alice,
+		StartEpoch: 2,
+		EndEpoch:   4,
+	}
+	if err := cc.HandleMonitoringResponse(req, d.Monitor(req), key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].OldEpoch != 2 || events[1].NewEpoch != 4 {
+		t.Fatal("Unexpected epoch events", events)
+	}
+	if changes := events[1].PolicyChanges; len(changes) != 1 ||
+		changes[0] != protocol.PolicyEpochDeadline {
+		t.Fatal("Expect", protocol.PolicyEpochDeadline, "to change, got", changes)
+	}
+}
@@ -0,0 +1,206 @@
+// Implements the client's strict mode, in which the client doesn't
+// accept a fresh STR received in a registration response until one of
+// its auditors confirms that it has observed the same STR. This closes
+// the window in which a directory could fork the client's view right
+// at registration, when the client has no binding to monitor yet.
+
+package client
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A ConfirmationFallback determines what a client in strict mode does
+// with a registration whose STR no auditor has confirmed before the
+// confirmation timeout.
+type ConfirmationFallback int
+
+// These are the fallbacks of the strict mode.
+// RejectUnconfirmed discards the registration, and AcceptUnconfirmed
+// accepts it as in the non-strict mode, i.e., trusts the STR on
+// first use.
+const (
+	RejectUnconfirmed ConfirmationFallback = iota
+	AcceptUnconfirmed
+)
+
+// StrictMode configures the client's strict mode.
+// Timeout is the time the client waits for an auditor to confirm a
+// registration's STR before it applies the Fallback; a zero Timeout
+// waits forever.
+type StrictMode struct {
+	Timeout  time.Duration
+	Fallback ConfirmationFallback
+}
+
+// An unconfirmedRegistration is a verified registration response
+// whose STR awaits an auditor's confirmation.
+type unconfirmedRegistration struct {
+	df       *protocol.DirectoryProof
+	next     BindingState
+	deadline time.Time
+}
+
+// SetStrictMode enables the client's strict mode with the settings
+// mode, or disables it if mode is nil. Registrations which await
+// confirmation when the strict mode is disabled keep waiting.
+func (cc *ConsistencyChecks) SetStrictMode(mode *StrictMode) {
+	cc.lock.Lock()
+	defer cc.unlock()
+	cc.strict = mode
+}
+
+// Unconfirmed returns the STR of the registration of uname which awaits
+// an auditor's confirmation, or nil. The client should request the
+// STR of the returned STR's epoch from its auditors
+// (see protocol.AuditingRequest), and pass their responses to
+// HandleConfirmation().
+func (cc *ConsistencyChecks) Unconfirmed(uname string) *protocol.DirSTR {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if u := cc.unconfirmed[uname]; u != nil {
+		return u.df.STR[0]
+	}
+	return nil
+}
+
+// awaitsConfirmation returns whether the client must wait for an
+// auditor to confirm the STR str received in a response to a request
+// of type requestType, i.e., whether the client is in strict mode and
+// str is a fresh STR included in a registration response.
+func (cc *ConsistencyChecks) awaitsConfirmation(requestType int, str *protocol.DirSTR) bool {
+	return cc.strict != nil && requestType == protocol.RegistrationType &&
+		str.Epoch != cc.VerifiedSTR().Epoch
+}
+
+// holdRegistration records the verified registration response df for
+// uname until an auditor confirms its STR.
+func (cc *ConsistencyChecks) holdRegistration(uname string, next BindingState,
+	df *protocol.DirectoryProof) {
+	u := &unconfirmedRegistration{df: df, next: next}
+	if cc.strict.Timeout != 0 {
+		u.deadline = cc.clock.Now().Add(cc.strict.Timeout)
+	}
+	cc.unconfirmed[uname] = u
+}
+
+// HandleConfirmation verifies the response msg of an auditor to an
+// AuditingRequest against the STRs of the registrations which await
+// confirmation (see Unconfirmed()). Each registration whose STR's epoch
+// is covered by msg is accepted if the auditor has observed the same
+// STR, i.e., the binding's state, the verified STR and the client's
+// bindings are updated as in HandleResponse(). Otherwise, the directory
+// has shown different STRs to the client and to the auditor, and the
+// registration is discarded.
+//
+// HandleConfirmation() returns a CheckBadSTR if the auditor has
+// observed a different STR for any of the registrations, or the
+// consistency check error of the first registration which can't be
+// accepted anymore, e.g., because the client has verified a different
+// STR for the same epoch in the meantime.
+func (cc *ConsistencyChecks) HandleConfirmation(msg *protocol.Response) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	strs := msg.STRHistoryRange()
+	if strs == nil {
+		return protocol.ErrMalformedMessage
+	}
+	observed := make(map[uint64]*protocol.DirSTR, len(strs.STR))
+	for _, str := range strs.STR {
+		observed[str.Epoch] = str
+	}
+
+	var err error
+	for _, uname := range cc.unconfirmedNames() {
+		u := cc.unconfirmed[uname]
+		str, ok := observed[u.df.STR[0].Epoch]
+		if !ok {
+			continue
+		}
+		delete(cc.unconfirmed, uname)
+		var e error = protocol.CheckBadSTR
+		if sameSTR(str, u.df.STR[0]) {
+			e = cc.acceptRegistration(uname, u)
+		}
+		if err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ExpireConfirmations applies the fallback of the strict mode to each
+// registration which awaits confirmation past its deadline, according
+// to the client's clock. It returns the result for each name whose
+// registration expired: nil if the registration was accepted, or
+// a CheckUnconfirmedSTR if it was discarded.
+func (cc *ConsistencyChecks) ExpireConfirmations() map[string]error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	results := make(map[string]error)
+	if cc.strict == nil {
+		return results
+	}
+	now := cc.clock.Now()
+	for _, uname := range cc.unconfirmedNames() {
+		u := cc.unconfirmed[uname]
+		if u.deadline.IsZero() || !now.After(u.deadline) {
+			continue
+		}
+		delete(cc.unconfirmed, uname)
+		switch cc.strict.Fallback {
+		case AcceptUnconfirmed:
+			results[uname] = cc.acceptRegistration(uname, u)
+		default:
+			results[uname] = protocol.CheckUnconfirmedSTR
+		}
+	}
+	return results
+}
+
+// acceptRegistration checks the registration u of uname against the
+// client's current state, and moves the binding into the verified
+// state. The verified STR is updated if the registration's STR is
+// newer.
+func (cc *ConsistencyChecks) acceptRegistration(uname string,
+	u *unconfirmedRegistration) error {
+	str := u.df.STR[0]
+	if str.Epoch >= cc.VerifiedSTR().Epoch {
+		if err := cc.CheckSTRAgainstVerified(str); err != nil {
+			return err
+		}
+	}
+	if err := cc.checkTransition(uname, u.next); err != nil {
+		return err
+	}
+	if str.Epoch > cc.VerifiedSTR().Epoch {
+		cc.updateVerifiedSTR(str)
+	}
+	cc.updateBinding(uname, u.next, u.df)
+	return nil
+}
+
+// unconfirmedNames returns the names whose registrations await
+// confirmation, in lexical order.
+func (cc *ConsistencyChecks) unconfirmedNames() []string {
+	names := make([]string, 0, len(cc.unconfirmed))
+	for uname := range cc.unconfirmed {
+		names = append(names, uname)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sameSTR returns whether a and b are the same STR, i.e.,
+// whether they have the same contents and signature.
+func sameSTR(a, b *protocol.DirSTR) bool {
+	return bytes.Equal(a.Signature, b.Signature) &&
+		bytes.Equal(a.Serialize(), b.Serialize())
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+func newTestStrictClient(t *testing.T, mode *StrictMode) (*directory.ConiksDirectory,
+	*ConsistencyChecks) {
+	d, cc := newTestClient(t)
+	cc.SetStrictMode(mode)
+	d.Update()
+	res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+	if err := cc.HandleResponse(protocol.RegistrationType, res, alice, key); err != protocol.CheckUnconfirmedSTR {
+		t.Fatal("Expect", protocol.CheckUnconfirmedSTR, "got", err)
+	}
+	if str := cc.Unconfirmed(alice); str == nil || str.Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the registration to await confirmation")
+	}
+	if cc.State(alice) != Unregistered || cc.VerifiedSTR().Epoch == d.LatestSTR().Epoch {
+		t.Fatal("Expect the unconfirmed registration not to be accepted")
+	}
+	return d, cc
+}
+
+func TestStrictRegistrationConfirmed(t *testing.T) {
+	d, cc := newTestStrictClient(t, &StrictMode{})
+	// the auditor doesn't cover the registration's epoch yet
+	prev := d.GetSTRHistory(&protocol.STRHistoryRequest{StartEpoch: 0, EndEpoch: 1})
+	if err := cc.HandleConfirmation(prev); err != nil {
+		t.Fatal(err)
+	}
+	if cc.Unconfirmed(alice) == nil {
+		t.Fatal("Expect the registration to await confirmation")
+	}
+
+	res := protocol.NewSTRHistoryRange([]*protocol.DirSTR{d.LatestSTR()})
+	if err := cc.HandleConfirmation(res); err != nil {
+		t.Fatal(err)
+	}
+	if cc.Unconfirmed(alice) != nil || cc.State(alice) != Promised ||
+		cc.VerifiedSTR().Epoch != d.LatestSTR().Epoch {
+		t.Fatal("Expect the confirmed registration to be accepted")
+	}
+}
+
+func TestStrictRegistrationForked(t *testing.T) {
+	_, cc := newTestStrictClient(t, &StrictMode{})
+	// the directory shows another STR to the auditor
+	fork := directory.NewTestDirectory(t)
+	fork.Update()
+	fork.Register(&protocol.RegistrationRequest{Username: "bob", Key: key})
+	fork.Update()
+	res := protocol.NewSTRHistoryRange([]*protocol.DirSTR{fork.LatestSTR()})
+	if err := cc.HandleConfirmation(res); err != protocol.CheckBadSTR {
+		t.Fatal("Expect", protocol.CheckBadSTR, "got", err)
+	}
+	if cc.Unconfirmed(alice) != nil || cc.State(alice) != Unregistered {
+		t.Fatal("Expect the registration to be discarded")
+	}
+}
+
+func TestStrictRegistrationFallback(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fallback ConfirmationFallback
+		want     error
+		state    BindingState
+	}{
+		{"reject", RejectUnconfirmed, protocol.CheckUnconfirmedSTR, Unregistered},
+		{"accept", AcceptUnconfirmed, nil, Promised},
+	} {
+		clock := utils.NewFakeClock(time.Unix(0, 0))
+		d, cc := newTestClient(t)
+		cc.SetClock(clock)
+		cc.SetStrictMode(&StrictMode{Timeout: time.Minute, Fallback: tc.fallback})
+		d.Update()
+		res := d.Register(&protocol.RegistrationRequest{Username: alice, Key: key})
+		cc.HandleResponse(protocol.RegistrationType, res, alice, key)
+
+		if results := cc.ExpireConfirmations(); len(results) != 0 {
+			t.Error(tc.name, "expect no expired confirmations", "got", results)
+		}
+		clock.Advance(2 * time.Minute)
+		err, ok := cc.ExpireConfirmations()[alice]
+		if !ok || err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+		}
+		if cc.Unconfirmed(alice) != nil || cc.State(alice) != tc.state {
+			t.Error(tc.name, "expect", tc.state, "got", cc.State(alice))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Implements the verification of a CONIKS directory's proof of the
+// subtree of a provider's namespace.
+
+package client
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// HandleSubtreeResponse verifies the directory's response msg to the
+// request req for the proof of the subtree of the namespace of
+// req.Provider in the directory's tree at the epoch req.Epoch.
+//
+// The STRs in msg are verified as for HandleEmptyRangeResponse(). The
+// provider's private index is then verified against the VRF public key
+// of the STR for req.Epoch, and the proof against the STR's tree hash
+// (see merkletree.SubtreeProof.Verify()). Once the response verifies,
+// the hash of the subtree is taken from the proof.
+// HandleSubtreeResponse() returns an ErrMalformedMessage if the first
+// STR in msg doesn't match req, or if the proof's prefix isn't the
+// provider's prefix in the namespaces declared by the STR's policies,
+// a CheckBadVRFProof if the provider's private index doesn't verify,
+// and a CheckBadAuthPath if the proof doesn't verify.
+func (cc *ConsistencyChecks) HandleSubtreeResponse(req *protocol.SubtreeRequest,
+	msg *protocol.Response,
+	fetch func(*protocol.STRHistoryRequest) (*protocol.Response, error)) error {
+	cc.lock.Lock()
+	defer cc.unlock()
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	p := msg.SubtreeProof()
+	if p == nil || p.STR[0].Epoch != req.Epoch {
+		return protocol.ErrMalformedMessage
+	}
+
+	strs, err := cc.completeSTRRange(p.STR, p.Continuation, fetch)
+	if err != nil {
+		return err
+	}
+	if err := strs[0].CheckHeader(); err != nil {
+		return err
+	}
+	if !cc.VerifySignature(strs[0]) {
+		return protocol.CheckBadSignature
+	}
+	if err := cc.auditSTRRange(strs); err != nil {
+		return err
+	}
+	hasher, err := strHasher(strs[0])
+	if err != nil {
+		return err
+	}
+	bits := strs[0].Policies.NamespaceBits
+	if bits == 0 || p.Proof.PrefixBits != bits ||
+		!bytes.Equal(p.Proof.Prefix, p.ProviderIndex) {
+		return protocol.ErrMalformedMessage
+	}
+	if !strs[0].Policies.VerifyVrf([]byte(req.Provider), p.ProviderIndex, p.ProviderProof) {
+		return protocol.CheckBadVRFProof
+	}
+	start := time.Now()
+	err = p.Proof.VerifyWith(hasher, strs[0].TreeHash)
+	metrics.AuthPath.observe(time.Since(start), err == nil)
+	switch err {
+	case nil:
+		return nil
+	case merkletree.ErrMalformedRange, merkletree.ErrMalformedAuthPath:
+		return protocol.ErrMalformedMessage
+	default:
+		return protocol.CheckBadAuthPath
+	}
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+func TestSubtree(t *testing.T) {
+	d := directory.New(1, protocol.NewNamespacedVRF(crypto.NewStaticTestVRFKey(), 8),
+		crypto.NewStaticTestSigningKey(), 100, true)
+	name := "alice@example.org"
+	d.Register(&protocol.RegistrationRequest{Username: name, Key: key})
+	d.Update()
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	cc := New(d.LatestSTR(), true, pk)
+
+	// the lookups verify the namespaced private indices
+	res := d.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+	if err := cc.HandleResponse(protocol.KeyLookupType, res, name, key); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &protocol.SubtreeRequest{Provider: "example.org", Epoch: 1}
+	res = d.ProveSubtree(req)
+	if err := cc.HandleSubtreeResponse(req, res, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the proof isn't for the requested provider
+	if err := cc.HandleSubtreeResponse(&protocol.SubtreeRequest{Provider: "example.com", Epoch: 1},
+		res, nil); err != protocol.CheckBadVRFProof {
+		t.Fatal("Expect", protocol.CheckBadVRFProof, "got", err)
+	}
+	// the proof's prefix isn't the provider's one
+	p := res.SubtreeProof()
+	p.Proof.Prefix = append([]byte{}, p.Proof.Prefix...)
+	p.Proof.Prefix[0] ^= 0x80
+	if err := cc.HandleSubtreeResponse(req, res, nil); err != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", err)
+	}
+}
@@ -0,0 +1,34 @@
+// Implements the client side of the opt-in anonymous telemetry,
+// in which a CONIKS client reports the latest STR it has verified
+// to a CONIKS auditor.
+
+package client
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// NewObservationReport creates an anonymous report of the latest STR
+// verified by cc for the directory dirInitHash, which the client may
+// send to an auditor if its user has opted in to telemetry.
+//
+// secret is a random value which the client generates once and never
+// discloses. It is used to compute the report's pseudonymous reporter
+// tag, which allows the auditor to discard duplicate reports within an
+// epoch, while reports for different epochs remain unlinkable.
+func (cc *ConsistencyChecks) NewObservationReport(dirInitHash [crypto.HashSizeByte]byte,
+	secret []byte) *protocol.ObservationReport {
+	cc.lock.Lock()
+	defer cc.unlock()
+	str := cc.VerifiedSTR()
+	report := &protocol.ObservationReport{
+		DirInitSTRHash: dirInitHash,
+		Epoch:          str.Epoch,
+	}
+	copy(report.STRHash[:], crypto.Digest(str.Signature))
+	copy(report.ReporterTag[:], crypto.Digest(secret, dirInitHash[:],
+		utils.ULongToBytes(str.Epoch)))
+	return report
+}
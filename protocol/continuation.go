@@ -0,0 +1,83 @@
+// Defines the maximum size of a CONIKS response, and the continuation
+// a directory or auditor returns along with a partial response to
+// a request for an epoch range whose complete response would exceed
+// this size.
+
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// MaxResponseSize is the maximum size in bytes of the encoding of
+// a response to any CONIKS request. A client doesn't read more than
+// MaxResponseSize bytes of a response.
+const MaxResponseSize = 8192
+
+// responseOverhead is the size in bytes reserved in each response for
+// its error code, its field names, its continuation and the policy
+// transitions of an STRHistoryRange.
+const responseOverhead = 1024
+
+// A Continuation indicates that a response to a request for an epoch
+// range only covers a prefix of the range, since the complete response
+// would exceed MaxResponseSize. The recipient requests the remaining
+// range by sending the same request with the start epoch set to
+// NextEpoch.
+type Continuation struct {
+	NextEpoch uint64
+}
+
+// A ResponseBudget tracks the remaining size of a response which
+// a directory or an auditor builds epoch by epoch, so that the
+// response doesn't exceed MaxResponseSize.
+type ResponseBudget struct {
+	remaining int
+	used      bool
+	// buf holds the encoding of the last proof measured by Spend(),
+	// and is reused for the next proofs
+	buf []byte
+}
+
+// jsonAppender is implemented by the proofs which encode themselves
+// by hand, such as the STRs and the authentication paths.
+type jsonAppender interface {
+	AppendJSON(b []byte) []byte
+}
+
+// NewResponseBudget returns the ResponseBudget of an empty response.
+func NewResponseBudget() *ResponseBudget {
+	return &ResponseBudget{remaining: MaxResponseSize - responseOverhead}
+}
+
+// Spend returns whether the proofs ps of an epoch, e.g., its
+// authentication path and STR, fit in the remaining size of the
+// response, and if so, subtracts their encoded size from it.
+// The proofs of the first epoch always fit, so that each response
+// makes progress.
+// The proofs which encode themselves by hand are measured by encoding
+// them once into a buffer reused across the calls, and the other
+// proofs by json.Marshal().
+func (b *ResponseBudget) Spend(ps ...interface{}) bool {
+	size := 0
+	for _, p := range ps {
+		if a, ok := p.(jsonAppender); ok {
+			b.buf = a.AppendJSON(b.buf[:0])
+			size += len(b.buf)
+		} else {
+			bs, err := json.Marshal(p)
+			if err != nil {
+				return false
+			}
+			size += len(bs)
+		}
+		// the separator between the list elements
+		size++
+	}
+	if b.used && size > b.remaining {
+		return false
+	}
+	b.used = true
+	b.remaining -= size
+	return true
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+func TestResponseBudget(t *testing.T) {
+	b := NewResponseBudget()
+	huge := make([]byte, MaxResponseSize)
+	if !b.Spend(huge) {
+		t.Fatal("Expect the first epoch to fit regardless of its size")
+	}
+	if b.Spend([]byte("small")) {
+		t.Fatal("Expect the exhausted budget to reject further epochs")
+	}
+
+	b = NewResponseBudget()
+	n := 0
+	for b.Spend(make([]byte, 100)) {
+		n++
+	}
+	if n < 2 || n*100 > MaxResponseSize {
+		t.Fatal("Unexpected number of epochs", n, "within the budget")
+	}
+}
+
+func TestResponseBudgetMeasuresEncoding(t *testing.T) {
+	vrfKey, err := vrf.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrfPublicKey, _ := vrfKey.Public()
+	pad, err := merkletree.NewPAD(NewPolicies(10, vrfPublicKey), signKey, vrfKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pad.Set("alice", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	pad.Update(nil)
+	ap, err := pad.Lookup("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	str := NewDirSTR(pad.LatestSTR())
+
+	// the hand-encoded proofs are measured as json.Marshal() encodes them
+	want := 0
+	for _, p := range []interface{}{ap, str, []byte("nonce")} {
+		bs, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want += len(bs) + 1
+	}
+	b := NewResponseBudget()
+	if !b.Spend(ap, str, []byte("nonce")) {
+		t.Fatal("Expect the first epoch to fit")
+	}
+	if spent := MaxResponseSize - responseOverhead - b.remaining; spent != want {
+		t.Fatal("Expect", want, "spent bytes, got", spent)
+	}
+}
@@ -0,0 +1,45 @@
+// Defines the countersignatures with which CONIKS auditors vouch for
+// the STRs they have verified
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/crypto/sign"
+
+// countersignatureLabel separates the auditors' countersignatures
+// from their signatures on any other data.
+const countersignatureLabel = "coniks-str-countersignature"
+
+// A Countersignature is the Signature of the auditor whose public key is
+// Key on an STR it has verified, i.e., on the STR along with the
+// directory's signature on it. A relying party trusting a quorum of
+// auditors accepts the STRs countersigned by the quorum (see
+// auditor.Quorum).
+type Countersignature struct {
+	Key       sign.PublicKey
+	Signature []byte
+}
+
+// NewCountersignature creates the countersignature of the auditor
+// whose signing key is signKey on the STR str.
+func NewCountersignature(signKey sign.PrivateKey, str *DirSTR) *Countersignature {
+	pk, _ := signKey.Public()
+	return &Countersignature{
+		Key:       pk,
+		Signature: signKey.Sign(serializeCountersigned(str)),
+	}
+}
+
+// Verify checks that c is a valid countersignature on str
+// by the auditor whose public key is c.Key.
+func (c *Countersignature) Verify(str *DirSTR) bool {
+	return len(c.Key) == sign.PublicKeySize &&
+		c.Key.Verify(serializeCountersigned(str), c.Signature)
+}
+
+func serializeCountersigned(str *DirSTR) []byte {
+	var bs []byte
+	bs = append(bs, []byte(countersignatureLabel)...)
+	bs = append(bs, str.Serialize()...)
+	bs = append(bs, str.Signature...)
+	return bs
+}
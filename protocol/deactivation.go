@@ -0,0 +1,84 @@
+// Defines the messages with which a user deactivates the binding of
+// a username, i.e., retires the username
+
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// deactivationLabel separates the signatures on deactivations from the
+// user's signatures on any other data.
+const deactivationLabel = "coniks-deactivate"
+
+// Tombstone is the value a CONIKS directory binds to a deactivated
+// username instead of a key. It starts with a zero byte, and the
+// directory rejects the registrations and key changes to this value,
+// so that it can't be mistaken for a user's key.
+var Tombstone = []byte("\x00coniks-deactivated")
+
+// IsTombstone returns true if value is the Tombstone, i.e., if the
+// binding whose value it is has been deactivated.
+func IsTombstone(value []byte) bool {
+	return bytes.Equal(value, Tombstone)
+}
+
+// A DeactivationRequest is a message with a username as a string that
+// a CONIKS client sends to a CONIKS directory to deactivate the binding
+// of a registered username, e.g., when the user retires the account.
+// The directory then binds the username to the Tombstone for good: the
+// username can neither be looked up to a key, nor be registered or
+// changed again.
+//
+// A deactivation is a key change to the Tombstone (see
+// KeyChangeRequest): the response to a successful request is
+// a DirectoryProof with a proof of inclusion of the current binding,
+// and a TB promising the Tombstone in the next epoch. Until then, the
+// deactivation is pending and can be aborted with the current key
+// (see KeyChangeAbortRequest).
+//
+// Unless the user registered the username with AllowUnsignedKeychange
+// set, the request must include a Signature made with the private key
+// corresponding to the current key over the deactivation (see
+// DeactivationMessage()).
+type DeactivationRequest struct {
+	Username  string
+	Signature []byte `json:",omitempty"`
+}
+
+// DeactivationMessage returns the message a user signs to deactivate
+// the binding of username to key.
+func DeactivationMessage(username string, key []byte) []byte {
+	var bs []byte
+	bs = append(bs, []byte(deactivationLabel)...)
+	for _, b := range [][]byte{[]byte(username), key} {
+		bs = append(bs, utils.ULongToBytes(uint64(len(b)))...)
+		bs = append(bs, b...)
+	}
+	return bs
+}
+
+// NewDeactivationRequest creates a request to deactivate the binding
+// of username to key, signed with signKey, the private key
+// corresponding to key.
+func NewDeactivationRequest(signKey sign.PrivateKey, username string,
+	key []byte) *DeactivationRequest {
+	return &DeactivationRequest{
+		Username:  username,
+		Signature: signKey.Sign(DeactivationMessage(username, key)),
+	}
+}
+
+// Verify returns true if req is signed with the private key
+// corresponding to key, the key bound to the username, or to its
+// master key if key is a profile (see KeyChangeRequest.Verify()).
+func (req *DeactivationRequest) Verify(key []byte) bool {
+	pk, ok := userKey(key)
+	if !ok {
+		return false
+	}
+	return pk.Verify(DeactivationMessage(req.Username, key), req.Signature)
+}
@@ -0,0 +1,68 @@
+// Defines the messages with which a CONIKS auditor or mirror follows
+// the changes of a directory's tree epoch by epoch
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/merkletree"
+
+// An EpochDeltaRequest is a message that a CONIKS auditor or mirror
+// sends to a CONIKS directory to obtain the leaves of the directory's
+// tree whose bindings are new or changed in the epoch Epoch, i.e.,
+// since the tree of the previous epoch (see merkletree.TreeDelta).
+// A mirror thus keeps up with the directory's tree by inserting the
+// leaves of each epoch into its copy of the tree, without requesting
+// the authentication paths of the changed leaves, or learning their
+// bindings. StartIndex, if set, is the NextIndex of the response to the
+// previous request for Epoch.
+//
+// The response to a successful request is an EpochDelta.
+type EpochDeltaRequest struct {
+	Epoch      uint64
+	StartIndex []byte `json:",omitempty"`
+}
+
+// An EpochDelta response includes the delta Delta of the tree of the
+// requested epoch, and the STR for this epoch. If the leaves of the
+// delta would exceed MaxResponseSize, Delta only includes the leaves
+// whose index is less than NextIndex, and the recipient requests the
+// remaining leaves by sending the same request with the StartIndex set
+// to NextIndex.
+type EpochDelta struct {
+	Delta     *merkletree.TreeDelta
+	STR       *DirSTR
+	NextIndex []byte `json:",omitempty"`
+}
+
+var _ DirectoryResponse = (*EpochDelta)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*EpochDelta) ResponseType() string { return "EpochDelta" }
+
+func (p *EpochDelta) validate() error {
+	if p.STR == nil || p.Delta == nil || !validSTRs([]*DirSTR{p.STR}) {
+		return ErrMalformedMessage
+	}
+	for _, leaf := range p.Delta.Leaves {
+		if leaf == nil || leaf.Commitment == nil {
+			return ErrMalformedMessage
+		}
+	}
+	return nil
+}
+
+// NewEpochDelta creates the response message a CONIKS directory sends
+// upon an EpochDeltaRequest, and returns a Response containing an
+// EpochDelta struct.
+// directory.EpochDelta() passes the delta d, the signed tree root of
+// the requested epoch str, and the index next of the first leaf left
+// out of d, or nil.
+func NewEpochDelta(d *merkletree.TreeDelta, str *DirSTR, next []byte) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &EpochDelta{
+			Delta:     d,
+			STR:       str,
+			NextIndex: next,
+		},
+	}
+}
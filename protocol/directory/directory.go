@@ -2,19 +2,44 @@
 // maintains.
 // A directory is a publicly auditable, tamper-evident, privacy-preserving
 // data structure that contains mappings from usernames to public keys.
-// It currently supports registration, latest-version key lookups, past key
-// lookups, and monitoring.
-// It does not yet support key changes.
+// It currently supports registration, two-phase key changes,
+// latest-version key lookups, past key lookups, monitoring, and
+// key history queries.
 
 package directory
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/crypto/sign"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/profile"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+var (
+	// ErrNoIdentity indicates that a persisted directory's database
+	// doesn't record the directory's identity, e.g., because it was
+	// written by an earlier version (see PersistIdentity()).
+	ErrNoIdentity = errors.New("[directory] Database doesn't record the directory's identity")
+)
+
+// identityKey is the database key of the directory's identity, and
+// unsignedPrefix prefixes the database keys of the usernames whose key
+// changes don't need to be signed.
+var (
+	identityKey    = []byte("identity")
+	unsignedPrefix = []byte("unsigned-keychange/")
 )
 
 // A ConiksDirectory maintains the underlying persistent
@@ -24,11 +49,89 @@ import (
 // The current implementation of ConiksDirectory also keeps track
 // of temporary bindings (TBs). This feature may be split into a separate
 // protocol extension in a future release.
+//
+// The operations which don't modify the directory
+// (see protocol.ReadOnly()) may run concurrently with each other, but
+// not with the other operations nor with Update(). Each of their
+// responses is generated against a single snapshot: they read the
+// latest STR once, and only serve the proofs and STRs of the epochs up
+// to this STR, so that the proofs of a response always verify against
+// the STRs it includes. Use a Snapshot to serve key lookups
+// concurrently with Update().
 type ConiksDirectory struct {
-	pad      *merkletree.PAD
+	pad *merkletree.PAD
+	// identity is the hash of the directory's initial STR,
+	// which its TBs commit to
+	identity [crypto.HashSizeByte]byte
 	useTBs   bool
 	tbs      map[string]*protocol.TemporaryBinding
+	changes  map[string]*protocol.TemporaryBinding // TBs of pending key changes
+	// unsigned contains the usernames registered with
+	// AllowUnsignedKeychange, and db is the database to which the
+	// directory is persisted, if any, in which they are recorded.
+	unsigned map[string]bool
+	db       kv.DB
 	policies *protocol.Policies
+	// latestSTR caches the *protocol.DirSTR of the latest PAD snapshot.
+	// It is only swapped at each Update(), so that it can be read
+	// concurrently without locking.
+	latestSTR atomic.Value
+	// clock keeps track of the directory's epochs, and issuedAt
+	// is the time at which the latest STR was issued.
+	clock    utils.Clock
+	issuedAt time.Time
+	// bindings is the number of bindings in the pending version of
+	// the directory, and registrations the number of registrations
+	// accepted since the latest STR, which are checked against the
+	// directory's limits.
+	bindings      uint64
+	registrations uint64
+	limits        Limits
+	onLimit       func(*LimitAlert)
+	// attestationKeys maps the username suffixes to the public keys
+	// of the account verification bots trusted for these suffixes.
+	attestationKeys map[string]sign.PublicKey
+	// idPolicies restricts the registrations of each identifier type.
+	idPolicies map[protocol.IdentifierType]protocol.IdentifierPolicy
+	// namePolicies reserves username suffixes to the bots verifying them.
+	namePolicies protocol.NamePolicies
+	// publishPolicies indicates whether the directory publishes its
+	// policy document, and latestPolicies caches the
+	// *protocol.PolicyDocumentProof for the latest STR.
+	publishPolicies    bool
+	requireAttestation bool
+	extensions         map[string]*merkletree.STRExtension
+	latestPolicies     atomic.Value
+}
+
+// Limits bounds the size of a ConiksDirectory, in order to keep the
+// latency of its epoch updates in check. A limit of 0 is disabled.
+//
+// Register() rejects new registrations once the directory contains
+// MaxBindings bindings, or has accepted MaxRegistrationsPerEpoch
+// registrations in the latest epoch. Reaching a soft limit only
+// raises a LimitAlert (see SetLimits()).
+type Limits struct {
+	SoftMaxBindings              uint64
+	MaxBindings                  uint64
+	SoftMaxRegistrationsPerEpoch uint64
+	MaxRegistrationsPerEpoch     uint64
+}
+
+// These are the names of the limits reported in a LimitAlert.
+const (
+	LimitBindings              = "bindings"
+	LimitRegistrationsPerEpoch = "registrations per epoch"
+)
+
+// A LimitAlert is raised when a registration makes a ConiksDirectory
+// reach one of its soft limits. Value is the current value of the
+// limited quantity in the latest epoch Epoch.
+type LimitAlert struct {
+	Limit     string
+	Value     uint64
+	SoftLimit uint64
+	Epoch     uint64
 }
 
 // New constructs a new ConiksDirectory given the key server's PAD
@@ -39,14 +142,15 @@ type ConiksDirectory struct {
 // dirSize indicates the number of PAD snapshots the server keeps in memory.
 // useTBs indicates whether the key server returns TBs upon a successful
 // registration.
-func New(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
+func New(epDeadline protocol.Timestamp, vrfKey vrf.VRF,
 	signKey sign.PrivateKey, dirSize uint64, useTBs bool) *ConiksDirectory {
 	// FIXME: see #110
 	if !useTBs {
 		panic("Currently the server is forced to use TBs")
 	}
 	d := new(ConiksDirectory)
-	vrfPublicKey, ok := vrfKey.Public()
+	d.clock = utils.RealClock
+	vrfPublicKey, ok := vrfKey.PublicKey()
 	if !ok {
 		panic(vrf.ErrGetPubKey)
 	}
@@ -56,52 +160,564 @@ func New(epDeadline protocol.Timestamp, vrfKey vrf.PrivateKey,
 		panic(err)
 	}
 	d.pad = pad
+	d.cacheLatestSTR()
+	d.identity = identity(d.LatestSTR())
 	d.useTBs = useTBs
 	if useTBs {
 		d.tbs = make(map[string]*protocol.TemporaryBinding)
 	}
+	d.changes = make(map[string]*protocol.TemporaryBinding)
+	d.unsigned = make(map[string]bool)
 	return d
 }
 
+// Restore reconstructs a ConiksDirectory from the PAD checkpoint and
+// WAL persisted in db, and keeps persisting the directory's PAD to db,
+// writing a new checkpoint every checkpointInterval epochs.
+// The remaining parameters are the same as for New().
+// The TBs for all bindings and key changes pending inclusion in the
+// next snapshot are reissued, so that the restored directory keeps its
+// promises.
+//
+// A signing key rotation pending in db is resumed, in which case the
+// directory signs with the rotated keys instead of signKey (see
+// merkletree.RestorePAD()).
+//
+// Restore() returns merkletree.ErrNoCheckpoint if db doesn't contain a
+// checkpoint, merkletree.ErrBadCheckpoint if the restored PAD is
+// inconsistent with its latest STR, and ErrNoIdentity if db doesn't
+// record the directory's identity.
+func Restore(db kv.DB, checkpointInterval uint64, epDeadline protocol.Timestamp,
+	vrfKey vrf.VRF, signKey sign.PrivateKey, dirSize uint64,
+	useTBs bool) (*ConiksDirectory, error) {
+	// FIXME: see #110
+	if !useTBs {
+		panic("Currently the server is forced to use TBs")
+	}
+	vrfPublicKey, ok := vrfKey.PublicKey()
+	if !ok {
+		panic(vrf.ErrGetPubKey)
+	}
+	d := new(ConiksDirectory)
+	d.clock = utils.RealClock
+	d.policies = protocol.NewPolicies(epDeadline, vrfPublicKey)
+	pad, err := merkletree.RestorePAD(db, checkpointInterval, d.policies,
+		signKey, vrfKey, dirSize, encodePolicies, decodePolicies)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := db.Get(identityKey)
+	if err == db.ErrNotFound() {
+		return nil, ErrNoIdentity
+	} else if err != nil {
+		return nil, err
+	}
+	if len(buf) != len(d.identity) {
+		return nil, merkletree.ErrBadCheckpoint
+	}
+	copy(d.identity[:], buf)
+	d.pad = pad
+	// the restored tree keeps the hasher of the persisted STRs
+	d.policies.HashID = pad.Hasher().ID()
+	d.policies.CipherSuite = protocol.NewCipherSuite(d.policies)
+	// and the directory keeps committing to its bootstrap seed
+	d.policies.BootstrapHash = protocol.GetPolicies(pad.LatestSTR()).BootstrapHash
+	// the policies of a pending rotation declare the new signing key,
+	// and are already those of the PAD if the next STR is cross-signed
+	if pk, crossSigned := pad.PendingRotation(); pk != nil {
+		p := *d.policies
+		if crossSigned {
+			d.policies.NextSigningKey = pk
+		} else {
+			p.NextSigningKey = pk
+		}
+		d.policies = &p
+	}
+	d.cacheLatestSTR()
+	d.useTBs = useTBs
+	d.tbs = make(map[string]*protocol.TemporaryBinding)
+	d.changes = make(map[string]*protocol.TemporaryBinding)
+	d.unsigned = make(map[string]bool)
+	iter := db.NewIterator(kv.BytesPrefix(unsignedPrefix))
+	for ok := iter.First(); ok; ok = iter.Next() {
+		d.unsigned[string(iter.Key()[len(unsignedPrefix):])] = true
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	d.db = db
+	pad.VisitPending(func(name string, key []byte) {
+		ap, err := pad.Lookup(name)
+		switch {
+		case err != nil || !bytes.Equal(ap.LookupIndex, ap.Leaf.Index):
+			d.tbs[name] = d.NewTB(name, key)
+		case !bytes.Equal(ap.Leaf.Value, key):
+			d.changes[name] = d.NewKeyChangeTB(name, ap.Leaf.Value, key)
+		}
+		// otherwise, the pending binding is a rolled back key change
+	})
+	d.bindings = pad.Len()
+	return d, nil
+}
+
+// Persist enables the persistence of this ConiksDirectory's PAD to db.
+// The directory writes a new checkpoint of its PAD every
+// checkpointInterval epochs, and logs all changes made in between,
+// so that it can be reconstructed using Restore(). The database also
+// records the directory's identity (see Identity()).
+func (d *ConiksDirectory) Persist(db kv.DB, checkpointInterval uint64) error {
+	b := db.NewBatch()
+	b.Put(identityKey, d.identity[:])
+	for name := range d.unsigned {
+		b.Put(unsignedKey(name), nil)
+	}
+	if err := db.Write(b); err != nil {
+		return err
+	}
+	if err := d.pad.Persist(db, checkpointInterval, encodePolicies, decodePolicies); err != nil {
+		return err
+	}
+	d.db = db
+	return nil
+}
+
+// unsignedKey returns the database key recording that the key changes
+// of name don't need to be signed.
+func unsignedKey(name string) []byte {
+	return append(append([]byte{}, unsignedPrefix...), name...)
+}
+
+// PersistIdentity records the identity of the directory whose initial
+// STR is initSTR in its database db, which was written by a version
+// which didn't record it, so that the directory can be restored.
+func PersistIdentity(db kv.DB, initSTR *protocol.DirSTR) error {
+	if initSTR.Epoch != 0 {
+		return protocol.ErrMalformedMessage
+	}
+	id := identity(initSTR)
+	return db.Put(identityKey, id[:])
+}
+
+// Identity returns the directory's identity, i.e. the hash of its
+// initial STR, to which its TBs commit (see
+// protocol.TemporaryBinding.Serialize()).
+func (d *ConiksDirectory) Identity() [crypto.HashSizeByte]byte {
+	return d.identity
+}
+
+// identity returns the identity of the directory whose initial STR
+// is initSTR, as computed by auditor.ComputeDirectoryIdentity().
+func identity(initSTR *protocol.DirSTR) [crypto.HashSizeByte]byte {
+	var id [crypto.HashSizeByte]byte
+	copy(id[:], crypto.Digest(initSTR.Signature))
+	return id
+}
+
+func encodePolicies(ad merkletree.AssocData) ([]byte, error) {
+	return json.Marshal(ad)
+}
+
+func decodePolicies(buf []byte) (merkletree.AssocData, error) {
+	p := new(protocol.Policies)
+	if err := json.Unmarshal(buf, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 // Update creates a new PAD snapshot updating this ConiksDirectory.
 // Update() is called at the end of a CONIKS epoch. This implementation
 // also deletes all issued TBs for the ending epoch as their
-// corresponding mappings will have been inserted into the PAD.
-func (d *ConiksDirectory) Update() {
-	d.pad.Update(d.policies)
+// corresponding mappings will have been inserted into the PAD, which
+// commits the pending key changes. If the directory publishes its
+// policy document, the new STR commits to the document for the
+// policies it includes.
+//
+// If the directory is persisted and its database fails to store the
+// new STR, Update() returns the database's error, and the directory
+// keeps serving the STR of the previous epoch, along with the TBs and
+// pending key changes issued during this epoch (see
+// merkletree.PAD.Update()). The update can then be retried.
+func (d *ConiksDirectory) Update() error {
+	doc := d.commitPolicyDocument()
+	if err := d.pad.Update(d.policies); err != nil {
+		return err
+	}
+	// only the policies of the cross-signed STR hand over to the new key
+	if d.policies.NextSigningKey != nil {
+		p := *d.policies
+		p.NextSigningKey = nil
+		d.policies = &p
+	}
+	d.cacheLatestSTR()
+	if doc != nil {
+		d.cachePolicyDocument(doc)
+	}
+	d.registrations = 0
 	// clear issued temporary bindings
 	for key := range d.tbs {
 		delete(d.tbs, key)
 	}
+	for key := range d.changes {
+		delete(d.changes, key)
+	}
+	return nil
 }
 
 // SetPolicies sets this ConiksDirectory's epoch deadline, which will be used
 // in the next epoch.
 func (d *ConiksDirectory) SetPolicies(epDeadline protocol.Timestamp) {
-	d.policies = protocol.NewPolicies(epDeadline, d.policies.VrfPublicKey)
+	vrfPublicKey, err := d.policies.VrfVerifier()
+	if err != nil {
+		panic(err)
+	}
+	saltScheme := d.policies.SaltScheme
+	hashID := d.policies.HashID
+	bootstrapHash := d.policies.BootstrapHash
+	nextSigningKey := d.policies.NextSigningKey
+	d.policies = protocol.NewPolicies(epDeadline, vrfPublicKey)
+	d.policies.SaltScheme = saltScheme
+	d.policies.HashID = hashID
+	d.policies.CipherSuite = protocol.NewCipherSuite(d.policies)
+	d.policies.BootstrapHash = bootstrapHash
+	d.policies.NextSigningKey = nextSigningKey
+}
+
+// Bootstrap pre-populates this new ConiksDirectory with the reserved
+// bindings of seed (e.g., staff accounts, or the registration bot's own
+// key), and reissues the directory's initial STR, so that the bindings
+// are included at epoch 0. The policies of the initial STR, and of all
+// the following STRs, record the hash of the seed, so that the clients
+// and auditors holding the seed can verify the bootstrap set
+// (see protocol.BootstrapSeed.VerifyInitSTR()).
+//
+// The seed should have been verified against the directory's signing
+// key (see protocol.BootstrapSeed.Verify()), since its bindings bypass
+// the directory's registration policies. Bootstrap() must be called
+// before the directory is persisted and its initial STR is published,
+// and returns merkletree.ErrBootstrapped otherwise.
+func (d *ConiksDirectory) Bootstrap(seed *protocol.BootstrapSeed) error {
+	bindings := make(map[string][]byte, len(seed.Bindings))
+	for _, b := range seed.Bindings {
+		bindings[b.Username] = b.Key
+	}
+	p := *d.policies
+	p.BootstrapHash = seed.Hash()
+	if err := d.pad.Bootstrap(&p, bindings); err != nil {
+		return err
+	}
+	d.policies = &p
+	d.bindings = uint64(len(bindings))
+	d.cacheLatestSTR()
+	// the reissued initial STR identifies the directory
+	d.identity = identity(d.LatestSTR())
+	return nil
+}
+
+// SetSTRExtensions sets the extensions included in the STRs this
+// ConiksDirectory issues from the next epoch on
+// (see merkletree.STRExtension). The extension committing to the
+// directory's policy document is added to exts, if the directory
+// publishes it (see PublishPolicyDocument()).
+func (d *ConiksDirectory) SetSTRExtensions(exts map[string]*merkletree.STRExtension) {
+	d.extensions = exts
+	d.pad.SetSTRExtensions(exts)
+}
+
+// SetBeacon makes the next STR of this ConiksDirectory include the
+// randomness beacon value b (see protocol.BeaconExtension), along with
+// the extensions set by SetSTRExtensions(). A nil b removes the beacon
+// value, e.g., if the beacon is unavailable.
+func (d *ConiksDirectory) SetBeacon(b *protocol.BeaconValue) {
+	exts := make(map[string]*merkletree.STRExtension, len(d.extensions)+1)
+	for name, ext := range d.extensions {
+		if name != protocol.BeaconExtension {
+			exts[name] = ext
+		}
+	}
+	if b != nil {
+		exts[protocol.BeaconExtension] = &merkletree.STRExtension{
+			Value: b.Serialize(),
+		}
+	}
+	d.SetSTRExtensions(exts)
+}
+
+// SetSaltKey makes this ConiksDirectory derive the commitment salts of
+// all bindings registered from now on from the master secret key
+// (see merkletree.PAD.SetSaltKey()), so that the salts can be
+// recomputed for disaster recovery and audited by the key server.
+// The salt scheme is recorded in the policies of the next epoch.
+func (d *ConiksDirectory) SetSaltKey(key []byte) {
+	d.pad.SetSaltKey(key)
+	p := *d.policies
+	p.SaltScheme = crypto.SaltPRFID
+	d.policies = &p
+}
+
+// SetHashSize makes this ConiksDirectory truncate the hashes of its
+// tree's nodes to size bytes along with its next policies, keeping the
+// hash algorithm declared in these policies (see SetHasher()), and
+// trading the collision resistance of the hashes for smaller
+// authentication paths. SetHashSize() returns crypto.ErrUnsupportedHash
+// if size is less than crypto.MinHashSizeByte or greater than
+// crypto.HashSizeByte.
+func (d *ConiksDirectory) SetHashSize(size int) error {
+	h, err := d.policies.Hasher()
+	if err != nil {
+		return err
+	}
+	h, err = hashers.WithSize(h, size)
+	if err != nil {
+		return err
+	}
+	return d.SetHasher(h.ID())
+}
+
+// SetHasher makes this ConiksDirectory hash its tree's nodes with the
+// hasher identified by id, e.g., "SHA256" or "BLAKE2b/128", along with
+// its next policies (see merkletree.PAD.SetHasher()). The hasher is
+// recorded in the HashID and in the cipher suite of the policies, so
+// that the clients verify the authentication paths with it
+// (see protocol.Policies.Hasher()).
+// SetHasher() returns crypto.ErrUnsupportedHash if id doesn't identify
+// a registered hasher (see hashers.NewPADHasher()).
+func (d *ConiksDirectory) SetHasher(id string) error {
+	h, err := hashers.NewPADHasher(id)
+	if err != nil {
+		return err
+	}
+	d.pad.SetHasher(h)
+	p := *d.policies
+	p.HashID = h.ID()
+	p.CipherSuite = protocol.NewCipherSuite(&p)
+	d.policies = &p
+	return nil
+}
+
+// RotateSigningKey makes this ConiksDirectory hand the signing of its
+// STRs over to key along with its next policies, which declare the
+// new public key (see protocol.Policies.NextSigningKey): the STR
+// including these policies is signed with both the current signing key
+// and key, and the following STRs and temporary bindings are only
+// signed with key (see merkletree.PAD.RotateSigningKey()). The clients
+// and auditors verifying this STR learn the new key from it
+// (see protocol.DirSTR.VerifyHandover()), so they don't have to pin it
+// out of band. If the directory is persisted, the rotation is stored
+// in its database, and a restored directory resumes the handover (see
+// Restore()).
+//
+// RotateSigningKey() returns merkletree.ErrRotationPending if the
+// directory is still handing over to the key of a previous rotation,
+// or the database's error if the rotation cannot be stored.
+func (d *ConiksDirectory) RotateSigningKey(key sign.PrivateKey) error {
+	pk, _ := key.Public()
+	if err := d.pad.RotateSigningKey(key); err != nil {
+		return err
+	}
+	p := *d.policies
+	p.NextSigningKey = pk
+	d.policies = &p
+	return nil
+}
+
+// SetHashWorkers makes this ConiksDirectory hash its tree with up to
+// n goroutines at each epoch update (see
+// merkletree.PAD.SetHashWorkers()). A n less than 2 restores the
+// sequential hashing.
+func (d *ConiksDirectory) SetHashWorkers(n int) {
+	d.pad.SetHashWorkers(n)
+}
+
+// AuditSalts calls f for each username whose commitment salt in the
+// pending version of this ConiksDirectory wasn't derived from the salt
+// key set by SetSaltKey(), along with the epoch in which the username's
+// binding was included.
+func (d *ConiksDirectory) AuditSalts(f func(name string, epoch uint64)) {
+	d.pad.AuditSalts(f)
+}
+
+// SetLimits sets the limits on the size of this ConiksDirectory.
+// alert is called, if it is non-nil, each time a registration makes the
+// directory reach one of its soft limits. alert is called synchronously
+// from Register(), so it shouldn't block.
+func (d *ConiksDirectory) SetLimits(limits Limits, alert func(*LimitAlert)) {
+	d.limits = limits
+	d.onLimit = alert
+}
+
+// Bindings returns the number of bindings in this ConiksDirectory,
+// including the registrations pending inclusion in the next snapshot.
+func (d *ConiksDirectory) Bindings() uint64 {
+	return d.bindings
+}
+
+// checkLimits returns whether a new registration would exceed one of
+// this ConiksDirectory's hard limits.
+func (d *ConiksDirectory) checkLimits() bool {
+	return (d.limits.MaxBindings == 0 || d.bindings < d.limits.MaxBindings) &&
+		(d.limits.MaxRegistrationsPerEpoch == 0 ||
+			d.registrations < d.limits.MaxRegistrationsPerEpoch)
+}
+
+// alertLimits raises a LimitAlert for each soft limit which the latest
+// registration has made this ConiksDirectory reach.
+func (d *ConiksDirectory) alertLimits() {
+	if d.onLimit == nil {
+		return
+	}
+	epoch := d.LatestSTR().Epoch
+	if d.limits.SoftMaxBindings > 0 && d.bindings == d.limits.SoftMaxBindings {
+		d.onLimit(&LimitAlert{LimitBindings, d.bindings,
+			d.limits.SoftMaxBindings, epoch})
+	}
+	if d.limits.SoftMaxRegistrationsPerEpoch > 0 &&
+		d.registrations == d.limits.SoftMaxRegistrationsPerEpoch {
+		d.onLimit(&LimitAlert{LimitRegistrationsPerEpoch, d.registrations,
+			d.limits.SoftMaxRegistrationsPerEpoch, epoch})
+	}
+}
+
+// SetAttestationKeys sets the public keys of the account verification
+// bots whose attestations this ConiksDirectory accepts, indexed by
+// the suffix of the usernames each bot verifies (e.g., "@twitter").
+// If several suffixes match a username, the longest one is used.
+// If the directory maps its usernames into hierarchical namespaces,
+// the bot trusted for the suffix "@example.org" holds the registration
+// authority for the namespace of the provider "example.org": the
+// directory rejects the registrations of this namespace which it
+// doesn't attest.
+func (d *ConiksDirectory) SetAttestationKeys(keys map[string]sign.PublicKey) {
+	d.attestationKeys = keys
+}
+
+// verifyAttestation checks that the attestation included in req is
+// fresh and signed by the bot trusted for req.Username, which is the
+// authority of the name policy governing req.Username, if any.
+func (d *ConiksDirectory) verifyAttestation(req *protocol.RegistrationRequest) bool {
+	if _, ok := d.namePolicies.Match(req.Username); ok {
+		return d.namePolicies.VerifyAttestation(req.Attestation,
+			req.Username, d.clock.Now())
+	}
+	var key sign.PublicKey
+	matched := -1
+	for suffix, k := range d.attestationKeys {
+		if strings.HasSuffix(req.Username, suffix) && len(suffix) > matched {
+			key, matched = k, len(suffix)
+		}
+	}
+	return req.Attestation.Verify(key, req.Username, d.clock.Now())
+}
+
+// delegates returns true if this ConiksDirectory maps its usernames into
+// hierarchical namespaces (see protocol.NewNamespacedVRF()), and has
+// delegated the registrations of the namespace of name's provider to
+// the provider, i.e., it trusts a bot for the suffix "@" followed by
+// the provider's name (see SetAttestationKeys()).
+func (d *ConiksDirectory) delegates(name string) bool {
+	provider := protocol.Provider(name)
+	if d.policies.NamespaceBits == 0 || provider == "" {
+		return false
+	}
+	_, ok := d.attestationKeys["@"+provider]
+	return ok
+}
+
+// SetIdentifierPolicies sets the policies restricting the registrations
+// of each identifier type (see protocol.IdentifierType). The types
+// without a policy are accepted.
+func (d *ConiksDirectory) SetIdentifierPolicies(policies map[protocol.IdentifierType]protocol.IdentifierPolicy) {
+	d.idPolicies = policies
+}
+
+// SetNamePolicies sets the name policies of this ConiksDirectory,
+// which reserve the usernames ending in each policy's suffix to the
+// account verification bot of the policy: the directory rejects the
+// registrations of these usernames which don't include an attestation
+// signed by this bot, regardless of the bots trusted for the suffix
+// (see SetAttestationKeys()) and of the address through which they
+// are sent.
+func (d *ConiksDirectory) SetNamePolicies(policies protocol.NamePolicies) {
+	d.namePolicies = policies
 }
 
 // EpochDeadline returns this ConiksDirectory's latest epoch deadline
 // as a timestamp.
 func (d *ConiksDirectory) EpochDeadline() protocol.Timestamp {
-	return protocol.GetPolicies(d.pad.LatestSTR()).EpochDeadline
+	return d.LatestSTR().Policies.EpochDeadline
+}
+
+// NextPolicies returns the policies which the next STR of this
+// ConiksDirectory will include. The returned policies must not be
+// modified.
+func (d *ConiksDirectory) NextPolicies() *protocol.Policies {
+	return d.policies
 }
 
 // LatestSTR returns this ConiksDirectory's latest STR.
+// LatestSTR() is safe to call concurrently with Update().
+// The returned STR must not be modified.
 func (d *ConiksDirectory) LatestSTR() *protocol.DirSTR {
-	return protocol.NewDirSTR(d.pad.LatestSTR())
+	return d.latestSTR.Load().(*protocol.DirSTR)
+}
+
+// cacheLatestSTR swaps the cached latest STR with the STR of
+// the PAD's latest snapshot, and records the time it was issued.
+func (d *ConiksDirectory) cacheLatestSTR() {
+	d.latestSTR.Store(protocol.NewDirSTR(d.pad.LatestSTR()))
+	d.issuedAt = d.clock.Now()
+}
+
+// SetClock sets the clock this ConiksDirectory uses to keep track of
+// its epochs, e.g. a utils.FakeClock in tests. The latest STR is
+// considered to be issued at the clock's current time.
+func (d *ConiksDirectory) SetClock(clock utils.Clock) {
+	d.clock = clock
+	d.issuedAt = clock.Now()
+}
+
+// NextEpoch returns the time by which this ConiksDirectory is expected
+// to issue its next STR, i.e. the time at which the latest STR was
+// issued plus the latest STR's epoch deadline.
+func (d *ConiksDirectory) NextEpoch() time.Time {
+	return d.issuedAt.Add(time.Duration(d.EpochDeadline()) * time.Second)
 }
 
 // NewTB creates a new temporary binding for the given name-to-key mapping.
 // NewTB() computes the private index for the name, and
-// digitally signs the (index, key, latest STR signature) tuple.
+// digitally signs the (directory identity, latest STR signature, index,
+// key, issued epoch, inclusion epoch) tuple. The TB is issued in the latest epoch, and
+// promises the binding's inclusion in the snapshot of the next epoch.
 func (d *ConiksDirectory) NewTB(name string, key []byte) *protocol.TemporaryBinding {
-	index := d.pad.Index(name)
-	return &protocol.TemporaryBinding{
-		Index:     index,
-		Value:     key,
-		Signature: d.pad.Sign(d.LatestSTR().Signature, index, key),
+	str := d.LatestSTR()
+	tb := &protocol.TemporaryBinding{
+		Index:          d.pad.Index(name),
+		Value:          key,
+		IssuedEpoch:    str.Epoch,
+		InclusionEpoch: str.Epoch + 1,
+		DirInitSTRHash: d.identity,
 	}
+	tb.Signature = d.pad.Sign(tb.Serialize(str.Signature))
+	return tb
+}
+
+// NewKeyChangeTB creates a new temporary binding for the change of the
+// key bound to name from old to key. Like NewTB(), it promises the
+// change in the snapshot of the next epoch, unless the change is
+// aborted in the meantime (see AbortKeyChange()).
+func (d *ConiksDirectory) NewKeyChangeTB(name string, old, key []byte) *protocol.TemporaryBinding {
+	str := d.LatestSTR()
+	tb := &protocol.TemporaryBinding{
+		Index:          d.pad.Index(name),
+		Value:          key,
+		IssuedEpoch:    str.Epoch,
+		InclusionEpoch: str.Epoch + 1,
+		PreviousValue:  old,
+		DirInitSTRHash: d.identity,
+	}
+	tb.Signature = d.pad.Sign(tb.Serialize(str.Signature))
+	return tb
 }
 
 // Register inserts the username-to-key mapping contained in a
@@ -110,9 +726,18 @@ func (d *ConiksDirectory) NewTB(name string, key []byte) *protocol.TemporaryBind
 // The response (which also includes the error code) is supposed to
 // be sent back to the client.
 //
-// A request without a username or without a public key is considered
+// A request without a username or without a public key, with the
+// protocol.Tombstone or a frozen value (see protocol.IsFrozen()) as key,
+// with an invalid or expired profile (see profile.Profile.Verify())
+// as key, or whose username isn't the canonical form of an identifier
+// (see protocol.ParseCanonicalIdentifier()), is considered
 // malformed, and causes Register() to return a
 // message.NewErrorResponse(ErrMalformedMessage).
+// If the directory doesn't accept identifiers of this type, or requires
+// an attestation for them which req doesn't include
+// (see SetIdentifierPolicies()), Register() returns a
+// message.NewErrorResponse(ReqIDTypeDisabled), or a
+// message.NewErrorResponse(ReqMissingAttestation), respectively.
 // Register() inserts the new mapping in req
 // into a pending version of the directory so it can be included in the
 // snapshot taken at the end of the latest epoch, and returns a
@@ -120,17 +745,51 @@ func (d *ConiksDirectory) NewTB(name string, key []byte) *protocol.TemporaryBind
 // if this operation succeeds.
 // Otherwise, if the username already exists, Register() returns a
 // message.NewRegistrationProof(ap=proof of inclusion, str, nil,
-// ReqNameExisted). ap will be a proof of absence with a non-nil
-// TB, if the username is still pending inclusion in the next directory
-// snapshot.
+// ReqNameExisted), even if its binding has been deactivated, so that
+// a retired username is never registered again. ap will be a proof of
+// absence with a non-nil TB, if the username is still pending
+// inclusion in the next directory snapshot.
 // In any case, str is the signed tree root for the latest epoch.
+// If req includes an attestation, Register() verifies that it is fresh
+// and signed by the bot trusted for the username
+// (see SetAttestationKeys()), and returns a
+// message.NewErrorResponse(ReqBadAttestation) otherwise. If the
+// directory has delegated the registrations of the username's namespace
+// to its provider, Register() returns a
+// message.NewErrorResponse(ReqMissingAttestation) if req doesn't
+// include an attestation. The same holds for the usernames governed by
+// a name policy (see SetNamePolicies()), whose attestation must be
+// signed by the policy's authority.
+// If registering the new mapping would exceed one of the directory's
+// hard limits (see SetLimits()), Register() returns a
+// message.NewErrorResponse(ReqLimitExceeded).
 // If Register() encounters an internal error at any point, it returns
 // a message.NewErrorResponse(ErrDirectory).
 func (d *ConiksDirectory) Register(req *protocol.RegistrationRequest) *protocol.Response {
 	// make sure the request is well-formed
-	if len(req.Username) <= 0 || len(req.Key) <= 0 {
+	if len(req.Username) <= 0 || !d.validKey(req.Key) {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	id, err := protocol.ParseCanonicalIdentifier(req.Username)
+	if err != nil {
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
+	policy := d.idPolicies[id.Type]
+	if policy.Disabled {
+		return protocol.NewErrorResponse(protocol.ReqIDTypeDisabled)
+	}
+	if policy.RequireAttestation && req.Attestation == nil {
+		return protocol.NewErrorResponse(protocol.ReqMissingAttestation)
+	}
+	if req.Attestation != nil && !d.verifyAttestation(req) {
+		return protocol.NewErrorResponse(protocol.ReqBadAttestation)
+	}
+	if req.Attestation == nil && d.delegates(req.Username) {
+		return protocol.NewErrorResponse(protocol.ReqMissingAttestation)
+	}
+	if _, ok := d.namePolicies.Match(req.Username); ok && req.Attestation == nil {
+		return protocol.NewErrorResponse(protocol.ReqMissingAttestation)
+	}
 
 	// check whether the name already exists
 	// in the directory before we register
@@ -153,16 +812,209 @@ func (d *ConiksDirectory) Register(req *protocol.RegistrationRequest) *protocol.
 		tb = d.NewTB(req.Username, req.Key)
 	}
 
-	if err = d.pad.Set(req.Username, req.Key); err != nil {
+	if !d.checkLimits() {
+		return protocol.NewErrorResponse(protocol.ReqLimitExceeded)
+	}
+	// record that the key changes don't need to be signed
+	// in the same write as the registration
+	var b kv.Batch
+	if req.AllowUnsignedKeychange && d.db != nil {
+		b = d.db.NewBatch()
+		b.Put(unsignedKey(req.Username), nil)
+	}
+	if err = d.pad.SetWith(req.Username, req.Key, b); err != nil {
 		return protocol.NewErrorResponse(protocol.ErrDirectory)
 	}
+	if req.AllowUnsignedKeychange {
+		d.unsigned[req.Username] = true
+	}
 
 	if tb != nil {
 		d.tbs[req.Username] = tb
 	}
+	d.bindings++
+	d.registrations++
+	d.alertLimits()
 	return protocol.NewRegistrationProof(ap, d.LatestSTR(), tb, protocol.ReqSuccess)
 }
 
+// RegisterWithAttestation is like Register(), but rejects the
+// registrations which don't include an attestation by an account
+// verification bot with a message.NewErrorResponse(ReqMissingAttestation).
+// It handles the registrations clients send directly to the key server
+// after obtaining an attestation from a bot running in detached mode.
+func (d *ConiksDirectory) RegisterWithAttestation(req *protocol.RegistrationRequest) *protocol.Response {
+	if req.Attestation == nil {
+		return protocol.NewErrorResponse(protocol.ReqMissingAttestation)
+	}
+	return d.Register(req)
+}
+
+// KeyChange changes the key bound to the username indicated in the
+// KeyChangeRequest req received from a CONIKS client to the new key
+// in req, and returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A request without a username or without a key, with the
+// protocol.Tombstone, a frozen value or an invalid or expired profile
+// as key, or whose username isn't
+// the canonical form of an identifier, is considered malformed, and causes
+// KeyChange() to return a
+// message.NewErrorResponse(ErrMalformedMessage). Unless the username
+// was registered with AllowUnsignedKeychange, a request whose
+// signature doesn't verify with the key the username is bound to in
+// the latest snapshot (see protocol.KeyChangeRequest.Verify()) is
+// also considered malformed.
+// If the username isn't included in the latest directory snapshot,
+// KeyChange() returns a message.NewKeyChangeProof(ap=proof of absence,
+// str, nil, ReqNameNotFound).
+// If the binding of the username has been deactivated (see
+// Deactivate()), KeyChange() returns a
+// message.NewKeyChangeProof(ap=proof of inclusion, str, nil,
+// ReqNameDeactivated), and if the directory's operator has frozen it
+// (see Freeze()), a message.NewKeyChangeProof(ap=proof of inclusion,
+// str, nil, ReqNameFrozen).
+// If a change is already pending for the username, since the directory
+// allows only one key change per epoch, KeyChange() returns a
+// message.NewKeyChangeProof(ap=proof of inclusion, str, tb, ReqNameExisted),
+// where tb is the TB of the pending change.
+// Otherwise, KeyChange() sets the new key in the pending version of
+// the directory, and returns a message.NewKeyChangeProof(ap=proof of
+// inclusion, str, tb, ReqSuccess), where tb promises the change in the
+// next snapshot (see NewKeyChangeTB()). The change remains pending, and
+// can be aborted using the previous key, until the end of the latest
+// epoch.
+// In any case, str is the signed tree root for the latest epoch.
+// If KeyChange() encounters an internal error at any point, it returns
+// a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) KeyChange(req *protocol.KeyChangeRequest) *protocol.Response {
+	// make sure the request is well-formed
+	if !d.validKey(req.Key) {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	return d.changeKey(req.Username, req.Key, req.Verify)
+}
+
+// validKey returns whether key can be bound to a username by a user:
+// key must be non-empty, must be neither the protocol.Tombstone nor
+// a frozen value, and, if it is a profile (see profile.Profile), must
+// be well-formed, signed by its master key and unexpired.
+func (d *ConiksDirectory) validKey(key []byte) bool {
+	if len(key) == 0 || protocol.IsTombstone(key) || protocol.IsFrozen(key) {
+		return false
+	}
+	if profile.IsProfile(key) {
+		p, err := profile.Parse(key)
+		return err == nil && p.Verify(d.clock.Now()) == nil
+	}
+	return true
+}
+
+// Deactivate deactivates the binding of the username indicated in the
+// DeactivationRequest req received from a CONIKS client, i.e., changes
+// the key bound to the username to the protocol.Tombstone, and returns
+// a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A deactivation is a key change to the protocol.Tombstone, and
+// follows the semantics of KeyChange(): unless the username was
+// registered with AllowUnsignedKeychange, req must be signed with the
+// key the username is bound to in the latest snapshot (see
+// protocol.DeactivationRequest.Verify()), and the deactivation remains
+// pending, and can be aborted using this key (see AbortKeyChange()),
+// until the end of the latest epoch. Once the deactivation is included
+// in a snapshot, the username can't be registered or changed anymore.
+func (d *ConiksDirectory) Deactivate(req *protocol.DeactivationRequest) *protocol.Response {
+	return d.changeKey(req.Username, protocol.Tombstone, req.Verify)
+}
+
+// changeKey changes the key bound to uname to key if the request
+// verifies with the key uname is currently bound to (see KeyChange()).
+func (d *ConiksDirectory) changeKey(uname string, key []byte,
+	verify func(previous []byte) bool) *protocol.Response {
+	if len(uname) <= 0 {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	if _, err := protocol.ParseCanonicalIdentifier(uname); err != nil {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	ap, err := d.pad.Lookup(uname)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	if !bytes.Equal(ap.LookupIndex, ap.Leaf.Index) {
+		return protocol.NewKeyChangeProof(ap, d.LatestSTR(), nil, protocol.ReqNameNotFound)
+	}
+	if protocol.IsTombstone(ap.Leaf.Value) {
+		return protocol.NewKeyChangeProof(ap, d.LatestSTR(), nil, protocol.ReqNameDeactivated)
+	}
+	if protocol.IsFrozen(ap.Leaf.Value) {
+		return protocol.NewKeyChangeProof(ap, d.LatestSTR(), nil, protocol.ReqNameFrozen)
+	}
+	if !d.unsigned[uname] && !verify(ap.Leaf.Value) {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	if tb := d.changes[uname]; tb != nil {
+		return protocol.NewKeyChangeProof(ap, d.LatestSTR(), tb, protocol.ReqNameExisted)
+	}
+
+	tb := d.NewKeyChangeTB(uname, ap.Leaf.Value, key)
+	if err := d.pad.Set(uname, key); err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	d.changes[uname] = tb
+	return protocol.NewKeyChangeProof(ap, d.LatestSTR(), tb, protocol.ReqSuccess)
+}
+
+// AbortKeyChange rolls back the pending key change for the username
+// indicated in the KeyChangeAbortRequest req received from a CONIKS
+// client, and returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A request without a username, or whose signature doesn't verify
+// with the key the username is bound to in the latest snapshot, is
+// considered malformed, and causes AbortKeyChange() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// If no key change is pending for the username, e.g., because the
+// change has already been included in the latest snapshot,
+// AbortKeyChange() returns a message.NewErrorResponse(ReqNoPendingChange).
+// The freezes and unfreezes of the directory's operator can't be
+// aborted by the user (see Freeze()): AbortKeyChange() returns
+// a message.NewErrorResponse(ReqNameFrozen) for them.
+// Otherwise, AbortKeyChange() restores the previous key in the pending
+// version of the directory, and returns a message.NewKeyChangeProof(
+// ap=proof of inclusion, str, nil, ReqSuccess), where str is the signed
+// tree root for the latest epoch.
+// If AbortKeyChange() encounters an internal error at any point, it
+// returns a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) AbortKeyChange(req *protocol.KeyChangeAbortRequest) *protocol.Response {
+	if len(req.Username) <= 0 {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	tb := d.changes[req.Username]
+	if tb == nil {
+		return protocol.NewErrorResponse(protocol.ReqNoPendingChange)
+	}
+	if protocol.IsFrozen(tb.Value) || protocol.IsFrozen(tb.PreviousValue) {
+		return protocol.NewErrorResponse(protocol.ReqNameFrozen)
+	}
+	if !req.Verify(tb) {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	ap, err := d.pad.Lookup(req.Username)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	if err := d.pad.Set(req.Username, tb.PreviousValue); err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	delete(d.changes, req.Username)
+	return protocol.NewKeyChangeProof(ap, d.LatestSTR(), nil, protocol.ReqSuccess)
+}
+
 // KeyLookup gets the public key for the username indicated in the
 // KeyLookupRequest req received from a CONIKS client from the latest
 // snapshot of this ConiksDirectory, and returns a protocol.Response.
@@ -179,11 +1031,11 @@ func (d *ConiksDirectory) Register(req *protocol.RegistrationRequest) *protocol.
 // Otherwise, KeyLookup() returns a message.NewKeyLookupProof(ap=proof of
 // absence, str, tb, ReqSuccess) if there is a corresponding TB for
 // the username, but there isn't an entry in the directory yet, and a
-// a message.NewKeyLookupProof(ap=proof of inclusion, str, nil, ReqSuccess)
-// if there is.
-// In any case, str is the signed tree root for the latest epoch.
-// If KeyLookup() encounters an internal error at any point, it returns
-// a message.NewErrorResponse(ErrDirectory).
+// a message.NewKeyLookupProof(ap=proof of inclusion, str, tb, ReqSuccess)
+// if there is, where tb is the TB of the key change pending for the
+// username, if any, so that the user can contest the change in time.
+// In any case, str is the signed tree root for the latest epoch, and
+// ap is looked up in the snapshot it commits to.
 func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Response {
 
 	// make sure the request is well-formed
@@ -191,21 +1043,70 @@ func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Re
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
 
-	ap, err := d.pad.Lookup(req.Username)
-	if err != nil {
-		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	str := d.LatestSTR()
+	ap := d.pad.LookupInSTR(req.Username, str.SignedTreeRoot)
+	return newKeyLookupProof(req.Username, ap, str, d.tbs, d.changes)
+}
+
+// BatchKeyLookup gets the public keys for the usernames indicated in
+// the BatchKeyLookupRequest req received from a CONIKS client from the
+// latest snapshot of this ConiksDirectory, and returns
+// a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A request without usernames, with more than protocol.MaxBatchSize
+// usernames or with an empty username is considered malformed, and
+// causes BatchKeyLookup() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// Otherwise, BatchKeyLookup() returns
+// a message.NewBatchKeyLookupProof(ap, str, tb, e), where ap, tb and
+// e contain the authentication path, the TB and the error code of the
+// lookup of each username, in order, as KeyLookup() would return them,
+// and str is the signed tree root for the latest epoch, which all the
+// lookups share.
+func (d *ConiksDirectory) BatchKeyLookup(req *protocol.BatchKeyLookupRequest) *protocol.Response {
+	return batchKeyLookup(req.Usernames, d.pad, d.LatestSTR(), d.tbs, d.changes)
+}
+
+// batchKeyLookup creates the response to a batched key lookup for
+// unames in the snapshot of str of pad, given the TBs issued in str's
+// epoch tbs and the TBs of the pending key changes changes (see
+// BatchKeyLookup()).
+func batchKeyLookup(unames []string, pad *merkletree.PAD, str *protocol.DirSTR,
+	tbs, changes map[string]*protocol.TemporaryBinding) *protocol.Response {
+	if len(unames) == 0 || len(unames) > protocol.MaxBatchSize {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	aps := make([]*merkletree.AuthenticationPath, len(unames))
+	promises := make([]*protocol.TemporaryBinding, len(unames))
+	errs := make([]protocol.ErrorCode, len(unames))
+	for i, uname := range unames {
+		if len(uname) <= 0 {
+			return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+		}
+		aps[i] = pad.LookupInSTR(uname, str.SignedTreeRoot)
+		res := newKeyLookupProof(uname, aps[i], str, tbs, changes)
+		promises[i] = res.DirectoryProof().TB
+		errs[i] = res.Error
 	}
+	return protocol.NewBatchKeyLookupProof(aps, str, promises, errs)
+}
 
+// newKeyLookupProof creates the response to a key lookup for uname,
+// given the authentication path ap in the snapshot of str, the
+// TBs issued in str's epoch tbs and the TBs of the pending key
+// changes changes (see KeyLookup()).
+func newKeyLookupProof(uname string, ap *merkletree.AuthenticationPath,
+	str *protocol.DirSTR, tbs, changes map[string]*protocol.TemporaryBinding) *protocol.Response {
 	if bytes.Equal(ap.LookupIndex, ap.Leaf.Index) {
-		return protocol.NewKeyLookupProof(ap, d.LatestSTR(), nil, protocol.ReqSuccess)
+		return protocol.NewKeyLookupProof(ap, str, changes[uname], protocol.ReqSuccess)
 	}
 	// if not found in the tree, do lookup in tb array
-	if d.useTBs {
-		if tb := d.tbs[req.Username]; tb != nil {
-			return protocol.NewKeyLookupProof(ap, d.LatestSTR(), tb, protocol.ReqSuccess)
-		}
+	if tb := tbs[uname]; tb != nil {
+		return protocol.NewKeyLookupProof(ap, str, tb, protocol.ReqSuccess)
 	}
-	return protocol.NewKeyLookupProof(ap, d.LatestSTR(), nil, protocol.ReqNameNotFound)
+	return protocol.NewKeyLookupProof(ap, str, nil, protocol.ReqNameNotFound)
 }
 
 // KeyLookupInEpoch gets the public key for the username for a prior
@@ -222,12 +1123,17 @@ func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Re
 // If the username doesn't have an entry in the directory
 // snapshot for the indicated epoch, KeyLookupInEpoch()
 // returns a message.NewKeyLookupInEpochProof(ap=proof of absence, str,
-// ReqNameNotFound).
+// next, ReqNameNotFound).
 // Otherwise, KeyLookupInEpoch() returns a
-// message.NewKeyLookupInEpochProof(ap=proof of inclusion, str, ReqSuccess).
+// message.NewKeyLookupInEpochProof(ap=proof of inclusion, str, next,
+// ReqSuccess).
 // In either case, str is a list of STRs for the epoch range [ep,
 // d.LatestSTR().Epoch], where ep is the past epoch for which
-// the client has requested the user's key.
+// the client has requested the user's key. If these STRs would exceed
+// protocol.MaxResponseSize, str ends at the last STR which fits, and
+// next continues the range at the following epoch, from which the
+// client fetches the remaining STRs, e.g., by monitoring; otherwise,
+// next is nil.
 // KeyLookupInEpoch() proofs do not include temporary bindings since
 // the TB corresponding to a registered binding is discarded at the time
 // the binding is included in a directory snapshot.
@@ -236,28 +1142,234 @@ func (d *ConiksDirectory) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Re
 func (d *ConiksDirectory) KeyLookupInEpoch(req *protocol.KeyLookupInEpochRequest) *protocol.Response {
 
 	// make sure the request is well-formed
+	latest := d.LatestSTR().Epoch
 	if len(req.Username) <= 0 ||
-		req.Epoch > d.LatestSTR().Epoch {
+		req.Epoch > latest {
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
 
 	var strs []*protocol.DirSTR
 	startEp := req.Epoch
-	endEp := d.LatestSTR().Epoch
+	endEp := latest
 
 	ap, err := d.pad.LookupInEpoch(req.Username, startEp)
 	if err != nil {
 		return protocol.NewErrorResponse(protocol.ErrDirectory)
 	}
+	var next *protocol.Continuation
+	budget := protocol.NewResponseBudget()
+	budget.Spend(ap)
 	for ep := startEp; ep <= endEp; ep++ {
 		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
+		if ep > startEp && !budget.Spend(str) {
+			next = &protocol.Continuation{NextEpoch: ep}
+			break
+		}
 		strs = append(strs, str)
 	}
 
+	e := protocol.ReqNameNotFound
 	if bytes.Equal(ap.LookupIndex, ap.Leaf.Index) {
-		return protocol.NewKeyLookupInEpochProof(ap, strs, protocol.ReqSuccess)
+		e = protocol.ReqSuccess
+	}
+	return protocol.NewKeyLookupInEpochProof(ap, strs, next, e)
+}
+
+// ProveEmptyRange gets a proof that the tree of this ConiksDirectory
+// doesn't contain any leaf whose lookup index starts with the prefix
+// indicated in the EmptyRangeRequest req, at the epoch req.Epoch, and
+// returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the auditor or client.
+//
+// A request with an empty prefix, or a prefix longer than
+// merkletree.MaxRangePrefixBits or than its bytes, or with a future epoch, is considered malformed, and
+// causes ProveEmptyRange() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// ProveEmptyRange() returns a message.NewErrorResponse(ReqRangeNotEmpty)
+// if the tree contains a leaf within the range, and a
+// message.NewErrorResponse(ErrDirectory) if the snapshot of the
+// requested epoch is no longer available.
+// Otherwise, ProveEmptyRange() returns a
+// message.NewEmptyRangeProof(proof, strs, next), where proof is the
+// proof for req.Epoch and strs is the list of STRs for the epoch range
+// [req.Epoch, d.LatestSTR().Epoch], cut short at next if it would
+// exceed protocol.MaxResponseSize.
+func (d *ConiksDirectory) ProveEmptyRange(req *protocol.EmptyRangeRequest) *protocol.Response {
+	latest := d.LatestSTR().Epoch
+	if req.Epoch > latest {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	proof, err := d.pad.GetEmptyRangeInEpoch(req.Prefix, req.PrefixBits, req.Epoch)
+	switch err {
+	case nil:
+	case merkletree.ErrMalformedRange:
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	case merkletree.ErrRangeNotEmpty:
+		return protocol.NewErrorResponse(protocol.ReqRangeNotEmpty)
+	default:
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+
+	var strs []*protocol.DirSTR
+	var next *protocol.Continuation
+	budget := protocol.NewResponseBudget()
+	budget.Spend(proof)
+	for ep := req.Epoch; ep <= latest; ep++ {
+		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
+		if ep > req.Epoch && !budget.Spend(str) {
+			next = &protocol.Continuation{NextEpoch: ep}
+			break
+		}
+		strs = append(strs, str)
 	}
-	return protocol.NewKeyLookupInEpochProof(ap, strs, protocol.ReqNameNotFound)
+	return protocol.NewEmptyRangeProof(proof, strs, next)
+}
+
+// ProveSubtree gets the proof of the subtree of the namespace of the
+// provider indicated in the SubtreeRequest req in the tree of this
+// ConiksDirectory at the epoch req.Epoch, and returns
+// a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the provider, auditor or client.
+//
+// A request without a provider, or for a provider whose name contains
+// an "@", or with a future epoch, or for an epoch in which the directory
+// didn't map its usernames into namespaces (see
+// protocol.NewNamespacedVRF()), is
+// considered malformed, and causes ProveSubtree() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// ProveSubtree() returns a message.NewErrorResponse(ErrDirectory) if the
+// snapshot of the requested epoch is no longer available.
+// Otherwise, ProveSubtree() returns a message.NewSubtreeProof(proof,
+// index, vrfProof, strs, next), where proof is the proof for req.Epoch,
+// index and vrfProof are the VRF output for the provider's name and its
+// proof, and strs is the list of STRs for the epoch range
+// [req.Epoch, d.LatestSTR().Epoch], cut short at next if it would
+// exceed protocol.MaxResponseSize.
+func (d *ConiksDirectory) ProveSubtree(req *protocol.SubtreeRequest) *protocol.Response {
+	latest := d.LatestSTR().Epoch
+	if req.Provider == "" || strings.Contains(req.Provider, "@") ||
+		req.Epoch > latest {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	str := d.pad.GetSTR(req.Epoch)
+	if str == nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	bits := protocol.GetPolicies(str).NamespaceBits
+	if bits == 0 {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	index, vrfProof := d.pad.IndexWithProof(req.Provider)
+	proof, err := d.pad.GetSubtreeInEpoch(index, bits, req.Epoch)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+
+	var strs []*protocol.DirSTR
+	var next *protocol.Continuation
+	budget := protocol.NewResponseBudget()
+	budget.Spend(proof)
+	for ep := req.Epoch; ep <= latest; ep++ {
+		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
+		if ep > req.Epoch && !budget.Spend(str) {
+			next = &protocol.Continuation{NextEpoch: ep}
+			break
+		}
+		strs = append(strs, str)
+	}
+	return protocol.NewSubtreeProof(proof, index, vrfProof, strs, next)
+}
+
+// Sample gets the authentication paths of the tree of this
+// ConiksDirectory at the lookup indices indicated in the SampleRequest
+// req received from a CONIKS auditor, at the epoch req.Epoch, and
+// returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the auditor.
+//
+// A request without indices, with more than protocol.MaxSampleSize
+// indices or an index which isn't vrf.Size bytes long, or with a future
+// epoch, is considered malformed, and causes Sample() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// Sample() returns a message.NewErrorResponse(ErrDirectory) if the
+// snapshot of the requested epoch is no longer available.
+// Otherwise, Sample() returns a message.NewSampleProof(ap, str), where
+// ap is the list of sampled authentication paths, which omit the
+// bindings of their leaves (see merkletree.MerkleTree.Sample()), and
+// str is the STR for req.Epoch.
+func (d *ConiksDirectory) Sample(req *protocol.SampleRequest) *protocol.Response {
+	if len(req.Indices) == 0 || len(req.Indices) > protocol.MaxSampleSize ||
+		req.Epoch > d.LatestSTR().Epoch {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	aps, err := d.pad.SampleInEpoch(req.Indices, req.Epoch)
+	switch err {
+	case nil:
+	case merkletree.ErrMalformedIndex:
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	default:
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	return protocol.NewSampleProof(aps, protocol.NewDirSTR(d.pad.GetSTR(req.Epoch)))
+}
+
+// EpochDelta gets the leaves of the tree of this ConiksDirectory whose
+// bindings are new or changed in the epoch req.Epoch, as indicated in
+// the EpochDeltaRequest req received from a CONIKS auditor or mirror,
+// and returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the auditor or mirror.
+//
+// A request with a future epoch is considered malformed, and causes
+// EpochDelta() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// EpochDelta() returns a message.NewErrorResponse(ErrDirectory) if the
+// snapshot of the requested epoch or of the previous epoch is no longer
+// available.
+// Otherwise, EpochDelta() returns a message.NewEpochDelta(delta, str,
+// next), where delta includes the changed leaves whose index isn't less
+// than req.StartIndex (see merkletree.TreeDelta), str is the STR for
+// req.Epoch, and next is the index of the first leaf left out of delta
+// if the leaves would exceed protocol.MaxResponseSize, or nil.
+func (d *ConiksDirectory) EpochDelta(req *protocol.EpochDeltaRequest) *protocol.Response {
+	if req.Epoch > d.LatestSTR().Epoch {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	delta, err := d.pad.DeltaInEpoch(req.Epoch)
+	if err != nil {
+		return protocol.NewErrorResponse(protocol.ErrDirectory)
+	}
+	str := protocol.NewDirSTR(d.pad.GetSTR(req.Epoch))
+	budget := protocol.NewResponseBudget()
+	budget.Spend(str, delta.TreeNonce)
+	leaves := delta.Leaves
+	delta.Leaves = nil
+	var next []byte
+	for _, leaf := range leaves {
+		if bytes.Compare(leaf.Index, req.StartIndex) < 0 {
+			continue
+		}
+		if !budget.Spend(leaf) {
+			next = leaf.Index
+			break
+		}
+		delta.Leaves = append(delta.Leaves, leaf)
+	}
+	return protocol.NewEpochDelta(delta, str, next)
+}
+
+// TreeStats returns the aggregate shape of the tree of the directory's
+// snapshot at epoch (see merkletree.TreeStats), which reveals no
+// binding. It returns merkletree.ErrSTRNotFound if epoch is greater
+// than the latest epoch, or if its snapshot has been removed from
+// memory.
+func (d *ConiksDirectory) TreeStats(epoch uint64) (*merkletree.TreeStats, error) {
+	if epoch > d.LatestSTR().Epoch {
+		return nil, merkletree.ErrSTRNotFound
+	}
+	return d.pad.StatsInEpoch(epoch)
 }
 
 // Monitor gets the directory proofs for the username for the range of
@@ -270,41 +1382,186 @@ func (d *ConiksDirectory) KeyLookupInEpoch(req *protocol.KeyLookupInEpochRequest
 // latest epoch of this directory, or a start epoch greater than the
 // end epoch is considered malformed, and causes Monitor() to return a
 // message.NewErrorResponse(ErrMalformedMessage).
-// Monitor() returns a message.NewMonitoringProof(ap, str).
+// Monitor() returns a message.NewMonitoringProof(ap, str, next, t).
 // ap is a list of proofs of inclusion, and str is a list of STRs for
 // the epoch range [startEpoch, endEpoch], where startEpoch
 // and endEpoch are the epoch range endpoints indicated in the client's
 // request. If req.endEpoch is greater than d.LatestSTR().Epoch,
 // the end of the range will be set to d.LatestSTR().Epoch.
+// If the proofs for the whole range would exceed
+// protocol.MaxResponseSize, the range ends at the last epoch whose
+// proofs fit, and next continues the range at the following epoch;
+// otherwise, next is nil.
+// If req.KnownEpoch is greater than 0, str omits the STRs for the epochs
+// up to and including req.KnownEpoch, except for the STR of endEpoch.
+// If the username was first included in an epoch of the range, i.e.
+// if it was absent in the previous epoch, t proves this transition
+// (see protocol.TransitionProof), so that a client which has just
+// registered the username can check the directory's promise; otherwise,
+// or if the previous epoch has been removed from memory, t is nil.
 // If Monitor() encounters an internal error at any point,
 // it returns a message.NewErrorResponse(ErrDirectory).
 func (d *ConiksDirectory) Monitor(req *protocol.MonitoringRequest) *protocol.Response {
 
 	// make sure the request is well-formed
+	latest := d.LatestSTR().Epoch
 	if len(req.Username) <= 0 ||
-		req.StartEpoch > d.LatestSTR().Epoch ||
+		req.StartEpoch > latest ||
 		req.StartEpoch > req.EndEpoch {
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
 
 	var strs []*protocol.DirSTR
 	var aps []*merkletree.AuthenticationPath
+	var next *protocol.Continuation
+	var transition *protocol.TransitionProof
+	included := false
 	startEp := req.StartEpoch
 	endEp := req.EndEpoch
-	if endEp > d.LatestSTR().Epoch {
-		endEp = d.LatestSTR().Epoch
+	if endEp > latest {
+		endEp = latest
 	}
+	budget := protocol.NewResponseBudget()
 	for ep := startEp; ep <= endEp; ep++ {
 		ap, err := d.pad.LookupInEpoch(req.Username, ep)
 		if err != nil {
 			return protocol.NewErrorResponse(protocol.ErrDirectory)
 		}
+		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
+		// count the STR even if it's omitted, since it's included
+		// if the range ends at this epoch
+		proofs := []interface{}{ap, str}
+		var t *protocol.TransitionProof
+		if !included && ap.ProofType() == merkletree.ProofOfInclusion {
+			var prev *merkletree.AuthenticationPath
+			if len(aps) > 0 {
+				prev = aps[len(aps)-1]
+			}
+			if t = d.transitionProof(req.Username, ep, ap, prev); t != nil {
+				proofs = append(proofs, t)
+			}
+		}
+		if !budget.Spend(proofs...) {
+			next = &protocol.Continuation{NextEpoch: ep}
+			break
+		}
+		if ap.ProofType() == merkletree.ProofOfInclusion {
+			included = true
+			if t != nil {
+				transition = t
+			}
+		}
 		aps = append(aps, ap)
+		strs = append(strs, str)
+	}
+	// omit the STRs the client already knows, but always
+	// include the STR of the range's last epoch
+	for len(strs) > 1 && strs[0].Epoch <= req.KnownEpoch {
+		strs = strs[1:]
+	}
+
+	return protocol.NewMonitoringProof(aps, strs, next, transition)
+}
+
+// transitionProof returns the proof that uname was first included in
+// the epoch ep, whose proof of inclusion is ap, or nil if uname was
+// already included in the epoch ep-1, or if this epoch has been removed
+// from memory. prev is the authentication path for uname in the epoch
+// ep-1, or nil if transitionProof() has to look it up.
+func (d *ConiksDirectory) transitionProof(uname string, ep uint64,
+	ap, prev *merkletree.AuthenticationPath) *protocol.TransitionProof {
+	if ep == 0 {
+		return nil
+	}
+	if prev == nil {
+		var err error
+		if prev, err = d.pad.LookupInEpoch(uname, ep-1); err != nil {
+			return nil
+		}
+	}
+	str := d.pad.GetSTR(ep - 1)
+	if str == nil || prev.ProofType() != merkletree.ProofOfAbsence {
+		return nil
+	}
+	return &protocol.TransitionProof{
+		Epoch:     ep,
+		STR:       protocol.NewDirSTR(str),
+		Absence:   prev,
+		Inclusion: ap,
+	}
+}
+
+// KeyHistory gets the history of the values bound to the username for
+// the range of epochs indicated in the KeyHistoryRequest req received
+// from a CONIKS client, and returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// A request without a username, with a start epoch greater than the
+// latest epoch of this directory, or a start epoch greater than the
+// end epoch is considered malformed, and causes KeyHistory() to return a
+// message.NewErrorResponse(ErrMalformedMessage).
+// KeyHistory() returns a message.NewKeyHistoryProof(ap, str, next).
+// ap is a list of authentication paths, and str is a list of STRs for
+// startEpoch and for each epoch in the range [startEpoch, endEpoch]
+// in which the value bound to the username differs from the
+// value bound in the previous epoch (including the binding's
+// registration). If req.endEpoch is greater than d.LatestSTR().Epoch,
+// the end of the range will be set to d.LatestSTR().Epoch.
+// If the proofs for the whole range would exceed
+// protocol.MaxResponseSize, the range ends before the first change
+// whose proofs don't fit, and next continues the range at the epoch of
+// this change; otherwise, next is nil.
+// If KeyHistory() encounters an internal error at any point,
+// it returns a message.NewErrorResponse(ErrDirectory).
+func (d *ConiksDirectory) KeyHistory(req *protocol.KeyHistoryRequest) *protocol.Response {
+	// make sure the request is well-formed
+	latest := d.LatestSTR().Epoch
+	if len(req.Username) <= 0 ||
+		req.StartEpoch > latest ||
+		req.StartEpoch > req.EndEpoch {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+
+	endEp := req.EndEpoch
+	if endEp > latest {
+		endEp = latest
+	}
+	var strs []*protocol.DirSTR
+	var aps []*merkletree.AuthenticationPath
+	var prev *merkletree.AuthenticationPath
+	var next *protocol.Continuation
+	budget := protocol.NewResponseBudget()
+	for ep := req.StartEpoch; ep <= endEp; ep++ {
+		ap, err := d.pad.LookupInEpoch(req.Username, ep)
+		if err != nil {
+			return protocol.NewErrorResponse(protocol.ErrDirectory)
+		}
+		if prev != nil && sameBinding(prev, ap) {
+			continue
+		}
 		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
+		if !budget.Spend(ap, str) {
+			next = &protocol.Continuation{NextEpoch: ep}
+			break
+		}
+		aps = append(aps, ap)
 		strs = append(strs, str)
+		prev = ap
 	}
 
-	return protocol.NewMonitoringProof(aps, strs)
+	return protocol.NewKeyHistoryProof(aps, strs, next)
+}
+
+// sameBinding returns whether the authentication paths ap1 and ap2
+// prove the same binding, i.e., both prove the absence of the name,
+// or both prove the inclusion of the same value.
+func sameBinding(ap1, ap2 *merkletree.AuthenticationPath) bool {
+	if ap1.ProofType() != ap2.ProofType() {
+		return false
+	}
+	return ap1.ProofType() == merkletree.ProofOfAbsence ||
+		bytes.Equal(ap1.Leaf.Value, ap2.Leaf.Value)
 }
 
 // GetSTRHistory gets the directory snapshots for the epoch range
@@ -317,29 +1574,42 @@ func (d *ConiksDirectory) Monitor(req *protocol.MonitoringRequest) *protocol.Res
 // end epoch is considered malformed, and causes
 // GetSTRHistory() to return a
 // message.NewErrorResponse(ErrMalformedMessage).
-// GetSTRHistory() returns a message.NewSTRHistoryRange(strs).
+// GetSTRHistory() returns a message.NewPartialSTRHistoryRange(strs, next).
 // strs is a list of STRs for
 // the epoch range [startEpoch, endEpoch], where startEpoch
 // and endEpoch are the epoch range endpoints indicated in the client's
-// request. If req.endEpoch is greater than d.LatestSTR().Epoch,
-// the end of the range will be set to d.LatestSTR().Epoch.
+// request. If req.EndEpoch is greater than d.LatestSTR().Epoch,
+// or if req.Latest is set, the end of the range will be set to
+// d.LatestSTR().Epoch (see protocol.STRHistoryRequest.Range()).
+// If the STRs of the whole range would exceed protocol.MaxResponseSize,
+// the range ends at the last STR which fits, and next continues the
+// range at the following epoch; otherwise, next is nil.
+// The STRs which have been removed from memory are read from the
+// directory's database, if it's persisted (see Persist()).
+// If any STR in the range is unavailable,
+// GetSTRHistory() returns a message.NewErrorResponse(ErrDirectory).
 func (d *ConiksDirectory) GetSTRHistory(req *protocol.STRHistoryRequest) *protocol.Response {
 	// make sure the request is well-formed
-	if req.StartEpoch > d.LatestSTR().Epoch ||
-		req.EndEpoch < req.StartEpoch {
+	startEp, endEp, ok := req.Range(d.LatestSTR().Epoch)
+	if !ok {
 		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
 	}
 
-	endEp := req.EndEpoch
-	if req.EndEpoch > d.LatestSTR().Epoch {
-		endEp = d.LatestSTR().Epoch
-	}
-
 	var strs []*protocol.DirSTR
-	for ep := req.StartEpoch; ep <= endEp; ep++ {
-		str := protocol.NewDirSTR(d.pad.GetSTR(ep))
-		strs = append(strs, str)
+	var next *protocol.Continuation
+	budget := protocol.NewResponseBudget()
+	for ep := startEp; ep <= endEp; ep++ {
+		str, err := d.pad.LoadSTR(ep)
+		if err != nil {
+			return protocol.NewErrorResponse(protocol.ErrDirectory)
+		}
+		dirSTR := protocol.NewDirSTR(str)
+		if !budget.Spend(dirSTR) {
+			next = &protocol.Continuation{NextEpoch: ep}
+			break
+		}
+		strs = append(strs, dirSTR)
 	}
 
-	return protocol.NewSTRHistoryRange(strs)
+	return protocol.NewPartialSTRHistoryRange(strs, next)
 }
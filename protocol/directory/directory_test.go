@@ -1,9 +1,21 @@
 package directory
 
 import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/profile"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
 )
 
 func TestPoliciesChanges(t *testing.T) {
@@ -33,6 +45,232 @@ func TestPoliciesChanges(t *testing.T) {
 	}
 }
 
+func TestSaltKeyPolicies(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.SetSaltKey(make([]byte, crypto.SaltKeySize))
+	// reloading the policies keeps the salt scheme
+	d.SetPolicies(2)
+	d.Update()
+	d.Update()
+	p := d.LatestSTR().Policies
+	if p.SaltScheme != crypto.SaltPRFID || p.EpochDeadline != 2 {
+		t.Fatal("Unexpected policies", "want", crypto.SaltPRFID, 2,
+			"got", p.SaltScheme, p.EpochDeadline)
+	}
+}
+
+func TestSetHashSize(t *testing.T) {
+	d := NewTestDirectory(t)
+	if err := d.SetHashSize(crypto.MinHashSizeByte - 1); err != crypto.ErrUnsupportedHash {
+		t.Fatal("Expect", crypto.ErrUnsupportedHash, "got", err)
+	}
+	if err := d.SetHashSize(crypto.MinHashSizeByte); err != nil {
+		t.Fatal(err)
+	}
+	// reloading the policies keeps the hash size
+	d.SetPolicies(2)
+	d.Update()
+	d.Update()
+	str := d.LatestSTR()
+	if want := crypto.HashIDWithSize(crypto.MinHashSizeByte); str.Policies.HashID != want {
+		t.Fatal("Expect", want, "got", str.Policies.HashID)
+	}
+	if len(str.TreeHash) != crypto.MinHashSizeByte {
+		t.Fatal("Expect", crypto.MinHashSizeByte, "got", len(str.TreeHash))
+	}
+}
+
+func TestSetHasher(t *testing.T) {
+	d := NewTestDirectory(t)
+	if err := d.SetHasher("MD5"); err != crypto.ErrUnsupportedHash {
+		t.Fatal("Expect", crypto.ErrUnsupportedHash, "got", err)
+	}
+	if err := d.SetHasher(hashers.BLAKE2b); err != nil {
+		t.Fatal(err)
+	}
+	// the hash size keeps the hash algorithm
+	if err := d.SetHashSize(crypto.MinHashSizeByte); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	d.Update()
+	str := d.LatestSTR()
+	if want := "BLAKE2b/128"; str.Policies.HashID != want {
+		t.Fatal("Expect", want, "got", str.Policies.HashID)
+	}
+	if len(str.TreeHash) != crypto.MinHashSizeByte {
+		t.Fatal("Expect", crypto.MinHashSizeByte, "got", len(str.TreeHash))
+	}
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	d := NewTestDirectory(t)
+	oldPK, _ := crypto.NewStaticTestSigningKey().Public()
+	key, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := key.Public()
+	if err := d.RotateSigningKey(key); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	// the STR including the new policies hands the signing over
+	d.Update()
+	str := d.LatestSTR()
+	if !bytes.Equal(str.Policies.NextSigningKey, pk) {
+		t.Fatal("Expect the policies to declare the new signing key")
+	}
+	if !oldPK.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Expect the STR to be signed with the old signing key")
+	}
+	if err := str.VerifyHandover(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Update()
+	str = d.LatestSTR()
+	if str.Policies.NextSigningKey != nil || str.CrossSignature != nil {
+		t.Fatal("Expect a single cross-signed STR")
+	}
+	if !pk.Verify(str.Serialize(), str.Signature) {
+		t.Fatal("Expect the STR to be signed with the new signing key")
+	}
+
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   3,
+	})
+	rng := res.STRHistoryRange()
+	if len(rng.Transitions) != 2 || rng.Transitions[0].Epoch != 2 ||
+		rng.Transitions[1].Epoch != 3 ||
+		!reflect.DeepEqual(rng.Transitions[0].Changed,
+			[]string{protocol.PolicyNextSigningKey}) {
+		t.Fatal("Expect the signing key transitions, got", rng.Transitions)
+	}
+	if err := rng.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSTRHistoryPolicyTransitions(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.SetPolicies(2)
+	d.Update()
+	d.Update()
+
+	res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   3,
+	})
+	rng := res.STRHistoryRange()
+	// the new policies take effect in the STR following the next update
+	if len(rng.Transitions) != 1 || rng.Transitions[0].Epoch != 3 ||
+		len(rng.Transitions[0].Changed) != 1 ||
+		rng.Transitions[0].Changed[0] != protocol.PolicyEpochDeadline {
+		t.Fatal("Expect an epoch deadline transition at epoch", 3)
+	}
+	if err := rng.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	rng.Transitions[0].Epoch = 2
+	if err := rng.Verify(); err != protocol.CheckBadPolicyTransition {
+		t.Fatal("Expect", protocol.CheckBadPolicyTransition, "got", err)
+	}
+	rng.Transitions = nil
+	if err := rng.Verify(); err != protocol.CheckBadPolicyTransition {
+		t.Fatal("Expect", protocol.CheckBadPolicyTransition, "got", err)
+	}
+
+	// a range within the same policies has no transitions
+	res = d.GetSTRHistory(&protocol.STRHistoryRequest{
+		StartEpoch: 1,
+		EndEpoch:   2,
+	})
+	if rng := res.STRHistoryRange(); rng.Transitions != nil {
+		t.Fatal("Expect no transitions, got", rng.Transitions)
+	}
+}
+
+func TestSnapshotKeyLookup(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key")})
+	s := d.Snapshot()
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{
+		Username: "bob",
+		Key:      []byte("key")})
+
+	// the snapshot isn't affected by the update
+	res := s.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	df := res.DirectoryProof()
+	if res.Error != protocol.ReqSuccess || df.STR[0].Epoch != 1 || df.TB == nil ||
+		df.AP[0].ProofType() != merkletree.ProofOfAbsence {
+		t.Fatal("Expect a proof of absence and a TB in epoch", 1)
+	}
+	res = s.KeyLookup(&protocol.KeyLookupRequest{Username: "bob"})
+	if res.Error != protocol.ReqNameNotFound {
+		t.Fatal("Expect", protocol.ReqNameNotFound, "got", res.Error)
+	}
+	res = s.KeyLookup(&protocol.KeyLookupRequest{})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
+
+func TestLatestSTRCache(t *testing.T) {
+	d := NewTestDirectory(t)
+	str0 := d.LatestSTR()
+	if d.LatestSTR() != str0 {
+		t.Fatal("Expect the same cached STR within an epoch")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			d.Update()
+		}
+		close(done)
+	}()
+	// read the latest STR concurrently with the updates
+	for prev := str0.Epoch; ; {
+		select {
+		case <-done:
+			if str := d.LatestSTR(); str.Epoch != 10 ||
+				!bytes.Equal(str.Signature, d.pad.LatestSTR().Signature) {
+				t.Fatal("Expect the latest STR to be swapped at each update")
+			}
+			return
+		default:
+		}
+		str := d.LatestSTR()
+		if str.Epoch < prev || str.Policies.EpochDeadline != d.EpochDeadline() {
+			t.Fatal("Unexpected STR", str.Epoch)
+		}
+		prev = str.Epoch
+	}
+}
+
+func TestNextEpoch(t *testing.T) {
+	d := NewTestDirectory(t)
+	clock := utils.NewFakeClock(time.Unix(0, 0))
+	d.SetClock(clock)
+	if next := d.NextEpoch(); !next.Equal(time.Unix(1, 0)) {
+		t.Fatal("Expect next epoch at", time.Unix(1, 0), "got", next)
+	}
+
+	clock.Advance(3 * time.Second)
+	d.Update()
+	if next := d.NextEpoch(); !next.Equal(time.Unix(4, 0)) {
+		t.Fatal("Expect next epoch at", time.Unix(4, 0), "got", next)
+	}
+}
+
 func TestDirectoryKeyLookupInEpochBadEpoch(t *testing.T) {
 	d := NewTestDirectory(t)
 	for _, tc := range []struct {
@@ -54,6 +292,286 @@ func TestDirectoryKeyLookupInEpochBadEpoch(t *testing.T) {
 	}
 }
 
+func TestProveEmptyRange(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+
+	for _, tc := range []struct {
+		name string
+		bits uint32
+		ep   uint64
+		want error
+	}{
+		{"empty tree", 1, 0, protocol.ReqSuccess},
+		{"whole index space", 0, 1, protocol.ErrMalformedMessage},
+		{"prefix too long", merkletree.MaxRangePrefixBits + 1, 1, protocol.ErrMalformedMessage},
+		{"bad epoch", 1, 2, protocol.ErrMalformedMessage},
+	} {
+		res := d.ProveEmptyRange(&protocol.EmptyRangeRequest{
+			Prefix:     make([]byte, 8),
+			PrefixBits: tc.bits,
+			Epoch:      tc.ep,
+		})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	index := d.pad.Index("alice")
+
+	for _, tc := range []struct {
+		name    string
+		indices [][]byte
+		ep      uint64
+		want    error
+	}{
+		{"included index", [][]byte{index}, 1, protocol.ReqSuccess},
+		{"no index", nil, 1, protocol.ErrMalformedMessage},
+		{"too many indices", make([][]byte, protocol.MaxSampleSize+1), 1, protocol.ErrMalformedMessage},
+		{"short index", [][]byte{index[:4]}, 1, protocol.ErrMalformedMessage},
+		{"bad epoch", [][]byte{index}, 2, protocol.ErrMalformedMessage},
+	} {
+		res := d.Sample(&protocol.SampleRequest{Indices: tc.indices, Epoch: tc.ep})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+
+	res := d.Sample(&protocol.SampleRequest{Indices: [][]byte{index}, Epoch: 1})
+	sp := res.SampleProof()
+	if sp.STR.Epoch != 1 || sp.AP[0].Leaf.Value != nil {
+		t.Fatal("Expect the path of alice's leaf without her key at epoch 1")
+	}
+	if err := sp.AP[0].VerifySample(sp.STR.TreeHash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEpochDelta(t *testing.T) {
+	d := NewTestDirectory(t)
+	for i := 0; i < 100; i++ {
+		d.Register(&protocol.RegistrationRequest{
+			Username: "user" + strconv.Itoa(i),
+			Key:      []byte("key"),
+		})
+	}
+	d.Update()
+
+	if res := d.EpochDelta(&protocol.EpochDeltaRequest{Epoch: 2}); res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+
+	// the leaves don't fit in a single response
+	var leaves []*merkletree.ProofNode
+	var start []byte
+	responses := 0
+	for {
+		res := d.EpochDelta(&protocol.EpochDeltaRequest{Epoch: 1, StartIndex: start})
+		delta := res.EpochDelta()
+		if res.Error != protocol.ReqSuccess || delta.STR.Epoch != 1 {
+			t.Fatal("Expect the delta of epoch 1, got", res.Error)
+		}
+		leaves = append(leaves, delta.Delta.Leaves...)
+		responses++
+		if start = delta.NextIndex; start == nil {
+			break
+		}
+	}
+	if responses < 2 || len(leaves) != 100 {
+		t.Fatal("Expect", 100, "leaves in several responses, got", len(leaves),
+			"leaves in", responses, "responses")
+	}
+	for i := 1; i < len(leaves); i++ {
+		if bytes.Compare(leaves[i-1].Index, leaves[i].Index) >= 0 {
+			t.Fatal("Expect the leaves in the order of their indices")
+		}
+	}
+
+	d.Update()
+	res := d.EpochDelta(&protocol.EpochDeltaRequest{Epoch: 2})
+	if res.Error != protocol.ReqSuccess || len(res.EpochDelta().Delta.Leaves) != 0 {
+		t.Fatal("Expect an empty delta for epoch 2")
+	}
+}
+
+func TestBatchKeyLookup(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+
+	for _, tc := range []struct {
+		name  string
+		names []string
+		want  error
+	}{
+		{"names", []string{"alice", "bob"}, protocol.ReqSuccess},
+		{"no name", nil, protocol.ErrMalformedMessage},
+		{"too many names", make([]string, protocol.MaxBatchSize+1), protocol.ErrMalformedMessage},
+		{"empty name", []string{"alice", ""}, protocol.ErrMalformedMessage},
+	} {
+		res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: tc.names})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+
+	names := []string{"alice", "bob", "carol"}
+	res := d.BatchKeyLookup(&protocol.BatchKeyLookupRequest{Usernames: names})
+	if err := res.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	df := res.BatchKeyLookupProof()
+	if df.STR.Epoch != d.LatestSTR().Epoch || len(df.AP) != len(names) {
+		t.Fatal("Expect one path per name under the latest STR")
+	}
+	for i, name := range names {
+		want := d.KeyLookup(&protocol.KeyLookupRequest{Username: name})
+		got := df.Proof(i)
+		if got.Error != want.Error ||
+			!reflect.DeepEqual(got.DirectoryResponse, want.DirectoryResponse) {
+			t.Error(name, "expect the proof of a key lookup")
+		}
+	}
+}
+
+func TestProveSubtree(t *testing.T) {
+	d := New(1, protocol.NewNamespacedVRF(crypto.NewStaticTestVRFKey(), 8),
+		crypto.NewStaticTestSigningKey(), 10, true)
+	d.Register(&protocol.RegistrationRequest{Username: "alice@example.org", Key: []byte("key")})
+	d.Update()
+
+	subtree := func(ep uint64) []byte {
+		res := d.ProveSubtree(&protocol.SubtreeRequest{Provider: "example.org", Epoch: ep})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		p := res.SubtreeProof()
+		if err := p.Proof.Verify(p.STR[0].TreeHash); err != nil {
+			t.Fatal(err)
+		}
+		return p.Proof.Hash
+	}
+	hash := subtree(1)
+
+	// another provider's user doesn't change the subtree
+	d.Register(&protocol.RegistrationRequest{Username: "bob@example.com", Key: []byte("key")})
+	d.Update()
+	if !bytes.Equal(subtree(2), hash) {
+		t.Fatal("Expect the subtree of example.org to be unchanged")
+	}
+	d.Register(&protocol.RegistrationRequest{Username: "carol@example.org", Key: []byte("key")})
+	d.Update()
+	if bytes.Equal(subtree(3), hash) {
+		t.Fatal("Expect the subtree of example.org to change")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		d        *ConiksDirectory
+		provider string
+		ep       uint64
+		want     error
+	}{
+		{"no provider", d, "", 1, protocol.ErrMalformedMessage},
+		{"namespaced provider", d, "alice@example.org", 1, protocol.ErrMalformedMessage},
+		{"bad epoch", d, "example.org", 4, protocol.ErrMalformedMessage},
+		{"no namespaces", NewTestDirectory(t), "example.org", 0, protocol.ErrMalformedMessage},
+	} {
+		res := tc.d.ProveSubtree(&protocol.SubtreeRequest{Provider: tc.provider, Epoch: tc.ep})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+func TestRegisterDelegatedNamespace(t *testing.T) {
+	d := New(1, protocol.NewNamespacedVRF(crypto.NewStaticTestVRFKey(), 8),
+		crypto.NewStaticTestSigningKey(), 10, true)
+	clock := utils.NewFakeClock(time.Unix(3600, 0))
+	d.SetClock(clock)
+	provider, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := provider.Public()
+	d.SetAttestationKeys(map[string]sign.PublicKey{"@example.org": pk})
+
+	expiry := clock.Now().Add(time.Minute)
+	for _, tc := range []struct {
+		name        string
+		username    string
+		attestation *protocol.RegistrationAttestation
+		want        protocol.ErrorCode
+	}{
+		{"missing", "alice@example.org", nil, protocol.ReqMissingAttestation},
+		{"attested", "alice@example.org",
+			protocol.NewRegistrationAttestation(provider, "alice@example.org", expiry),
+			protocol.ReqSuccess},
+		{"other provider", "bob@example.com", nil, protocol.ReqSuccess},
+	} {
+		res := d.Register(&protocol.RegistrationRequest{
+			Username:    tc.username,
+			Key:         []byte("key"),
+			Attestation: tc.attestation,
+		})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+func TestRegisterNamePolicies(t *testing.T) {
+	d := NewTestDirectory(t)
+	clock := utils.NewFakeClock(time.Unix(3600, 0))
+	d.SetClock(clock)
+	twitterBot, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBot, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twitterKey, _ := twitterBot.Public()
+	otherKey, _ := otherBot.Public()
+	// the bot trusted for the suffix isn't the policy's authority
+	d.SetAttestationKeys(map[string]sign.PublicKey{"@twitter": otherKey})
+	d.SetNamePolicies(protocol.NamePolicies{{Suffix: "@twitter", Authority: twitterKey}})
+
+	expiry := clock.Now().Add(time.Minute)
+	for _, tc := range []struct {
+		name        string
+		username    string
+		attestation *protocol.RegistrationAttestation
+		want        protocol.ErrorCode
+	}{
+		{"missing", "alice@twitter", nil, protocol.ReqMissingAttestation},
+		{"other authority", "alice@twitter",
+			protocol.NewRegistrationAttestation(otherBot, "alice@twitter", expiry),
+			protocol.ReqBadAttestation},
+		{"authority", "alice@twitter",
+			protocol.NewRegistrationAttestation(twitterBot, "alice@twitter", expiry),
+			protocol.ReqSuccess},
+		{"no policy", "bob@example", nil, protocol.ReqSuccess},
+	} {
+		res := d.Register(&protocol.RegistrationRequest{
+			Username:    tc.username,
+			Key:         []byte("key"),
+			Attestation: tc.attestation,
+		})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
 func TestBadRequestMonitoring(t *testing.T) {
 	d := NewTestDirectory(t)
 
@@ -79,6 +597,121 @@ func TestBadRequestMonitoring(t *testing.T) {
 	}
 }
 
+func TestMonitorOmitsKnownSTRs(t *testing.T) {
+	d := NewTestDirectory(t)
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+
+	for _, tc := range []struct {
+		name    string
+		knownEp uint64
+		want    uint64 // epoch of the first returned STR
+	}{
+		{"no known STRs", 0, 1},
+		{"known STRs", 2, 3},
+		{"all STRs known", 3, 3},
+	} {
+		res := d.Monitor(&protocol.MonitoringRequest{
+			Username:   "alice",
+			StartEpoch: 1,
+			EndEpoch:   3,
+			KnownEpoch: tc.knownEp,
+		})
+		df := res.DirectoryProof()
+		if len(df.AP) != 3 || len(df.STR) != int(4-tc.want) ||
+			df.STR[0].Epoch != tc.want {
+			t.Errorf("%s: unexpected STRs in the monitoring proof", tc.name)
+		}
+	}
+}
+
+func TestMonitorTransition(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key")})
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+
+	for _, tc := range []struct {
+		name    string
+		startEp uint64
+		endEp   uint64
+		want    bool
+	}{
+		{"range starting at the inclusion", 2, 4, true},
+		{"range including the absence", 1, 3, true},
+		{"range before the inclusion", 0, 1, false},
+		{"range after the inclusion", 3, 4, false},
+	} {
+		res := d.Monitor(&protocol.MonitoringRequest{
+			Username:   "alice",
+			StartEpoch: tc.startEp,
+			EndEpoch:   tc.endEp,
+		})
+		df := res.DirectoryProof()
+		tr := df.Transition
+		if (tr != nil) != tc.want {
+			t.Fatal(tc.name, "expect a transition proof", tc.want, "got", tr)
+		}
+		if tr == nil {
+			continue
+		}
+		if tr.Epoch != 2 || tr.STR.Epoch != 1 ||
+			tr.Absence.ProofType() != merkletree.ProofOfAbsence ||
+			tr.Inclusion != df.AP[2-tc.startEp] {
+			t.Error(tc.name, "unexpected transition proof")
+		}
+	}
+}
+
+func TestKeyHistory(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Update()
+	d.Register(&protocol.RegistrationRequest{
+		Username: "alice",
+		Key:      []byte("key")})
+	for i := 0; i < 3; i++ {
+		d.Update()
+	}
+
+	res := d.KeyHistory(&protocol.KeyHistoryRequest{
+		Username:   "alice",
+		StartEpoch: 1,
+		EndEpoch:   10,
+	})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	df := res.DirectoryProof()
+	if len(df.AP) != 2 || len(df.STR) != 2 {
+		t.Fatal("Expect", 2, "entries, got", len(df.AP))
+	}
+	if df.STR[0].Epoch != 1 || df.AP[0].ProofType() != merkletree.ProofOfAbsence {
+		t.Fatal("Expect a proof of absence in epoch", 1)
+	}
+	if df.STR[1].Epoch != 3 || df.AP[1].ProofType() != merkletree.ProofOfInclusion {
+		t.Fatal("Expect a proof of inclusion in epoch", 3)
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  *protocol.KeyHistoryRequest
+	}{
+		{"no username", &protocol.KeyHistoryRequest{StartEpoch: 1, EndEpoch: 2}},
+		{"bad end epoch", &protocol.KeyHistoryRequest{Username: "alice", StartEpoch: 3, EndEpoch: 2}},
+		{"out-of-bounds", &protocol.KeyHistoryRequest{Username: "alice", StartEpoch: 10, EndEpoch: 12}},
+	} {
+		if res := d.KeyHistory(tc.req); res.Error != protocol.ErrMalformedMessage {
+			t.Errorf("%s: Expect %v got %v", tc.name, protocol.ErrMalformedMessage, res.Error)
+		}
+	}
+}
+
 func TestBadRequestGetSTRHistory(t *testing.T) {
 	d := NewTestDirectory(t)
 	d.Update()
@@ -87,17 +720,945 @@ func TestBadRequestGetSTRHistory(t *testing.T) {
 		name    string
 		startEp uint64
 		endEp   uint64
+		latest  bool
 		want    error
 	}{
-		{"bad end epoch", 4, 2, protocol.ErrMalformedMessage},
-		{"out-of-bounds", 6, d.LatestSTR().Epoch, protocol.ErrMalformedMessage},
+		{"bad end epoch", 4, 2, false, protocol.ErrMalformedMessage},
+		{"out-of-bounds", 6, d.LatestSTR().Epoch, false, protocol.ErrMalformedMessage},
+		{"out-of-bounds latest", 6, 0, true, protocol.ErrMalformedMessage},
+		{"end epoch and latest", 0, 1, true, protocol.ErrMalformedMessage},
 	} {
 		res := d.GetSTRHistory(&protocol.STRHistoryRequest{
 			StartEpoch: tc.startEp,
 			EndEpoch:   tc.endEp,
+			Latest:     tc.latest,
 		})
 		if res.Error != tc.want {
 			t.Errorf("Expect ErrMalformedMessage for %s", tc.name)
 		}
 	}
 }
+
+func TestGetSTRHistoryLatest(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Update()
+	d.Update()
+
+	for _, tc := range []struct {
+		name    string
+		startEp uint64
+		endEp   uint64
+		latest  bool
+		want    []uint64
+	}{
+		{"latest", 1, 0, true, []uint64{1, 2}},
+		{"latest only", 2, 0, true, []uint64{2}},
+		{"end epoch zero", 0, 0, false, []uint64{0}},
+		{"end epoch past latest", 1, 100, false, []uint64{1, 2}},
+	} {
+		res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: tc.startEp,
+			EndEpoch:   tc.endEp,
+			Latest:     tc.latest,
+		})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal(tc.name, "expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		strs := res.STRHistoryRange().STR
+		if len(strs) != len(tc.want) {
+			t.Fatal(tc.name, "expect", len(tc.want), "STRs, got", len(strs))
+		}
+		for i, str := range strs {
+			if str.Epoch != tc.want[i] {
+				t.Error(tc.name, "expect epoch", tc.want[i], "got", str.Epoch)
+			}
+		}
+	}
+}
+
+func TestDirectoryRestore(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+		d.Update()
+		d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+
+		restored, err := Restore(db, 10, 1, vrfKey, signKey, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(restored.LatestSTR().Signature, d.LatestSTR().Signature) {
+			t.Fatal("Expect the same latest STR")
+		}
+		// the promise for the pending binding is kept
+		res := restored.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("other")})
+		df := res.DirectoryProof()
+		if res.Error != protocol.ReqNameExisted || df.TB == nil ||
+			!bytes.Equal(df.TB.Value, []byte("key")) {
+			t.Fatal("Expect the reissued TB for bob")
+		}
+		res = restored.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+		if res.Error != protocol.ReqSuccess ||
+			res.DirectoryProof().TB != nil {
+			t.Fatal("Expect alice's binding to be included")
+		}
+		if restored.Bindings() != 2 {
+			t.Fatal("Expect", 2, "bindings, got", restored.Bindings())
+		}
+		if restored.Identity() != d.Identity() || df.TB.DirInitSTRHash != d.Identity() {
+			t.Fatal("Expect the restored directory to keep its identity")
+		}
+	})
+}
+
+func TestDirectoryRestoreSTRHistory(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 2, true)
+		if err := d.Persist(db, 2); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			d.Update()
+		}
+
+		restored, err := Restore(db, 2, 1, vrfKey, signKey, 2, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []*ConiksDirectory{d, restored} {
+			res := dir.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: 0,
+				EndEpoch:   4,
+			})
+			if res.Error != protocol.ReqSuccess {
+				t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+			}
+			rng := res.STRHistoryRange()
+			if len(rng.STR) != 5 || rng.STR[0].Epoch != 0 {
+				t.Fatal("Expect the whole STR history")
+			}
+			if err := rng.Verify(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDirectoryRestoreRotation(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	key, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := key.Public()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.RotateSigningKey(key); err != nil {
+			t.Fatal(err)
+		}
+		// the directory is restarted with its initial key at each
+		// stage of the handover
+		for i := 0; i < 4; i++ {
+			d, err = Restore(db, 10, 1, vrfKey, signKey, 10, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Update(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		res := d.GetSTRHistory(&protocol.STRHistoryRequest{
+			StartEpoch: 0,
+			EndEpoch:   4,
+		})
+		rng := res.STRHistoryRange()
+		if err := rng.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		if len(rng.Transitions) != 2 || rng.Transitions[0].Epoch != 2 ||
+			rng.Transitions[1].Epoch != 3 {
+			t.Fatal("Expect the signing key transitions, got", rng.Transitions)
+		}
+		if err := rng.STR[2].VerifyHandover(); err != nil {
+			t.Fatal(err)
+		}
+		for _, str := range rng.STR[3:] {
+			if !pk.Verify(str.Serialize(), str.Signature) {
+				t.Fatal("Expect STR", str.Epoch, "to be signed with the new signing key")
+			}
+		}
+	})
+}
+
+func TestDirectoryRestoreNoIdentity(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 10, true)
+		initSTR := d.LatestSTR()
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		d.Update()
+		// a database written before the identity was recorded
+		if err := db.Delete(identityKey); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Restore(db, 10, 1, vrfKey, signKey, 10, true); err != ErrNoIdentity {
+			t.Fatal("Expect", ErrNoIdentity, "got", err)
+		}
+		if err := PersistIdentity(db, initSTR); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := Restore(db, 10, 1, vrfKey, signKey, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Identity() != d.Identity() {
+			t.Fatal("Expect the restored directory to keep its identity")
+		}
+	})
+}
+
+func TestBootstrap(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	pk, _ := signKey.Public()
+	seed := protocol.NewBootstrapSeed(signKey, []*protocol.BootstrapBinding{
+		{Username: "staff", Key: []byte("staff key")},
+		{Username: "bot", Key: []byte("bot key")},
+	})
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 10, true)
+		if err := d.Bootstrap(seed); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := seed.VerifyInitSTR(pk, d.LatestSTR()); err != nil {
+			t.Fatal(err)
+		}
+		if d.Bindings() != 2 {
+			t.Fatal("Expect", 2, "bindings, got", d.Bindings())
+		}
+		// the seed's bindings are included at epoch 0
+		res := d.KeyLookup(&protocol.KeyLookupRequest{Username: "staff"})
+		df := res.DirectoryProof()
+		if res.Error != protocol.ReqSuccess || df.TB != nil ||
+			df.AP[0].ProofType() != merkletree.ProofOfInclusion ||
+			df.STR[0].Epoch != 0 {
+			t.Fatal("Expect staff's binding to be included at epoch 0")
+		}
+		if res := d.Register(&protocol.RegistrationRequest{
+			Username: "bot", Key: []byte("key")}); res.Error != protocol.ReqNameExisted {
+			t.Fatal("Expect", protocol.ReqNameExisted, "got", res.Error)
+		}
+
+		// the following policies keep committing to the seed
+		d.SetPolicies(2)
+		d.Update()
+		if err := d.Bootstrap(seed); err != merkletree.ErrBootstrapped {
+			t.Fatal("Expect", merkletree.ErrBootstrapped, "got", err)
+		}
+		restored, err := Restore(db, 10, 2, vrfKey, signKey, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored.Update()
+		p := restored.LatestSTR().Policies
+		if changed := p.Diff(protocol.GetPolicies(d.pad.GetSTR(0))); len(changed) != 1 ||
+			changed[0] != protocol.PolicyEpochDeadline {
+			t.Fatal("Expect an epoch deadline transition only, got", changed)
+		}
+	})
+}
+
+func TestRegisterLimits(t *testing.T) {
+	d := NewTestDirectory(t)
+	var alerts []*LimitAlert
+	d.SetLimits(Limits{
+		SoftMaxBindings:              3,
+		MaxBindings:                  4,
+		SoftMaxRegistrationsPerEpoch: 2,
+		MaxRegistrationsPerEpoch:     3,
+	}, func(a *LimitAlert) {
+		alerts = append(alerts, a)
+	})
+	register := func(name string) protocol.ErrorCode {
+		return d.Register(&protocol.RegistrationRequest{
+			Username: name,
+			Key:      []byte("key")}).Error
+	}
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := register(name); err != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", err)
+		}
+	}
+	if err := register("dave"); err != protocol.ReqLimitExceeded {
+		t.Fatal("Expect", protocol.ReqLimitExceeded, "got", err)
+	}
+	// an existing name is still reported as such
+	if err := register("alice"); err != protocol.ReqNameExisted {
+		t.Fatal("Expect", protocol.ReqNameExisted, "got", err)
+	}
+	if len(alerts) != 2 ||
+		alerts[0].Limit != LimitRegistrationsPerEpoch || alerts[0].Value != 2 ||
+		alerts[1].Limit != LimitBindings || alerts[1].Value != 3 {
+		t.Fatal("Unexpected alerts", alerts)
+	}
+
+	d.Update()
+	if err := register("dave"); err != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", err)
+	}
+	if err := register("eve"); err != protocol.ReqLimitExceeded {
+		t.Fatal("Expect", protocol.ReqLimitExceeded, "got", err)
+	}
+	if d.Bindings() != 4 || len(alerts) != 2 {
+		t.Fatal("Expect", 4, "bindings and no new alert, got",
+			d.Bindings(), len(alerts))
+	}
+}
+
+func TestRegisterLimitsWithoutTBs(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.useTBs = false
+	var alerts []*LimitAlert
+	d.SetLimits(Limits{
+		SoftMaxRegistrationsPerEpoch: 1,
+		MaxRegistrationsPerEpoch:     2,
+	}, func(a *LimitAlert) {
+		alerts = append(alerts, a)
+	})
+	register := func(name string) protocol.ErrorCode {
+		return d.Register(&protocol.RegistrationRequest{
+			Username: name,
+			Key:      []byte("key")}).Error
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := register(name); err != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", err)
+		}
+	}
+	if err := register("carol"); err != protocol.ReqLimitExceeded {
+		t.Fatal("Expect", protocol.ReqLimitExceeded, "got", err)
+	}
+	if len(alerts) != 1 || alerts[0].Limit != LimitRegistrationsPerEpoch ||
+		alerts[0].Value != 1 {
+		t.Fatal("Unexpected alerts", alerts)
+	}
+	d.Update()
+	if err := register("carol"); err != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", err)
+	}
+}
+
+func TestRegisterAttestations(t *testing.T) {
+	d := NewTestDirectory(t)
+	clock := utils.NewFakeClock(time.Unix(3600, 0))
+	d.SetClock(clock)
+	twitterBot, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailBot, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twitterKey, _ := twitterBot.Public()
+	mailKey, _ := mailBot.Public()
+	d.SetAttestationKeys(map[string]sign.PublicKey{
+		"@twitter":        twitterKey,
+		"@mail.twitter":   mailKey,
+		"@unused.example": twitterKey,
+	})
+
+	expiry := clock.Now().Add(time.Minute)
+	for _, tc := range []struct {
+		name        string
+		username    string
+		attestation *protocol.RegistrationAttestation
+		want        protocol.ErrorCode
+	}{
+		{"missing", "alice@twitter", nil, protocol.ReqMissingAttestation},
+		{"untrusted suffix", "alice@example",
+			protocol.NewRegistrationAttestation(twitterBot, "alice@example", expiry),
+			protocol.ReqBadAttestation},
+		{"other username", "alice@twitter",
+			protocol.NewRegistrationAttestation(twitterBot, "bob@twitter", expiry),
+			protocol.ReqBadAttestation},
+		{"expired", "alice@twitter",
+			protocol.NewRegistrationAttestation(twitterBot, "alice@twitter",
+				clock.Now().Add(-time.Second)),
+			protocol.ReqBadAttestation},
+		{"shorter suffix", "bob@mail.twitter",
+			protocol.NewRegistrationAttestation(twitterBot, "bob@mail.twitter", expiry),
+			protocol.ReqBadAttestation},
+		{"valid", "alice@twitter",
+			protocol.NewRegistrationAttestation(twitterBot, "alice@twitter", expiry),
+			protocol.ReqSuccess},
+		{"longest suffix", "bob@mail.twitter",
+			protocol.NewRegistrationAttestation(mailBot, "bob@mail.twitter", expiry),
+			protocol.ReqSuccess},
+	} {
+		res := d.RegisterWithAttestation(&protocol.RegistrationRequest{
+			Username:    tc.username,
+			Key:         []byte("key"),
+			Attestation: tc.attestation,
+		})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+func TestKeyChange(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"),
+		AllowUnsignedKeychange: true})
+	d.Update()
+
+	res := d.KeyChange(&protocol.KeyChangeRequest{Username: "bob", Key: []byte("new")})
+	if res.Error != protocol.ReqNameNotFound {
+		t.Fatal("Expect", protocol.ReqNameNotFound, "got", res.Error)
+	}
+	res = d.KeyChange(&protocol.KeyChangeRequest{Username: "alice", Key: []byte("new")})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	tb := res.DirectoryProof().TB
+	if tb == nil || !bytes.Equal(tb.Value, []byte("new")) ||
+		!bytes.Equal(tb.PreviousValue, []byte("key")) ||
+		tb.InclusionEpoch != d.LatestSTR().Epoch+1 {
+		t.Fatal("Unexpected key change TB", tb)
+	}
+	// only one change can be pending at a time
+	res = d.KeyChange(&protocol.KeyChangeRequest{Username: "alice", Key: []byte("other")})
+	if res.Error != protocol.ReqNameExisted ||
+		res.DirectoryProof().TB != tb {
+		t.Fatal("Expect", protocol.ReqNameExisted, "with the pending TB, got", res.Error)
+	}
+
+	// the lookup returns the previous key, along with the pending change
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	df := res.DirectoryProof()
+	if !bytes.Equal(df.AP[0].Leaf.Value, []byte("key")) || df.TB != tb {
+		t.Fatal("Expect the previous key and the pending change")
+	}
+
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	df = res.DirectoryProof()
+	if !bytes.Equal(df.AP[0].Leaf.Value, []byte("new")) || df.TB != nil {
+		t.Fatal("Expect the new key to be included")
+	}
+}
+
+func TestDeactivate(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"),
+		AllowUnsignedKeychange: true})
+	d.Update()
+
+	for _, tc := range []struct {
+		name string
+		res  *protocol.Response
+	}{
+		{"register", d.Register(&protocol.RegistrationRequest{Username: "bob",
+			Key: protocol.Tombstone})},
+		{"key change", d.KeyChange(&protocol.KeyChangeRequest{Username: "alice",
+			Key: protocol.Tombstone})},
+	} {
+		if tc.res.Error != protocol.ErrMalformedMessage {
+			t.Error(tc.name, "expect", protocol.ErrMalformedMessage, "got", tc.res.Error)
+		}
+	}
+
+	res := d.Deactivate(&protocol.DeactivationRequest{Username: "alice"})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	tb := res.DirectoryProof().TB
+	if tb == nil || !protocol.IsTombstone(tb.Value) ||
+		!bytes.Equal(tb.PreviousValue, []byte("key")) {
+		t.Fatal("Unexpected deactivation TB", tb)
+	}
+
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if df := res.DirectoryProof(); res.Error != protocol.ReqSuccess ||
+		!protocol.IsTombstone(df.AP[0].Leaf.Value) {
+		t.Fatal("Expect the tombstone to be included")
+	}
+	// a retired username can't be changed or registered again
+	for _, tc := range []struct {
+		name string
+		res  *protocol.Response
+		want error
+	}{
+		{"deactivate", d.Deactivate(&protocol.DeactivationRequest{Username: "alice"}),
+			protocol.ReqNameDeactivated},
+		{"key change", d.KeyChange(&protocol.KeyChangeRequest{Username: "alice",
+			Key: []byte("new")}), protocol.ReqNameDeactivated},
+		{"register", d.Register(&protocol.RegistrationRequest{Username: "alice",
+			Key: []byte("new")}), protocol.ReqNameExisted},
+	} {
+		if tc.res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", tc.res.Error)
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"),
+		AllowUnsignedKeychange: true})
+	d.Update()
+
+	if _, err := d.Freeze("bob"); err != protocol.ReqNameNotFound {
+		t.Fatal("Expect", protocol.ReqNameNotFound, "got", err)
+	}
+	if _, err := d.Unfreeze("alice"); err != ErrNotFrozen {
+		t.Fatal("Expect", ErrNotFrozen, "got", err)
+	}
+	tb, err := d.Freeze("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !protocol.IsFrozen(tb.Value) || !bytes.Equal(protocol.FrozenKey(tb.Value), []byte("key")) ||
+		!bytes.Equal(tb.PreviousValue, []byte("key")) {
+		t.Fatal("Unexpected freeze TB", tb)
+	}
+	// the user can neither change the key nor abort the pending freeze
+	res := d.KeyChange(&protocol.KeyChangeRequest{Username: "alice", Key: []byte("new")})
+	if res.Error != protocol.ReqNameExisted || res.DirectoryProof().TB != tb {
+		t.Fatal("Expect the pending freeze, got", res.Error)
+	}
+	res = d.AbortKeyChange(&protocol.KeyChangeAbortRequest{Username: "alice"})
+	if res.Error != protocol.ReqNameFrozen {
+		t.Fatal("Expect", protocol.ReqNameFrozen, "got", res.Error)
+	}
+
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	if df := res.DirectoryProof(); res.Error != protocol.ReqSuccess ||
+		!bytes.Equal(df.AP[0].Leaf.Value, tb.Value) {
+		t.Fatal("Expect the freeze to be included")
+	}
+	for _, tc := range []struct {
+		name string
+		res  *protocol.Response
+		want error
+	}{
+		{"register frozen value", d.Register(&protocol.RegistrationRequest{Username: "bob",
+			Key: tb.Value}), protocol.ErrMalformedMessage},
+		{"key change", d.KeyChange(&protocol.KeyChangeRequest{Username: "alice",
+			Key: []byte("new")}), protocol.ReqNameFrozen},
+		{"deactivate", d.Deactivate(&protocol.DeactivationRequest{Username: "alice"}),
+			protocol.ReqNameFrozen},
+	} {
+		if tc.res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", tc.res.Error)
+		}
+	}
+	if _, err := d.Freeze("alice"); err != protocol.ReqNameFrozen {
+		t.Fatal("Expect", protocol.ReqNameFrozen, "got", err)
+	}
+
+	if _, err := d.Unfreeze("alice"); err != nil {
+		t.Fatal(err)
+	}
+	d.Update()
+	res = d.KeyChange(&protocol.KeyChangeRequest{Username: "alice", Key: []byte("new")})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+}
+
+func TestSignedKeyChange(t *testing.T) {
+	d := NewTestDirectory(t)
+	userKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := userKey.Public()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: pk})
+	d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+	d.Update()
+
+	// a signature on the change to another key
+	tampered := protocol.NewKeyChangeRequest(userKey, "alice", pk, []byte("other"))
+	tampered.Key = []byte("new")
+	for _, tc := range []struct {
+		name string
+		req  *protocol.KeyChangeRequest
+		want protocol.ErrorCode
+	}{
+		{"unsigned", &protocol.KeyChangeRequest{Username: "alice", Key: []byte("new")},
+			protocol.ErrMalformedMessage},
+		{"signed with another key",
+			protocol.NewKeyChangeRequest(otherKey, "alice", pk, []byte("new")),
+			protocol.ErrMalformedMessage},
+		{"signed for another key", tampered, protocol.ErrMalformedMessage},
+		{"not a signing key",
+			protocol.NewKeyChangeRequest(userKey, "bob", []byte("key"), []byte("new")),
+			protocol.ErrMalformedMessage},
+		{"signed", protocol.NewKeyChangeRequest(userKey, "alice", pk, []byte("new")),
+			protocol.ReqSuccess},
+	} {
+		if res := d.KeyChange(tc.req); res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+func TestRegisterProfile(t *testing.T) {
+	d := NewTestDirectory(t)
+	masterKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []*profile.Device{{ID: "phone", Key: []byte("phone key")}}
+	newProfile := func(expiry time.Time) []byte {
+		p, err := profile.New(masterKey, devices, expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Marshal()
+	}
+	valid := newProfile(time.Now().Add(time.Hour))
+	forged, _ := profile.Parse(newProfile(time.Now().Add(time.Hour)))
+	forged.Devices[0].Key = []byte("forged")
+	for _, tc := range []struct {
+		name string
+		key  []byte
+		want protocol.ErrorCode
+	}{
+		{"expired", newProfile(time.Now().Add(-time.Hour)), protocol.ErrMalformedMessage},
+		{"forged", forged.Marshal(), protocol.ErrMalformedMessage},
+		{"malformed", append(valid, '}'), protocol.ErrMalformedMessage},
+		{"valid", valid, protocol.ReqSuccess},
+	} {
+		res := d.Register(&protocol.RegistrationRequest{Username: "alice", Key: tc.key})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+	d.Update()
+
+	// the changes of the profile are signed by its master key
+	devices = append(devices, &profile.Device{ID: "laptop", Key: []byte("laptop key")})
+	next := newProfile(time.Now().Add(time.Hour))
+	req := protocol.NewKeyChangeRequest(deviceKey, "alice", valid, next)
+	if res := d.KeyChange(req); res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+	req = protocol.NewKeyChangeRequest(masterKey, "alice", valid, next)
+	if res := d.KeyChange(req); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+}
+
+func TestAbortKeyChange(t *testing.T) {
+	d := NewTestDirectory(t)
+	userKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := userKey.Public()
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: pk})
+	d.Update()
+
+	req := &protocol.KeyChangeAbortRequest{Username: "alice"}
+	if res := d.AbortKeyChange(req); res.Error != protocol.ReqNoPendingChange {
+		t.Fatal("Expect", protocol.ReqNoPendingChange, "got", res.Error)
+	}
+	res := d.KeyChange(protocol.NewKeyChangeRequest(userKey, "alice", pk, []byte("new")))
+	tb := res.DirectoryProof().TB
+
+	other, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = protocol.NewKeyChangeAbortRequest(other, "alice", tb)
+	if res := d.AbortKeyChange(req); res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+	req = protocol.NewKeyChangeAbortRequest(userKey, "alice", tb)
+	if res := d.AbortKeyChange(req); res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	if res := d.AbortKeyChange(req); res.Error != protocol.ReqNoPendingChange {
+		t.Fatal("Expect", protocol.ReqNoPendingChange, "got", res.Error)
+	}
+
+	// the previous key is kept in the next snapshot
+	d.Update()
+	res = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+	df := res.DirectoryProof()
+	if !bytes.Equal(df.AP[0].Leaf.Value, pk) || df.TB != nil {
+		t.Fatal("Expect the previous key to be kept")
+	}
+
+	// a change can't be aborted once it has taken effect
+	res = d.KeyChange(protocol.NewKeyChangeRequest(userKey, "alice", pk, []byte("new")))
+	tb = res.DirectoryProof().TB
+	d.Update()
+	req = protocol.NewKeyChangeAbortRequest(userKey, "alice", tb)
+	if res := d.AbortKeyChange(req); res.Error != protocol.ReqNoPendingChange {
+		t.Fatal("Expect", protocol.ReqNoPendingChange, "got", res.Error)
+	}
+}
+
+func TestDirectoryRestoreKeyChange(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key"),
+			AllowUnsignedKeychange: true})
+		d.Register(&protocol.RegistrationRequest{Username: "bob", Key: []byte("key")})
+		d.Update()
+		d.KeyChange(&protocol.KeyChangeRequest{Username: "alice", Key: []byte("new")})
+
+		restored, err := Restore(db, 10, 1, vrfKey, signKey, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the pending change is reissued
+		res := restored.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+		tb := res.DirectoryProof().TB
+		if tb == nil || !bytes.Equal(tb.Value, []byte("new")) ||
+			!bytes.Equal(tb.PreviousValue, []byte("key")) {
+			t.Fatal("Expect the reissued key change TB for alice")
+		}
+		res = restored.KeyLookup(&protocol.KeyLookupRequest{Username: "bob"})
+		if res.DirectoryProof().TB != nil {
+			t.Fatal("Expect no pending change for bob")
+		}
+		// alice's key changes still don't need to be signed, unlike bob's
+		restored.Update()
+		res = restored.KeyChange(&protocol.KeyChangeRequest{Username: "alice", Key: []byte("key")})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		res = restored.KeyChange(&protocol.KeyChangeRequest{Username: "bob", Key: []byte("new")})
+		if res.Error != protocol.ErrMalformedMessage {
+			t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+		}
+	})
+}
+
+func TestPublishPolicyDocument(t *testing.T) {
+	d := NewTestDirectory(t)
+	if res := d.GetPolicies(&protocol.PoliciesRequest{}); res.Error != protocol.ReqNoPolicyDocument {
+		t.Fatal("Expect", protocol.ReqNoPolicyDocument, "got", res.Error)
+	}
+	d.SetLimits(Limits{MaxBindings: 10}, nil)
+	d.PublishPolicyDocument(true)
+	// the document is published from the next epoch on
+	if res := d.GetPolicies(&protocol.PoliciesRequest{}); res.Error != protocol.ReqNoPolicyDocument {
+		t.Fatal("Expect", protocol.ReqNoPolicyDocument, "got", res.Error)
+	}
+
+	// the new policies are included in the second STR from now on,
+	// along with the document describing them
+	d.SetPolicies(2)
+	d.Update()
+	d.Update()
+	res := d.GetPolicies(&protocol.PoliciesRequest{})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	p := res.PolicyDocumentProof()
+	if p.STR != d.LatestSTR() {
+		t.Fatal("Expect the document for the latest STR")
+	}
+	doc, err := p.Document.Decode(p.STR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.EpochDeadline != 2 || !doc.Registration.RequireAttestation ||
+		doc.Registration.MaxBindings != 10 ||
+		!doc.Supports(protocol.ExtensionTBs) || !doc.Supports(protocol.ExtensionKeyChanges) {
+		t.Fatal("Unexpected policy document", doc)
+	}
+}
+
+func TestSetBeacon(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.PublishPolicyDocument(false)
+	d.SetBeacon(&protocol.BeaconValue{Round: 7, Randomness: []byte{1, 2, 3}})
+	d.Update()
+	str := d.LatestSTR()
+	b, err := str.Beacon()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.Round != 7 || !bytes.Equal(b.Randomness, []byte{1, 2, 3}) {
+		t.Fatal("Expect the beacon value of round 7, got", b)
+	}
+	if str.Extensions[protocol.PolicyDocumentExtension] == nil {
+		t.Fatal("Expect the STR to commit to the policy document as well")
+	}
+
+	d.SetBeacon(nil)
+	d.Update()
+	if b, err := d.LatestSTR().Beacon(); b != nil || err != nil {
+		t.Fatal("Expect no beacon value, got", b, err)
+	}
+}
+
+func TestDirectoryRestorePolicyDocument(t *testing.T) {
+	vrfKey := crypto.NewStaticTestVRFKey()
+	signKey := crypto.NewStaticTestSigningKey()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, vrfKey, signKey, 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		d.PublishPolicyDocument(false)
+		d.Update()
+		want := d.GetPolicies(&protocol.PoliciesRequest{}).PolicyDocumentProof()
+
+		restored, err := Restore(db, 10, 1, vrfKey, signKey, 10, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored.PublishPolicyDocument(false)
+		res := restored.GetPolicies(&protocol.PoliciesRequest{})
+		if res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		got := res.PolicyDocumentProof()
+		if !bytes.Equal(got.Document.Document, want.Document.Document) {
+			t.Fatal("Expect the same policy document")
+		}
+	})
+}
+
+func TestRegisterIdentifierPolicies(t *testing.T) {
+	d := NewTestDirectory(t)
+	d.SetIdentifierPolicies(map[protocol.IdentifierType]protocol.IdentifierPolicy{
+		protocol.DeviceIdentifier:  {RequireAttestation: true},
+		protocol.ServiceIdentifier: {Disabled: true},
+	})
+	for _, tc := range []struct {
+		name string
+		want protocol.ErrorCode
+	}{
+		{"alice", protocol.ReqSuccess},
+		{"user:bob", protocol.ErrMalformedMessage},
+		{"device:", protocol.ErrMalformedMessage},
+		{"device:thermostat-42", protocol.ReqMissingAttestation},
+		{"service:backup", protocol.ReqIDTypeDisabled},
+	} {
+		res := d.Register(&protocol.RegistrationRequest{
+			Username: tc.name,
+			Key:      []byte("key"),
+		})
+		if res.Error != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", res.Error)
+		}
+	}
+}
+
+func TestBoundedResponseSizes(t *testing.T) {
+	d := New(1, crypto.NewStaticTestVRFKey(), crypto.NewStaticTestSigningKey(), 100, true)
+	d.Register(&protocol.RegistrationRequest{Username: "alice", Key: []byte("key")})
+	for i := 0; i < 40; i++ {
+		d.Update()
+	}
+
+	for _, tc := range []struct {
+		name  string
+		fetch func(start uint64) *protocol.Response
+	}{
+		{"monitoring", func(start uint64) *protocol.Response {
+			return d.Monitor(&protocol.MonitoringRequest{
+				Username: "alice", StartEpoch: start, EndEpoch: 40})
+		}},
+		{"STR history", func(start uint64) *protocol.Response {
+			return d.GetSTRHistory(&protocol.STRHistoryRequest{
+				StartEpoch: start, EndEpoch: 40})
+		}},
+	} {
+		var covered uint64
+		for start := uint64(0); ; {
+			res := tc.fetch(start)
+			bs, err := json.Marshal(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bs) > protocol.MaxResponseSize {
+				t.Error(tc.name, "expect at most", protocol.MaxResponseSize, "bytes, got", len(bs))
+			}
+			var strs []*protocol.DirSTR
+			var next *protocol.Continuation
+			switch r := res.DirectoryResponse.(type) {
+			case *protocol.DirectoryProof:
+				strs, next = r.STR, r.Continuation
+			case *protocol.STRHistoryRange:
+				strs, next = r.STR, r.Continuation
+			}
+			covered = strs[len(strs)-1].Epoch
+			if next == nil {
+				break
+			}
+			if next.NextEpoch != covered+1 {
+				t.Fatal(tc.name, "expect the continuation at", covered+1, "got", next.NextEpoch)
+			}
+			start = next.NextEpoch
+		}
+		if covered != 40 {
+			t.Error(tc.name, "expect the range to be covered up to", 40, "got", covered)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	d := NewTestDirectory(t)
+	res := d.Handle(&protocol.Request{
+		Type: protocol.RegistrationType,
+		Request: &protocol.RegistrationRequest{
+			Username: "alice",
+			Key:      []byte("key")}})
+	if res.Error != protocol.ReqSuccess {
+		t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+	}
+	// the request's content doesn't match its type
+	res = d.Handle(&protocol.Request{
+		Type: protocol.KeyLookupType,
+		Request: &protocol.RegistrationRequest{
+			Username: "bob",
+			Key:      []byte("key")}})
+	if res.Error != protocol.ErrMalformedMessage {
+		t.Fatal("Expect", protocol.ErrMalformedMessage, "got", res.Error)
+	}
+}
@@ -0,0 +1,101 @@
+//go:build faults
+// +build faults
+
+package directory
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils"
+	"github.com/coniks-sys/coniks-go/utils/faults"
+)
+
+func TestRegisterStorageFailure(t *testing.T) {
+	defer faults.Reset()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, crypto.NewStaticTestVRFKey(),
+			crypto.NewStaticTestSigningKey(), 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		faults.Inject(faults.StorageWrite, &faults.Fault{})
+		req := &protocol.RegistrationRequest{Username: "alice", Key: []byte("key")}
+		if res := d.Register(req); res.Error != protocol.ErrDirectory {
+			t.Fatal("Expect", protocol.ErrDirectory, "got", res.Error)
+		}
+		faults.Clear(faults.StorageWrite)
+		// the failed registration leaves no trace
+		if res := d.Register(req); res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+	})
+}
+
+func TestRegisterUnsignedStorageFailure(t *testing.T) {
+	defer faults.Reset()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, crypto.NewStaticTestVRFKey(),
+			crypto.NewStaticTestSigningKey(), 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		faults.Inject(faults.StorageWrite, &faults.Fault{})
+		req := &protocol.RegistrationRequest{Username: "alice", Key: []byte("key"),
+			AllowUnsignedKeychange: true}
+		if res := d.Register(req); res.Error != protocol.ErrDirectory {
+			t.Fatal("Expect", protocol.ErrDirectory, "got", res.Error)
+		}
+		faults.Clear(faults.StorageWrite)
+		// the unsigned key changes aren't recorded for a failed registration
+		if _, err := db.Get(unsignedKey("alice")); err != db.ErrNotFound() {
+			t.Fatal("Expect no record of unsigned key changes, got", err)
+		}
+		if d.unsigned["alice"] {
+			t.Fatal("Expect alice's key changes to need a signature")
+		}
+	})
+}
+
+func TestUpdateStorageFailure(t *testing.T) {
+	defer faults.Reset()
+	utils.WithDB(func(db kv.DB) {
+		d := New(1, crypto.NewStaticTestVRFKey(),
+			crypto.NewStaticTestSigningKey(), 10, true)
+		if err := d.Persist(db, 10); err != nil {
+			t.Fatal(err)
+		}
+		req := &protocol.RegistrationRequest{Username: "alice", Key: []byte("key")}
+		if res := d.Register(req); res.Error != protocol.ReqSuccess {
+			t.Fatal("Expect", protocol.ReqSuccess, "got", res.Error)
+		}
+		str0 := d.LatestSTR()
+
+		faults.Inject(faults.StorageWrite, &faults.Fault{})
+		if err := d.Update(); err != faults.ErrInjected {
+			t.Fatal("Expect", faults.ErrInjected, "got", err)
+		}
+		// the directory keeps serving the previous STR and its TBs
+		if d.LatestSTR() != str0 {
+			t.Fatal("Expect the previous STR to remain the latest STR")
+		}
+		lookup := d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+		if df := lookup.DirectoryProof(); df.TB == nil {
+			t.Fatal("Expect the TB for alice to be kept")
+		}
+
+		faults.Clear(faults.StorageWrite)
+		if err := d.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if str := d.LatestSTR(); str.Epoch != 1 || !str.VerifyHashChain(str0) {
+			t.Fatal("Expect next STR in hash chain")
+		}
+		lookup = d.KeyLookup(&protocol.KeyLookupRequest{Username: "alice"})
+		if lookup.Error != protocol.ReqSuccess || lookup.DirectoryProof().TB != nil {
+			t.Fatal("Expect alice's binding to be included")
+		}
+	})
+}
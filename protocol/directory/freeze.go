@@ -0,0 +1,93 @@
+// This module implements the freezes with which the operator of
+// a CONIKS directory stops the changes of a binding, e.g., as
+// a moderation action, transparently: a freeze is a key change to
+// a frozen value which the directory promises and commits to like
+// any other change, so that clients can tell it from a silent edit.
+
+package directory
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+var (
+	// ErrNotFrozen indicates that the operator tried to unfreeze
+	// a binding which isn't frozen.
+	ErrNotFrozen = errors.New("[directory] The binding isn't frozen")
+)
+
+// Freeze freezes the binding of uname on behalf of the directory's
+// operator, i.e., changes its key to protocol.Frozen(key), where key
+// is the key uname is bound to in the latest snapshot, and returns
+// the TB promising the freeze in the next snapshot
+// (see NewKeyChangeTB()).
+//
+// Once the freeze is included in a snapshot, the directory rejects
+// the key changes and deactivations of uname with a ReqNameFrozen,
+// until the operator unfreezes the binding (see Unfreeze()). Unlike
+// the user's key changes, a pending freeze can't be aborted by the
+// user (see AbortKeyChange()).
+//
+// Freeze() returns a ReqNameNotFound if uname isn't included in the
+// latest snapshot, a ReqNameDeactivated if its binding has been
+// deactivated, and a ReqNameFrozen if it is already frozen.
+// Since the directory allows only one change per epoch, it returns
+// a ReqNameExisted if a change is already pending for uname, in which
+// case the operator can freeze the binding in the next epoch.
+// If Freeze() encounters an internal error, it returns an
+// ErrDirectory.
+func (d *ConiksDirectory) Freeze(uname string) (*protocol.TemporaryBinding, error) {
+	return d.operatorChange(uname, func(value []byte) ([]byte, error) {
+		if protocol.IsFrozen(value) {
+			return nil, protocol.ReqNameFrozen
+		}
+		return protocol.Frozen(value), nil
+	})
+}
+
+// Unfreeze unfreezes the binding of uname frozen by the directory's
+// operator (see Freeze()), i.e., changes it back to its key, and
+// returns the TB promising the change in the next snapshot.
+// Unfreeze() returns an ErrNotFrozen if the binding of uname isn't
+// frozen in the latest snapshot, and the errors of Freeze() otherwise.
+func (d *ConiksDirectory) Unfreeze(uname string) (*protocol.TemporaryBinding, error) {
+	return d.operatorChange(uname, func(value []byte) ([]byte, error) {
+		if !protocol.IsFrozen(value) {
+			return nil, ErrNotFrozen
+		}
+		return protocol.FrozenKey(value), nil
+	})
+}
+
+// operatorChange changes the value bound to uname in the latest
+// snapshot to the value which change returns for it, and returns
+// the TB of the change (see Freeze()).
+func (d *ConiksDirectory) operatorChange(uname string,
+	change func(value []byte) ([]byte, error)) (*protocol.TemporaryBinding, error) {
+	ap, err := d.pad.Lookup(uname)
+	if err != nil {
+		return nil, protocol.ErrDirectory
+	}
+	if !bytes.Equal(ap.LookupIndex, ap.Leaf.Index) {
+		return nil, protocol.ReqNameNotFound
+	}
+	if protocol.IsTombstone(ap.Leaf.Value) {
+		return nil, protocol.ReqNameDeactivated
+	}
+	if d.changes[uname] != nil {
+		return nil, protocol.ReqNameExisted
+	}
+	value, err := change(ap.Leaf.Value)
+	if err != nil {
+		return nil, err
+	}
+	tb := d.NewKeyChangeTB(uname, ap.Leaf.Value, value)
+	if err := d.pad.Set(uname, value); err != nil {
+		return nil, protocol.ErrDirectory
+	}
+	d.changes[uname] = tb
+	return tb, nil
+}
@@ -0,0 +1,84 @@
+// This module implements the dispatch of the requests a CONIKS
+// directory receives to its operation handlers, which the key server
+// and the embedded directory share.
+
+package directory
+
+import (
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// Handle passes the request req to the appropriate operation handler
+// of the directory according to the request type, and returns the
+// directory's response. It returns
+// protocol.NewErrorResponse(protocol.ErrMalformedMessage) if req's
+// type is unknown or doesn't match its content.
+//
+// Handle doesn't synchronize the operations itself: the caller must
+// follow the rules for the concurrent use of a ConiksDirectory (see
+// protocol.ReadOnly()).
+func (d *ConiksDirectory) Handle(req *protocol.Request) *protocol.Response {
+	switch req.Type {
+	case protocol.RegistrationType:
+		if msg, ok := req.Request.(*protocol.RegistrationRequest); ok {
+			return d.Register(msg)
+		}
+	case protocol.KeyChangeType:
+		if msg, ok := req.Request.(*protocol.KeyChangeRequest); ok {
+			return d.KeyChange(msg)
+		}
+	case protocol.KeyChangeAbortType:
+		if msg, ok := req.Request.(*protocol.KeyChangeAbortRequest); ok {
+			return d.AbortKeyChange(msg)
+		}
+	case protocol.DeactivationType:
+		if msg, ok := req.Request.(*protocol.DeactivationRequest); ok {
+			return d.Deactivate(msg)
+		}
+	case protocol.KeyLookupType:
+		if msg, ok := req.Request.(*protocol.KeyLookupRequest); ok {
+			return d.KeyLookup(msg)
+		}
+	case protocol.BatchKeyLookupType:
+		if msg, ok := req.Request.(*protocol.BatchKeyLookupRequest); ok {
+			return d.BatchKeyLookup(msg)
+		}
+	case protocol.KeyLookupInEpochType:
+		if msg, ok := req.Request.(*protocol.KeyLookupInEpochRequest); ok {
+			return d.KeyLookupInEpoch(msg)
+		}
+	case protocol.MonitoringType:
+		if msg, ok := req.Request.(*protocol.MonitoringRequest); ok {
+			return d.Monitor(msg)
+		}
+	case protocol.STRType:
+		if msg, ok := req.Request.(*protocol.STRHistoryRequest); ok {
+			return d.GetSTRHistory(msg)
+		}
+	case protocol.KeyHistoryType:
+		if msg, ok := req.Request.(*protocol.KeyHistoryRequest); ok {
+			return d.KeyHistory(msg)
+		}
+	case protocol.PoliciesType:
+		if msg, ok := req.Request.(*protocol.PoliciesRequest); ok {
+			return d.GetPolicies(msg)
+		}
+	case protocol.EmptyRangeType:
+		if msg, ok := req.Request.(*protocol.EmptyRangeRequest); ok {
+			return d.ProveEmptyRange(msg)
+		}
+	case protocol.SampleType:
+		if msg, ok := req.Request.(*protocol.SampleRequest); ok {
+			return d.Sample(msg)
+		}
+	case protocol.SubtreeType:
+		if msg, ok := req.Request.(*protocol.SubtreeRequest); ok {
+			return d.ProveSubtree(msg)
+		}
+	case protocol.EpochDeltaType:
+		if msg, ok := req.Request.(*protocol.EpochDeltaRequest); ok {
+			return d.EpochDelta(msg)
+		}
+	}
+	return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+}
@@ -0,0 +1,126 @@
+// This module implements the publication of a CONIKS directory's
+// policy document (see protocol.PolicyDocument). A directory which
+// publishes its policy document commits to the hash of the document
+// in the STR of each epoch, in addition to the policies included in
+// the STR.
+
+package directory
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// PublishPolicyDocument makes this ConiksDirectory publish its policy
+// document from the next epoch on. The document describes the
+// directory's policies, its limits (see SetLimits()), the username
+// suffixes for which it accepts attestations (see SetAttestationKeys()),
+// its identifier policies (see SetIdentifierPolicies()), its name
+// policies (see SetNamePolicies()), and the protocol extensions it
+// supports. requireAttestation indicates whether the clients must
+// include an attestation in their registrations
+// (see RegisterWithAttestation()).
+//
+// PublishPolicyDocument() must be called after the directory's other
+// settings. If the latest STR already commits to the same document,
+// e.g., because the directory has been restored with the same
+// settings, the document is published for the latest epoch right away.
+func (d *ConiksDirectory) PublishPolicyDocument(requireAttestation bool) {
+	d.publishPolicies = true
+	d.requireAttestation = requireAttestation
+	str := d.LatestSTR()
+	ext := str.Extensions[protocol.PolicyDocumentExtension]
+	if ext == nil {
+		return
+	}
+	doc, err := d.newPolicyDocument(str.Policies)
+	if err == nil && bytes.Equal(ext.Value, doc.Hash()) {
+		d.cachePolicyDocument(doc)
+	}
+}
+
+// newPolicyDocument creates the policy document of this
+// ConiksDirectory describing the policies p, and signs it.
+func (d *ConiksDirectory) newPolicyDocument(p *protocol.Policies) (*protocol.SignedPolicyDocument, error) {
+	var suffixes []string
+	for suffix := range d.attestationKeys {
+		suffixes = append(suffixes, suffix)
+	}
+	sort.Strings(suffixes)
+	rules := protocol.RegistrationRules{
+		RequireAttestation:       d.requireAttestation,
+		AttestationSuffixes:      suffixes,
+		MaxBindings:              d.limits.MaxBindings,
+		MaxRegistrationsPerEpoch: d.limits.MaxRegistrationsPerEpoch,
+		Identifiers:              d.idPolicies,
+		Names:                    d.namePolicies,
+	}
+	var exts []string
+	if d.useTBs {
+		exts = append(exts, protocol.ExtensionTBs)
+	}
+	exts = append(exts, protocol.ExtensionKeyChanges)
+
+	bs, err := json.Marshal(protocol.NewPolicyDocument(p, rules, exts))
+	if err != nil {
+		return nil, err
+	}
+	doc := &protocol.SignedPolicyDocument{Document: bs}
+	doc.Signature = d.pad.Sign(doc.Serialize())
+	return doc, nil
+}
+
+// commitPolicyDocument sets the extensions of the next STR of this
+// ConiksDirectory, which commit to the policy document for the
+// policies included in this STR, and returns the document.
+// It returns nil if the directory doesn't publish its policy document.
+func (d *ConiksDirectory) commitPolicyDocument() *protocol.SignedPolicyDocument {
+	if !d.publishPolicies {
+		return nil
+	}
+	doc, err := d.newPolicyDocument(d.pad.AssocData().(*protocol.Policies))
+	if err != nil {
+		panic(err)
+	}
+	exts := make(map[string]*merkletree.STRExtension, len(d.extensions)+1)
+	for name, ext := range d.extensions {
+		exts[name] = ext
+	}
+	exts[protocol.PolicyDocumentExtension] = &merkletree.STRExtension{
+		Value: doc.Hash(),
+	}
+	d.pad.SetSTRExtensions(exts)
+	return doc
+}
+
+// cachePolicyDocument swaps the cached policy document with the
+// document doc committed to by the latest STR.
+func (d *ConiksDirectory) cachePolicyDocument(doc *protocol.SignedPolicyDocument) {
+	d.latestPolicies.Store(&protocol.PolicyDocumentProof{
+		STR:      d.LatestSTR(),
+		Document: doc,
+	})
+}
+
+// GetPolicies gets the policy document of this ConiksDirectory for its
+// latest epoch upon the PoliciesRequest req received from a CONIKS
+// client, and returns a protocol.Response.
+// The response (which also includes the error code) is supposed to
+// be sent back to the client.
+//
+// If the directory doesn't publish its policy document, or the latest
+// STR doesn't commit to a document yet, GetPolicies() returns a
+// message.NewErrorResponse(ReqNoPolicyDocument).
+// Otherwise, GetPolicies() returns a message.NewPoliciesResponse(p),
+// where p contains the latest STR and the document it commits to.
+func (d *ConiksDirectory) GetPolicies(req *protocol.PoliciesRequest) *protocol.Response {
+	p, ok := d.latestPolicies.Load().(*protocol.PolicyDocumentProof)
+	if !ok {
+		return protocol.NewErrorResponse(protocol.ReqNoPolicyDocument)
+	}
+	return protocol.NewPoliciesResponse(p)
+}
@@ -0,0 +1,62 @@
+// This module implements read-only snapshots of a CONIKS key
+// directory, which allow a key server to keep serving key lookups
+// while the directory is being updated.
+
+package directory
+
+import (
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+// A Snapshot is a read-only view of a ConiksDirectory's latest
+// snapshot and of the TBs issued in the latest epoch, including the
+// TBs of the pending key changes.
+// A Snapshot isn't affected by changes made to the directory after
+// its creation, so it can serve key lookups concurrently with
+// Register() and Update().
+type Snapshot struct {
+	pad     *merkletree.PAD
+	str     *protocol.DirSTR
+	tbs     map[string]*protocol.TemporaryBinding
+	changes map[string]*protocol.TemporaryBinding
+}
+
+// Snapshot creates a read-only view of the latest snapshot of this
+// ConiksDirectory. Snapshot() must not be called concurrently with
+// Register() or Update().
+func (d *ConiksDirectory) Snapshot() *Snapshot {
+	tbs := make(map[string]*protocol.TemporaryBinding, len(d.tbs))
+	for name, tb := range d.tbs {
+		tbs[name] = tb
+	}
+	changes := make(map[string]*protocol.TemporaryBinding, len(d.changes))
+	for name, tb := range d.changes {
+		changes[name] = tb
+	}
+	return &Snapshot{
+		pad:     d.pad,
+		str:     d.LatestSTR(),
+		tbs:     tbs,
+		changes: changes,
+	}
+}
+
+// KeyLookup gets the public key for the username indicated in the
+// KeyLookupRequest req from the snapshot s, following the semantics
+// of ConiksDirectory.KeyLookup().
+func (s *Snapshot) KeyLookup(req *protocol.KeyLookupRequest) *protocol.Response {
+	// make sure the request is well-formed
+	if len(req.Username) <= 0 {
+		return protocol.NewErrorResponse(protocol.ErrMalformedMessage)
+	}
+	ap := s.pad.LookupInSTR(req.Username, s.str.SignedTreeRoot)
+	return newKeyLookupProof(req.Username, ap, s.str, s.tbs, s.changes)
+}
+
+// BatchKeyLookup gets the public keys for the usernames indicated in
+// the BatchKeyLookupRequest req from the snapshot s, following the
+// semantics of ConiksDirectory.BatchKeyLookup().
+func (s *Snapshot) BatchKeyLookup(req *protocol.BatchKeyLookupRequest) *protocol.Response {
+	return batchKeyLookup(req.Usernames, s.pad, s.str, s.tbs, s.changes)
+}
@@ -14,5 +14,6 @@ func NewTestDirectory(t *testing.T) *ConiksDirectory {
 	signKey := crypto.NewStaticTestSigningKey()
 	d := New(1, vrfKey, signKey, 10, true)
 	d.pad = merkletree.StaticPAD(t, d.policies)
+	d.cacheLatestSTR()
 	return d
 }
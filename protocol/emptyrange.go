@@ -0,0 +1,62 @@
+// Defines the messages with which a CONIKS directory proves that its
+// tree doesn't contain any leaf within a range of lookup indices.
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/merkletree"
+
+// An EmptyRangeRequest is a message that a CONIKS auditor or client
+// sends to a CONIKS directory to obtain a proof that the directory's
+// tree for the epoch Epoch doesn't contain any leaf whose lookup index
+// starts with the first PrefixBits bits of Prefix. Sampling such ranges
+// lets auditors and researchers estimate the number of users in the
+// directory without learning their lookup indices. PrefixBits must be
+// positive, and must not exceed merkletree.MaxRangePrefixBits.
+//
+// The response to a successful request is an EmptyRangeProof.
+type EmptyRangeRequest struct {
+	Prefix     []byte
+	PrefixBits uint32
+	Epoch      uint64
+}
+
+// An EmptyRangeProof response includes the proof Proof that a range of
+// lookup indices is empty, and a list of STRs covering the epoch range
+// [Epoch, d.LatestSTR().Epoch], where Epoch is the requested epoch.
+// Continuation is set if the list only covers a prefix of this range
+// (see Continuation).
+type EmptyRangeProof struct {
+	Proof        *merkletree.EmptyRangeProof
+	STR          []*DirSTR
+	Continuation *Continuation `json:",omitempty"`
+}
+
+var _ DirectoryResponse = (*EmptyRangeProof)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*EmptyRangeProof) ResponseType() string { return "EmptyRangeProof" }
+
+func (p *EmptyRangeProof) validate() error {
+	if len(p.STR) == 0 || p.Proof == nil || !validSTRs(p.STR) {
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// NewEmptyRangeProof creates the response message a CONIKS directory
+// sends to a client upon an EmptyRangeRequest, and returns a Response
+// containing an EmptyRangeProof struct.
+// directory.ProveEmptyRange() passes the proof p, a list of signed tree
+// roots for the requested range of epochs str, and the continuation
+// next if the range had to be cut short, or nil.
+func NewEmptyRangeProof(p *merkletree.EmptyRangeProof, str []*DirSTR,
+	next *Continuation) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &EmptyRangeProof{
+			Proof:        p,
+			STR:          str,
+			Continuation: next,
+		},
+	}
+}
@@ -5,6 +5,10 @@
 
 package protocol
 
+import (
+	"sort"
+)
+
 // An ErrorCode implements the built-in error interface type.
 type ErrorCode int
 
@@ -23,6 +27,45 @@ const (
 	ErrDirectory
 	ErrAuditLog
 	ErrMalformedMessage
+
+	// server/auditor->client: the request was dropped because
+	// the client exceeded the server's or auditor's rate limits
+	ReqRateLimited
+	// server->client: the request was dropped because the directory
+	// is being updated and the server is shedding load
+	ReqRetryLater
+	// server->client: the registration was rejected because the
+	// directory has reached one of its size limits
+	ReqLimitExceeded
+	// client: the verification API doesn't support responses
+	// to this request type
+	ErrUnsupportedRequest
+	// server->client: the registration was rejected because its
+	// attestation is expired, or isn't signed by the account
+	// verification bot the server trusts for the username
+	ReqBadAttestation
+	// server->client: the registration was rejected because it
+	// doesn't include the attestation required by the server
+	ReqMissingAttestation
+	// server->client: there is no pending key change to abort
+	// for the username, e.g., because it has already taken effect
+	ReqNoPendingChange
+	// server->client: the directory doesn't publish a policy
+	// document for its latest epoch
+	ReqNoPolicyDocument
+	// server->client: the registration was rejected because the
+	// directory doesn't accept identifiers of this type
+	ReqIDTypeDisabled
+	// server->client: the directory contains a leaf in the requested
+	// index range, so it cannot prove that the range is empty
+	ReqRangeNotEmpty
+	// server->client: the key change or deactivation was rejected
+	// because the binding of the username has been deactivated
+	ReqNameDeactivated
+	// server->client: the key change, deactivation or abort was
+	// rejected because the directory's operator has frozen the
+	// binding of the username
+	ReqNameFrozen
 )
 
 // These codes indicate the result
@@ -38,6 +81,16 @@ const (
 	CheckBadSTR
 	CheckBadPromise
 	CheckBrokenPromise
+	CheckBadPolicyTransition
+	CheckUnsupportedSTR
+	CheckBadPolicyDocument
+	CheckUnsupportedDocument
+	CheckUnconfirmedSTR
+	CheckBadBootstrap
+	CheckBadBeacon
+	CheckNoQuorum
+	CheckAuditorDisagrees
+	CheckUnsupportedSuite
 )
 
 // errors contains codes indicating the client
@@ -46,34 +99,133 @@ const (
 // a malformed client request, an internal server error or
 // due to a malformed server response.
 var errors = map[error]bool{
-	ErrMalformedMessage: true,
-	ErrDirectory:        true,
-	ErrAuditLog:         true,
+	ErrMalformedMessage:   true,
+	ErrDirectory:          true,
+	ErrAuditLog:           true,
+	ReqRateLimited:        true,
+	ReqRetryLater:         true,
+	ReqLimitExceeded:      true,
+	ReqBadAttestation:     true,
+	ReqMissingAttestation: true,
+	ReqNoPendingChange:    true,
+	ReqNoPolicyDocument:   true,
+	ReqIDTypeDisabled:     true,
+	ReqRangeNotEmpty:      true,
+	ReqUnknownDirectory:   true,
 }
 
 var (
 	errorMessages = map[ErrorCode]string{
-		ReqSuccess:      "[coniks] Successful client request",
-		ReqNameExisted:  "[coniks] Registering identity is already registered",
-		ReqNameNotFound: "[coniks] Searched name not found in directory",
-
-		ErrMalformedMessage: "[coniks] Malformed message",
-		ErrDirectory:        "[coniks] Directory error",
-		ErrAuditLog:         "[coniks] Audit log error",
-
-		CheckBadSignature:   "[coniks] Directory's signature on STR or TB is invalid",
-		CheckBadVRFProof:    "[coniks] Returned index is not valid for the given name",
-		CheckBindingsDiffer: "[coniks] The key in the binding is inconsistent with our expectation",
-		CheckBadCommitment:  "[coniks] The name-to-key binding commitment is not verifiable",
-		CheckBadLookupIndex: "[coniks] The lookup index is inconsistent with the index of the proof node",
-		CheckBadAuthPath:    "[coniks] Returned binding is inconsistent with the tree root hash",
-		CheckBadSTR:         "[coniks] The hash chain is inconsistent",
-		CheckBadPromise:     "[coniks] The directory returned an invalid registration promise",
-		CheckBrokenPromise:  "[coniks] The directory broke the registration promise",
+		ReqSuccess:            "[coniks] Successful client request",
+		ReqNameExisted:        "[coniks] Registering identity is already registered",
+		ReqNameNotFound:       "[coniks] Searched name not found in directory",
+		ReqUnknownDirectory:   "[coniks] The auditor has no observed history for this directory",
+		ReqRateLimited:        "[coniks] Request dropped due to rate limiting",
+		ReqRetryLater:         "[coniks] Directory is updating, retry the request later",
+		ReqLimitExceeded:      "[coniks] Registration rejected, the directory has reached its size limit",
+		ReqBadAttestation:     "[coniks] Registration rejected, the account verification attestation is invalid",
+		ReqMissingAttestation: "[coniks] Registration rejected, an account verification attestation is required",
+		ReqNoPendingChange:    "[coniks] There is no pending key change for this name",
+		ReqNoPolicyDocument:   "[coniks] The directory doesn't publish a policy document",
+		ReqIDTypeDisabled:     "[coniks] Registration rejected, the directory doesn't accept identifiers of this type",
+		ReqRangeNotEmpty:      "[coniks] The requested index range is not empty",
+		ReqNameDeactivated:    "[coniks] The binding of this name has been deactivated",
+		ReqNameFrozen:         "[coniks] The binding of this name has been frozen by the directory's operator",
+
+		ErrMalformedMessage:   "[coniks] Malformed message",
+		ErrDirectory:          "[coniks] Directory error",
+		ErrAuditLog:           "[coniks] Audit log error",
+		ErrUnsupportedRequest: "[coniks] Unsupported request type",
+
+		CheckBadSignature:        "[coniks] Directory's signature on STR or TB is invalid",
+		CheckBadVRFProof:         "[coniks] Returned index is not valid for the given name",
+		CheckBindingsDiffer:      "[coniks] The key in the binding is inconsistent with our expectation",
+		CheckBadCommitment:       "[coniks] The name-to-key binding commitment is not verifiable",
+		CheckBadLookupIndex:      "[coniks] The lookup index is inconsistent with the index of the proof node",
+		CheckBadAuthPath:         "[coniks] Returned binding is inconsistent with the tree root hash",
+		CheckBadSTR:              "[coniks] The hash chain is inconsistent",
+		CheckBadPromise:          "[coniks] The directory returned an invalid registration promise",
+		CheckBrokenPromise:       "[coniks] The directory broke the registration promise",
+		CheckBadPolicyTransition: "[coniks] The policy transitions are inconsistent with the STRs' policies",
+		CheckUnsupportedSTR:      "[coniks] The STR's header version or one of its critical extensions is not supported",
+		CheckBadPolicyDocument:   "[coniks] The policy document is inconsistent with the STR",
+		CheckUnsupportedDocument: "[coniks] The version of the policy document is not supported",
+		CheckUnconfirmedSTR:      "[coniks] The registration's STR hasn't been confirmed by an auditor",
+		CheckBadBootstrap:        "[coniks] The initial STR doesn't commit to the bootstrap seed",
+		CheckBadBeacon:           "[coniks] The STR's randomness beacon value is invalid or out of order",
+		CheckNoQuorum:            "[coniks] The STR isn't countersigned by a quorum of trusted auditors",
+		CheckAuditorDisagrees:    "[coniks] The directory's STR differs from the STR observed by an auditor",
+		CheckUnsupportedSuite:    "[coniks] The cipher suite declared in the directory's policies is not supported",
 	}
 )
 
+// errorNames maps each error code to the name of its constant, e.g.,
+// for the machine-readable description of the protocol.
+var errorNames = map[ErrorCode]string{
+	ReqSuccess:               "ReqSuccess",
+	ReqNameExisted:           "ReqNameExisted",
+	ReqNameNotFound:          "ReqNameNotFound",
+	ReqUnknownDirectory:      "ReqUnknownDirectory",
+	ReqRateLimited:           "ReqRateLimited",
+	ReqRetryLater:            "ReqRetryLater",
+	ReqLimitExceeded:         "ReqLimitExceeded",
+	ReqBadAttestation:        "ReqBadAttestation",
+	ReqMissingAttestation:    "ReqMissingAttestation",
+	ReqNoPendingChange:       "ReqNoPendingChange",
+	ReqNoPolicyDocument:      "ReqNoPolicyDocument",
+	ReqIDTypeDisabled:        "ReqIDTypeDisabled",
+	ReqRangeNotEmpty:         "ReqRangeNotEmpty",
+	ReqNameDeactivated:       "ReqNameDeactivated",
+	ReqNameFrozen:            "ReqNameFrozen",
+	ErrMalformedMessage:      "ErrMalformedMessage",
+	ErrDirectory:             "ErrDirectory",
+	ErrAuditLog:              "ErrAuditLog",
+	ErrUnsupportedRequest:    "ErrUnsupportedRequest",
+	CheckBadSignature:        "CheckBadSignature",
+	CheckBadVRFProof:         "CheckBadVRFProof",
+	CheckBindingsDiffer:      "CheckBindingsDiffer",
+	CheckBadCommitment:       "CheckBadCommitment",
+	CheckBadLookupIndex:      "CheckBadLookupIndex",
+	CheckBadAuthPath:         "CheckBadAuthPath",
+	CheckBadSTR:              "CheckBadSTR",
+	CheckBadPromise:          "CheckBadPromise",
+	CheckBrokenPromise:       "CheckBrokenPromise",
+	CheckBadPolicyTransition: "CheckBadPolicyTransition",
+	CheckUnsupportedSTR:      "CheckUnsupportedSTR",
+	CheckBadPolicyDocument:   "CheckBadPolicyDocument",
+	CheckUnsupportedDocument: "CheckUnsupportedDocument",
+	CheckUnconfirmedSTR:      "CheckUnconfirmedSTR",
+	CheckBadBootstrap:        "CheckBadBootstrap",
+	CheckBadBeacon:           "CheckBadBeacon",
+	CheckNoQuorum:            "CheckNoQuorum",
+	CheckAuditorDisagrees:    "CheckAuditorDisagrees",
+	CheckUnsupportedSuite:    "CheckUnsupportedSuite",
+}
+
 // Error returns the error message corresponding to the error code e.
 func (e ErrorCode) Error() string {
 	return errorMessages[e]
 }
+
+// Name returns the name of the constant of the error code e,
+// e.g., "ReqNameNotFound".
+func (e ErrorCode) Name() string {
+	return errorNames[e]
+}
+
+// SkipsChecks reports whether a response with the error code e carries
+// no proof, so that the client skips its consistency checks
+// (see Response.Validate()).
+func (e ErrorCode) SkipsChecks() bool {
+	return errors[e]
+}
+
+// ErrorCodes returns all the error codes, in increasing order.
+func ErrorCodes() []ErrorCode {
+	codes := make([]ErrorCode, 0, len(errorMessages))
+	for e := range errorMessages {
+		codes = append(codes, e)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
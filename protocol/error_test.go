@@ -0,0 +1,25 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestErrorCodesHaveNames(t *testing.T) {
+	codes := ErrorCodes()
+	if len(codes) != len(errorNames) {
+		t.Fatal("Expect", len(codes), "named error codes, got", len(errorNames))
+	}
+	for i, e := range codes {
+		if e.Name() == "" {
+			t.Error("Missing name of error code", int(e))
+		}
+		if i > 0 && codes[i-1] >= e {
+			t.Fatal("Expect the error codes in increasing order")
+		}
+	}
+	for e := range errors {
+		if e.(ErrorCode).Error() == "" {
+			t.Error("Missing message of error code", e.(ErrorCode).Name())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Defines the values with which a CONIKS directory records that its
+// operator has frozen the binding of a username, e.g., as a moderation
+// action.
+
+package protocol
+
+import (
+	"bytes"
+)
+
+// frozenPrefix prefixes the key of a frozen binding. It starts with
+// a zero byte, like the Tombstone, and the directory rejects the
+// registrations and key changes to values with this prefix, so that
+// a frozen value can't be mistaken for a user's key.
+var frozenPrefix = []byte("\x00coniks-frozen\x00")
+
+// Frozen returns the value a CONIKS directory binds to a username
+// whose binding to key has been frozen by the directory's operator.
+// A frozen binding keeps its key, which Frozen() wraps so that the
+// freeze is committed to in the directory's snapshots, and can't be
+// changed or deactivated by the user until the operator unfreezes it.
+//
+// The directory freezes a binding like it changes a key: it returns
+// a TB promising the change from key to Frozen(key) in the next epoch
+// (see TemporaryBinding.IsKeyChange()). Thus, a client can tell
+// a binding frozen by the operator, whose key is unchanged, from
+// a silent change of its key.
+func Frozen(key []byte) []byte {
+	var value []byte
+	value = append(value, frozenPrefix...)
+	value = append(value, key...)
+	return value
+}
+
+// IsFrozen returns true if value is the value of a frozen binding
+// (see Frozen()).
+func IsFrozen(value []byte) bool {
+	return bytes.HasPrefix(value, frozenPrefix)
+}
+
+// FrozenKey returns the key of the frozen binding whose value is value,
+// or nil if value isn't the value of a frozen binding.
+func FrozenKey(value []byte) []byte {
+	if !IsFrozen(value) {
+		return nil
+	}
+	return value[len(frozenPrefix):]
+}
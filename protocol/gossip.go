@@ -0,0 +1,29 @@
+// Defines the messages with which CONIKS auditors exchange the STRs
+// they have observed for the same directory
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/crypto"
+
+// MaxGossipSTRs is the maximum number of STRs a CONIKS auditor
+// includes in a single Gossip message.
+const MaxGossipSTRs = 8
+
+// A Gossip is a message with a CONIKS key directory's identity and
+// a list of STRs STR that a CONIKS auditor has observed for this
+// directory, in ascending order of their epochs, which the auditor
+// sends to another auditor of the same directory (its peer).
+// Comparing the STRs the auditors have observed for the same epochs
+// allows them to detect split-view attacks, in which a directory shows
+// different STR histories to different auditors.
+//
+// The response to a request is an STRHistoryRange with the STRs the
+// peer has observed for the gossiped epochs, followed by the latest
+// STR the peer has observed. A peer which has observed a different STR
+// for one of the gossiped epochs responds with a CheckBadSTR along
+// with its own STRs, so that the recipient can verify the evidence of
+// the split view.
+type Gossip struct {
+	DirInitSTRHash [crypto.HashSizeByte]byte
+	STR            []*DirSTR
+}
@@ -0,0 +1,105 @@
+// Defines the typed identifiers a CONIKS directory binds keys to
+
+package protocol
+
+import (
+	"strings"
+)
+
+// An IdentifierType is the type of the entity an identifier names.
+type IdentifierType string
+
+// These are the identifier types a CONIKS directory supports.
+// A UserIdentifier names a human user, and is written without its
+// type prefix, so that the usernames registered before the typed
+// identifiers were introduced keep their meaning. Any other identifier
+// is written with its type prefix, e.g., "device:thermostat-42".
+const (
+	UserIdentifier    IdentifierType = "user"
+	DeviceIdentifier  IdentifierType = "device"
+	ServiceIdentifier IdentifierType = "service"
+)
+
+// identifierSeparator separates the type prefix of an identifier
+// from its name.
+const identifierSeparator = ":"
+
+var identifierTypes = map[IdentifierType]bool{
+	UserIdentifier:    true,
+	DeviceIdentifier:  true,
+	ServiceIdentifier: true,
+}
+
+// Known returns true if t is one of the identifier types
+// a CONIKS directory supports.
+func (t IdentifierType) Known() bool {
+	return identifierTypes[t]
+}
+
+// An Identifier is a typed name a CONIKS directory binds a key to,
+// e.g., the name of a user, of a device or of a service account.
+//
+// The directory and its clients use the canonical string form of an
+// identifier (see String()) wherever the protocol messages contain
+// a username. Since the string form is also the VRF input the
+// identifier's private index is computed from, the type prefixes
+// separate the index domains of the identifier types: a username can't
+// start with the prefix of another type, so no two identifiers of
+// different types share an index.
+type Identifier struct {
+	Type IdentifierType
+	Name string
+}
+
+// NewIdentifier returns the identifier of type t for name.
+func NewIdentifier(t IdentifierType, name string) Identifier {
+	return Identifier{Type: t, Name: name}
+}
+
+// ParseIdentifier parses the string s into an Identifier.
+// If s starts with the prefix of a known identifier type, e.g.,
+// "device:", the identifier has this type. Otherwise, s is a username,
+// including if its prefix isn't a known type (e.g., "mailto:alice").
+// ParseIdentifier() returns an ErrMalformedMessage if the name of the
+// identifier is empty.
+func ParseIdentifier(s string) (Identifier, error) {
+	id := Identifier{Type: UserIdentifier, Name: s}
+	if i := strings.Index(s, identifierSeparator); i >= 0 {
+		if t := IdentifierType(s[:i]); t.Known() {
+			id = Identifier{Type: t, Name: s[i+len(identifierSeparator):]}
+		}
+	}
+	if id.Name == "" {
+		return Identifier{}, ErrMalformedMessage
+	}
+	return id, nil
+}
+
+// ParseCanonicalIdentifier is like ParseIdentifier(), but also returns
+// an ErrMalformedMessage if s isn't the canonical string form of the
+// identifier, e.g., for "user:alice".
+func ParseCanonicalIdentifier(s string) (Identifier, error) {
+	id, err := ParseIdentifier(s)
+	if err != nil || id.String() != s {
+		return Identifier{}, ErrMalformedMessage
+	}
+	return id, nil
+}
+
+// String returns the canonical string form of the identifier id.
+func (id Identifier) String() string {
+	if id.Type == UserIdentifier {
+		return id.Name
+	}
+	return string(id.Type) + identifierSeparator + id.Name
+}
+
+// An IdentifierPolicy restricts the registrations of the identifiers
+// of one type. Disabled rejects all registrations of this type, and
+// RequireAttestation rejects the registrations of this type which
+// don't include an attestation, regardless of the address through
+// which they are sent (see RegistrationAttestation).
+type IdentifierPolicy struct {
+	Disabled           bool `json:",omitempty"`
+	RequireAttestation bool `json:",omitempty"`
+}
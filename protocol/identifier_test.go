@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestParseIdentifier(t *testing.T) {
+	for _, tc := range []struct {
+		s         string
+		want      Identifier
+		err       error
+		canonical bool
+	}{
+		{"alice", NewIdentifier(UserIdentifier, "alice"), nil, true},
+		{"device:thermostat-42", NewIdentifier(DeviceIdentifier, "thermostat-42"), nil, true},
+		{"service:backup:eu", NewIdentifier(ServiceIdentifier, "backup:eu"), nil, true},
+		// an unknown prefix is part of the username
+		{"mailto:alice", NewIdentifier(UserIdentifier, "mailto:alice"), nil, true},
+		{"user:alice", NewIdentifier(UserIdentifier, "alice"), nil, false},
+		{"device:", Identifier{}, ErrMalformedMessage, false},
+		{"", Identifier{}, ErrMalformedMessage, false},
+	} {
+		id, err := ParseIdentifier(tc.s)
+		if id != tc.want || err != tc.err {
+			t.Error(tc.s, "expect", tc.want, tc.err, "got", id, err)
+		}
+		if _, err := ParseCanonicalIdentifier(tc.s); (err == nil) != tc.canonical {
+			t.Error(tc.s, "expect canonical", tc.canonical, "got", err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Implements the hand-rolled JSON encoding of the STRs and of the
+// DirectoryProof responses, which dominates the latency of the
+// responses including large proofs. The encoding is identical to the
+// encoding of encoding/json, without its reflection.
+
+package protocol
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// MarshalJSON implements the json.Marshaler interface.
+func (str *DirSTR) MarshalJSON() ([]byte, error) {
+	return str.AppendJSON(nil), nil
+}
+
+// AppendJSON appends the JSON encoding of str to b,
+// or null if str is nil.
+func (str *DirSTR) AppendJSON(b []byte) []byte {
+	if str == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, '{')
+	if s := str.SignedTreeRoot; s != nil {
+		b = append(b, `"TreeHash":`...)
+		b = utils.AppendJSONBytes(b, s.TreeHash)
+		b = append(b, `,"Epoch":`...)
+		b = strconv.AppendUint(b, s.Epoch, 10)
+		b = append(b, `,"PreviousEpoch":`...)
+		b = strconv.AppendUint(b, s.PreviousEpoch, 10)
+		b = append(b, `,"PreviousSTRHash":`...)
+		b = utils.AppendJSONBytes(b, s.PreviousSTRHash)
+		b = append(b, `,"Signature":`...)
+		b = utils.AppendJSONBytes(b, s.Signature)
+		if s.Version != 0 {
+			b = append(b, `,"Version":`...)
+			b = strconv.AppendUint(b, uint64(s.Version), 10)
+		}
+		if len(s.Extensions) > 0 {
+			b = append(b, `,"Extensions":`...)
+			b = appendExtensionsJSON(b, s.Extensions)
+		}
+		if len(s.CrossSignature) > 0 {
+			b = append(b, `,"CrossSignature":`...)
+			b = utils.AppendJSONBytes(b, s.CrossSignature)
+		}
+		b = append(b, ',')
+	}
+	b = append(b, `"Policies":`...)
+	b = str.Policies.appendJSON(b)
+	return append(b, '}')
+}
+
+// appendExtensionsJSON appends the JSON encoding of the STR
+// extensions exts to b, sorted by name as encoding/json sorts
+// the keys of a map.
+func appendExtensionsJSON(b []byte, exts map[string]*merkletree.STRExtension) []byte {
+	names := make([]string, 0, len(exts))
+	for name := range exts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b = append(b, '{')
+	for i, name := range names {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = utils.AppendJSONString(b, name)
+		b = append(b, ':')
+		ext := exts[name]
+		if ext == nil {
+			b = append(b, "null"...)
+			continue
+		}
+		b = append(b, `{"Critical":`...)
+		b = strconv.AppendBool(b, ext.Critical)
+		b = append(b, `,"Value":`...)
+		b = utils.AppendJSONBytes(b, ext.Value)
+		b = append(b, '}')
+	}
+	return append(b, '}')
+}
+
+func (p *Policies) appendJSON(b []byte) []byte {
+	if p == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, `{"Version":`...)
+	b = utils.AppendJSONString(b, p.Version)
+	b = append(b, `,"HashID":`...)
+	b = utils.AppendJSONString(b, p.HashID)
+	if p.SaltScheme != "" {
+		b = append(b, `,"SaltScheme":`...)
+		b = utils.AppendJSONString(b, p.SaltScheme)
+	}
+	if p.VrfAlgorithm != "" {
+		b = append(b, `,"VrfAlgorithm":`...)
+		b = utils.AppendJSONString(b, string(p.VrfAlgorithm))
+	}
+	b = append(b, `,"VrfPublicKey":`...)
+	b = utils.AppendJSONBytes(b, p.VrfPublicKey)
+	b = append(b, `,"EpochDeadline":`...)
+	b = strconv.AppendUint(b, uint64(p.EpochDeadline), 10)
+	if len(p.BootstrapHash) > 0 {
+		b = append(b, `,"BootstrapHash":`...)
+		b = utils.AppendJSONBytes(b, p.BootstrapHash)
+	}
+	if p.NamespaceBits != 0 {
+		b = append(b, `,"NamespaceBits":`...)
+		b = strconv.AppendUint(b, uint64(p.NamespaceBits), 10)
+	}
+	if cs := p.CipherSuite; cs != nil {
+		b = append(b, `,"CipherSuite":{"Signature":`...)
+		b = utils.AppendJSONString(b, cs.Signature)
+		b = append(b, `,"Vrf":`...)
+		b = utils.AppendJSONString(b, string(cs.Vrf))
+		b = append(b, `,"Hash":`...)
+		b = utils.AppendJSONString(b, cs.Hash)
+		b = append(b, '}')
+	}
+	if len(p.NextSigningKey) > 0 {
+		b = append(b, `,"NextSigningKey":`...)
+		b = utils.AppendJSONBytes(b, p.NextSigningKey)
+	}
+	return append(b, '}')
+}
+
+// AppendJSON appends the JSON encoding of msg to b (see MarshalJSON()).
+// The DirectoryProof responses are encoded by hand, and the other
+// responses by encoding/json.
+func (msg *Response) AppendJSON(b []byte) ([]byte, error) {
+	b = append(b, `{"Error":`...)
+	b = strconv.AppendInt(b, int64(msg.Error), 10)
+	if msg.DirectoryResponse != nil {
+		b = append(b, `,"Type":`...)
+		b = utils.AppendJSONString(b, msg.DirectoryResponse.ResponseType())
+		b = append(b, `,"DirectoryResponse":`...)
+		if df, ok := msg.DirectoryResponse.(*DirectoryProof); ok {
+			var err error
+			if b, err = df.appendJSON(b); err != nil {
+				return nil, err
+			}
+		} else {
+			content, err := json.Marshal(msg.DirectoryResponse)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, content...)
+		}
+	}
+	return append(b, '}'), nil
+}
+
+func (df *DirectoryProof) appendJSON(b []byte) ([]byte, error) {
+	if df == nil {
+		return append(b, "null"...), nil
+	}
+	b = append(b, `{"AP":`...)
+	b = appendAuthPathsJSON(b, df.AP)
+	b = append(b, `,"STR":`...)
+	b = appendSTRsJSON(b, df.STR)
+	if df.TB != nil {
+		// the temporary bindings are small
+		content, err := json.Marshal(df.TB)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, `,"TB":`...)
+		b = append(b, content...)
+	}
+	if df.Continuation != nil {
+		b = append(b, `,"Continuation":{"NextEpoch":`...)
+		b = strconv.AppendUint(b, df.Continuation.NextEpoch, 10)
+		b = append(b, '}')
+	}
+	if tp := df.Transition; tp != nil {
+		b = append(b, `,"Transition":{"Epoch":`...)
+		b = strconv.AppendUint(b, tp.Epoch, 10)
+		b = append(b, `,"STR":`...)
+		b = tp.STR.AppendJSON(b)
+		b = append(b, `,"Absence":`...)
+		b = tp.Absence.AppendJSON(b)
+		b = append(b, `,"Inclusion":`...)
+		b = tp.Inclusion.AppendJSON(b)
+		b = append(b, '}')
+	}
+	return append(b, '}'), nil
+}
+
+func appendAuthPathsJSON(b []byte, aps []*merkletree.AuthenticationPath) []byte {
+	if aps == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, '[')
+	for i, ap := range aps {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = ap.AppendJSON(b)
+	}
+	return append(b, ']')
+}
+
+func appendSTRsJSON(b []byte, strs []*DirSTR) []byte {
+	if strs == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, '[')
+	for i, str := range strs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = str.AppendJSON(b)
+	}
+	return append(b, ']')
+}
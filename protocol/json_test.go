@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// plainDirSTR is encoded by the reflection of encoding/json.
+type plainDirSTR DirSTR
+
+func testDirSTRs() []*DirSTR {
+	str := &merkletree.SignedTreeRoot{
+		TreeHash:        []byte("tree hash"),
+		Epoch:           2,
+		PreviousEpoch:   1,
+		PreviousSTRHash: []byte("previous"),
+		Signature:       []byte("signature"),
+	}
+	extended := *str
+	extended.Version = 1
+	extended.Extensions = map[string]*merkletree.STRExtension{
+		"rekor":    {Critical: true, Value: []byte("entry")},
+		"<beacon>": {Value: []byte{}},
+		"nil":      nil,
+	}
+	policies := &Policies{
+		Version:       Version,
+		HashID:        crypto.HashID,
+		VrfPublicKey:  []byte("vrf key"),
+		EpochDeadline: 60,
+	}
+	all := &Policies{
+		Version:       "1.0.0",
+		HashID:        "café",
+		SaltScheme:    crypto.SaltPRFID,
+		VrfAlgorithm:  "namespaced",
+		VrfPublicKey:  []byte{},
+		EpochDeadline: 3600,
+		BootstrapHash: []byte("seed"),
+		NamespaceBits: 8,
+		CipherSuite:   &CipherSuite{Signature: "ed\"25519", Hash: "café"},
+	}
+	handover := *str
+	handover.CrossSignature = []byte("cross signature")
+	next := *policies
+	next.NextSigningKey = []byte("next key")
+	return []*DirSTR{
+		{},
+		{SignedTreeRoot: str},
+		{SignedTreeRoot: str, Policies: policies},
+		{SignedTreeRoot: &extended, Policies: all},
+		{Policies: &Policies{BootstrapHash: []byte{}}},
+		{SignedTreeRoot: &handover, Policies: &next},
+		{Policies: &Policies{NextSigningKey: []byte{}}},
+	}
+}
+
+func TestDirSTRJSON(t *testing.T) {
+	for _, str := range testDirSTRs() {
+		want, err := json.Marshal((*plainDirSTR)(str))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Error("Expect", string(want), "got", string(got))
+		}
+		// and the encoding decodes back into the same STR
+		var decoded DirSTR
+		if err := json.Unmarshal(got, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := json.Marshal(&decoded); !bytes.Equal(again, got) {
+			t.Error("Expect", string(got), "got", string(again))
+		}
+	}
+}
+
+func TestDirectoryProofJSON(t *testing.T) {
+	strs := testDirSTRs()
+	ap := &merkletree.AuthenticationPath{
+		TreeNonce:  []byte("nonce"),
+		PrunedTree: [][]byte{[]byte("hash")},
+		Leaf:       &merkletree.ProofNode{Level: 1, IsEmpty: true},
+	}
+	for _, res := range []*Response{
+		NewErrorResponse(ErrDirectory),
+		{Error: ReqSuccess, DirectoryResponse: &DirectoryProof{}},
+		{Error: ReqSuccess, DirectoryResponse: (*DirectoryProof)(nil)},
+		{Error: ReqSuccess, DirectoryResponse: &DirectoryProof{
+			AP:           []*merkletree.AuthenticationPath{ap, nil},
+			STR:          strs,
+			TB:           &TemporaryBinding{Index: []byte("index"), Signature: []byte("sig")},
+			Continuation: &Continuation{NextEpoch: 3},
+			Transition:   &TransitionProof{Epoch: 2, STR: strs[2], Absence: ap},
+		}},
+		NewSTRPushAck(1, ReqSuccess),
+	} {
+		envelope := struct {
+			Error             ErrorCode
+			Type              string            `json:",omitempty"`
+			DirectoryResponse DirectoryResponse `json:",omitempty"`
+		}{Error: res.Error, DirectoryResponse: res.DirectoryResponse}
+		if res.DirectoryResponse != nil {
+			envelope.Type = res.DirectoryResponse.ResponseType()
+		}
+		want, err := json.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Error("Expect", string(want), "got", string(got))
+		}
+	}
+}
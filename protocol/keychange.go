@@ -0,0 +1,163 @@
+// Defines the messages of the two-phase key change protocol
+
+package protocol
+
+import (
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
+	"github.com/coniks-sys/coniks-go/protocol/profile"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// changeLabel and abortLabel separate the signatures on key changes
+// and key change aborts from the user's signatures on any other data.
+const (
+	changeLabel = "coniks-keychange"
+	abortLabel  = "coniks-keychange-abort"
+)
+
+// A KeyChangeRequest is a message with a username as a string and
+// a new public key as bytes that a CONIKS client sends to a CONIKS
+// directory to change the key bound to a registered username.
+//
+// The response to a successful request is a DirectoryProof with
+// a proof of inclusion of the current binding, and a TB promising the
+// key change in the next epoch (see TemporaryBinding.PreviousValue).
+// Until then, the change is pending and can be aborted with the
+// current key.
+//
+// Unless the user registered the username with AllowUnsignedKeychange
+// set (see RegistrationRequest), the request must include a Signature
+// made with the private key corresponding to the current key, which
+// must thus be a sign.PublicKey, or to the master key of the current
+// profile (see profile.Profile), over the change (see
+// KeyChangeMessage()).
+type KeyChangeRequest struct {
+	Username  string
+	Key       []byte
+	Signature []byte `json:",omitempty"`
+}
+
+// KeyChangeMessage returns the message a user signs to change the key
+// bound to username from previous to key.
+func KeyChangeMessage(username string, previous, key []byte) []byte {
+	var bs []byte
+	bs = append(bs, []byte(changeLabel)...)
+	for _, b := range [][]byte{[]byte(username), previous, key} {
+		bs = append(bs, utils.ULongToBytes(uint64(len(b)))...)
+		bs = append(bs, b...)
+	}
+	return bs
+}
+
+// NewKeyChangeRequest creates a request to change the key bound to
+// username from previous to key, signed with signKey, the private key
+// corresponding to previous.
+func NewKeyChangeRequest(signKey sign.PrivateKey, username string,
+	previous, key []byte) *KeyChangeRequest {
+	return &KeyChangeRequest{
+		Username:  username,
+		Key:       key,
+		Signature: signKey.Sign(KeyChangeMessage(username, previous, key)),
+	}
+}
+
+// Verify returns true if req is signed with the private key
+// corresponding to previous, the key bound to the username, or to its
+// master key if previous is a profile (see userKey()).
+func (req *KeyChangeRequest) Verify(previous []byte) bool {
+	pk, ok := userKey(previous)
+	if !ok {
+		return false
+	}
+	return pk.Verify(KeyChangeMessage(req.Username, previous, req.Key), req.Signature)
+}
+
+// userKey returns the public key with which the user whose username is
+// bound to value signs the changes of the binding: the master key of
+// the profile if value is a profile (see profile.Profile), and value
+// itself otherwise, which must then be a sign.PublicKey.
+func userKey(value []byte) (sign.PublicKey, bool) {
+	if profile.IsProfile(value) {
+		pk := profile.MasterKey(value)
+		return pk, pk != nil
+	}
+	if len(value) != sign.PublicKeySize {
+		return nil, false
+	}
+	return sign.PublicKey(value), true
+}
+
+// A KeyChangeAbortRequest is a message with a username as a string
+// and a Signature that a CONIKS client sends to a CONIKS directory to
+// contest a pending key change for the username before it takes
+// effect. The Signature is made with the private key corresponding to
+// the key bound to the username before the change, which must thus be
+// a sign.PublicKey or a profile, over the TB of the pending change
+// (see KeyChangeAbortMessage()).
+//
+// The response to a successful request is a DirectoryProof with
+// a proof of inclusion of the binding to the previous key, which the
+// directory keeps in the next snapshot.
+type KeyChangeAbortRequest struct {
+	Username  string
+	Signature []byte
+}
+
+// KeyChangeAbortMessage returns the message a user signs to abort
+// the pending key change for username promised by tb.
+func KeyChangeAbortMessage(username string, tb *TemporaryBinding) []byte {
+	var bs []byte
+	bs = append(bs, []byte(abortLabel)...)
+	bs = append(bs, utils.ULongToBytes(uint64(len(username)))...)
+	bs = append(bs, []byte(username)...)
+	bs = append(bs, tb.Signature...)
+	return bs
+}
+
+// NewKeyChangeAbortRequest creates a request to abort the pending key
+// change for username promised by tb, signed with signKey, the private
+// key corresponding to tb.PreviousValue.
+func NewKeyChangeAbortRequest(signKey sign.PrivateKey, username string,
+	tb *TemporaryBinding) *KeyChangeAbortRequest {
+	return &KeyChangeAbortRequest{
+		Username:  username,
+		Signature: signKey.Sign(KeyChangeAbortMessage(username, tb)),
+	}
+}
+
+// Verify returns true if req is signed with the private key
+// corresponding to the previous key of the pending key change tb
+// (see userKey()).
+func (req *KeyChangeAbortRequest) Verify(tb *TemporaryBinding) bool {
+	if !tb.IsKeyChange() {
+		return false
+	}
+	pk, ok := userKey(tb.PreviousValue)
+	if !ok {
+		return false
+	}
+	return pk.Verify(KeyChangeAbortMessage(req.Username, tb), req.Signature)
+}
+
+// NewKeyChangeProof creates the response message a CONIKS directory
+// sends to a client upon a KeyChangeRequest or a KeyChangeAbortRequest,
+// and returns a Response containing a DirectoryProof struct.
+// directory.KeyChange() and directory.AbortKeyChange() pass an
+// authentication path ap, the TB of the pending key change tb, if any,
+// and error code e according to the result of the request, and the
+// signed tree root for the latest epoch str.
+//
+// See directory.KeyChange() for details on the contents of the created
+// DirectoryProof.
+func NewKeyChangeProof(ap *merkletree.AuthenticationPath, str *DirSTR,
+	tb *TemporaryBinding, e ErrorCode) *Response {
+	return &Response{
+		Error: e,
+		DirectoryResponse: &DirectoryProof{
+			AP:  append([]*merkletree.AuthenticationPath{}, ap),
+			STR: append([]*DirSTR{}, str),
+			TB:  tb,
+		},
+	}
+}
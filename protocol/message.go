@@ -17,8 +17,37 @@ const (
 	MonitoringType
 	AuditType
 	STRType
+	ObservationReportType
+	KeyHistoryType
+	AttestationType
+	KeyChangeType
+	KeyChangeAbortType
+	PoliciesType
+	STRPushType
+	EmptyRangeType
+	SampleType
+	SubtreeType
+	BatchKeyLookupType
+	DeactivationType
+	EpochDeltaType
+	GossipType
 )
 
+// ReadOnly reports whether the requests of type t don't modify the
+// directory, so that a key server may handle them concurrently with
+// each other. A server must not handle them concurrently with an
+// update of the directory (see directory.ConiksDirectory).
+func ReadOnly(t int) bool {
+	switch t {
+	case KeyLookupType, BatchKeyLookupType, KeyLookupInEpochType,
+		MonitoringType, STRType, KeyHistoryType, PoliciesType,
+		EmptyRangeType, SampleType, SubtreeType, EpochDeltaType:
+		return true
+	default:
+		return false
+	}
+}
+
 // A Request message defines the data a CONIKS client must send to a CONIKS
 // directory for a particular request.
 type Request struct {
@@ -31,15 +60,23 @@ type Request struct {
 // to register a new entry (i.e. name-to-key binding).
 // Optionally, the client can include the user's key
 // change and visibility policies as boolean values in the
-// request. These flags are currently unused by the CONIKS protocols.
+// request. AllowUnsignedKeychange lets the user change the key bound
+// to the username without signing the change with the current key
+// (see KeyChangeRequest), e.g., if the key isn't a signing key.
+// AllowPublicLookup is currently unused by the CONIKS protocols.
+//
+// If the client has obtained a RegistrationAttestation for the username
+// from an account verification bot running in detached mode, it includes
+// the attestation in the request.
 //
 // The response to a successful request is a DirectoryProof with a TB for
 // the requested username and public key.
 type RegistrationRequest struct {
 	Username               string
 	Key                    []byte
-	AllowUnsignedKeychange bool `json:",omitempty"`
-	AllowPublicLookup      bool `json:",omitempty"`
+	AllowUnsignedKeychange bool                     `json:",omitempty"`
+	AllowPublicLookup      bool                     `json:",omitempty"`
+	Attestation            *RegistrationAttestation `json:",omitempty"`
 }
 
 // A KeyLookupRequest is a message with a username as a string
@@ -89,10 +126,16 @@ type KeyLookupInEpochRequest struct {
 // of the binding before registration, and name-to-key binding monitoring
 // which can be used to verify the inclusion of the binding after
 // registration.
+//
+// A client which has already verified the directory's STRs up to some
+// epoch (e.g. via auditing) indicates this epoch with the known epoch, so
+// that the directory omits these STRs from its response. A known epoch
+// of 0 omits no STRs.
 type MonitoringRequest struct {
 	Username   string
 	StartEpoch uint64
 	EndEpoch   uint64
+	KnownEpoch uint64 `json:",omitempty"`
 }
 
 // An AuditingRequest is a message with a CONIKS key directory's address
@@ -109,17 +152,100 @@ type AuditingRequest struct {
 	EndEpoch       uint64
 }
 
-// An STRHistoryRequest is a message with a StartEpoch and optional EndEpoch
+// An STRHistoryRequest is a message with a StartEpoch and an EndEpoch
 // of an epoch range as two uint64's that a CONIKS auditor
 // sends to a directory to retrieve a range of STRs starting at epoch
-// StartEpoch.
+// StartEpoch. An auditor which wants all STRs up to the directory's
+// latest epoch, whichever it is, sets Latest instead of EndEpoch,
+// which must then be 0. Since a zero EndEpoch is a valid end of the
+// range, omitting EndEpoch without setting Latest requests the range
+// [StartEpoch, 0].
 //
 // The response to a successful request is an STRHistoryRange with
 // a list of STRs covering the epoch range [StartEpoch, EndEpoch],
-// or [StartEpoch, d.LatestSTR().Epoch] if EndEpoch is omitted.
+// or [StartEpoch, d.LatestSTR().Epoch] if Latest is set. An EndEpoch
+// greater than the directory's latest epoch also sets the end of the
+// range at the directory's latest epoch.
 type STRHistoryRequest struct {
 	StartEpoch uint64
 	EndEpoch   uint64
+	Latest     bool `json:",omitempty"`
+}
+
+// Range returns the epoch range [start, end] requested by req from a
+// directory whose latest epoch is latest, or ok = false if the request
+// is malformed, i.e. if StartEpoch is greater than latest or EndEpoch,
+// or if both Latest and a non-zero EndEpoch are set.
+func (req *STRHistoryRequest) Range(latest uint64) (start, end uint64, ok bool) {
+	start, end = req.StartEpoch, req.EndEpoch
+	if req.Latest {
+		if end != 0 {
+			return 0, 0, false
+		}
+		end = latest
+	}
+	if start > latest || start > end {
+		return 0, 0, false
+	}
+	if end > latest {
+		end = latest
+	}
+	return start, end, true
+}
+
+// A KeyHistoryRequest is a message with a username as a string and the
+// start and end epochs of an epoch range as two uint64 that a CONIKS
+// client sends to the directory to retrieve the history of the keys
+// bound to the username, e.g. for self-monitoring or forensics.
+// An end epoch with a value greater than the key directory's latest
+// epoch sets the end of the epoch range at the directory's latest epoch.
+//
+// The response to a successful request is a DirectoryProof with an
+// authentication path and the STR for the start epoch, and for each
+// epoch in the range in which the value bound to the username changed.
+type KeyHistoryRequest struct {
+	Username   string
+	StartEpoch uint64
+	EndEpoch   uint64
+}
+
+// An ObservationReport is a message with a CONIKS key directory's
+// identity, an epoch as a uint64, and the hash of the STR that a CONIKS
+// client has verified for that epoch, which the client sends to a CONIKS
+// auditor if its user has opted in to anonymous telemetry.
+// Auditors aggregate these reports across all reporting clients to detect
+// partitioning attacks, in which a directory feeds a subset of its
+// clients a forked STR history.
+//
+// The report does not contain any information identifying the client or
+// its user. ReporterTag is a pseudonymous tag derived from a secret known
+// only to the client, the directory identity and the epoch, so that an
+// auditor can discard duplicate reports for the same epoch without being
+// able to link a client's reports across epochs.
+//
+// The response to a successful request is a Response with ReqSuccess and
+// no DirectoryResponse.
+type ObservationReport struct {
+	DirInitSTRHash [crypto.HashSizeByte]byte
+	Epoch          uint64
+	STRHash        [crypto.HashSizeByte]byte
+	ReporterTag    [crypto.HashSizeByte]byte
+}
+
+// An STRPush is a message with a CONIKS key directory's identity and
+// a list of STRs STR that a CONIKS directory sends to a CONIKS auditor
+// right after issuing a new STR, instead of waiting for the auditor to
+// fetch it. The list covers the epochs from the first epoch the auditor
+// hasn't acknowledged yet up to the directory's latest epoch, so that
+// a push which didn't reach the auditor is made up for by the next one.
+//
+// The response to a request is an STRPushAck, which acknowledges the
+// latest epoch the auditor has verified for the directory. An auditor
+// which has observed a different STR for one of the pushed epochs
+// acknowledges the push with a CheckBadSTR.
+type STRPush struct {
+	DirInitSTRHash [crypto.HashSizeByte]byte
+	STR            []*DirSTR
 }
 
 // A Response message indicates the result of a CONIKS client request
@@ -133,16 +259,34 @@ type Response struct {
 // A DirectoryResponse is a message that includes cryptographic proofs
 // about the key directory that a CONIKS key directory or auditor returns
 // to a CONIKS client.
-type DirectoryResponse interface{}
+// The DirectoryResponse types are defined by this package: they are
+// DirectoryProof, STRHistoryRange, STRPushAck, RegistrationAttestation,
+// EmptyRangeProof, SubtreeProof, SampleProof, BatchKeyLookupProof,
+// PolicyDocumentProof and EpochDelta. The Response accessor of the same name returns
+// a response of each type (e.g., Response.DirectoryProof()).
+type DirectoryResponse interface {
+	// ResponseType returns the name of the response's type, which tags
+	// its JSON encoding (see Response.MarshalJSON()).
+	ResponseType() string
+	// validate returns ErrMalformedMessage if the response is
+	// missing any of its proofs.
+	validate() error
+}
 
 // A DirectoryProof response includes a list of authentication paths
 // AP for a given username-to-key binding in the directory and a list of
 // signed tree roots STR for a range of epochs, and optionally
 // a temporary binding for the given binding for a single epoch.
+// Continuation is set if the proof only covers a prefix of the
+// requested epoch range (see Continuation).
+// A monitoring response whose range includes the epoch in which the
+// given binding was first included also includes a Transition proof.
 type DirectoryProof struct {
-	AP  []*merkletree.AuthenticationPath
-	STR []*DirSTR
-	TB  *TemporaryBinding `json:",omitempty"`
+	AP           []*merkletree.AuthenticationPath
+	STR          []*DirSTR
+	TB           *TemporaryBinding `json:",omitempty"`
+	Continuation *Continuation     `json:",omitempty"`
+	Transition   *TransitionProof  `json:",omitempty"`
 }
 
 // An STRHistoryRange response includes a list of signed tree roots
@@ -151,8 +295,62 @@ type DirectoryProof struct {
 // A CONIKS auditor returns this DirectoryResponse type upon an
 // AuditingRequest from a client, and a CONIKS directory returns
 // this message upon an STRHistoryRequest from an auditor.
+//
+// Transitions annotates the range with the epochs in which the
+// directory's policies changed (e.g. its epoch deadline or VRF key),
+// so that its recipient doesn't have to infer them by comparing the STRs.
+// The recipient must verify the annotations with Verify().
+// Continuation is set if the range only covers a prefix of the
+// requested epoch range (see Continuation).
 type STRHistoryRange struct {
-	STR []*DirSTR
+	STR          []*DirSTR
+	Transitions  []*PolicyTransition `json:",omitempty"`
+	Continuation *Continuation       `json:",omitempty"`
+}
+
+// Verify checks that the policy transitions of the range r match the
+// policies included in its STRs (see VerifyPolicyTransitions()).
+func (r *STRHistoryRange) Verify() error {
+	return VerifyPolicyTransitions(r.STR, r.Transitions)
+}
+
+// An STRPushAck response acknowledges the latest epoch Epoch for which
+// a CONIKS auditor has verified the STR of a directory, upon an STRPush
+// from this directory.
+type STRPushAck struct {
+	Epoch uint64
+}
+
+// ResponseType implements the DirectoryResponse interface.
+func (*DirectoryProof) ResponseType() string { return "DirectoryProof" }
+
+// ResponseType implements the DirectoryResponse interface.
+func (*STRHistoryRange) ResponseType() string { return "STRHistoryRange" }
+
+// ResponseType implements the DirectoryResponse interface.
+func (*STRPushAck) ResponseType() string { return "STRPushAck" }
+
+func (df *DirectoryProof) validate() error {
+	if len(df.STR) == 0 || len(df.AP) == 0 || !validSTRs(df.STR) {
+		return ErrMalformedMessage
+	}
+	for _, ap := range df.AP {
+		if ap == nil || ap.Leaf == nil {
+			return ErrMalformedMessage
+		}
+	}
+	return nil
+}
+
+func (r *STRHistoryRange) validate() error {
+	if len(r.STR) == 0 || !validSTRs(r.STR) {
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+func (*STRPushAck) validate() error {
+	return nil
 }
 
 // NewErrorResponse creates a new response message indicating the error
@@ -164,6 +362,7 @@ func NewErrorResponse(e ErrorCode) *Response {
 
 var _ DirectoryResponse = (*DirectoryProof)(nil)
 var _ DirectoryResponse = (*STRHistoryRange)(nil)
+var _ DirectoryResponse = (*STRPushAck)(nil)
 
 // NewRegistrationProof creates the response message a CONIKS directory
 // sends to a client upon a RegistrationRequest,
@@ -213,19 +412,21 @@ func NewKeyLookupProof(ap *merkletree.AuthenticationPath, str *DirSTR,
 // sends to a client upon a KeyLookupRequest,
 // and returns a Response containing a DirectoryProofs struct.
 // directory.KeyLookupInEpoch() passes an authentication path ap and error
-// code e according to the result of the lookup, and a list of signed
-// tree roots for the requested range of epochs str.
+// code e according to the result of the lookup, a list of signed
+// tree roots for the requested range of epochs str, and the
+// continuation next if the range had to be cut short, or nil.
 //
 // See directory.KeyLookupInEpoch() for details on the contents of the
 // created DirectoryProofs.
 func NewKeyLookupInEpochProof(ap *merkletree.AuthenticationPath,
-	str []*DirSTR, e ErrorCode) *Response {
+	str []*DirSTR, next *Continuation, e ErrorCode) *Response {
 	aps := append([]*merkletree.AuthenticationPath{}, ap)
 	return &Response{
 		Error: e,
 		DirectoryResponse: &DirectoryProof{
-			AP:  aps,
-			STR: str,
+			AP:           aps,
+			STR:          str,
+			Continuation: next,
 		},
 	}
 }
@@ -234,17 +435,43 @@ func NewKeyLookupInEpochProof(ap *merkletree.AuthenticationPath,
 // sends to a client upon a MonitoringRequest,
 // and returns a Response containing a DirectoryProofs struct.
 // directory.Monitor() passes a list of authentication paths ap and a
-// list of signed tree roots for the requested range of epochs str.
+// list of signed tree roots for the requested range of epochs str,
+// the continuation next if the range had to be cut short, or nil,
+// and the proof t of the binding's first inclusion in the range, or nil.
 //
 // See directory.Monitor() for details on the contents of the created
 // DirectoryProofs.
 func NewMonitoringProof(ap []*merkletree.AuthenticationPath,
-	str []*DirSTR) *Response {
+	str []*DirSTR, next *Continuation, t *TransitionProof) *Response {
 	return &Response{
 		Error: ReqSuccess,
 		DirectoryResponse: &DirectoryProof{
-			AP:  ap,
-			STR: str,
+			AP:           ap,
+			STR:          str,
+			Continuation: next,
+			Transition:   t,
+		},
+	}
+}
+
+// NewKeyHistoryProof creates the response message a CONIKS directory
+// sends to a client upon a KeyHistoryRequest,
+// and returns a Response containing a DirectoryProof struct.
+// directory.KeyHistory() passes a list of authentication paths ap and
+// a list of signed tree roots str for the epochs in which the binding
+// changed, and the continuation next if the range had to be cut short,
+// or nil.
+//
+// See directory.KeyHistory() for details on the contents of the created
+// DirectoryProof.
+func NewKeyHistoryProof(ap []*merkletree.AuthenticationPath,
+	str []*DirSTR, next *Continuation) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &DirectoryProof{
+			AP:           ap,
+			STR:          str,
+			Continuation: next,
 		},
 	}
 }
@@ -255,17 +482,43 @@ func NewMonitoringProof(ap []*merkletree.AuthenticationPath,
 // auditlog.GetObservedSTRs() passes a list of one or more signed tree roots
 // that the auditor observed for the requested range of epochs str.
 //
+// The created STRHistoryRange is annotated with the policy transitions
+// within str.
+//
 // See auditlog.GetObservedSTRs() for details on the contents of the created
 // STRHistoryRange.
 func NewSTRHistoryRange(str []*DirSTR) *Response {
+	return NewPartialSTRHistoryRange(str, nil)
+}
+
+// NewPartialSTRHistoryRange is like NewSTRHistoryRange(), but also
+// includes the continuation next if str only covers a prefix of the
+// requested range of epochs, since the complete range would exceed
+// MaxResponseSize.
+func NewPartialSTRHistoryRange(str []*DirSTR, next *Continuation) *Response {
 	return &Response{
 		Error: ReqSuccess,
 		DirectoryResponse: &STRHistoryRange{
-			STR: str,
+			STR:          str,
+			Transitions:  NewPolicyTransitions(str),
+			Continuation: next,
 		},
 	}
 }
 
+// NewSTRPushAck creates the response message a CONIKS auditor sends
+// to a directory upon an STRPush, and returns a Response containing
+// an STRPushAck struct.
+// auditlog.ReceiveSTRs() passes the latest epoch epoch the auditor has
+// verified for the directory, and the error code e according to the
+// result of the audit of the pushed STRs.
+func NewSTRPushAck(epoch uint64, e ErrorCode) *Response {
+	return &Response{
+		Error:             e,
+		DirectoryResponse: &STRPushAck{Epoch: epoch},
+	}
+}
+
 // Validate returns immediately if the message includes an error code.
 // Otherwise, it verifies whether the message has proper format.
 func (msg *Response) Validate() error {
@@ -276,20 +529,18 @@ func (msg *Response) Validate() error {
 	if msg.DirectoryResponse == nil {
 		return ErrMalformedMessage
 	}
-	switch df := msg.DirectoryResponse.(type) {
-	case *DirectoryProof:
-		if len(df.STR) == 0 || len(df.AP) == 0 {
-			return ErrMalformedMessage
-		}
-		return nil
-	case *STRHistoryRange:
-		if len(df.STR) == 0 {
-			return ErrMalformedMessage
+	return msg.DirectoryResponse.validate()
+}
+
+// validSTRs returns false if any STR in strs is missing its signed
+// tree root or its policies.
+func validSTRs(strs []*DirSTR) bool {
+	for _, str := range strs {
+		if str == nil || str.SignedTreeRoot == nil || str.Policies == nil {
+			return false
 		}
-		return nil
-	default:
-		panic("[coniks] Malformed response")
 	}
+	return true
 }
 
 // GetKey returns the key extracted from
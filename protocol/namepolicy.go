@@ -0,0 +1,47 @@
+// Defines the name policies binding username suffixes to the
+// verification authorities of a CONIKS directory
+
+package protocol
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+)
+
+// A NamePolicy reserves the usernames ending in Suffix (e.g.,
+// "@twitter") to the account verification bot whose public key is
+// Authority: the directory only registers these usernames with an
+// attestation signed by this bot (see RegistrationAttestation).
+type NamePolicy struct {
+	Suffix    string
+	Authority sign.PublicKey
+}
+
+// NamePolicies is the list of the name policies of a CONIKS directory.
+// If several suffixes match a username, the longest one is used.
+type NamePolicies []NamePolicy
+
+// Match returns the name policy governing username, i.e., the policy
+// with the longest suffix of username, and whether there is any.
+func (ps NamePolicies) Match(username string) (NamePolicy, bool) {
+	var policy NamePolicy
+	matched := false
+	for _, p := range ps {
+		if strings.HasSuffix(username, p.Suffix) &&
+			(!matched || len(p.Suffix) > len(policy.Suffix)) {
+			policy, matched = p, true
+		}
+	}
+	return policy, matched
+}
+
+// VerifyAttestation returns true if a is a valid attestation for
+// username at the time now by the authority of the name policy
+// governing username. It returns false if no policy governs username.
+func (ps NamePolicies) VerifyAttestation(a *RegistrationAttestation,
+	username string, now time.Time) bool {
+	p, ok := ps.Match(username)
+	return ok && a.Verify(p.Authority, username, now)
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+)
+
+func TestNamePoliciesMatch(t *testing.T) {
+	policies := NamePolicies{
+		{Suffix: "@twitter", Authority: []byte("twitter")},
+		{Suffix: "@mail.twitter", Authority: []byte("mail")},
+	}
+	for _, tc := range []struct {
+		username string
+		want     string
+		matched  bool
+	}{
+		{"alice@twitter", "@twitter", true},
+		{"bob@mail.twitter", "@mail.twitter", true},
+		{"carol@example", "", false},
+		{"@twitter.com", "", false},
+	} {
+		p, ok := policies.Match(tc.username)
+		if ok != tc.matched || p.Suffix != tc.want {
+			t.Error(tc.username, "expect", tc.want, tc.matched, "got", p.Suffix, ok)
+		}
+	}
+}
+
+func TestNamePoliciesVerifyAttestation(t *testing.T) {
+	bot, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, _ := bot.Public()
+	policies := NamePolicies{{Suffix: "@twitter", Authority: pk}}
+	now := time.Unix(3600, 0)
+	expiry := now.Add(time.Minute)
+
+	a := NewRegistrationAttestation(bot, "alice@twitter", expiry)
+	if !policies.VerifyAttestation(a, "alice@twitter", now) {
+		t.Error("Expect the authority's attestation to verify")
+	}
+	a = NewRegistrationAttestation(bot, "alice@example", expiry)
+	if policies.VerifyAttestation(a, "alice@example", now) {
+		t.Error("Expect no attestation to verify without a name policy")
+	}
+}
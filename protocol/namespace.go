@@ -0,0 +1,162 @@
+// Defines the hierarchical namespaces of a CONIKS directory, in which
+// the usernames of each provider share a subtree of the directory's
+// tree.
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+// namespaceSeparator separates a username from the name of the
+// provider whose namespace it belongs to, e.g., "alice@example.org".
+const namespaceSeparator = "@"
+
+// MaxNamespaceBits is the maximum length in bits of the prefix the
+// private indices of a provider's usernames share. It is bounded by
+// merkletree.MaxRangePrefixBits, so that a directory can prove that
+// the subtree of a provider is empty.
+const MaxNamespaceBits = merkletree.MaxRangePrefixBits
+
+// Provider returns the name of the provider whose namespace
+// the username name belongs to, i.e., the part of name after its last
+// "@", or "" if name doesn't belong to a provider's namespace.
+func Provider(name string) string {
+	i := strings.LastIndex(name, namespaceSeparator)
+	if i <= 0 {
+		return ""
+	}
+	return name[i+len(namespaceSeparator):]
+}
+
+// NamespacedIndex returns the private index of a username of
+// a provider's namespace (see NewNamespacedVRF()): the first bits bits
+// of providerIndex, the VRF output for the provider's name, followed
+// by the remaining bits of index, the VRF output for the username.
+func NamespacedIndex(providerIndex, index []byte, bits uint32) []byte {
+	composed := append([]byte{}, index...)
+	for i := uint32(0); i < bits && i < uint32(len(composed))*8; i++ {
+		mask := byte(1) << (7 - i%8)
+		if providerIndex[i/8]&mask != 0 {
+			composed[i/8] |= mask
+		} else {
+			composed[i/8] &^= mask
+		}
+	}
+	return composed
+}
+
+// A namespacedVRF maps the usernames of each provider's namespace into
+// the provider's subtree: the private index of a username of
+// a namespace starts with the first bits bits of the VRF output for the
+// provider's name, followed by the remaining bits of the VRF output for
+// the username. The private indices of the other usernames are the VRF
+// outputs for the usernames.
+type namespacedVRF struct {
+	vrf.VRF
+	bits uint32
+}
+
+// NewNamespacedVRF returns the VRF with which a directory computes the
+// private indices of its usernames in hierarchical namespaces
+// of prefixes of bits bits, under the VRF key key. The proof of
+// a username's private index includes the VRF outputs and proofs for
+// both the provider's name and the username, so that the clients
+// verify both (see NamespacedVerifier).
+//
+// Since the namespaces change the private indices of all usernames
+// of a provider, a directory can't enable them once it contains such
+// a username.
+func NewNamespacedVRF(key vrf.VRF, bits uint32) vrf.VRF {
+	return &namespacedVRF{VRF: key, bits: bits}
+}
+
+// Compute returns the private index of the username m.
+func (v *namespacedVRF) Compute(m []byte) []byte {
+	index, _ := v.Prove(m)
+	return index
+}
+
+// Prove returns the private index of the username m, and the proof of
+// this index.
+func (v *namespacedVRF) Prove(m []byte) (index, proof []byte) {
+	provider := Provider(string(m))
+	if provider == "" {
+		return v.VRF.Prove(m)
+	}
+	providerIndex, providerProof := v.VRF.Prove([]byte(provider))
+	index, proof = v.VRF.Prove(m)
+	return NamespacedIndex(providerIndex, index, v.bits),
+		encodeNamespaceProof(providerIndex, providerProof, index, proof)
+}
+
+// PublicKey returns the NamespacedVerifier of v.
+func (v *namespacedVRF) PublicKey() (vrf.Verifier, bool) {
+	pk, ok := v.VRF.PublicKey()
+	if !ok {
+		return nil, false
+	}
+	return &NamespacedVerifier{Verifier: pk, Bits: v.bits}, true
+}
+
+// A NamespacedVerifier verifies the private indices computed by
+// a VRF returned by NewNamespacedVRF(), with the VRF public key
+// Verifier, in namespaces of prefixes of Bits bits.
+type NamespacedVerifier struct {
+	vrf.Verifier
+	Bits uint32
+}
+
+// Verify returns true iff index is the private index of the username
+// m, as proven by proof.
+func (v *NamespacedVerifier) Verify(m, index, proof []byte) bool {
+	provider := Provider(string(m))
+	if provider == "" {
+		return v.Verifier.Verify(m, index, proof)
+	}
+	providerIndex, providerProof, nameIndex, nameProof, ok := decodeNamespaceProof(proof)
+	return ok &&
+		v.Verifier.Verify([]byte(provider), providerIndex, providerProof) &&
+		v.Verifier.Verify(m, nameIndex, nameProof) &&
+		bytes.Equal(index, NamespacedIndex(providerIndex, nameIndex, v.Bits))
+}
+
+// encodeNamespaceProof encodes the VRF outputs and proofs of the proof
+// of a username's private index, each preceded by its length.
+func encodeNamespaceProof(fields ...[]byte) []byte {
+	var bs []byte
+	for _, f := range fields {
+		bs = append(bs, make([]byte, 4)...)
+		binary.BigEndian.PutUint32(bs[len(bs)-4:], uint32(len(f)))
+		bs = append(bs, f...)
+	}
+	return bs
+}
+
+// decodeNamespaceProof decodes the proof of a username's private index
+// encoded by encodeNamespaceProof(). It returns false if proof is
+// malformed.
+func decodeNamespaceProof(proof []byte) (providerIndex, providerProof,
+	index, indexProof []byte, ok bool) {
+	var fields [4][]byte
+	for i := range fields {
+		if len(proof) < 4 {
+			return nil, nil, nil, nil, false
+		}
+		n := binary.BigEndian.Uint32(proof)
+		proof = proof[4:]
+		if uint64(n) > uint64(len(proof)) {
+			return nil, nil, nil, nil, false
+		}
+		fields[i], proof = proof[:n], proof[n:]
+	}
+	if len(proof) != 0 || len(fields[0]) != len(fields[2]) {
+		return nil, nil, nil, nil, false
+	}
+	return fields[0], fields[1], fields[2], fields[3], true
+}
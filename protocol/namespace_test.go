@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+)
+
+func TestProvider(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"alice", ""},
+		{"alice@example.org", "example.org"},
+		{"alice@mail@example.org", "example.org"},
+		{"@example.org", ""},
+	} {
+		if got := Provider(tc.name); got != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", got)
+		}
+	}
+}
+
+func TestNamespacedVRF(t *testing.T) {
+	key := crypto.NewStaticTestVRFKey()
+	v := NewNamespacedVRF(key, 8)
+	pk, _ := v.PublicKey()
+
+	provider := key.Compute([]byte("example.org"))
+	for _, name := range []string{"alice@example.org", "bob@example.org"} {
+		index, proof := v.Prove([]byte(name))
+		if index[0] != provider[0] {
+			t.Fatal("Expect", name, "in the subtree of example.org")
+		}
+		if !bytes.Equal(index[1:], key.Compute([]byte(name))[1:]) {
+			t.Fatal("Expect the remaining bits of the index of", name)
+		}
+		if !pk.Verify([]byte(name), index, proof) {
+			t.Fatal("Expect the index of", name, "to verify")
+		}
+		if pk.Verify([]byte("carol@example.org"), index, proof) {
+			t.Fatal("Expect the index of", name, "not to verify for another name")
+		}
+		if pk.Verify([]byte(name), key.Compute([]byte(name)), proof) {
+			t.Fatal("Expect the flat index of", name, "not to verify")
+		}
+	}
+
+	// the usernames outside of any namespace keep their flat indices
+	index, proof := v.Prove([]byte("alice"))
+	want, wantProof := key.Prove([]byte("alice"))
+	if !bytes.Equal(index, want) || !bytes.Equal(proof, wantProof) {
+		t.Fatal("Expect the flat index of alice")
+	}
+	if !pk.Verify([]byte("alice"), index, proof) {
+		t.Fatal("Expect the index of alice to verify")
+	}
+
+	// a flat proof doesn't verify a namespaced username
+	index, proof = key.Prove([]byte("alice@example.org"))
+	if pk.Verify([]byte("alice@example.org"), index, proof) {
+		t.Fatal("Expect a flat proof not to verify")
+	}
+}
+
+func TestPoliciesNamespaceBits(t *testing.T) {
+	key := crypto.NewStaticTestVRFKey()
+	flatPK, _ := key.PublicKey()
+	pk, _ := NewNamespacedVRF(key, 8).PublicKey()
+	p := NewPolicies(1, pk)
+	if p.NamespaceBits != 8 {
+		t.Fatal("Expect", 8, "got", p.NamespaceBits)
+	}
+	name := []byte("alice@example.org")
+	index, proof := NewNamespacedVRF(key, 8).Prove(name)
+	if !p.VerifyVrf(name, index, proof) {
+		t.Fatal("Expect the namespaced index to verify")
+	}
+
+	flat := NewPolicies(1, flatPK)
+	if flat.VerifyVrf(name, index, proof) {
+		t.Fatal("Expect the namespaced index not to verify without namespaces")
+	}
+	if bytes.Equal(flat.Serialize(), p.Serialize()) {
+		t.Fatal("Expect the namespace bits to be signed")
+	}
+	if changed := flat.Diff(p); len(changed) != 1 || changed[0] != PolicyNamespaceBits {
+		t.Fatal("Expect a namespace bits transition, got", changed)
+	}
+}
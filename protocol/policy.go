@@ -1,7 +1,11 @@
 package protocol
 
 import (
+	"bytes"
+	"reflect"
+
 	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/hashers"
 	"github.com/coniks-sys/coniks-go/crypto/vrf"
 	"github.com/coniks-sys/coniks-go/merkletree"
 	"github.com/coniks-sys/coniks-go/utils"
@@ -15,37 +19,121 @@ type Timestamp uint64
 // of the VRF key used to generate private indices,
 // the cryptographic algorithms in use, as well as
 // the protocol version number.
+// HashID identifies the hasher of the directory's tree, e.g.,
+// "SHAKE128" or "SHA256/128" (see hashers.NewPADHasher()).
+// VrfAlgorithm identifies the VRF construction of the VRF key, and
+// is empty for the default construction (see vrf.NewVerifier()).
+// SaltScheme identifies how the directory generates the salts of
+// its commitments: it is crypto.SaltPRFID if the salts are derived
+// from a master secret (see crypto.DeriveSalt()), and empty if the
+// salts are random.
+// BootstrapHash is the hash of the seed from which the directory was
+// pre-populated at epoch 0 (see BootstrapSeed), and is empty if the
+// directory was created empty.
+// NamespaceBits is the length in bits of the prefix shared by the
+// private indices of each provider's usernames if the directory maps
+// them into hierarchical namespaces (see NewNamespacedVRF()), and is 0
+// otherwise.
+// CipherSuite declares the signature scheme, the VRF construction and
+// the hash algorithm of the directory (see CipherSuite), and is nil in
+// the policies which predate it.
+// NextSigningKey is the public key to which the directory hands the
+// signing of its STRs over, in the policies of the STR signed with both
+// the directory's current signing key and the new key
+// (see DirSTR.VerifyHandover()), and is empty otherwise.
 type Policies struct {
-	Version       string
-	HashID        string
-	VrfPublicKey  vrf.PublicKey
-	EpochDeadline Timestamp
+	Version        string
+	HashID         string
+	SaltScheme     string        `json:",omitempty"`
+	VrfAlgorithm   vrf.Algorithm `json:",omitempty"`
+	VrfPublicKey   []byte
+	EpochDeadline  Timestamp
+	BootstrapHash  []byte       `json:",omitempty"`
+	NamespaceBits  uint32       `json:",omitempty"`
+	CipherSuite    *CipherSuite `json:",omitempty"`
+	NextSigningKey []byte       `json:",omitempty"`
 }
 
 var _ merkletree.AssocData = (*Policies)(nil)
 
 // NewPolicies returns a new Policies with the given epoch deadline
-// and public VRF key.
-func NewPolicies(epDeadline Timestamp, vrfPublicKey vrf.PublicKey) *Policies {
-	return &Policies{
+// and public VRF key. If vrfPublicKey is a NamespacedVerifier, the
+// policies declare its namespaces.
+func NewPolicies(epDeadline Timestamp, vrfPublicKey vrf.Verifier) *Policies {
+	p := &Policies{
 		Version:       Version,
 		HashID:        crypto.HashID,
-		VrfPublicKey:  vrfPublicKey,
+		VrfPublicKey:  vrfPublicKey.Bytes(),
 		EpochDeadline: epDeadline,
 	}
+	// keep the serialization of the policies using the default
+	// VRF construction unchanged
+	if alg := vrfPublicKey.Algorithm(); alg != vrf.Ed25519SHA3Elligator {
+		p.VrfAlgorithm = alg
+	}
+	if nv, ok := vrfPublicKey.(*NamespacedVerifier); ok {
+		p.NamespaceBits = nv.Bits
+	}
+	p.CipherSuite = NewCipherSuite(p)
+	return p
+}
+
+// VrfVerifier returns the VRF public key of the policies p, decoded
+// according to p.VrfAlgorithm, and wrapped in a NamespacedVerifier if
+// p declares namespaces. It returns vrf.ErrUnknownAlgorithm if the
+// VRF construction is unknown to this client.
+func (p *Policies) VrfVerifier() (vrf.Verifier, error) {
+	pk, err := vrf.NewVerifier(p.VrfAlgorithm, p.VrfPublicKey)
+	if err != nil || p.NamespaceBits == 0 {
+		return pk, err
+	}
+	return &NamespacedVerifier{Verifier: pk, Bits: p.NamespaceBits}, nil
+}
+
+// Hasher returns the hasher of the directory's tree declared by the
+// policies p (see hashers.NewPADHasher()). It returns
+// crypto.ErrUnsupportedHash if the hash algorithm is unknown to this
+// client, or if its output is truncated below crypto.MinHashSizeByte.
+func (p *Policies) Hasher() (hashers.PADHasher, error) {
+	return hashers.NewPADHasher(p.HashID)
+}
+
+// VerifyVrf returns true iff vrf is the VRF output for m under the
+// VRF public key of the policies p, as proven by proof.
+// It returns false if the VRF construction of p is unknown.
+func (p *Policies) VerifyVrf(m, vrf, proof []byte) bool {
+	pk, err := p.VrfVerifier()
+	if err != nil {
+		return false
+	}
+	return pk.Verify(m, vrf, proof)
 }
 
 // Serialize serializes the policies for signing the tree root.
 // Default policies serialization includes the library version
 // (see version.go),
-// the cryptographic algorithms in use (i.e., the hashing algorithm),
-// the epoch deadline and the public part of the VRF key.
+// the cryptographic algorithms in use (i.e., the hashing algorithm
+// and the commitment salt scheme, if any), the epoch deadline and the public part of the VRF key, preceded by
+// the VRF construction if it isn't the default one, and followed by
+// the hash of the bootstrap seed, if any, by the length of the
+// namespaces' prefixes, if any, by the cipher suite, if any, and by
+// the next signing key, if any.
 func (p *Policies) Serialize() []byte {
 	var bs []byte
 	bs = append(bs, []byte(p.Version)...)                           // protocol version
 	bs = append(bs, []byte(p.HashID)...)                            // cryptographic algorithms in use
+	bs = append(bs, []byte(p.SaltScheme)...)                        // commitment salt scheme
+	bs = append(bs, []byte(p.VrfAlgorithm)...)                      // vrf construction
 	bs = append(bs, p.VrfPublicKey...)                              // vrf public key
 	bs = append(bs, utils.ULongToBytes(uint64(p.EpochDeadline))...) // epoch deadline
+	bs = append(bs, p.BootstrapHash...)                             // bootstrap seed hash
+	if p.NamespaceBits > 0 {
+		bs = append(bs, utils.UInt32ToBytes(p.NamespaceBits)...) // namespaces
+	}
+	if p.CipherSuite != nil {
+		bs = append(bs, p.CipherSuite.Serialize()...) // cipher suite
+	}
+	bs = append(bs, p.NextSigningKey...) // next signing key
 	return bs
 }
 
@@ -53,3 +141,103 @@ func (p *Policies) Serialize() []byte {
 func GetPolicies(str *merkletree.SignedTreeRoot) *Policies {
 	return str.Ad.(*Policies)
 }
+
+// Names of the policy fields a PolicyTransition may list.
+const (
+	PolicyVersion        = "Version"
+	PolicyHashID         = "HashID"
+	PolicySaltScheme     = "SaltScheme"
+	PolicyVrfAlgorithm   = "VrfAlgorithm"
+	PolicyVrfPublicKey   = "VrfPublicKey"
+	PolicyEpochDeadline  = "EpochDeadline"
+	PolicyBootstrapHash  = "BootstrapHash"
+	PolicyNamespaceBits  = "NamespaceBits"
+	PolicyCipherSuite    = "CipherSuite"
+	PolicyNextSigningKey = "NextSigningKey"
+)
+
+// A PolicyTransition records that the directory's policies changed
+// at Epoch, i.e., that the policies included in the STR for Epoch
+// differ from the policies included in the STR for the previous epoch.
+// Changed lists the names of the changed policy fields.
+type PolicyTransition struct {
+	Epoch   uint64
+	Changed []string
+}
+
+// Diff returns the names of the fields in which the policies p
+// and other differ, in the order in which they are serialized.
+func (p *Policies) Diff(other *Policies) []string {
+	var changed []string
+	if p.Version != other.Version {
+		changed = append(changed, PolicyVersion)
+	}
+	if p.HashID != other.HashID {
+		changed = append(changed, PolicyHashID)
+	}
+	if p.SaltScheme != other.SaltScheme {
+		changed = append(changed, PolicySaltScheme)
+	}
+	if p.VrfAlgorithm != other.VrfAlgorithm {
+		changed = append(changed, PolicyVrfAlgorithm)
+	}
+	if !bytes.Equal(p.VrfPublicKey, other.VrfPublicKey) {
+		changed = append(changed, PolicyVrfPublicKey)
+	}
+	if p.EpochDeadline != other.EpochDeadline {
+		changed = append(changed, PolicyEpochDeadline)
+	}
+	if !bytes.Equal(p.BootstrapHash, other.BootstrapHash) {
+		changed = append(changed, PolicyBootstrapHash)
+	}
+	if p.NamespaceBits != other.NamespaceBits {
+		changed = append(changed, PolicyNamespaceBits)
+	}
+	if !reflect.DeepEqual(p.CipherSuite, other.CipherSuite) {
+		changed = append(changed, PolicyCipherSuite)
+	}
+	if !bytes.Equal(p.NextSigningKey, other.NextSigningKey) {
+		changed = append(changed, PolicyNextSigningKey)
+	}
+	return changed
+}
+
+// NewPolicyTransitions returns the policy transitions within the
+// range of consecutive STRs strs, in chronological order.
+// Since the policies of the first STR in strs cannot be compared with
+// those of its predecessor, a transition at the first epoch is never
+// recorded.
+func NewPolicyTransitions(strs []*DirSTR) []*PolicyTransition {
+	var transitions []*PolicyTransition
+	for i := 1; i < len(strs); i++ {
+		if changed := strs[i].Policies.Diff(strs[i-1].Policies); len(changed) > 0 {
+			transitions = append(transitions, &PolicyTransition{
+				Epoch:   strs[i].Epoch,
+				Changed: changed,
+			})
+		}
+	}
+	return transitions
+}
+
+// VerifyPolicyTransitions checks that transitions are exactly the
+// policy transitions within the range of consecutive STRs strs.
+// Since the policies are included in the signed STRs, it is sufficient
+// to verify the STRs' signatures and hash chain to trust the verified
+// transitions.
+// VerifyPolicyTransitions() returns a CheckBadPolicyTransition if
+// a transition is missing, or if a transition doesn't match the policies
+// of the STRs.
+func VerifyPolicyTransitions(strs []*DirSTR, transitions []*PolicyTransition) error {
+	want := NewPolicyTransitions(strs)
+	if len(want) != len(transitions) {
+		return CheckBadPolicyTransition
+	}
+	for i, t := range transitions {
+		if t == nil || t.Epoch != want[i].Epoch ||
+			!reflect.DeepEqual(t.Changed, want[i].Changed) {
+			return CheckBadPolicyTransition
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+)
+
+func TestPoliciesVrfAlgorithm(t *testing.T) {
+	sk := crypto.NewStaticTestVRFKey()
+	pk, _ := sk.PublicKey()
+	alice := []byte("alice")
+	index, proof := sk.Prove(alice)
+
+	p := NewPolicies(1, pk)
+	// the default construction isn't tagged, so that the
+	// serialization of existing policies doesn't change
+	if p.VrfAlgorithm != "" {
+		t.Fatal("Expect no VRF algorithm, got", p.VrfAlgorithm)
+	}
+	if !p.VerifyVrf(alice, index, proof) {
+		t.Fatal("Expect the VRF proof to verify")
+	}
+
+	tagged := *p
+	tagged.VrfAlgorithm = "unknown"
+	if tagged.VerifyVrf(alice, index, proof) {
+		t.Fatal("Expect an unknown VRF construction to fail verification")
+	}
+	if _, err := tagged.VrfVerifier(); err != vrf.ErrUnknownAlgorithm {
+		t.Fatal("Expect", vrf.ErrUnknownAlgorithm, "got", err)
+	}
+	if bytes.Equal(tagged.Serialize(), p.Serialize()) {
+		t.Fatal("Expect the VRF algorithm to be signed")
+	}
+	if changed := tagged.Diff(p); len(changed) != 1 || changed[0] != PolicyVrfAlgorithm {
+		t.Fatal("Expect a VRF algorithm transition, got", changed)
+	}
+}
+
+func TestPoliciesSaltScheme(t *testing.T) {
+	pk, _ := crypto.NewStaticTestVRFKey().PublicKey()
+	p := NewPolicies(1, pk)
+	if p.SaltScheme != "" {
+		t.Fatal("Expect no salt scheme, got", p.SaltScheme)
+	}
+	derived := *p
+	derived.SaltScheme = crypto.SaltPRFID
+	if bytes.Equal(derived.Serialize(), p.Serialize()) {
+		t.Fatal("Expect the salt scheme to be signed")
+	}
+	if changed := derived.Diff(p); len(changed) != 1 || changed[0] != PolicySaltScheme {
+		t.Fatal("Expect a salt scheme transition, got", changed)
+	}
+}
+
+func TestPoliciesBootstrapHash(t *testing.T) {
+	pk, _ := crypto.NewStaticTestVRFKey().PublicKey()
+	p := NewPolicies(1, pk)
+	bootstrapped := *p
+	bootstrapped.BootstrapHash = crypto.Digest([]byte("seed"))
+	if bytes.Equal(bootstrapped.Serialize(), p.Serialize()) {
+		t.Fatal("Expect the bootstrap seed hash to be signed")
+	}
+	if changed := bootstrapped.Diff(p); len(changed) != 1 || changed[0] != PolicyBootstrapHash {
+		t.Fatal("Expect a bootstrap seed hash transition, got", changed)
+	}
+}
+
+func TestPoliciesCipherSuite(t *testing.T) {
+	pk, _ := crypto.NewStaticTestVRFKey().PublicKey()
+	p := NewPolicies(1, pk)
+	want := CipherSuite{SignatureEd25519, vrf.Ed25519SHA3Elligator, crypto.HashID}
+	if p.CipherSuite == nil || *p.CipherSuite != want {
+		t.Fatal("Expect", want, "got", p.CipherSuite)
+	}
+	if err := p.CheckCipherSuite(); err != nil {
+		t.Fatal(err)
+	}
+	legacy := *p
+	legacy.CipherSuite = nil
+	if err := legacy.CheckCipherSuite(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(legacy.Serialize(), p.Serialize()) {
+		t.Fatal("Expect the cipher suite to be signed")
+	}
+	if changed := legacy.Diff(p); len(changed) != 1 || changed[0] != PolicyCipherSuite {
+		t.Fatal("Expect a cipher suite transition, got", changed)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		suite CipherSuite
+		want  error
+	}{
+		{"signature", CipherSuite{"ed448", vrf.Ed25519SHA3Elligator, crypto.HashID}, CheckUnsupportedSuite},
+		{"vrf", CipherSuite{SignatureEd25519, "unknown", crypto.HashID}, CheckUnsupportedSuite},
+		{"hash", CipherSuite{SignatureEd25519, vrf.Ed25519SHA3Elligator, "MD5"}, CheckUnsupportedSuite},
+		{"truncated hash", CipherSuite{SignatureEd25519, vrf.Ed25519SHA3Elligator, "SHAKE128/128"}, CheckUnsupportedSuite},
+		{"other vrf", CipherSuite{SignatureEd25519, vrf.VXEdDSA, crypto.HashID}, ErrMalformedMessage},
+		{"other hash", CipherSuite{SignatureEd25519, vrf.Ed25519SHA3Elligator, "SHA256"}, ErrMalformedMessage},
+	} {
+		declared := *p
+		declared.CipherSuite = &tc.suite
+		if err := declared.CheckCipherSuite(); err != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Defines the machine-readable policy document of a CONIKS directory
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+)
+
+const (
+	// PolicyDocumentVersion is the semantic version of the format of
+	// the policy documents this implementation issues. A client
+	// accepts the documents of the same major version, and ignores
+	// the fields added by the minor versions it doesn't know.
+	PolicyDocumentVersion = "1.2.0"
+
+	// PolicyDocumentExtension is the name of the STR extension
+	// (see merkletree.STRExtension) committing to the hash of the
+	// directory's policy document. The extension isn't critical, so
+	// that the clients which only understand the policies included
+	// in the STRs keep working.
+	PolicyDocumentExtension = "policy-document"
+
+	// SignatureEd25519 identifies the signature scheme of the
+	// directory's signing key (see crypto/sign).
+	SignatureEd25519 = "ed25519"
+)
+
+// These are the extension flags a policy document may list.
+const (
+	// ExtensionTBs indicates that the directory issues TBs.
+	ExtensionTBs = "temporary-bindings"
+	// ExtensionKeyChanges indicates that the directory supports
+	// two-phase key changes (see KeyChangeRequest).
+	ExtensionKeyChanges = "key-changes"
+)
+
+// policyDocumentLabel separates the signatures on policy documents
+// from the directory's signatures on any other data.
+const policyDocumentLabel = "coniks-policy-document"
+
+// RegistrationRules describes which registrations a CONIKS directory
+// accepts. RequireAttestation indicates that the clients must register
+// directly with an attestation by an account verification bot trusted
+// for one of the AttestationSuffixes (see RegistrationAttestation).
+// MaxBindings and MaxRegistrationsPerEpoch are the directory's hard
+// size limits, if any. Identifiers maps the identifier types to the
+// policies restricting their registrations, if any (since version
+// 1.1.0 of the document's format). Names lists the username suffixes
+// reserved to an account verification bot, if any (see NamePolicy;
+// since version 1.2.0): a client can check that the registrations of
+// these usernames are attested by the bot (see
+// NamePolicies.VerifyAttestation()).
+type RegistrationRules struct {
+	RequireAttestation       bool                                `json:",omitempty"`
+	AttestationSuffixes      []string                            `json:",omitempty"`
+	MaxBindings              uint64                              `json:",omitempty"`
+	MaxRegistrationsPerEpoch uint64                              `json:",omitempty"`
+	Identifiers              map[IdentifierType]IdentifierPolicy `json:",omitempty"`
+	Names                    NamePolicies                        `json:",omitempty"`
+}
+
+// A PolicyDocument is the standalone, machine-readable description of
+// a CONIKS directory's policies. In addition to the Policies included
+// in the STRs, it identifies all of the directory's algorithms
+// explicitly, and describes its registration rules and the protocol
+// extensions it supports.
+// DocumentVersion is the semantic version of the document's format
+// (see PolicyDocumentVersion).
+//
+// The directory signs the JSON encoding of the document, and commits
+// to its hash in the PolicyDocumentExtension of the STR of each epoch
+// (see SignedPolicyDocument).
+type PolicyDocument struct {
+	DocumentVersion    string
+	ProtocolVersion    string
+	HashID             string
+	SignatureAlgorithm string
+	SaltScheme         string `json:",omitempty"`
+	VrfAlgorithm       vrf.Algorithm
+	VrfPublicKey       []byte
+	EpochDeadline      Timestamp
+	BootstrapHash      []byte `json:",omitempty"`
+	Registration       RegistrationRules
+	Extensions         []string `json:",omitempty"`
+}
+
+// NewPolicyDocument returns the policy document describing the inline
+// policies p, the registration rules rules and the extension flags
+// exts. It migrates the policies of the directories which only
+// include them in their STRs.
+func NewPolicyDocument(p *Policies, rules RegistrationRules,
+	exts []string) *PolicyDocument {
+	return &PolicyDocument{
+		DocumentVersion:    PolicyDocumentVersion,
+		ProtocolVersion:    p.Version,
+		HashID:             p.HashID,
+		SignatureAlgorithm: SignatureEd25519,
+		SaltScheme:         p.SaltScheme,
+		VrfAlgorithm:       vrfAlgorithm(p),
+		VrfPublicKey:       p.VrfPublicKey,
+		EpochDeadline:      p.EpochDeadline,
+		BootstrapHash:      p.BootstrapHash,
+		Registration:       rules,
+		Extensions:         exts,
+	}
+}
+
+// vrfAlgorithm returns the VRF construction of the policies p,
+// which is implicit in p for the default construction.
+func vrfAlgorithm(p *Policies) vrf.Algorithm {
+	if p.VrfAlgorithm == "" {
+		return vrf.Ed25519SHA3Elligator
+	}
+	return p.VrfAlgorithm
+}
+
+// Matches returns true if the document doc describes the inline
+// policies p.
+func (doc *PolicyDocument) Matches(p *Policies) bool {
+	return doc.ProtocolVersion == p.Version &&
+		doc.HashID == p.HashID &&
+		doc.SaltScheme == p.SaltScheme &&
+		doc.VrfAlgorithm == vrfAlgorithm(p) &&
+		bytes.Equal(doc.VrfPublicKey, p.VrfPublicKey) &&
+		doc.EpochDeadline == p.EpochDeadline &&
+		bytes.Equal(doc.BootstrapHash, p.BootstrapHash)
+}
+
+// Supports returns true if the document doc lists the extension ext.
+func (doc *PolicyDocument) Supports(ext string) bool {
+	for _, e := range doc.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportedDocumentVersion returns true if a policy document of the
+// semantic version v can be read by this implementation, i.e.,
+// if v has the same major version as PolicyDocumentVersion.
+func SupportedDocumentVersion(v string) bool {
+	major, ok := semverMajor(v)
+	want, _ := semverMajor(PolicyDocumentVersion)
+	return ok && major == want
+}
+
+// semverMajor returns the major version of the semantic version v,
+// which must be of the form MAJOR.MINOR.PATCH.
+func semverMajor(v string) (uint64, bool) {
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	var major uint64
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		if i == 0 {
+			major = n
+		}
+	}
+	return major, true
+}
+
+// A SignedPolicyDocument is the JSON encoding Document of a
+// PolicyDocument, and the directory's Signature of the encoding.
+// The document is kept encoded as it was signed, so that its hash
+// doesn't depend on the fields a client is able to decode.
+type SignedPolicyDocument struct {
+	Document  []byte
+	Signature []byte
+}
+
+// Serialize serializes the signed document into
+// a specified format for signing.
+func (s *SignedPolicyDocument) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, []byte(policyDocumentLabel)...)
+	bs = append(bs, s.Document...)
+	return bs
+}
+
+// Hash returns the hash of the encoded document, which the directory
+// commits to in the PolicyDocumentExtension of its STRs.
+func (s *SignedPolicyDocument) Hash() []byte {
+	return crypto.Digest(s.Document)
+}
+
+// Decode verifies that s is the policy document committed to by the
+// STR str, and returns the decoded document. Since the document is
+// committed to by the signed STR, the directory's signature of the
+// document only needs to be verified if the document is passed on
+// without str (see Serialize()).
+//
+// Decode() returns a CheckBadPolicyDocument if str doesn't commit to
+// s, or if the document doesn't describe the policies included in str,
+// a CheckUnsupportedDocument if the version of the document's format
+// isn't supported (see SupportedDocumentVersion()), and an
+// ErrMalformedMessage if the document can't be decoded.
+func (s *SignedPolicyDocument) Decode(str *DirSTR) (*PolicyDocument, error) {
+	ext := str.Extensions[PolicyDocumentExtension]
+	if ext == nil || !bytes.Equal(ext.Value, s.Hash()) {
+		return nil, CheckBadPolicyDocument
+	}
+	doc := new(PolicyDocument)
+	if err := json.Unmarshal(s.Document, doc); err != nil {
+		return nil, ErrMalformedMessage
+	}
+	if !SupportedDocumentVersion(doc.DocumentVersion) {
+		return nil, CheckUnsupportedDocument
+	}
+	if !doc.Matches(str.Policies) {
+		return nil, CheckBadPolicyDocument
+	}
+	return doc, nil
+}
+
+// A PolicyDocumentProof response includes the directory's latest STR,
+// and the policy document STR commits to. A response served by a mirror
+// of the directory also includes the mirror's metadata.
+type PolicyDocumentProof struct {
+	STR      *DirSTR
+	Document *SignedPolicyDocument
+	Mirror   *MirrorMetadata `json:",omitempty"`
+}
+
+// MirrorMetadata advertises that a PolicyDocumentProof is served by
+// a read-only mirror of the directory rather than by the directory
+// itself. Primary is the address of the directory the mirror follows,
+// and VerifiedEpoch is the latest epoch the mirror has verified.
+// The metadata isn't signed by the directory, so it is only
+// informational: the STR and the document are verified as usual.
+type MirrorMetadata struct {
+	Primary       string
+	VerifiedEpoch uint64
+}
+
+var _ DirectoryResponse = (*PolicyDocumentProof)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*PolicyDocumentProof) ResponseType() string { return "PolicyDocumentProof" }
+
+func (p *PolicyDocumentProof) validate() error {
+	if p.STR == nil || !validSTRs([]*DirSTR{p.STR}) ||
+		p.Document == nil || len(p.Document.Document) == 0 ||
+		len(p.Document.Signature) == 0 {
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// A PoliciesRequest is a message that a CONIKS client sends to a CONIKS
+// directory to retrieve the directory's policy document for its latest
+// epoch.
+//
+// The response to a successful request is a PolicyDocumentProof.
+type PoliciesRequest struct{}
+
+// NewPoliciesResponse creates the response message a CONIKS directory
+// sends to a client upon a PoliciesRequest, and returns a Response
+// containing the PolicyDocumentProof p.
+//
+// See directory.GetPolicies() for details on the contents of the
+// created PolicyDocumentProof.
+func NewPoliciesResponse(p *PolicyDocumentProof) *Response {
+	return &Response{
+		Error:             ReqSuccess,
+		DirectoryResponse: p,
+	}
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/crypto/vrf"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
+
+func TestSupportedDocumentVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    bool
+	}{
+		{PolicyDocumentVersion, true},
+		{"1.4.2", true},
+		{"2.0.0", false},
+		{"0.9.0", false},
+		{"1.0", false},
+		{"1.x.0", false},
+		{"", false},
+	} {
+		if got := SupportedDocumentVersion(tc.version); got != tc.want {
+			t.Error(tc.version, "expect", tc.want, "got", got)
+		}
+	}
+}
+
+// committedDocument returns doc encoded as is, and an STR with the
+// policies p committing to the encoding.
+func committedDocument(t *testing.T, p *Policies,
+	doc interface{}) (*SignedPolicyDocument, *DirSTR) {
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SignedPolicyDocument{Document: bs}
+	str := &DirSTR{
+		SignedTreeRoot: &merkletree.SignedTreeRoot{
+			Extensions: map[string]*merkletree.STRExtension{
+				PolicyDocumentExtension: {Value: s.Hash()},
+			},
+		},
+		Policies: p,
+	}
+	return s, str
+}
+
+func TestPolicyDocumentMigration(t *testing.T) {
+	pk, _ := crypto.NewStaticTestVRFKey().PublicKey()
+	p := NewPolicies(1, pk)
+	doc := NewPolicyDocument(p, RegistrationRules{MaxBindings: 10},
+		[]string{ExtensionKeyChanges})
+	// the default VRF construction is explicit in the document
+	if doc.VrfAlgorithm != vrf.Ed25519SHA3Elligator || !doc.Matches(p) {
+		t.Fatal("Expect the document to describe the inline policies")
+	}
+
+	s, str := committedDocument(t, p, doc)
+	decoded, err := s.Decode(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Registration.MaxBindings != 10 ||
+		!decoded.Supports(ExtensionKeyChanges) || decoded.Supports(ExtensionTBs) {
+		t.Fatal("Unexpected decoded document", decoded)
+	}
+
+	other := NewPolicies(2, pk)
+	if _, err := s.Decode(&DirSTR{str.SignedTreeRoot, other}); err != CheckBadPolicyDocument {
+		t.Fatal("Expect", CheckBadPolicyDocument, "got", err)
+	}
+	str.Extensions = nil
+	if _, err := s.Decode(str); err != CheckBadPolicyDocument {
+		t.Fatal("Expect", CheckBadPolicyDocument, "got", err)
+	}
+}
+
+func TestPolicyDocumentVersions(t *testing.T) {
+	pk, _ := crypto.NewStaticTestVRFKey().PublicKey()
+	p := NewPolicies(1, pk)
+
+	// a minor version may add fields, which are ignored
+	type minorDocument struct {
+		PolicyDocument
+		Transparency string
+	}
+	newer := &minorDocument{*NewPolicyDocument(p, RegistrationRules{}, nil), "logged"}
+	newer.DocumentVersion = "1.2.0"
+	s, str := committedDocument(t, p, newer)
+	if _, err := s.Decode(str); err != nil {
+		t.Fatal(err)
+	}
+
+	major := NewPolicyDocument(p, RegistrationRules{}, nil)
+	major.DocumentVersion = "2.0.0"
+	s, str = committedDocument(t, p, major)
+	if _, err := s.Decode(str); err != CheckUnsupportedDocument {
+		t.Fatal("Expect", CheckUnsupportedDocument, "got", err)
+	}
+}
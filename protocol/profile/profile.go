@@ -0,0 +1,200 @@
+// Package profile defines the structured values with which a CONIKS
+// directory binds a username to a bundle of keys rather than to
+// a single opaque key, e.g., the keys of each of the devices of
+// a messaging app's user.
+//
+// A Profile lists the user's device keys, and is signed by the user's
+// master key, which the user keeps offline, and which signs the user's
+// key changes (see protocol.KeyChangeRequest): a device can thus be
+// added or revoked without changing the user's identity, while
+// a compromised device can't change the user's profile. A profile
+// expires, so that a stale bundle of keys isn't used forever.
+//
+// The directory checks that each profile it is asked to bind is
+// well-formed, signed by its master key and unexpired
+// (see Verify()), and treats any other value as an opaque key.
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
+// profileLabel separates the signatures on profiles from the master
+// key's signatures on any other data.
+const profileLabel = "coniks-profile"
+
+// MaxDevices is the maximum number of devices in a profile.
+const MaxDevices = 64
+
+// prefix prefixes the encoding of a profile. It starts with a zero
+// byte, like the values the directory reserves for itself (see
+// protocol.Tombstone), so that a profile can't be mistaken for
+// an opaque key.
+var prefix = []byte("\x00coniks-profile\x00")
+
+var (
+	// ErrMalformedProfile indicates that a value which is marked as
+	// a profile can't be decoded, or that the profile has no master
+	// key, no device, more than MaxDevices devices, a device without
+	// ID or key, or two devices with the same ID.
+	ErrMalformedProfile = errors.New("[profile] Malformed profile")
+	// ErrBadSignature indicates that a profile isn't signed by its
+	// master key.
+	ErrBadSignature = errors.New("[profile] The profile isn't signed by its master key")
+	// ErrExpired indicates that a profile has expired.
+	ErrExpired = errors.New("[profile] The profile has expired")
+	// ErrNotProfile indicates that a value isn't a profile, but
+	// an opaque key.
+	ErrNotProfile = errors.New("[profile] The value isn't a profile")
+)
+
+// A Device is the key of one of a user's devices, identified by an ID
+// which is unique in the user's profile, e.g., "laptop".
+type Device struct {
+	ID  string
+	Key []byte
+}
+
+// A Profile consists of the MasterKey of a user, the user's Devices,
+// the Expiry time (in seconds since the Unix epoch) after which the
+// profile is no longer valid, and a digital Signature of these fields
+// by the master key.
+type Profile struct {
+	MasterKey sign.PublicKey
+	Devices   []*Device
+	Expiry    uint64
+	Signature []byte
+}
+
+// New creates a new profile listing devices, which is valid until
+// expiry, signed with the master key masterKey.
+// It returns an ErrMalformedProfile if devices isn't a valid list of
+// devices (see Parse()).
+func New(masterKey sign.PrivateKey, devices []*Device, expiry time.Time) (*Profile, error) {
+	pk, ok := masterKey.Public()
+	if !ok {
+		return nil, ErrMalformedProfile
+	}
+	p := &Profile{
+		MasterKey: pk,
+		Devices:   devices,
+		Expiry:    uint64(expiry.Unix()),
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	p.Signature = masterKey.Sign(p.Serialize())
+	return p, nil
+}
+
+// Serialize serializes the profile into
+// a specified format for signing.
+func (p *Profile) Serialize() []byte {
+	var bs []byte
+	bs = append(bs, []byte(profileLabel)...)
+	bs = appendLengthPrefixed(bs, p.MasterKey)
+	bs = append(bs, utils.ULongToBytes(uint64(len(p.Devices)))...)
+	for _, dev := range p.Devices {
+		bs = appendLengthPrefixed(bs, []byte(dev.ID))
+		bs = appendLengthPrefixed(bs, dev.Key)
+	}
+	bs = append(bs, utils.ULongToBytes(p.Expiry)...)
+	return bs
+}
+
+func appendLengthPrefixed(bs, field []byte) []byte {
+	bs = append(bs, utils.ULongToBytes(uint64(len(field)))...)
+	return append(bs, field...)
+}
+
+// Marshal returns the encoding of the profile, i.e., the value
+// a client registers with the directory as the key of its username.
+func (p *Profile) Marshal() []byte {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		// a Profile always has a JSON encoding
+		panic(err)
+	}
+	return append(append([]byte{}, prefix...), buf...)
+}
+
+// IsProfile returns true if value is marked as the encoding of
+// a profile, which may still be malformed (see Parse()).
+func IsProfile(value []byte) bool {
+	return bytes.HasPrefix(value, prefix)
+}
+
+// Parse decodes the profile encoded in value (see Marshal()), and
+// checks that it is well-formed, but not that it is signed by its
+// master key, nor that it hasn't expired (see Verify()).
+// Parse() returns an ErrNotProfile if value isn't marked as a profile,
+// and an ErrMalformedProfile if it can't be decoded or isn't
+// well-formed.
+func Parse(value []byte) (*Profile, error) {
+	if !IsProfile(value) {
+		return nil, ErrNotProfile
+	}
+	var p Profile
+	if err := json.Unmarshal(value[len(prefix):], &p); err != nil {
+		return nil, ErrMalformedProfile
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Profile) validate() error {
+	if len(p.MasterKey) != sign.PublicKeySize ||
+		len(p.Devices) == 0 || len(p.Devices) > MaxDevices {
+		return ErrMalformedProfile
+	}
+	ids := make(map[string]bool, len(p.Devices))
+	for _, dev := range p.Devices {
+		if dev == nil || dev.ID == "" || len(dev.Key) == 0 || ids[dev.ID] {
+			return ErrMalformedProfile
+		}
+		ids[dev.ID] = true
+	}
+	return nil
+}
+
+// Verify checks that p is signed by its master key, and that it is
+// still valid at the time now. It returns an ErrBadSignature or an
+// ErrExpired otherwise.
+func (p *Profile) Verify(now time.Time) error {
+	if !p.MasterKey.Verify(p.Serialize(), p.Signature) {
+		return ErrBadSignature
+	}
+	if uint64(now.Unix()) > p.Expiry {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Device returns the device of the profile whose ID is id, or nil.
+func (p *Profile) Device(id string) *Device {
+	for _, dev := range p.Devices {
+		if dev.ID == id {
+			return dev
+		}
+	}
+	return nil
+}
+
+// MasterKey returns the master key of the profile encoded in value,
+// which signs the changes of a binding to this profile, or nil if
+// value isn't a well-formed profile (see Parse()).
+func MasterKey(value []byte) sign.PublicKey {
+	p, err := Parse(value)
+	if err != nil {
+		return nil
+	}
+	return p.MasterKey
+}
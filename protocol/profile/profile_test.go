@@ -0,0 +1,83 @@
+package profile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+)
+
+func newTestProfile(t *testing.T, expiry time.Time) (*Profile, sign.PrivateKey) {
+	masterKey, err := sign.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(masterKey, []*Device{
+		{ID: "laptop", Key: []byte("laptop key")},
+		{ID: "phone", Key: []byte("phone key")},
+	}, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, masterKey
+}
+
+func TestProfile(t *testing.T) {
+	now := time.Now()
+	p, _ := newTestProfile(t, now.Add(time.Hour))
+	value := p.Marshal()
+	if !IsProfile(value) {
+		t.Fatal("Expect the encoding to be marked as a profile")
+	}
+	parsed, err := Parse(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(now); err != nil {
+		t.Fatal(err)
+	}
+	if dev := parsed.Device("phone"); dev == nil || string(dev.Key) != "phone key" {
+		t.Fatal("Expect the phone's key, got", dev)
+	}
+	if parsed.Device("tablet") != nil {
+		t.Fatal("Expect no tablet")
+	}
+	if pk := MasterKey(value); string(pk) != string(p.MasterKey) {
+		t.Fatal("Bad master key")
+	}
+
+	if err := parsed.Verify(now.Add(2 * time.Hour)); err != ErrExpired {
+		t.Fatal("Expect", ErrExpired, "got", err)
+	}
+	parsed.Devices[0].Key = []byte("forged")
+	if err := parsed.Verify(now); err != ErrBadSignature {
+		t.Fatal("Expect", ErrBadSignature, "got", err)
+	}
+}
+
+func TestParseMalformedProfile(t *testing.T) {
+	p, masterKey := newTestProfile(t, time.Now().Add(time.Hour))
+	if _, err := Parse([]byte("opaque key")); err != ErrNotProfile {
+		t.Fatal("Expect", ErrNotProfile, "got", err)
+	}
+	if MasterKey([]byte("opaque key")) != nil {
+		t.Fatal("Expect no master key")
+	}
+	if _, err := Parse(append(p.Marshal(), '}')); err != ErrMalformedProfile {
+		t.Fatal("Expect", ErrMalformedProfile, "got", err)
+	}
+	for _, devices := range [][]*Device{
+		nil,
+		{{ID: "", Key: []byte("key")}},
+		{{ID: "phone", Key: nil}},
+		{{ID: "phone", Key: []byte("key")}, {ID: "phone", Key: []byte("key")}},
+	} {
+		if _, err := New(masterKey, devices, time.Now()); err != ErrMalformedProfile {
+			t.Error("Expect", ErrMalformedProfile, "for", devices, "got", err)
+		}
+		malformed := &Profile{MasterKey: p.MasterKey, Devices: devices}
+		if _, err := Parse(malformed.Marshal()); err != ErrMalformedProfile {
+			t.Error("Expect", ErrMalformedProfile, "for", devices, "got", err)
+		}
+	}
+}
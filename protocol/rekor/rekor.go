@@ -0,0 +1,323 @@
+// Package rekor implements the records of a CONIKS directory's STRs in
+// a Rekor transparency log (see https://github.com/sigstore/rekor),
+// and their verification.
+//
+// A key server may publish each of its STRs to a Rekor log, as an entry
+// of the "rekord" type signed with the directory's signing key. Since
+// the log is append-only and public, a client or an auditor who finds
+// its verified STR in the log knows that the directory has committed to
+// this STR publicly: the log is a witness of the directory's history,
+// independent of the directory and of the CONIKS auditors, in which an
+// equivocating directory would have to record both of its views.
+package rekor
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/protocol"
+)
+
+var (
+	// ErrEntryMismatch indicates that a log entry doesn't record the
+	// expected STR signed by the directory's signing key.
+	ErrEntryMismatch = errors.New("[coniks] The log entry doesn't record the STR")
+	// ErrBadTimestamp indicates that the signed entry timestamp of
+	// a log entry isn't signed by the log's key.
+	ErrBadTimestamp = errors.New("[coniks] The signed entry timestamp of the log entry is invalid")
+	// ErrBadInclusionProof indicates that the inclusion proof of a log
+	// entry is missing or invalid, or that its checkpoint isn't signed
+	// by the log's key.
+	ErrBadInclusionProof = errors.New("[coniks] The inclusion proof of the log entry is invalid")
+)
+
+// An Entry is an entry of a Rekor log, as returned by the log's API,
+// with the proof of its inclusion in the log (see Verify()).
+// Body is the canonical encoding of the recorded STR (see
+// ProposedEntry()).
+type Entry struct {
+	Body           []byte        `json:"body"`
+	IntegratedTime int64         `json:"integratedTime"`
+	LogID          string        `json:"logID"`
+	LogIndex       int64         `json:"logIndex"`
+	Verification   *Verification `json:"verification"`
+}
+
+// A Verification contains the log's promise to include an entry, i.e.,
+// its signed entry timestamp, and the proof of the entry's inclusion.
+type Verification struct {
+	InclusionProof       *InclusionProof `json:"inclusionProof,omitempty"`
+	SignedEntryTimestamp []byte          `json:"signedEntryTimestamp"`
+}
+
+// An InclusionProof proves the inclusion of the entry at LogIndex in
+// the log's Merkle tree of TreeSize entries with the root RootHash
+// (see RFC 6962): Hashes is the audit path of the entry, and Checkpoint
+// is the log's signed note which commits to the tree.
+// The hashes are hex-encoded.
+type InclusionProof struct {
+	Checkpoint string   `json:"checkpoint"`
+	Hashes     []string `json:"hashes"`
+	LogIndex   int64    `json:"logIndex"`
+	RootHash   string   `json:"rootHash"`
+	TreeSize   int64    `json:"treeSize"`
+}
+
+// rekord is the format of the "rekord" entries: the proposed entries
+// include the recorded data, while the log's canonical entries include
+// its hash only.
+type rekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Content []byte `json:"content,omitempty"`
+			Hash    *struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash,omitempty"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			Format    string `json:"format"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// ProposedEntry returns the entry a key server proposes to a Rekor log
+// to record str, which is signed with the directory's signing key pk:
+// a "rekord" entry of the serialized STR, with its signature, and
+// the PEM-encoded pk.
+func ProposedEntry(str *protocol.DirSTR, pk sign.PublicKey) ([]byte, error) {
+	key, err := encodePublicKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	var e rekord
+	e.APIVersion = "0.0.1"
+	e.Kind = "rekord"
+	e.Spec.Data.Content = str.Serialize()
+	e.Spec.Signature.Content = str.Signature
+	e.Spec.Signature.Format = "x509"
+	e.Spec.Signature.PublicKey.Content = key
+	return json.Marshal(&e)
+}
+
+// Hash returns the hex-encoded hash under which a Rekor log indexes the
+// entry recording str.
+func Hash(str *protocol.DirSTR) string {
+	h := sha256.Sum256(str.Serialize())
+	return hex.EncodeToString(h[:])
+}
+
+func encodePublicKey(pk sign.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(ed25519.PublicKey(pk))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePublicKey parses the PEM-encoded public key of a Rekor log.
+func ParsePublicKey(buf []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, errors.New("[coniks] Malformed public key of the log")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("[coniks] The public key of the log isn't an ECDSA key")
+	}
+	return ecKey, nil
+}
+
+// Verify checks that the entry e records the STR str signed with the
+// directory's signing key pk, and that the log with the public key
+// logKey has promised to include it, i.e., signed its entry timestamp.
+// If the entry includes an inclusion proof, Verify() also checks the
+// proof against the tree of the proof's checkpoint, signed by logKey.
+// Verify() returns an ErrEntryMismatch, an ErrBadTimestamp or an
+// ErrBadInclusionProof, respectively, if a check fails.
+func (e *Entry) Verify(str *protocol.DirSTR, pk sign.PublicKey,
+	logKey *ecdsa.PublicKey) error {
+	if err := e.verifyBody(str, pk); err != nil {
+		return err
+	}
+	if e.Verification == nil || !e.verifyTimestamp(logKey) {
+		return ErrBadTimestamp
+	}
+	if p := e.Verification.InclusionProof; p != nil {
+		if p.LogIndex != e.LogIndex || !p.verify(e.Body, logKey) {
+			return ErrBadInclusionProof
+		}
+	}
+	return nil
+}
+
+// VerifyInclusion checks the entry e as Verify() does, and requires its
+// inclusion proof.
+func (e *Entry) VerifyInclusion(str *protocol.DirSTR, pk sign.PublicKey,
+	logKey *ecdsa.PublicKey) error {
+	if err := e.Verify(str, pk, logKey); err != nil {
+		return err
+	}
+	if e.Verification.InclusionProof == nil {
+		return ErrBadInclusionProof
+	}
+	return nil
+}
+
+func (e *Entry) verifyBody(str *protocol.DirSTR, pk sign.PublicKey) error {
+	var r rekord
+	if err := json.Unmarshal(e.Body, &r); err != nil || r.Kind != "rekord" {
+		return ErrEntryMismatch
+	}
+	key, err := encodePublicKey(pk)
+	if err != nil {
+		return err
+	}
+	hash := r.Spec.Data.Hash
+	if hash == nil || hash.Algorithm != "sha256" || hash.Value != Hash(str) ||
+		!bytes.Equal(r.Spec.Signature.Content, str.Signature) ||
+		!bytes.Equal(r.Spec.Signature.PublicKey.Content, key) {
+		return ErrEntryMismatch
+	}
+	return nil
+}
+
+// signedTimestamp returns the canonical JSON encoding of the entry e
+// signed in its signed entry timestamp.
+func (e *Entry) signedTimestamp() []byte {
+	buf, _ := json.Marshal(&struct {
+		Body           []byte `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{e.Body, e.IntegratedTime, e.LogID, e.LogIndex})
+	return buf
+}
+
+func (e *Entry) verifyTimestamp(logKey *ecdsa.PublicKey) bool {
+	h := sha256.Sum256(e.signedTimestamp())
+	return ecdsa.VerifyASN1(logKey, h[:], e.Verification.SignedEntryTimestamp)
+}
+
+// verify checks the inclusion proof p of the entry body against the
+// root hash of its checkpoint, signed by logKey.
+func (p *InclusionProof) verify(body []byte, logKey *ecdsa.PublicKey) bool {
+	size, root, ok := verifyCheckpoint(p.Checkpoint, logKey)
+	if !ok || size != p.TreeSize || hex.EncodeToString(root) != p.RootHash ||
+		p.LogIndex < 0 || p.LogIndex >= p.TreeSize {
+		return false
+	}
+	path := make([][]byte, len(p.Hashes))
+	for i, h := range p.Hashes {
+		var err error
+		if path[i], err = hex.DecodeString(h); err != nil {
+			return false
+		}
+	}
+	return bytes.Equal(rootFromPath(uint64(p.LogIndex), uint64(p.TreeSize),
+		leafHash(body), path), root)
+}
+
+// verifyCheckpoint verifies the signed note checkpoint, which commits to
+// the log's tree of size entries with the root hash root, and returns
+// them if one of its signatures is signed by logKey.
+func verifyCheckpoint(checkpoint string, logKey *ecdsa.PublicKey) (size int64,
+	root []byte, ok bool) {
+	i := strings.Index(checkpoint, "\n\n")
+	if i < 0 {
+		return 0, nil, false
+	}
+	text, sigs := checkpoint[:i+1], checkpoint[i+2:]
+	lines := strings.Split(text, "\n")
+	if len(lines) < 4 {
+		return 0, nil, false
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	if root, err = base64.StdEncoding.DecodeString(lines[2]); err != nil {
+		return 0, nil, false
+	}
+	h := sha256.Sum256([]byte(text))
+	for _, line := range strings.Split(sigs, "\n") {
+		// a signature line is "— <name> <base64(key hint || signature)>"
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "—" {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil || len(sig) < 4 {
+			continue
+		}
+		if ecdsa.VerifyASN1(logKey, h[:], sig[4:]) {
+			return size, root, true
+		}
+	}
+	return 0, nil, false
+}
+
+// leafHash returns the hash of the log's leaf for the entry body.
+func leafHash(body []byte) []byte {
+	h := sha256.Sum256(append([]byte{0}, body...))
+	return h[:]
+}
+
+// nodeHash returns the hash of the log's inner node with the children
+// hashes left and right.
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// rootFromPath returns the root hash of a tree of size leaves computed
+// from the hash leaf of the leaf at index, and its audit path, or nil
+// if the path doesn't fit the tree (see RFC 9162, section 2.1.3.2).
+func rootFromPath(index, size uint64, leaf []byte, path [][]byte) []byte {
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range path {
+		if sn == 0 {
+			return nil
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return nil
+	}
+	return r
+}
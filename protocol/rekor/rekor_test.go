@@ -0,0 +1,80 @@
+package rekor
+
+import (
+	"testing"
+
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/protocol"
+	"github.com/coniks-sys/coniks-go/protocol/directory"
+)
+
+// recordSTRs records the STRs of the epochs [0, n) of a test
+// directory in the log l.
+func recordSTRs(t *testing.T, l *TestLog, n int) ([]*protocol.DirSTR, []string) {
+	d := directory.NewTestDirectory(t)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	var strs []*protocol.DirSTR
+	var uuids []string
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			d.Update()
+		}
+		entry, err := ProposedEntry(d.LatestSTR(), pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uuid, _, _, err := l.Add(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		strs = append(strs, d.LatestSTR())
+		uuids = append(uuids, uuid)
+	}
+	return strs, uuids
+}
+
+func TestVerifyEntry(t *testing.T) {
+	l := NewTestLog(t)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	strs, uuids := recordSTRs(t, l, 5)
+	for i, str := range strs {
+		e := l.Entry(uuids[i])
+		if err := e.VerifyInclusion(str, pk, l.PublicKey()); err != nil {
+			t.Error("epoch", i, "expect", nil, "got", err)
+		}
+		if found := l.Search(Hash(str)); len(found) != 1 || found[0] != uuids[i] {
+			t.Error("epoch", i, "expect to find", uuids[i], "got", found)
+		}
+	}
+
+	// the log doesn't record an STR twice
+	entry, _ := ProposedEntry(strs[0], pk)
+	if uuid, _, existed, _ := l.Add(entry); !existed || uuid != uuids[0] {
+		t.Fatal("Expect the existing entry", uuids[0], "got", uuid)
+	}
+}
+
+func TestVerifyEntryFailures(t *testing.T) {
+	l := NewTestLog(t)
+	pk, _ := crypto.NewStaticTestSigningKey().Public()
+	strs, uuids := recordSTRs(t, l, 3)
+
+	e := l.Entry(uuids[1])
+	if err := e.Verify(strs[2], pk, l.PublicKey()); err != ErrEntryMismatch {
+		t.Error("Expect", ErrEntryMismatch, "got", err)
+	}
+	if err := e.Verify(strs[1], pk, NewTestLog(t).PublicKey()); err != ErrBadTimestamp {
+		t.Error("Expect", ErrBadTimestamp, "got", err)
+	}
+	e.Verification.InclusionProof.Hashes[0] = e.Verification.InclusionProof.Hashes[1]
+	if err := e.Verify(strs[1], pk, l.PublicKey()); err != ErrBadInclusionProof {
+		t.Error("Expect", ErrBadInclusionProof, "got", err)
+	}
+	e.Verification.InclusionProof = nil
+	if err := e.Verify(strs[1], pk, l.PublicKey()); err != nil {
+		t.Error("Expect", nil, "got", err)
+	}
+	if err := e.VerifyInclusion(strs[1], pk, l.PublicKey()); err != ErrBadInclusionProof {
+		t.Error("Expect", ErrBadInclusionProof, "got", err)
+	}
+}
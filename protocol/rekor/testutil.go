@@ -0,0 +1,169 @@
+package rekor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// A TestLog is an in-memory Rekor log, which records the proposed
+// "rekord" entries, used for testing the publication and the
+// verification of STRs. Its entries are identified by the hex-encoded
+// hash of their leaf, as in Rekor.
+type TestLog struct {
+	key *ecdsa.PrivateKey
+
+	lock   sync.Mutex
+	leaves [][]byte
+	bodies [][]byte
+	index  map[string]int
+}
+
+// NewTestLog creates an empty TestLog with a new signing key.
+func NewTestLog(t *testing.T) *TestLog {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TestLog{key: key, index: make(map[string]int)}
+}
+
+// PublicKey returns the public key of the log.
+func (l *TestLog) PublicKey() *ecdsa.PublicKey {
+	return &l.key.PublicKey
+}
+
+// Add records the proposed entry proposed (see ProposedEntry()), and
+// returns its UUID and the recorded entry, or the existing entry with
+// existed = true if the log already records it.
+func (l *TestLog) Add(proposed []byte) (uuid string, e *Entry, existed bool, err error) {
+	var r rekord
+	if err := json.Unmarshal(proposed, &r); err != nil || r.Kind != "rekord" {
+		return "", nil, false, fmt.Errorf("Malformed entry")
+	}
+	h := sha256.Sum256(r.Spec.Data.Content)
+	r.Spec.Data.Content = nil
+	r.Spec.Data.Hash = &struct {
+		Algorithm string `json:"algorithm"`
+		Value     string `json:"value"`
+	}{"sha256", hex.EncodeToString(h[:])}
+	body, err := json.Marshal(&r)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	leaf := leafHash(body)
+	uuid = hex.EncodeToString(leaf)
+	if _, ok := l.index[uuid]; ok {
+		return uuid, l.entry(uuid), true, nil
+	}
+	l.index[uuid] = len(l.leaves)
+	l.leaves = append(l.leaves, leaf)
+	l.bodies = append(l.bodies, body)
+	return uuid, l.entry(uuid), false, nil
+}
+
+// Entry returns the entry identified by uuid, with the proof of its
+// inclusion in the current tree of the log, or nil.
+func (l *TestLog) Entry(uuid string) *Entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.entry(uuid)
+}
+
+// Search returns the UUIDs of the entries which record the data with
+// the hex-encoded hash hash (see Hash()).
+func (l *TestLog) Search(hash string) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var uuids []string
+	for uuid, i := range l.index {
+		var r rekord
+		if json.Unmarshal(l.bodies[i], &r) == nil && r.Spec.Data.Hash.Value == hash {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+func (l *TestLog) entry(uuid string) *Entry {
+	i, ok := l.index[uuid]
+	if !ok {
+		return nil
+	}
+	e := &Entry{
+		Body:           l.bodies[i],
+		IntegratedTime: int64(i),
+		LogID:          "test",
+		LogIndex:       int64(i),
+	}
+	root := treeHash(l.leaves)
+	var hashes []string
+	for _, h := range auditPath(i, l.leaves) {
+		hashes = append(hashes, hex.EncodeToString(h))
+	}
+	text := fmt.Sprintf("test\n%d\n%s\n", len(l.leaves),
+		base64.StdEncoding.EncodeToString(root))
+	e.Verification = &Verification{
+		SignedEntryTimestamp: l.sign(e.signedTimestamp()),
+		InclusionProof: &InclusionProof{
+			Checkpoint: text + "\n— test " + base64.StdEncoding.EncodeToString(
+				append([]byte{0, 0, 0, 0}, l.sign([]byte(text))...)) + "\n",
+			Hashes:   hashes,
+			LogIndex: int64(i),
+			RootHash: hex.EncodeToString(root),
+			TreeSize: int64(len(l.leaves)),
+		},
+	}
+	return e
+}
+
+func (l *TestLog) sign(msg []byte) []byte {
+	h := sha256.Sum256(msg)
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+// treeHash returns the root hash of the tree of the leaves with the
+// given hashes (see RFC 6962, section 2.1).
+func treeHash(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(treeHash(leaves[:k]), treeHash(leaves[k:]))
+}
+
+// auditPath returns the audit path of the m-th leaf of the tree of the
+// leaves with the given hashes (see RFC 6962, section 2.1.1).
+func auditPath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if m < k {
+		return append(auditPath(m, leaves[:k]), treeHash(leaves[k:]))
+	}
+	return append(auditPath(m-k, leaves[k:]), treeHash(leaves[:k]))
+}
+
+// split returns the largest power of two smaller than n.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
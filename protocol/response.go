@@ -0,0 +1,149 @@
+// Defines the tagged JSON encoding of the Response messages, and the
+// typed accessors of their DirectoryResponse
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// responseTypes maps the name of each DirectoryResponse type (see
+// DirectoryResponse.ResponseType()) to a constructor of an empty
+// response of this type.
+var responseTypes = make(map[string]func() DirectoryResponse)
+
+func init() {
+	for _, newResponse := range []func() DirectoryResponse{
+		func() DirectoryResponse { return new(DirectoryProof) },
+		func() DirectoryResponse { return new(STRHistoryRange) },
+		func() DirectoryResponse { return new(STRPushAck) },
+		func() DirectoryResponse { return new(RegistrationAttestation) },
+		func() DirectoryResponse { return new(EmptyRangeProof) },
+		func() DirectoryResponse { return new(SubtreeProof) },
+		func() DirectoryResponse { return new(SampleProof) },
+		func() DirectoryResponse { return new(BatchKeyLookupProof) },
+		func() DirectoryResponse { return new(PolicyDocumentProof) },
+		func() DirectoryResponse { return new(EpochDelta) },
+	} {
+		responseTypes[newResponse().ResponseType()] = newResponse
+	}
+}
+
+// NewDirectoryResponse returns an empty DirectoryResponse of the type
+// named responseType, into which a response can be decoded, or nil if
+// there is no such type.
+func NewDirectoryResponse(responseType string) DirectoryResponse {
+	newResponse, ok := responseTypes[responseType]
+	if !ok {
+		return nil
+	}
+	return newResponse()
+}
+
+// MarshalJSON encodes msg as a tagged envelope: the Type field names
+// the type of its DirectoryResponse, if any, so that the recipient
+// can decode the response without knowing the request it answers
+// (see also AppendJSON()).
+func (msg Response) MarshalJSON() ([]byte, error) {
+	return msg.AppendJSON(nil)
+}
+
+// UnmarshalJSON decodes the tagged envelope data (see MarshalJSON())
+// into msg. It returns ErrMalformedMessage if data includes a
+// DirectoryResponse whose type is missing or unknown.
+func (msg *Response) UnmarshalJSON(data []byte) error {
+	var envelope struct {
+		Error             ErrorCode
+		Type              string
+		DirectoryResponse json.RawMessage
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+	msg.Error = envelope.Error
+	msg.DirectoryResponse = nil
+	if len(envelope.DirectoryResponse) == 0 ||
+		bytes.Equal(envelope.DirectoryResponse, []byte("null")) {
+		return nil
+	}
+	response := NewDirectoryResponse(envelope.Type)
+	if response == nil {
+		return ErrMalformedMessage
+	}
+	if err := json.Unmarshal(envelope.DirectoryResponse, response); err != nil {
+		return err
+	}
+	msg.DirectoryResponse = response
+	return nil
+}
+
+// DirectoryProof returns the DirectoryProof included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) DirectoryProof() *DirectoryProof {
+	df, _ := msg.DirectoryResponse.(*DirectoryProof)
+	return df
+}
+
+// STRHistoryRange returns the STRHistoryRange included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) STRHistoryRange() *STRHistoryRange {
+	r, _ := msg.DirectoryResponse.(*STRHistoryRange)
+	return r
+}
+
+// STRPushAck returns the STRPushAck included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) STRPushAck() *STRPushAck {
+	ack, _ := msg.DirectoryResponse.(*STRPushAck)
+	return ack
+}
+
+// RegistrationAttestation returns the RegistrationAttestation included
+// in msg, or nil if msg includes another type of response.
+func (msg *Response) RegistrationAttestation() *RegistrationAttestation {
+	a, _ := msg.DirectoryResponse.(*RegistrationAttestation)
+	return a
+}
+
+// EmptyRangeProof returns the EmptyRangeProof included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) EmptyRangeProof() *EmptyRangeProof {
+	p, _ := msg.DirectoryResponse.(*EmptyRangeProof)
+	return p
+}
+
+// SubtreeProof returns the SubtreeProof included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) SubtreeProof() *SubtreeProof {
+	p, _ := msg.DirectoryResponse.(*SubtreeProof)
+	return p
+}
+
+// SampleProof returns the SampleProof included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) SampleProof() *SampleProof {
+	p, _ := msg.DirectoryResponse.(*SampleProof)
+	return p
+}
+
+// BatchKeyLookupProof returns the BatchKeyLookupProof included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) BatchKeyLookupProof() *BatchKeyLookupProof {
+	p, _ := msg.DirectoryResponse.(*BatchKeyLookupProof)
+	return p
+}
+
+// PolicyDocumentProof returns the PolicyDocumentProof included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) PolicyDocumentProof() *PolicyDocumentProof {
+	p, _ := msg.DirectoryResponse.(*PolicyDocumentProof)
+	return p
+}
+
+// EpochDelta returns the EpochDelta included in msg,
+// or nil if msg includes another type of response.
+func (msg *Response) EpochDelta() *EpochDelta {
+	d, _ := msg.DirectoryResponse.(*EpochDelta)
+	return d
+}
@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestResponseTaggedEnvelope(t *testing.T) {
+	msg, err := json.Marshal(NewSTRPushAck(3, ReqSuccess))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(msg, []byte(`"Type":"STRPushAck"`)) {
+		t.Fatal("Expect the response to be tagged", "got", string(msg))
+	}
+	var res Response
+	if err := json.Unmarshal(msg, &res); err != nil {
+		t.Fatal(err)
+	}
+	if ack := res.STRPushAck(); ack == nil || ack.Epoch != 3 {
+		t.Fatal("Expect", &STRPushAck{Epoch: 3}, "got", res.DirectoryResponse)
+	}
+	if res.DirectoryProof() != nil || res.STRHistoryRange() != nil {
+		t.Error("Expect no response of another type")
+	}
+}
+
+func TestResponseUntaggedError(t *testing.T) {
+	msg, err := json.Marshal(NewErrorResponse(ErrDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(msg, []byte("Type")) {
+		t.Fatal("Expect an untagged error response", "got", string(msg))
+	}
+	var res Response
+	if err := json.Unmarshal(msg, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != ErrDirectory || res.DirectoryResponse != nil {
+		t.Fatal("Expect", ErrDirectory, "got", res.Error, res.DirectoryResponse)
+	}
+}
+
+func TestResponseUnknownType(t *testing.T) {
+	for _, msg := range []string{
+		`{"Error":100,"Type":"Unknown","DirectoryResponse":{}}`,
+		`{"Error":100,"DirectoryResponse":{"Epoch":1}}`,
+	} {
+		var res Response
+		if err := json.Unmarshal([]byte(msg), &res); err != ErrMalformedMessage {
+			t.Error(msg, "expect", ErrMalformedMessage, "got", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Defines the messages with which a CONIKS auditor samples
+// the authentication paths of a directory's tree
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/merkletree"
+
+// MaxSampleSize is the maximum number of lookup indices
+// a SampleRequest may include.
+const MaxSampleSize = 32
+
+// A SampleRequest is a message that a CONIKS auditor sends to a CONIKS
+// directory to obtain the authentication paths of the directory's tree
+// for the epoch Epoch at each of the lookup Indices, which the auditor
+// chooses at random (see auditor.Sampler). By verifying these paths
+// against the STR for Epoch, the auditor probabilistically detects
+// a directory whose STRs don't commit to the tree it serves, without
+// learning any binding (see merkletree.MerkleTree.Sample()).
+// Indices must contain between 1 and MaxSampleSize lookup indices of
+// vrf.Size bytes.
+//
+// The response to a successful request is a SampleProof.
+type SampleRequest struct {
+	Indices [][]byte
+	Epoch   uint64
+}
+
+// A SampleProof response includes the authentication path AP for each
+// of the requested lookup indices, in order, and the STR for the
+// requested epoch.
+type SampleProof struct {
+	AP  []*merkletree.AuthenticationPath
+	STR *DirSTR
+}
+
+var _ DirectoryResponse = (*SampleProof)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*SampleProof) ResponseType() string { return "SampleProof" }
+
+func (p *SampleProof) validate() error {
+	if p.STR == nil || len(p.AP) == 0 || !validSTRs([]*DirSTR{p.STR}) {
+		return ErrMalformedMessage
+	}
+	for _, ap := range p.AP {
+		if ap == nil || ap.Leaf == nil {
+			return ErrMalformedMessage
+		}
+	}
+	return nil
+}
+
+// NewSampleProof creates the response message a CONIKS directory
+// sends to an auditor upon a SampleRequest, and returns a Response
+// containing a SampleProof struct.
+// directory.Sample() passes the authentication paths ap and the signed
+// tree root of the requested epoch str.
+func NewSampleProof(ap []*merkletree.AuthenticationPath, str *DirSTR) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &SampleProof{
+			AP:  ap,
+			STR: str,
+		},
+	}
+}
@@ -1,6 +1,9 @@
 package protocol
 
-import "github.com/coniks-sys/coniks-go/merkletree"
+import (
+	"github.com/coniks-sys/coniks-go/crypto/sign"
+	"github.com/coniks-sys/coniks-go/merkletree"
+)
 
 // DirSTR disambiguates merkletree.SignedTreeRoot's AssocData interface,
 // for the purpose of exporting and unmarshalling.
@@ -22,6 +25,56 @@ func (str *DirSTR) Serialize() []byte {
 	return append(str.SerializeInternal(), str.Policies.Serialize()...)
 }
 
+// KnownSTRExtensions contains the names of the STR extensions
+// (see merkletree.STRExtension) this implementation understands.
+var KnownSTRExtensions = map[string]bool{
+	PolicyDocumentExtension: true,
+	BeaconExtension:         true,
+}
+
+// CheckHeader checks that this implementation supports the version of
+// the STR's header and all of its critical extensions, and returns a
+// CheckUnsupportedSTR otherwise. Unknown non-critical extensions are
+// ignored. CheckHeader also checks the cipher suite declared in the
+// STR's policies (see Policies.CheckCipherSuite()).
+// Since the signature scheme of the STR is part of its cipher suite,
+// CheckHeader should be called before verifying the STR's signature.
+func (str *DirSTR) CheckHeader() error {
+	if str.Version > merkletree.STRVersion {
+		return CheckUnsupportedSTR
+	}
+	unknown := str.UnknownCriticalExtensions(func(name string) bool {
+		return KnownSTRExtensions[name]
+	})
+	if len(unknown) > 0 {
+		return CheckUnsupportedSTR
+	}
+	if str.Policies == nil {
+		return ErrMalformedMessage
+	}
+	return str.Policies.CheckCipherSuite()
+}
+
+// VerifyHandover checks that the STR is cross-signed by the new signing
+// key its policies hand the signing over to, if any
+// (see Policies.NextSigningKey), so that the directory's verifiers
+// learn the new key from the STR signed with the key they trust.
+// It returns ErrMalformedMessage if the new key is malformed, and a
+// CheckBadSignature if the cross signature is invalid.
+func (str *DirSTR) VerifyHandover() error {
+	next := str.Policies.NextSigningKey
+	if len(next) == 0 {
+		return nil
+	}
+	if len(next) != sign.PublicKeySize {
+		return ErrMalformedMessage
+	}
+	if !sign.PublicKey(next).Verify(str.Serialize(), str.CrossSignature) {
+		return CheckBadSignature
+	}
+	return nil
+}
+
 // VerifyHashChain wraps merkletree.SignedTreeRoot.VerifyHashChain
 func (str *DirSTR) VerifyHashChain(savedSTR *DirSTR) bool {
 	return str.SignedTreeRoot.VerifyHashChain(savedSTR.SignedTreeRoot)
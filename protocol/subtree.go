@@ -0,0 +1,69 @@
+// Defines the messages with which a CONIKS directory proves the hash
+// of the subtree of a provider's namespace.
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/merkletree"
+
+// A SubtreeRequest is a message that a provider, or a CONIKS auditor
+// or client, sends to a CONIKS directory which maps its usernames into
+// hierarchical namespaces (see NewNamespacedVRF()) to obtain the hash
+// of the subtree of the namespace of Provider in the directory's tree
+// for the epoch Epoch. Since the subtree contains the bindings of all
+// usernames of the namespace, e.g., "alice@example.org" for the provider
+// "example.org", a provider which tracks the hash of its subtree across
+// epochs learns whether any of its users' bindings has changed.
+//
+// The response to a successful request is a SubtreeProof.
+type SubtreeRequest struct {
+	Provider string
+	Epoch    uint64
+}
+
+// A SubtreeProof response includes the proof Proof of the subtree of the
+// requested provider's namespace, the private index ProviderIndex
+// the subtree's prefix is taken from, i.e., the VRF output for the
+// provider's name, and its VRF proof ProviderProof. STR is a list of
+// STRs covering the epoch range [Epoch, d.LatestSTR().Epoch], where
+// Epoch is the requested epoch. Continuation is set if the list only
+// covers a prefix of this range (see Continuation).
+type SubtreeProof struct {
+	Proof         *merkletree.SubtreeProof
+	ProviderIndex []byte
+	ProviderProof []byte
+	STR           []*DirSTR
+	Continuation  *Continuation `json:",omitempty"`
+}
+
+var _ DirectoryResponse = (*SubtreeProof)(nil)
+
+// ResponseType implements the DirectoryResponse interface.
+func (*SubtreeProof) ResponseType() string { return "SubtreeProof" }
+
+func (p *SubtreeProof) validate() error {
+	if len(p.STR) == 0 || p.Proof == nil || !validSTRs(p.STR) {
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+// NewSubtreeProof creates the response message a CONIKS directory
+// sends upon a SubtreeRequest, and returns a Response containing
+// a SubtreeProof struct.
+// directory.ProveSubtree() passes the proof p, the provider's private
+// index and its VRF proof, a list of signed tree roots for the requested
+// range of epochs str, and the continuation next if the range had to be
+// cut short, or nil.
+func NewSubtreeProof(p *merkletree.SubtreeProof, providerIndex, providerProof []byte,
+	str []*DirSTR, next *Continuation) *Response {
+	return &Response{
+		Error: ReqSuccess,
+		DirectoryResponse: &SubtreeProof{
+			Proof:         p,
+			ProviderIndex: providerIndex,
+			ProviderProof: providerProof,
+			STR:           str,
+			Continuation:  next,
+		},
+	}
+}
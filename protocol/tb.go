@@ -2,10 +2,18 @@
 
 package protocol
 
+import (
+	"github.com/coniks-sys/coniks-go/crypto"
+	"github.com/coniks-sys/coniks-go/utils"
+)
+
 // A TemporaryBinding consists of the private
 // Index for a username, the Value (i.e. public key etc.)
-// mapped to this index in a key directory, and a digital
-// Signature of these fields.
+// mapped to this index in a key directory, the IssuedEpoch
+// in which the TB was issued, the InclusionEpoch whose
+// snapshot must include the binding, the identity of the issuing
+// directory, i.e. the hash of its initial STR (DirInitSTRHash),
+// and a digital Signature of these fields.
 //
 // A TB serves as a proof of registration and as a
 // signed promise by a CONIKS server
@@ -14,18 +22,65 @@ package protocol
 // to begin using the contained name-to-key binding for
 // encryption/signing without having to wait for the binding's inclusion
 // in the next snapshot.
+//
+// A TB with a PreviousValue promises a key change instead, i.e., that
+// the binding of the name to PreviousValue will be replaced with the
+// binding to Value in the snapshot of the InclusionEpoch, unless the
+// user aborts the change with the previous key before this epoch
+// (see KeyChangeAbortRequest).
 type TemporaryBinding struct {
-	Index     []byte
-	Value     []byte
-	Signature []byte
+	Index          []byte
+	Value          []byte
+	IssuedEpoch    uint64
+	InclusionEpoch uint64
+	PreviousValue  []byte `json:",omitempty"`
+	DirInitSTRHash [crypto.HashSizeByte]byte
+	Signature      []byte
 }
 
-// Serialize serializes the temporary binding into
-// a specified format.
+// tbLabel separates the serialization of the TBs from the other
+// messages signed with a directory's signing key, and keyChangeLabel
+// separates the serialization of the TBs for key changes from the
+// serialization of the TBs for registrations.
+const (
+	tbLabel        = "coniks-tb-v1"
+	keyChangeLabel = "keychange"
+)
+
+// Serialize serializes the temporary binding, along with the
+// signature strSig of the STR of its issued epoch, into the message
+// the directory signs:
+//
+//	"coniks-tb-v1" || DirInitSTRHash || IssuedEpoch || InclusionEpoch ||
+//	len(strSig) || strSig || len(Index) || Index || len(Value) || Value
+//
+// followed by "keychange" || len(PreviousValue) || PreviousValue for
+// a key change. The epochs and lengths are 8-byte little-endian
+// integers (see utils.ULongToBytes()), so that the signature of a TB
+// can't be replayed for a different directory, epoch or binding.
 func (tb *TemporaryBinding) Serialize(strSig []byte) []byte {
 	var tbBytes []byte
-	tbBytes = append(tbBytes, strSig...)
-	tbBytes = append(tbBytes, tb.Index...)
-	tbBytes = append(tbBytes, tb.Value...)
+	tbBytes = append(tbBytes, []byte(tbLabel)...)
+	tbBytes = append(tbBytes, tb.DirInitSTRHash[:]...)
+	tbBytes = append(tbBytes, utils.ULongToBytes(tb.IssuedEpoch)...)
+	tbBytes = append(tbBytes, utils.ULongToBytes(tb.InclusionEpoch)...)
+	tbBytes = appendLengthPrefixed(tbBytes, strSig)
+	tbBytes = appendLengthPrefixed(tbBytes, tb.Index)
+	tbBytes = appendLengthPrefixed(tbBytes, tb.Value)
+	if tb.IsKeyChange() {
+		tbBytes = append(tbBytes, []byte(keyChangeLabel)...)
+		tbBytes = appendLengthPrefixed(tbBytes, tb.PreviousValue)
+	}
 	return tbBytes
 }
+
+func appendLengthPrefixed(bs, field []byte) []byte {
+	bs = append(bs, utils.ULongToBytes(uint64(len(field)))...)
+	return append(bs, field...)
+}
+
+// IsKeyChange returns whether tb promises a key change rather than
+// the registration of a new binding.
+func (tb *TemporaryBinding) IsKeyChange() bool {
+	return tb.PreviousValue != nil
+}
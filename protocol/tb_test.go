@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestTBSerializeVectors(t *testing.T) {
+	var id [32]byte
+	for i := range id {
+		id[i] = 0xaa
+	}
+	tb := &TemporaryBinding{
+		Index:          []byte{1, 2},
+		Value:          []byte("key"),
+		IssuedEpoch:    1,
+		InclusionEpoch: 2,
+		DirInitSTRHash: id,
+	}
+	header := "636f6e696b732d74622d7631" + // "coniks-tb-v1"
+		strings.Repeat("aa", 32) + // DirInitSTRHash
+		"0100000000000000" + "0200000000000000" + // epochs
+		"010000000000000009" + // STR signature
+		"02000000000000000102" + // Index
+		"03000000000000006b6579" // Value
+	keyChange := "6b65796368616e6765" + // "keychange"
+		"03000000000000006f6c64" // PreviousValue
+	for _, tc := range []struct {
+		name     string
+		previous []byte
+		want     string
+	}{
+		{"registration", nil, header},
+		{"key change", []byte("old"), header + keyChange},
+	} {
+		tb.PreviousValue = tc.previous
+		if got := hex.EncodeToString(tb.Serialize([]byte{9})); got != tc.want {
+			t.Error(tc.name, "expect", tc.want, "got", got)
+		}
+	}
+}
+
+func TestTBSerializeUnambiguous(t *testing.T) {
+	tb := &TemporaryBinding{Index: []byte("ab"), Value: []byte("c"), IssuedEpoch: 1, InclusionEpoch: 2}
+	for _, tc := range []struct {
+		name   string
+		other  TemporaryBinding
+		strSig []byte
+	}{
+		{"shifted fields", TemporaryBinding{Index: []byte("a"), Value: []byte("bc"), IssuedEpoch: 1, InclusionEpoch: 2}, nil},
+		{"other directory", TemporaryBinding{Index: []byte("ab"), Value: []byte("c"), IssuedEpoch: 1, InclusionEpoch: 2,
+			DirInitSTRHash: [32]byte{1}}, nil},
+		{"other epoch", TemporaryBinding{Index: []byte("ab"), Value: []byte("c"), IssuedEpoch: 2, InclusionEpoch: 3}, nil},
+		{"STR signature", TemporaryBinding{Index: []byte("b"), Value: []byte("c"), IssuedEpoch: 1, InclusionEpoch: 2}, []byte("a")},
+	} {
+		if bytes.Equal(tb.Serialize(nil), tc.other.Serialize(tc.strSig)) {
+			t.Error(tc.name, "expect different serializations")
+		}
+	}
+}
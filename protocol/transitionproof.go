@@ -0,0 +1,23 @@
+// Defines the proof with which a CONIKS directory shows a client
+// monitoring a newly registered binding the epoch in which the binding
+// was first included, so that the client can check that the directory
+// has kept the promise of its temporary binding.
+
+package protocol
+
+import "github.com/coniks-sys/coniks-go/merkletree"
+
+// A TransitionProof proves that a name was absent from the directory's
+// tree at the epoch Epoch-1, and included in its tree at the epoch
+// Epoch. Absence is a proof of absence for the name against STR, the
+// directory's STR for the epoch Epoch-1, and Inclusion is a proof of
+// inclusion for the name against the directory's STR for Epoch, which
+// the monitoring response includes along with the STRs of the
+// monitored range. STR must be signed by the directory, and the STR
+// for Epoch must be hash-chained to it.
+type TransitionProof struct {
+	Epoch     uint64
+	STR       *DirSTR
+	Absence   *merkletree.AuthenticationPath
+	Inclusion *merkletree.AuthenticationPath
+}
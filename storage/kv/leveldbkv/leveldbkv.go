@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"github.com/coniks-sys/coniks-go/storage/kv"
+	"github.com/coniks-sys/coniks-go/utils/faults"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -35,6 +36,22 @@ func OpenDB(path string) kv.DB {
 	return Wrap(db)
 }
 
+// OpenReadOnlyDB opens the existing database at path in read-only
+// mode, e.g., to inspect the database of a stopped server. All writes
+// to the returned database fail. Since a running server locks its
+// database, OpenReadOnlyDB returns an error for the database of
+// a running server.
+func OpenReadOnlyDB(path string) (kv.DB, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{
+		ReadOnly:       true,
+		ErrorIfMissing: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(db), nil
+}
+
 // Wrap uses a leveldb.DB as a kv.DB the obvious way (and with Sync:true).
 func Wrap(db *leveldb.DB) kv.DB {
 	return (*leveldbkv)(db)
@@ -45,10 +62,16 @@ func (db *leveldbkv) Get(key []byte) ([]byte, error) {
 }
 
 func (db *leveldbkv) Put(key, value []byte) error {
+	if err := faults.Check(faults.StorageWrite); err != nil {
+		return err
+	}
 	return (*leveldb.DB)(db).Put(key, value, &opt.WriteOptions{Sync: true})
 }
 
 func (db *leveldbkv) Delete(key []byte) error {
+	if err := faults.Check(faults.StorageWrite); err != nil {
+		return err
+	}
 	return (*leveldb.DB)(db).Delete(key, &opt.WriteOptions{Sync: true})
 }
 
@@ -61,6 +84,9 @@ func (db *leveldbkv) Write(b kv.Batch) error {
 	if !ok {
 		return fmt.Errorf("leveldbkv.Write: expected *leveldb.Batch, got %T", b)
 	}
+	if err := faults.Check(faults.StorageWrite); err != nil {
+		return err
+	}
 	return (*leveldb.DB)(db).Write(wb, &opt.WriteOptions{Sync: true})
 }
 
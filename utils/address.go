@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// These are the address families to which a ResolutionPolicy
+// can restrict the resolution of a host name.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// ParseAddress splits an address formatted as a url, scheme://address,
+// into its network and the address on this network: the host and port
+// of a TCP address ("tcp", or "tcp4" and "tcp6" for IPv4 or IPv6 only),
+// the path of a Unix socket ("unix"), or the name of an in-memory
+// listener ("mem", see ListenMem()).
+//
+// An IPv6 literal must be enclosed in brackets, and may include a zone
+// without escaping it, e.g., "tcp://[fe80::1%eth0]:3000", which
+// url.Parse() rejects.
+func ParseAddress(address string) (network, addr string, err error) {
+	i := strings.Index(address, "://")
+	if i < 0 {
+		return "", "", fmt.Errorf("Address %q must be formatted as scheme://address", address)
+	}
+	network, addr = address[:i], address[i+len("://"):]
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid address %q: %v", address, err)
+		}
+		if port == "" {
+			return "", "", fmt.Errorf("Address %q has no port", address)
+		}
+		// only IPv6 literals may be enclosed in brackets
+		if strings.HasPrefix(addr, "[") &&
+			(net.ParseIP(strings.SplitN(host, "%", 2)[0]) == nil || !strings.Contains(host, ":")) {
+			return "", "", fmt.Errorf("Address %q encloses a non-IPv6 host in brackets", address)
+		}
+		return network, net.JoinHostPort(host, port), nil
+	case "unix", "mem":
+		if addr == "" {
+			return "", "", fmt.Errorf("Address %q has no path", address)
+		}
+		return network, addr, nil
+	default:
+		return "", "", fmt.Errorf("Address %q has an unknown network type", address)
+	}
+}
+
+// A ResolutionPolicy controls how the host name of a TCP address is
+// resolved. Family restricts the resolution to the IPv4 addresses
+// (FamilyIPv4, A records) or to the IPv6 addresses (FamilyIPv6, AAAA
+// records) of the host, which by default resolves to both.
+//
+// If the host has addresses of both families, a client dials the
+// addresses of the family its resolver returns first, and races them
+// with the other family after FallbackDelay milliseconds ("Happy
+// Eyeballs", RFC 6555). A FallbackDelay of 0 uses the default delay of
+// 300ms, and a negative FallbackDelay disables the race, so that the
+// addresses are dialed one after another.
+//
+// A nil *ResolutionPolicy is the default policy.
+type ResolutionPolicy struct {
+	Family        string `toml:"family,omitempty"`
+	FallbackDelay int64  `toml:"fallback_delay,omitempty"`
+}
+
+// Validate returns an error if the policy's family is unknown.
+func (p *ResolutionPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Family {
+	case "", FamilyIPv4, FamilyIPv6:
+		return nil
+	default:
+		return fmt.Errorf("Unknown address family %q", p.Family)
+	}
+}
+
+// Network returns the network on which an address of the given network
+// is resolved under the policy p, e.g., "tcp4" for a "tcp" address
+// and the family FamilyIPv4. It returns an error if the family of p
+// contradicts the network, e.g., for a "tcp6" address and FamilyIPv4.
+// The networks other than TCP are returned unchanged.
+func (p *ResolutionPolicy) Network(network string) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return network, nil
+	}
+	if p == nil || p.Family == "" {
+		return network, nil
+	}
+	restricted := "tcp4"
+	if p.Family == FamilyIPv6 {
+		restricted = "tcp6"
+	}
+	if network != "tcp" && network != restricted {
+		return "", fmt.Errorf("Network %s contradicts the address family %s",
+			network, p.Family)
+	}
+	return restricted, nil
+}
+
+// Dialer returns a dialer following the fallback delay of the policy p.
+func (p *ResolutionPolicy) Dialer() *net.Dialer {
+	d := new(net.Dialer)
+	if p != nil {
+		d.FallbackDelay = time.Duration(p.FallbackDelay) * time.Millisecond
+	}
+	return d
+}
+
+// Dial connects to the address formatted as a url (see ParseAddress()),
+// resolving its host name under the policy p, which may be nil.
+func Dial(address string, p *ResolutionPolicy) (net.Conn, error) {
+	network, addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "mem" {
+		return DialMem(addr)
+	}
+	network, err = p.Network(network)
+	if err != nil {
+		return nil, err
+	}
+	return p.Dialer().Dial(network, addr)
+}
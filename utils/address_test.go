@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		network string
+		addr    string
+		ok      bool
+	}{
+		{"tcp://127.0.0.1:3000", "tcp", "127.0.0.1:3000", true},
+		{"tcp://localhost:3000", "tcp", "localhost:3000", true},
+		{"tcp://[::1]:3000", "tcp", "[::1]:3000", true},
+		{"tcp6://[fe80::1%eth0]:3000", "tcp6", "[fe80::1%eth0]:3000", true},
+		{"unix:///tmp/coniks.sock", "unix", "/tmp/coniks.sock", true},
+		// IPv6 literals must be enclosed in brackets
+		{"tcp://::1:3000", "", "", false},
+		{"tcp://[127.0.0.1]:3000", "", "", false},
+		{"tcp://[localhost]:3000", "", "", false},
+		{"tcp://127.0.0.1", "", "", false},
+		{"tcp://127.0.0.1:", "", "", false},
+		{"127.0.0.1:3000", "", "", false},
+		{"udp://127.0.0.1:3000", "", "", false},
+		{"unix://", "", "", false},
+	} {
+		network, addr, err := ParseAddress(tc.address)
+		if (err == nil) != tc.ok {
+			t.Error(tc.address, "expect ok", tc.ok, "got", err)
+			continue
+		}
+		if network != tc.network || addr != tc.addr {
+			t.Error(tc.address, "expect", tc.network, tc.addr, "got", network, addr)
+		}
+	}
+}
+
+func TestResolutionPolicyNetwork(t *testing.T) {
+	for _, tc := range []struct {
+		policy  *ResolutionPolicy
+		network string
+		want    string
+		ok      bool
+	}{
+		{nil, "tcp", "tcp", true},
+		{&ResolutionPolicy{}, "tcp6", "tcp6", true},
+		{&ResolutionPolicy{Family: FamilyIPv4}, "tcp", "tcp4", true},
+		{&ResolutionPolicy{Family: FamilyIPv6}, "tcp", "tcp6", true},
+		{&ResolutionPolicy{Family: FamilyIPv6}, "tcp6", "tcp6", true},
+		{&ResolutionPolicy{Family: FamilyIPv6}, "unix", "unix", true},
+		{&ResolutionPolicy{Family: FamilyIPv4}, "tcp6", "", false},
+		{&ResolutionPolicy{Family: "ipx"}, "tcp", "", false},
+	} {
+		network, err := tc.policy.Network(tc.network)
+		if (err == nil) != tc.ok || network != tc.want {
+			t.Error("Expect", tc.want, tc.ok, "got", network, err)
+		}
+	}
+}
+
+func TestDialWithPolicy(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	address := "tcp://localhost:" + port
+	conn, err := Dial(address, &ResolutionPolicy{Family: FamilyIPv4, FallbackDelay: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := conn.RemoteAddr().(*net.TCPAddr).IP; ip.To4() == nil {
+		t.Fatal("Expect an IPv4 connection, got", ip)
+	}
+	conn.Close()
+	if _, err := Dial("tcp6://localhost:"+port, &ResolutionPolicy{Family: FamilyIPv4}); err == nil {
+		t.Fatal("Expect the network to contradict the policy")
+	}
+}
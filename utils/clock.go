@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock provides the current time and timers. Time-dependent code
+// takes a Clock instead of using the time package directly, so that
+// tests can control the time with a FakeClock instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// A Timer is the interface of a time.Timer created by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// RealClock is the Clock which uses the time package.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// A FakeClock is a Clock whose time only changes when calling Advance().
+// It is safe for concurrent use.
+type FakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+var _ Clock = (*FakeClock)(nil)
+
+// NewFakeClock returns a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the fake clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// NewTimer creates a new timer which fires once the fake clock
+// has been advanced by at least d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.Lock()
+	defer c.Unlock()
+	t := &fakeTimer{
+		clock: c,
+		c:     make(chan time.Time, 1),
+	}
+	t.reset(d)
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the fake clock's time forward by d, and fires all
+// timers whose deadline has been reached.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.active && !t.deadline.After(c.now) {
+			t.active = false
+			select {
+			case t.c <- c.now:
+			default:
+			}
+		}
+	}
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// reset must be called with the clock locked.
+func (t *fakeTimer) reset(d time.Duration) bool {
+	wasActive := t.active
+	t.deadline = t.clock.now.Add(d)
+	t.active = true
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	return t.reset(d)
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
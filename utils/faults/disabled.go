@@ -0,0 +1,40 @@
+//go:build !faults
+// +build !faults
+
+package faults
+
+// Enabled indicates whether the code has been built with the faults
+// build tag, i.e., whether the faults can be injected.
+const Enabled = false
+
+// Inject does nothing, since the code hasn't been built with the
+// faults build tag.
+func Inject(p Point, f *Fault) {}
+
+// Configure returns ErrDisabled if spec specifies any fault,
+// since the code hasn't been built with the faults build tag.
+// Otherwise, it returns the error returned by Parse(), if any.
+func Configure(spec string) error {
+	faults, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	if len(faults) != 0 {
+		return ErrDisabled
+	}
+	return nil
+}
+
+// Clear does nothing, since the code hasn't been built with the
+// faults build tag.
+func Clear(p Point) {}
+
+// Reset does nothing, since the code hasn't been built with the
+// faults build tag.
+func Reset() {}
+
+// Check always returns nil, since the code hasn't been built with the
+// faults build tag.
+func Check(p Point) error {
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !faults
+// +build !faults
+
+package faults
+
+import (
+	"testing"
+)
+
+func TestDisabled(t *testing.T) {
+	Inject(StorageWrite, &Fault{})
+	if err := Check(StorageWrite); err != nil {
+		t.Fatal("Expect no fault without the faults build tag", "got", err)
+	}
+	if err := Configure("storage-write"); err != ErrDisabled {
+		t.Fatal("Expect", ErrDisabled, "got", err)
+	}
+	if err := Configure(""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,20 @@
+/*
+Package faults implements failure injection points for resilience
+testing of the CONIKS server and clients.
+
+The injection points are compiled in only if the code is built with the
+faults build tag, e.g.,
+
+	go test -tags faults ./...
+	go build -tags faults ./cli/coniksserver
+
+Otherwise, Check() always returns nil, and the compiler removes the
+injection points from the code paths they're placed in.
+
+The tests inject faults with Inject(), and the server operators with
+the --chaos flag of the coniksserver run command, which takes a
+specification of the faults parsed by Parse(), e.g.,
+
+	coniksserver run --chaos "storage-write=0.01,update-delay=5s"
+*/
+package faults
@@ -0,0 +1,65 @@
+//go:build faults
+// +build faults
+
+package faults
+
+import (
+	"sync"
+)
+
+// Enabled indicates whether the code has been built with the faults
+// build tag, i.e., whether the faults can be injected.
+const Enabled = true
+
+var (
+	mu       sync.RWMutex
+	injected = make(map[Point]*Fault)
+)
+
+// Inject injects the fault f at the injection point p,
+// replacing the fault previously injected there, if any.
+func Inject(p Point, f *Fault) {
+	mu.Lock()
+	defer mu.Unlock()
+	injected[p] = f
+}
+
+// Configure injects the faults specified by spec (see Parse()).
+func Configure(spec string) error {
+	faults, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	for p, f := range faults {
+		Inject(p, f)
+	}
+	return nil
+}
+
+// Clear removes the fault injected at the injection point p.
+func Clear(p Point) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(injected, p)
+}
+
+// Reset removes all injected faults.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	injected = make(map[Point]*Fault)
+}
+
+// Check triggers the fault injected at the injection point p,
+// if any, and returns its error (see Fault).
+// It returns nil if no fault is injected at p, or if the
+// fault doesn't trigger.
+func Check(p Point) error {
+	mu.RLock()
+	f := injected[p]
+	mu.RUnlock()
+	if f == nil {
+		return nil
+	}
+	return f.trigger()
+}
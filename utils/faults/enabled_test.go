@@ -0,0 +1,37 @@
+//go:build faults
+// +build faults
+
+package faults
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	defer Reset()
+	if err := Check(StorageWrite); err != nil {
+		t.Fatal("Expect no fault", "got", err)
+	}
+	Inject(StorageWrite, &Fault{})
+	if err := Check(StorageWrite); err != ErrInjected {
+		t.Fatal("Expect", ErrInjected, "got", err)
+	}
+	if err := Check(Signature); err != nil {
+		t.Fatal("Expect no fault at another point", "got", err)
+	}
+	Clear(StorageWrite)
+	if err := Check(StorageWrite); err != nil {
+		t.Fatal("Expect no fault", "got", err)
+	}
+
+	if err := Configure("signature,truncate-response"); err != nil {
+		t.Fatal(err)
+	}
+	if Check(Signature) == nil || Check(TruncateResponse) == nil {
+		t.Fatal("Expect the configured faults to trigger")
+	}
+	Reset()
+	if Check(Signature) != nil || Check(TruncateResponse) != nil {
+		t.Fatal("Expect no fault after reset")
+	}
+}
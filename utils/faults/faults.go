@@ -0,0 +1,105 @@
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Point names a failure injection point.
+type Point string
+
+// These are the failure injection points.
+// StorageWrite fails the writes to the key-value database
+// (see storage/kv), Signature corrupts the signatures created with
+// a sign.PrivateKey, UpdateDelay delays the epoch updates of a server,
+// or skips them if the fault returns an error, and TruncateResponse
+// truncates the responses a server sends to its clients.
+const (
+	StorageWrite     Point = "storage-write"
+	Signature        Point = "signature"
+	UpdateDelay      Point = "update-delay"
+	TruncateResponse Point = "truncate-response"
+)
+
+var points = map[Point]bool{
+	StorageWrite:     true,
+	Signature:        true,
+	UpdateDelay:      true,
+	TruncateResponse: true,
+}
+
+var (
+	// ErrInjected is returned by an injection point whose fault
+	// doesn't specify another error.
+	ErrInjected = errors.New("[faults] Injected failure")
+	// ErrDisabled indicates that the faults can't be injected
+	// since the code hasn't been built with the faults build tag.
+	ErrDisabled = errors.New("[faults] Built without the faults build tag")
+)
+
+// A Fault describes the failure injected at a Point.
+// The fault triggers with the given Probability; a zero Probability
+// always triggers it. A triggered fault first waits for Delay, then
+// returns Err, or nil if Err is nil and Delay isn't zero, or
+// ErrInjected otherwise.
+type Fault struct {
+	Probability float64
+	Delay       time.Duration
+	Err         error
+}
+
+func (f *Fault) trigger() error {
+	if f.Probability != 0 && rand.Float64() >= f.Probability {
+		return nil
+	}
+	time.Sleep(f.Delay)
+	switch {
+	case f.Err != nil:
+		return f.Err
+	case f.Delay != 0:
+		return nil
+	default:
+		return ErrInjected
+	}
+}
+
+// Parse parses the fault specification spec, which is a
+// comma-separated list of injection points, each optionally
+// followed by "=" and either the fault's probability or its delay,
+// e.g., "storage-write=0.01,signature,update-delay=5s".
+// It returns an error if spec names an unknown injection point
+// or contains an invalid probability.
+func Parse(spec string) (map[Point]*Fault, error) {
+	faults := make(map[Point]*Fault)
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		var arg string
+		if i := strings.Index(s, "="); i >= 0 {
+			s, arg = s[:i], s[i+1:]
+		}
+		p := Point(s)
+		if !points[p] {
+			return nil, fmt.Errorf("[faults] Unknown injection point %q", s)
+		}
+		f := new(Fault)
+		if arg != "" {
+			if d, err := time.ParseDuration(arg); err == nil {
+				f.Delay = d
+			} else if prob, err := strconv.ParseFloat(arg, 64); err == nil &&
+				prob > 0 && prob <= 1 {
+				f.Probability = prob
+			} else {
+				return nil, fmt.Errorf("[faults] Invalid probability or delay %q for %s", arg, s)
+			}
+		}
+		faults[p] = f
+	}
+	return faults, nil
+}
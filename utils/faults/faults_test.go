@@ -0,0 +1,52 @@
+package faults
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		spec   string
+		want   map[Point]Fault
+		wantOK bool
+	}{
+		{"empty", "", map[Point]Fault{}, true},
+		{"always", "signature", map[Point]Fault{Signature: {}}, true},
+		{"probability and delay", "storage-write=0.5, update-delay=2s",
+			map[Point]Fault{
+				StorageWrite: {Probability: 0.5},
+				UpdateDelay:  {Delay: 2 * time.Second},
+			}, true},
+		{"unknown point", "disk-full", nil, false},
+		{"invalid probability", "truncate-response=2", nil, false},
+		{"invalid argument", "truncate-response=often", nil, false},
+	} {
+		faults, err := Parse(tc.spec)
+		if (err == nil) != tc.wantOK {
+			t.Error(tc.name, "expect error", !tc.wantOK, "got", err)
+			continue
+		}
+		if len(faults) != len(tc.want) {
+			t.Error(tc.name, "expect", len(tc.want), "faults", "got", len(faults))
+		}
+		for p, f := range tc.want {
+			if got := faults[p]; got == nil || *got != f {
+				t.Error(tc.name, "expect", f, "got", got)
+			}
+		}
+	}
+}
+
+func TestFaultTrigger(t *testing.T) {
+	if err := (&Fault{}).trigger(); err != ErrInjected {
+		t.Fatal("Expect", ErrInjected, "got", err)
+	}
+	if err := (&Fault{Delay: time.Millisecond}).trigger(); err != nil {
+		t.Fatal("Expect no error for a delay", "got", err)
+	}
+	if err := (&Fault{Err: ErrDisabled}).trigger(); err != ErrDisabled {
+		t.Fatal("Expect", ErrDisabled, "got", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Implements the JSON encoding of the primitive values for the
+// hand-rolled JSON encoders of the messages whose encoding dominates
+// the latency of the responses, i.e. the proofs. The output is
+// identical to the output of encoding/json, without its reflection.
+
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+)
+
+// AppendJSONBytes appends the JSON encoding of the byte slice v to b,
+// i.e., v encoded in standard base64 as a string, or null if v is nil.
+func AppendJSONBytes(b []byte, v []byte) []byte {
+	if v == nil {
+		return append(b, "null"...)
+	}
+	n := base64.StdEncoding.EncodedLen(len(v))
+	b = grow(b, n+2)
+	b = append(b, '"')
+	start := len(b)
+	b = b[:start+n]
+	base64.StdEncoding.Encode(b[start:], v)
+	return append(b, '"')
+}
+
+// grow returns b with the capacity for n more bytes.
+func grow(b []byte, n int) []byte {
+	if cap(b)-len(b) >= n {
+		return b
+	}
+	nb := make([]byte, len(b), 2*cap(b)+n)
+	copy(nb, b)
+	return nb
+}
+
+// AppendJSONByteArrays appends the JSON encoding of the list of byte
+// slices v to b as a list of arrays of numbers, i.e., the encoding of
+// a list of byte arrays, or null if v is nil.
+func AppendJSONByteArrays(b []byte, v [][]byte) []byte {
+	if v == nil {
+		return append(b, "null"...)
+	}
+	b = append(b, '[')
+	for i, e := range v {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = grow(b, 4*len(e)+2)
+		b = append(b, '[')
+		for j, c := range e {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendUint(b, uint64(c), 10)
+		}
+		b = append(b, ']')
+	}
+	return append(b, ']')
+}
+
+// AppendJSONString appends the JSON encoding of the string s to b.
+// The characters which encoding/json escapes, including the HTML
+// special characters, are left to encoding/json.
+func AppendJSONString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '"' || c == '\\' ||
+			c == '<' || c == '>' || c == '&' {
+			enc, _ := json.Marshal(s)
+			return append(b, enc...)
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"')
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrMemAddrInUse indicates that an in-memory listener
+// is already listening on the requested name.
+var ErrMemAddrInUse = errors.New("[coniks] In-memory address already in use")
+
+// ErrMemConnRefused indicates that no in-memory listener
+// is listening on the dialed name.
+var ErrMemConnRefused = errors.New("[coniks] No in-memory listener at this address")
+
+// memListeners contains the in-memory listeners of the process,
+// indexed by their name.
+var memListeners = struct {
+	sync.Mutex
+	m map[string]*memListener
+}{m: make(map[string]*memListener)}
+
+// memAddr is the address of an in-memory listener or connection.
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// memTimeout is the error returned by the Accept() of an in-memory
+// listener whose deadline has passed.
+type memTimeout struct{}
+
+func (memTimeout) Error() string   { return "i/o timeout" }
+func (memTimeout) Timeout() bool   { return true }
+func (memTimeout) Temporary() bool { return true }
+
+// A memListener is a net.Listener whose connections are created by
+// DialMem() within the same process, without any socket.
+type memListener struct {
+	name  string
+	conns chan net.Conn
+
+	lock     sync.Mutex
+	closed   chan struct{}
+	deadline chan struct{} // closed once the deadline has passed
+	timer    *time.Timer
+}
+
+// ListenMem listens on the in-memory address name, i.e. "name" in the
+// address "mem://name", and returns the listener. The connections
+// accepted by the listener are dialed with DialMem() in the same
+// process, which makes the full request/response path of a server
+// testable without sockets or TLS certificates.
+// ListenMem returns an ErrMemAddrInUse if another listener is
+// listening on name.
+func ListenMem(name string) (net.Listener, error) {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+	if _, ok := memListeners.m[name]; ok {
+		return nil, ErrMemAddrInUse
+	}
+	l := &memListener{
+		name:     name,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+		deadline: make(chan struct{}),
+	}
+	memListeners.m[name] = l
+	return l, nil
+}
+
+// DialMem connects to the in-memory listener listening on name (see
+// ListenMem()), and returns the client's end of the connection.
+// It returns an ErrMemConnRefused if no listener accepts the connection.
+func DialMem(name string) (net.Conn, error) {
+	memListeners.Lock()
+	l, ok := memListeners.m[name]
+	memListeners.Unlock()
+	if !ok {
+		return nil, ErrMemConnRefused
+	}
+	client, server := memPipe(name)
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, ErrMemConnRefused
+	}
+}
+
+// Accept waits for the next connection dialed with DialMem(), until
+// the listener is closed or its deadline passes.
+func (l *memListener) Accept() (net.Conn, error) {
+	l.lock.Lock()
+	deadline := l.deadline
+	l.lock.Unlock()
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "mem", Addr: l.Addr(),
+			Err: errors.New("use of closed network connection")}
+	case <-deadline:
+		return nil, &net.OpError{Op: "accept", Net: "mem", Addr: l.Addr(),
+			Err: memTimeout{}}
+	}
+}
+
+// SetDeadline sets the time after which Accept() fails with a timeout
+// error, as a *net.TCPListener does. A zero t means no deadline.
+func (l *memListener) SetDeadline(t time.Time) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	select {
+	case <-l.deadline:
+		l.deadline = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		return nil
+	}
+	deadline := l.deadline
+	l.timer = time.AfterFunc(time.Until(t), func() { close(deadline) })
+	return nil
+}
+
+// Close stops listening, so that the name can be listened on again.
+func (l *memListener) Close() error {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+	select {
+	case <-l.closed:
+		return nil
+	default:
+	}
+	close(l.closed)
+	delete(memListeners.m, l.name)
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr(l.name)
+}
+
+// A memConn is one end of an in-memory connection. Unlike the ends of
+// a net.Pipe(), it can be half-closed with CloseWrite(), as the
+// CONIKS clients do after sending their request. Its deadlines are
+// ignored.
+type memConn struct {
+	r    *io.PipeReader
+	w    *io.PipeWriter
+	addr memAddr
+}
+
+// memPipe returns the two ends of an in-memory connection
+// to the listener name.
+func memPipe(name string) (*memConn, *memConn) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	return &memConn{r: r1, w: w2, addr: memAddr(name)},
+		&memConn{r: r2, w: w1, addr: memAddr(name)}
+}
+
+func (c *memConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *memConn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+// CloseWrite closes the sending direction of the connection, so that
+// the other end reads io.EOF.
+func (c *memConn) CloseWrite() error {
+	return c.w.Close()
+}
+
+func (c *memConn) Close() error {
+	c.w.Close()
+	return c.r.Close()
+}
+
+func (c *memConn) LocalAddr() net.Addr                { return c.addr }
+func (c *memConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *memConn) SetDeadline(t time.Time) error      { return nil }
+func (c *memConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *memConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemConn(t *testing.T) {
+	ln, err := ListenMem("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenMem("test"); err != ErrMemAddrInUse {
+		t.Fatal("Expect", ErrMemAddrInUse, "got", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, _ := ioutil.ReadAll(conn)
+		conn.Write(append([]byte("re: "), req...))
+	}()
+
+	conn, err := Dial("mem://test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	// the server reads the request until the client half-closes
+	conn.(interface {
+		CloseWrite() error
+	}).CloseWrite()
+	res, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != "re: hello" {
+		t.Fatal("Expect", "re: hello", "got", string(res))
+	}
+
+	ln.Close()
+	if _, err := DialMem("test"); err != ErrMemConnRefused {
+		t.Fatal("Expect", ErrMemConnRefused, "got", err)
+	}
+	// the name can be reused once the listener is closed
+	ln, err = ListenMem("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
+
+func TestMemListenerDeadline(t *testing.T) {
+	ln, err := ListenMem("deadline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln.(interface {
+		SetDeadline(time.Time) error
+	}).SetDeadline(time.Now())
+	_, err = ln.Accept()
+	if opErr, ok := err.(*net.OpError); !ok || !opErr.Timeout() {
+		t.Fatal("Expect a timeout, got", err)
+	}
+}
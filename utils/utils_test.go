@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"math/rand"
 	"testing"
 	"time"
@@ -57,3 +58,31 @@ func TestLongToBytes(t *testing.T) {
 		t.Fatal("Conversion to bytes looks wrong!")
 	}
 }
+
+func TestAppendJSON(t *testing.T) {
+	for _, v := range [][]byte{nil, {}, []byte("a"), []byte("ab"), []byte("abc"), make([]byte, 100)} {
+		want, _ := json.Marshal(v)
+		if got := AppendJSONBytes([]byte("x"), v); string(got) != "x"+string(want) {
+			t.Error("Expect", "x"+string(want), "got", string(got))
+		}
+	}
+	for _, v := range [][][]byte{nil, {}, {{1, 2, 3, 4}, {255, 0, 16, 7}}} {
+		var arrays [][4]byte
+		if v != nil {
+			arrays = [][4]byte{}
+		}
+		for _, e := range v {
+			arrays = append(arrays, [4]byte{e[0], e[1], e[2], e[3]})
+		}
+		want, _ := json.Marshal(arrays)
+		if got := AppendJSONByteArrays(nil, v); string(got) != string(want) {
+			t.Error("Expect", string(want), "got", string(got))
+		}
+	}
+	for _, s := range []string{"", "1.0.0", "a\"b", "<&>", "tab\t", " ", "caf\xc3\xa9", "\xff"} {
+		want, _ := json.Marshal(s)
+		if got := AppendJSONString(nil, s); string(got) != string(want) {
+			t.Error("Expect", string(want), "got", string(got))
+		}
+	}
+}